@@ -0,0 +1,227 @@
+package bank
+
+// Bank transfers replicated by XPaxos or PBFT, with a checker of their invariants
+//
+// bank := MakeBank(accounts, balance)   - Bank state machine (see statemachine), one per server
+// teller := MakeTeller(client)          - Issues transfers through the client of either protocol
+// teller.Transfer(from, to, amount)     - Whether the transfer was applied (and committed)
+// teller.Balance(account)               - Balance of an account (a committed read)
+// RandomTransfer(r, accounts)           - A random transfer between two accounts
+// Encode(op)                            - A Transfer or Query as proposed to the bank
+// Check(banks, accounts, balance)       - First invariant violation of the banks of all servers
+//
+// => Every bank starts with accounts accounts (0 to accounts-1) holding balance each; a transfer
+//    is rejected if the source account cannot cover it, so balances never become negative
+// => Check() is a safety oracle for chaos and Byzantine tests: whatever the faults, every server's
+//    bank must hold the initial total (conservation) with no negative balance, and two servers
+//    that applied the same number of transfers must hold the same balances (agreement)
+// => Snapshots list the accounts in order, so equal banks have equal snapshots
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"math/rand"
+	"strconv"
+	"sync"
+)
+
+type Transfer struct {
+	From   int
+	To     int
+	Amount int
+}
+
+type Query struct {
+	Account int
+}
+
+type Bank struct {
+	mu       sync.Mutex
+	balances []int
+	applied  int // Transfers applied (rejected ones included)
+}
+
+type snapshot struct {
+	Balances []int
+	Applied  int
+}
+
+var _ statemachine.StateMachine = &Bank{}
+
+type Teller struct {
+	client statemachine.Consensus
+}
+
+func init() {
+	gob.Register(Transfer{}) // Operations are decoded as interface values (see Apply())
+	gob.Register(Query{})
+}
+
+//
+// ------------------------------- STATE MACHINE ------------------------------
+//
+func MakeBank(accounts int, balance int) *Bank {
+	bank := &Bank{}
+	bank.balances = make([]int, accounts)
+	for account := range bank.balances {
+		bank.balances[account] = balance
+	}
+	return bank
+}
+
+// Returns "1" if a transfer was applied and "0" if it was rejected, or the balance of a query
+func (bank *Bank) Apply(data []byte) []byte {
+	var op interface{}
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&op); err != nil {
+		return nil // Not an operation of the bank (i.e. a null operation)
+	}
+
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	switch op := op.(type) {
+	case Transfer:
+		bank.applied++
+		if bank.valid(op.From) == false || bank.valid(op.To) == false || op.Amount < 0 ||
+			bank.balances[op.From] < op.Amount {
+			return []byte("0")
+		}
+		bank.balances[op.From] -= op.Amount
+		bank.balances[op.To] += op.Amount
+		return []byte("1")
+	case Query:
+		if bank.valid(op.Account) {
+			return []byte(strconv.Itoa(bank.balances[op.Account]))
+		}
+	}
+	return nil
+}
+
+// Must be called with bank.mu held
+func (bank *Bank) valid(account int) bool {
+	return account >= 0 && account < len(bank.balances)
+}
+
+func (bank *Bank) Snapshot() []byte {
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(snapshot{bank.balances, bank.applied})
+	return buf.Bytes()
+}
+
+func (bank *Bank) Restore(data []byte) {
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	restored := snapshot{}
+	if len(data) > 0 {
+		gob.NewDecoder(bytes.NewBuffer(data)).Decode(&restored)
+	}
+	bank.balances = make([]int, len(bank.balances)) // Gob omits zero balances at the end
+	copy(bank.balances, restored.Balances)
+	bank.applied = restored.Applied
+}
+
+func (bank *Bank) Hash() [32]byte {
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	hasher := statemachine.MakeHasher().WriteInt(int64(bank.applied))
+	for _, balance := range bank.balances {
+		hasher.WriteInt(int64(balance))
+	}
+	return hasher.Sum()
+}
+
+// Bank of as many accounts, all of them empty (Restore() replaces the balances)
+func (bank *Bank) Scratch() statemachine.StateMachine {
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	return MakeBank(len(bank.balances), 0)
+}
+
+// Copy of the balances and the number of transfers applied
+func (bank *Bank) Balances() ([]int, int) {
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	return append([]int(nil), bank.balances...), bank.applied
+}
+
+//
+// ---------------------------------- TELLER ----------------------------------
+//
+func MakeTeller(client statemachine.Consensus) *Teller {
+	teller := &Teller{}
+	teller.client = client
+	return teller
+}
+
+// Operation (a Transfer or a Query) as passed to Apply(), e.g. to propose it without a teller;
+// operations travel gob-encoded as interface values, so the bank can tell them apart
+func Encode(op interface{}) []byte {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(&op)
+	return buf.Bytes()
+}
+
+// Returns whether the transfer was applied and whether the request committed
+func (teller *Teller) Transfer(from int, to int, amount int) (bool, bool) {
+	result, ok := teller.client.Execute(Encode(Transfer{from, to, amount}))
+	return string(result) == "1", ok
+}
+
+func (teller *Teller) Balance(account int) (int, bool) {
+	result, ok := teller.client.Execute(Encode(Query{account}))
+	balance, err := strconv.Atoi(string(result))
+	return balance, ok && err == nil
+}
+
+func RandomTransfer(r *rand.Rand, accounts int) Transfer {
+	from := r.Intn(accounts)
+	to := (from + 1 + r.Intn(accounts-1)) % accounts
+	return Transfer{from, to, r.Intn(100)}
+}
+
+//
+// ---------------------------------- CHECKER ---------------------------------
+//
+// banks holds the bank of every server (nil for servers without one)
+func Check(banks []*Bank, accounts int, balance int) error {
+	applied := make(map[int][]int) // Transfers applied -> balances of the first bank that applied them
+	for server, bank := range banks {
+		if bank == nil {
+			continue
+		}
+
+		balances, numApplied := bank.Balances()
+		total := 0
+		for account, b := range balances {
+			if b < 0 {
+				return fmt.Errorf("server %d: account %d has a negative balance %d", server, account, b)
+			}
+			total += b
+		}
+		if total != accounts*balance {
+			return fmt.Errorf("server %d: total balance %d instead of %d after %d transfers", server, total,
+				accounts*balance, numApplied)
+		}
+
+		if reference, ok := applied[numApplied]; ok {
+			for account := range reference {
+				if reference[account] != balances[account] {
+					return fmt.Errorf("server %d: account %d has balance %d instead of %d after %d transfers",
+						server, account, balances[account], reference[account], numApplied)
+				}
+			}
+		} else {
+			applied[numApplied] = balances
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package bank
+
+import (
+	"fmt"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"math/rand"
+	"testing"
+)
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestBank(t *testing.T) {
+	fmt.Println("Test: Bank - Transfers and Balances")
+
+	bank := MakeBank(3, 100)
+	teller := MakeTeller(statemachine.MakeLocal(bank))
+
+	if applied, ok := teller.Transfer(0, 1, 30); applied == false || ok == false {
+		t.Fatal("Covered transfer rejected!")
+	}
+	if applied, _ := teller.Transfer(0, 2, 80); applied == true {
+		t.Fatal("Uncovered transfer applied!")
+	}
+	if applied, _ := teller.Transfer(0, 3, 10); applied == true {
+		t.Fatal("Transfer to an unknown account applied!")
+	}
+	if balance, ok := teller.Balance(1); ok == false || balance != 130 {
+		t.Fatalf("Balance %d instead of 130!", balance)
+	}
+	if _, ok := teller.Balance(3); ok == true {
+		t.Fatal("Balance of an unknown account!")
+	}
+	if balances, applied := bank.Balances(); balances[0] != 70 || applied != 3 {
+		t.Fatalf("Balances %v after %d transfers!", balances, applied)
+	}
+}
+
+func TestBankCheck(t *testing.T) {
+	fmt.Println("Test: Bank - Conservation and Agreement Checks")
+
+	r := rand.New(rand.NewSource(0))
+	banks := make([]*Bank, 4)
+	for i := 1; i < len(banks); i++ { // banks[0] stands for a server without a bank
+		banks[i] = MakeBank(5, 100)
+	}
+	for i := 0; i < 200; i++ {
+		op := Encode(RandomTransfer(r, 5))
+		for j := 1; j < len(banks); j++ {
+			if j < 3 || i < 100 { // banks[3] lags behind
+				banks[j].Apply(op)
+			}
+		}
+	}
+	if err := Check(banks, 5, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// A snapshot restores the same balances
+	restored := MakeBank(5, 100)
+	restored.Restore(banks[1].Snapshot())
+	if err := Check([]*Bank{banks[1], restored}, 5, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// So does a scratch bank, which starts out empty
+	scratch := banks[1].Scratch()
+	if scratch.Hash() == banks[1].Hash() {
+		t.Fatal("Scratch bank holds the state of the bank!")
+	}
+	scratch.Restore(banks[1].Snapshot())
+	if scratch.Hash() != banks[1].Hash() {
+		t.Fatal("Snapshot restored on a scratch bank holds another state!")
+	}
+
+	// A bank that diverged from another at the same point of the log
+	banks[2].Restore(banks[1].Snapshot())
+	banks[2].balances[0]--
+	banks[2].balances[1]++
+	if err := Check(banks, 5, 100); err == nil {
+		t.Fatal("Diverging banks not detected!")
+	}
+
+	// A bank that created money
+	banks[2].Restore(banks[1].Snapshot())
+	banks[2].balances[0]++
+	if err := Check(banks, 5, 100); err == nil {
+		t.Fatal("Money creation not detected!")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+// Verifies the audit trails of persisted XPaxos servers offline against their public keys, and
+// looks for servers that equivocated
+//
+// go run ./cmd/audit -keys=file file.persist...
+//
+// => The files are written by the tests of xpaxos with -audit and -persistdir=dir (see
+//    xpaxos/audit.go), i.e. "go test -run=Chaos -args -audit -persistdir=/tmp/run" and then
+//    "go run ./cmd/audit -keys=/tmp/run/TestChaos1.keys /tmp/run/TestChaos1-*.persist"
+// => Prints the number of signed messages archived by the server of every file, the messages
+//    that fail verification and the prepares and commits that equivocate
+// => The key file holds every key of each server (see xpaxos.WriteKeyHistory()), so messages signed
+//    before a key rotation verify against the key of the server at the time
+// => Exits with status 1 if any message fails verification or equivocates
+
+import (
+	"flag"
+	"fmt"
+	"github.com/csanti/cos518_project/src/xpaxos"
+	"os"
+	"sort"
+)
+
+var keys = flag.String("keys", "", "every public key of the servers (written next to the persisters)")
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 || *keys == "" {
+		fmt.Fprintln(os.Stderr, "usage: audit -keys=file file.persist...")
+		os.Exit(2)
+	}
+
+	keyHistory, err := xpaxos.ReadKeyHistory(*keys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		if audit(path, keyHistory) == false {
+			failed = true
+		}
+	}
+	if failed == true {
+		os.Exit(1)
+	}
+}
+
+// Returns whether every archived message of the file is valid and none equivocates
+func audit(path string, keyHistory xpaxos.KeyHistory) bool {
+	persister, err := xpaxos.ReadPersister(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	entries, err := xpaxos.ReadAuditTrail(persister)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return false
+	}
+
+	counts := make(map[int]int) // Server ID -> messages archived
+	servers := make([]int, 0)
+	for _, entry := range entries {
+		if counts[entry.Server] == 0 {
+			servers = append(servers, entry.Server)
+		}
+		counts[entry.Server]++
+	}
+	sort.Ints(servers)
+	fmt.Printf("%s: %d signed messages", path, len(entries))
+	for _, server := range servers {
+		fmt.Printf(", %d of server (%d)", counts[server], server)
+	}
+	fmt.Println()
+
+	problems := xpaxos.VerifyAuditTrail(entries, keyHistory)
+	for _, problem := range problems {
+		fmt.Printf("  INVALID %s\n", problem)
+	}
+	equivocations := xpaxos.Equivocations(entries)
+	for _, equivocation := range equivocations {
+		fmt.Printf("  EQUIVOCATION %s\n", equivocation)
+	}
+	return len(problems) == 0 && len(equivocations) == 0
+}
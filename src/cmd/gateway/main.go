@@ -0,0 +1,47 @@
+package main
+
+// Serves the HTTP gateway (see gateway) in front of a deployed XPaxos cluster (see cmd/xpaxosd)
+//
+// go run ./cmd/gateway -dir=cluster -addr=:8080 [-clients=8] [-timeout=10s] [-slow=50ms]
+//
+// => i.e. "curl -X PUT -d '{"value": "v"}' localhost:8080/kv/k" and "curl localhost:8080/kv/k"
+
+import (
+	"flag"
+	"fmt"
+	"github.com/csanti/cos518_project/src/debug"
+	"github.com/csanti/cos518_project/src/gateway"
+	"github.com/csanti/cos518_project/src/kvservice"
+	"github.com/csanti/cos518_project/src/xpaxos"
+	"net/http"
+	"os"
+	"time"
+)
+
+var dir = flag.String("dir", "cluster", "directory of the cluster (keys and sockets)")
+var addr = flag.String("addr", ":8080", "address to serve HTTP on")
+var clients = flag.Int("clients", 8, "XPaxos clients, i.e. HTTP requests in flight")
+var timeout = flag.Duration("timeout", 10*time.Second, "how long a request waits for the leader's reply")
+var slow = flag.Duration("slow", 0, "log requests slower than this with their phases at the leader (see xpaxos/slow.go)")
+
+func main() {
+	flag.Var(debug.Flag(), "debug", "per-module verbosity, i.e. -debug=all=0 (see debug/debug.go)")
+	flag.Parse()
+
+	xpClients, err := xpaxos.ConnectClients(*dir, *clients)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	clerks := make([]*kvservice.Clerk, len(xpClients))
+	for i, client := range xpClients {
+		client.SetSlowThreshold(*slow)
+		clerks[i] = kvservice.MakeClerk(client)
+	}
+
+	fmt.Printf("Gateway to %s serving %s\n", *dir, *addr)
+	if err := http.ListenAndServe(*addr, gateway.MakeGateway(clerks, *timeout)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
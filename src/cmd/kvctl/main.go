@@ -0,0 +1,151 @@
+package main
+
+// Issues operations to a deployed XPaxos cluster running the key-value service (see cmd/xpaxosd)
+//
+// kvctl [-dir=cluster] init n           - Creates the cluster's directory with keys for n servers
+//                                         (of the signature scheme -scheme, see signing/signing.go)
+// kvctl [-dir=cluster] put key value    - Replaces the value of key
+// kvctl [-dir=cluster] append key value - Appends to the value of key
+// kvctl [-dir=cluster] get key          - Prints the value of key
+// kvctl [-dir=cluster] status           - Prints the view and sequence numbers of every server
+// kvctl [-dir=cluster] inspect i         - Prints the internal state of server i as JSON
+//
+// => Operations go through an XPaxos client over the servers' Unix sockets (see xpaxos/cluster.go)
+//    and print the committed value; they fail after -timeout if the leader did not reply
+// => With -config, operations reach the servers of a cluster configuration file instead of -dir
+//    (see xpaxos/bootstrap.go); init writes one to the cluster's directory ("cluster.json")
+// => Exits with status 1 if an operation failed or a server is unreachable
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/csanti/cos518_project/src/debug"
+	"github.com/csanti/cos518_project/src/kvservice"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/xpaxos"
+	"os"
+	"strconv"
+	"time"
+)
+
+var dir = flag.String("dir", "cluster", "directory of the cluster (keys and sockets)")
+var configPath = flag.String("config", "", "cluster configuration file to issue operations to, i.e. cluster/cluster.json (instead of -dir)")
+var timeout = flag.Duration("timeout", 10*time.Second, "how long to wait for the leader's reply")
+var scheme = signing.DEFAULT
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: kvctl [-dir=cluster] [-timeout=10s] [-scheme=rsa-1024] init n | put key value | append key value |"+
+		" get key | status | inspect i")
+	os.Exit(2)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func main() {
+	flag.Var(debug.Flag(), "debug", "per-module verbosity, i.e. -debug=all=0 (see debug/debug.go)")
+	flag.Func("scheme", "signature scheme of the keys created by init, one of "+fmt.Sprint(signing.Schemes()),
+		func(name string) (err error) {
+			scheme, err = signing.ParseScheme(name)
+			return err
+		})
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
+
+	switch {
+	case args[0] == "init" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			usage()
+		}
+		if err := xpaxos.InitCluster(*dir, n, scheme); err != nil {
+			fail(err)
+		}
+		fmt.Printf("Cluster of %d XPaxos servers in %s (%v keys)\n", n, *dir, scheme)
+	case args[0] == "status" && len(args) == 1:
+		status()
+	case args[0] == "inspect" && len(args) == 2:
+		i, err := strconv.Atoi(args[1])
+		if err != nil || i < 1 {
+			usage()
+		}
+		inspect(i)
+	case args[0] == "get" && len(args) == 2:
+		execute(func(clerk *kvservice.Clerk) (string, bool) { return clerk.Get(args[1]) })
+	case args[0] == "put" && len(args) == 3:
+		execute(func(clerk *kvservice.Clerk) (string, bool) { return clerk.Put(args[1], args[2]) })
+	case args[0] == "append" && len(args) == 3:
+		execute(func(clerk *kvservice.Clerk) (string, bool) { return clerk.Append(args[1], args[2]) })
+	default:
+		usage()
+	}
+}
+
+func execute(op func(clerk *kvservice.Clerk) (string, bool)) {
+	var client *xpaxos.Client
+	var err error
+	if *configPath != "" {
+		client, err = xpaxos.ConnectClientConfig(*configPath)
+	} else {
+		client, err = xpaxos.ConnectClient(*dir)
+	}
+	if err != nil {
+		fail(err)
+	}
+	clerk := kvservice.MakeClerk(client)
+
+	done := make(chan bool, 1)
+	var value string
+	go func() {
+		var ok bool
+		value, ok = op(clerk)
+		done <- ok
+	}()
+
+	select {
+	case ok := <-done:
+		if ok == false {
+			fail(fmt.Errorf("operation not committed"))
+		}
+		fmt.Println(value)
+	case <-time.After(*timeout):
+		fail(fmt.Errorf("no reply from the leader within %v", *timeout))
+	}
+}
+
+func status() {
+	statuses, err := xpaxos.ClusterStatus(*dir, *timeout)
+	if err != nil {
+		fail(err)
+	}
+
+	unreachable := false
+	for _, s := range statuses {
+		if s.Reachable == false {
+			fmt.Printf("server %d: unreachable\n", s.Id)
+			unreachable = true
+			continue
+		}
+		fmt.Printf("server %d: view %d (leader %d), prepared %d, executed %d, synchronous group %v\n", s.Id,
+			s.View, s.Leader, s.PrepareSeqNum, s.ExecuteSeqNum, s.InGroup)
+	}
+	if unreachable == true {
+		os.Exit(1)
+	}
+}
+
+func inspect(i int) {
+	state, err := xpaxos.InspectServer(*dir, i, *timeout)
+	if err != nil {
+		fail(err)
+	}
+
+	data, _ := json.MarshalIndent(state, "", "  ")
+	fmt.Println(string(data))
+}
@@ -0,0 +1,110 @@
+package main
+
+// Replays persisted XPaxos servers offline and prints the state of their state machines
+//
+// go run ./cmd/replay [-machine=log|kv|bank] [-keys=file] file.persist...
+//
+// => The files are written by the tests of xpaxos with -persistdir=dir (see xpaxos/replay.go),
+//    i.e. "go test -run=Chaos -args -persistdir=/tmp/run" and then
+//    "go run ./cmd/replay -keys=/tmp/run/TestChaos1.keys /tmp/run/TestChaos1-*.persist"
+// => -machine must be the state machine the servers ran (the tests run log machines unless they
+//    set others, e.g. banks in TestBank*)
+// => Without -keys, signatures are not verified
+// => Exits with status 1 if any commit log entry fails verification
+
+import (
+	"crypto"
+	"flag"
+	"fmt"
+	"github.com/csanti/cos518_project/src/bank"
+	"github.com/csanti/cos518_project/src/kvservice"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"github.com/csanti/cos518_project/src/xpaxos"
+	"os"
+)
+
+var machine = flag.String("machine", "log", "state machine of the servers: log, kv or bank")
+var keys = flag.String("keys", "", "public keys of the servers (written next to the persisters)")
+var accounts = flag.Int("accounts", 5, "accounts of every bank (-machine=bank)")
+var balance = flag.Int("balance", 1000, "initial balance of every account (-machine=bank)")
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: replay [-machine=log|kv|bank] [-keys=file] file.persist...")
+		os.Exit(2)
+	}
+
+	var publicKeys map[int]crypto.PublicKey
+	if *keys != "" {
+		var err error
+		if publicKeys, err = xpaxos.ReadPublicKeys(*keys); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		if replay(path, publicKeys) == false {
+			failed = true
+		}
+	}
+	if failed == true {
+		os.Exit(1)
+	}
+}
+
+// Returns whether the persister replayed without problems
+func replay(path string, publicKeys map[int]crypto.PublicKey) bool {
+	persister, err := xpaxos.ReadPersister(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	sm := makeMachine()
+	replayed, err := xpaxos.Replay(persister, sm, publicKeys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return false
+	}
+
+	fmt.Printf("%s: view %d, %d entries (%d executed), checkpoint at %d, %d applied\n", path, replayed.View,
+		replayed.Entries, replayed.ExecuteSeqNum, replayed.Checkpoint, replayed.Applied)
+	for _, problem := range replayed.Problems {
+		fmt.Printf("  INVALID %s\n", problem)
+	}
+	printState(sm)
+	return len(replayed.Problems) == 0
+}
+
+func makeMachine() statemachine.StateMachine {
+	switch *machine {
+	case "kv":
+		return kvservice.MakeKV()
+	case "bank":
+		return bank.MakeBank(*accounts, *balance)
+	case "log":
+		return statemachine.MakeLog()
+	}
+	fmt.Fprintf(os.Stderr, "unknown state machine %q\n", *machine)
+	os.Exit(2)
+	return nil
+}
+
+func printState(sm statemachine.StateMachine) {
+	switch sm := sm.(type) {
+	case *statemachine.Log:
+		for i, op := range sm.Ops() {
+			fmt.Printf("  %d: %q\n", i+1, op)
+		}
+	case *kvservice.KV:
+		for _, key := range sm.Keys() {
+			fmt.Printf("  %s = %q\n", key, sm.Get(key))
+		}
+	case *bank.Bank:
+		balances, applied := sm.Balances()
+		fmt.Printf("  balances %v after %d transfers\n", balances, applied)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+// Holds the private key of one XPaxos server in its own process, which signs for the server
+//
+// go run ./cmd/signerd -dir=cluster -id=i [-socket=cluster/i.signer]
+//
+// => Reads the key of server i from the cluster's key file (see cmd/kvctl) and serves signatures
+//    on -socket until it is killed (see signing/remote.go); the server then runs with
+//    "xpaxosd -dir=cluster -id=i -signer=cluster/i.signer" and never holds its key
+// => Stands in for an HSM or a KMS, whose clients implement the same signing.Signer interface
+
+import (
+	"flag"
+	"fmt"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/xpaxos"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var dir = flag.String("dir", "cluster", "directory of the cluster (keys and sockets)")
+var id = flag.Int("id", 0, "ID of the XPaxos server whose key to serve (1..n)")
+var socketPath = flag.String("socket", "", "socket to serve signatures on (default dir/<id>.signer)")
+
+func main() {
+	flag.Parse()
+	if *id < 1 {
+		fmt.Fprintln(os.Stderr, "usage: signerd -dir=cluster -id=i [-socket=path]")
+		os.Exit(2)
+	}
+	if *socketPath == "" {
+		*socketPath = fmt.Sprintf("%s/%d.signer", *dir, *id)
+	}
+
+	signer, err := xpaxos.ClusterSigner(*dir, *id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	socket, err := signing.ServeSigner(*socketPath, signer)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Signer of XPaxos server (%d) serving %s\n", *id, *socketPath)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	socket.Close()
+}
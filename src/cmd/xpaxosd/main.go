@@ -0,0 +1,179 @@
+package main
+
+// Runs one XPaxos server of a deployed cluster, replicating the key-value service
+//
+// go run ./cmd/xpaxosd -dir=cluster -id=i [-store=kv.i] [-sync] [-metrics=:9100]
+// go run ./cmd/xpaxosd -config=cluster.json -key=i.key.pem -id=i [...]
+//     [-otlp=http://localhost:4318] [-selfcheck=5s] [-signer=cluster/i.signer] [-presign=4] [-dkg=1024]
+//
+// => The cluster's directory is created with "kvctl -dir=cluster init n" (see cmd/kvctl), and
+//    every server i = 1..n runs in its own process until it is killed
+// => With -config, the server takes its peers, their sockets and their keys (or certificates) from
+//    a cluster configuration file instead of -dir, and its private key from the PEM file -key,
+//    i.e. for keys generated outside kvctl (see xpaxos/bootstrap.go and signing/pem.go)
+// => With -store, the server keeps the values of the service in a file (see kvservice/storage.go)
+//    instead of memory, and with -sync it waits for every write to reach the disk; the server
+//    stops within STORECHECK once the file cannot be read or written (i.e. a full disk)
+// => With -metrics, the server serves its Prometheus metrics on /metrics (see xpaxos/metrics.go)
+//    and its internal state as JSON on /debug/state (see xpaxos/inspect.go)
+// => With -otlp, the server exports spans of the phases of every request to an OpenTelemetry
+//    collector every FLUSHINTERVAL (see tracing); spans of all servers share the request's trace ID
+// => With -selfcheck, the server checks the invariants of its own state periodically and logs
+//    their violations (see xpaxos/selfcheck.go)
+// => With -signer, the server signs through the signer process serving its key on that socket
+//    (see cmd/signerd and signing/remote.go) instead of reading its key from the key file
+// => With -presign, the server signs the client requests it leads on that many goroutines while
+//    they wait for its lock, so that signatures leave its critical path (see xpaxos/presign.go)
+// => With -dkg, the server generates a threshold key of that many bits with the other servers as
+//    soon as it starts (every server of the cluster must run with the same -dkg, see
+//    xpaxos/dkg.go), and certifies its view changes and commits with its share once the ceremony
+//    ends
+// => Servers checkpoint the service every kvservice.CHECKPOINT operations (see xpaxos/cluster.go)
+
+import (
+	"crypto"
+	"flag"
+	"fmt"
+	"github.com/csanti/cos518_project/src/debug"
+	"github.com/csanti/cos518_project/src/kvservice"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/tracing"
+	"github.com/csanti/cos518_project/src/xpaxos"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const FLUSHINTERVAL = time.Second // Between exports of spans to the OTLP collector
+const DKGCEREMONY = 1             // Number of the ceremony of -dkg, the same on every server
+const STORECHECK = time.Second    // Between checks of the errors of the store
+
+var dir = flag.String("dir", "cluster", "directory of the cluster (keys and sockets)")
+var configPath = flag.String("config", "", "cluster configuration file, i.e. cluster/cluster.json (instead of -dir)")
+var keyPath = flag.String("key", "", "PEM file of the server's private key (with -config, unless -signer is set)")
+var id = flag.Int("id", 0, "ID of this XPaxos server (1..n)")
+var store = flag.String("store", "", "file to store the values of the service in (memory if empty)")
+var sync = flag.Bool("sync", false, "wait for every write to the store file to reach the disk")
+var metricsAddr = flag.String("metrics", "", "address to serve Prometheus metrics and the debug state on (none if empty)")
+var otlp = flag.String("otlp", "", "OTLP/HTTP collector to export spans to, i.e. http://localhost:4318")
+var signerPath = flag.String("signer", "", "socket of the signer process holding the server's key (key file if empty)")
+var selfCheck = flag.Duration("selfcheck", 0, "check the invariants of the server's state at this interval, i.e. 5s (never if 0)")
+var dkgBits = flag.Int("dkg", 0, "bits of a threshold key to generate with the other servers at start, i.e. 1024 (none if 0)")
+var presigners = flag.Int("presign", 0, "goroutines signing client requests ahead of the server's lock, i.e. the number of cores (inline if 0)")
+
+func main() {
+	flag.Var(debug.Flag(), "debug", "per-module verbosity, i.e. -debug=all=0 (see debug/debug.go)")
+	flag.Parse()
+	if *id < 1 {
+		fmt.Fprintln(os.Stderr, "usage: xpaxosd -dir=cluster -id=i")
+		os.Exit(2)
+	}
+
+	kv := kvservice.MakeKV()
+	var fs *kvservice.FileStorage
+	if *store != "" {
+		var err error
+		fs, err = kvservice.MakeFileStorage(*store, *sync)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		kv = kvservice.MakeKVWithStorage(fs)
+	}
+
+	var xp *xpaxos.XPaxos
+	var socket *network.SocketServer
+	var err error
+	if *configPath != "" {
+		var signer signing.Signer
+		if *signerPath != "" {
+			signer, err = signing.DialSigner(*signerPath)
+		} else {
+			var key crypto.Signer
+			if key, err = signing.ReadPrivateKeyFile(*keyPath); err == nil {
+				signer, err = signing.ErasableSigner(key)
+				signing.Erase(key) // The signer holds its own copy, which xp.Kill() erases
+			}
+		}
+		if err == nil {
+			xp, socket, err = xpaxos.StartReplicaConfig(*configPath, *id, signer, kv, kvservice.CHECKPOINT)
+		}
+	} else if *signerPath != "" {
+		var signer signing.Signer
+		if signer, err = signing.DialSigner(*signerPath); err == nil {
+			xp, socket, err = xpaxos.StartReplicaSigner(*dir, *id, signer, kv, kvservice.CHECKPOINT)
+		}
+	} else {
+		xp, socket, err = xpaxos.StartReplica(*dir, *id, kv, kvservice.CHECKPOINT)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("XPaxos server (%d) serving %s\n", *id, *dir)
+	xp.SetSelfCheckInterval(*selfCheck)
+	xp.SetPresigners(*presigners)
+	if *dkgBits > 0 {
+		go func() {
+			if _, err := xp.GenerateThresholdKey(DKGCEREMONY, *dkgBits); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", xp.Metrics())
+		mux.Handle("/debug/state", xpaxos.InspectHandler(xp))
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+
+	var tracer *tracing.Tracer
+	if *otlp != "" {
+		tracer = tracing.MakeTracer("xpaxos", strconv.Itoa(*id), tracing.MakeOTLPExporter(*otlp))
+		xp.SetTracer(tracer)
+		go func() {
+			for range time.Tick(FLUSHINTERVAL) {
+				if err := tracer.Flush(); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+			}
+		}()
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		for range time.Tick(STORECHECK) {
+			if err := kv.Err(); err != nil {
+				failed <- err
+				return
+			}
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	code := 0
+	select {
+	case <-signals:
+	case err := <-failed: // The store no longer holds the state of the other servers
+		fmt.Fprintln(os.Stderr, err)
+		code = 1
+	}
+
+	socket.Close()
+	xp.Kill()
+	tracer.Flush()
+	if fs != nil {
+		fs.Close() // os.Exit skips deferred calls
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,151 @@
+package debug
+
+// Per-module debug verbosity
+//
+// debug.Printf(module, level, format, a...) - Logs a record without fields (see logger.go) if the
+//                                             verbosity of module is at least level
+// debug.Level(module)                       - Current verbosity of a module
+// debug.SetLevel(module, level)             - Changes the verbosity of a module
+// debug.Parse(spec)                         - Sets verbosities from a spec, i.e. "xpaxos=2,network=0"
+// flag.Var(debug.Flag(), "debug", usage)    - A flag taking a spec (see the tests of xpaxos and pbft)
+//
+// => Levels: NONE (0), INFO (1) and DEBUG (2)
+// => Modules: XPAXOS and PBFT (the servers), NETWORK and CLIENT (the client servers of both
+//    protocols); "all" in a spec sets every module
+// => A spec in the environment variable DEBUGENV is applied at startup, so the verbosity can be
+//    changed without editing code, i.e. "COS518_DEBUG=xpaxos=0,client=1 go test -run=TestCommonCase1"
+// => Defaults: xpaxos=1, pbft=0, network=2, client=1
+// => "format=text" or "format=json" in a spec sets the output format of records (see logger.go)
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const DEBUGENV = "COS518_DEBUG" // Environment variable holding a spec
+
+const ( // Levels
+	NONE  = iota
+	INFO  = iota
+	DEBUG = iota
+)
+
+const ( // Modules
+	XPAXOS   = iota
+	PBFT     = iota
+	NETWORK  = iota
+	CLIENT   = iota
+	NMODULES = iota
+)
+
+var names = [NMODULES]string{"xpaxos", "pbft", "network", "client"}
+
+var levels = [NMODULES]int32{INFO, NONE, DEBUG, INFO}
+
+func init() {
+	if spec := os.Getenv(DEBUGENV); spec != "" {
+		if err := Parse(spec); err != nil {
+			log.Printf("Ignoring %s: %v\n", DEBUGENV, err)
+		}
+	}
+}
+
+func Printf(module int, level int, format string, a ...interface{}) (n int, err error) {
+	MakeLogger(module).log(level, format, a...)
+	return
+}
+
+func Level(module int) int {
+	return int(atomic.LoadInt32(&levels[module]))
+}
+
+func SetLevel(module int, level int) {
+	atomic.StoreInt32(&levels[module], int32(level))
+}
+
+// A spec is a comma-separated list of "module=level"; later entries override earlier ones
+func Parse(spec string) error {
+	var parsed [NMODULES]int32
+	for module := 0; module < NMODULES; module++ {
+		parsed[module] = int32(Level(module))
+	}
+	parsedFormat := Format()
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, "=")
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid entry %q (expected module=level)", entry)
+		}
+
+		if strings.ToLower(strings.TrimSpace(fields[0])) == "format" {
+			value := strings.ToLower(strings.TrimSpace(fields[1]))
+			found := false
+			for f, name := range formatNames {
+				if value == name {
+					parsedFormat = f
+					found = true
+				}
+			}
+			if found == false {
+				return fmt.Errorf("invalid format %q (expected text or json)", fields[1])
+			}
+			continue
+		}
+
+		level, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil || level < NONE || level > DEBUG {
+			return fmt.Errorf("invalid level %q (expected %d to %d)", fields[1], NONE, DEBUG)
+		}
+
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		found := false
+		for module := 0; module < NMODULES; module++ {
+			if name == "all" || name == names[module] {
+				parsed[module] = int32(level)
+				found = true
+			}
+		}
+		if found == false {
+			return fmt.Errorf("unknown module %q", fields[0])
+		}
+	}
+
+	for module := 0; module < NMODULES; module++ { // All or nothing
+		SetLevel(module, int(parsed[module]))
+	}
+	SetFormat(parsedFormat)
+	return nil
+}
+
+// Current verbosities and format as a spec
+func String() string {
+	entries := make([]string, NMODULES)
+	for module := 0; module < NMODULES; module++ {
+		entries[module] = fmt.Sprintf("%s=%d", names[module], Level(module))
+	}
+	entries = append(entries, "format="+formatNames[Format()])
+	return strings.Join(entries, ",")
+}
+
+type specFlag struct{}
+
+func (specFlag) String() string {
+	return String()
+}
+
+func (specFlag) Set(spec string) error {
+	return Parse(spec)
+}
+
+// A flag.Value that parses its argument as a spec
+func Flag() specFlag {
+	return specFlag{}
+}
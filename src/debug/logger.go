@@ -0,0 +1,153 @@
+package debug
+
+// Structured loggers
+//
+// logger := debug.MakeLogger(module)        - Logger of a module with no fields
+// logger.With(key, value, ...)              - Logger adding fields to every record, i.e. "server", id
+// logger.To(w)                              - Logger writing its records to w instead of the output
+// logger.Debugf(format, a...)               - Logs a record at level DEBUG
+// logger.Infof(format, a...)                - Logs a record at level INFO
+// debug.SetFormat(format)                   - Output format of every logger: TEXT or JSON
+// debug.SetOutput(w)                        - Writer of every logger (stderr by default)
+//
+// => A record has a time, a level, a module, the fields of its logger in the order they were
+//    added, and a message; it is only formatted if the verbosity of its module allows its level
+// => TEXT records are single lines, i.e.
+//    "2019/01/02 15:04:05.000000 DEBUG xpaxos server=1 view=2 seqNum=3 Prepare: to server (2)",
+//    and JSON records single JSON objects, i.e.
+//    {"time":"...","level":"debug","module":"xpaxos","server":1,"view":2,"seqNum":3,"msg":"..."},
+//    so that the logs of multi-server runs can be filtered and analyzed by tools
+// => The format is also set by a spec, i.e. "COS518_DEBUG=format=json,xpaxos=2"
+// => Servers of both protocols log through a logger with their ID as the "server" field, which
+//    tests or deployments can replace (see SetLogger() of xpaxos and pbft); messages add the
+//    view and sequence number they are about as "view" and "seqNum", and the trace ID of their
+//    client request as "trace" (see network.NewTraceId())
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const ( // Formats
+	TEXT = iota
+	JSON = iota
+)
+
+var formatNames = []string{"text", "json"}
+
+var levelNames = []string{"NONE", "INFO", "DEBUG"}
+
+var format int32 = TEXT
+
+var outMu sync.Mutex
+var out io.Writer = os.Stderr
+
+type Logger struct {
+	module int
+	keys   []string
+	values []interface{}
+	out    io.Writer // Writer of the records (nil for the output of every logger)
+}
+
+func MakeLogger(module int) *Logger {
+	return &Logger{module: module}
+}
+
+// Pairs of a key (a string) and its value
+func (logger *Logger) With(fields ...interface{}) *Logger {
+	child := &Logger{module: logger.module, out: logger.out}
+	child.keys = append(append([]string(nil), logger.keys...), make([]string, 0, len(fields)/2)...)
+	child.values = append([]interface{}(nil), logger.values...)
+	for i := 0; i+1 < len(fields); i += 2 {
+		child.keys = append(child.keys, fmt.Sprint(fields[i]))
+		child.values = append(child.values, fields[i+1])
+	}
+	return child
+}
+
+func (logger *Logger) To(w io.Writer) *Logger {
+	child := logger.With()
+	child.out = w
+	return child
+}
+
+func (logger *Logger) Debugf(format string, a ...interface{}) {
+	logger.log(DEBUG, format, a...)
+}
+
+func (logger *Logger) Infof(format string, a ...interface{}) {
+	logger.log(INFO, format, a...)
+}
+
+func (logger *Logger) log(level int, format string, a ...interface{}) {
+	if Level(logger.module) < level {
+		return
+	}
+
+	msg := strings.TrimRight(fmt.Sprintf(format, a...), "\n")
+	now := time.Now()
+
+	var buf bytes.Buffer
+	if Format() == JSON {
+		buf.WriteString(`{"time":`)
+		writeJSON(&buf, now.Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		writeJSON(&buf, strings.ToLower(levelNames[level]))
+		buf.WriteString(`,"module":`)
+		writeJSON(&buf, names[logger.module])
+		for i, key := range logger.keys {
+			buf.WriteByte(',')
+			writeJSON(&buf, key)
+			buf.WriteByte(':')
+			writeJSON(&buf, logger.values[i])
+		}
+		buf.WriteString(`,"msg":`)
+		writeJSON(&buf, msg)
+		buf.WriteString("}\n")
+	} else {
+		buf.WriteString(now.Format("2006/01/02 15:04:05.000000 "))
+		buf.WriteString(levelNames[level] + " " + names[logger.module])
+		for i, key := range logger.keys {
+			fmt.Fprintf(&buf, " %s=%v", key, logger.values[i])
+		}
+		buf.WriteString(" " + msg + "\n")
+	}
+
+	outMu.Lock()
+	defer outMu.Unlock()
+	if logger.out != nil {
+		logger.out.Write(buf.Bytes())
+	} else {
+		out.Write(buf.Bytes())
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
+func Format() int {
+	return int(atomic.LoadInt32(&format))
+}
+
+func SetFormat(f int) {
+	atomic.StoreInt32(&format, int32(f))
+}
+
+func SetOutput(w io.Writer) {
+	outMu.Lock()
+	defer outMu.Unlock()
+
+	out = w
+}
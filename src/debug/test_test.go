@@ -0,0 +1,78 @@
+package debug
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestParse(t *testing.T) {
+	fmt.Println("Test: Debug - Per-Module Levels")
+
+	saved := String()
+	defer Parse(saved)
+
+	if err := Parse("all=0, xpaxos=2,CLIENT=1"); err != nil {
+		t.Fatal(err)
+	}
+	if Level(XPAXOS) != DEBUG || Level(CLIENT) != INFO || Level(PBFT) != NONE || Level(NETWORK) != NONE {
+		t.Fatalf("Invalid levels %s!", String())
+	}
+
+	for _, spec := range []string{"xpaxos", "xpaxos=3", "paxos=1", "network=x", "pbft=1,client"} {
+		if err := Parse(spec); err == nil {
+			t.Fatalf("Invalid spec %q parsed!", spec)
+		}
+	}
+	if String() != "xpaxos=2,pbft=0,network=0,client=1,format=text" {
+		t.Fatalf("Invalid spec changed levels to %s!", String())
+	}
+}
+
+func TestLogger(t *testing.T) {
+	fmt.Println("Test: Debug - Structured Records")
+
+	saved := String()
+	defer Parse(saved)
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+
+	if err := Parse("all=0,xpaxos=1,format=json"); err != nil {
+		t.Fatal(err)
+	}
+	logger := MakeLogger(XPAXOS).With("server", 1)
+	logger.With("view", 2, "seqNum", 3).Infof("Prepare: to server (%d)\n", 2)
+	logger.Debugf("Not logged at level INFO")
+	MakeLogger(PBFT).Infof("Not logged for a module at level NONE")
+
+	record := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Record %q is not a JSON object: %v!", buf.String(), err)
+	}
+	if record["level"] != "info" || record["module"] != "xpaxos" || record["server"] != 1.0 ||
+		record["view"] != 2.0 || record["seqNum"] != 3.0 || record["msg"] != "Prepare: to server (2)" {
+		t.Fatalf("Invalid record %v!", record)
+	}
+	if strings.Index(buf.String(), `"server":1,"view":2,"seqNum":3,"msg"`) < 0 {
+		t.Fatalf("Fields out of order in %q!", buf.String())
+	}
+
+	buf.Reset()
+	if err := Parse("format=text"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Infof("Commit")
+	if strings.HasSuffix(buf.String(), " INFO xpaxos server=1 Commit\n") == false {
+		t.Fatalf("Invalid record %q!", buf.String())
+	}
+	if err := Parse("format=xml"); err == nil || Format() != TEXT {
+		t.Fatal("Invalid format parsed!")
+	}
+}
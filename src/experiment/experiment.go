@@ -0,0 +1,408 @@
+package experiment
+
+// Side-by-side experiments with XPaxos and PBFT
+//
+// cluster := MakeCluster(protocol, n) - Creates a network with a client and n-1 replicas
+// MakeClusterScheme(protocol, n, s)   - Same with keys of signature scheme s (see signing)
+// cluster.Propose(op)                 - Proposes an operation through the client
+// cluster.Serve(makeMachine, k)      - Runs a state machine on the replicas, returns the client
+// cluster.StartService()             - Runs the key-value service on the replicas, returns its clerk
+// cluster.SetFaultRate(server, rate)  - Makes a replica fail to send rate% of its RPCs
+// cluster.Cleanup()                   - Kills all replicas
+// res := Run(protocol, n, workload)   - Runs a workload (and its fault schedule) on a fresh cluster
+// Compare(n, workload)                - Runs the same workload on every protocol in PROTOCOLS
+// Sweep(replicas, workload)           - Runs Compare() for every number of replicas in replicas
+//
+// => n is the total number of client and replica servers, as in the protocol test configs
+//    (the client is server 0)
+// => Operations are generated from the workload seed, so every protocol proposes the same
+//    sequence of operations and sees the same faults at the same points of the workload
+// => Latencies are collected in a histogram (see histogram/histogram.go) and reported as mean and
+//    p50/p90/p99/p999
+// => Operations come from a workload generator (see workload/workload.go): closed loop unless the
+//    workload sets an arrival rate; a workload with a duration proposes operations until the
+//    duration elapses (instead of proposing a fixed number of operations)
+// => In an open loop workload, a fault is applied when the operation it precedes is proposed
+// => A workload with Service set runs its operations through the key-value service (see
+//    kvservice), whose state machine every replica applies, so that protocols are also compared
+//    end to end (reads are committed like writes)
+// => Replicas time their signatures and verifications (see CryptoTime() of xpaxos and pbft), so
+//    results attribute the time spent on cryptography apart from the network and the queues
+// => Results report the view changes of the replicas (see xpaxos/vcstats.go): how many, how long
+//    they took and how many messages and re-proposed requests they cost, i.e. the cost of
+//    recovering from the faults of the schedule (PBFT replicas have no view change protocol)
+// => Results report the peak backlog of the servers (see network/stats.go), the most RPCs that
+//    waited to be handled by one server at once, which grows without bound once a server is
+//    overloaded (i.e. the leader under an open loop workload beyond its throughput)
+// => Memory is sampled while the workload runs (see memstats/memstats.go), so results report the
+//    allocations per committed operation and the peak live heap; allocations are those of the
+//    whole process (client, replicas and network)
+// => A workload with a CPU or mutex contention profile profiles the same window (see
+//    profiling/profiling.go), i.e. to find the hot spots (RSA, locks, gob) of a protocol
+// => Replicas sign with keys of the workload's signature scheme (RSA-1024 unless set), so that
+//    protocols can also be compared under the signing and verification costs of i.e. ECDSA or
+//    Ed25519 keys
+
+import (
+	"crypto"
+	"fmt"
+	"github.com/csanti/cos518_project/src/kvservice"
+	"github.com/csanti/cos518_project/src/memstats"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/pbft"
+	"github.com/csanti/cos518_project/src/profiling"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"github.com/csanti/cos518_project/src/workload"
+	"github.com/csanti/cos518_project/src/xpaxos"
+	"time"
+)
+
+const CLIENT = 0 // Client ID is always set to zero (see xpaxos/common.go and pbft/common.go)
+
+type Client interface {
+	Propose(op interface{}) bool // Whether the request was committed
+	statemachine.Consensus
+}
+
+type Replica interface {
+	SetStateMachine(sm statemachine.StateMachine)
+	SetCheckpointInterval(interval int)
+	CryptoTime() (time.Duration, time.Duration) // Time spent signing and verifying signatures
+	Kill()
+}
+
+type viewChanger interface { // Replicas of protocols with a view change (see xpaxos/vcstats.go)
+	ViewChangeStats() xpaxos.ViewChangeStats
+}
+
+type Protocol struct {
+	Name        string
+	MakeReplica func(replicas []network.Transport, id int, privateKey crypto.Signer,
+		publicKeys map[int]crypto.PublicKey) Replica
+	MakeClient func(replicas []network.Transport) Client
+	Faults     func(n int) int // Number of faults tolerated by n servers (client included)
+}
+
+var XPaxos = Protocol{
+	Name: "XPaxos",
+	MakeReplica: func(replicas []network.Transport, id int, privateKey crypto.Signer,
+		publicKeys map[int]crypto.PublicKey) Replica {
+		return xpaxos.Make(replicas, id, xpaxos.MakePersister(), signing.KeySigner(privateKey), publicKeys)
+	},
+	MakeClient: func(replicas []network.Transport) Client {
+		return xpaxos.MakeClient(replicas)
+	},
+	Faults: func(n int) int {
+		return (n - 2) / 2 // n-1 = 2t+1 replicas
+	},
+}
+
+var PBFT = Protocol{
+	Name: "PBFT",
+	MakeReplica: func(replicas []network.Transport, id int, privateKey crypto.Signer,
+		publicKeys map[int]crypto.PublicKey) Replica {
+		return pbft.Make(replicas, id, signing.KeySigner(privateKey), publicKeys)
+	},
+	MakeClient: func(replicas []network.Transport) Client {
+		return pbft.MakeClient(replicas)
+	},
+	Faults: func(n int) int {
+		return (n - 2) / 3 // n-1 = 3f+1 replicas
+	},
+}
+
+var PROTOCOLS = []Protocol{XPaxos, PBFT}
+
+var REPLICAS = []int{4, 7, 10, 13} // Cluster sizes of the scaling experiments
+
+type Fault struct {
+	Before    int // Index of the operation before which the fault is applied
+	Server    int
+	FaultRate int // Percentage of RPCs the server fails to send (100 = crashed, 0 = recovered)
+}
+
+type Workload struct {
+	workload.Config                // Operations (size, arrival rate, read/write mix and keys)
+	Seed            int64          // Seeds both the operations and the network's drops and delays
+	Ops             int            // Number of operations proposed
+	Duration        time.Duration  // If set, propose operations until the duration elapses (ignores Ops)
+	Faults          []Fault        // Fault schedule
+	Unreliable      bool           // Whether the network drops and delays RPCs
+	MemSample       time.Duration  // Sample memory at this interval (0 = only before and after)
+	HeapDir         string         // Dump a heap profile to this directory at every memory sample (if set)
+	CPUProfile      string         // Write a CPU profile of the workload to this file (if set)
+	MutexProfile    string         // Write a mutex contention profile of the workload to this file (if set)
+	Service         bool           // Run the operations through the key-value service
+	Scheme          signing.Scheme // Signature scheme of the replicas' keys (signing.DEFAULT if unset)
+}
+
+type Result struct {
+	Protocol       string
+	N              int
+	F              int           // Faults tolerated by the protocol with N servers
+	Unreliable     bool          // Whether the workload ran on an unreliable network
+	Ops            int           // Operations proposed
+	Committed      int           // Operations the client saw commit
+	Duration       time.Duration // Wall clock time of the whole workload
+	Throughput     float64       // Committed operations per second
+	Latency        time.Duration // Mean latency of all operations
+	P50            time.Duration // Latency percentiles of all operations
+	P90            time.Duration
+	P99            time.Duration
+	P999           time.Duration
+	RPCs           int            // RPCs executed by all servers (including the client)
+	Bytes          int64          // Request and reply bytes of all RPCs
+	Allocs         uint64         // Heap objects allocated while the workload ran
+	AllocBytes     uint64         // Heap bytes allocated while the workload ran
+	PeakHeap       uint64         // Largest live heap sampled (in bytes)
+	SignTime       time.Duration  // Time all replicas spent signing messages
+	VerifyTime     time.Duration  // Time all replicas spent verifying signatures
+	ViewChanges    int            // View changes completed by the replica that completed the most
+	ViewChangeTime time.Duration  // Longest time a replica spent in view changes
+	ViewChangeMsgs int            // View change messages sent by all replicas
+	Reproposed     int            // Most requests re-proposed by new leaders that a replica executed
+	PeakBacklog    int            // Most RPCs a server had sent to it but not yet handled at once
+	Service        bool           // Whether the operations ran through the key-value service
+	Scheme         signing.Scheme // Signature scheme of the replicas' keys
+}
+
+type Cluster struct {
+	Net      *network.Network
+	Protocol Protocol
+	n        int
+	client   Client
+	replicas []Replica
+	clerk    *kvservice.Clerk // Clerk of the key-value service (nil until cluster.StartService())
+}
+
+func MakeCluster(protocol Protocol, n int) *Cluster {
+	return MakeClusterScheme(protocol, n, signing.DEFAULT)
+}
+
+func MakeClusterScheme(protocol Protocol, n int, scheme signing.Scheme) *Cluster {
+	cluster := &Cluster{}
+	cluster.Net = network.MakeNetwork()
+	cluster.Protocol = protocol
+	cluster.n = n
+	cluster.replicas = make([]Replica, n)
+
+	publicKeys := make(map[int]crypto.PublicKey, n)
+	privateKeys := make(map[int]crypto.Signer, n)
+	for i := 1; i < n; i++ {
+		privateKey, err := scheme.GenerateKey()
+		if err != nil {
+			panic(err)
+		}
+		privateKeys[i] = privateKey
+		publicKeys[i] = privateKey.Public()
+	}
+
+	for i := 0; i < n; i++ {
+		ends := cluster.makeEnds(i)
+
+		var rcvr interface{}
+		if i == CLIENT {
+			cluster.client = protocol.MakeClient(ends)
+			rcvr = cluster.client
+		} else {
+			cluster.replicas[i] = protocol.MakeReplica(ends, i, privateKeys[i], publicKeys)
+			rcvr = cluster.replicas[i]
+		}
+
+		srv := network.MakeServer()
+		srv.AddService(network.MakeService(rcvr))
+		cluster.Net.AddServer(i, srv)
+	}
+
+	return cluster
+}
+
+// A ClientEnd from server i to every server (including itself)
+func (cluster *Cluster) makeEnds(i int) []network.Transport {
+	ends := make([]network.Transport, cluster.n)
+	for j := 0; j < cluster.n; j++ {
+		endname := fmt.Sprintf("%d-%d", i, j)
+		ends[j] = cluster.Net.MakeEnd(endname)
+		cluster.Net.Connect(endname, j)
+		cluster.Net.Enable(endname, true)
+	}
+	return ends
+}
+
+func (cluster *Cluster) Propose(op interface{}) bool {
+	return cluster.client.Propose(op)
+}
+
+// Every replica applies committed operations to its own state machine made by makeMachine from now
+// on, and takes a checkpoint of it every interval operations; returns the client to propose them
+func (cluster *Cluster) Serve(makeMachine func() statemachine.StateMachine, interval int) statemachine.Consensus {
+	for _, replica := range cluster.replicas[1:] {
+		replica.SetCheckpointInterval(interval)
+		replica.SetStateMachine(makeMachine())
+	}
+	return cluster.client
+}
+
+// Runs the key-value service (see kvservice) on the replicas
+func (cluster *Cluster) StartService() *kvservice.Clerk {
+	client := cluster.Serve(func() statemachine.StateMachine { return kvservice.MakeKV() }, kvservice.CHECKPOINT)
+	cluster.clerk = kvservice.MakeClerk(client)
+	return cluster.clerk
+}
+
+func (cluster *Cluster) SetFaultRate(server int, rate int) {
+	cluster.Net.SetFaultRate(server, rate)
+}
+
+func (cluster *Cluster) Cleanup() {
+	for _, replica := range cluster.replicas[1:] {
+		replica.Kill()
+	}
+}
+
+func Run(protocol Protocol, n int, w Workload) Result {
+	cluster := MakeClusterScheme(protocol, n, w.Scheme)
+	defer cluster.Cleanup()
+
+	cluster.Net.Reliable(!w.Unreliable)
+	cluster.Net.Seed(w.Seed)
+
+	res := Result{}
+	res.Protocol = protocol.Name
+	res.N = n
+	res.F = protocol.Faults(n)
+	res.Unreliable = w.Unreliable
+	res.Service = w.Service
+	res.Scheme = w.Scheme
+
+	propose := func(op workload.Op) bool { return cluster.Propose(op) }
+	if w.Service == true {
+		propose = cluster.StartService().Do
+	}
+
+	gen := workload.MakeGenerator(w.Config, w.Seed)
+	mem := memstats.Start(w.MemSample, w.HeapDir)
+	prof, err := profiling.Start(w.CPUProfile, w.MutexProfile)
+	if err != nil {
+		panic(err)
+	}
+	stats := gen.Run(w.Ops, w.Duration, func(i int, op workload.Op) bool {
+		for _, fault := range w.Faults {
+			if fault.Before == i {
+				cluster.SetFaultRate(fault.Server, fault.FaultRate)
+			}
+		}
+		return propose(op)
+	})
+	memSum := mem.Stop()
+	if _, err := prof.Stop(); err != nil {
+		panic(err)
+	}
+
+	res.Ops = stats.Ops
+	res.Committed = stats.Committed
+	res.Duration = stats.Duration
+	if res.Duration > 0 {
+		res.Throughput = float64(res.Committed) / res.Duration.Seconds()
+	}
+	res.Latency = stats.Latencies.Mean()
+	res.P50 = stats.Latencies.Percentile(50)
+	res.P90 = stats.Latencies.Percentile(90)
+	res.P99 = stats.Latencies.Percentile(99)
+	res.P999 = stats.Latencies.Percentile(99.9)
+
+	netStats := cluster.Net.Stats()
+	for _, server := range netStats.Servers {
+		res.RPCs += server.RPCs
+		res.Bytes += server.Bytes
+		if server.PeakBacklog > res.PeakBacklog {
+			res.PeakBacklog = server.PeakBacklog
+		}
+	}
+
+	res.Allocs = memSum.Allocs
+	res.AllocBytes = memSum.Bytes
+	res.PeakHeap = memSum.PeakHeap
+
+	for _, replica := range cluster.replicas[1:] {
+		sign, verify := replica.CryptoTime()
+		res.SignTime += sign
+		res.VerifyTime += verify
+
+		if vc, ok := replica.(viewChanger); ok {
+			stats := vc.ViewChangeStats()
+			if stats.Completed > res.ViewChanges {
+				res.ViewChanges = stats.Completed
+			}
+			if stats.Duration > res.ViewChangeTime {
+				res.ViewChangeTime = stats.Duration
+			}
+			res.ViewChangeMsgs += stats.Messages
+			if stats.Reproposed > res.Reproposed {
+				res.Reproposed = stats.Reproposed
+			}
+		}
+	}
+
+	return res
+}
+
+func Compare(n int, workload Workload) []Result {
+	results := make([]Result, 0, len(PROTOCOLS))
+	for _, protocol := range PROTOCOLS {
+		results = append(results, Run(protocol, n, workload))
+	}
+	return results
+}
+
+func Sweep(replicas []int, workload Workload) []Result {
+	results := make([]Result, 0, len(replicas)*len(PROTOCOLS))
+	for _, numReplicas := range replicas {
+		results = append(results, Compare(numReplicas+1, workload)...) // Client included
+	}
+	return results
+}
+
+// RPCs per committed operation
+func (res Result) MessagesPerOp() float64 {
+	if res.Committed == 0 {
+		return 0
+	}
+	return float64(res.RPCs) / float64(res.Committed)
+}
+
+// Heap objects allocated per committed operation
+func (res Result) AllocsPerOp() float64 {
+	if res.Committed == 0 {
+		return 0
+	}
+	return float64(res.Allocs) / float64(res.Committed)
+}
+
+// Time all replicas spent signing and verifying per committed operation
+func (res Result) CryptoTimePerOp() time.Duration {
+	if res.Committed == 0 {
+		return 0
+	}
+	return (res.SignTime + res.VerifyTime) / time.Duration(res.Committed)
+}
+
+// Heap bytes allocated per committed operation
+func (res Result) AllocBytesPerOp() float64 {
+	if res.Committed == 0 {
+		return 0
+	}
+	return float64(res.AllocBytes) / float64(res.Committed)
+}
+
+func (res Result) String() string {
+	protocol := res.Protocol
+	if res.Service == true {
+		protocol += "/KV"
+	}
+	return fmt.Sprintf("%-9s n=%d f=%d committed=%d/%d throughput=%.1f ops/s latency=%v (p50=%v p90=%v p99=%v p999=%v) rpcs=%d (%.1f/op) bytes=%d allocs=%.0f/op (%.0f B/op) peak-heap=%d sign=%v verify=%v (%v/op) view-changes=%d (%v, %d msgs, %d reproposed) peak-backlog=%d scheme=%v",
+		protocol, res.N, res.F, res.Committed, res.Ops, res.Throughput, res.Latency, res.P50, res.P90, res.P99, res.P999, res.RPCs, res.MessagesPerOp(),
+		res.Bytes, res.AllocsPerOp(), res.AllocBytesPerOp(), res.PeakHeap, res.SignTime, res.VerifyTime,
+		res.CryptoTimePerOp(), res.ViewChanges, res.ViewChangeTime, res.ViewChangeMsgs, res.Reproposed, res.PeakBacklog, res.Scheme)
+}
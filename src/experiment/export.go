@@ -0,0 +1,145 @@
+package experiment
+
+// Machine-readable export of experiment results (for plotting)
+//
+// WriteResults(path, results) - Writes results to path as CSV (.csv) or JSON (anything else)
+// WriteCSV(w, results)        - Writes a header and one row per result
+// WriteJSON(w, results)       - Writes an array with one object per result
+//
+// => Both formats carry the same fields under the same names (see Record); latencies are in
+//    milliseconds
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type Record struct {
+	Protocol        string  `json:"protocol"`
+	N               int     `json:"n"` // Client included
+	F               int     `json:"f"` // Faults tolerated
+	Unreliable      bool    `json:"unreliable"`
+	Ops             int     `json:"ops"`
+	Committed       int     `json:"committed"`
+	DurationMs      float64 `json:"duration_ms"`
+	Throughput      float64 `json:"throughput"` // Committed operations per second
+	LatencyMs       float64 `json:"latency_ms"` // Mean
+	P50Ms           float64 `json:"p50_ms"`
+	P90Ms           float64 `json:"p90_ms"`
+	P99Ms           float64 `json:"p99_ms"`
+	P999Ms          float64 `json:"p999_ms"`
+	RPCs            int     `json:"rpcs"`
+	MessagesPerOp   float64 `json:"msgs_per_op"`
+	Bytes           int64   `json:"bytes"`
+	AllocsPerOp     float64 `json:"allocs_per_op"`
+	AllocBytesPerOp float64 `json:"alloc_bytes_per_op"`
+	PeakHeap        uint64  `json:"peak_heap_bytes"`
+	SignMs          float64 `json:"sign_ms"`   // Time all replicas spent signing
+	VerifyMs        float64 `json:"verify_ms"` // Time all replicas spent verifying signatures
+	ViewChanges     int     `json:"view_changes"`
+	ViewChangeMs    float64 `json:"view_change_ms"` // Longest time a replica spent in view changes
+	ViewChangeMsgs  int     `json:"view_change_msgs"`
+	Reproposed      int     `json:"reproposed"`
+	PeakBacklog     int     `json:"peak_backlog"` // Most RPCs waiting to be handled by a server
+	Service         bool    `json:"service"`      // Operations ran through the key-value service
+	Scheme          string  `json:"scheme"`       // Signature scheme of the replicas' keys
+}
+
+var HEADER = []string{"protocol", "n", "f", "unreliable", "ops", "committed", "duration_ms",
+	"throughput", "latency_ms", "p50_ms", "p90_ms", "p99_ms", "p999_ms", "rpcs", "msgs_per_op", "bytes",
+	"allocs_per_op", "alloc_bytes_per_op", "peak_heap_bytes", "sign_ms", "verify_ms", "view_changes", "view_change_ms", "view_change_msgs", "reproposed", "peak_backlog", "service", "scheme"}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (res Result) Record() Record {
+	return Record{
+		Protocol:        res.Protocol,
+		N:               res.N,
+		F:               res.F,
+		Unreliable:      res.Unreliable,
+		Ops:             res.Ops,
+		Committed:       res.Committed,
+		DurationMs:      milliseconds(res.Duration),
+		Throughput:      res.Throughput,
+		LatencyMs:       milliseconds(res.Latency),
+		P50Ms:           milliseconds(res.P50),
+		P90Ms:           milliseconds(res.P90),
+		P99Ms:           milliseconds(res.P99),
+		P999Ms:          milliseconds(res.P999),
+		RPCs:            res.RPCs,
+		MessagesPerOp:   res.MessagesPerOp(),
+		Bytes:           res.Bytes,
+		AllocsPerOp:     res.AllocsPerOp(),
+		AllocBytesPerOp: res.AllocBytesPerOp(),
+		PeakHeap:        res.PeakHeap,
+		SignMs:          milliseconds(res.SignTime),
+		VerifyMs:        milliseconds(res.VerifyTime),
+		ViewChanges:     res.ViewChanges,
+		ViewChangeMs:    milliseconds(res.ViewChangeTime),
+		ViewChangeMsgs:  res.ViewChangeMsgs,
+		Reproposed:      res.Reproposed,
+		PeakBacklog:     res.PeakBacklog,
+		Service:         res.Service,
+		Scheme:          res.Scheme.String()}
+}
+
+// CSV row in the order of HEADER
+func (rec Record) row() []string {
+	float := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 3, 64)
+	}
+
+	return []string{rec.Protocol, strconv.Itoa(rec.N), strconv.Itoa(rec.F),
+		strconv.FormatBool(rec.Unreliable), strconv.Itoa(rec.Ops), strconv.Itoa(rec.Committed),
+		float(rec.DurationMs), float(rec.Throughput), float(rec.LatencyMs), float(rec.P50Ms),
+		float(rec.P90Ms), float(rec.P99Ms), float(rec.P999Ms), strconv.Itoa(rec.RPCs),
+		float(rec.MessagesPerOp), strconv.FormatInt(rec.Bytes, 10), float(rec.AllocsPerOp),
+		float(rec.AllocBytesPerOp), strconv.FormatUint(rec.PeakHeap, 10), float(rec.SignMs), float(rec.VerifyMs),
+		strconv.Itoa(rec.ViewChanges), float(rec.ViewChangeMs), strconv.Itoa(rec.ViewChangeMsgs),
+		strconv.Itoa(rec.Reproposed), strconv.Itoa(rec.PeakBacklog), strconv.FormatBool(rec.Service), rec.Scheme}
+}
+
+func WriteCSV(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(HEADER); err != nil {
+		return err
+	}
+	for _, res := range results {
+		if err := cw.Write(res.Record().row()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func WriteJSON(w io.Writer, results []Result) error {
+	records := make([]Record, 0, len(results))
+	for _, res := range results {
+		records = append(records, res.Record())
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+func WriteResults(path string, results []Result) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if filepath.Ext(path) == ".csv" {
+		return WriteCSV(file, results)
+	}
+	return WriteJSON(file, results)
+}
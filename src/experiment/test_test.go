@@ -0,0 +1,214 @@
+package experiment
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/csanti/cos518_project/src/lockservice"
+	"github.com/csanti/cos518_project/src/profiling"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// go test -run=XXX -bench=Scaling -benchtime=1x -results=scaling.csv (or .json)
+var resultsPath = flag.String("results", "", "write benchmark results to this file (CSV if it ends in .csv, JSON otherwise)")
+var memSample = flag.Duration("memsample", 0, "sample memory during benchmarks at this interval (default: only before and after)")
+var heapDir = flag.String("heapdir", "", "dump heap profiles of every benchmark run to a subdirectory of this directory")
+var cpuDir = flag.String("cpudir", "", "write a CPU profile of every benchmark run to this directory")
+var mutexDir = flag.String("mutexdir", "", "write a mutex contention profile of every benchmark run to this directory")
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestCompareNoFaults(t *testing.T) {
+	fmt.Println("Test: Experiment - XPaxos vs. PBFT, No Faults")
+
+	workload := Workload{Seed: 1, Ops: 10}
+	workload.Size = 1024
+	workload.CPUProfile = filepath.Join(t.TempDir(), "cpu.pprof") // Overwritten by every protocol
+
+	for _, res := range Compare(4, workload) {
+		fmt.Println(res)
+		if res.Committed != workload.Ops {
+			t.Fatal("Not all operations committed!")
+		}
+		if res.RPCs == 0 || res.Bytes == 0 {
+			t.Fatal("No RPCs recorded!")
+		}
+		if res.Allocs == 0 || res.AllocsPerOp() == 0 || res.PeakHeap == 0 {
+			t.Fatal("No allocations recorded!")
+		}
+		if res.SignTime == 0 || res.VerifyTime == 0 || res.CryptoTimePerOp() > res.Duration {
+			t.Fatal("No time spent on signatures recorded!")
+		}
+		if res.PeakBacklog == 0 {
+			t.Fatal("No backlog recorded!")
+		}
+	}
+	if info, err := os.Stat(workload.CPUProfile); err != nil || info.Size() == 0 {
+		t.Fatal("No CPU profile written!")
+	}
+}
+
+func TestCompareSchemes(t *testing.T) {
+	fmt.Println("Test: Experiment - XPaxos vs. PBFT, ECDSA and Ed25519 Keys")
+
+	for _, scheme := range []signing.Scheme{signing.ECDSAP256, signing.ED25519} {
+		workload := Workload{Seed: 1, Ops: 5, Scheme: scheme}
+		workload.Size = 64
+
+		for _, res := range Compare(4, workload) {
+			fmt.Println(res)
+			if res.Committed != workload.Ops || res.Scheme != scheme || res.Record().Scheme != scheme.String() {
+				t.Fatalf("Not all operations committed with %v keys!", scheme)
+			}
+		}
+	}
+}
+
+func TestRunFaultSchedule(t *testing.T) {
+	fmt.Println("Test: Experiment - XPaxos, Single Crash Failure (t=1)")
+
+	// XPaxos server (ID = 2) fails to send RPCs 100% of the time
+	workload := Workload{Seed: 1, Ops: 3}
+	workload.Size = 64
+	workload.Faults = []Fault{Fault{Before: 0, Server: 2, FaultRate: 100}}
+
+	res := Run(XPaxos, 4, workload)
+	fmt.Println(res)
+	if res.Committed == 0 {
+		t.Fatal("No operations committed!")
+	}
+	if res.ViewChanges == 0 || res.ViewChangeTime == 0 || res.ViewChangeMsgs == 0 {
+		t.Fatal("View change of the crash not reported!")
+	}
+}
+
+func TestCompareService(t *testing.T) {
+	fmt.Println("Test: Experiment - XPaxos vs. PBFT, Key-Value Service")
+
+	for _, protocol := range PROTOCOLS {
+		cluster := MakeCluster(protocol, 5)
+		clerk := cluster.StartService()
+
+		clerk.Put("a", "x")
+		clerk.Append("a", "y")
+		if value, ok := clerk.Get("a"); ok == false || value != "xy" {
+			t.Fatalf("%s: expected xy, got %q!", protocol.Name, value)
+		}
+		cluster.Cleanup()
+	}
+
+	workload := Workload{Seed: 1, Ops: 10, Service: true}
+	workload.Size = 64
+	workload.ReadRatio = 0.5
+
+	for _, res := range Compare(5, workload) {
+		fmt.Println(res)
+		if res.Committed != workload.Ops || res.Service == false {
+			t.Fatal("Not all operations committed!")
+		}
+	}
+}
+
+func TestLockService(t *testing.T) {
+	fmt.Println("Test: Experiment - XPaxos vs. PBFT, Lock Service")
+
+	for _, protocol := range PROTOCOLS {
+		cluster := MakeCluster(protocol, 5)
+		client := cluster.Serve(func() statemachine.StateMachine { return lockservice.MakeLocks() }, 0)
+		alice := lockservice.MakeLocker(client, "alice", 200*time.Millisecond)
+		bob := lockservice.MakeLocker(client, "bob", 200*time.Millisecond)
+
+		if alice.Acquire("a") == false || bob.Acquire("a") == true {
+			t.Fatalf("%s: lock acquired by two sessions!", protocol.Name)
+		}
+		time.Sleep(300 * time.Millisecond) // Alice stops renewing her lease
+		if bob.Acquire("a") == false {
+			t.Fatalf("%s: expired lease not released!", protocol.Name)
+		}
+		cluster.Cleanup()
+	}
+}
+
+func TestWriteResults(t *testing.T) {
+	fmt.Println("Test: Experiment - CSV and JSON Export")
+
+	results := []Result{
+		Result{Protocol: "XPaxos", N: 4, F: 1, Ops: 10, Committed: 10, P99: 1500 * time.Microsecond},
+		Result{Protocol: "PBFT", N: 5, F: 1, Unreliable: true, Ops: 10, Committed: 9, RPCs: 90}}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || len(rows[0]) != len(HEADER) || rows[1][0] != "XPaxos" || rows[2][3] != "true" ||
+		rows[1][11] != "1.500" {
+		t.Fatal("Invalid CSV export!")
+	}
+
+	buf.Reset()
+	if err := WriteJSON(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	var records []Record
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1] != results[1].Record() || records[1].MessagesPerOp != 10 {
+		t.Fatal("Invalid JSON export!")
+	}
+}
+
+//
+// ---------------------------- BENCHMARK FUNCTIONS ---------------------------
+//
+// Benchmark_Scaling - Closed loop workload of 1 kB operations for one second per protocol and
+// number of replicas (n.b. compare the ops/s, msgs/op and allocs/commit metrics; ns/op is
+// meaningless); with -results, the last run of every protocol and number of replicas is written to
+// a file, with -heapdir, heap profiles of each go to their own subdirectory, and with -cpudir and
+// -mutexdir, each writes its CPU and mutex contention profiles (i.e. XPaxos_4.cpu.pprof)
+func Benchmark_Scaling(b *testing.B) {
+	workload := Workload{Seed: 1, Duration: time.Second}
+	workload.Size = 1024
+	workload.MemSample = *memSample
+	results := make([]Result, 0)
+
+	for _, protocol := range PROTOCOLS {
+		for _, replicas := range REPLICAS {
+			name := fmt.Sprintf("%s_%d", protocol.Name, replicas)
+			if *heapDir != "" {
+				workload.HeapDir = filepath.Join(*heapDir, name)
+			}
+			workload.CPUProfile = profiling.FileName(*cpuDir, name, "cpu")
+			workload.MutexProfile = profiling.FileName(*mutexDir, name, "mutex")
+
+			b.Run(name, func(b *testing.B) {
+				var res Result
+				for i := 0; i < b.N; i++ {
+					res = Run(protocol, replicas+1, workload) // Client included
+				}
+				b.ReportMetric(res.Throughput, "ops/s")
+				b.ReportMetric(res.MessagesPerOp(), "msgs/op")
+				b.ReportMetric(res.AllocsPerOp(), "allocs/commit")
+				results = append(results, res)
+			})
+		}
+	}
+
+	if *resultsPath != "" {
+		if err := WriteResults(*resultsPath, results); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package gateway
+
+// HTTP gateway to the key-value service (see kvservice), i.e. for load generators not written in Go
+//
+// gw := MakeGateway(clerks, timeout) - An http.Handler issuing operations through a pool of clerks
+// http.ListenAndServe(addr, gw)     - Serves the gateway
+//
+// GET  /kv/<key>                     - Value of key
+// PUT  /kv/<key> {"value": "..."}    - Replaces the value of key
+// POST /kv/<key> {"value": "..."}    - Appends to the value of key
+//
+// => Every response is a JSON object: {"key": ..., "value": ...} with the committed value (200),
+//    or {"error": ...} if the request is malformed (400), the operation did not commit (503) or
+//    no clerk got a reply within timeout (504)
+// => A clerk runs one operation at a time (a client's requests are ordered by their timestamps),
+//    so concurrent HTTP requests are spread over the pool and wait for an idle clerk; the clerks
+//    should have distinct client IDs (see ConnectClients() in xpaxos/cluster.go)
+// => A clerk that timed out returns to the pool once its operation is done
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/csanti/cos518_project/src/kvservice"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const PREFIX = "/kv/"
+
+type Gateway struct {
+	clerks  chan *kvservice.Clerk // Idle clerks
+	timeout time.Duration
+}
+
+type Request struct {
+	Value string `json:"value"`
+}
+
+type Response struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value"`
+	Error string `json:"error,omitempty"`
+}
+
+type result struct {
+	value string
+	ok    bool
+}
+
+func MakeGateway(clerks []*kvservice.Clerk, timeout time.Duration) *Gateway {
+	gw := &Gateway{}
+	gw.clerks = make(chan *kvservice.Clerk, len(clerks))
+	for _, clerk := range clerks {
+		gw.clerks <- clerk
+	}
+	gw.timeout = timeout
+	return gw
+}
+
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, PREFIX) == false || len(r.URL.Path) == len(PREFIX) {
+		reply(w, http.StatusNotFound, Response{Error: "not found: " + r.URL.Path})
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, PREFIX)
+
+	var op func(clerk *kvservice.Clerk) (string, bool)
+	switch r.Method {
+	case http.MethodGet:
+		op = func(clerk *kvservice.Clerk) (string, bool) { return clerk.Get(key) }
+	case http.MethodPut, http.MethodPost:
+		request := Request{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			reply(w, http.StatusBadRequest, Response{Error: "malformed body: " + err.Error()})
+			return
+		}
+		if r.Method == http.MethodPut {
+			op = func(clerk *kvservice.Clerk) (string, bool) { return clerk.Put(key, request.Value) }
+		} else {
+			op = func(clerk *kvservice.Clerk) (string, bool) { return clerk.Append(key, request.Value) }
+		}
+	default:
+		reply(w, http.StatusMethodNotAllowed, Response{Error: "method not allowed: " + r.Method})
+		return
+	}
+
+	timer := time.NewTimer(gw.timeout)
+	defer timer.Stop()
+
+	var clerk *kvservice.Clerk
+	select {
+	case clerk = <-gw.clerks:
+	case <-timer.C:
+		reply(w, http.StatusGatewayTimeout, Response{Key: key, Error: "no idle clerk"})
+		return
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, ok := op(clerk)
+		gw.clerks <- clerk
+		done <- result{value, ok}
+	}()
+
+	select {
+	case res := <-done:
+		if res.ok == false {
+			reply(w, http.StatusServiceUnavailable, Response{Key: key, Error: "operation not committed"})
+			return
+		}
+		reply(w, http.StatusOK, Response{Key: key, Value: res.value})
+	case <-timer.C:
+		reply(w, http.StatusGatewayTimeout, Response{Key: key, Error: fmt.Sprintf("no reply within %v",
+			gw.timeout)})
+	}
+}
+
+func reply(w http.ResponseWriter, status int, response Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
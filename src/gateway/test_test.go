@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/csanti/cos518_project/src/kvservice"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Cluster behind the clerks of a gateway: requests wait for blocked while it is not nil, and are
+// applied but reported as committed only if commit is set (i.e. a leader that lost its view)
+type cluster struct {
+	*statemachine.Local
+	blocked chan bool
+	commit  bool
+}
+
+func (c *cluster) Execute(op interface{}) ([]byte, bool) {
+	if c.blocked != nil {
+		<-c.blocked
+	}
+	result, _ := c.Local.Execute(op)
+	return result, c.commit
+}
+
+func do(t *testing.T, url string, method string, body string) (int, Response) {
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	response := Response{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, response
+}
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestGateway(t *testing.T) {
+	fmt.Println("Test: Gateway - Get, Put and Append over HTTP")
+
+	kv := kvservice.MakeKV()
+	clerks := []*kvservice.Clerk{kvservice.MakeClerk(&cluster{Local: statemachine.MakeLocal(kv), commit: true}),
+		kvservice.MakeClerk(&cluster{Local: statemachine.MakeLocal(kv), commit: true})}
+	server := httptest.NewServer(MakeGateway(clerks, time.Second))
+	defer server.Close()
+
+	if status, response := do(t, server.URL+"/kv/a", http.MethodPut, `{"value": "x"}`); status != http.StatusOK ||
+		response.Value != "x" {
+		t.Fatalf("Put replied %d %+v!", status, response)
+	}
+	if status, response := do(t, server.URL+"/kv/a", http.MethodPost, `{"value": "y"}`); status != http.StatusOK ||
+		response.Value != "xy" {
+		t.Fatalf("Append replied %d %+v!", status, response)
+	}
+	if status, response := do(t, server.URL+"/kv/a", http.MethodGet, ""); status != http.StatusOK ||
+		response.Key != "a" || response.Value != "xy" || kv.Get("a") != "xy" {
+		t.Fatalf("Get replied %d %+v!", status, response)
+	}
+
+	if status, _ := do(t, server.URL+"/kv/a", http.MethodPut, `{"value": `); status != http.StatusBadRequest {
+		t.Fatalf("Malformed body replied %d!", status)
+	}
+	if status, _ := do(t, server.URL+"/kv/a", http.MethodDelete, ""); status != http.StatusMethodNotAllowed {
+		t.Fatalf("Unknown method replied %d!", status)
+	}
+	if status, _ := do(t, server.URL+"/other", http.MethodGet, ""); status != http.StatusNotFound {
+		t.Fatalf("Unknown path replied %d!", status)
+	}
+}
+
+func TestGatewayFailures(t *testing.T) {
+	fmt.Println("Test: Gateway - Uncommitted and Timed Out Operations")
+
+	failing := MakeGateway([]*kvservice.Clerk{kvservice.MakeClerk(&cluster{Local: statemachine.MakeLocal(kvservice.MakeKV())})}, time.Second)
+	server := httptest.NewServer(failing)
+	if status, _ := do(t, server.URL+"/kv/a", http.MethodGet, ""); status != http.StatusServiceUnavailable {
+		t.Fatalf("Uncommitted operation replied %d!", status)
+	}
+	server.Close()
+
+	blocked := &cluster{Local: statemachine.MakeLocal(kvservice.MakeKV()), blocked: make(chan bool), commit: true}
+	server = httptest.NewServer(MakeGateway([]*kvservice.Clerk{kvservice.MakeClerk(blocked)},
+		100*time.Millisecond))
+	defer server.Close()
+
+	if status, _ := do(t, server.URL+"/kv/a", http.MethodGet, ""); status != http.StatusGatewayTimeout {
+		t.Fatalf("Operation without reply replied %d!", status)
+	}
+	if status, _ := do(t, server.URL+"/kv/a", http.MethodGet, ""); status != http.StatusGatewayTimeout {
+		t.Fatalf("Request without idle clerk replied %d!", status)
+	}
+
+	blocked.blocked <- true // The clerk returns to the pool once its operation is done
+	close(blocked.blocked)
+	if status, _ := do(t, server.URL+"/kv/a", http.MethodGet, ""); status != http.StatusOK {
+		t.Fatalf("Operation after the clerk returned replied %d!", status)
+	}
+}
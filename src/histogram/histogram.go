@@ -0,0 +1,154 @@
+package histogram
+
+// Latency histograms with HDR-style buckets
+//
+// h := MakeHistogram()  - Creates an empty histogram
+// h.Record(d)           - Records a latency
+// h.Merge(other)        - Adds all latencies recorded by another histogram
+// h.Percentile(p)       - Latency below which p percent of the recorded latencies fall
+// h.Count(), h.Mean(), h.Max()
+// h.Summary()           - "n=... mean=... p50=... p90=... p99=... p999=... max=..."
+//
+// => Values below SUBBUCKETS nanoseconds get a bucket each; above, every power of two is split into
+//    SUBBUCKETS/2 equal buckets, so a percentile is off by at most 2/SUBBUCKETS (about 3%) no
+//    matter the magnitude of the latencies
+// => Percentiles report the upper end of their bucket (capped by the largest recorded latency)
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+const SUBBUCKETS = 64 // Values below SUBBUCKETS are exact; above, each power of two gets SUBBUCKETS/2 buckets
+
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []int64
+	count   int64
+	sum     time.Duration
+	max     time.Duration
+}
+
+func MakeHistogram() *Histogram {
+	h := &Histogram{}
+	h.buckets = make([]int64, SUBBUCKETS)
+	return h
+}
+
+// Index of the bucket of v (in nanoseconds)
+func bucketOf(v uint64) int {
+	if v < SUBBUCKETS {
+		return int(v)
+	}
+	shift := bits.Len64(v) - bits.Len64(SUBBUCKETS/2) // v>>shift is in [SUBBUCKETS/2, SUBBUCKETS)
+	return shift*SUBBUCKETS/2 + int(v>>uint(shift))
+}
+
+// Largest value (in nanoseconds) that falls into bucket i
+func upperBound(i int) uint64 {
+	if i < SUBBUCKETS {
+		return uint64(i)
+	}
+	shift := uint(i/(SUBBUCKETS/2) - 1)
+	sub := uint64(i%(SUBBUCKETS/2) + SUBBUCKETS/2)
+	return (sub+1)<<shift - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := bucketOf(uint64(d))
+	for i >= len(h.buckets) {
+		h.buckets = append(h.buckets, 0)
+	}
+	h.buckets[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+func (h *Histogram) Merge(other *Histogram) {
+	other.mu.Lock()
+	buckets := make([]int64, len(other.buckets))
+	copy(buckets, other.buckets)
+	count, sum, max := other.count, other.sum, other.max
+	other.mu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, n := range buckets {
+		for i >= len(h.buckets) {
+			h.buckets = append(h.buckets, 0)
+		}
+		h.buckets[i] += n
+	}
+	h.count += count
+	h.sum += sum
+	if max > h.max {
+		h.max = max
+	}
+}
+
+func (h *Histogram) Percentile(p float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(p / 100 * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i, n := range h.buckets {
+		seen += n
+		if seen >= rank {
+			if d := time.Duration(upperBound(i)); d < h.max {
+				return d
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+func (h *Histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) Mean() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+func (h *Histogram) Max() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.max
+}
+
+func (h *Histogram) Summary() string {
+	return fmt.Sprintf("n=%d mean=%v p50=%v p90=%v p99=%v p999=%v max=%v", h.Count(), h.Mean(),
+		h.Percentile(50), h.Percentile(90), h.Percentile(99), h.Percentile(99.9), h.Max())
+}
@@ -0,0 +1,69 @@
+package histogram
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestPercentiles(t *testing.T) {
+	fmt.Println("Test: Histogram - Percentiles")
+
+	h := MakeHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	expected := map[float64]time.Duration{
+		50:   500 * time.Millisecond,
+		90:   900 * time.Millisecond,
+		99:   990 * time.Millisecond,
+		99.9: 999 * time.Millisecond,
+		100:  1000 * time.Millisecond}
+
+	for p, d := range expected {
+		got := h.Percentile(p)
+		if got < d || got > d+d*2/SUBBUCKETS {
+			t.Fatalf("p%v is %v (expected %v)!", p, got, d)
+		}
+	}
+
+	if h.Count() != 1000 || h.Max() != time.Second || h.Mean() != 500500*time.Microsecond {
+		t.Fatal("Invalid count, max or mean!")
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	fmt.Println("Test: Histogram - Buckets")
+
+	// Every value falls into the bucket whose upper bound is the first one at or above it
+	for v := uint64(0); v < 1<<20; v++ {
+		i := bucketOf(v)
+		if upperBound(i) < v || (i > 0 && upperBound(i-1) >= v) {
+			t.Fatalf("Value %d is in the wrong bucket (%d)!", v, i)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	fmt.Println("Test: Histogram - Merge")
+
+	a := MakeHistogram()
+	b := MakeHistogram()
+	for i := 0; i < 99; i++ {
+		a.Record(time.Millisecond)
+	}
+	b.Record(time.Minute)
+	a.Merge(b)
+
+	if a.Count() != 100 || a.Percentile(99) > 2*time.Millisecond || a.Percentile(100) != time.Minute {
+		t.Fatal("Merged histogram has invalid percentiles!")
+	}
+
+	if MakeHistogram().Percentile(50) != 0 {
+		t.Fatal("Empty histogram has a non-zero percentile!")
+	}
+}
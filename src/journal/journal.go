@@ -0,0 +1,126 @@
+package journal
+
+// Bounded in-memory log of the significant transitions of a server, for assertions of tests
+//
+// j := MakeJournal(capacity)            - Journal keeping the last capacity entries
+// j.Record(now, kind, view, seqNum)     - Appends an entry, i.e. EXECUTED of a sequence number
+// j.Entries()                           - Entries kept, oldest first
+// j.Filter(kind)                        - Entries kept of a kind, oldest first
+// j.Count(kind)                         - Entries of a kind ever recorded, dropped ones included
+// j.Dropped()                           - Entries dropped to keep the journal bounded
+//
+// => Servers of both protocols keep a journal of SIZE entries (see Journal() of xpaxos and pbft):
+//    XPaxos records the view changes it starts (on a suspicion) and completes (on a new view),
+//    and both protocols record the checkpoints they take or adopt, the checkpoints that become
+//    stable and the requests they execute; XPaxos also records the key changes it applies (see
+//    xpaxos/rotation.go)
+// => Entries carry the time of the server's clock (virtual in tests that drive one), the view of
+//    the server and the sequence number they are about (0 for view changes)
+// => Once full, the journal drops its oldest entries, but Count() keeps counting them, so that
+//    tests of long runs can still assert i.e. "exactly one view change completed"
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const SIZE = 1024 // Entries kept by the journal of a server
+
+const ( // Kinds of entries
+	VIEWCHANGESTARTED = iota
+	VIEWCHANGED       = iota
+	CHECKPOINTED      = iota
+	STABLE            = iota
+	EXECUTED          = iota
+	KEYCHANGED        = iota
+)
+
+var kindNames = []string{"view-change-started", "view-changed", "checkpointed", "stable", "executed",
+	"key-changed"}
+
+type Entry struct {
+	Time   time.Time
+	Kind   int
+	View   int
+	SeqNum int
+}
+
+type Journal struct {
+	mu      sync.Mutex
+	entries []Entry // Ring of the last len(entries) entries
+	next    int     // Index of the next entry in the ring
+	total   int     // Entries ever recorded
+	counts  map[int]int
+}
+
+func MakeJournal(capacity int) *Journal {
+	j := &Journal{}
+	j.entries = make([]Entry, 0, capacity)
+	j.counts = make(map[int]int)
+	return j
+}
+
+func (j *Journal) Record(now time.Time, kind int, view int, seqNum int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry := Entry{now, kind, view, seqNum}
+	if len(j.entries) < cap(j.entries) {
+		j.entries = append(j.entries, entry)
+	} else if cap(j.entries) > 0 {
+		j.entries[j.next] = entry
+	}
+	if cap(j.entries) > 0 {
+		j.next = (j.next + 1) % cap(j.entries)
+	}
+	j.total++
+	j.counts[kind]++
+}
+
+func (j *Journal) Entries() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.entries) < cap(j.entries) {
+		return append([]Entry(nil), j.entries...)
+	}
+	return append(append([]Entry(nil), j.entries[j.next:]...), j.entries[:j.next]...)
+}
+
+func (j *Journal) Filter(kind int) []Entry {
+	filtered := make([]Entry, 0)
+	for _, entry := range j.Entries() {
+		if entry.Kind == kind {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+func (j *Journal) Count(kind int) int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.counts[kind]
+}
+
+func (j *Journal) Dropped() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.total - len(j.entries)
+}
+
+func KindName(kind int) string {
+	if kind < 0 || kind >= len(kindNames) {
+		return "unknown"
+	}
+	return kindNames[kind]
+}
+
+// i.e. "15:04:05.000000 view=2 seqNum=0 view-changed"
+func (entry Entry) String() string {
+	return fmt.Sprintf("%s view=%d seqNum=%d %s", entry.Time.Format("15:04:05.000000"), entry.View, entry.SeqNum,
+		KindName(entry.Kind))
+}
@@ -0,0 +1,38 @@
+package journal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestJournal(t *testing.T) {
+	fmt.Println("Test: Journal - Bounded Log of Transitions")
+
+	j := MakeJournal(4)
+	start := time.Now()
+	j.Record(start, VIEWCHANGESTARTED, 2, 0)
+	j.Record(start.Add(time.Millisecond), VIEWCHANGED, 2, 0)
+	for seqNum := 1; seqNum <= 5; seqNum++ {
+		j.Record(start.Add(time.Duration(1+seqNum)*time.Millisecond), EXECUTED, 2, seqNum)
+	}
+
+	entries := j.Entries()
+	if len(entries) != 4 || j.Dropped() != 3 {
+		t.Fatalf("%d entries kept and %d dropped instead of 4 and 3!", len(entries), j.Dropped())
+	}
+	for i, entry := range entries {
+		if entry.Kind != EXECUTED || entry.SeqNum != i+2 {
+			t.Fatalf("Entry %d is %s instead of seqNum=%d executed!", i, entry, i+2)
+		}
+	}
+	if j.Count(VIEWCHANGED) != 1 || len(j.Filter(VIEWCHANGED)) != 0 || j.Count(EXECUTED) != 5 {
+		t.Fatal("Counts of the journal lost dropped entries!")
+	}
+	if KindName(STABLE) != "stable" || KindName(-1) != "unknown" {
+		t.Fatal("Invalid kind names!")
+	}
+}
@@ -0,0 +1,374 @@
+package kvservice
+
+// Key-value service replicated by XPaxos or PBFT
+//
+// kv := MakeKV()                - Key-value state machine (see statemachine), one per server
+// MakeKVWithStorage(store)      - Key-value state machine on a storage (see storage.go)
+// clerk := MakeClerk(client)    - Issues operations through the client of either protocol
+// clerk.Get(key)                - Value of key ("" if none) and whether the request committed
+// clerk.Put(key, value)         - Replaces the value of key
+// clerk.Append(key, value)      - Appends to the value of key
+// clerk.PutTTL(key, value, ttl) - Replaces the value of key until it expires after ttl
+// clerk.Txn(compares, writes)   - Applies writes atomically if every compared key has its value
+// clerk.Do(op)                  - Runs a workload operation (a read is a Get, a write a Put)
+// kv.Get(key)                   - Local value of key on this server (e.g. for tests)
+// kv.Keys()                     - Local keys on this server in order
+// kv.Watch(keys...)             - Notifications of the writes this server applies (see watch.go)
+// kv.Err()                      - First error of the storage or a snapshot (nil if none)
+//
+// => The service has the same semantics on both protocols: every operation (reads included) is
+//    committed like any other request and applied in log order, so a clerk sees linearizable
+//    results whichever protocol replicates the service
+// => Put and Append return the new value and Get the current one, so all results are compared
+//    the same way by a PBFT client (see pbft/client.go)
+// => Operations are encoded by the clerk, so they travel as byte slices in client requests
+// => Through a client that serves reads without committing them (statemachine.ReadConsensus,
+//    i.e. XPaxos read-index reads), Get reads the leader's store and only goes through the log if
+//    the read was not served; the result is the same, so the service keeps its semantics
+// => A transaction (TXN) is a single request: every server checks its conditions against its store
+//    when it applies the request, in log order, so all servers detect the same conflicts and
+//    either apply all of its writes or none; its result is the encoded TxnResult
+// => Keys with a TTL expire deterministically: every operation is applied at the time the leader
+//    (XPaxos) or primary (PBFT) assigned to its log entry (see statemachine.ApplyAt()), never at a
+//    time of the client, the store's clock is the latest time of any applied operation, and
+//    ApplyAt() first deletes the keys whose expiry is not after the clock, so every server expires
+//    the same keys at the same point of the log (reads see a key until an operation past its
+//    expiry is applied); PUT with a TTL of 0 clears the key's expiry and APPEND keeps it
+// => A store whose storage failed (i.e. a full disk under a FileStorage), or that was handed a
+//    snapshot it cannot decode (which it does not restore), applies no operations from then on,
+//    and Err() returns the error: it could not hold the state of the other servers, and it is up
+//    to the process to stop the server (xpaxosd does)
+// => Servers running the service should checkpoint it every CHECKPOINT operations (see
+//    SetCheckpointInterval() of xpaxos and pbft), so that their logs keep the operations of at
+//    most that many requests and a restarted server restores the store from its last snapshot
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"github.com/csanti/cos518_project/src/workload"
+	"sort"
+	"sync"
+	"time"
+)
+
+const CHECKPOINT = 100 // Applied operations between checkpoints of the store
+
+const ( // Operation types
+	GET    = iota
+	PUT    = iota
+	APPEND = iota
+	TXN    = iota
+)
+
+type Op struct {
+	Type     int
+	Key      string
+	Value    []byte
+	TTL      int64     // PUT and APPEND: time to live in nanoseconds (0 = none)
+	Compares []Compare // TXN: conditions on the values of keys
+	Writes   []Op      // TXN: PUT and APPEND operations applied if every condition holds
+}
+
+// Holds if key has value (a key without a value has the empty value)
+type Compare struct {
+	Key   string
+	Value []byte
+}
+
+type TxnResult struct {
+	Succeeded bool     // Whether every condition held and the writes were applied
+	Values    [][]byte // Value of each compared key when the transaction was applied
+}
+
+type KV struct {
+	mu       sync.Mutex
+	store    Storage           // Keys and values (see storage.go)
+	expiry   map[string]int64  // Key -> time it expires at (keys with a TTL only)
+	now      int64             // Latest time of any applied operation (see ApplyAt())
+	watchers map[*Watcher]bool // Active watches (see watch.go)
+	err      error             // First error of the storage or a snapshot (see kv.Err())
+}
+
+type snapshot struct {
+	Data   map[string][]byte
+	Expiry map[string]int64
+	Now    int64
+}
+
+var _ statemachine.StateMachine = &KV{}
+var _ statemachine.TimedStateMachine = &KV{}
+var _ statemachine.Reader = &KV{}
+
+type Clerk struct {
+	client statemachine.Consensus
+}
+
+//
+// ------------------------------- STATE MACHINE ------------------------------
+//
+func MakeKV() *KV {
+	return MakeKVWithStorage(MakeMemStorage())
+}
+
+func MakeKVWithStorage(store Storage) *KV {
+	kv := &KV{}
+	kv.store = store
+	kv.expiry = make(map[string]int64)
+	kv.watchers = make(map[*Watcher]bool)
+	return kv
+}
+
+// Applies the operation without a time, so the store's clock does not move
+func (kv *KV) Apply(data []byte) []byte {
+	return kv.ApplyAt(data, 0)
+}
+
+// Applies the operation at time now of its log entry
+func (kv *KV) ApplyAt(data []byte, now int64) []byte {
+	op := Op{}
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&op); err != nil {
+		return nil // Not an operation of the service (i.e. a null operation)
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.err != nil {
+		return nil
+	}
+	if now > kv.now {
+		kv.now = now
+	}
+	kv.expire()
+
+	switch op.Type {
+	case PUT, APPEND:
+		kv.write(op)
+	case TXN:
+		return kv.transact(op)
+	}
+	return kv.get(op.Key)
+}
+
+// Value of key in the storage (nil if none); must be called with kv.mu held
+func (kv *KV) get(key string) []byte {
+	value, _, err := kv.store.Get(key)
+	kv.fail(err)
+	return value
+}
+
+// Keeps the first error of the storage (or of a snapshot); must be called with kv.mu held
+func (kv *KV) fail(err error) {
+	if err != nil && kv.err == nil {
+		kv.err = err
+	}
+}
+
+func (kv *KV) Err() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	return kv.err
+}
+
+// Must be called with kv.mu held
+func (kv *KV) write(op Op) {
+	switch op.Type {
+	case PUT:
+		kv.fail(kv.store.Put(op.Key, append([]byte(nil), op.Value...)))
+		delete(kv.expiry, op.Key)
+	case APPEND:
+		kv.fail(kv.store.Put(op.Key, append(kv.get(op.Key), op.Value...)))
+	default:
+		return
+	}
+	if op.TTL > 0 {
+		kv.expiry[op.Key] = kv.now + op.TTL
+	}
+	kv.notify(op.Type, op.Key)
+}
+
+// Delete the keys that expired by the store's clock, in order; must be called with kv.mu held
+func (kv *KV) expire() {
+	expired := make([]string, 0)
+	for key, expiry := range kv.expiry {
+		if expiry <= kv.now {
+			expired = append(expired, key)
+		}
+	}
+	sort.Strings(expired)
+
+	for _, key := range expired {
+		kv.fail(kv.store.Delete(key))
+		delete(kv.expiry, key)
+		kv.notify(EXPIRE, key)
+	}
+}
+
+// Must be called with kv.mu held
+func (kv *KV) transact(op Op) []byte {
+	result := TxnResult{Succeeded: true}
+	for _, compare := range op.Compares {
+		value := kv.get(compare.Key)
+		result.Values = append(result.Values, value)
+		if bytes.Equal(value, compare.Value) == false {
+			result.Succeeded = false
+		}
+	}
+
+	if result.Succeeded == true {
+		for _, write := range op.Writes {
+			kv.write(write)
+		}
+	}
+	return statemachine.Encode(result)
+}
+
+// Value of a GET operation (nil for other operations)
+func (kv *KV) Read(data []byte) []byte {
+	op := Op{}
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&op); err != nil || op.Type != GET {
+		return nil
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	return kv.get(op.Key)
+}
+
+func (kv *KV) Snapshot() []byte {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(snapshot{kv.contents(), kv.expiry, kv.now})
+	return buf.Bytes()
+}
+
+// Must be called with kv.mu held
+func (kv *KV) contents() map[string][]byte {
+	data := make(map[string][]byte)
+	for _, key := range kv.store.Keys() {
+		data[key] = kv.get(key)
+	}
+	return data
+}
+
+// Clock and keys in order with their values and expiries
+func (kv *KV) Hash() [32]byte {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	hasher := statemachine.MakeHasher().WriteInt(kv.now)
+	for _, key := range kv.store.Keys() {
+		value := kv.get(key)
+		hasher.WriteString(key).Write(value).WriteInt(kv.expiry[key])
+	}
+	return hasher.Sum()
+}
+
+// Store in memory whatever the storage of kv, which the hash does not depend on, and without its
+// watchers
+func (kv *KV) Scratch() statemachine.StateMachine {
+	return MakeKV()
+}
+
+func (kv *KV) Restore(data []byte) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	restored := snapshot{}
+	if len(data) > 0 {
+		if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&restored); err != nil {
+			kv.fail(err) // Keeps the previous state
+			return
+		}
+	}
+
+	var old map[string][]byte
+	if len(kv.watchers) > 0 {
+		old = kv.contents()
+	}
+	kv.fail(kv.store.Reset())
+	for key, value := range restored.Data {
+		kv.fail(kv.store.Put(key, value))
+	}
+	kv.expiry = make(map[string]int64)
+	for key, expiry := range restored.Expiry {
+		kv.expiry[key] = expiry
+	}
+	kv.now = restored.Now
+	kv.notifyRestore(old)
+}
+
+func (kv *KV) Get(key string) string {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	return string(kv.get(key))
+}
+
+func (kv *KV) Keys() []string {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	return kv.store.Keys()
+}
+
+//
+// ----------------------------------- CLERK ----------------------------------
+//
+func MakeClerk(client statemachine.Consensus) *Clerk {
+	clerk := &Clerk{}
+	clerk.client = client
+	return clerk
+}
+
+func (clerk *Clerk) execute(op Op) (string, bool) {
+	result, ok := clerk.client.Execute(statemachine.Encode(op))
+	return string(result), ok
+}
+
+func (clerk *Clerk) Get(key string) (string, bool) {
+	if reader, ok := clerk.client.(statemachine.ReadConsensus); ok {
+		if result, ok := reader.Read(statemachine.Encode(Op{Type: GET, Key: key})); ok {
+			return string(result), true
+		}
+	}
+	return clerk.execute(Op{Type: GET, Key: key})
+}
+
+func (clerk *Clerk) Put(key string, value string) (string, bool) {
+	return clerk.execute(Op{Type: PUT, Key: key, Value: []byte(value)})
+}
+
+func (clerk *Clerk) PutTTL(key string, value string, ttl time.Duration) (string, bool) {
+	return clerk.execute(Op{Type: PUT, Key: key, Value: []byte(value), TTL: int64(ttl)})
+}
+
+func (clerk *Clerk) Append(key string, value string) (string, bool) {
+	return clerk.execute(Op{Type: APPEND, Key: key, Value: []byte(value)})
+}
+
+// Returns the result of the transaction and whether it committed
+func (clerk *Clerk) Txn(compares []Compare, writes []Op) (TxnResult, bool) {
+	result := TxnResult{}
+	op := Op{Type: TXN, Compares: compares, Writes: writes}
+	data, ok := clerk.client.Execute(statemachine.Encode(op))
+	if ok == false || gob.NewDecoder(bytes.NewBuffer(data)).Decode(&result) != nil {
+		return TxnResult{}, false
+	}
+	return result, true
+}
+
+// Returns whether the operation committed
+func (clerk *Clerk) Do(op workload.Op) bool {
+	if op.Read == true {
+		_, ok := clerk.Get(op.Key)
+		return ok
+	}
+	_, ok := clerk.execute(Op{Type: PUT, Key: op.Key, Value: op.Value})
+	return ok
+}
@@ -0,0 +1,345 @@
+package kvservice
+
+// Storage of the key-value pairs of the store
+//
+// MakeMemStorage()             - Keys and values in a map (the default of MakeKV())
+// MakeFileStorage(path, sync)  - Values in an append-only file, keys and offsets in memory
+// MakeKVWithStorage(store)     - Key-value state machine on a storage
+// fs.Size()                    - Size of the file in bytes (i.e. for experiments)
+// fs.Close()                   - Closes the file
+//
+// => A storage holds the current values only: the clock and the expiries of keys (see TTLs) are
+//    kept by the store, and Snapshot()/Restore() of the store read and replace the whole storage
+// => FileStorage appends every write to its file (a record of the key and its value or a deletion)
+//    and keeps the offset of every value in memory, so state larger than memory can be stored;
+//    with sync, it also waits for every write to reach the disk, like a durable store would
+// => MakeFileStorage() recovers the values of an existing file by reading its records in order,
+//    so recovery time grows with the size of the file (the protocols restore a restarted server's
+//    store from its checkpoint anyway, which replaces the contents of its storage)
+// => The file is rewritten with the current values only once stale records take more space than
+//    current ones (and at least COMPACTSIZE bytes)
+// => A storage is only used with the store's lock held; I/O errors of a FileStorage are returned
+//    to the store, which stops applying operations after the first one, since a server cannot
+//    apply an operation it cannot store (see kv.Err())
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const COMPACTSIZE = 1 << 20 // Stale bytes in the file of a FileStorage before it is rewritten
+
+const ( // Record types of a FileStorage
+	recordPut    = 0
+	recordDelete = 1
+)
+
+type Storage interface {
+	Get(key string) ([]byte, bool, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	Keys() []string // In order
+	Reset() error   // Deletes every key
+}
+
+type MemStorage struct {
+	data map[string][]byte
+}
+
+type FileStorage struct {
+	path  string
+	sync  bool
+	file  *os.File
+	index map[string]location // Key -> location of its value in the file
+	size  int64               // Bytes in the file
+	stale int64               // Bytes of records of overwritten or deleted values
+}
+
+type location struct {
+	offset int64 // Of the value
+	length int
+	record int64 // Bytes of the whole record
+}
+
+var _ Storage = &MemStorage{}
+var _ Storage = &FileStorage{}
+
+//
+// ------------------------------- MEMORY STORAGE -----------------------------
+//
+func MakeMemStorage() *MemStorage {
+	ms := &MemStorage{}
+	ms.data = make(map[string][]byte)
+	return ms
+}
+
+func (ms *MemStorage) Get(key string) ([]byte, bool, error) {
+	value, ok := ms.data[key]
+	return value, ok, nil
+}
+
+func (ms *MemStorage) Put(key string, value []byte) error {
+	ms.data[key] = value
+	return nil
+}
+
+func (ms *MemStorage) Delete(key string) error {
+	delete(ms.data, key)
+	return nil
+}
+
+func (ms *MemStorage) Keys() []string {
+	keys := make([]string, 0, len(ms.data))
+	for key := range ms.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (ms *MemStorage) Reset() error {
+	ms.data = make(map[string][]byte)
+	return nil
+}
+
+//
+// -------------------------------- FILE STORAGE ------------------------------
+//
+func MakeFileStorage(path string, sync bool) (*FileStorage, error) {
+	fs := &FileStorage{}
+	fs.path = path
+	fs.sync = sync
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Open the file and recover the locations of its values
+func (fs *FileStorage) open() error {
+	file, err := os.OpenFile(fs.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	fs.file = file
+	fs.index = make(map[string]location)
+	fs.size = 0
+	fs.stale = 0
+
+	reader := bufio.NewReader(file)
+	for {
+		recordType, key, value, length, err := readRecord(reader)
+		if err != nil {
+			break // The end of the file, or a record cut short by a crash (dropped below)
+		}
+
+		fs.forget(key)
+		if recordType == recordPut {
+			fs.index[key] = location{fs.size + length - int64(len(value)), len(value), length}
+		} else {
+			fs.stale += length
+		}
+		fs.size += length
+	}
+
+	if err := file.Truncate(fs.size); err != nil {
+		file.Close()
+		return err
+	}
+	_, err = file.Seek(fs.size, io.SeekStart)
+	return err
+}
+
+func readRecord(reader *bufio.Reader) (int, string, []byte, int64, error) {
+	recordType, err := reader.ReadByte()
+	if err != nil {
+		return 0, "", nil, 0, err
+	}
+	keyLength, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return 0, "", nil, 0, io.ErrUnexpectedEOF
+	}
+	key := make([]byte, keyLength)
+	if _, err := io.ReadFull(reader, key); err != nil {
+		return 0, "", nil, 0, io.ErrUnexpectedEOF
+	}
+	valueLength, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return 0, "", nil, 0, io.ErrUnexpectedEOF
+	}
+	value := make([]byte, valueLength)
+	if _, err := io.ReadFull(reader, value); err != nil {
+		return 0, "", nil, 0, io.ErrUnexpectedEOF
+	}
+
+	length := 1 + uvarintSize(keyLength) + int64(keyLength) + uvarintSize(valueLength) + int64(valueLength)
+	return int(recordType), string(key), value, length, nil
+}
+
+func uvarintSize(x uint64) int64 {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return int64(binary.PutUvarint(buf, x))
+}
+
+// Append a record to the file and return the location of its value
+func (fs *FileStorage) append(recordType int, key string, value []byte) (location, error) {
+	record := []byte{byte(recordType)}
+	record = binary.AppendUvarint(record, uint64(len(key)))
+	record = append(record, key...)
+	record = binary.AppendUvarint(record, uint64(len(value)))
+	record = append(record, value...)
+
+	if n, err := fs.file.Write(record); err != nil {
+		fs.size += int64(n) // A partial record, dropped on recovery
+		fs.stale += int64(n)
+		return location{}, fmt.Errorf("FileStorage: write %s: %w", fs.path, err)
+	}
+	loc := location{fs.size + int64(len(record)-len(value)), len(value), int64(len(record))}
+	fs.size += int64(len(record))
+
+	if fs.sync == true {
+		if err := fs.file.Sync(); err != nil {
+			return loc, fmt.Errorf("FileStorage: sync %s: %w", fs.path, err)
+		}
+	}
+	return loc, nil
+}
+
+// The record of key's value becomes stale
+func (fs *FileStorage) forget(key string) {
+	if loc, ok := fs.index[key]; ok {
+		fs.stale += loc.record
+		delete(fs.index, key)
+	}
+}
+
+func (fs *FileStorage) Get(key string) ([]byte, bool, error) {
+	loc, ok := fs.index[key]
+	if ok == false {
+		return nil, false, nil
+	}
+
+	value := make([]byte, loc.length)
+	if _, err := fs.file.ReadAt(value, loc.offset); err != nil {
+		return nil, false, fmt.Errorf("FileStorage: read %s: %w", fs.path, err)
+	}
+	return value, true, nil
+}
+
+// The key keeps its old value if the record of the new one could not be written
+func (fs *FileStorage) Put(key string, value []byte) error {
+	loc, err := fs.append(recordPut, key, value)
+	if err != nil && loc.record == 0 {
+		return err
+	}
+	fs.forget(key)
+	fs.index[key] = loc
+	if err != nil {
+		return err
+	}
+	return fs.compact()
+}
+
+func (fs *FileStorage) Delete(key string) error {
+	if _, ok := fs.index[key]; ok == false {
+		return nil
+	}
+	loc, err := fs.append(recordDelete, key, nil)
+	if err != nil && loc.record == 0 {
+		return err
+	}
+	fs.forget(key)
+	fs.stale += loc.record
+	if err != nil {
+		return err
+	}
+	return fs.compact()
+}
+
+func (fs *FileStorage) Keys() []string {
+	keys := make([]string, 0, len(fs.index))
+	for key := range fs.index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (fs *FileStorage) Reset() error {
+	if err := fs.file.Truncate(0); err != nil {
+		return fmt.Errorf("FileStorage: truncate %s: %w", fs.path, err)
+	}
+	fs.index = make(map[string]location)
+	fs.size = 0
+	fs.stale = 0
+	if _, err := fs.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("FileStorage: seek %s: %w", fs.path, err)
+	}
+	return nil
+}
+
+// Rewrite the file with the current values once stale records take more space than them; the
+// storage keeps its file if the rewritten one could not be written
+func (fs *FileStorage) compact() error {
+	if fs.stale < COMPACTSIZE || fs.stale < fs.size-fs.stale {
+		return nil
+	}
+
+	compacted, err := fs.rewrite(fs.path + ".compact")
+	if err != nil {
+		os.Remove(fs.path + ".compact")
+		return fmt.Errorf("FileStorage: compact %s: %w", fs.path, err)
+	}
+
+	if err := os.Rename(compacted.path, fs.path); err != nil {
+		compacted.file.Close()
+		os.Remove(compacted.path)
+		return fmt.Errorf("FileStorage: compact %s: %w", fs.path, err)
+	}
+	fs.file.Close()
+	fs.file = compacted.file // Renamed, and at the end of the file for the next appends
+	fs.index = compacted.index
+	fs.size = compacted.size
+	fs.stale = 0
+	return nil
+}
+
+// Storage in a new file at path holding the current values only, synced to the disk
+func (fs *FileStorage) rewrite(path string) (*FileStorage, error) {
+	compacted, err := MakeFileStorage(path, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := compacted.Reset(); err != nil {
+		compacted.file.Close()
+		return nil, err
+	}
+	for _, key := range fs.Keys() {
+		value, _, err := fs.Get(key)
+		if err == nil {
+			compacted.index[key], err = compacted.append(recordPut, key, value)
+		}
+		if err != nil {
+			compacted.file.Close()
+			return nil, err
+		}
+	}
+	if err := compacted.file.Sync(); err != nil {
+		compacted.file.Close()
+		return nil, err
+	}
+	return compacted, nil
+}
+
+func (fs *FileStorage) Size() int64 {
+	return fs.size
+}
+
+func (fs *FileStorage) Close() error {
+	return fs.file.Close()
+}
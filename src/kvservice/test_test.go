@@ -0,0 +1,376 @@
+package kvservice
+
+import (
+	"fmt"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"github.com/csanti/cos518_project/src/workload"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Serves reads from the state machine without applying them, unless serve is false
+type reading struct {
+	*statemachine.Local
+	sm    statemachine.StateMachine
+	serve bool
+	reads int
+}
+
+func (r *reading) Read(op interface{}) ([]byte, bool) {
+	r.reads++
+	if r.serve == false {
+		return nil, false
+	}
+	return r.sm.(statemachine.Reader).Read(statemachine.Encode(op)), true
+}
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestKV(t *testing.T) {
+	fmt.Println("Test: Key-Value Service - Get, Put and Append")
+
+	kv := MakeKV()
+	clerk := MakeClerk(statemachine.MakeLocal(kv))
+
+	if value, ok := clerk.Get("a"); ok == false || value != "" {
+		t.Fatalf("Expected empty value, got %q!", value)
+	}
+	if value, _ := clerk.Put("a", "x"); value != "x" {
+		t.Fatalf("Expected x after put, got %q!", value)
+	}
+	if value, _ := clerk.Append("a", "y"); value != "xy" {
+		t.Fatalf("Expected xy after append, got %q!", value)
+	}
+	if value, _ := clerk.Get("a"); value != "xy" || kv.Get("a") != "xy" {
+		t.Fatalf("Expected xy, got %q!", value)
+	}
+
+	if clerk.Do(workload.Op{Key: "b", Value: []byte("z")}) == false || kv.Get("b") != "z" {
+		t.Fatal("Workload write not applied!")
+	}
+	if kv.Apply(statemachine.Encode(nil)) != nil || kv.Apply(statemachine.Encode(7)) != nil {
+		t.Fatal("Null operation changed the state!")
+	}
+}
+
+func TestKVSnapshot(t *testing.T) {
+	fmt.Println("Test: Key-Value Service - Snapshot and Restore")
+
+	kv := MakeKV()
+	clerk := MakeClerk(statemachine.MakeLocal(kv))
+	clerk.Put("a", "1")
+	clerk.Put("b", "2")
+
+	snapshot := kv.Snapshot()
+	clerk.Put("a", "3")
+
+	restored := MakeKV()
+	restored.Restore(snapshot)
+	if restored.Get("a") != "1" || restored.Get("b") != "2" {
+		t.Fatal("Snapshot not restored!")
+	}
+
+	restored.Restore(nil)
+	if restored.Get("a") != "" {
+		t.Fatal("Empty snapshot not restored!")
+	}
+
+	// A snapshot that does not decode leaves the state as it was and fails the store
+	restored.Restore(snapshot)
+	restored.Restore(snapshot[:len(snapshot)/2])
+	if restored.Get("a") != "1" || restored.Get("b") != "2" {
+		t.Fatal("Corrupt snapshot changed the state!")
+	}
+	if restored.Err() == nil {
+		t.Fatal("Corrupt snapshot not reported!")
+	}
+}
+
+func TestKVReads(t *testing.T) {
+	fmt.Println("Test: Key-Value Service - Reads Without Log Entries")
+
+	kv := MakeKV()
+	client := &reading{Local: statemachine.MakeLocal(kv), sm: kv, serve: true}
+	clerk := MakeClerk(client)
+
+	clerk.Put("a", "x")
+	if value, ok := clerk.Get("a"); ok == false || value != "x" || client.reads != 1 {
+		t.Fatalf("Expected %q from a read, got %q after %d reads!", "x", value, client.reads)
+	}
+	if kv.Read(statemachine.Encode(Op{Type: PUT, Key: "a", Value: []byte("y")})) != nil || kv.Get("a") != "x" {
+		t.Fatal("Read applied a write!")
+	}
+
+	// Reads that are not served go through the log
+	client.serve = false
+	if value, ok := clerk.Get("a"); ok == false || value != "x" || client.reads != 2 {
+		t.Fatalf("Expected %q through the log, got %q!", "x", value)
+	}
+}
+
+func TestKVWatch(t *testing.T) {
+	fmt.Println("Test: Key-Value Service - Watches")
+
+	kv := MakeKV()
+	clerk := MakeClerk(statemachine.MakeLocal(kv))
+	all := kv.Watch()
+	a := kv.Watch("a")
+
+	clerk.Put("a", "x")
+	clerk.Put("b", "y")
+	clerk.Get("a")
+	clerk.Append("a", "z")
+
+	expected := []Event{{PUT, "a", []byte("x")}, {PUT, "b", []byte("y")}, {APPEND, "a", []byte("xz")}}
+	for _, e := range expected {
+		if event := <-all.Events(); event.Type != e.Type || event.Key != e.Key ||
+			string(event.Value) != string(e.Value) {
+			t.Fatalf("Expected %+v, got %+v!", e, event)
+		}
+	}
+	if event := <-a.Events(); event.Key != "a" || string(event.Value) != "x" {
+		t.Fatalf("Watch of a got %+v!", event)
+	}
+	if event := <-a.Events(); event.Key != "a" || string(event.Value) != "xz" {
+		t.Fatalf("Watch of a got %+v!", event)
+	}
+
+	snapshot := kv.Snapshot()
+	clerk.Put("a", "w")
+	<-a.Events()
+	kv.Restore(snapshot)
+	if event := <-a.Events(); event.Type != RESTORE || string(event.Value) != "xz" {
+		t.Fatalf("Expected the restored value of a, got %+v!", event)
+	}
+
+	a.Cancel()
+	if _, ok := <-a.Events(); ok == true || a.Lagged() == true {
+		t.Fatal("Cancelled watch still open!")
+	}
+
+	for i := 0; i <= WATCHBUFFER; i++ { // all never reads again, so it falls behind
+		clerk.Put("c", "v")
+	}
+	if all.Lagged() == false {
+		t.Fatal("Watch not cancelled after its buffer filled!")
+	}
+}
+
+func TestKVTxn(t *testing.T) {
+	fmt.Println("Test: Key-Value Service - Multi-Key Transactions")
+
+	clock := network.MakeVirtualClock() // Same operations on both replicas
+	kv := MakeKV()
+	local := statemachine.MakeLocal(kv)
+	local.SetClock(clock)
+	clerk := MakeClerk(local)
+	clerk.Put("a", "1")
+	w := kv.Watch()
+
+	// Swap a and b only if a is 1 and b has no value
+	compares := []Compare{{"a", []byte("1")}, {"b", nil}}
+	writes := []Op{{Type: PUT, Key: "a", Value: nil}, {Type: PUT, Key: "b", Value: []byte("1")}}
+	if result, ok := clerk.Txn(compares, writes); ok == false || result.Succeeded == false ||
+		kv.Get("a") != "" || kv.Get("b") != "1" {
+		t.Fatalf("Transaction not applied: %+v!", result)
+	}
+	if event := <-w.Events(); event.Key != "a" || len(event.Value) != 0 {
+		t.Fatalf("Expected the write of a, got %+v!", event)
+	}
+	if event := <-w.Events(); event.Key != "b" || string(event.Value) != "1" {
+		t.Fatalf("Expected the write of b, got %+v!", event)
+	}
+
+	// The same transaction conflicts now, and reports the values it found
+	if result, ok := clerk.Txn(compares, writes); ok == false || result.Succeeded == true ||
+		string(result.Values[0]) != "" || string(result.Values[1]) != "1" {
+		t.Fatalf("Conflicting transaction not detected: %+v!", result)
+	}
+	if kv.Get("b") != "1" || len(w.Events()) != 0 {
+		t.Fatal("Conflicting transaction applied writes!")
+	}
+
+	// Every replica applying the same transactions ends in the same state
+	other := MakeKV()
+	local = statemachine.MakeLocal(other)
+	local.SetClock(clock)
+	clerk = MakeClerk(local)
+	clerk.Put("a", "1")
+	clerk.Txn(compares, writes)
+	clerk.Txn(compares, writes)
+	if other.Hash() != kv.Hash() {
+		t.Fatal("Replicas applying the same transactions differ!")
+	}
+}
+
+func TestKVTTL(t *testing.T) {
+	fmt.Println("Test: Key-Value Service - Keys Expire at the Same Operation on Every Replica")
+
+	clock := network.MakeVirtualClock()
+	kv := MakeKV()
+	local := statemachine.MakeLocal(kv)
+	local.SetClock(clock)
+	clerk := MakeClerk(local)
+	w := kv.Watch("a")
+
+	clerk.PutTTL("a", "x", 10*time.Second)
+	clerk.Put("b", "y")
+	<-w.Events()
+
+	clock.Advance(5 * time.Second)
+	if value, _ := clerk.Append("a", "z"); value != "xz" { // Keeps the expiry
+		t.Fatalf("Expected xz before expiry, got %q!", value)
+	}
+	<-w.Events()
+	snapshot := kv.Snapshot()
+
+	clock.Advance(5 * time.Second)
+	if kv.Get("a") != "xz" {
+		t.Fatal("Key expired before an operation past its expiry was applied!")
+	}
+	if value, _ := clerk.Get("a"); value != "" || kv.Get("b") != "y" {
+		t.Fatalf("Expected a to expire, got %q!", value)
+	}
+	if event := <-w.Events(); event.Type != EXPIRE || event.Value != nil && len(event.Value) != 0 {
+		t.Fatalf("Expected the expiry of a, got %+v!", event)
+	}
+
+	// A replica restored from the snapshot expires the key at the same operation
+	restored := MakeKV()
+	restored.Restore(snapshot)
+	if restored.Get("a") != "xz" {
+		t.Fatal("Expiring key not restored!")
+	}
+	restored.ApplyAt(statemachine.Encode(Op{Type: GET, Key: "a"}), clock.Now().UnixNano())
+	if restored.Hash() != kv.Hash() {
+		t.Fatal("Restored replica did not expire the same keys!")
+	}
+
+	// An operation with an earlier time does not turn the clock back
+	clerk.PutTTL("c", "v", time.Second)
+	kv.ApplyAt(statemachine.Encode(Op{Type: GET, Key: "c"}), 0)
+	if kv.Get("c") != "v" {
+		t.Fatal("Operation with an earlier time moved the clock!")
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	fmt.Println("Test: Key-Value Service - File Storage Recovery and Compaction")
+
+	path := filepath.Join(t.TempDir(), "kv")
+	fs, err := MakeFileStorage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Put("a", []byte("1"))
+	fs.Put("b", []byte("2"))
+	fs.Put("a", []byte("3"))
+	fs.Delete("b")
+	fs.Put("c", nil)
+	fs.Close()
+
+	// A record cut short by a crash is dropped on recovery
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.Write([]byte{recordPut, 1, 'd', 10, 'x'})
+	file.Close()
+
+	fs, err = MakeFileStorage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if value, ok, err := fs.Get("a"); err != nil || ok == false || string(value) != "3" {
+		t.Fatalf("Expected 3, recovered %q!", value)
+	}
+	if _, ok, _ := fs.Get("b"); ok == true {
+		t.Fatal("Deleted key recovered!")
+	}
+	if keys := fs.Keys(); len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Fatalf("Recovered keys %v!", keys)
+	}
+
+	// Overwriting the same keys keeps the file bounded
+	value := make([]byte, 4096)
+	for i := 0; i < 4*COMPACTSIZE/len(value); i++ {
+		if err := fs.Put(strconv.Itoa(i%10), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fs.Size() > 2*COMPACTSIZE+int64(20*len(value)) {
+		t.Fatalf("File of %d bytes not compacted!", fs.Size())
+	}
+	if got, ok, _ := fs.Get("a"); ok == false || string(got) != "3" || len(fs.Keys()) != 12 {
+		t.Fatal("Compaction lost values!")
+	}
+}
+
+// I/O errors of the file are returned instead of failing the process, and stop the store
+func TestFileStorageErrors(t *testing.T) {
+	fmt.Println("Test: Key-Value Service - File Storage Errors")
+
+	fs, err := MakeFileStorage(filepath.Join(t.TempDir(), "kv"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv := MakeKVWithStorage(fs)
+	clerk := MakeClerk(statemachine.MakeLocal(kv))
+	clerk.Put("a", "x")
+	fs.Close() // Every read and write of the file fails from now on
+
+	if err := fs.Put("b", []byte("y")); err == nil {
+		t.Fatal("Write to a closed file succeeded!")
+	}
+	if _, _, err := fs.Get("a"); err == nil {
+		t.Fatal("Read of a closed file succeeded!")
+	}
+	if keys := fs.Keys(); len(keys) != 1 || keys[0] != "a" {
+		t.Fatalf("Failed write changed the keys (%v)!", keys)
+	}
+
+	if kv.Err() != nil {
+		t.Fatalf("Store failed before using the closed file (%v)!", kv.Err())
+	}
+	clerk.Append("a", "y")
+	if kv.Err() == nil {
+		t.Fatal("Store did not keep the error of its storage!")
+	}
+	if result, _ := clerk.Put("c", "z"); result != "" {
+		t.Fatal("Store applied an operation after its storage failed!")
+	}
+}
+
+func TestKVStorage(t *testing.T) {
+	fmt.Println("Test: Key-Value Service - Same State on Memory and File Storage")
+
+	fs, err := MakeFileStorage(filepath.Join(t.TempDir(), "kv"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	clock := network.MakeVirtualClock()
+	stores := []*KV{MakeKV(), MakeKVWithStorage(fs)}
+	for _, kv := range stores {
+		local := statemachine.MakeLocal(kv)
+		local.SetClock(clock)
+		clerk := MakeClerk(local)
+		clerk.Put("a", "x")
+		clerk.Append("a", "y")
+		clerk.PutTTL("b", "z", time.Second)
+		clerk.Txn([]Compare{{"a", []byte("xy")}}, []Op{{Type: APPEND, Key: "c", Value: []byte("w")}})
+	}
+	if stores[0].Hash() != stores[1].Hash() || stores[1].Get("a") != "xy" || stores[1].Get("c") != "w" {
+		t.Fatal("Stores on memory and file storage differ!")
+	}
+
+	restored := MakeKVWithStorage(fs)
+	restored.Restore(stores[0].Snapshot())
+	if restored.Hash() != stores[0].Hash() {
+		t.Fatal("Snapshot not restored to file storage!")
+	}
+}
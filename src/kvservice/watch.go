@@ -0,0 +1,131 @@
+package kvservice
+
+// Watches: push-style notifications of the writes a server applies to the store
+//
+// w := kv.Watch(keys...)     - Watches keys (every key if none) on this server
+// w.Events()                 - Channel of the watched writes, in the order they were applied
+// w.Cancel()                 - Stops the watch and closes its channel
+// w.Lagged()                 - Whether the watch was cancelled because its consumer fell behind
+//
+// => Events come from the apply stream, so they follow the committed order of the log and every
+//    server delivers the same events for the same operations; a consumer may watch any server
+// => Reads (GET) do not change the store and are not delivered; writes of a transaction (TXN) are
+//    delivered one by one if it succeeded, and keys that expired (see TTLs) as EXPIRE events
+// => Restoring a snapshot (i.e. a restarted or lagging server adopting a checkpoint) delivers a
+//    RESTORE event with the restored value of every watched key it changed, in key order
+// => Apply() runs with the server's lock held, so it never waits for a consumer: a watch whose
+//    buffer of WATCHBUFFER events is full is cancelled and marked as lagged, and its consumer
+//    should read the current value and watch again
+
+import (
+	"bytes"
+	"sort"
+)
+
+const WATCHBUFFER = 64 // Undelivered events before a watch is cancelled
+
+const ( // Event types besides PUT and APPEND
+	RESTORE = TXN + 1 + iota // The key's value changed by restoring a snapshot
+	EXPIRE                   // The key expired (and has no value)
+)
+
+type Event struct {
+	Type  int // PUT, APPEND, RESTORE or EXPIRE
+	Key   string
+	Value []byte // Value of the key after the write
+}
+
+type Watcher struct {
+	kv     *KV
+	keys   map[string]bool // Watched keys (nil for every key)
+	events chan Event
+	lagged bool
+}
+
+func (kv *KV) Watch(keys ...string) *Watcher {
+	w := &Watcher{}
+	w.kv = kv
+	if len(keys) > 0 {
+		w.keys = make(map[string]bool, len(keys))
+		for _, key := range keys {
+			w.keys[key] = true
+		}
+	}
+	w.events = make(chan Event, WATCHBUFFER)
+
+	kv.mu.Lock()
+	kv.watchers[w] = true
+	kv.mu.Unlock()
+	return w
+}
+
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+func (w *Watcher) Cancel() {
+	w.kv.mu.Lock()
+	defer w.kv.mu.Unlock()
+
+	w.kv.unwatch(w)
+}
+
+func (w *Watcher) Lagged() bool {
+	w.kv.mu.Lock()
+	defer w.kv.mu.Unlock()
+
+	return w.lagged
+}
+
+// Must be called with kv.mu held
+func (kv *KV) unwatch(w *Watcher) {
+	if kv.watchers[w] == true {
+		delete(kv.watchers, w)
+		close(w.events)
+	}
+}
+
+// Deliver an event to the watches of its key; must be called with kv.mu held
+func (kv *KV) notify(eventType int, key string) {
+	value := kv.get(key)
+	for w, _ := range kv.watchers {
+		if w.keys != nil && w.keys[key] == false {
+			continue
+		}
+
+		event := Event{
+			Type:  eventType,
+			Key:   key,
+			Value: append([]byte(nil), value...)}
+		select {
+		case w.events <- event:
+		default:
+			w.lagged = true
+			kv.unwatch(w)
+		}
+	}
+}
+
+// Deliver the keys a restored snapshot changed; must be called with kv.mu held
+func (kv *KV) notifyRestore(old map[string][]byte) {
+	if len(kv.watchers) == 0 {
+		return
+	}
+
+	changed := make([]string, 0)
+	for key, value := range old {
+		if current, ok, err := kv.store.Get(key); err != nil || ok == false || bytes.Equal(current, value) == false {
+			changed = append(changed, key)
+		}
+	}
+	for _, key := range kv.store.Keys() {
+		if _, ok := old[key]; ok == false {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+
+	for _, key := range changed {
+		kv.notify(RESTORE, key)
+	}
+}
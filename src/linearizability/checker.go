@@ -0,0 +1,202 @@
+package linearizability
+
+// Linearizability checker for operation histories
+//
+// CheckOperations(model, history) - Whether the history is linearizable with respect to the model
+//
+// => Implements the Wing & Gong search with Lowe's memoization (as in Porcupine): operations
+//    are linearized in call order as long as the model accepts their output, and the search
+//    backtracks whenever it reaches the response of an operation it has not linearized yet
+// => Configurations (set of linearized operations, model state) already explored are cached,
+//    which keeps the search fast for the mostly sequential histories produced by the tests
+// => Worst case running time is exponential in the number of concurrent operations
+
+import (
+	"sort"
+)
+
+type entry struct {
+	id         int  // Index of the operation in the history
+	call       bool // Invocation (true) or response (false)
+	time       int64
+	match      *entry // Response entry of an invocation
+	prev, next *entry
+}
+
+type frame struct {
+	entry *entry
+	state interface{} // Model state before the operation was linearized
+}
+
+type cacheEntry struct {
+	linearized bitset
+	state      interface{}
+}
+
+func CheckOperations(model Model, history []Operation) bool {
+	partitions := [][]Operation{history}
+	if model.Partition != nil {
+		partitions = model.Partition(history)
+	}
+
+	for _, partition := range partitions {
+		if !checkSingle(model, partition) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func checkSingle(model Model, history []Operation) bool {
+	head := makeEntries(history)
+	equal := model.Equal
+	if equal == nil {
+		equal = func(state1, state2 interface{}) bool { return state1 == state2 }
+	}
+
+	state := model.Init()
+	linearized := makeBitset(len(history))
+	cache := make(map[uint64][]cacheEntry)
+	calls := make([]frame, 0)
+
+	e := head.next
+	for head.next != nil {
+		if e.call {
+			op := history[e.id]
+			if ok, newState := model.Step(state, op.Input, op.Output); ok {
+				newLinearized := linearized.clone().set(e.id)
+				if !cacheContains(cache, equal, newLinearized, newState) {
+					hash := newLinearized.hash()
+					cache[hash] = append(cache[hash], cacheEntry{newLinearized, newState})
+					calls = append(calls, frame{e, state})
+					state = newState
+					linearized.set(e.id)
+					lift(e)
+					e = head.next
+					continue
+				}
+			}
+			e = e.next
+		} else { // Reached a response before linearizing its operation: backtrack
+			if len(calls) == 0 {
+				return false
+			}
+			top := calls[len(calls)-1]
+			calls = calls[:len(calls)-1]
+			state = top.state
+			linearized.clear(top.entry.id)
+			unlift(top.entry)
+			e = top.entry.next
+		}
+	}
+
+	return true
+}
+
+// Build the doubly linked list of invocations and responses in time order (behind a sentinel)
+func makeEntries(history []Operation) *entry {
+	entries := make([]*entry, 0, 2*len(history))
+	for i, op := range history {
+		call := &entry{id: i, call: true, time: op.Call}
+		ret := &entry{id: i, call: false, time: op.Return}
+		call.match = ret
+		entries = append(entries, call, ret)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].time != entries[j].time {
+			return entries[i].time < entries[j].time
+		}
+		return entries[i].call && !entries[j].call // Invocations first: equal times are concurrent
+	})
+
+	head := &entry{}
+	prev := head
+	for _, e := range entries {
+		prev.next = e
+		e.prev = prev
+		prev = e
+	}
+
+	return head
+}
+
+// Remove an invocation and its response from the list
+func lift(e *entry) {
+	e.prev.next = e.next
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+// Put back an invocation and its response removed by lift
+func unlift(e *entry) {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	e.prev.next = e
+	if e.next != nil {
+		e.next.prev = e
+	}
+}
+
+func cacheContains(cache map[uint64][]cacheEntry, equal func(interface{}, interface{}) bool,
+	linearized bitset, state interface{}) bool {
+	for _, c := range cache[linearized.hash()] {
+		if linearized.equals(c.linearized) && equal(state, c.state) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// ---------------------------------- BITSET ----------------------------------
+//
+type bitset []uint64
+
+func makeBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) clone() bitset {
+	c := make(bitset, len(b))
+	copy(c, b)
+	return c
+}
+
+func (b bitset) set(i int) bitset {
+	b[i/64] |= 1 << uint(i%64)
+	return b
+}
+
+func (b bitset) clear(i int) bitset {
+	b[i/64] &^= 1 << uint(i%64)
+	return b
+}
+
+func (b bitset) equals(c bitset) bool {
+	for i := range b {
+		if b[i] != c[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bitset) hash() uint64 { // FNV-1a over the words
+	h := uint64(14695981039346656037)
+	for _, w := range b {
+		h ^= w
+		h *= 1099511628211
+	}
+	return h
+}
@@ -0,0 +1,73 @@
+package linearizability
+
+// Operation history gathered by a test harness
+//
+// h := MakeHistory()       - Creates an empty history
+// id := h.Invoke(c, input) - Records the invocation of an operation by client c
+// h.Return(id, output)     - Records the response to a previously invoked operation
+// h.Operations()           - Returns a copy of the recorded operations
+//
+// => Times are logical: every invocation and response draws the next value of a counter, so
+//    the history is ordered exactly as the events happened in real time
+// => Operations that never return are pending: they may take effect at any point after their
+//    invocation, so the checker linearizes them last if nothing else requires them earlier
+
+import (
+	"math"
+	"sync"
+)
+
+const PENDING = math.MaxInt64 // Return time of an operation that never returned
+
+type Operation struct {
+	ClientId int
+	Input    interface{}
+	Output   interface{} // Nil if the output is unknown (any output is accepted)
+	Call     int64       // Logical time of invocation
+	Return   int64       // Logical time of response (PENDING if the operation never returned)
+}
+
+type History struct {
+	mu   sync.Mutex
+	time int64
+	ops  []Operation
+}
+
+func MakeHistory() *History {
+	h := &History{}
+	h.ops = make([]Operation, 0)
+	return h
+}
+
+func (h *History) Invoke(clientId int, input interface{}) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.time++
+	op := Operation{
+		ClientId: clientId,
+		Input:    input,
+		Call:     h.time,
+		Return:   PENDING}
+	h.ops = append(h.ops, op)
+
+	return len(h.ops) - 1
+}
+
+func (h *History) Return(id int, output interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.time++
+	h.ops[id].Output = output
+	h.ops[id].Return = h.time
+}
+
+func (h *History) Operations() []Operation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ops := make([]Operation, len(h.ops))
+	copy(ops, h.ops)
+	return ops
+}
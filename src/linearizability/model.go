@@ -0,0 +1,76 @@
+package linearizability
+
+// Sequential specifications checked by CheckOperations
+//
+// KvModel - A key/value store with Get, Put and Append operations (inputs are KvInput and
+//           outputs are KvOutput)
+//
+// => Put and Append return the value the key held before the operation, like Get returns the
+//    current value; a harness that can only observe the order in which a replicated log applied
+//    the operations still gets a fully specified output for every operation
+// => A nil output matches any result (e.g. the response of a timed out operation was lost)
+
+type Model struct {
+	Partition func(history []Operation) [][]Operation                    // Optional: split into independently checked histories
+	Init      func() interface{}                                         // Initial state
+	Step      func(state, input, output interface{}) (bool, interface{}) // Whether output is legal and the next state
+	Equal     func(state1, state2 interface{}) bool                      // Optional: defaults to ==
+}
+
+const ( // Key/value operations
+	GET    = iota
+	PUT    = iota
+	APPEND = iota
+)
+
+type KvInput struct {
+	Op    int
+	Key   string
+	Value string
+}
+
+type KvOutput struct {
+	Value string
+}
+
+var KvModel = Model{
+	Partition: partitionByKey,
+	Init: func() interface{} {
+		return ""
+	},
+	Step: func(state, input, output interface{}) (bool, interface{}) {
+		st := state.(string)
+		in := input.(KvInput)
+
+		ok := true
+		if output != nil {
+			ok = output.(KvOutput).Value == st
+		}
+
+		switch in.Op {
+		case PUT:
+			return ok, in.Value
+		case APPEND:
+			return ok, st + in.Value
+		default:
+			return ok, st
+		}
+	},
+}
+
+// Every key of a key/value store is an independent register, so check each key on its own
+func partitionByKey(history []Operation) [][]Operation {
+	keys := make(map[string]int)
+	partitions := make([][]Operation, 0)
+
+	for _, op := range history {
+		key := op.Input.(KvInput).Key
+		if _, ok := keys[key]; !ok {
+			keys[key] = len(partitions)
+			partitions = append(partitions, make([]Operation, 0))
+		}
+		partitions[keys[key]] = append(partitions[keys[key]], op)
+	}
+
+	return partitions
+}
@@ -0,0 +1,81 @@
+package linearizability
+
+import (
+	"fmt"
+	"testing"
+)
+
+func kvOp(client int, call int64, ret int64, op int, key string, value string, output string) Operation {
+	return Operation{
+		ClientId: client,
+		Input:    KvInput{Op: op, Key: key, Value: value},
+		Output:   KvOutput{Value: output},
+		Call:     call,
+		Return:   ret}
+}
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestSequentialHistory(t *testing.T) {
+	fmt.Println("Test: Linearizability - Sequential History")
+
+	history := []Operation{
+		kvOp(0, 1, 2, PUT, "x", "a", ""),
+		kvOp(0, 3, 4, APPEND, "x", "b", "a"),
+		kvOp(0, 5, 6, GET, "x", "", "ab"),
+		kvOp(0, 7, 8, GET, "y", "", "")}
+
+	if !CheckOperations(KvModel, history) {
+		t.Fatal("Sequential history is not linearizable!")
+	}
+
+	history[2] = kvOp(0, 5, 6, GET, "x", "", "a")
+	if CheckOperations(KvModel, history) {
+		t.Fatal("Stale read is linearizable!")
+	}
+}
+
+func TestConcurrentHistory(t *testing.T) {
+	fmt.Println("Test: Linearizability - Concurrent History")
+
+	// The write by client 1 overlaps both reads, so it may take effect between them
+	history := []Operation{
+		kvOp(0, 1, 2, PUT, "x", "a", ""),
+		kvOp(1, 3, 10, PUT, "x", "b", "a"),
+		kvOp(2, 4, 5, GET, "x", "", "a"),
+		kvOp(2, 6, 7, GET, "x", "", "b")}
+
+	if !CheckOperations(KvModel, history) {
+		t.Fatal("Concurrent history is not linearizable!")
+	}
+
+	// Once a read has observed the new value, a later read cannot observe the old one
+	history[3] = kvOp(2, 6, 7, GET, "x", "", "a")
+	history[2] = kvOp(2, 4, 5, GET, "x", "", "b")
+	if CheckOperations(KvModel, history) {
+		t.Fatal("Read of an overwritten value is linearizable!")
+	}
+}
+
+func TestPendingOperations(t *testing.T) {
+	fmt.Println("Test: Linearizability - Pending Operations")
+
+	h := MakeHistory()
+	put := h.Invoke(0, KvInput{Op: PUT, Key: "x", Value: "a"})
+	h.Return(put, KvOutput{Value: ""})
+	h.Invoke(1, KvInput{Op: PUT, Key: "x", Value: "b"}) // Never returns
+	get := h.Invoke(0, KvInput{Op: GET, Key: "x"})
+	h.Return(get, KvOutput{Value: "b"})
+
+	if !CheckOperations(KvModel, h.Operations()) {
+		t.Fatal("Read of a pending write is not linearizable!")
+	}
+
+	get = h.Invoke(0, KvInput{Op: GET, Key: "x"})
+	h.Return(get, KvOutput{Value: "c"})
+
+	if CheckOperations(KvModel, h.Operations()) {
+		t.Fatal("Read of a value that was never written is linearizable!")
+	}
+}
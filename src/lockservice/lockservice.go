@@ -0,0 +1,224 @@
+package lockservice
+
+// Lock service with leases replicated by XPaxos or PBFT
+//
+// locks := MakeLocks()                        - Lock state machine (see statemachine), one per server
+// locker := MakeLocker(client, session, lease) - Takes locks for a session through a protocol client
+// locker.Acquire(name)                         - Whether the session holds the lock afterwards
+// locker.Release(name)                         - Releases the lock if the session holds it
+// locker.KeepAlive()                           - Extends the leases of all locks of the session
+// locker.SetClock(clock)                       - Takes the time of operations from clock (see network)
+// locks.Owner(name, now)                       - Local owner of a lock at time now ("" if free)
+//
+// => A lock is held by a session until the session releases it or its lease expires; acquiring a
+//    lock the session already holds renews its lease
+// => Every operation is a read-modify-write of the lock table: Apply() first expires the leases
+//    that ended before the operation's time, then applies the operation
+// => Time is part of the operations (the proposer's clock), not read by Apply(), so that every
+//    server expires the same leases at the same point of the log; the lock table's clock is the
+//    latest time of any operation, so a proposer with a slow clock cannot revive expired leases
+// => A session whose proposer crashed loses its locks once their leases expire
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"sort"
+	"sync"
+	"time"
+)
+
+const ( // Operation types
+	ACQUIRE   = iota
+	RELEASE   = iota
+	KEEPALIVE = iota
+)
+
+type Op struct {
+	Type    int
+	Name    string // Lock (unused by KEEPALIVE)
+	Session string
+	Now     int64 // Proposer's time in nanoseconds
+	Lease   int64 // Lease duration in nanoseconds
+}
+
+type lock struct {
+	Session string
+	Expiry  int64
+}
+
+type Locks struct {
+	mu    sync.Mutex
+	locks map[string]lock
+	now   int64 // Latest time of any applied operation
+}
+
+type snapshot struct {
+	Locks map[string]lock
+	Now   int64
+}
+
+var _ statemachine.StateMachine = &Locks{}
+
+type Locker struct {
+	client  statemachine.Consensus
+	session string
+	lease   time.Duration
+	clock   network.Clock
+}
+
+//
+// ------------------------------- STATE MACHINE ------------------------------
+//
+func MakeLocks() *Locks {
+	locks := &Locks{}
+	locks.locks = make(map[string]lock)
+	return locks
+}
+
+// Returns the session holding the lock after the operation ("" if free)
+func (locks *Locks) Apply(data []byte) []byte {
+	op := Op{}
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&op); err != nil {
+		return nil // Not an operation of the service (i.e. a null operation)
+	}
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+
+	if op.Now > locks.now {
+		locks.now = op.Now
+	}
+	locks.expire()
+
+	switch op.Type {
+	case ACQUIRE:
+		if held, ok := locks.locks[op.Name]; ok == false || held.Session == op.Session {
+			locks.locks[op.Name] = lock{op.Session, locks.now + op.Lease}
+		}
+	case RELEASE:
+		if held, ok := locks.locks[op.Name]; ok && held.Session == op.Session {
+			delete(locks.locks, op.Name)
+		}
+	case KEEPALIVE:
+		for name, held := range locks.locks {
+			if held.Session == op.Session {
+				locks.locks[name] = lock{op.Session, locks.now + op.Lease}
+			}
+		}
+		return []byte(op.Session)
+	}
+	return []byte(locks.locks[op.Name].Session)
+}
+
+// Must be called with locks.mu held
+func (locks *Locks) expire() {
+	for name, held := range locks.locks {
+		if held.Expiry <= locks.now {
+			delete(locks.locks, name)
+		}
+	}
+}
+
+func (locks *Locks) Snapshot() []byte {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(snapshot{locks.locks, locks.now})
+	return buf.Bytes()
+}
+
+func (locks *Locks) Restore(data []byte) {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+
+	restored := snapshot{}
+	if len(data) > 0 {
+		gob.NewDecoder(bytes.NewBuffer(data)).Decode(&restored)
+	}
+	locks.locks = make(map[string]lock)
+	for name, held := range restored.Locks {
+		locks.locks[name] = held
+	}
+	locks.now = restored.Now
+}
+
+// Locks in order of their names with their sessions and expiries
+func (locks *Locks) Hash() [32]byte {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+
+	names := make([]string, 0, len(locks.locks))
+	for name := range locks.locks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hasher := statemachine.MakeHasher().WriteInt(locks.now)
+	for _, name := range names {
+		held := locks.locks[name]
+		hasher.WriteString(name).WriteString(held.Session).WriteInt(held.Expiry)
+	}
+	return hasher.Sum()
+}
+
+func (locks *Locks) Scratch() statemachine.StateMachine {
+	return MakeLocks()
+}
+
+func (locks *Locks) Owner(name string, now time.Time) string {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+
+	if held, ok := locks.locks[name]; ok && held.Expiry > now.UnixNano() {
+		return held.Session
+	}
+	return ""
+}
+
+//
+// ---------------------------------- LOCKER ----------------------------------
+//
+func MakeLocker(client statemachine.Consensus, session string, lease time.Duration) *Locker {
+	locker := &Locker{}
+	locker.client = client
+	locker.session = session
+	locker.lease = lease
+	locker.clock = network.RealClock{}
+	return locker
+}
+
+func (locker *Locker) SetClock(clock network.Clock) {
+	locker.clock = clock
+}
+
+// Returns the session holding the lock after the operation and whether the request committed
+func (locker *Locker) execute(opType int, name string) (string, bool) {
+	op := Op{
+		Type:    opType,
+		Name:    name,
+		Session: locker.session,
+		Now:     locker.clock.Now().UnixNano(),
+		Lease:   int64(locker.lease)}
+
+	result, ok := locker.client.Execute(statemachine.Encode(op))
+	return string(result), ok
+}
+
+func (locker *Locker) Acquire(name string) bool {
+	owner, ok := locker.execute(ACQUIRE, name)
+	return ok && owner == locker.session
+}
+
+// Returns whether the request committed (the lock is free or held by another session)
+func (locker *Locker) Release(name string) bool {
+	owner, ok := locker.execute(RELEASE, name)
+	return ok && owner != locker.session
+}
+
+func (locker *Locker) KeepAlive() bool {
+	_, ok := locker.execute(KEEPALIVE, "")
+	return ok
+}
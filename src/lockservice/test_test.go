@@ -0,0 +1,81 @@
+package lockservice
+
+import (
+	"fmt"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"testing"
+	"time"
+)
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestLocks(t *testing.T) {
+	fmt.Println("Test: Lock Service - Acquire and Release")
+
+	locks := MakeLocks()
+	clock := network.MakeVirtualClock()
+	alice := MakeLocker(statemachine.MakeLocal(locks), "alice", time.Second)
+	bob := MakeLocker(statemachine.MakeLocal(locks), "bob", time.Second)
+	alice.SetClock(clock)
+	bob.SetClock(clock)
+
+	if alice.Acquire("a") == false || alice.Acquire("a") == false {
+		t.Fatal("Free lock not acquired!")
+	}
+	if bob.Acquire("a") == true || locks.Owner("a", clock.Now()) != "alice" {
+		t.Fatal("Lock acquired by two sessions!")
+	}
+	if bob.Release("a") == false || locks.Owner("a", clock.Now()) != "alice" {
+		t.Fatal("Lock released by a session that does not hold it!")
+	}
+	if alice.Release("a") == false || bob.Acquire("a") == false {
+		t.Fatal("Released lock not acquired!")
+	}
+}
+
+func TestLockLeases(t *testing.T) {
+	fmt.Println("Test: Lock Service - Lease Expiry and Keep-Alive")
+
+	locks := MakeLocks()
+	clock := network.MakeVirtualClock()
+	alice := MakeLocker(statemachine.MakeLocal(locks), "alice", time.Second)
+	bob := MakeLocker(statemachine.MakeLocal(locks), "bob", time.Second)
+	alice.SetClock(clock)
+	bob.SetClock(clock)
+
+	alice.Acquire("a")
+	alice.Acquire("b")
+
+	clock.Advance(800 * time.Millisecond)
+	alice.KeepAlive() // Both leases now end at 1.8s
+	clock.Advance(800 * time.Millisecond)
+	if bob.Acquire("a") == true {
+		t.Fatal("Lock acquired before its renewed lease expired!")
+	}
+
+	clock.Advance(time.Second)
+	if bob.Acquire("b") == false || locks.Owner("a", clock.Now()) != "" {
+		t.Fatal("Lease of a session that stopped renewing did not expire!")
+	}
+
+	// A proposer with a slow clock gets a lease from the lock table's clock, not its own
+	slow := network.MakeVirtualClock()
+	carol := MakeLocker(statemachine.MakeLocal(locks), "carol", time.Second)
+	carol.SetClock(slow)
+	if carol.Acquire("c") == false {
+		t.Fatal("Free lock not acquired by a slow proposer!")
+	}
+	clock.Advance(100 * time.Millisecond)
+	if bob.Acquire("c") == true {
+		t.Fatal("Lease of a slow proposer expired early!")
+	}
+
+	snapshot := locks.Snapshot()
+	restored := MakeLocks()
+	restored.Restore(snapshot)
+	if restored.Owner("b", clock.Now()) != "bob" {
+		t.Fatal("Snapshot not restored!")
+	}
+}
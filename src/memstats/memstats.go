@@ -0,0 +1,145 @@
+package memstats
+
+// Memory and allocation profiling of benchmarks
+//
+// s := Start(interval, dir)  - Samples runtime.MemStats every interval from now on
+// sum := s.Stop()            - Stops sampling and summarizes the allocations since Start()
+// sum.AllocsPer(ops)         - Allocations per operation (see also sum.BytesPer())
+// sum.String()               - "allocs=... bytes=... peak-heap=... gcs=..."
+//
+// => Allocations are counted over the whole process (runtime.MemStats.Mallocs and TotalAlloc), so
+//    they include those of the client, the servers and the simulated network
+// => Every sample records the live heap (HeapAlloc) and the summary reports its peak; an interval
+//    of 0 samples only at Start() and Stop()
+// => With a directory (created if missing), every sample also dumps a heap profile
+//    (heap-<sample>.pprof) for "go tool pprof"; the profile reflects the heap as of the last
+//    garbage collection
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+type Sampler struct {
+	mu       sync.Mutex
+	dir      string // Heap profiles (none if empty)
+	start    runtime.MemStats
+	peakHeap uint64
+	samples  int
+	profiles []string
+	done     chan bool
+	stopped  sync.WaitGroup
+}
+
+type Summary struct {
+	Allocs   uint64   // Heap objects allocated
+	Bytes    uint64   // Heap bytes allocated
+	PeakHeap uint64   // Largest live heap of all samples (in bytes)
+	GCs      uint32   // Completed garbage collections
+	Samples  int      // Samples taken (including those of Start() and Stop())
+	Profiles []string // Paths of the heap profiles dumped
+}
+
+func Start(interval time.Duration, dir string) *Sampler {
+	s := &Sampler{}
+	s.dir = dir
+	s.profiles = make([]string, 0)
+	s.done = make(chan bool)
+
+	if dir != "" {
+		os.MkdirAll(dir, 0755)
+	}
+
+	runtime.ReadMemStats(&s.start)
+	s.sample(&s.start)
+
+	if interval > 0 {
+		s.stopped.Add(1)
+		go func() {
+			defer s.stopped.Done()
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-s.done:
+					return
+				case <-ticker.C:
+					var m runtime.MemStats
+					runtime.ReadMemStats(&m)
+					s.sample(&m)
+				}
+			}
+		}()
+	}
+
+	return s
+}
+
+func (s *Sampler) sample(m *runtime.MemStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples++
+	if m.HeapAlloc > s.peakHeap {
+		s.peakHeap = m.HeapAlloc
+	}
+
+	if s.dir == "" {
+		return
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("heap-%d.pprof", s.samples))
+	file, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if pprof.WriteHeapProfile(file) == nil {
+		s.profiles = append(s.profiles, path)
+	}
+}
+
+func (s *Sampler) Stop() Summary {
+	close(s.done)
+	s.stopped.Wait()
+
+	var end runtime.MemStats
+	runtime.ReadMemStats(&end)
+	s.sample(&end)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sum := Summary{}
+	sum.Allocs = end.Mallocs - s.start.Mallocs
+	sum.Bytes = end.TotalAlloc - s.start.TotalAlloc
+	sum.PeakHeap = s.peakHeap
+	sum.GCs = end.NumGC - s.start.NumGC
+	sum.Samples = s.samples
+	sum.Profiles = s.profiles
+	return sum
+}
+
+func (sum Summary) AllocsPer(ops int) float64 {
+	if ops == 0 {
+		return 0
+	}
+	return float64(sum.Allocs) / float64(ops)
+}
+
+func (sum Summary) BytesPer(ops int) float64 {
+	if ops == 0 {
+		return 0
+	}
+	return float64(sum.Bytes) / float64(ops)
+}
+
+func (sum Summary) String() string {
+	return fmt.Sprintf("allocs=%d bytes=%d peak-heap=%d gcs=%d samples=%d", sum.Allocs, sum.Bytes,
+		sum.PeakHeap, sum.GCs, sum.Samples)
+}
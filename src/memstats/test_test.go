@@ -0,0 +1,41 @@
+package memstats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var sink [][]byte
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestSampler(t *testing.T) {
+	fmt.Println("Test: Memory Stats - Allocations and Heap Profiles")
+
+	dir := t.TempDir()
+	s := Start(10*time.Millisecond, dir)
+
+	for i := 0; i < 1000; i++ {
+		sink = append(sink, make([]byte, 1024))
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	sum := s.Stop()
+	sink = nil
+
+	if sum.Allocs < 1000 || sum.Bytes < 1000*1024 {
+		t.Fatalf("Allocations not counted (%s)!", sum)
+	}
+	if sum.PeakHeap < 1000*1024 {
+		t.Fatalf("Peak heap not sampled (%s)!", sum)
+	}
+	if sum.Samples < 3 || len(sum.Profiles) != sum.Samples {
+		t.Fatalf("Expected a heap profile for each of at least 3 samples, got %d profiles (%s)!",
+			len(sum.Profiles), sum)
+	}
+	if sum.AllocsPer(0) != 0 || sum.AllocsPer(2) != float64(sum.Allocs)/2 {
+		t.Fatal("Invalid allocations per operation!")
+	}
+}
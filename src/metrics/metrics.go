@@ -0,0 +1,279 @@
+package metrics
+
+// Metrics in the Prometheus text exposition format, for deployed servers (see xpaxos/metrics.go)
+//
+// reg := MakeRegistry(labels...)              - Registry adding constant labels, i.e. "server", "1"
+// reg.Counter(name, help)                     - Counter incremented by the caller
+// reg.CounterFunc(name, help, f)              - Counter read from f() at every scrape
+// reg.GaugeFunc(name, help, f)                - Gauge read from f() at every scrape
+// reg.Histogram(name, help, label, buckets)   - Histograms of durations, one per value of label
+// reg.GaugeVec(name, help, label)             - Gauges set by the caller, one per value of label
+// c.Add(n), c.Value()                         - Counters
+// g.Add(value, n), g.Value(value)             - Adds n to / reads the gauge of a label value
+// h.Observe(value, d)                         - Records d in the histogram of a label value
+// http.Handle("/metrics", reg)                - Serves the metrics
+// reg.Write(w)                                - Writes the metrics (i.e. for tests)
+//
+// => Metrics are written in the order they were registered, gauges and histograms of a vector by
+//    label value, so that successive scrapes are easy to diff
+// => Histograms have cumulative buckets of durations in seconds (le="..." and le="+Inf"), their
+//    sum and their count, like those of the Prometheus client libraries; DURATIONS are the default
+//    buckets, from 1ms to 10s
+// => The package has no dependencies, so a server built without Prometheus still serves them
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var DURATIONS = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10} // Seconds
+
+type Registry struct {
+	mu       sync.Mutex
+	labels   string // Constant labels, i.e. `server="1"`
+	families []family
+}
+
+type family interface {
+	write(w io.Writer, labels string)
+}
+
+type Counter struct {
+	name  string
+	help  string
+	value int64
+}
+
+type funcMetric struct {
+	name  string
+	help  string
+	kind  string // counter or gauge
+	value func() float64
+}
+
+type HistogramVec struct {
+	mu         sync.Mutex
+	name       string
+	help       string
+	label      string
+	buckets    []float64
+	histograms map[string]*histogram // Label value -> histogram
+}
+
+type GaugeVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	label  string
+	gauges map[string]int64 // Label value -> gauge
+}
+
+type histogram struct {
+	counts []int64 // Per bucket (not cumulative), and one more for +Inf
+	sum    float64
+	count  int64
+}
+
+func MakeRegistry(labels ...string) *Registry {
+	reg := &Registry{}
+	pairs := make([]string, 0)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"="+strconv.Quote(labels[i+1]))
+	}
+	reg.labels = strings.Join(pairs, ",")
+	return reg
+}
+
+func (reg *Registry) register(f family) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.families = append(reg.families, f)
+}
+
+func (reg *Registry) Counter(name string, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	reg.register(c)
+	return c
+}
+
+func (reg *Registry) CounterFunc(name string, help string, value func() float64) {
+	reg.register(&funcMetric{name, help, "counter", value})
+}
+
+func (reg *Registry) GaugeFunc(name string, help string, value func() float64) {
+	reg.register(&funcMetric{name, help, "gauge", value})
+}
+
+// buckets are upper bounds in seconds, in increasing order (nil for DURATIONS)
+func (reg *Registry) Histogram(name string, help string, label string, buckets []float64) *HistogramVec {
+	if buckets == nil {
+		buckets = DURATIONS
+	}
+	h := &HistogramVec{name: name, help: help, label: label, buckets: buckets}
+	h.histograms = make(map[string]*histogram)
+	reg.register(h)
+	return h
+}
+
+func (reg *Registry) GaugeVec(name string, help string, label string) *GaugeVec {
+	g := &GaugeVec{name: name, help: help, label: label}
+	g.gauges = make(map[string]int64)
+	reg.register(g)
+	return g
+}
+
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	reg.Write(w)
+}
+
+func (reg *Registry) Write(w io.Writer) {
+	reg.mu.Lock()
+	families := append([]family(nil), reg.families...)
+	reg.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, f := range families {
+		f.write(&buf, reg.labels)
+	}
+	w.Write(buf.Bytes())
+}
+
+//
+// --------------------------------- COUNTERS ---------------------------------
+//
+func (c *Counter) Add(n int64) {
+	atomic.AddInt64(&c.value, n)
+}
+
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+func (c *Counter) write(w io.Writer, labels string) {
+	header(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s%s %d\n", c.name, braces(labels), c.Value())
+}
+
+func (m *funcMetric) write(w io.Writer, labels string) {
+	header(w, m.name, m.help, m.kind)
+	fmt.Fprintf(w, "%s%s %s\n", m.name, braces(labels), formatFloat(m.value()))
+}
+
+//
+// ---------------------------------- GAUGES ----------------------------------
+//
+func (g *GaugeVec) Add(value string, n int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.gauges[value] += n
+}
+
+func (g *GaugeVec) Value(value string) int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.gauges[value]
+}
+
+func (g *GaugeVec) write(w io.Writer, labels string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	header(w, g.name, g.help, "gauge")
+	values := make([]string, 0, len(g.gauges))
+	for value := range g.gauges {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for _, value := range values {
+		fmt.Fprintf(w, "%s{%s} %d\n", g.name, join(labels, g.label+"="+strconv.Quote(value)), g.gauges[value])
+	}
+}
+
+//
+// -------------------------------- HISTOGRAMS --------------------------------
+//
+func (h *HistogramVec) Observe(value string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.histograms[value]
+	if ok == false {
+		hist = &histogram{counts: make([]int64, len(h.buckets)+1)}
+		h.histograms[value] = hist
+	}
+
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(h.buckets, seconds) // First bucket with seconds <= its bound
+	hist.counts[i]++
+	hist.sum += seconds
+	hist.count++
+}
+
+func (h *HistogramVec) write(w io.Writer, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	header(w, h.name, h.help, "histogram")
+	values := make([]string, 0, len(h.histograms))
+	for value := range h.histograms {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for _, value := range values {
+		hist := h.histograms[value]
+		series := join(labels, h.label+"="+strconv.Quote(value))
+
+		cumulative := int64(0)
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.name, join(series, "le="+strconv.Quote(formatFloat(bound))),
+				cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.name, join(series, `le="+Inf"`), hist.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, series, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, series, hist.count)
+	}
+}
+
+//
+// ---------------------------------- FORMAT ----------------------------------
+//
+func header(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func join(labels string, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestMetrics(t *testing.T) {
+	fmt.Println("Test: Metrics - Text Exposition Format")
+
+	reg := MakeRegistry("server", "1")
+	signatures := reg.Counter("signatures_total", "Signatures")
+	view := 3.0
+	reg.GaugeFunc("view", "Current view", func() float64 { return view })
+	rpcs := reg.Histogram("rpc_duration_seconds", "RPC latencies", "method", []float64{.01, .1})
+	inFlight := reg.GaugeVec("rpcs_in_flight", "RPCs in flight", "peer")
+
+	signatures.Add(2)
+	rpcs.Observe("Prepare", 5*time.Millisecond)
+	rpcs.Observe("Prepare", 50*time.Millisecond)
+	rpcs.Observe("Prepare", time.Second)
+	rpcs.Observe("Commit", time.Millisecond)
+	inFlight.Add("3", 2)
+	inFlight.Add("2", 1)
+	inFlight.Add("3", -1)
+
+	var buf bytes.Buffer
+	reg.Write(&buf)
+	expected := `# HELP signatures_total Signatures
+# TYPE signatures_total counter
+signatures_total{server="1"} 2
+# HELP view Current view
+# TYPE view gauge
+view{server="1"} 3
+# HELP rpc_duration_seconds RPC latencies
+# TYPE rpc_duration_seconds histogram
+rpc_duration_seconds_bucket{server="1",method="Commit",le="0.01"} 1
+rpc_duration_seconds_bucket{server="1",method="Commit",le="0.1"} 1
+rpc_duration_seconds_bucket{server="1",method="Commit",le="+Inf"} 1
+rpc_duration_seconds_sum{server="1",method="Commit"} 0.001
+rpc_duration_seconds_count{server="1",method="Commit"} 1
+rpc_duration_seconds_bucket{server="1",method="Prepare",le="0.01"} 1
+rpc_duration_seconds_bucket{server="1",method="Prepare",le="0.1"} 2
+rpc_duration_seconds_bucket{server="1",method="Prepare",le="+Inf"} 3
+rpc_duration_seconds_sum{server="1",method="Prepare"} 1.055
+rpc_duration_seconds_count{server="1",method="Prepare"} 3
+# HELP rpcs_in_flight RPCs in flight
+# TYPE rpcs_in_flight gauge
+rpcs_in_flight{server="1",peer="2"} 1
+rpcs_in_flight{server="1",peer="3"} 1
+`
+	if buf.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, buf.String())
+	}
+
+	if inFlight.Value("3") != 1 || inFlight.Value("4") != 0 {
+		t.Fatal("Invalid gauge values!")
+	}
+
+	view = 4
+	recorder := httptest.NewRecorder()
+	reg.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(recorder.Body.String(), `view{server="1"} 4`) == false {
+		t.Fatal("Gauge not read at scrape time!")
+	}
+}
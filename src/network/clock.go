@@ -0,0 +1,161 @@
+package network
+
+// Clock abstraction shared by the network delay model and the protocol timers
+//
+// RealClock{} simply wraps the time package. A VirtualClock only moves forward when a test
+// calls Advance(), so timeouts fire exactly when the test decides they should
+//
+// clock := MakeVirtualClock() - Virtual clock starting at the Unix epoch
+// net.SetClock(clock)         - Drive all network delays and timeouts from clock
+// clock.Advance(d)            - Move time forward by d and fire every expired timer
+// clock.WaitForTimers(n)      - Block (in real time) until at least n timers are pending
+//
+// SkewedClock{base, rate} runs the timers of base rate times as fast (rate > 1 fires them early,
+// rate < 1 late), i.e. the drifting local clock of one server; its Now() is that of base
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+type RealClock struct{}
+
+type VirtualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []virtualTimer
+}
+
+type SkewedClock struct {
+	Base Clock
+	Rate float64
+}
+
+type virtualTimer struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+//
+// ------------------------------- REAL CLOCK ---------------------------------
+//
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+//
+// ------------------------------ VIRTUAL CLOCK -------------------------------
+//
+func MakeVirtualClock() *VirtualClock {
+	vc := &VirtualClock{}
+	vc.now = time.Unix(0, 0)
+	vc.waiters = make([]virtualTimer, 0)
+	return vc
+}
+
+func (vc *VirtualClock) Now() time.Time {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	return vc.now
+}
+
+func (vc *VirtualClock) After(d time.Duration) <-chan time.Time {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	ch := make(chan time.Time, 1) // Buffered so that Advance() never blocks on a timer
+	if d <= 0 {
+		ch <- vc.now
+		return ch
+	}
+
+	vc.waiters = append(vc.waiters, virtualTimer{vc.now.Add(d), ch})
+	return ch
+}
+
+func (vc *VirtualClock) Sleep(d time.Duration) {
+	<-vc.After(d)
+}
+
+func (vc *VirtualClock) Advance(d time.Duration) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	vc.now = vc.now.Add(d)
+
+	// Fire expired timers in deadline order
+	sort.SliceStable(vc.waiters, func(i, j int) bool {
+		return vc.waiters[i].deadline.Before(vc.waiters[j].deadline)
+	})
+
+	fired := 0
+	for _, w := range vc.waiters {
+		if w.deadline.After(vc.now) {
+			break
+		}
+		w.ch <- w.deadline
+		fired++
+	}
+	vc.waiters = vc.waiters[fired:]
+}
+
+func (vc *VirtualClock) Pending() int {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	return len(vc.waiters)
+}
+
+func (vc *VirtualClock) WaitForTimers(n int) {
+	for vc.Pending() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//
+// ------------------------------ SKEWED CLOCK --------------------------------
+//
+func (sc SkewedClock) Now() time.Time {
+	return sc.Base.Now()
+}
+
+func (sc SkewedClock) After(d time.Duration) <-chan time.Time {
+	return sc.Base.After(time.Duration(float64(d) / sc.Rate))
+}
+
+func (sc SkewedClock) Sleep(d time.Duration) {
+	sc.Base.Sleep(time.Duration(float64(d) / sc.Rate))
+}
+
+//
+// ----------------------------- NETWORK FUNCTIONS ----------------------------
+//
+func (rn *Network) SetClock(clock Clock) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.clock = clock
+}
+
+func (rn *Network) GetClock() Clock {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.clock
+}
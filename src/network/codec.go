@@ -0,0 +1,71 @@
+package network
+
+// Pluggable serialization of RPC arguments and replies
+//
+// Every network (i.e. every cluster) has exactly one codec that is used by all of its
+// ClientEnds and Servers. The codec is captured when an RPC is issued so that the request
+// and the reply of a single RPC are always encoded the same way.
+//
+// net.SetCodec(codec) - Select the codec (GobCodec by default); call before issuing RPCs
+//
+// => GobCodec is Go-only and sends type descriptors with every RPC since each RPC uses a
+//    fresh encoder
+// => JSONCodec is language-agnostic (i.e. non-Go tooling can read captured payloads)
+// => A protobuf codec only has to implement the Codec interface; its generated message
+//    types are not part of this repository
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+type Codec interface {
+	Name() string
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error // v must be a pointer
+}
+
+type GobCodec struct{}
+
+type JSONCodec struct{}
+
+func (GobCodec) Name() string {
+	return "gob"
+}
+
+func (GobCodec) Encode(v interface{}) ([]byte, error) {
+	b := new(bytes.Buffer)
+	err := gob.NewEncoder(b).Encode(v)
+	return b.Bytes(), err
+}
+
+func (GobCodec) Decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(v)
+}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (rn *Network) SetCodec(codec Codec) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.codec = codec
+}
+
+func (rn *Network) getCodec() Codec {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.codec
+}
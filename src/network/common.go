@@ -1,33 +1,77 @@
 package network
 
 import (
+	"math/rand"
 	"reflect"
 	"sync"
+	"time"
 )
 
-const DEBUG = 2   // Debugging (0 = None, 1 = Info, 2 = Debug)
 const DELTA = 100 // Network time frame delta for XPaxos synchronous group (in milliseconds)
 
 type Network struct {
-	mu             sync.Mutex
-	reliable       bool
-	longDelays     bool                       // Pause a long time
-	longReordering bool                       // Reorder replies by occaisionally delaying them
-	ends           map[interface{}]*ClientEnd // Client endpoints by name
-	enabled        map[interface{}]bool
-	servers        map[interface{}]*Server     // Servers by name
-	connections    map[interface{}]interface{} // Map of endpoint name to server name
-	endCh          chan reqMsg
-	faultRate      map[interface{}]int
-	simulNetDelay  bool
-	netDelayMax	   int
-	netDelayMin    int
+	mu                sync.Mutex
+	reliable          bool
+	longDelays        bool                       // Pause a long time
+	longReordering    bool                       // Reorder replies by occaisionally delaying them
+	ends              map[interface{}]*ClientEnd // Client endpoints by name
+	enabled           map[interface{}]bool
+	servers           map[interface{}]*Server     // Servers by name
+	connections       map[interface{}]interface{} // Map of endpoint name to server name
+	endCh             chan reqMsg
+	faultRate         map[interface{}]int
+	latency           LatencyDistribution // Default propagation delay (nil = none)
+	linkLatency       map[link]LatencyDistribution
+	jitter            time.Duration // Default jitter around the sampled delay
+	linkJitter        map[link]time.Duration
+	asynchronous      bool          // Delay every RPC beyond DELTA (see latency.go)
+	linkDisabled      map[link]bool // Directed links (caller ID, server name) that drop all traffic
+	inboxes           map[interface{}]*inbox
+	sendRate          map[int]int         // RPCs per second by throttled sender (caller ID)
+	sendSlot          map[int]time.Time   // Next free send slot by throttled sender
+	concurrency       map[interface{}]int // Handler concurrency limits by server name
+	watchers          []chan ServerEvent  // Membership watchers (see membership.go)
+	subscribers       []chan Event        // Event bus subscribers (see events.go)
+	msgId             int64               // Last message ID handed out
+	recording         bool                // Whether message seeds are recorded
+	recorded          []Decision
+	replay            map[decisionKey]int64 // Recorded seeds to replay
+	linkSeq           map[decisionKey]int   // Messages issued per link and method (Seq = 0)
+	rand              *rand.Rand            // Seeds the random decisions about each message (guarded by mu)
+	codec             Codec                 // Serialization of RPC arguments and replies
+	methodStats       map[string]*MethodStats
+	drops             map[interface{}]int           // Lost requests and replies by server name
+	delays            map[interface{}]time.Duration // Cumulative delays applied by server name
+	clock             Clock                         // Source of time for delays and timeouts
+	streamId          int64                         // Last stream ID handed out by CallStream()
+	holdPred          HoldPredicate                 // Requests matching the predicate are held (nil = none)
+	held              []*HeldMessage
+	heldId            int // Last ID handed out to a held message
+	corruptionRate    int // Percentage of messages to corrupt on every link
+	linkCorruption    map[link]int
+	corrupted         map[interface{}]int // Corrupted requests and replies by receiver
+	undelivered       map[interface{}]int // Requests sent but not yet handed to the server, by server name
+	peakUndelivered   map[interface{}]int // Highest backlog of every server since the last reset
+	compressThreshold int                 // Compress arguments of at least this many bytes (0 = off)
+	compressStats     CompressionStats
+}
+
+type link struct { // Directed link from a caller ID to a server name
+	from interface{}
+	to   interface{}
 }
 
 type Server struct {
-	mu       sync.Mutex
-	services map[string]*Service
-	count    int // Count of incoming RPCs
+	mu           sync.Mutex
+	services     map[string]*Service
+	count        int   // Count of incoming RPCs
+	bytes        int64 // Count of request and reply bytes
+	panics       int   // Count of RPCs whose handler panicked (see recover.go)
+	interceptors []Interceptor
+	limit        int        // Maximum number of concurrently executing handlers (0 = unlimited)
+	active       int        // Number of executing handlers
+	queued       int        // Number of RPCs waiting for a handler slot
+	slots        *sync.Cond // Signaled when a handler slot frees up
 }
 
 type Service struct {
@@ -38,17 +82,26 @@ type Service struct {
 }
 
 type ClientEnd struct {
-	endname interface{} // Client endpoint's name
-	ch      chan reqMsg // Copy of Network.endCh
+	mu           sync.Mutex
+	endname      interface{} // Client endpoint's name
+	ch           chan reqMsg // Copy of Network.endCh
+	net          *Network
+	interceptors []Interceptor
 }
 
 type reqMsg struct {
-	endname  interface{} // Name of sending client endpoint
-	svcMeth  string      // i.e. "XPaxos.Replicate"
-	argsType reflect.Type
-	args     []byte
-	replyCh  chan replyMsg
-	callerId int
+	id         int64       // Unique message ID (see events.go)
+	endname    interface{} // Name of sending client endpoint
+	svcMeth    string      // i.e. "XPaxos.Replicate"
+	argsType   reflect.Type
+	args       []byte
+	replyCh    chan replyMsg
+	callerId   int
+	codec      Codec // Codec used for both the arguments and the reply
+	priority   int
+	compressed bool   // Whether args is gzipped
+	seed       int64  // Seed of all random decisions about the message (see record.go)
+	traceId    string // Trace ID of the arguments (see events.go)
 }
 
 type replyMsg struct {
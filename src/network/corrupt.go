@@ -0,0 +1,68 @@
+package network
+
+// Payload corruption
+//
+// A corrupted message has a few of its bytes flipped on the wire. Corrupted requests and
+// replies that can still be decoded are delivered as they are, so that digest checks and
+// signature verification in the protocols get to see them; messages that can no longer be
+// decoded are lost (as if a transport checksum had caught them)
+//
+// net.SetCorruptionRate(rate)                 - Percentage of messages on every link to corrupt
+// net.SetLinkCorruptionRate(from, to, rate)   - Percentage for a single directed link (negative = default)
+
+import (
+	"math/rand"
+)
+
+const CORRUPTBYTES = 3 // Maximum number of bytes flipped in a corrupted message
+
+func (rn *Network) SetCorruptionRate(rate int) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.corruptionRate = rate
+}
+
+func (rn *Network) SetLinkCorruptionRate(from interface{}, to interface{}, rate int) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if rate < 0 {
+		delete(rn.linkCorruption, link{from, to})
+	} else {
+		rn.linkCorruption[link{from, to}] = rate
+	}
+}
+
+func (rn *Network) isCorrupting() bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.corruptionRate > 0 || len(rn.linkCorruption) > 0
+}
+
+// Returns a corrupted copy of data if a message on the link should be corrupted and data
+// itself otherwise
+func (rn *Network) maybeCorrupt(r *rand.Rand, from interface{}, to interface{}, data []byte) ([]byte, bool) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rate, ok := rn.linkCorruption[link{from, to}]
+	if ok == false {
+		rate = rn.corruptionRate
+	}
+
+	if len(data) == 0 || r.Intn(100) >= rate {
+		return data, false
+	}
+
+	corrupted := make([]byte, len(data))
+	copy(corrupted, data)
+	flips := 1 + r.Intn(CORRUPTBYTES)
+	for i := 0; i < flips; i++ {
+		corrupted[r.Intn(len(corrupted))] ^= byte(1 + r.Intn(255)) // Never a no-op
+	}
+
+	rn.corrupted[to]++
+	return corrupted, true
+}
@@ -0,0 +1,141 @@
+package network
+
+// Network event bus
+//
+// Every RPC produces a stream of structured events as it moves through the network, so that
+// tests can assert ordering properties (i.e. "no Commit was delivered before its Prepare")
+// without parsing debug logs. All events of a single RPC carry the same message ID
+//
+// ch := net.Subscribe()  - Receive all events from now on
+// net.Unsubscribe(ch)    - Stop receiving events
+//
+// => SENT: the request entered the network
+// => DELAYED: the request or reply was delayed (Delay holds the duration)
+// => DROPPED: the request or reply was lost
+// => DELIVERED: the request was handed to the server
+// => REPLIED: the reply reached the caller
+// => CORRUPTED: the network flipped bytes of the request or of the reply (see corrupt.go)
+// => A subscriber must keep draining its channel since the network blocks once EVENTBUFFER
+//    events are pending
+// => Events of an RPC whose arguments implement Traced carry the trace ID of the client request
+//    it is about (see NewTraceId()), so that all messages of a request can be followed across
+//    servers, like its log records
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const EVENTBUFFER = 4096 // Pending events per subscriber
+
+const ( // Event types
+	SENT      = iota
+	DELAYED   = iota
+	DROPPED   = iota
+	DELIVERED = iota
+	REPLIED   = iota
+	CORRUPTED = iota
+)
+
+type Event struct {
+	Type    int
+	MsgId   int64       // Unique per RPC
+	SvcMeth string      // i.e. "XPaxos.Commit"
+	From    int         // Caller ID
+	To      interface{} // Server name (nil if the caller's end is not connected)
+	Time    time.Time   // Time on the network's clock
+	Delay   time.Duration
+	TraceId string // Trace ID of the arguments ("" if they are not Traced)
+}
+
+// Arguments of RPCs about a client request (i.e. its prepare and commit messages)
+type Traced interface {
+	Trace() string
+}
+
+// Random 128-bit trace ID of a client request, in hex
+func NewTraceId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func traceOf(args interface{}) string {
+	if traced, ok := args.(Traced); ok {
+		return traced.Trace()
+	}
+	return ""
+}
+
+func (ev Event) TypeName() string {
+	switch ev.Type {
+	case SENT:
+		return "sent"
+	case DELAYED:
+		return "delayed"
+	case DROPPED:
+		return "dropped"
+	case DELIVERED:
+		return "delivered"
+	case REPLIED:
+		return "replied"
+	case CORRUPTED:
+		return "corrupted"
+	}
+	return "unknown"
+}
+
+func (rn *Network) Subscribe() <-chan Event {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	ch := make(chan Event, EVENTBUFFER)
+	rn.subscribers = append(rn.subscribers, ch)
+	return ch
+}
+
+func (rn *Network) Unsubscribe(ch <-chan Event) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	for i, sub := range rn.subscribers {
+		if sub == ch {
+			rn.subscribers = append(rn.subscribers[:i], rn.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (rn *Network) nextMsgId() int64 {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.msgId++
+	return rn.msgId
+}
+
+func (rn *Network) emit(eventType int, req reqMsg, servername interface{}, delay time.Duration) {
+	rn.mu.Lock()
+	subscribers := rn.subscribers
+	clock := rn.clock
+	rn.mu.Unlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+
+	ev := Event{}
+	ev.Type = eventType
+	ev.MsgId = req.id
+	ev.SvcMeth = req.svcMeth
+	ev.From = req.callerId
+	ev.To = servername
+	ev.Time = clock.Now()
+	ev.Delay = delay
+	ev.TraceId = req.traceId
+
+	for _, ch := range subscribers {
+		ch <- ev
+	}
+}
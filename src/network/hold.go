@@ -0,0 +1,129 @@
+package network
+
+// Holding in-flight messages to construct specific interleavings
+//
+// While a hold predicate is installed, every request that matches it is parked just before it
+// would be handed to its server. A test can then inspect the held messages and release or drop
+// them one at a time in whatever order it wants
+//
+// net.Hold(pred)      - Hold requests for which pred(svcMeth, from, to) is true (nil = stop)
+// net.Held()          - Held messages in arrival order
+// net.WaitForHeld(n)  - Block until at least n messages are held
+// net.Release(id)     - Deliver a held message and wait until the server has executed it
+// net.Drop(id)        - Lose a held message (the caller's Call() returns false)
+// net.ReleaseAll()    - Release all held messages in arrival order
+//
+// => Held messages stay held after Hold(nil); only newly sent requests are affected
+// => Release() waits for the handler to return so that releases take effect in order; if the
+//    handler synchronously sends another held request, release that one from another goroutine
+
+import (
+	"time"
+)
+
+type HoldPredicate func(svcMeth string, from int, to interface{}) bool
+
+type HeldMessage struct {
+	Id         int
+	SvcMeth    string
+	From       int         // Caller ID
+	To         interface{} // Server name
+	args       []byte
+	compressed bool
+	codec      Codec
+	decision   chan bool // Receives true to deliver the message and false to drop it
+	executed   chan bool // Closed once the server has executed the message
+}
+
+// Decode the arguments of a held message into v (a pointer to the argument type)
+func (m *HeldMessage) Decode(v interface{}) error {
+	return decodeArgs(m.codec, m.args, m.compressed, v)
+}
+
+func (rn *Network) Hold(pred HoldPredicate) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.holdPred = pred
+}
+
+func (rn *Network) Held() []*HeldMessage {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	held := make([]*HeldMessage, len(rn.held))
+	copy(held, rn.held)
+	return held
+}
+
+func (rn *Network) WaitForHeld(n int) {
+	for len(rn.Held()) < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (rn *Network) Release(id int) bool {
+	m := rn.unhold(id)
+	if m == nil {
+		return false
+	}
+
+	m.decision <- true
+	<-m.executed
+	return true
+}
+
+func (rn *Network) Drop(id int) bool {
+	m := rn.unhold(id)
+	if m == nil {
+		return false
+	}
+
+	m.decision <- false
+	return true
+}
+
+func (rn *Network) ReleaseAll() {
+	for _, m := range rn.Held() {
+		rn.Release(m.Id)
+	}
+}
+
+func (rn *Network) unhold(id int) *HeldMessage {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	for i, m := range rn.held {
+		if m.Id == id {
+			rn.held = append(rn.held[:i], rn.held[i+1:]...)
+			return m
+		}
+	}
+	return nil
+}
+
+// Park req if it matches the hold predicate; returns the held message (nil if it was not held)
+// and whether the message should be delivered
+func (rn *Network) holdRequest(req reqMsg, servername interface{}) (*HeldMessage, bool) {
+	rn.mu.Lock()
+	if rn.holdPred == nil || rn.holdPred(req.svcMeth, req.callerId, servername) == false {
+		rn.mu.Unlock()
+		return nil, true
+	}
+
+	rn.heldId++
+	m := &HeldMessage{}
+	m.Id = rn.heldId
+	m.SvcMeth = req.svcMeth
+	m.From = req.callerId
+	m.To = servername
+	m.args = req.args
+	m.compressed = req.compressed
+	m.codec = req.codec
+	m.decision = make(chan bool)
+	m.executed = make(chan bool)
+	rn.held = append(rn.held, m)
+	rn.mu.Unlock()
+
+	return m, <-m.decision
+}
@@ -0,0 +1,58 @@
+package network
+
+// RPC interceptor chains
+//
+// An interceptor wraps every RPC on a ClientEnd (around the whole Call()) or on a Server
+// (around the handler) so that cross-cutting features such as logging, metrics, fault
+// injection and authentication checks don't have to be added to every handler. Interceptors
+// run in the order they were added; each one calls next() to continue down the chain and may
+// inspect the reply once next() returns
+//
+// end.Use(interceptor) - Add a client-side interceptor
+// srv.Use(interceptor) - Add a server-side interceptor
+//
+// => Returning false without calling next() rejects the RPC: on the client Call() returns
+//    false without sending anything and on the server the caller's Call() returns false
+// => On the server, args is the decoded argument and reply is a pointer to the handler's reply
+// => For datagrams (end.Send()) the client-side reply is nil and the result of next() only
+//    says that the datagram was sent
+
+type CallInfo struct {
+	SvcMeth  string // i.e. "XPaxos.Replicate"
+	CallerId int
+}
+
+type Interceptor func(info CallInfo, args interface{}, reply interface{}, next func() bool) bool
+
+func (e *ClientEnd) Use(interceptor Interceptor) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.interceptors = append(e.interceptors, interceptor)
+}
+
+func (e *ClientEnd) getInterceptors() []Interceptor {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.interceptors
+}
+
+func (rs *Server) Use(interceptor Interceptor) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.interceptors = append(rs.interceptors, interceptor)
+}
+
+// Wrap invoke in the interceptors (the first interceptor is the outermost) and run the chain
+func intercept(interceptors []Interceptor, info CallInfo, args interface{}, reply interface{},
+	invoke func() bool) bool {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoke
+		invoke = func() bool {
+			return interceptor(info, args, reply, next)
+		}
+	}
+	return invoke()
+}
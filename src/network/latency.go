@@ -0,0 +1,176 @@
+package network
+
+// Per-link latency distributions
+//
+// The propagation delay of every RPC is drawn from the distribution of its link (caller ID,
+// server name) or, if the link has none, from the network's default distribution
+//
+// net.SetLatency(dist)                - Default distribution for all links (nil = no delay)
+// net.SetLinkLatency(from, to, dist)  - Distribution for a single directed link (nil = default)
+// net.SetJitter(jitter)               - Default jitter for all links (0 = none)
+// net.SetLinkJitter(from, to, jitter) - Jitter for a single directed link (negative = default)
+// net.SetAsynchronous(yes)            - Add delays well beyond DELTA to every link (i.e. before GST)
+//
+// => ConstantLatency{d}        - Always d
+// => UniformLatency{min, max}  - Uniform over [min, max)
+// => ExponentialLatency{mean}  - Exponential with the given mean
+// => ParetoLatency{min, shape} - Pareto heavy tail with scale min (shape > 1 for a finite mean);
+//                               build it with MakeParetoLatency(), which rejects min or shape <= 0
+//
+// Jitter adds uniform noise in [-jitter, +jitter] to every sampled delay (delays never drop
+// below zero) and applies whether or not the network is reliable, so latency can be noisy
+// without the network being lossy
+//
+// While the network is asynchronous every RPC is delayed by an extra ASYNCLATENCY sample on top of
+// the delay of its link, so the bound DELTA that XPaxos' synchronous group relies on no longer holds
+// (but every message is still delivered); tests alternate between asynchronous and synchronous
+// periods to model partial synchrony, where the network is synchronous after the global
+// stabilization time (GST)
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+var ASYNCLATENCY = ExponentialLatency{2 * DELTA * time.Millisecond} // Extra delay while asynchronous
+
+type LatencyDistribution interface {
+	Sample(r *rand.Rand) time.Duration
+}
+
+type ConstantLatency struct {
+	Delay time.Duration
+}
+
+type UniformLatency struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+type ExponentialLatency struct {
+	Mean time.Duration
+}
+
+type ParetoLatency struct {
+	Min   time.Duration
+	Shape float64
+}
+
+// Pareto distribution of scale min and the given shape, both > 0
+func MakeParetoLatency(min time.Duration, shape float64) (ParetoLatency, error) {
+	if min <= 0 {
+		return ParetoLatency{}, fmt.Errorf("pareto latency: scale %v is not positive", min)
+	}
+	if shape <= 0 || math.IsNaN(shape) {
+		return ParetoLatency{}, fmt.Errorf("pareto latency: shape %v is not positive", shape)
+	}
+	return ParetoLatency{min, shape}, nil
+}
+
+func (d ConstantLatency) Sample(r *rand.Rand) time.Duration {
+	return d.Delay
+}
+
+func (d UniformLatency) Sample(r *rand.Rand) time.Duration {
+	if d.Max <= d.Min {
+		return d.Min
+	}
+	return d.Min + time.Duration(r.Int63n(int64(d.Max-d.Min)))
+}
+
+func (d ExponentialLatency) Sample(r *rand.Rand) time.Duration {
+	return time.Duration(r.ExpFloat64() * float64(d.Mean))
+}
+
+func (d ParetoLatency) Sample(r *rand.Rand) time.Duration {
+	if d.Min <= 0 || d.Shape <= 0 { // Not built by MakeParetoLatency()
+		return 0
+	}
+	u := 1.0 - r.Float64() // Uniform over (0, 1]
+	return time.Duration(float64(d.Min) / math.Pow(u, 1.0/d.Shape))
+}
+
+func (rn *Network) SetLatency(dist LatencyDistribution) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.latency = dist
+}
+
+func (rn *Network) SetLinkLatency(from interface{}, to interface{}, dist LatencyDistribution) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if dist == nil {
+		delete(rn.linkLatency, link{from, to})
+	} else {
+		rn.linkLatency[link{from, to}] = dist
+	}
+}
+
+func (rn *Network) SetJitter(jitter time.Duration) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.jitter = jitter
+}
+
+func (rn *Network) SetAsynchronous(yes bool) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.asynchronous = yes
+}
+
+func (rn *Network) IsAsynchronous() bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.asynchronous
+}
+
+func (rn *Network) SetLinkJitter(from interface{}, to interface{}, jitter time.Duration) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if jitter < 0 {
+		delete(rn.linkJitter, link{from, to})
+	} else {
+		rn.linkJitter[link{from, to}] = jitter
+	}
+}
+
+// Draw the propagation delay of an RPC sent from caller to server
+func (rn *Network) sampleLatency(r *rand.Rand, from interface{}, to interface{}) time.Duration {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	dist, ok := rn.linkLatency[link{from, to}]
+	if ok == false {
+		dist = rn.latency
+	}
+
+	jitter, ok := rn.linkJitter[link{from, to}]
+	if ok == false {
+		jitter = rn.jitter
+	}
+
+	delay := time.Duration(0)
+	if dist != nil {
+		delay = dist.Sample(r)
+	}
+
+	if jitter > 0 {
+		delay += time.Duration(r.Int63n(int64(2*jitter)+1)) - jitter
+		if delay < 0 {
+			delay = 0
+		}
+	}
+
+	if rn.asynchronous {
+		delay += ASYNCLATENCY.Sample(r)
+	}
+	return delay
+}
@@ -0,0 +1,51 @@
+package network
+
+// Discovering servers in a running network
+//
+// Servers, ClientEnds and connections can be added at any time, including while RPCs are in
+// flight. Existing servers learn about newcomers (and departures) by watching the network's
+// membership instead of relying on a fixed list created before the cluster started
+//
+// net.Servers()      - Names of the servers currently in the network (in no particular order)
+// net.WatchServers() - Channel of membership events from now on
+//
+// => Events are delivered in order; a watcher must keep draining its channel since the network
+//    blocks once WATCHBUFFER events are pending
+// => The protocols' own membership (i.e. XPaxos' synchronous groups) does not change when a
+//    server is discovered; that is up to a reconfiguration protocol
+
+const WATCHBUFFER = 64 // Pending membership events per watcher
+
+type ServerEvent struct {
+	Name  interface{} // Server name
+	Added bool        // True if the server was added and false if it was deleted
+}
+
+func (rn *Network) Servers() []interface{} {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	names := make([]interface{}, 0, len(rn.servers))
+	for servername, server := range rn.servers {
+		if server != nil {
+			names = append(names, servername)
+		}
+	}
+	return names
+}
+
+func (rn *Network) WatchServers() <-chan ServerEvent {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	ch := make(chan ServerEvent, WATCHBUFFER)
+	rn.watchers = append(rn.watchers, ch)
+	return ch
+}
+
+// Deliver a membership event to every watcher (with rn.mu held so that events stay in order)
+func (rn *Network) notifyWatchers(event ServerEvent) {
+	for _, ch := range rn.watchers {
+		ch <- event
+	}
+}
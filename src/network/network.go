@@ -1,7 +1,8 @@
 package network
 
 // Channel-based RPC network adapted from Golang's net/rpc/server.go
-// Sends gob-encoded values to ensure that RPCs don't include references to program objects
+// Sends encoded values (gob by default) to ensure that RPCs don't include references to
+// program objects
 //
 // Originally written for MIT's 6.824 (Distributed Systems) course and modified for use as
 // a controlled network environment in our Princeton COS 518 (Advanced Computer Systems)
@@ -9,11 +10,32 @@ package network
 //
 // net := MakeNetwork()              - Holds network, clients, servers
 // end := net.MakeEnd(endname)       - Create a client endpoint to talk to one server
-// net.AddServer(servername, server) - Add a named server to network
+// net.AddServer(servername, server) - Add a named server to network (also while it is running)
 // net.DeleteServer(servername)      - Eliminate a named server from network
 // net.Connect(endname, servername)  - Connect a client to a server
+// net.StartRecording()              - Record the network's decisions for a later Replay(path)
+// net.Subscribe()                   - Structured events for every message (see events.go)
+// net.WatchServers()                - Discover servers added to (or deleted from) the network
 // net.Enable(endname, enabled)      - Enable/disable a client
+// net.EnableLink(from, to, enabled) - Enable/disable traffic in one direction between servers
 // net.Reliable(bool)                - False means drop/delay messages
+// net.Seed(seed)                    - Derive all random decisions about messages from a seed
+// net.SetCodec(codec)               - Select how RPC arguments and replies are serialized
+// net.SetCompression(threshold)     - Gzip RPC arguments above a size threshold
+// net.MethodStats()                 - Per-method RPC counts and latencies
+// net.GetUndelivered(servername)    - RPCs sent to a server that it has not started handling yet
+// net.Stats() / net.ResetStats()    - Snapshot / reset of all network statistics
+// net.SetClock(clock)               - Drive delays and timeouts from a (virtual) clock
+// net.SetLatency(dist)              - Draw propagation delays from a latency distribution
+// net.SetJitter(jitter)             - Add noise around the propagation delay of every link
+// net.SetAsynchronous(yes)          - Delay every RPC beyond DELTA (i.e. before GST)
+// net.Hold(pred)                    - Hold matching requests until the test releases them
+// net.SetConcurrency(server, n)     - Bound the concurrently executing handlers of a server
+// net.SetSendRate(sender, rate)     - Cap the RPCs per second a sender may emit
+// net.SetCorruptionRate(rate)       - Flip bytes in a percentage of requests and replies
+// net.SetTopology(topo)             - Per-pair latencies of a WAN topology (i.e. 3 datacenters)
+//
+// ServeSocket(path, server) / MakeSocketEnd(path) - The same servers over real Unix sockets
 //
 // end.Call("XPaxos.Replicate", args, &reply) - Send an RPC and wait for reply
 // => "XPaxos" is the name of the server struct to be called
@@ -21,6 +43,13 @@ package network
 // => Call() returns true to indicate that the server executed the request and the reply
 //    is valid (no cryptographic verification though!)
 // => Call() returns false if the network lost the request/reply or the server is down
+// => Call() also returns false (and the server goes on) if the server has no such service or
+//    method, or if the reply does not decode into the caller's reply type
+// => end.CallTimeout(..., timeout) returns false if no reply arrives within timeout
+// => end.Send(svcMeth, args, callerId) sends a datagram that has no reply and may be dropped
+// => end.CallStream(svcMeth, data, chunkSize, callerId) sends bulk data as a chunk stream
+// => *ClientEnd is the simulated network's Transport (see transport.go), which is all XPaxos
+//    and PBFT depend on
 // => It's OK to have multiple Call()'s in progress at the same time on the same ClientEnd
 // => Concurrent calls to Call() may be delivered to the server out of order since the network
 //    may reorder messages
@@ -29,8 +58,11 @@ package network
 // => The server RPC handler function must declare its reply arguments as pointers, so that
 //    their types exactly match the types of the arguments to Call()
 //
-// srv := MakeServer() - Holds a collection of services all sharing the same RPC dispatcher
-// srv.AddService(svc) - A server can have multiple services (i.e. XPaxos and k/v)
+// srv := MakeServer()   - Holds a collection of services all sharing the same RPC dispatcher
+// srv.AddService(svc)   - A server can have multiple services (i.e. XPaxos and k/v)
+// srv.Use(interceptor)  - Wrap every handler (see interceptor.go; end.Use() for the client side)
+// srv.SetConcurrency(n) - At most n handlers execute at once; excess RPCs queue (0 = unlimited)
+// => A handler that panics fails its RPC but not the server (see recover.go)
 // => Pass srv to net.AddServer()
 //
 // svc := MakeService(receiverObject) - Object's methods that will handle RPCs
@@ -38,12 +70,11 @@ package network
 // => Pass svc to srv.AddService()
 
 import (
-	"bytes"
-	"encoding/gob"
 	"log"
 	"math/rand"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -51,27 +82,99 @@ import (
 // ------------------------------- CALL FUNCTION ------------------------------
 //
 func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}, callerId int) bool {
-	// The return value indicates success; false means the server couldn't be contacted
+	return e.call(svcMeth, args, reply, callerId, NORMALPRIORITY, 0)
+}
+
+func (e *ClientEnd) CallPriority(svcMeth string, args interface{}, reply interface{}, callerId int,
+	priority int) bool {
+	return e.call(svcMeth, args, reply, callerId, priority, 0)
+}
+
+// Like Call() but gives up and returns false once timeout has passed on the network's clock, so
+// that the caller can apply its own retransmission policy; a late reply is discarded
+func (e *ClientEnd) CallTimeout(svcMeth string, args interface{}, reply interface{}, callerId int,
+	timeout time.Duration) bool {
+	return e.call(svcMeth, args, reply, callerId, NORMALPRIORITY, timeout)
+}
+
+func (e *ClientEnd) call(svcMeth string, args interface{}, reply interface{}, callerId int, priority int,
+	timeout time.Duration) bool {
+	info := CallInfo{svcMeth, callerId}
+	return intercept(e.getInterceptors(), info, args, reply, func() bool {
+		return e.roundTrip(svcMeth, args, reply, callerId, priority, timeout)
+	})
+}
+
+// Fire-and-forget datagram: the handler's reply is discarded and the message may be dropped
+// without the sender ever finding out (arguments that fail to encode are never sent)
+func (e *ClientEnd) Send(svcMeth string, args interface{}, callerId int) {
+	info := CallInfo{svcMeth, callerId}
+	intercept(e.getInterceptors(), info, args, nil, func() bool {
+		req, ok := e.makeRequest(svcMeth, args, callerId, NORMALPRIORITY)
+		if ok == false {
+			return false
+		}
+		e.net.recordIssue(svcMeth)
+		e.ch <- req
+		return true
+	})
+}
+
+// False (and nothing to send) if args fail to encode
+func (e *ClientEnd) makeRequest(svcMeth string, args interface{}, callerId int, priority int) (reqMsg, bool) {
 	req := reqMsg{}
+	req.id = e.net.nextMsgId()
 	req.endname = e.endname
 	req.svcMeth = svcMeth
 	req.argsType = reflect.TypeOf(args)
-	req.replyCh = make(chan replyMsg)
+	req.replyCh = make(chan replyMsg, 1) // Buffered so that a late or unwanted reply does not block the network
 	req.callerId = callerId
+	req.codec = e.net.getCodec()
+	req.priority = priority
+	req.traceId = traceOf(args)
+
+	data, err := req.codec.Encode(args)
+	if err != nil {
+		logger.With("svcMeth", svcMeth).Infof("Network: encode request: %v", err)
+		return req, false
+	}
+	req.args = data
+	e.net.compressArgs(&req)
+	return req, true
+}
 
-	qb := new(bytes.Buffer)
-	qe := gob.NewEncoder(qb)
-	qe.Encode(args)
-	req.args = qb.Bytes()
+func (e *ClientEnd) roundTrip(svcMeth string, args interface{}, reply interface{}, callerId int, priority int,
+	timeout time.Duration) bool {
+	// The return value indicates success; false means the server couldn't be contacted
+	req, ok := e.makeRequest(svcMeth, args, callerId, priority)
+	if ok == false {
+		return false
+	}
 
+	clock := e.net.GetClock()
+	start := clock.Now()
+	e.net.recordIssue(svcMeth)
 	e.ch <- req
 
-	rep := <-req.replyCh
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = clock.After(timeout)
+	}
+
+	var rep replyMsg
+	select {
+	case rep = <-req.replyCh:
+	case <-timer:
+		rep = replyMsg{false, nil}
+	}
+
+	e.net.recordCompletion(svcMeth, rep.ok, clock.Now().Sub(start))
 	if rep.ok {
-		rb := bytes.NewBuffer(rep.reply)
-		rd := gob.NewDecoder(rb)
-		if err := rd.Decode(reply); err != nil {
-			log.Fatalf("ClientEnd.Call(): decode reply: %v\n", err)
+		if err := req.codec.Decode(rep.reply, reply); err != nil {
+			if e.net.isCorrupting() == false { // Else the network garbled the reply beyond recognition
+				logger.With("svcMeth", svcMeth).Infof("Network: decode reply: %v", err)
+			}
+			return false
 		}
 		return true
 	} else {
@@ -91,9 +194,29 @@ func MakeNetwork() *Network {
 	rn.connections = map[interface{}](interface{}){}
 	rn.endCh = make(chan reqMsg)
 	rn.faultRate = map[interface{}]int{}
+	rn.codec = GobCodec{}
+	rn.methodStats = map[string]*MethodStats{}
+	rn.drops = map[interface{}]int{}
+	rn.delays = map[interface{}]time.Duration{}
+	rn.linkCorruption = map[link]int{}
+	rn.corrupted = map[interface{}]int{}
+	rn.undelivered = map[interface{}]int{}
+	rn.peakUndelivered = map[interface{}]int{}
+	rn.clock = RealClock{}
+	rn.linkLatency = map[link]LatencyDistribution{}
+	rn.linkJitter = map[link]time.Duration{}
+	rn.linkDisabled = map[link]bool{}
+	rn.inboxes = map[interface{}]*inbox{}
+	rn.sendRate = map[int]int{}
+	rn.concurrency = map[interface{}]int{}
+	rn.sendSlot = map[int]time.Time{}
+	rn.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	rn.replay = map[decisionKey]int64{}
+	rn.linkSeq = map[decisionKey]int{}
 
 	go func() { // Single goroutine to handle all ClientEnd.Call()'s
 		for xreq := range rn.endCh {
+			xreq.seed = rn.assignSeed(xreq) // In issue order so that recordings can be replayed
 			go rn.ProcessReq(xreq)
 		}
 	}()
@@ -112,6 +235,15 @@ func (rn *Network) Reliable(yes bool) {
 	rn.reliable = yes
 }
 
+// Messages get their seeds (see record.go) from seed in the order they are issued, so a run
+// issuing the same messages in the same order sees the same drops, delays and corruption
+func (rn *Network) Seed(seed int64) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.rand = rand.New(rand.NewSource(seed))
+}
+
 func (rn *Network) LongReordering(yes bool) {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
@@ -130,9 +262,7 @@ func (rn *Network) SetDelays(minDelay int, maxDelay int) {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
 
-	rn.simulNetDelay = true
-	rn.netDelayMin = minDelay
-	rn.netDelayMax = maxDelay
+	rn.latency = UniformLatency{time.Duration(minDelay) * time.Millisecond, time.Duration(maxDelay) * time.Millisecond}
 }
 
 func (rn *Network) ReadEndnameInfo(endname interface{}) (enabled bool, servername interface{},
@@ -150,6 +280,26 @@ func (rn *Network) ReadEndnameInfo(endname interface{}) (enabled bool, servernam
 	return
 }
 
+// Enable/disable traffic in one direction only (i.e. from can no longer reach to, but to can
+// still reach from); requests on a disabled link are never delivered and replies are lost
+func (rn *Network) EnableLink(from interface{}, to interface{}, enabled bool) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if enabled {
+		delete(rn.linkDisabled, link{from, to})
+	} else {
+		rn.linkDisabled[link{from, to}] = true
+	}
+}
+
+func (rn *Network) IsLinkEnabled(from interface{}, to interface{}) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.linkDisabled[link{from, to}] == false
+}
+
 func (rn *Network) IsServerDead(endname interface{}, servername interface{}, server *Server) bool {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
@@ -161,31 +311,65 @@ func (rn *Network) IsServerDead(endname interface{}, servername interface{}, ser
 }
 
 func (rn *Network) ProcessReq(req reqMsg) {
+	clock := rn.GetClock()
+	r := rand.New(rand.NewSource(req.seed)) // Source of all random decisions about this message
+
+	// Wait for a send slot if the sender is throttled
+	rn.awaitSendSlot(clock, req.callerId)
+
 	enabled, servername, server, reliable, longreordering := rn.ReadEndnameInfo(req.endname)
+	rn.emit(SENT, req, servername, 0)
+
+	if enabled && servername != nil && server != nil && rn.IsLinkEnabled(req.callerId, servername) {
+		// The request is undelivered until the server gets it (or the network drops it)
+		delivered := rn.recordSent(servername, server)
+		defer delivered()
 
-	if enabled && servername != nil && server != nil {
 		if reliable == false {
-			ms := (rand.Int() % 27) // Artifically create a short random delay
-			time.Sleep(time.Duration(ms) * time.Millisecond)
+			ms := (r.Int() % 27) // Artifically create a short random delay
+			rn.applyDelay(clock, req, servername, time.Duration(ms)*time.Millisecond)
 		}
 
-		if reliable == false && (rand.Int()%1000) < 100 {
+		if reliable == false && (r.Int()%1000) < 100 {
+			rn.recordDrop(req, servername)
 			req.replyCh <- replyMsg{false, nil} // Drop the request and return as if timeout
 			return
 		}
 
-		if (rand.Int() % 100) < rn.faultRate[servername] { // Failure when sending to destination
-			dPrintf("Network: couldn't connect XPaxos server (%d) to XPaxos server (%d)\n", req.callerId, servername)
-			time.Sleep(time.Duration(DELTA) * time.Millisecond)
+		if (r.Int() % 100) < rn.faultRate[servername] { // Failure when sending to destination
+			logger.With("from", req.callerId, "to", servername).Debugf("Network: dropped request")
+			clock.Sleep(time.Duration(DELTA) * time.Millisecond)
+			rn.recordDrop(req, servername)
 			req.replyCh <- replyMsg{false, nil} // Drop the request and return as if timeout
 			return
 		}
 
+		// Wait for the test to release the request if it matches the hold predicate
+		held, deliver := rn.holdRequest(req, servername)
+		if deliver == false {
+			rn.recordDrop(req, servername)
+			req.replyCh <- replyMsg{false, nil} // Dropped by the test
+			return
+		}
+
+		// Wait for the server's inbox to admit the request (only if its delivery rate is limited)
+		rn.awaitDelivery(servername, req.priority)
+
+		corrupted := false
+		if req.args, corrupted = rn.maybeCorrupt(r, req.callerId, servername, req.args); corrupted {
+			rn.emit(CORRUPTED, req, servername, 0)
+		}
+
 		// Execute the request in a separate thread so that we can periodically check if the server
 		// has been killed and the RPC should get a failure reply
 		ech := make(chan replyMsg)
+		delivered()
+		rn.emit(DELIVERED, req, servername, 0)
 		go func() {
 			r := server.dispatch(req)
+			if held != nil {
+				close(held.executed)
+			}
 			ech <- r
 		}()
 
@@ -197,14 +381,18 @@ func (rn *Network) ProcessReq(req reqMsg) {
 		for replyOK == false && serverDead == false {
 			select {
 			case reply = <-ech:
-				if (rand.Int() % 100) < rn.faultRate[req.callerId] { // Failure when sending to source
-					dPrintf("Network: couldn't connect XPaxos server (%d) to XPaxos server (%d)\n", servername, req.callerId)
-					time.Sleep(time.Duration(DELTA) * time.Millisecond)
+				if (r.Int() % 100) < rn.faultRate[req.callerId] { // Failure when sending to source
+					logger.With("from", servername, "to", req.callerId).Debugf("Network: dropped reply")
+					clock.Sleep(time.Duration(DELTA) * time.Millisecond)
+					rn.recordDrop(req, servername)
 					req.replyCh <- replyMsg{false, nil} // Drop the request and return as if timeout
 					return
 				}
+				if reply.reply, corrupted = rn.maybeCorrupt(r, servername, req.callerId, reply.reply); corrupted {
+					rn.emit(CORRUPTED, req, servername, 0)
+				}
 				replyOK = true
-			case <-time.After(100 * time.Millisecond):
+			case <-clock.After(100 * time.Millisecond):
 				serverDead = rn.IsServerDead(req.endname, servername, server)
 			}
 		}
@@ -214,30 +402,37 @@ func (rn *Network) ProcessReq(req reqMsg) {
 		serverDead = rn.IsServerDead(req.endname, servername, server)
 
 		// network propagation delay
-		if rn.simulNetDelay {
-			ms := rn.netDelayMin+(rand.Int() % (rn.netDelayMax - rn.netDelayMin))
-			time.Sleep(time.Duration(ms) * time.Millisecond)
+		if delay := rn.sampleLatency(r, req.callerId, servername); delay > 0 {
+			rn.applyDelay(clock, req, servername, delay)
 		}
 
 		if replyOK == false || serverDead == true {
+			rn.recordDrop(req, servername)
 			req.replyCh <- replyMsg{false, nil} // Server was killed while we were waiting; return error
-		} else if reliable == false && (rand.Int()%1000) < 100 {
+		} else if reliable == false && (r.Int()%1000) < 100 {
+			rn.recordDrop(req, servername)
 			req.replyCh <- replyMsg{false, nil} // Drop the reply and return as if timeout
-		} else if longreordering == true && rand.Intn(900) < 600 {
-			ms := 200 + rand.Intn(1+rand.Intn(2000)) // Artificially delay the response for a while
-			time.Sleep(time.Duration(ms) * time.Millisecond)
+		} else if rn.IsLinkEnabled(servername, req.callerId) == false {
+			rn.recordDrop(req, servername)
+			req.replyCh <- replyMsg{false, nil} // Server executed the request but cannot reach the caller
+		} else if longreordering == true && r.Intn(900) < 600 {
+			ms := 200 + r.Intn(1+r.Intn(2000)) // Artificially delay the response for a while
+			rn.applyDelay(clock, req, servername, time.Duration(ms)*time.Millisecond)
+			rn.emit(REPLIED, req, servername, 0)
 			req.replyCh <- reply
 		} else {
+			rn.emit(REPLIED, req, servername, 0)
 			req.replyCh <- reply
 		}
 	} else { // Simulate no reply and an eventual timeout
 		ms := 0
 		if rn.longDelays {
-			ms = (rand.Int() % 7000)
+			ms = (r.Int() % 7000)
 		} else {
-			ms = (rand.Int() % 100)
+			ms = (r.Int() % 100)
 		}
-		time.Sleep(time.Duration(ms) * time.Millisecond)
+		clock.Sleep(time.Duration(ms) * time.Millisecond)
+		rn.recordDrop(req, servername)
 		req.replyCh <- replyMsg{false, nil}
 	}
 }
@@ -253,6 +448,7 @@ func (rn *Network) MakeEnd(endname interface{}) *ClientEnd {
 	e := &ClientEnd{}
 	e.endname = endname
 	e.ch = rn.endCh
+	e.net = rn
 	rn.ends[endname] = e
 	rn.enabled[endname] = false
 	rn.connections[endname] = nil
@@ -264,13 +460,32 @@ func (rn *Network) AddServer(servername interface{}, rs *Server) {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
 
+	if limit, ok := rn.concurrency[servername]; ok {
+		rs.SetConcurrency(limit)
+	}
 	rn.servers[servername] = rs
+	rn.notifyWatchers(ServerEvent{servername, true})
+}
+
+// Limit the concurrently executing handlers of a server, including servers added later under
+// the same name (i.e. restarted servers)
+func (rn *Network) SetConcurrency(servername interface{}, limit int) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.concurrency[servername] = limit
+	if rs := rn.servers[servername]; rs != nil {
+		rs.SetConcurrency(limit)
+	}
 }
 
 func (rn *Network) DeleteServer(servername interface{}) {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
 
+	if rn.servers[servername] != nil {
+		rn.notifyWatchers(ServerEvent{servername, false})
+	}
 	rn.servers[servername] = nil
 }
 
@@ -298,12 +513,22 @@ func (rn *Network) GetCount(servername interface{}) int {
 	return svr.GetCount()
 }
 
+// Get a server's count of request and reply bytes
+func (rn *Network) GetBytes(servername interface{}) int64 {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	svr := rn.servers[servername]
+	return svr.GetBytes()
+}
+
 //
 // ----------------------------- SERVER FUNCTIONS -----------------------------
 //
 func MakeServer() *Server {
 	rs := &Server{}
 	rs.services = map[string]*Service{}
+	rs.slots = sync.NewCond(&rs.mu)
 	return rs
 }
 
@@ -316,24 +541,41 @@ func (rs *Server) AddService(svc *Service) {
 func (rs *Server) dispatch(req reqMsg) replyMsg {
 	rs.mu.Lock()
 	rs.count += 1
+	rs.bytes += int64(len(req.args))
 
 	dot := strings.LastIndex(req.svcMeth, ".")
-	serviceName := req.svcMeth[:dot]
-	methodName := req.svcMeth[dot+1:]
+	serviceName, methodName := "", req.svcMeth
+	if dot >= 0 {
+		serviceName, methodName = req.svcMeth[:dot], req.svcMeth[dot+1:]
+	}
 
 	service, ok := rs.services[serviceName]
+	interceptors := rs.interceptors
 
 	rs.mu.Unlock()
 
 	if ok {
-		return service.dispatch(methodName, req)
+		rs.acquire()
+		rep, panicked := service.dispatch(methodName, req, interceptors)
+		rs.release()
+
+		rs.mu.Lock()
+		rs.bytes += int64(len(rep.reply))
+		if panicked {
+			rs.panics += 1
+		}
+		rs.mu.Unlock()
+
+		return rep
 	} else {
+		rs.mu.Lock()
 		choices := []string{}
 		for k, _ := range rs.services {
 			choices = append(choices, k)
 		}
-		log.Fatalf("labrpc.Server.dispatch(): unknown service %v in %v.%v; expecting one of %v\n",
-			serviceName, serviceName, methodName, choices)
+		rs.mu.Unlock()
+		logger.With("from", req.callerId, "svcMeth", req.svcMeth).Infof(
+			"Network: unknown service %q; expecting one of %v", serviceName, choices)
 		return replyMsg{false, nil}
 	}
 }
@@ -344,6 +586,56 @@ func (rs *Server) GetCount() int {
 	return rs.count
 }
 
+func (rs *Server) GetBytes() int64 {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.bytes
+}
+
+func (rs *Server) SetConcurrency(limit int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.limit = limit
+	rs.slots.Broadcast() // Queued RPCs may fit under the new limit
+}
+
+// Number of RPCs waiting for a free handler slot
+func (rs *Server) GetQueued() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.queued
+}
+
+// Wait for a free handler slot
+func (rs *Server) acquire() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.queued++
+	for rs.limit > 0 && rs.active >= rs.limit {
+		rs.slots.Wait()
+	}
+	rs.queued--
+	rs.active++
+}
+
+func (rs *Server) release() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.active--
+	rs.slots.Signal()
+}
+
+func (rs *Server) resetStats() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.count = 0
+	rs.bytes = 0
+	rs.panics = 0
+}
+
 //
 // ----------------------------- SERVICE FUNCTIONS ----------------------------
 //
@@ -373,37 +665,56 @@ func MakeService(rcvr interface{}) *Service { // A single server may have more t
 	return svc
 }
 
-func (svc *Service) dispatch(methname string, req reqMsg) replyMsg {
+// Also returns whether the handler panicked (see recover.go)
+func (svc *Service) dispatch(methname string, req reqMsg, interceptors []Interceptor) (replyMsg, bool) {
 	if method, ok := svc.methods[methname]; ok { // Prepare space into which to read the argument
-		args := reflect.New(req.argsType) // The value's type will be a pointer to req.argsType
+		argsType := req.argsType
+		if argsType == nil { // Requests from a socket (see socket.go) carry no Go type
+			argsType = method.Type.In(1)
+		}
+		args := reflect.New(argsType) // The value's type will be a pointer to argsType
 
-		// (1) Decode the argument
-		ab := bytes.NewBuffer(req.args)
-		ad := gob.NewDecoder(ab)
-		ad.Decode(args.Interface())
+		// (1) Decode the argument; a handler never runs on a message that did not decode, i.e. one
+		// garbled by the network or a malformed frame read off a socket
+		if err := decodeArgs(req.codec, req.args, req.compressed, args.Interface()); err != nil {
+			logger.With("from", req.callerId, "svcMeth", req.svcMeth).Debugf("Network: decode request: %v", err)
+			return replyMsg{false, nil}, false
+		}
 
 		// (2) Allocate space for the reply
 		replyType := method.Type.In(2)
 		replyType = replyType.Elem()
 		replyv := reflect.New(replyType)
 
-		// (3) Call the method
+		// (3) Call the method (wrapped in the server's interceptors), recovering from its panics
 		function := method.Func
-		function.Call([]reflect.Value{svc.rcvr, args.Elem(), replyv})
+		info := CallInfo{req.svcMeth, req.callerId}
+		var panicked interface{}
+		ok := intercept(interceptors, info, args.Elem().Interface(), replyv.Interface(), func() bool {
+			panicked = recovered(info, args.Elem().Interface(), func() {
+				function.Call([]reflect.Value{svc.rcvr, args.Elem(), replyv})
+			})
+			return panicked == nil
+		})
+		if ok == false {
+			return replyMsg{false, nil}, panicked != nil // Rejected by an interceptor or invalid
+		}
 
-		// (4) Encode the reply
-		rb := new(bytes.Buffer)
-		re := gob.NewEncoder(rb)
-		re.EncodeValue(replyv)
+		// (4) Encode the reply; one that does not encode fails the call like a lost reply
+		rb, err := req.codec.Encode(replyv.Interface())
+		if err != nil {
+			logger.With("from", req.callerId, "svcMeth", req.svcMeth).Infof("Network: encode reply: %v", err)
+			return replyMsg{false, nil}, false
+		}
 
-		return replyMsg{true, rb.Bytes()}
+		return replyMsg{true, rb}, false
 	} else {
 		choices := []string{}
 		for k, _ := range svc.methods {
 			choices = append(choices, k)
 		}
-		log.Fatalf("labrpc.Service.dispatch(): unknown method %v in %v; expecting one of %v\n",
-			methname, req.svcMeth, choices)
-		return replyMsg{false, nil}
+		logger.With("from", req.callerId, "svcMeth", req.svcMeth).Infof(
+			"Network: unknown method %q; expecting one of %v", methname, choices)
+		return replyMsg{false, nil}, false
 	}
 }
@@ -0,0 +1,130 @@
+package network
+
+// Priority lanes for inbound messages
+//
+// By default a server receives every message as soon as the network delivers it. Once a
+// delivery rate is set, a server can only take rate messages per second off the wire and
+// the excess queues up in its inbox; queued messages are handed to the server highest
+// priority first (and in arrival order within a priority)
+//
+// end.CallPriority(svcMeth, args, reply, callerId, priority) - Call() with a message priority
+// net.SetDeliveryRate(servername, rate)                       - Messages per second (0 = unlimited)
+// net.GetBacklog(servername)                                  - Number of queued messages
+
+import (
+	"time"
+)
+
+const ( // Message priorities (higher priorities are delivered first)
+	LOWPRIORITY    = iota
+	NORMALPRIORITY = iota
+	HIGHPRIORITY   = iota
+)
+
+type inbox struct {
+	rate    int // Messages per second (0 = unlimited)
+	queue   []*delivery
+	running bool      // Whether a goroutine is pumping the inbox
+	notify  chan bool // Wakes up the pump when a message is queued
+}
+
+type delivery struct {
+	priority int
+	ready    chan bool // Closed when the message may be handed to the server
+}
+
+func (rn *Network) getInbox(servername interface{}) *inbox {
+	ib, ok := rn.inboxes[servername]
+	if ok == false {
+		ib = &inbox{}
+		ib.queue = make([]*delivery, 0)
+		ib.notify = make(chan bool, 1)
+		rn.inboxes[servername] = ib
+	}
+	return ib
+}
+
+func (rn *Network) SetDeliveryRate(servername interface{}, rate int) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	ib := rn.getInbox(servername)
+	ib.rate = rate
+
+	if rate > 0 && ib.running == false {
+		ib.running = true
+		go rn.pumpInbox(servername, ib)
+	}
+
+	select { // Wake up the pump so that it notices the new rate
+	case ib.notify <- true:
+	default:
+	}
+}
+
+func (rn *Network) GetBacklog(servername interface{}) int {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if ib, ok := rn.inboxes[servername]; ok {
+		return len(ib.queue)
+	}
+	return 0
+}
+
+// Block until a message of the given priority may be handed to the server
+func (rn *Network) awaitDelivery(servername interface{}, priority int) {
+	rn.mu.Lock()
+	ib, ok := rn.inboxes[servername]
+	if ok == false || ib.rate == 0 {
+		rn.mu.Unlock()
+		return
+	}
+
+	d := &delivery{priority, make(chan bool)}
+	ib.queue = append(ib.queue, d)
+
+	select {
+	case ib.notify <- true:
+	default:
+	}
+	rn.mu.Unlock()
+
+	<-d.ready
+}
+
+func (rn *Network) pumpInbox(servername interface{}, ib *inbox) {
+	for {
+		rn.mu.Lock()
+		if ib.rate == 0 { // Unlimited again; flush the inbox
+			for _, d := range ib.queue {
+				close(d.ready)
+			}
+			ib.queue = make([]*delivery, 0)
+			ib.running = false
+			rn.mu.Unlock()
+			return
+		}
+
+		if len(ib.queue) == 0 {
+			rn.mu.Unlock()
+			<-ib.notify
+			continue
+		}
+
+		next := 0
+		for i, d := range ib.queue {
+			if d.priority > ib.queue[next].priority {
+				next = i
+			}
+		}
+
+		close(ib.queue[next].ready)
+		ib.queue = append(ib.queue[:next], ib.queue[next+1:]...)
+		interval := time.Second / time.Duration(ib.rate)
+		clock := rn.clock
+		rn.mu.Unlock()
+
+		clock.Sleep(interval)
+	}
+}
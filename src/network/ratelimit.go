@@ -0,0 +1,50 @@
+package network
+
+// Per-sender rate limiting
+//
+// A throttled sender (caller ID) may only put rate RPCs per second on the wire; excess RPCs
+// wait at the sender until their slot comes up (on the network's clock), which models an
+// overloaded or throttled node
+//
+// net.SetSendRate(sender, rate) - RPCs per second sender may emit (0 = unlimited)
+//
+// => The limit applies to everything the sender emits (Call(), Send() and streams)
+
+import (
+	"time"
+)
+
+func (rn *Network) SetSendRate(sender int, rate int) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if rate <= 0 {
+		delete(rn.sendRate, sender)
+		delete(rn.sendSlot, sender)
+	} else {
+		rn.sendRate[sender] = rate
+	}
+}
+
+// Block until sender may emit another RPC
+func (rn *Network) awaitSendSlot(clock Clock, sender int) {
+	rn.mu.Lock()
+	rate, ok := rn.sendRate[sender]
+	if ok == false {
+		rn.mu.Unlock()
+		return
+	}
+
+	// Reserve the next free slot; slots are spaced 1/rate seconds apart
+	now := clock.Now()
+	slot := rn.sendSlot[sender]
+	if slot.Before(now) {
+		slot = now
+	}
+	rn.sendSlot[sender] = slot.Add(time.Second / time.Duration(rate))
+	rn.mu.Unlock()
+
+	if wait := slot.Sub(now); wait > 0 {
+		clock.Sleep(wait)
+	}
+}
@@ -0,0 +1,44 @@
+package network
+
+// Recovery from panicking RPC handlers
+//
+// Every handler registered with srv.AddService() runs under a recover() layer, so that a message
+// that makes a handler panic (i.e. an index taken from a corrupted or adversarial message) fails
+// that RPC only instead of crashing the process, and the server goes on handling the next ones
+//
+// srv.GetPanics() - Number of RPCs whose handler panicked since the last reset
+//
+// => The panic is logged at INFO ("Network: handler panicked") with the method, the caller, the
+//    decoded message and the stack of the handler
+// => The message is treated as invalid: the RPC is rejected like one refused by an interceptor
+//    (the caller's Call() returns false and no reply is encoded), and interceptors see next()
+//    return false; ServerStats count such RPCs as Panics
+// => Only the handler is recovered, not the interceptors around it
+// => Deferred calls of the handler run before the panic is recovered, but a lock that the handler
+//    releases without defer stays held, which blocks the handlers that need it next; the XPaxos
+//    and PBFT handlers release their lock with defer (XPaxos handlers that wait for other servers
+//    release it around the wait only, see unlocked() in xpaxos/util.go), so a server goes on
+//    committing after one of its handlers panicked
+
+import (
+	rdebug "runtime/debug"
+)
+
+// Calls handler, returning the value it panicked with (nil if it returned)
+func recovered(info CallInfo, args interface{}, handler func()) (value interface{}) {
+	defer func() {
+		if value = recover(); value != nil {
+			logger.With("from", info.CallerId, "svcMeth", info.SvcMeth).Infof(
+				"Network: handler panicked: %v (message %+v)\n%s", value, args, rdebug.Stack())
+		}
+	}()
+
+	handler()
+	return nil
+}
+
+func (rs *Server) GetPanics() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.panics
+}
@@ -0,0 +1,176 @@
+package network
+
+// Real transport over Unix domain sockets (i.e. one OS process per replica)
+//
+// ss, err := ServeSocket(path, server) - Serve the services of a Server on a Unix socket
+// ss.Close()                           - Stop serving (connections in progress are cut)
+// end := MakeSocketEnd(path)           - A Transport that calls the server listening on path
+//
+// => One connection per RPC (like call() in paxos.go): the request is the method name, the caller
+//    ID and the arguments; the reply says whether the handler ran and carries its reply (both
+//    encoded with GobCodec)
+// => A call fails (returns false) if nothing listens on path, i.e. the replica process was killed
+// => Arguments that do not decode into the handler's argument type fail the call without running
+//    the handler, like messages the simulated network garbled
+// => Failing accepts (e.g. out of file descriptors) are retried with an exponential backoff of up
+//    to a second, like net/http.Server
+// => There is no simulated network in between, so none of the Network's faults, delays or
+//    statistics apply; priorities are ignored and Send() is a Call() whose reply is discarded
+// => CallStream() sends its chunks as Calls with a random stream ID, since the stream IDs of
+//    different sender processes cannot come from one counter (see stream.go)
+
+import (
+	"encoding/gob"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type SocketEnd struct {
+	path string
+}
+
+type SocketServer struct {
+	mu       sync.Mutex
+	listener net.Listener
+	server   *Server
+	closed   bool
+}
+
+type socketRequest struct {
+	SvcMeth  string
+	CallerId int
+	Args     []byte
+}
+
+type socketReply struct {
+	Ok    bool
+	Reply []byte
+}
+
+var _ Transport = &SocketEnd{} // Sockets are a Transport too
+
+func MakeSocketEnd(path string) *SocketEnd {
+	end := &SocketEnd{}
+	end.path = path
+	return end
+}
+
+func (end *SocketEnd) Call(svcMeth string, args interface{}, reply interface{}, callerId int) bool {
+	return end.CallTimeout(svcMeth, args, reply, callerId, 0)
+}
+
+func (end *SocketEnd) CallPriority(svcMeth string, args interface{}, reply interface{}, callerId int,
+	priority int) bool {
+	return end.CallTimeout(svcMeth, args, reply, callerId, 0)
+}
+
+// A timeout of zero waits for the reply for as long as the connection stays up
+func (end *SocketEnd) CallTimeout(svcMeth string, args interface{}, reply interface{}, callerId int,
+	timeout time.Duration) bool {
+	codec := GobCodec{}
+
+	data, err := codec.Encode(args)
+	if err != nil {
+		return false
+	}
+
+	conn, err := net.Dial("unix", end.path)
+	if err != nil {
+		return false // Nobody listens (i.e. the process was killed)
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	if err := gob.NewEncoder(conn).Encode(socketRequest{svcMeth, callerId, data}); err != nil {
+		return false
+	}
+
+	rep := socketReply{}
+	if err := gob.NewDecoder(conn).Decode(&rep); err != nil || rep.Ok == false {
+		return false
+	}
+
+	if reply != nil {
+		if err := codec.Decode(rep.Reply, reply); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (end *SocketEnd) Send(svcMeth string, args interface{}, callerId int) {
+	go end.Call(svcMeth, args, nil, callerId)
+}
+
+func ServeSocket(path string, server *Server) (*SocketServer, error) {
+	os.Remove(path) // Left behind by a killed process
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := &SocketServer{}
+	ss.listener = listener
+	ss.server = server
+
+	go func() {
+		var backoff time.Duration // Like net/http.Server, back off while Accept() fails (e.g. EMFILE)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ss.isClosed() {
+					return
+				}
+				if backoff == 0 {
+					backoff = 5 * time.Millisecond
+				} else if backoff *= 2; backoff > time.Second {
+					backoff = time.Second
+				}
+				time.Sleep(backoff)
+				continue
+			}
+			backoff = 0
+			go ss.serve(conn)
+		}
+	}()
+
+	return ss, nil
+}
+
+func (ss *SocketServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	req := socketRequest{}
+	if err := gob.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	msg := reqMsg{}
+	msg.svcMeth = req.SvcMeth
+	msg.args = req.Args
+	msg.callerId = req.CallerId
+	msg.codec = GobCodec{} // argsType stays nil: the service takes it from the handler's signature
+
+	rep := ss.server.dispatch(msg)
+	gob.NewEncoder(conn).Encode(socketReply{rep.ok, rep.reply})
+}
+
+func (ss *SocketServer) Close() {
+	ss.mu.Lock()
+	ss.closed = true
+	ss.mu.Unlock()
+
+	ss.listener.Close()
+}
+
+func (ss *SocketServer) isClosed() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.closed
+}
@@ -0,0 +1,205 @@
+package network
+
+// Per-method RPC statistics
+//
+// Every ClientEnd.Call() is accounted to its method name (i.e. "XPaxos.Prepare") when it is
+// issued and again when it completes together with the time between issuing the RPC and
+// receiving the reply (or the failure)
+//
+// net.MethodStats() - Snapshot of the statistics of every method called so far
+// net.Stats()       - Snapshot of all network statistics (per server, per method, compression)
+// net.ResetStats()  - Reset all statistics (i.e. between the phases of a test)
+// net.GetUndelivered(servername) - Backlog of a server: RPCs sent to it that it has not started
+//                                  handling yet
+//
+// => Datagrams (end.Send()) are counted when they are sent but never complete
+// => The backlog of a server counts the RPCs the network delays, holds or queues in the server's
+//    inbox (see priority.go) and those waiting for a free handler slot of the server (see
+//    srv.SetConcurrency()); ServerStats report it and its peak since the last reset, so that a
+//    server that cannot keep up with its load stands out of a benchmark
+
+import (
+	"sync"
+	"time"
+)
+
+type NetworkStats struct {
+	Servers     map[interface{}]ServerStats // By server name
+	Methods     map[string]MethodStats      // By method name (i.e. "XPaxos.Prepare")
+	Compression CompressionStats
+	Drops       int           // Requests and replies lost by the network (including unknown servers)
+	Corrupted   int           // Requests and replies corrupted by the network
+	Delay       time.Duration // Cumulative delay applied to all RPCs
+}
+
+type ServerStats struct {
+	RPCs        int           // Incoming RPCs executed by the server
+	Bytes       int64         // Request and reply bytes
+	Drops       int           // Requests to and replies from the server lost by the network
+	Corrupted   int           // Requests and replies received by the server that were corrupted
+	Delay       time.Duration // Cumulative delay applied to RPCs to the server
+	Backlog     int           // RPCs sent to the server that it has not started handling yet
+	PeakBacklog int           // Highest backlog since the last reset
+	Panics      int           // RPCs whose handler panicked (see recover.go)
+}
+
+type MethodStats struct {
+	Count     int           // Number of RPCs issued
+	Completed int           // Number of RPCs for which Call() returned
+	Failed    int           // Number of RPCs for which Call() returned false
+	Latency   time.Duration // Cumulative latency of all completed RPCs
+}
+
+func (ms MethodStats) MeanLatency() time.Duration {
+	if ms.Completed == 0 {
+		return 0
+	}
+	return ms.Latency / time.Duration(ms.Completed)
+}
+
+func (rn *Network) getMethodStats(svcMeth string) *MethodStats {
+	ms, ok := rn.methodStats[svcMeth]
+	if ok == false {
+		ms = &MethodStats{}
+		rn.methodStats[svcMeth] = ms
+	}
+	return ms
+}
+
+func (rn *Network) recordIssue(svcMeth string) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.getMethodStats(svcMeth).Count++
+}
+
+func (rn *Network) recordCompletion(svcMeth string, ok bool, latency time.Duration) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	ms := rn.getMethodStats(svcMeth)
+	ms.Completed++
+	if ok == false {
+		ms.Failed++
+	}
+	ms.Latency += latency
+}
+
+func (rn *Network) MethodStats() map[string]MethodStats {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	stats := make(map[string]MethodStats, len(rn.methodStats))
+	for svcMeth, ms := range rn.methodStats {
+		stats[svcMeth] = *ms
+	}
+	return stats
+}
+
+func (rn *Network) recordDrop(req reqMsg, servername interface{}) {
+	rn.mu.Lock()
+	rn.drops[servername]++
+	rn.mu.Unlock()
+
+	rn.emit(DROPPED, req, servername, 0)
+}
+
+// Counts a request to servername as undelivered; the function returned (idempotent) counts it
+// as delivered. The peak is sampled here, since a backlog only grows when RPCs are sent
+func (rn *Network) recordSent(servername interface{}, server *Server) func() {
+	rn.mu.Lock()
+	rn.undelivered[servername]++
+	if backlog := rn.undelivered[servername] + server.GetQueued(); backlog > rn.peakUndelivered[servername] {
+		rn.peakUndelivered[servername] = backlog
+	}
+	rn.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			rn.mu.Lock()
+			rn.undelivered[servername]--
+			rn.mu.Unlock()
+		})
+	}
+}
+
+func (rn *Network) GetUndelivered(servername interface{}) int {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	backlog := rn.undelivered[servername]
+	if server := rn.servers[servername]; server != nil {
+		backlog += server.GetQueued()
+	}
+	return backlog
+}
+
+// Sleep for d on clock and account the delay to servername
+func (rn *Network) applyDelay(clock Clock, req reqMsg, servername interface{}, d time.Duration) {
+	rn.mu.Lock()
+	rn.delays[servername] += d
+	rn.mu.Unlock()
+
+	rn.emit(DELAYED, req, servername, d)
+	clock.Sleep(d)
+}
+
+func (rn *Network) Stats() NetworkStats {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	stats := NetworkStats{}
+	stats.Servers = map[interface{}]ServerStats{}
+	stats.Methods = make(map[string]MethodStats, len(rn.methodStats))
+	stats.Compression = rn.compressStats
+
+	for servername, server := range rn.servers {
+		if server != nil {
+			ss := ServerStats{}
+			ss.RPCs = server.GetCount()
+			ss.Bytes = server.GetBytes()
+			ss.Drops = rn.drops[servername]
+			ss.Delay = rn.delays[servername]
+			ss.Corrupted = rn.corrupted[servername]
+			ss.Backlog = rn.undelivered[servername] + server.GetQueued()
+			ss.PeakBacklog = rn.peakUndelivered[servername]
+			ss.Panics = server.GetPanics()
+			stats.Servers[servername] = ss
+		}
+	}
+
+	for svcMeth, ms := range rn.methodStats {
+		stats.Methods[svcMeth] = *ms
+	}
+
+	for _, drops := range rn.drops {
+		stats.Drops += drops
+	}
+	for _, delay := range rn.delays {
+		stats.Delay += delay
+	}
+	for _, corrupted := range rn.corrupted {
+		stats.Corrupted += corrupted
+	}
+
+	return stats
+}
+
+func (rn *Network) ResetStats() {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	for _, server := range rn.servers {
+		if server != nil {
+			server.resetStats()
+		}
+	}
+
+	rn.methodStats = map[string]*MethodStats{}
+	rn.drops = map[interface{}]int{}
+	rn.delays = map[interface{}]time.Duration{}
+	rn.corrupted = map[interface{}]int{}
+	rn.peakUndelivered = map[interface{}]int{}
+	rn.compressStats = CompressionStats{}
+}
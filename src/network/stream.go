@@ -0,0 +1,237 @@
+package network
+
+// Chunked streaming for bulk transfers (i.e. state transfer and snapshot installation)
+//
+// Instead of encoding one gigantic blob in a single Call(), the sender splits the payload into
+// chunks that are sent as a sequence of ordinary RPCs. The receiving service declares a handler
+// that takes a StreamChunk and feeds every chunk into a StreamBuffer, which hands back the full
+// payload once the last missing chunk has arrived
+//
+// end.CallStream(svcMeth, data, chunkSize, callerId) - Send data chunk by chunk (any Transport)
+// buf := MakeStreamBuffer()                          - Reassembles streams on the server side
+// buf.Add(chunk)                                     - Returns (data, true) once a stream completes
+//
+// => The handler must have the signature Handler(args StreamChunk, reply *StreamReply)
+// => CallStream() returns false as soon as a chunk is lost; the sender retries with a fresh
+//    stream and the receiver discards the abandoned one with buf.Drop(sender)
+// => The first chunk of a stream fixes its number of chunks (at most MAXCHUNKS, so a sender
+//    cannot make the receiver allocate any amount); later chunks that claim another number are
+//    dropped
+// => A sender has at most MAXSTREAMS incomplete streams at a receiver; a new one discards its
+//    oldest, so abandoned streams cost a receiver a bounded amount whether it drops them or not
+// => The receiver remembers the last CLOSEDSTREAMS streams it completed or dropped, and discards
+//    the chunks of a closed stream that arrive late or twice instead of opening it again
+
+import (
+	"math/rand"
+	"sync"
+)
+
+const CHUNKSIZE = 64 * 1024 // Default chunk size in bytes
+const MAXCHUNKS = 16 * 1024 // Chunks of the longest stream a receiver buffers (1 GiB of CHUNKSIZE)
+const MAXSTREAMS = 4        // Incomplete streams a receiver buffers per sender
+const CLOSEDSTREAMS = 64    // Closed streams a receiver remembers
+
+type StreamChunk struct {
+	StreamId int64 // Unique per sender
+	Sender   int   // Caller ID of the sender
+	Seq      int   // Position of the chunk in the stream
+	Total    int   // Number of chunks in the stream
+	Data     []byte
+}
+
+type StreamReply struct {
+	Received int // Number of distinct chunks the receiver holds for this stream
+}
+
+type StreamBuffer struct {
+	mu      sync.Mutex
+	streams map[streamKey]*partialStream
+	closed  map[streamKey]bool
+	order   []streamKey // Closed streams, oldest first
+	opened  int64       // Streams opened so far
+}
+
+type streamKey struct {
+	sender   int
+	streamId int64
+}
+
+type partialStream struct {
+	chunks   [][]byte
+	received int
+	opened   int64 // Streams the buffer opened before this one
+}
+
+//
+// ------------------------------ SENDER FUNCTIONS ----------------------------
+//
+func (e *ClientEnd) CallStream(svcMeth string, data []byte, chunkSize int, callerId int) bool {
+	return callStream(e, e.net.nextStreamId(), svcMeth, data, chunkSize, callerId)
+}
+
+// Stream IDs of different processes only have to differ per sender, so a random one will do
+func (end *SocketEnd) CallStream(svcMeth string, data []byte, chunkSize int, callerId int) bool {
+	return callStream(end, rand.Int63(), svcMeth, data, chunkSize, callerId)
+}
+
+// Sends the chunks of data as ordinary calls over e
+func callStream(e Transport, streamId int64, svcMeth string, data []byte, chunkSize int, callerId int) bool {
+	if chunkSize <= 0 {
+		chunkSize = CHUNKSIZE
+	}
+
+	total := (len(data) + chunkSize - 1) / chunkSize
+	if total == 0 {
+		total = 1 // An empty payload is still sent as a single (empty) chunk
+	}
+	if total > MAXCHUNKS { // The receiver would drop every chunk
+		return false
+	}
+
+	for seq := 0; seq < total; seq++ {
+		start := seq * chunkSize
+		end := start + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		chunk := StreamChunk{}
+		chunk.StreamId = streamId
+		chunk.Sender = callerId
+		chunk.Seq = seq
+		chunk.Total = total
+		chunk.Data = data[start:end]
+
+		reply := StreamReply{}
+		if ok := e.Call(svcMeth, chunk, &reply, callerId); ok == false {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rn *Network) nextStreamId() int64 {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.streamId++
+	return rn.streamId
+}
+
+//
+// ----------------------------- RECEIVER FUNCTIONS ---------------------------
+//
+func MakeStreamBuffer() *StreamBuffer {
+	buf := &StreamBuffer{}
+	buf.streams = map[streamKey]*partialStream{}
+	buf.closed = map[streamKey]bool{}
+	buf.order = make([]streamKey, 0, CLOSEDSTREAMS)
+	return buf
+}
+
+// Buffer a chunk and fill in reply; returns the reassembled payload once every chunk of the
+// stream has arrived (exactly once per stream)
+func (buf *StreamBuffer) Add(chunk StreamChunk, reply *StreamReply) ([]byte, bool) {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	if chunk.Total <= 0 || chunk.Total > MAXCHUNKS || chunk.Seq < 0 || chunk.Seq >= chunk.Total {
+		return nil, false
+	}
+
+	key := streamKey{chunk.Sender, chunk.StreamId}
+	if buf.closed[key] { // Late or duplicate chunk
+		return nil, false
+	}
+	ps, ok := buf.streams[key]
+	if ok == false {
+		buf.evict(chunk.Sender)
+		ps = &partialStream{}
+		ps.chunks = make([][]byte, chunk.Total)
+		ps.opened = buf.opened
+		buf.opened++
+		buf.streams[key] = ps
+	}
+	if chunk.Total != len(ps.chunks) { // Seq may be past the chunks of the stream
+		reply.Received = ps.received
+		return nil, false
+	}
+
+	if ps.chunks[chunk.Seq] == nil {
+		ps.chunks[chunk.Seq] = append([]byte{}, chunk.Data...) // Non-nil even if empty
+		ps.received++
+	}
+	reply.Received = ps.received
+
+	if ps.received < len(ps.chunks) {
+		return nil, false
+	}
+
+	size := 0
+	for _, c := range ps.chunks {
+		size += len(c)
+	}
+
+	data := make([]byte, 0, size)
+	for _, c := range ps.chunks {
+		data = append(data, c...)
+	}
+
+	buf.close(key)
+	return data, true
+}
+
+// Discard every incomplete stream from sender (i.e. after the sender retried)
+func (buf *StreamBuffer) Drop(sender int) {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	for key := range buf.streams {
+		if key.sender == sender {
+			buf.close(key)
+		}
+	}
+}
+
+// Number of streams that are still missing chunks
+func (buf *StreamBuffer) Pending() int {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	return len(buf.streams)
+}
+
+// Make room for a new stream of sender by closing its oldest ones. Must be called with buf.mu held
+func (buf *StreamBuffer) evict(sender int) {
+	for {
+		open := 0
+		oldest := streamKey{}
+		for key, ps := range buf.streams {
+			if key.sender != sender {
+				continue
+			}
+			if open == 0 || ps.opened < buf.streams[oldest].opened {
+				oldest = key
+			}
+			open++
+		}
+		if open < MAXSTREAMS {
+			return
+		}
+		buf.close(oldest)
+	}
+}
+
+// Forget the chunks of a stream and drop the ones that arrive later. Must be called with buf.mu held
+func (buf *StreamBuffer) close(key streamKey) {
+	delete(buf.streams, key)
+
+	if len(buf.order) == CLOSEDSTREAMS {
+		delete(buf.closed, buf.order[0])
+		buf.order = append(buf.order[:0], buf.order[1:]...)
+	}
+	buf.closed[key] = true
+	buf.order = append(buf.order, key)
+}
@@ -0,0 +1,1344 @@
+package network
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Echo struct {
+	mu      sync.Mutex
+	calls   int32 // Number of executed RPCs
+	history []int // Arguments in execution order
+}
+
+func (echo *Echo) Ping(args int, reply *int) {
+	atomic.AddInt32(&echo.calls, 1)
+
+	echo.mu.Lock()
+	echo.history = append(echo.history, args)
+	echo.mu.Unlock()
+
+	*reply = args
+}
+
+type tracedArgs struct {
+	N       int
+	TraceId string
+}
+
+func (args tracedArgs) Trace() string {
+	return args.TraceId
+}
+
+func (echo *Echo) TracedPing(args tracedArgs, reply *int) {
+	*reply = args.N
+}
+
+// Create a network with a single Echo server (server 1) and a ClientEnd connected to it
+func makeEchoNetwork() (*Network, *ClientEnd, *Echo) {
+	net := MakeNetwork()
+
+	echo := &Echo{}
+	srv := MakeServer()
+	srv.AddService(MakeService(echo))
+	net.AddServer(1, srv)
+
+	end := net.MakeEnd("end")
+	net.Connect("end", 1)
+	net.Enable("end", true)
+
+	return net, end, echo
+}
+
+// Issue an Echo.Ping RPC in the background; the channel yields whether the call succeeded
+func goPing(end *ClientEnd, args int) chan bool {
+	return goPingPriority(end, args, NORMALPRIORITY)
+}
+
+func goPingPriority(end *ClientEnd, args int, priority int) chan bool {
+	done := make(chan bool, 1)
+	go func() {
+		reply := 0
+		ok := end.CallPriority("Echo.Ping", args, &reply, 0, priority)
+		done <- ok && reply == args
+	}()
+	return done
+}
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestVirtualClock(t *testing.T) {
+	net, end, _ := makeEchoNetwork()
+	clock := MakeVirtualClock()
+	net.SetClock(clock)
+	net.SetLatency(ConstantLatency{50 * time.Millisecond})
+
+	fmt.Println("Test: Virtual Clock - Propagation Delay")
+
+	done := goPing(end, 7)
+
+	// One timer polls for a killed server and one simulates the propagation delay
+	clock.WaitForTimers(2)
+	clock.Advance(49 * time.Millisecond)
+
+	select {
+	case <-done:
+		t.Fatal("RPC completed before its propagation delay!")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Millisecond)
+	if ok := <-done; ok == false {
+		t.Fatal("RPC failed!")
+	}
+
+	if latency := net.MethodStats()["Echo.Ping"].Latency; latency != 50*time.Millisecond {
+		t.Fatalf("Invalid virtual latency (%v)!", latency)
+	}
+}
+
+func TestSkewedClock(t *testing.T) {
+	clock := MakeVirtualClock()
+	fast := SkewedClock{clock, 2}
+	slow := SkewedClock{clock, 0.5}
+
+	fmt.Println("Test: Virtual Clock - Skewed Timers")
+
+	fastCh := fast.After(100 * time.Millisecond)
+	slowCh := slow.After(100 * time.Millisecond)
+
+	clock.Advance(50 * time.Millisecond)
+	select {
+	case <-fastCh:
+	default:
+		t.Fatal("Fast timer did not fire after half of its duration!")
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	select {
+	case <-slowCh:
+		t.Fatal("Slow timer fired before twice its duration!")
+	default:
+	}
+
+	clock.Advance(50 * time.Millisecond)
+	select {
+	case <-slowCh:
+	default:
+		t.Fatal("Slow timer did not fire after twice its duration!")
+	}
+}
+
+func TestLinkLatency(t *testing.T) {
+	net, end, _ := makeEchoNetwork()
+	clock := MakeVirtualClock()
+	net.SetClock(clock)
+	net.SetLatency(ConstantLatency{10 * time.Millisecond})
+	net.SetLinkLatency(0, 1, ConstantLatency{30 * time.Millisecond})
+
+	fmt.Println("Test: Latency Distributions - Per-Link Latency")
+
+	done := goPing(end, 1)
+	clock.WaitForTimers(2)
+	clock.Advance(10 * time.Millisecond)
+
+	select {
+	case <-done:
+		t.Fatal("RPC used the default latency instead of the link latency!")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(20 * time.Millisecond)
+	if ok := <-done; ok == false {
+		t.Fatal("RPC failed!")
+	}
+}
+
+func TestAsynchronous(t *testing.T) {
+	net := MakeNetwork()
+	net.SetLatency(ConstantLatency{10 * time.Millisecond})
+	r := rand.New(rand.NewSource(1))
+
+	fmt.Println("Test: Latency Distributions - Asynchronous Periods")
+
+	net.SetAsynchronous(true)
+	samples := 10000
+	beyond := 0
+	for i := 0; i < samples; i++ {
+		if net.sampleLatency(r, 0, 1) > DELTA*time.Millisecond {
+			beyond++
+		}
+	}
+
+	// Exponential delays with mean 2*DELTA exceed DELTA with probability exp(-1/2) ~ 60%
+	if beyond < samples/2 || beyond > samples*7/10 {
+		t.Fatalf("Invalid share of delays beyond DELTA while asynchronous (%d of %d)!", beyond, samples)
+	}
+
+	net.SetAsynchronous(false)
+	if delay := net.sampleLatency(r, 0, 1); delay != 10*time.Millisecond {
+		t.Fatalf("Asynchronous delay after the network became synchronous (%v)!", delay)
+	}
+}
+
+func TestLatencyDistributions(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	mean := 10 * time.Millisecond
+
+	fmt.Println("Test: Latency Distributions - Sample Statistics")
+
+	pareto, err := MakeParetoLatency(mean/3, 1.5) // Mean = min * shape / (shape - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dists := map[string]LatencyDistribution{
+		"constant":    ConstantLatency{mean},
+		"uniform":     UniformLatency{0, 2 * mean},
+		"exponential": ExponentialLatency{mean},
+		"pareto":      pareto,
+	}
+
+	for name, dist := range dists {
+		samples := 100000
+		total := time.Duration(0)
+		for i := 0; i < samples; i++ {
+			total += dist.Sample(r)
+		}
+
+		// The Pareto tail converges slowly so only loose bounds are checked
+		if avg := total / time.Duration(samples); avg < mean*8/10 || avg > mean*12/10 {
+			t.Fatalf("Invalid mean of %s latency distribution (%v)!", name, avg)
+		}
+	}
+
+	// A Pareto distribution needs a positive scale and shape (the shape divides)
+	for _, invalid := range []ParetoLatency{{0, 1.5}, {-mean, 1.5}, {mean, 0}, {mean, -1}, {mean, math.NaN()}} {
+		if _, err := MakeParetoLatency(invalid.Min, invalid.Shape); err == nil {
+			t.Fatalf("Pareto latency with scale %v and shape %v accepted!", invalid.Min, invalid.Shape)
+		}
+	}
+	if delay := (ParetoLatency{mean, 0}).Sample(r); delay != 0 {
+		t.Fatalf("Pareto latency of shape 0 sampled %v!", delay)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	net := MakeNetwork()
+	r := rand.New(rand.NewSource(1))
+	net.SetLatency(ConstantLatency{10 * time.Millisecond})
+	net.SetJitter(2 * time.Millisecond)
+	net.SetLinkJitter(0, 2, 0)
+
+	fmt.Println("Test: Jitter - Noise Around the Mean Delay")
+
+	samples := 10000
+	total := time.Duration(0)
+	distinct := map[time.Duration]bool{}
+	for i := 0; i < samples; i++ {
+		delay := net.sampleLatency(r, 0, 1)
+		if delay < 8*time.Millisecond || delay > 12*time.Millisecond {
+			t.Fatalf("Jittered delay out of bounds (%v)!", delay)
+		}
+		total += delay
+		distinct[delay] = true
+	}
+
+	if avg := total / time.Duration(samples); avg < 9900*time.Microsecond || avg > 10100*time.Microsecond {
+		t.Fatalf("Jitter shifted the mean delay (%v)!", avg)
+	}
+	if len(distinct) < 100 {
+		t.Fatal("Delays are not jittered!")
+	}
+
+	if delay := net.sampleLatency(r, 0, 2); delay != 10*time.Millisecond {
+		t.Fatalf("Link without jitter has a jittered delay (%v)!", delay)
+	}
+
+	net.SetLinkJitter(0, 2, -1) // Back to the default jitter
+	net.SetJitter(20 * time.Millisecond)
+	for i := 0; i < samples; i++ {
+		if delay := net.sampleLatency(r, 0, 2); delay < 0 {
+			t.Fatalf("Negative delay (%v)!", delay)
+		}
+	}
+}
+
+func TestAsymmetricLink(t *testing.T) {
+	net, end, echo := makeEchoNetwork()
+
+	fmt.Println("Test: Asymmetric Link Failure")
+
+	// Requests from the caller (0) to the server (1) are lost before execution
+	net.EnableLink(0, 1, false)
+	if ok := <-goPing(end, 1); ok == true || atomic.LoadInt32(&echo.calls) != 0 {
+		t.Fatal("RPC was delivered on a disabled link!")
+	}
+
+	// Requests are executed but replies from the server (1) to the caller (0) are lost
+	net.EnableLink(0, 1, true)
+	net.EnableLink(1, 0, false)
+	if ok := <-goPing(end, 2); ok == true || atomic.LoadInt32(&echo.calls) != 1 {
+		t.Fatal("Reply was delivered on a disabled link!")
+	}
+
+	net.EnableLink(1, 0, true)
+	if ok := <-goPing(end, 3); ok == false {
+		t.Fatal("RPC failed on a re-enabled link!")
+	}
+}
+
+func TestPriorityLanes(t *testing.T) {
+	net, end, echo := makeEchoNetwork()
+	clock := MakeVirtualClock()
+	net.SetClock(clock)
+	net.SetDeliveryRate(1, 10) // One message every 100 ms
+
+	fmt.Println("Test: Priority Lanes - High Priority Overtakes Queued Traffic")
+
+	// The first message is delivered right away and occupies the inbox for 100 ms
+	first := goPing(end, 0)
+	<-first
+
+	queued := []chan bool{}
+	for i := 1; i <= 3; i++ {
+		queued = append(queued, goPingPriority(end, i, LOWPRIORITY))
+		for net.GetBacklog(1) < i {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	queued = append(queued, goPingPriority(end, 4, HIGHPRIORITY))
+	for net.GetBacklog(1) < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	if backlog := net.GetUndelivered(1); backlog != 4 {
+		t.Fatalf("Invalid backlog of the server (%d)!", backlog)
+	}
+
+	for i := 0; i < 4; i++ {
+		clock.Advance(100 * time.Millisecond)
+		for int(atomic.LoadInt32(&echo.calls)) < i+2 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for _, done := range queued {
+		if ok := <-done; ok == false {
+			t.Fatal("RPC failed!")
+		}
+	}
+
+	if stats := net.Stats().Servers[1]; stats.Backlog != 0 || stats.PeakBacklog != 4 {
+		t.Fatalf("Invalid backlog statistics %+v!", stats)
+	}
+
+	echo.mu.Lock()
+	defer echo.mu.Unlock()
+	if fmt.Sprint(echo.history) != "[0 4 1 2 3]" {
+		t.Fatalf("Invalid delivery order %v!", echo.history)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	net, end, echo := makeEchoNetwork()
+	clock := MakeVirtualClock()
+	net.SetClock(clock)
+	net.SetLatency(ConstantLatency{time.Second})
+
+	fmt.Println("Test: Call Timeout - Caller Gives Up Before the Reply")
+
+	done := make(chan bool)
+	go func() {
+		reply := 0
+		done <- end.CallTimeout("Echo.Ping", 1, &reply, 0, 100*time.Millisecond)
+	}()
+
+	// One timer for the caller's timeout, one polling for a killed server and one simulating
+	// the propagation delay
+	clock.WaitForTimers(3)
+	clock.Advance(100 * time.Millisecond)
+	if ok := <-done; ok == true {
+		t.Fatal("RPC succeeded before its propagation delay!")
+	}
+
+	// The late reply must not block the network
+	clock.Advance(time.Second)
+	if atomic.LoadInt32(&echo.calls) != 1 {
+		t.Fatal("Request was not executed!")
+	}
+
+	net.SetLatency(nil)
+	reply := 0
+	if ok := end.CallTimeout("Echo.Ping", 2, &reply, 0, time.Second); ok == false || reply != 2 {
+		t.Fatal("RPC failed!")
+	}
+}
+
+type Sink struct {
+	buf      *StreamBuffer
+	payloads chan []byte
+}
+
+func (sink *Sink) Install(args StreamChunk, reply *StreamReply) {
+	if data, ok := sink.buf.Add(args, reply); ok {
+		sink.payloads <- data
+	}
+}
+
+func TestStream(t *testing.T) {
+	net := MakeNetwork()
+
+	sink := &Sink{MakeStreamBuffer(), make(chan []byte, 1)}
+	srv := MakeServer()
+	srv.AddService(MakeService(sink))
+	net.AddServer(1, srv)
+
+	end := net.MakeEnd("end")
+	net.Connect("end", 1)
+	net.Enable("end", true)
+
+	fmt.Println("Test: Stream - Bulk Transfer in Chunks")
+
+	data := make([]byte, 1000*1000)
+	rand.Read(data)
+
+	if ok := end.CallStream("Sink.Install", data, 64*1024, 0); ok == false {
+		t.Fatal("Stream failed!")
+	}
+
+	received := <-sink.payloads
+	if string(received) != string(data) {
+		t.Fatal("Reassembled payload differs from the original!")
+	}
+
+	if stats := net.MethodStats()["Sink.Install"]; stats.Count != 16 {
+		t.Fatalf("Expected 16 chunk RPCs, got %d!", stats.Count)
+	}
+
+	if sink.buf.Pending() != 0 {
+		t.Fatal("Completed stream still buffered!")
+	}
+
+	fmt.Println("Test: Stream - Lost Chunk")
+
+	net.Enable("end", false)
+	if ok := end.CallStream("Sink.Install", data, 64*1024, 0); ok == true {
+		t.Fatal("Stream succeeded over a disabled link!")
+	}
+	net.Enable("end", true)
+
+	if ok := end.CallStream("Sink.Install", []byte{}, 0, 0); ok == false {
+		t.Fatal("Empty stream failed!")
+	}
+	if received := <-sink.payloads; len(received) != 0 {
+		t.Fatal("Empty stream delivered data!")
+	}
+
+	fmt.Println("Test: Stream - Chunks That Lie About the Stream")
+
+	buf := MakeStreamBuffer()
+	reply := StreamReply{}
+	buf.Add(StreamChunk{StreamId: 1, Sender: 2, Seq: 0, Total: 2, Data: []byte("a")}, &reply)
+	if _, ok := buf.Add(StreamChunk{StreamId: 1, Sender: 2, Seq: 3, Total: 4, Data: []byte("b")}, &reply); ok {
+		t.Fatal("Chunk past the first chunk's total completed the stream!")
+	}
+	if reply.Received != 1 {
+		t.Fatalf("Buffer holds %d chunks of the stream instead of 1!", reply.Received)
+	}
+	if _, ok := buf.Add(StreamChunk{StreamId: 2, Sender: 2, Seq: 0, Total: MAXCHUNKS + 1}, &reply); ok ||
+		buf.Pending() != 1 {
+		t.Fatal("Stream longer than MAXCHUNKS buffered!")
+	}
+	if data, ok := buf.Add(StreamChunk{StreamId: 1, Sender: 2, Seq: 1, Total: 2, Data: []byte("b")}, &reply); ok == false ||
+		string(data) != "ab" {
+		t.Fatalf("Stream reassembled to %q!", data)
+	}
+
+	fmt.Println("Test: Stream - Abandoned and Closed Streams")
+
+	if _, ok := buf.Add(StreamChunk{StreamId: 1, Sender: 2, Seq: 0, Total: 2, Data: []byte("a")}, &reply); ok ||
+		buf.Pending() != 0 {
+		t.Fatal("Late chunk of a completed stream opened it again!")
+	}
+	for id := int64(3); id < 3+2*MAXSTREAMS; id++ { // Abandoned by sender 2 after their first chunk
+		buf.Add(StreamChunk{StreamId: id, Sender: 2, Seq: 0, Total: 2}, &reply)
+	}
+	buf.Add(StreamChunk{StreamId: 1, Sender: 3, Seq: 0, Total: 2}, &reply)
+	if buf.Pending() != MAXSTREAMS+1 {
+		t.Fatalf("Buffer holds %d streams instead of %d!", buf.Pending(), MAXSTREAMS+1)
+	}
+	if _, ok := buf.Add(StreamChunk{StreamId: 3, Sender: 2, Seq: 1, Total: 2}, &reply); ok {
+		t.Fatal("Evicted stream completed!")
+	}
+	if _, ok := buf.Add(StreamChunk{StreamId: 1, Sender: 3, Seq: 1, Total: 2}, &reply); ok == false {
+		t.Fatal("Stream of another sender was evicted!")
+	}
+	buf.Drop(2)
+	if buf.Pending() != 0 {
+		t.Fatal("Dropped streams still buffered!")
+	}
+	if _, ok := buf.Add(StreamChunk{StreamId: 3 + 2*MAXSTREAMS - 1, Sender: 2, Seq: 1, Total: 2}, &reply); ok ||
+		buf.Pending() != 0 {
+		t.Fatal("Late chunk of a dropped stream opened it again!")
+	}
+}
+
+func TestTopology(t *testing.T) {
+	net, end, _ := makeEchoNetwork()
+	clock := MakeVirtualClock()
+	net.SetClock(clock)
+
+	fmt.Println("Test: Topology - Latency Between Datacenters")
+
+	topo := ThreeDatacenters(3) // Caller 0 in us-east, server 1 in us-east, 2 in us-west, 3 in eu-west
+	if site, ok := topo.SiteOf(3); ok == false || topo.Sites[site] != "eu-west" {
+		t.Fatal("Invalid placement of replica 3!")
+	}
+
+	topo.Place(0, 2) // Move the caller to eu-west
+	net.SetTopology(topo)
+
+	start := clock.Now()
+	done := goPing(end, 1)
+
+	// The propagation delay between eu-west and us-east is 40ms
+	clock.WaitForTimers(2)
+	clock.Advance(39 * time.Millisecond)
+
+	select {
+	case <-done:
+		t.Fatal("RPC completed before the inter-datacenter latency!")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Millisecond)
+	if ok := <-done; ok == false {
+		t.Fatal("RPC failed!")
+	}
+
+	if latency := clock.Now().Sub(start); latency != 40*time.Millisecond {
+		t.Fatalf("Invalid topology latency (%v)!", latency)
+	}
+}
+
+func TestHoldAndRelease(t *testing.T) {
+	net, end, echo := makeEchoNetwork()
+
+	fmt.Println("Test: Hold and Release - Chosen Delivery Order")
+
+	net.Hold(func(svcMeth string, from int, to interface{}) bool {
+		return svcMeth == "Echo.Ping" && from == 0 && to == 1
+	})
+
+	done := make([]chan bool, 3)
+	for i := 0; i < 3; i++ {
+		done[i] = goPing(end, i)
+	}
+
+	net.WaitForHeld(3)
+	if atomic.LoadInt32(&echo.calls) != 0 {
+		t.Fatal("Held message was delivered!")
+	}
+
+	args := map[int]int{} // Held message ID -> Ping argument
+	for _, m := range net.Held() {
+		arg := 0
+		if err := m.Decode(&arg); err != nil {
+			t.Fatal(err)
+		}
+		args[m.Id] = arg
+	}
+
+	// Deliver 2 and then 0, and lose 1
+	for id, arg := range args {
+		if arg == 2 {
+			net.Release(id)
+		}
+	}
+	for id, arg := range args {
+		if arg == 0 {
+			net.Release(id)
+		} else if arg == 1 {
+			net.Drop(id)
+		}
+	}
+
+	if ok := <-done[1]; ok == true {
+		t.Fatal("Dropped message was delivered!")
+	}
+	if ok := <-done[0]; ok == false {
+		t.Fatal("Released message failed!")
+	}
+	if ok := <-done[2]; ok == false {
+		t.Fatal("Released message failed!")
+	}
+
+	if fmt.Sprint(echo.history) != "[2 0]" {
+		t.Fatalf("Invalid delivery order %v!", echo.history)
+	}
+
+	net.Hold(nil)
+	if ok := <-goPing(end, 3); ok == false || len(net.Held()) != 0 {
+		t.Fatal("Message held after Hold(nil)!")
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	net := MakeNetwork()
+
+	echo := &Echo{}
+	srv := MakeServer()
+	srv.AddService(MakeService(echo))
+	net.AddServer(1, srv)
+
+	end := net.MakeEnd("end")
+	net.Connect("end", 1)
+	net.Enable("end", true)
+
+	fmt.Println("Test: Interceptors - Client and Server Chains")
+
+	var mu sync.Mutex
+	trace := []string{}
+	record := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		trace = append(trace, s)
+	}
+
+	end.Use(func(info CallInfo, args interface{}, reply interface{}, next func() bool) bool {
+		record("client-pre")
+		ok := next()
+		record(fmt.Sprintf("client-post %v %d", ok, *reply.(*int)))
+		return ok
+	})
+	srv.Use(func(info CallInfo, args interface{}, reply interface{}, next func() bool) bool {
+		record(fmt.Sprintf("server-auth %s %d", info.SvcMeth, info.CallerId))
+		if args.(int) < 0 { // Reject negative arguments
+			return false
+		}
+		return next()
+	})
+	srv.Use(func(info CallInfo, args interface{}, reply interface{}, next func() bool) bool {
+		record("server-pre")
+		ok := next()
+		*reply.(*int) *= 10 // Post hook rewrites the reply
+		return ok
+	})
+
+	reply := 0
+	if ok := end.Call("Echo.Ping", 4, &reply, 0); ok == false || reply != 40 {
+		t.Fatalf("Invalid reply (%d)!", reply)
+	}
+
+	if ok := end.Call("Echo.Ping", -1, &reply, 0); ok == true {
+		t.Fatal("Rejected RPC succeeded!")
+	}
+	if atomic.LoadInt32(&echo.calls) != 1 {
+		t.Fatal("Rejected RPC reached the handler!")
+	}
+
+	expected := "[client-pre server-auth Echo.Ping 0 server-pre client-post true 40 " +
+		"client-pre server-auth Echo.Ping 0 client-post false 40]"
+	if fmt.Sprint(trace) != expected {
+		t.Fatalf("Invalid interceptor trace %v!", trace)
+	}
+}
+
+type Table struct {
+	mu      sync.Mutex
+	entries []int
+}
+
+func (table *Table) Get(index int, reply *int) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	*reply = table.entries[index] // Panics on an index beyond the table
+}
+
+func TestPanicRecovery(t *testing.T) {
+	net := MakeNetwork()
+
+	table := &Table{entries: []int{7, 8, 9}}
+	srv := MakeServer()
+	srv.AddService(MakeService(table))
+	net.AddServer(1, srv)
+
+	end := net.MakeEnd("end")
+	net.Connect("end", 1)
+	net.Enable("end", true)
+
+	fmt.Println("Test: Panic Recovery - Invalid Messages Fail their RPC Only")
+
+	results := []bool{}
+	srv.Use(func(info CallInfo, args interface{}, reply interface{}, next func() bool) bool {
+		ok := next()
+		results = append(results, ok)
+		return ok
+	})
+
+	reply := 0
+	if ok := end.Call("Table.Get", 1, &reply, 0); ok == false || reply != 8 {
+		t.Fatalf("Invalid reply (%d)!", reply)
+	}
+	for _, index := range []int{3, -1} {
+		if ok := end.Call("Table.Get", index, &reply, 0); ok == true {
+			t.Fatalf("RPC with index %d succeeded!", index)
+		}
+	}
+	if ok := end.Call("Table.Get", 2, &reply, 0); ok == false || reply != 9 {
+		t.Fatalf("Server stopped after a panic (reply %d)!", reply)
+	}
+
+	if fmt.Sprint(results) != "[true false false true]" {
+		t.Fatalf("Interceptor saw %v!", results)
+	}
+	if srv.GetPanics() != 2 || net.Stats().Servers[1].Panics != 2 || srv.GetCount() != 4 {
+		t.Fatalf("%d panics counted!", srv.GetPanics())
+	}
+	net.ResetStats()
+	if srv.GetPanics() != 0 {
+		t.Fatal("Panics counted after ResetStats()!")
+	}
+}
+
+type Store struct {
+	data []byte
+}
+
+func (store *Store) Put(args []byte, reply *int) {
+	store.data = args
+	*reply = len(args)
+}
+
+func TestCompression(t *testing.T) {
+	net := MakeNetwork()
+
+	store := &Store{}
+	srv := MakeServer()
+	srv.AddService(MakeService(store))
+	net.AddServer(1, srv)
+
+	end := net.MakeEnd("end")
+	net.Connect("end", 1)
+	net.Enable("end", true)
+
+	net.SetCompression(1024)
+
+	fmt.Println("Test: Compression - Compressible, Incompressible and Small Arguments")
+
+	compressible := []byte(strings.Repeat("prepare ", 8192))
+	reply := 0
+	if ok := end.Call("Store.Put", compressible, &reply, 0); ok == false || string(store.data) != string(compressible) {
+		t.Fatal("Compressed arguments were not restored!")
+	}
+
+	stats := net.CompressionStats()
+	if stats.Compressed != 1 || stats.BytesIn <= int64(len(compressible)) || stats.Saved() < int64(len(compressible))/2 {
+		t.Fatalf("Invalid compression stats %+v!", stats)
+	}
+	if net.GetBytes(1) >= int64(len(compressible)) {
+		t.Fatal("Server received uncompressed bytes!")
+	}
+
+	random := make([]byte, 4096)
+	rand.Read(random)
+	if ok := end.Call("Store.Put", random, &reply, 0); ok == false || string(store.data) != string(random) {
+		t.Fatal("Incompressible arguments were not delivered!")
+	}
+
+	small := []byte("commit")
+	if ok := end.Call("Store.Put", small, &reply, 0); ok == false || string(store.data) != string(small) {
+		t.Fatal("Small arguments were not delivered!")
+	}
+
+	if stats := net.CompressionStats(); stats.Compressed != 1 || stats.Skipped != 1 {
+		t.Fatalf("Invalid compression stats %+v!", stats)
+	}
+}
+
+func TestSend(t *testing.T) {
+	net, end, echo := makeEchoNetwork()
+
+	fmt.Println("Test: Send - Datagrams Over Reliable and Unreliable Networks")
+
+	for i := 0; i < 10; i++ {
+		end.Send("Echo.Ping", i, 0)
+	}
+
+	for atomic.LoadInt32(&echo.calls) < 10 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if stats := net.MethodStats()["Echo.Ping"]; stats.Count != 10 || stats.Completed != 0 {
+		t.Fatalf("Invalid datagram stats %+v!", stats)
+	}
+
+	// Datagrams to a disabled end are silently lost and never block the sender
+	net.Enable("end", false)
+	end.Send("Echo.Ping", -1, 0)
+
+	net.Enable("end", true)
+	net.Reliable(false)
+	sent := 200
+	for i := 0; i < sent; i++ {
+		end.Send("Echo.Ping", i, 0)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	delivered := int(atomic.LoadInt32(&echo.calls)) - 10
+	if delivered == sent || delivered < sent/2 {
+		t.Fatalf("Unexpected number of delivered datagrams (%d of %d)!", delivered, sent)
+	}
+}
+
+func TestStats(t *testing.T) {
+	net, end, _ := makeEchoNetwork()
+	clock := MakeVirtualClock()
+	net.SetClock(clock)
+	net.SetLatency(ConstantLatency{10 * time.Millisecond})
+
+	fmt.Println("Test: Stats - Snapshot and Reset")
+
+	done := goPing(end, 1)
+	clock.WaitForTimers(2)
+	clock.Advance(10 * time.Millisecond)
+	if ok := <-done; ok == false {
+		t.Fatal("RPC failed!")
+	}
+
+	net.EnableLink(1, 0, false) // Lose the reply
+	done = goPing(end, 2)
+	clock.WaitForTimers(2)
+	clock.Advance(10 * time.Millisecond)
+	if ok := <-done; ok == true {
+		t.Fatal("RPC succeeded without a reply link!")
+	}
+
+	stats := net.Stats()
+	server := stats.Servers[1]
+	if server.RPCs != 2 || server.Bytes == 0 || server.Drops != 1 || server.Delay != 20*time.Millisecond {
+		t.Fatalf("Invalid server stats %+v!", server)
+	}
+	if stats.Drops != 1 || stats.Delay != 20*time.Millisecond || stats.Methods["Echo.Ping"].Failed != 1 {
+		t.Fatalf("Invalid network stats %+v!", stats)
+	}
+
+	net.ResetStats()
+	if server := net.Stats().Servers[1]; server != (ServerStats{}) || len(net.Stats().Methods) != 0 {
+		t.Fatalf("Stats not reset %+v!", net.Stats())
+	}
+
+	// The snapshot taken before the reset is unaffected
+	if stats.Servers[1].RPCs != 2 || stats.Methods["Echo.Ping"].Count != 2 {
+		t.Fatal("Snapshot changed after reset!")
+	}
+}
+
+type Signed struct {
+	Data   []byte
+	Digest [32]byte
+}
+
+type Verified struct {
+	Valid bool
+	Data  []byte // Data as received by the verifier
+}
+
+type Verifier struct{}
+
+func (v *Verifier) Verify(args Signed, reply *Verified) {
+	reply.Valid = sha256.Sum256(args.Data) == args.Digest
+	reply.Data = args.Data
+}
+
+func TestCorruption(t *testing.T) {
+	net := MakeNetwork()
+
+	srv := MakeServer()
+	srv.AddService(MakeService(&Verifier{}))
+	srv.AddService(MakeService(&Echo{}))
+	net.AddServer(1, srv)
+
+	end := net.MakeEnd("end")
+	net.Connect("end", 1)
+	net.Enable("end", true)
+
+	fmt.Println("Test: Corruption - Digest Checks Catch Corrupted Requests")
+
+	events := net.Subscribe()
+	net.SetLinkCorruptionRate(0, 1, 100)
+
+	iters := 200
+	caught := 0
+	for i := 0; i < iters; i++ {
+		args := Signed{}
+		args.Data = make([]byte, 256)
+		rand.Read(args.Data)
+		args.Digest = sha256.Sum256(args.Data)
+
+		// Flips in bytes that gob ignores (i.e. type names) leave the payload intact
+		reply := Verified{}
+		if ok := end.Call("Verifier.Verify", args, &reply, 0); ok == false || reply.Valid == false {
+			caught++
+		} else if string(reply.Data) != string(args.Data) {
+			t.Fatal("Corrupted request passed the digest check!")
+		}
+	}
+
+	if caught < iters/2 {
+		t.Fatalf("Too few corrupted requests caught (%d of %d)!", caught, iters)
+	}
+
+	if stats := net.Stats(); stats.Corrupted != iters || stats.Servers[1].Corrupted != iters {
+		t.Fatalf("Invalid number of corrupted messages (%d)!", stats.Corrupted)
+	}
+	net.Unsubscribe(events)
+	traced := 0
+	for len(events) > 0 {
+		if ev := <-events; ev.Type == CORRUPTED {
+			traced++
+		}
+	}
+	if traced != iters {
+		t.Fatalf("Invalid number of corruption events (%d)!", traced)
+	}
+
+	fmt.Println("Test: Corruption - Corrupted Replies")
+
+	net.SetLinkCorruptionRate(0, 1, -1)
+	net.SetCorruptionRate(50)
+
+	corrupted := 0
+	for i := 0; i < iters; i++ {
+		reply := 0
+		if ok := end.Call("Echo.Ping", 1000+i, &reply, 0); ok == false || reply != 1000+i {
+			corrupted++
+		}
+	}
+
+	if corrupted == 0 || corrupted == iters {
+		t.Fatalf("Unexpected number of corrupted RPCs (%d of %d)!", corrupted, iters)
+	}
+
+	net.SetCorruptionRate(0)
+	reply := 0
+	if ok := end.Call("Echo.Ping", 7, &reply, 0); ok == false || reply != 7 {
+		t.Fatal("RPC corrupted after disabling corruption!")
+	}
+}
+
+func TestSendRate(t *testing.T) {
+	net, end, echo := makeEchoNetwork()
+	clock := MakeVirtualClock()
+	net.SetClock(clock)
+	net.SetSendRate(0, 10) // One RPC every 100ms
+
+	fmt.Println("Test: Send Rate - Throttled Sender")
+
+	done := make([]chan bool, 3)
+	for i := 0; i < 3; i++ {
+		done[i] = goPing(end, i)
+	}
+
+	// The first RPC goes out immediately and the others wait for their slots
+	for atomic.LoadInt32(&echo.calls) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.WaitForTimers(2)
+
+	for i := 2; i <= 3; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if calls := atomic.LoadInt32(&echo.calls); calls != int32(i-1) {
+			t.Fatalf("Throttled RPCs were sent early (%d)!", calls)
+		}
+
+		clock.Advance(100 * time.Millisecond)
+		for atomic.LoadInt32(&echo.calls) < int32(i) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if ok := <-done[i]; ok == false {
+			t.Fatal("RPC failed!")
+		}
+	}
+
+	net.SetSendRate(0, 0)
+	if ok := <-goPing(end, 3); ok == false {
+		t.Fatal("RPC failed after removing the rate limit!")
+	}
+}
+
+type Gate struct {
+	open    chan bool
+	running int32 // Number of executing handlers
+	peak    int32 // Maximum number of concurrently executing handlers
+}
+
+func (gate *Gate) Pass(args int, reply *int) {
+	running := atomic.AddInt32(&gate.running, 1)
+	for {
+		peak := atomic.LoadInt32(&gate.peak)
+		if running <= peak || atomic.CompareAndSwapInt32(&gate.peak, peak, running) {
+			break
+		}
+	}
+
+	<-gate.open
+	atomic.AddInt32(&gate.running, -1)
+	*reply = args
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	net := MakeNetwork()
+
+	gate := &Gate{}
+	gate.open = make(chan bool)
+	srv := MakeServer()
+	srv.AddService(MakeService(gate))
+	srv.SetConcurrency(2)
+	net.AddServer(1, srv)
+
+	end := net.MakeEnd("end")
+	net.Connect("end", 1)
+	net.Enable("end", true)
+
+	fmt.Println("Test: Concurrency Limit - Excess RPCs Queue")
+
+	done := make(chan bool, 5)
+	for i := 0; i < 5; i++ {
+		go func(i int) {
+			reply := 0
+			done <- end.Call("Gate.Pass", i, &reply, 0) && reply == i
+		}(i)
+	}
+
+	for srv.GetQueued() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	if backlog := net.GetUndelivered(1); backlog != 3 {
+		t.Fatalf("Invalid backlog of the server (%d)!", backlog)
+	}
+	if running := atomic.LoadInt32(&gate.running); running != 2 {
+		t.Fatalf("Invalid number of executing handlers (%d)!", running)
+	}
+
+	for i := 0; i < 5; i++ {
+		gate.open <- true
+	}
+	for i := 0; i < 5; i++ {
+		if ok := <-done; ok == false {
+			t.Fatal("RPC failed!")
+		}
+	}
+
+	if peak := atomic.LoadInt32(&gate.peak); peak != 2 {
+		t.Fatalf("Concurrency limit exceeded (%d)!", peak)
+	}
+	if srv.GetQueued() != 0 {
+		t.Fatal("RPCs still queued!")
+	}
+	if backlog := net.GetUndelivered(1); backlog != 0 {
+		t.Fatalf("RPCs still undelivered (%d)!", backlog)
+	}
+}
+
+func TestDynamicServers(t *testing.T) {
+	net, end, _ := makeEchoNetwork()
+	events := net.WatchServers()
+
+	fmt.Println("Test: Dynamic Servers - Discover a Server Added While Running")
+
+	// Keep traffic flowing to server 1 while server 2 joins
+	stop := make(chan bool)
+	failed := make(chan bool, 1)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if ok := <-goPing(end, i); ok == false {
+				failed <- true
+				return
+			}
+		}
+	}()
+
+	// Server 1 discovers the newcomer and connects to it
+	discovered := make(chan bool)
+	go func() {
+		event := <-events
+		if event.Name != 2 || event.Added == false {
+			discovered <- false
+			return
+		}
+
+		newEnd := net.MakeEnd("1-2")
+		net.Connect("1-2", event.Name)
+		net.Enable("1-2", true)
+
+		reply := 0
+		discovered <- newEnd.Call("Echo.Ping", 42, &reply, 1) && reply == 42
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	echo2 := &Echo{}
+	srv := MakeServer()
+	srv.AddService(MakeService(echo2))
+	net.AddServer(2, srv)
+
+	if ok := <-discovered; ok == false {
+		t.Fatal("Newcomer was not discovered!")
+	}
+	if len(net.Servers()) != 2 {
+		t.Fatalf("Invalid servers %v!", net.Servers())
+	}
+
+	net.DeleteServer(2)
+	if event := <-events; event.Name != 2 || event.Added == true {
+		t.Fatalf("Invalid membership event %+v!", event)
+	}
+	if servers := net.Servers(); len(servers) != 1 || servers[0] != 1 {
+		t.Fatalf("Invalid servers %v!", servers)
+	}
+
+	close(stop)
+	select {
+	case <-failed:
+		t.Fatal("RPC to an existing server failed while the newcomer joined!")
+	default:
+	}
+}
+
+func TestEvents(t *testing.T) {
+	net, end, _ := makeEchoNetwork()
+	events := net.Subscribe()
+
+	fmt.Println("Test: Events - Lifecycle of Delivered and Dropped RPCs")
+
+	net.SetLatency(ConstantLatency{time.Millisecond})
+	if ok := <-goPing(end, 1); ok == false {
+		t.Fatal("RPC failed!")
+	}
+
+	net.EnableLink(1, 0, false)
+	if ok := <-goPing(end, 2); ok == true {
+		t.Fatal("RPC succeeded without a reply link!")
+	}
+	net.Unsubscribe(events)
+
+	if ok := <-goPing(end, 3); ok == true {
+		t.Fatal("RPC succeeded without a reply link!")
+	}
+
+	trace := []string{}
+	msgIds := map[int64]bool{}
+	for len(events) > 0 {
+		ev := <-events
+		if ev.SvcMeth != "Echo.Ping" || ev.From != 0 || ev.To != 1 {
+			t.Fatalf("Invalid event %+v!", ev)
+		}
+		trace = append(trace, ev.TypeName())
+		msgIds[ev.MsgId] = true
+	}
+
+	expected := "[sent delivered delayed replied sent delivered delayed dropped]"
+	if fmt.Sprint(trace) != expected {
+		t.Fatalf("Invalid event trace %v!", trace)
+	}
+	if len(msgIds) != 2 {
+		t.Fatal("Events of one RPC carry different message IDs!")
+	}
+}
+
+func TestTracedEvents(t *testing.T) {
+	net, end, _ := makeEchoNetwork()
+	events := net.Subscribe()
+	defer net.Unsubscribe(events)
+
+	fmt.Println("Test: Events - Trace IDs of Traced Arguments")
+
+	traceId := NewTraceId()
+	if len(traceId) != 32 || traceId == NewTraceId() {
+		t.Fatalf("Invalid trace ID %q!", traceId)
+	}
+
+	reply := 0
+	if ok := end.Call("Echo.TracedPing", tracedArgs{1, traceId}, &reply, 0); ok == false || reply != 1 {
+		t.Fatal("RPC failed!")
+	}
+	if ok := <-goPing(end, 2); ok == false {
+		t.Fatal("RPC failed!")
+	}
+
+	for len(events) > 0 {
+		ev := <-events
+		if ev.SvcMeth == "Echo.TracedPing" && ev.TraceId != traceId {
+			t.Fatalf("Event %+v without the trace ID of its arguments!", ev)
+		}
+		if ev.SvcMeth == "Echo.Ping" && ev.TraceId != "" {
+			t.Fatalf("Event %+v of untraced arguments with a trace ID!", ev)
+		}
+	}
+}
+
+// Issue sequential pings and return which of them failed
+func pingPattern(end *ClientEnd, n int) string {
+	pattern := make([]byte, n)
+	for i := 0; i < n; i++ {
+		pattern[i] = '.'
+		if ok := <-goPing(end, i); ok == false {
+			pattern[i] = 'x'
+		}
+	}
+	return string(pattern)
+}
+
+func TestSeed(t *testing.T) {
+	fmt.Println("Test: Seeded Network - Same Drops with the Same Seed")
+
+	net, end, _ := makeEchoNetwork()
+	net.Reliable(false)
+	net.Seed(42)
+	first := pingPattern(end, 100)
+
+	if strings.Contains(first, "x") == false {
+		t.Fatal("Unreliable network dropped nothing!")
+	}
+
+	net, end, _ = makeEchoNetwork()
+	net.Reliable(false)
+	net.Seed(42)
+	if second := pingPattern(end, 100); second != first {
+		t.Fatalf("Same seed gave different drops:\n%s\n%s", first, second)
+	}
+}
+
+func TestSocket(t *testing.T) {
+	path := t.TempDir() + "/echo.sock"
+
+	fmt.Println("Test: Sockets - Calls over a Unix Socket")
+
+	echo := &Echo{}
+	srv := MakeServer()
+	srv.AddService(MakeService(echo))
+	ss, err := ServeSocket(path, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	end := MakeSocketEnd(path)
+	reply := 0
+	if ok := end.Call("Echo.Ping", 7, &reply, 0); ok == false || reply != 7 {
+		t.Fatalf("Invalid reply over the socket (%v, %d)!", ok, reply)
+	}
+	if srv.GetCount() != 1 {
+		t.Fatal("Server did not count the RPC!")
+	}
+	if ok := end.Call("Echo.Ping", "seven", &reply, 0); ok == true || atomic.LoadInt32(&echo.calls) != 1 {
+		t.Fatal("Handler ran on arguments that do not decode!")
+	}
+
+	sink := &Sink{MakeStreamBuffer(), make(chan []byte, 1)}
+	srv.AddService(MakeService(sink))
+	data := make([]byte, 100*1000)
+	rand.Read(data)
+	if ok := end.CallStream("Sink.Install", data, 16*1024, 0); ok == false {
+		t.Fatal("Stream over the socket failed!")
+	}
+	if received := <-sink.payloads; string(received) != string(data) {
+		t.Fatal("Payload streamed over the socket differs from the original!")
+	}
+
+	ss.Close()
+	if ok := end.Call("Echo.Ping", 8, &reply, 0); ok == true {
+		t.Fatal("Call succeeded after the server stopped serving!")
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	_, end, echo := makeEchoNetwork()
+
+	fmt.Println("Test: Unknown Services and Methods - Failed Calls Instead of Exits")
+
+	reply := 0
+	for _, svcMeth := range []string{"Echo.Pong", "Unknown.Ping", "Ping", ""} {
+		if ok := end.Call(svcMeth, 7, &reply, 0); ok == true {
+			t.Fatalf("Call of %q succeeded!", svcMeth)
+		}
+	}
+	if atomic.LoadInt32(&echo.calls) != 0 {
+		t.Fatal("Handler ran on a call of another method!")
+	}
+
+	// A reply that does not decode into the caller's reply type
+	text := ""
+	if ok := end.Call("Echo.Ping", 7, &text, 0); ok == true {
+		t.Fatal("Call succeeded with a reply that does not decode!")
+	}
+
+	if ok := end.Call("Echo.Ping", 8, &reply, 0); ok == false || reply != 8 {
+		t.Fatalf("Server stopped after unknown calls (%v, %d)!", ok, reply)
+	}
+}
+
+func TestUnencodableArguments(t *testing.T) {
+	net, end, echo := makeEchoNetwork()
+
+	fmt.Println("Test: Unencodable Arguments - Failed Calls Instead of Empty Payloads")
+
+	reply := 0
+	if ok := end.Call("Echo.Ping", make(chan int), &reply, 0); ok == true {
+		t.Fatal("Call with unencodable arguments succeeded!")
+	}
+	end.Send("Echo.Ping", make(chan int), 0)
+
+	if ok := end.Call("Echo.Ping", 7, &reply, 0); ok == false || reply != 7 {
+		t.Fatalf("Server stopped after unencodable arguments (%v, %d)!", ok, reply)
+	}
+	if calls := atomic.LoadInt32(&echo.calls); calls != 1 {
+		t.Fatalf("Handler ran %d times instead of once!", calls)
+	}
+	if count := net.GetCount(1); count != 1 {
+		t.Fatalf("Network carried %d RPCs instead of 1!", count)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := t.TempDir() + "/network.rec"
+
+	fmt.Println("Test: Record and Replay - Same Drops in the Replayed Session")
+
+	net, end, _ := makeEchoNetwork()
+	net.Reliable(false)
+	net.StartRecording()
+	recorded := pingPattern(end, 100)
+	if err := net.SaveRecording(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(recorded, "x") == false {
+		t.Fatal("Unreliable network dropped nothing!")
+	}
+
+	net, end, _ = makeEchoNetwork()
+	net.Reliable(false)
+	if err := net.Replay(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed := pingPattern(end, 100); replayed != recorded {
+		t.Fatalf("Replayed session differs:\n%s\n%s", recorded, replayed)
+	}
+}
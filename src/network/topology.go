@@ -0,0 +1,101 @@
+package network
+
+// WAN topologies for geo-replication experiments
+//
+// A topology places every node (caller ID / server name) in a site (i.e. a datacenter) and
+// holds a matrix of one-way latencies between sites. Installing a topology sets the latency of
+// every directed link between two placed nodes; links to or from unplaced nodes keep the
+// network's default latency
+//
+// topo := MakeTopology(sites, latency) - Sites and their one-way latency matrix
+// topo.Place(node, site)               - Put a node in a site
+// net.SetTopology(topo)                - Install the per-pair latencies of topo
+//
+// => ThreeDatacenters(replicas) and FiveDatacenters(replicas) spread replicas 1..replicas
+//    round-robin over the datacenters (i.e. ThreeDatacenters(6) puts two replicas in each) and
+//    place node 0 (the client in both XPaxos and PBFT) in the first datacenter
+// => Inter-datacenter latencies of FiveDatacenters() exceed DELTA, so XPaxos' synchrony
+//    assumption does not hold for it
+
+import (
+	"time"
+)
+
+const LOCALLATENCY = 500 * time.Microsecond // One-way latency within a datacenter
+
+type Topology struct {
+	Sites   []string
+	Latency [][]time.Duration   // Latency[i][j] is the one-way latency from site i to site j
+	site    map[interface{}]int // Site of every placed node
+}
+
+func MakeTopology(sites []string, latency [][]time.Duration) *Topology {
+	topo := &Topology{}
+	topo.Sites = sites
+	topo.Latency = latency
+	topo.site = map[interface{}]int{}
+	return topo
+}
+
+func (topo *Topology) Place(node interface{}, site int) {
+	topo.site[node] = site
+}
+
+func (topo *Topology) SiteOf(node interface{}) (site int, ok bool) {
+	site, ok = topo.site[node]
+	return
+}
+
+// Place replicas 1..replicas round-robin over the sites and the client (0) in site 0
+func (topo *Topology) placeReplicas(replicas int) {
+	topo.Place(0, 0)
+	for i := 1; i <= replicas; i++ {
+		topo.Place(i, (i-1)%len(topo.Sites))
+	}
+}
+
+//
+// --------------------------------- PRESETS ----------------------------------
+//
+func ThreeDatacenters(replicas int) *Topology {
+	l := LOCALLATENCY
+	ms := time.Millisecond
+	topo := MakeTopology([]string{"us-east", "us-west", "eu-west"}, [][]time.Duration{
+		{l, 35 * ms, 40 * ms},
+		{35 * ms, l, 70 * ms},
+		{40 * ms, 70 * ms, l},
+	})
+	topo.placeReplicas(replicas)
+	return topo
+}
+
+func FiveDatacenters(replicas int) *Topology {
+	l := LOCALLATENCY
+	ms := time.Millisecond
+	topo := MakeTopology([]string{"us-east", "us-west", "eu-west", "ap-northeast", "sa-east"},
+		[][]time.Duration{
+			{l, 35 * ms, 40 * ms, 80 * ms, 60 * ms},
+			{35 * ms, l, 70 * ms, 55 * ms, 90 * ms},
+			{40 * ms, 70 * ms, l, 110 * ms, 95 * ms},
+			{80 * ms, 55 * ms, 110 * ms, l, 130 * ms},
+			{60 * ms, 90 * ms, 95 * ms, 130 * ms, l},
+		})
+	topo.placeReplicas(replicas)
+	return topo
+}
+
+//
+// ----------------------------- NETWORK FUNCTIONS ----------------------------
+//
+func (rn *Network) SetTopology(topo *Topology) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	for from, fromSite := range topo.site {
+		for to, toSite := range topo.site {
+			if from != to {
+				rn.linkLatency[link{from, to}] = ConstantLatency{topo.Latency[fromSite][toSite]}
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package network
+
+// Transport abstraction shared by the protocols
+//
+// XPaxos and PBFT only talk to their peers through a Transport, so the same protocol code (and
+// the same test harness) runs over the simulated network, where *ClientEnd is the Transport,
+// or over any other transport that provides the same call semantics
+//
+// => A Transport returns true only if the reply is valid and false if the request or reply was
+//    lost, timed out or the peer is down (exactly like ClientEnd.Call())
+// => CallStream() sends bulk data as a chunk stream of such calls (see stream.go)
+
+import (
+	"time"
+)
+
+type Transport interface {
+	Call(svcMeth string, args interface{}, reply interface{}, callerId int) bool
+	CallPriority(svcMeth string, args interface{}, reply interface{}, callerId int, priority int) bool
+	CallTimeout(svcMeth string, args interface{}, reply interface{}, callerId int, timeout time.Duration) bool
+	Send(svcMeth string, args interface{}, callerId int)
+	CallStream(svcMeth string, data []byte, chunkSize int, callerId int) bool
+}
+
+var _ Transport = &ClientEnd{} // The simulated network is a Transport
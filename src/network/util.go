@@ -1,17 +1,6 @@
 package network
 
-import "log"
+import "github.com/csanti/cos518_project/src/debug"
 
-func dPrintf(format string, a ...interface{}) (n int, err error) {
-	if DEBUG > 1 {
-		log.Printf(format, a...)
-	}
-	return
-}
-
-func iPrintf(format string, a ...interface{}) (n int, err error) {
-	if DEBUG > 0 {
-		log.Printf(format, a...)
-	}
-	return
-}
+// Records of the network carry the servers of a dropped message as "from" and "to"
+var logger = debug.MakeLogger(debug.NETWORK)
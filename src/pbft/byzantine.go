@@ -0,0 +1,90 @@
+package pbft
+
+// Byzantine behavior of PBFT servers
+//
+// cfg.setByzantine(server, strategy) - Makes a PBFT server follow a Byzantine strategy
+//
+// A Byzantine server tampers with the pre-prepare, prepare and commit messages it sends; its RPC
+// handlers stay correct
+//
+// => HONEST           - Follow the protocol
+// => CORRUPTSIGNATURE - Reshuffle the bytes of the signature of every message
+// => DROP             - Silently drop every message (the sender sees the RPC fail)
+// => EQUIVOCATE       - Send every server a different request (pre-prepares and prepares) or a
+//                       different digest (commits)
+// => LIEVIEW          - Claim to be one view ahead of the current view
+//
+// => At most f = (n-2)/3 PBFT servers may be Byzantine at the same time (n-1 = 3f+1 replicas)
+
+import (
+	"math/rand"
+)
+
+const ( // Byzantine strategies
+	HONEST           = iota
+	CORRUPTSIGNATURE = iota
+	DROP             = iota
+	EQUIVOCATE       = iota
+	LIEVIEW          = iota
+)
+
+// Returns the pre-prepare or prepare entry to send to server and whether to send it at all
+func (pbft *Pbft) tamperPrepare(server int, prepareEntry PrepareLogEntry) (PrepareLogEntry, bool) {
+	pbft.mu.Lock()
+	strategy := pbft.byzantine
+	pbft.mu.Unlock()
+
+	switch strategy {
+	case CORRUPTSIGNATURE:
+		prepareEntry.Msg0.Signature = shuffle(prepareEntry.Msg0.Signature)
+	case DROP:
+		return prepareEntry, false
+	case EQUIVOCATE:
+		prepareEntry.Request.Operation = server // A different operation for every server
+	case LIEVIEW:
+		prepareEntry.Msg0.View++
+	}
+
+	return prepareEntry, true
+}
+
+// Returns the commit message to send to server and whether to send it at all
+func (pbft *Pbft) tamperCommit(server int, msg CommitMessage) (CommitMessage, bool) {
+	pbft.mu.Lock()
+	strategy := pbft.byzantine
+	pbft.mu.Unlock()
+
+	switch strategy {
+	case CORRUPTSIGNATURE:
+		msg.Msg.Signature = shuffle(msg.Msg.Signature)
+	case DROP:
+		return msg, false
+	case EQUIVOCATE:
+		msg.Msg.MsgDigest = digest(server) // A different digest for every server
+		msg.Msg.Signature = pbft.sign(msg.Msg.MsgDigest)
+	case LIEVIEW:
+		msg.Msg.View++
+	}
+
+	return msg, true
+}
+
+// Copy of a signature with its bytes reshuffled (by a permutation derived from the signature, so
+// that runs with the same seed tamper with messages in the same way)
+func shuffle(signature []byte) []byte {
+	shuffled := make([]byte, len(signature))
+	copy(shuffled, signature)
+
+	var seed int64
+	for _, b := range signature {
+		seed = seed*31 + int64(b)
+	}
+	r := rand.New(rand.NewSource(seed))
+
+	for i := len(shuffled) - 1; i > 0; i-- {
+		j := r.Intn(i + 1)
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+
+	return shuffled
+}
@@ -0,0 +1,207 @@
+package pbft
+
+// Checkpoints of the state machine and truncation of the logs below them
+//
+// pbft.SetCheckpointInterval(k) - Takes a checkpoint every k applied requests (0 = never)
+// pbft.StableCheckpoint()       - Sequence number of the last stable checkpoint
+// compareCheckpoints(cfg)       - Fails the test if servers saw stable checkpoints of different states
+//
+// => A checkpoint is a snapshot of the state machine (see statemachine) after the request with
+//    sequence number SeqNum
+// => PBFT servers do not persist their state, so checkpoints only bound the memory of long runs
+// => A server that takes a checkpoint sends a signed CHECKPOINT message with the hash of its state
+//    machine (see statemachine) to all other servers; a checkpoint is stable at a server once 2f+1
+//    servers (itself included) sent the same hash for its sequence number, and a server whose
+//    hash differs from a stable one holds a diverged state
+// => Checkpoints also carry the head of the hash chain of the requests applied up to them (every
+//    link hashes the previous head, the sequence number and the digest of the request), which
+//    CHECKPOINT messages sign with the hash: 2f+1 servers must agree on both for the checkpoint to
+//    become stable, so a server whose history differs from a stable one (even one that leads to
+//    the same state) is detected like one whose state differs
+// => Once its own checkpoint is stable, a server drops the prepare and commit log entries below
+//    it, which 2f+1 servers executed: the logs start at the entry of the stable checkpoint
+//    (pbft.truncated), so they hold about one checkpoint interval of entries plus those in flight
+//    whatever the number of requests, and late prepares and commits of dropped entries are ignored
+// => The tests record every stable checkpoint and check that all servers agree on its hash
+
+import (
+	"github.com/csanti/cos518_project/src/journal"
+)
+
+func (pbft *Pbft) SetCheckpointInterval(interval int) {
+	pbft.mu.Lock()
+	defer pbft.mu.Unlock()
+
+	pbft.interval = interval
+}
+
+func (pbft *Pbft) StableCheckpoint() int {
+	pbft.mu.Lock()
+	defer pbft.mu.Unlock()
+
+	return pbft.stable
+}
+
+type checkpointDigest struct {
+	SeqNum int
+	Hash   [32]byte
+	Chain  [32]byte
+}
+
+type stateVote struct { // State and history of a server at a checkpoint
+	Hash  [32]byte
+	Chain [32]byte
+}
+
+type chainLink struct {
+	Prev   [32]byte // Head of the chain of the requests before
+	SeqNum int
+	Digest [32]byte // Of the request
+}
+
+// Head of the hash chain of the requests of entries extending head, that of the requests before
+// sequence number from (that of the first of entries)
+func extendChain(head [32]byte, entries []CommitLogEntry, from int) [32]byte {
+	for i, entry := range entries {
+		head = digest(chainLink{head, from + i, digest(entry.Request)})
+	}
+	return head
+}
+
+// Take a checkpoint of the requests applied so far; must be called with pbft.mu held
+func (pbft *Pbft) takeCheckpoint() {
+	previous := pbft.checkpoint
+	entries := pbft.commitLog[previous.SeqNum+1-pbft.truncated : pbft.applied+1-pbft.truncated] // Since previous
+	pbft.checkpoint = Checkpoint{
+		SeqNum:   pbft.applied,
+		Snapshot: pbft.stateMachine.Snapshot(),
+		Hash:     pbft.stateMachine.Hash(),
+		Chain:    extendChain(previous.Chain, entries, previous.SeqNum+1)}
+
+	msgDigest := digest(checkpointDigest{pbft.checkpoint.SeqNum, pbft.checkpoint.Hash, pbft.checkpoint.Chain})
+	msg := CheckpointMessage{
+		Msg: Message{
+			MsgType:       CHECKPOINT,
+			MsgDigest:     msgDigest,
+			Signature:     pbft.sign(msgDigest),
+			PrepareSeqNum: pbft.checkpoint.SeqNum,
+			View:          pbft.view,
+			SenderId:      pbft.id},
+		Hash:  pbft.checkpoint.Hash,
+		Chain: pbft.checkpoint.Chain}
+
+	for server, _ := range pbft.replicas {
+		if server != CLIENT && server != pbft.id {
+			go pbft.issueCheckpoint(server, msg)
+		}
+	}
+	pbft.vote(pbft.id, pbft.checkpoint.SeqNum, stateVote{pbft.checkpoint.Hash, pbft.checkpoint.Chain})
+
+	pbft.log().With("seqNum", pbft.applied).Debugf("Checkpoint: taken")
+	pbft.record(journal.CHECKPOINTED, pbft.applied)
+	for seqNum, _ := range pbft.results { // Results since the last checkpoint may still be replied
+		if seqNum <= previous.SeqNum {
+			delete(pbft.results, seqNum)
+		}
+	}
+}
+
+//
+// ------------------------------- CHECKPOINT RPC -----------------------------
+//
+func (pbft *Pbft) sendCheckpoint(server int, msg CheckpointMessage, reply *Reply) bool {
+	pbft.log().With("seqNum", msg.Msg.PrepareSeqNum).Debugf("Checkpoint: to Pbft server (%d)", server)
+	return pbft.replicas[server].Call("Pbft.Checkpoint", msg, reply, pbft.id)
+}
+
+func (pbft *Pbft) issueCheckpoint(server int, msg CheckpointMessage) {
+	reply := &Reply{}
+	pbft.sendCheckpoint(server, msg, reply)
+}
+
+func (pbft *Pbft) Checkpoint(msg CheckpointMessage, reply *Reply) {
+	if digest(checkpointDigest{msg.Msg.PrepareSeqNum, msg.Hash, msg.Chain}) != msg.Msg.MsgDigest ||
+		pbft.verify(msg.Msg.SenderId, msg.Msg.MsgDigest, msg.Msg.Signature) == false {
+		return
+	}
+
+	pbft.mu.Lock()
+	defer pbft.mu.Unlock()
+
+	pbft.vote(msg.Msg.SenderId, msg.Msg.PrepareSeqNum, stateVote{msg.Hash, msg.Chain})
+	reply.Success = true
+}
+
+// Record the checkpoint of a server and check whether it became stable; must be called with
+// pbft.mu held
+func (pbft *Pbft) vote(server int, seqNum int, checkpoint stateVote) {
+	if seqNum <= pbft.stable {
+		return
+	}
+	if pbft.votes[seqNum] == nil {
+		pbft.votes[seqNum] = make(map[int]stateVote)
+	}
+	pbft.votes[seqNum][server] = checkpoint
+
+	counts := make(map[stateVote]int)
+	for _, v := range pbft.votes[seqNum] {
+		counts[v]++
+	}
+	f := (len(pbft.replicas) - 2) / 3
+	for v, count := range counts {
+		if count < 2*f+1 {
+			continue
+		}
+
+		own, ok := pbft.votes[seqNum][pbft.id]
+		if ok == false {
+			return // Stable once this server took the checkpoint too
+		}
+		if own.Hash != v.Hash {
+			pbft.log().With("seqNum", seqNum).Infof("Checkpoint: diverged from a stable checkpoint")
+		} else if own.Chain != v.Chain {
+			pbft.log().With("seqNum", seqNum).Infof("Checkpoint: history diverged from a stable checkpoint")
+		}
+
+		pbft.stable = seqNum
+		pbft.record(journal.STABLE, seqNum)
+		for s, _ := range pbft.votes {
+			if s <= seqNum {
+				delete(pbft.votes, s)
+			}
+		}
+		pbft.truncate(seqNum)
+		if pbft.onStable != nil {
+			pbft.onStable(pbft.id, seqNum, own.Hash)
+		}
+		return
+	}
+}
+
+// Drop the log entries below seqNum, that of the stable checkpoint; must be called with pbft.mu held
+func (pbft *Pbft) truncate(seqNum int) {
+	prepares := seqNum - pbft.truncated
+	if prepares > len(pbft.prepareLog) {
+		prepares = len(pbft.prepareLog)
+	}
+	pbft.prepareLog = append(make([]PrepareLogEntry, 0, len(pbft.prepareLog)-prepares), pbft.prepareLog[prepares:]...)
+
+	commits := seqNum - pbft.truncated
+	if commits > len(pbft.commitLog) {
+		commits = len(pbft.commitLog)
+	}
+	pbft.commitLog = append(make([]CommitLogEntry, 0, len(pbft.commitLog)-commits), pbft.commitLog[commits:]...)
+
+	pbft.log().With("seqNum", seqNum).Debugf("Checkpoint: truncated %d entries", commits)
+	pbft.truncated = seqNum
+}
+
+// Length of the prepare log, truncated entries included
+func (pbft *Pbft) prepareLength() int {
+	return pbft.truncated + len(pbft.prepareLog)
+}
+
+// Length of the commit log, truncated entries included
+func (pbft *Pbft) commitLength() int {
+	return pbft.truncated + len(pbft.commitLog)
+}
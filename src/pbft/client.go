@@ -1,15 +1,18 @@
 package pbft
 
 import (
-	"network"
+	crand "crypto/rand"
+	"github.com/csanti/cos518_project/src/network"
 	"time"
 )
 
+const NONCESIZE = 16 // Bytes of the nonce of a client request
+
 //
 // ---------------------------- REPLICATE/REPLY RPC ---------------------------
 //
 func (client *Client) sendReplicate(server int, request ClientRequest, reply *Reply) bool {
-	iPrintf("Replicate: from client server (%d) to Pbft server (%d)\n", CLIENT, server)
+	clientLogger.With("trace", request.TraceId).Debugf("Replicate: to Pbft server (%d)", server)
 	return client.replicas[server].Call("Pbft.Replicate", request, reply, CLIENT)
 }
 
@@ -21,20 +24,35 @@ func (client *Client) issueReplicate(server int, request ClientRequest, replyCh
 }
 
 func (client *Client) Propose(op interface{}) bool { // For simplicity, we assume the client's proposal is correct
+	_, ok := client.Execute(op)
+	return ok
+}
+
+// Returns the result of the state machine that more than f replicas agree on (nil if the replicas
+// have none or it could not be told, i.e. some of them have not applied the request yet) and
+// whether the request committed
+func (client *Client) Execute(op interface{}) ([]byte, bool) {
 	var timer <-chan time.Time
+	client.mu.Lock()
 	client.timestamp++
-	//client.mu.Lock()
+	client.traceId = network.NewTraceId()
+	client.nonce = newNonce()
 	request := ClientRequest{
 		MsgType:   REPLICATE,
 		Timestamp: client.timestamp,
 		Operation: op,
-		ClientId:  CLIENT}
+		ClientId:  CLIENT,
+		Nonce:     client.nonce,
+		TraceId:   client.traceId}
 
 	replyCh := make(chan bool)
-	client.vcCh = make(chan bool)
+	committedCh := make(chan bool)
+	client.committedCh[request.Timestamp] = committedCh
 
 	rM := make(map[int]bool)
 	client.replyMap = append(client.replyMap, rM)
+	client.results = append(client.results, make(map[int][]byte))
+	client.mu.Unlock()
 
 	for server, _ := range client.replicas {
 		if server != CLIENT {
@@ -43,55 +61,101 @@ func (client *Client) Propose(op interface{}) bool { // For simplicity, we assum
 	}
 
 	if WAIT == false {
-		timer = time.NewTimer(TIMEOUT * time.Millisecond).C
+		timer = client.clock.After(TIMEOUT * time.Millisecond)
 	}
 
-	//client.mu.Unlock()
-
+	logger := clientLogger.With("timestamp", request.Timestamp, "trace", request.TraceId)
 	select {
 	case <-timer:
-		iPrintf("Timeout: Client.Propose: client server (%d)\n", CLIENT)
-		return false
+		logger.Infof("Timeout: Client.Propose")
+		return nil, false
 	case <-replyCh:
-		iPrintf("Success: committed request (%d)\n", client.timestamp)
-		return true
-	case <-client.vcCh:
-		iPrintf("Success: committed request after view change (%d)", client.timestamp)
-		return true
+		logger.Infof("Success: committed request")
+	case <-committedCh:
+		logger.Infof("Success: committed request")
 	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.result, true
 }
 
+// The request of the client is committed after a view change
 func (client *Client) ConfirmVC(msg Message, reply *Reply) {
-	client.vcCh <- true
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	clientLogger.With("timestamp", client.timestamp).Debugf("ConfirmVC: committed after view change")
+	client.signalCommitted(client.timestamp)
 }
 
 func (client *Client) Reply(creply ClientReply, reply *Reply) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	iPrintf("%d %d %d %d", len(client.replyMap), creply.Timestamp, len(client.replyMap[creply.Timestamp]), creply.Commiter)
+	if creply.Timestamp < 0 || creply.Timestamp >= len(client.replyMap) { // Not a request of the client
+		clientLogger.With("timestamp", creply.Timestamp, "trace", creply.TraceId).Infof(
+			"Reply: invalid timestamp from Pbft server (%d)", creply.Commiter)
+		return
+	}
+	clientLogger.With("timestamp", creply.Timestamp, "trace", creply.TraceId).Debugf("Reply: from Pbft server (%d), %d replies",
+		creply.Commiter, len(client.replyMap[creply.Timestamp]))
 	client.replyMap[creply.Timestamp][creply.Commiter] = true
+	client.results[creply.Timestamp][creply.Commiter] = creply.Result
 	if len(client.replyMap[creply.Timestamp]) >= 2*(len(client.replicas)-2)/3 && client.committed < creply.Timestamp {
 		client.committed = creply.Timestamp
-		iPrintf("committed: %d", client.committed)
-		client.vcCh <- true
+		client.result = client.agreedResult(creply.Timestamp)
+		clientLogger.With("timestamp", client.committed, "trace", creply.TraceId).Debugf("Reply: committed")
+		client.signalCommitted(creply.Timestamp)
+	}
+}
+
+// Wakes up the proposal of the request with timestamp, if it is still waiting, without ever
+// blocking (i.e. after it timed out); must be called with client.mu held
+func (client *Client) signalCommitted(timestamp int) {
+	if committedCh, ok := client.committedCh[timestamp]; ok {
+		close(committedCh)
+		delete(client.committedCh, timestamp)
 	}
 }
 
+// Result that more than f replicas replied with (nil if none); must be called with client.mu held
+func (client *Client) agreedResult(timestamp int) []byte {
+	counts := make(map[string]int)
+	for _, result := range client.results[timestamp] {
+		if result == nil {
+			continue
+		}
+		counts[string(result)]++
+		if counts[string(result)] > (len(client.replicas)-2)/3 {
+			return result
+		}
+	}
+	return nil
+}
+
 func (client *Client) RePropose(op interface{}) bool {
 	var timer <-chan time.Time
-	iPrintf("Repropose")
-	//client.mu.Lock()
+	client.mu.Lock()
+	clientLogger.With("trace", client.traceId).Debugf("Repropose")
 	request := ClientRequest{
 		MsgType:   REPLICATE,
 		Timestamp: client.timestamp,
 		Operation: op,
-		ClientId:  CLIENT}
+		ClientId:  CLIENT,
+		Nonce:     client.nonce,
+		TraceId:   client.traceId}
 
+	if client.committed >= request.Timestamp { // Committed since the proposal timed out
+		client.mu.Unlock()
+		return true
+	}
 	replyCh := make(chan bool)
-	client.vcCh = make(chan bool)
-
-	//rM := make(map[int]bool)
-	//client.replyMap = append(client.replyMap, rM)
+	committedCh, ok := client.committedCh[request.Timestamp]
+	if ok == false {
+		committedCh = make(chan bool)
+		client.committedCh[request.Timestamp] = committedCh
+	}
+	client.mu.Unlock()
 
 	for server, _ := range client.replicas {
 		if server != CLIENT {
@@ -100,36 +164,54 @@ func (client *Client) RePropose(op interface{}) bool {
 	}
 
 	if WAIT == false {
-		timer = time.NewTimer(TIMEOUT * time.Millisecond).C
+		timer = client.clock.After(TIMEOUT * time.Millisecond)
 	}
 
+	logger := clientLogger.With("timestamp", request.Timestamp, "trace", request.TraceId)
 	select {
 	case <-timer:
-		iPrintf("Timeout: Client.Propose: client server (%d)\n", CLIENT)
+		logger.Infof("Timeout: Client.Propose")
 		return false
 	case <-replyCh:
-		iPrintf("Success: committed request (%d)\n", client.timestamp)
+		logger.Infof("Success: committed request")
 		return true
-	case <-client.vcCh:
-		iPrintf("Success: committed request after view change (%d)", client.timestamp)
+	case <-committedCh:
+		logger.Infof("Success: committed request")
 		return true
 	}
 }
 
+// Every request carries a random nonce, which the digest signed by the messages of its agreement
+// covers, so that they cannot pass for those of another request with the same timestamp and operation
+func newNonce() []byte {
+	nonce := make([]byte, NONCESIZE)
+	crand.Read(nonce)
+	return nonce
+}
+
+// Requests without a nonce of their client are not pre-prepared
+func validNonce(request ClientRequest) bool {
+	return len(request.Nonce) == NONCESIZE
+}
+
 //
 // ------------------------------- MAKE FUNCTION ------------------------------
 //
-func MakeClient(replicas []*network.ClientEnd) *Client {
+func MakeClient(replicas []network.Transport) *Client {
 	client := &Client{}
 
 	client.mu.Lock()
 	client.replicas = replicas
+	client.clock = network.RealClock{}
 	client.timestamp = 0
 	client.committed = -1
-	client.vcCh = make(chan bool)
+	client.committedCh = make(map[int]chan bool)
 	client.replyMap = make([]map[int]bool, 0)
 	rM := make(map[int]bool)
 	client.replyMap = append(client.replyMap, rM)
+	client.results = make([]map[int][]byte, 0)
+	client.results = append(client.results, make(map[int][]byte))
+	client.result = nil
 
 	client.mu.Unlock()
 
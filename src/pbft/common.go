@@ -1,17 +1,23 @@
 package pbft
 
 import (
-	"crypto/rsa"
-	"network"
+	"crypto"
+	"github.com/csanti/cos518_project/src/histogram"
+	"github.com/csanti/cos518_project/src/journal"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
-const DEBUG = 0      // Debugging (0 = None, 1 = Info, 2 = Debug)
 const CLIENT = 0     // Client ID is always set to zero - DO NOT CHANGE
 const TIMEOUT = 500  // Client timeout period (in milliseconds)
 const WAIT = false   // If false, client times out after TIMEOUT milliseconds; if true, client never times out
-const BITSIZE = 1024 // RSA private key bit size
+const MAXGAP = 1 << 16 // Furthest a message may place a sequence number beyond the end of a log
 
 const ( // RPC message types for common case and view change protocols
 	REPLICATE  = iota
@@ -23,35 +29,53 @@ const ( // RPC message types for common case and view change protocols
 	VIEWCHANGE = iota
 	VCFINAL    = iota
 	NEWVIEW    = iota
+	CHECKPOINT = iota
 )
 
 type config struct {
 	mu          sync.Mutex
 	t           *testing.T
 	net         *network.Network
-	n           int   // Total number of client and PBFT servers
-	done        int32 // Tell internal threads to die
+	n           int        // Total number of client and PBFT servers
+	start       time.Time  // When the config was made (see cfg.running())
+	rand        *rand.Rand // Source of random choices of tests (seeded with -seed)
+	done        int32      // Tell internal threads to die
 	pbftServers []*Pbft
 	client      *Client
 	connected   []bool     // Whether each server is on the net
 	endnames    [][]string // The port file names each sends to
-	privateKeys map[int]*rsa.PrivateKey
-	publicKeys  map[int]*rsa.PublicKey
+	privateKeys map[int]crypto.Signer
+	publicKeys  map[int]crypto.PublicKey
+	freshKeys   bool                 // Servers get fresh keys instead of pooled ones (see pooledKeys())
+	scheme      signing.Scheme       // Signature scheme of the servers' keys (see signing)
+	latencies   *histogram.Histogram // Latencies of proposals made through cfg.propose
+	budgetRPCs  int                  // RPCs issued when the RPC budget began (see cfg.beginRPCBudget())
+	budgetBytes int64                // Bytes sent when the RPC budget began
+	machines    []*statemachine.Log  // State machine of each PBFT server
+	stable      map[int][32]byte     // Sequence number -> state hash of its first stable checkpoint
+	stableBy    map[int]int          // Sequence number -> server that first saw it stable
+	diverged    string               // First stable checkpoint with different hashes (see checkpoint.go)
 }
 
 type Client struct {
 	mu        sync.Mutex
-	replicas  []*network.ClientEnd
+	replicas  []network.Transport
+	clock     network.Clock
 	timestamp int
+	traceId   string // Trace ID of the last request (resent by RePropose())
+	nonce     []byte // Nonce of the last request (resent by RePropose())
 	committed int
-	vcCh      chan bool
+	// Timestamp -> closed once the request committed (see client.signalCommitted())
+	committedCh map[int]chan bool
 	// Must include statistics for evaluation
 	replyMap []map[int]bool
+	results  []map[int][]byte // Timestamp -> replica -> result of its state machine
+	result   []byte           // Result of the last committed request (see client.Execute())
 }
 
 type Pbft struct {
 	mu               sync.Mutex
-	replicas         []*network.ClientEnd
+	replicas         []network.Transport
 	synchronousGroup map[int]bool
 	id               int
 	view             int
@@ -59,8 +83,40 @@ type Pbft struct {
 	executeSeqNum    int
 	prepareLog       []PrepareLogEntry
 	commitLog        []CommitLogEntry
-	privateKey       *rsa.PrivateKey
-	publicKeys       map[int]*rsa.PublicKey
+	signer           signing.Signer // Private key of the server (see signing)
+	publicKeys       map[int]crypto.PublicKey
+	sessions         *signing.Sessions // Session keys with the other servers (see session.go)
+	byzantine        int               // Byzantine strategy (see byzantine.go)
+	failMu           sync.Mutex
+	failpoints       map[int]*failpoint        // Armed failpoints (see failpoint.go)
+	clock            network.Clock             // Source of time for failpoint delays and pre-prepares; guarded by failMu
+	stateMachine     statemachine.StateMachine // Service driven by the executor (nil if none)
+	applied          int                       // Sequence number of the last request applied to it
+	results          map[int][]byte            // Sequence number -> result of the state machine
+	checkpoint       Checkpoint                // Last checkpoint taken (see checkpoint.go)
+	interval         int                       // Applied requests between checkpoints (0 = none)
+	truncated        int                       // Sequence number of the first entry of the logs
+	votes            map[int]map[int]stateVote // Sequence number -> server -> state of its checkpoint
+	stable           int                       // Sequence number of the last stable checkpoint
+	onStable         func(int, int, [32]byte)  // Called with every stable checkpoint (tests)
+	logger           atomic.Value              // *debug.Logger of the server (see pbft.log())
+	tracer           atomic.Value              // *tracing.Tracer of the server (nil for none, see SetTracer())
+	journal          *journal.Journal          // Significant transitions of the server (see Journal())
+	signTime         int64                     // Nanoseconds spent signing (atomic, see pbft.CryptoTime())
+	verifyTime       int64                     // Nanoseconds spent verifying signatures (atomic)
+}
+
+type Checkpoint struct {
+	SeqNum   int      // Sequence number of the last request applied to the snapshot
+	Snapshot []byte   // Snapshot of the state machine
+	Hash     [32]byte // Hash of the state machine (see statemachine)
+	Chain    [32]byte // Head of the hash chain of the requests applied (see checkpoint.go)
+}
+
+type CheckpointMessage struct {
+	Msg   Message // Msg.PrepareSeqNum is the checkpoint's sequence number
+	Hash  [32]byte
+	Chain [32]byte
 }
 
 type PrepareLogEntry struct {
@@ -82,6 +138,8 @@ type ClientRequest struct {
 	Timestamp int
 	Operation interface{}
 	ClientId  int
+	Nonce     []byte // Random, chosen by the client for every request (see client.go)
+	TraceId   string // Assigned by the client (see network.NewTraceId())
 }
 
 type Message struct {
@@ -92,6 +150,8 @@ type Message struct {
 	View            int
 	ClientTimestamp int
 	SenderId        int
+	TraceId         string // Of the client request ("" for checkpoints)
+	Time            int64  // Pre-prepare or commit: primary's time of the entry in nanoseconds
 }
 
 type CommitMessage struct {
@@ -110,4 +170,6 @@ type Reply struct {
 type ClientReply struct {
 	Commiter  int
 	Timestamp int
+	Result    []byte // Result of the state machine (nil if not applied yet)
+	TraceId   string
 }
@@ -1,15 +1,49 @@
 package pbft
 
 import (
+	"crypto"
 	crand "crypto/rand"
-	"crypto/rsa"
 	"encoding/base64"
-	"network"
+	"fmt"
+	"github.com/csanti/cos518_project/src/histogram"
+	"github.com/csanti/cos518_project/src/memstats"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/profiling"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"math/rand"
 	"runtime"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// Cluster parameters set by go test flags (see test_test.go); zero values keep those of each test
+type parameters struct {
+	n          int  // Total number of client and PBFT servers (takes precedence over f)
+	f          int  // Number of faults to tolerate: n = 3f+2 (PBFT servers = 3f+1)
+	unreliable bool // Run every test on an unreliable network
+	seed       int64
+	duration   time.Duration  // Closed-loop tests propose until the duration elapses (see cfg.running())
+	freshKeys  bool           // Every test generates fresh RSA keys instead of using pooled ones
+	scheme     signing.Scheme // Signature scheme of the keys of every test (signing.DEFAULT unless set)
+	memSample  time.Duration  // How often benchmarks sample memory (0 = only before and after)
+	heapDir    string         // Benchmarks dump a heap profile here at every memory sample (if set)
+	cpuDir     string         // Benchmarks write a CPU profile of their measurement window here (if set)
+	mutexDir   string         // Benchmarks write a mutex contention profile here (if set)
+}
+
+var params parameters
+
+func (p parameters) apply(n int, unreliable bool) (int, bool) {
+	if p.n > 0 {
+		n = p.n
+	} else if p.f > 0 {
+		n = 3*p.f + 2
+	}
+	return n, unreliable || p.unreliable
+}
+
 func randstring(n int) string {
 	b := make([]byte, 2*n)
 	crand.Read(b)
@@ -18,17 +52,41 @@ func randstring(n int) string {
 }
 
 func makeConfig(t *testing.T, n int, unreliable bool) *config {
+	return makeConfigKeys(t, n, unreliable, params.freshKeys, params.scheme)
+}
+
+// Like makeConfig() but with keys no other test used (see pooledKeys())
+func makeConfigFreshKeys(t *testing.T, n int, unreliable bool) *config {
+	return makeConfigKeys(t, n, unreliable, true, params.scheme)
+}
+
+// Like makeConfig() but with keys of scheme, whatever -scheme says
+func makeConfigScheme(t *testing.T, n int, unreliable bool, scheme signing.Scheme) *config {
+	return makeConfigKeys(t, n, unreliable, params.freshKeys, scheme)
+}
+
+func makeConfigKeys(t *testing.T, n int, unreliable bool, freshKeys bool, scheme signing.Scheme) *config {
 	runtime.GOMAXPROCS(8)
 	cfg := &config{}
+	n, unreliable = params.apply(n, unreliable)
 	cfg.t = t
 	cfg.net = network.MakeNetwork()
 	cfg.n = n
+	cfg.start = time.Now()
+	cfg.rand = makeRand()
+	cfg.net.Seed(cfg.rand.Int63()) // Network decisions also follow -seed
 	cfg.pbftServers = make([]*Pbft, cfg.n)
 	cfg.client = &Client{}
 	cfg.connected = make([]bool, cfg.n)
 	cfg.endnames = make([][]string, cfg.n)
-	cfg.privateKeys = make(map[int]*rsa.PrivateKey, cfg.n)
-	cfg.publicKeys = make(map[int]*rsa.PublicKey, cfg.n)
+	cfg.privateKeys = make(map[int]crypto.Signer, cfg.n)
+	cfg.publicKeys = make(map[int]crypto.PublicKey, cfg.n)
+	cfg.freshKeys = freshKeys
+	cfg.scheme = scheme
+	cfg.latencies = histogram.MakeHistogram()
+	cfg.machines = make([]*statemachine.Log, cfg.n)
+	cfg.stable = make(map[int][32]byte)
+	cfg.stableBy = make(map[int]int)
 
 	cfg.setUnreliable(unreliable)
 	cfg.net.LongDelays(false)
@@ -86,21 +144,28 @@ func (cfg *config) start1(i int) {
 	}
 
 	// A fresh set of ClientEnds
-	ends := make([]*network.ClientEnd, cfg.n)
+	ends := make([]network.Transport, cfg.n)
 	for j := 0; j < cfg.n; j++ {
 		ends[j] = cfg.net.MakeEnd(cfg.endnames[i][j])
 		cfg.net.Connect(cfg.endnames[i][j], j)
 	}
 
-	// A fresh pair of RSA private/public keys
-	privateKey, publicKey := generateKeys()
+	// A pair of private/public keys of the config's scheme
+	privateKey, publicKey := cfg.keys(i)
 	cfg.privateKeys[i] = privateKey
 	cfg.publicKeys[i] = publicKey
 
-	pbft := Make(ends, i, cfg.privateKeys[i], cfg.publicKeys)
+	pbft := Make(ends, i, signing.KeySigner(cfg.privateKeys[i]), cfg.publicKeys)
+	pbft.clock = cfg.net.GetClock()
+	machine := statemachine.MakeLog()
+	pbft.SetStateMachine(machine)
+	pbft.mu.Lock()
+	pbft.onStable = cfg.checkpointStable
+	pbft.mu.Unlock()
 
 	cfg.mu.Lock()
 	cfg.pbftServers[i] = pbft
+	cfg.machines[i] = machine
 	cfg.mu.Unlock()
 
 	svc := network.MakeService(pbft)
@@ -139,13 +204,14 @@ func (cfg *config) startClient() {
 	}
 
 	// A fresh set of ClientEnds
-	ends := make([]*network.ClientEnd, cfg.n)
+	ends := make([]network.Transport, cfg.n)
 	for j := 0; j < cfg.n; j++ {
 		ends[j] = cfg.net.MakeEnd(cfg.endnames[CLIENT][j])
 		cfg.net.Connect(cfg.endnames[CLIENT][j], j)
 	}
 
 	client := MakeClient(ends)
+	client.clock = cfg.net.GetClock()
 
 	cfg.mu.Lock()
 	cfg.client = client
@@ -158,6 +224,10 @@ func (cfg *config) startClient() {
 }
 
 func (cfg *config) cleanup() {
+	if cfg.latencies.Count() > 0 {
+		fmt.Printf("Latency: %s\n", cfg.latencies.Summary())
+	}
+
 	if cfg.client != nil {
 		cfg.client.Kill()
 	}
@@ -171,6 +241,40 @@ func (cfg *config) cleanup() {
 	atomic.StoreInt32(&cfg.done, 1)
 }
 
+// Propose an operation through the client, reproposing it until it commits, and record its latency
+// in cfg.latencies
+func (cfg *config) propose(op interface{}) {
+	start := time.Now()
+	ok := cfg.client.Propose(op)
+	for ok == false {
+		time.Sleep(time.Duration(10) * time.Millisecond)
+		ok = cfg.client.RePropose(op)
+	}
+	cfg.latencies.Record(time.Since(start))
+}
+
+// Seeded with -seed if set (and with the time otherwise); all randomness of a test derives from it
+// (the test's own choices, network decisions and the seeds of workloads and nemeses), so that a
+// failing test can be re-run with the same random choices
+// => Concurrent RPCs may still be issued in a different order, which is up to the Go scheduler
+func makeRand() *rand.Rand {
+	seed := params.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	iPrintf("Seed: %d\n", seed)
+	return rand.New(rand.NewSource(seed))
+}
+
+// Whether a test loop goes on with iteration i: until iters iterations or, with -duration, until the
+// duration elapses since the config was made
+func (cfg *config) running(i int, iters int) bool {
+	if params.duration > 0 {
+		return time.Since(cfg.start) < params.duration
+	}
+	return i < iters
+}
+
 // Connect server i to the network
 func (cfg *config) connect(i int) {
 	if cfg.connected[i] == false {
@@ -229,6 +333,163 @@ func (cfg *config) rpcCount(server int) int {
 	return cfg.net.GetCount(server)
 }
 
+func (cfg *config) rpcBytes(server int) int64 {
+	return cfg.net.GetBytes(server)
+}
+
+// Total request and reply bytes handled by all servers (client included)
+func (cfg *config) resetStats() {
+	cfg.net.ResetStats()
+}
+
+func (cfg *config) totalBytes() int64 {
+	total := int64(0)
+	for i := 0; i < cfg.n; i++ {
+		total += cfg.rpcBytes(i)
+	}
+	return total
+}
+
+// RPCs issued (including failed ones) and request and reply bytes handled by all servers so far
+func (cfg *config) networkUsage() (int, int64) {
+	stats := cfg.net.Stats()
+
+	rpcs := 0
+	for _, ms := range stats.Methods {
+		rpcs += ms.Count
+	}
+
+	bytes := int64(0)
+	for _, ss := range stats.Servers {
+		bytes += ss.Bytes
+	}
+	return rpcs, bytes
+}
+
+// Start counting the RPCs and bytes that cfg.assertRPCBudget() bounds (unlike cfg.resetStats(),
+// the statistics of the network are kept)
+func (cfg *config) beginRPCBudget() {
+	cfg.budgetRPCs, cfg.budgetBytes = cfg.networkUsage()
+}
+
+// Fail the test if more than maxRPCs RPCs were issued or more than maxBytes bytes were sent since
+// cfg.beginRPCBudget() (0 = no bound), i.e. to enforce the message complexity of a protocol
+func (cfg *config) assertRPCBudget(maxRPCs int, maxBytes int64) {
+	rpcs, bytes := cfg.networkUsage()
+	rpcs -= cfg.budgetRPCs
+	bytes -= cfg.budgetBytes
+
+	if maxRPCs > 0 && rpcs > maxRPCs {
+		cfg.t.Fatalf("RPC budget exceeded: %d RPCs issued (at most %d)!", rpcs, maxRPCs)
+	}
+	if maxBytes > 0 && bytes > maxBytes {
+		cfg.t.Fatalf("RPC budget exceeded: %d bytes sent (at most %d)!", bytes, maxBytes)
+	}
+}
+
+// Drive the network, failpoint delays and the client's timeouts from clock (i.e. a
+// network.VirtualClock)
+func (cfg *config) setClock(clock network.Clock) {
+	cfg.net.SetClock(clock)
+
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	for i := 1; i < cfg.n; i++ {
+		if cfg.pbftServers[i] != nil {
+			cfg.pbftServers[i].failMu.Lock()
+			cfg.pbftServers[i].clock = clock
+			cfg.pbftServers[i].failMu.Unlock()
+		}
+	}
+
+	if cfg.client != nil {
+		cfg.client.clock = clock
+	}
+}
+
+func (cfg *config) setJitter(jitter time.Duration) {
+	cfg.net.SetJitter(jitter)
+}
+
+func (cfg *config) setTopology(topo *network.Topology) {
+	cfg.net.SetTopology(topo)
+}
+
+func (cfg *config) setCodec(codec network.Codec) {
+	cfg.net.SetCodec(codec)
+}
+
+func (cfg *config) setCompression(threshold int) {
+	cfg.net.SetCompression(threshold)
+}
+
+func (cfg *config) setConcurrency(server int, limit int) {
+	cfg.net.SetConcurrency(server, limit)
+}
+
+func (cfg *config) setSendRate(server int, rate int) {
+	cfg.net.SetSendRate(server, rate)
+}
+
+func (cfg *config) setCorruptionRate(rate int) {
+	cfg.net.SetCorruptionRate(rate)
+}
+
+// Make a PBFT server follow a Byzantine strategy (see byzantine.go); the test fails if more than
+// f = (n-2)/3 servers would be Byzantine at the same time
+func (cfg *config) setByzantine(server int, strategy int) {
+	numByzantine := 0
+	for i := 1; i < cfg.n; i++ {
+		pbft := cfg.pbftServers[i]
+		pbft.mu.Lock()
+		if i != server && pbft.byzantine != HONEST {
+			numByzantine++
+		}
+		pbft.mu.Unlock()
+	}
+
+	if strategy != HONEST && numByzantine+1 > (cfg.n-2)/3 {
+		cfg.t.Fatal("Too many Byzantine servers!")
+	}
+
+	pbft := cfg.pbftServers[server]
+	pbft.mu.Lock()
+	pbft.byzantine = strategy
+	pbft.mu.Unlock()
+}
+
+// Alternate the network between asynchronous periods of length async and synchronous periods of
+// length sync (starting with an asynchronous one) until gst elapses, after which the network stays
+// synchronous; returns the global stabilization time (GST)
+// => Liveness tests check that progress resumes after GST (see network/latency.go)
+func (cfg *config) partialSynchrony(async time.Duration, sync time.Duration, gst time.Duration) time.Time {
+	end := time.Now().Add(gst)
+	cfg.net.SetAsynchronous(true)
+
+	go func() {
+		asynchronous := true
+		for atomic.LoadInt32(&cfg.done) == 0 {
+			period := sync
+			if asynchronous {
+				period = async
+			}
+
+			if remaining := time.Until(end); remaining <= period {
+				time.Sleep(remaining)
+				cfg.net.SetAsynchronous(false)
+				return
+			}
+
+			time.Sleep(period)
+			asynchronous = !asynchronous
+			cfg.net.SetAsynchronous(asynchronous)
+		}
+	}()
+
+	return end
+}
+
 func (cfg *config) setUnreliable(unrel bool) {
 	cfg.net.Reliable(!unrel)
 }
@@ -236,3 +497,122 @@ func (cfg *config) setUnreliable(unrel bool) {
 func (cfg *config) setLongReordering(longrel bool) {
 	cfg.net.LongReordering(longrel)
 }
+
+// Fails the test unless every server establishes its session keys with every other one
+func (cfg *config) establishSessions() {
+	for i := 1; i < cfg.n; i++ {
+		cfg.mu.Lock()
+		pbft := cfg.pbftServers[i]
+		cfg.mu.Unlock()
+
+		if pbft != nil && pbft.EstablishSessions() != cfg.n-2 {
+			cfg.t.Fatalf("PBFT server (%d) failed to establish its session keys!", i)
+		}
+	}
+	cfg.checkSessions()
+}
+
+// Fails the test unless every running server checks the MACs of every other one within a second
+// (offers made in return of others may still be in flight)
+func (cfg *config) checkSessions() {
+	msgDigest := digest("session")
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		cfg.mu.Lock()
+		servers := append([]*Pbft{}, cfg.pbftServers...)
+		cfg.mu.Unlock()
+
+		failed := 0
+		for i := 1; i < cfg.n; i++ {
+			for j := 1; j < cfg.n; j++ {
+				if i != j && servers[i] != nil && servers[j] != nil &&
+					servers[j].checkMAC(i, msgDigest, servers[i].mac(j, msgDigest)) == false {
+					failed = j
+				}
+			}
+		}
+		if failed == 0 {
+			return
+		}
+		if time.Since(start) > time.Second {
+			cfg.t.Fatalf("PBFT server (%d) rejects the MACs of its session keys!", failed)
+		}
+	}
+}
+
+// Record a stable checkpoint seen by a server
+func (cfg *config) checkpointStable(server int, seqNum int, hash [32]byte) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	first, ok := cfg.stable[seqNum]
+	if ok == false {
+		cfg.stable[seqNum] = hash
+		cfg.stableBy[seqNum] = server
+	} else if first != hash && cfg.diverged == "" {
+		cfg.diverged = fmt.Sprintf("PBFT servers (%d) and (%d) hold different states at stable checkpoint %d!",
+			cfg.stableBy[seqNum], server, seqNum)
+	}
+}
+
+func compareCheckpoints(cfg *config) {
+	cfg.mu.Lock()
+	diverged := cfg.diverged
+	cfg.mu.Unlock()
+
+	if diverged != "" {
+		cfg.t.Fatal(diverged)
+	}
+}
+
+// Sample memory while a benchmark runs (see memstats/memstats.go), as set by -memsample and -heapdir
+func startMemStats() *memstats.Sampler {
+	return memstats.Start(params.memSample, params.heapDir)
+}
+
+// Report the allocations of a benchmark since startMemStats() per committed operation, to compare
+// the cost of serialization and batching changes
+func reportMemStats(s *memstats.Sampler, committed int, b *testing.B) {
+	sum := s.Stop()
+	b.ReportMetric(sum.AllocsPer(committed), "allocs/commit")
+	b.ReportMetric(sum.BytesPer(committed), "B/commit")
+	b.ReportMetric(float64(sum.PeakHeap), "peak-heap-B")
+}
+
+// Profile the CPU and mutex contention while a benchmark runs (see profiling/profiling.go), as set
+// by -cpudir and -mutexdir; the profiles of a benchmark are named after it
+func startProfiles(b *testing.B) *profiling.Profiler {
+	p, err := profiling.Start(profiling.FileName(params.cpuDir, b.Name(), "cpu"),
+		profiling.FileName(params.mutexDir, b.Name(), "mutex"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return p
+}
+
+func stopProfiles(p *profiling.Profiler, b *testing.B) {
+	if _, err := p.Stop(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func (cfg *config) setFailpoint(server int, point int, fp *failpoint) {
+	cfg.mu.Lock()
+	pbft := cfg.pbftServers[server]
+	cfg.mu.Unlock()
+
+	pbft.setFailpoint(point, fp)
+}
+
+func (cfg *config) failpointHits(server int, point int) int {
+	cfg.mu.Lock()
+	pbft := cfg.pbftServers[server]
+	cfg.mu.Unlock()
+
+	pbft.failMu.Lock()
+	defer pbft.failMu.Unlock()
+
+	if fp := pbft.failpoints[point]; fp != nil {
+		return fp.hits
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+package pbft
+
+// Failpoints of PBFT servers
+//
+// cfg.setFailpoint(server, point, fp) - Arms a failpoint of a PBFT server (nil disarms it)
+// cfg.failpointHits(server, point)    - Number of times the failpoint was triggered
+//
+// A failpoint is a place in the protocol where a test injects a fault the next few times (or every
+// time) a server gets there, so that narrow races can be triggered without changing the protocol
+// for every experiment:
+// => PREPREPAREPOINT - The leader sends a pre-prepare message to another server
+// => PREPAREPOINT    - A server sends a prepare message to another server
+// => COMMITPOINT     - A server sends a commit message to another server
+// => REPLYPOINT      - A server replies to the client
+//
+// => FAILDROP  - Skip the step: the message is lost (the sender sees the RPC fail)
+// => FAILDELAY - Delay the step by fp.delay on the server's clock
+//
+// i.e. "drop my next commit" at server 2:
+//      cfg.setFailpoint(2, COMMITPOINT, &failpoint{action: FAILDROP, count: 1})
+//
+// => PBFT has no persistent state, so unlike XPaxos there is no persistence failpoint
+//
+// => A restarted server (see cfg.start1()) has no failpoints armed
+
+import (
+	"time"
+)
+
+const ( // Failpoints
+	PREPREPAREPOINT = iota
+	PREPAREPOINT    = iota
+	COMMITPOINT     = iota
+	REPLYPOINT      = iota
+)
+
+const ( // Failpoint actions
+	FAILDROP  = iota
+	FAILDELAY = iota
+)
+
+type failpoint struct {
+	action int
+	delay  time.Duration
+	count  int // Remaining times the failpoint triggers (negative = every time)
+	hits   int // Times the failpoint triggered
+}
+
+func (pbft *Pbft) setFailpoint(point int, fp *failpoint) {
+	pbft.failMu.Lock()
+	defer pbft.failMu.Unlock()
+
+	if pbft.failpoints == nil {
+		pbft.failpoints = make(map[int]*failpoint)
+	}
+
+	if fp == nil {
+		delete(pbft.failpoints, point)
+	} else {
+		pbft.failpoints[point] = fp
+	}
+}
+
+// Whether the step at a failpoint goes ahead (after a delay if the failpoint is armed to delay it)
+func (pbft *Pbft) reachFailpoint(point int) bool {
+	pbft.failMu.Lock()
+	fp := pbft.failpoints[point]
+	if fp == nil || fp.count == 0 {
+		pbft.failMu.Unlock()
+		return true
+	}
+
+	if fp.count > 0 {
+		fp.count--
+	}
+	fp.hits++
+	action, delay, clock := fp.action, fp.delay, pbft.clock
+	pbft.failMu.Unlock()
+
+	pbft.log().Debugf("Failpoint: reached failpoint (%d)", point)
+
+	switch action {
+	case FAILDROP:
+		return false
+	case FAILDELAY:
+		clock.Sleep(delay)
+	}
+	return true
+}
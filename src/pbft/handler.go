@@ -0,0 +1,278 @@
+package pbft
+
+// Table-driven tests of single RPC handlers
+//
+// runHandlerCases(t, cases)               - Applies every case to a fresh PBFT server and checks it
+// h.prePrepare(seqNum, timestamp)         - Pre-prepare of the leader (see also h.prepare(), h.commit())
+// h.sign(j, msgDigest)                    - Signature of PBFT server j (i.e. to forge a message)
+//
+// A case (handlerCase) is a row of a table: the initial state of one PBFT server, one incoming
+// message and the expected reply, state and outgoing messages
+// => The peers of the server (and the client) are mocks (see mockEnd) that record the messages
+//    sent to them without running anything, so a case exercises exactly one handler and nothing
+//    is ever delivered back to the server
+// => Outgoing messages are listed as "<method> <to>" in sorted order; messages sent after the
+//    handler returned are collected until none was sent for HANDLERQUIET
+// => The state is that of the log entries of one sequence number (1 unless the case says
+//    otherwise), since the logs of a PBFT server are indexed by sequence number
+// => A setup function may apply earlier messages with h.apply(); messages it causes are not part
+//    of the outcome
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const HANDLERQUIET = 50 * time.Millisecond // No message sent for this long means the handler is done
+
+type handlerCase struct {
+	name   string
+	n      int                                 // Total number of client and PBFT servers (default 5)
+	id     int                                 // PBFT server handling the message
+	seqNum int                                 // Sequence number of the state (default 1)
+	setup  func(h *handlerHarness)             // Initial state (optional)
+	method string                              // Handler, i.e. "Pbft.Prepare"
+	msg    func(h *handlerHarness) interface{} // Incoming message
+	reply  Reply                               // Expected Success and IsLeader
+	state  handlerState                        // Expected state after the handler
+	sent   []string                            // Expected outgoing messages
+}
+
+type handlerState struct {
+	view          int
+	prepareSeqNum int
+	executeSeqNum int
+	prepares      int // Prepare messages logged for the sequence number
+	commits       int // Commit messages logged for the sequence number
+}
+
+type handlerHarness struct {
+	mu   sync.Mutex
+	t    *testing.T
+	n    int
+	pbft *Pbft
+	sent []string
+	last time.Time // When the last message was sent
+}
+
+// A peer of the server under test: records every message and answers that it was received
+type mockEnd struct {
+	h  *handlerHarness
+	to int
+}
+
+var _ network.Transport = &mockEnd{}
+
+func (e *mockEnd) Call(svcMeth string, args interface{}, reply interface{}, callerId int) bool {
+	return e.h.receive(e.to, svcMeth)
+}
+
+func (e *mockEnd) CallPriority(svcMeth string, args interface{}, reply interface{}, callerId int, priority int) bool {
+	return e.h.receive(e.to, svcMeth)
+}
+
+func (e *mockEnd) CallTimeout(svcMeth string, args interface{}, reply interface{}, callerId int,
+	timeout time.Duration) bool {
+	return e.h.receive(e.to, svcMeth)
+}
+
+func (e *mockEnd) Send(svcMeth string, args interface{}, callerId int) {
+	e.h.receive(e.to, svcMeth)
+}
+
+func (e *mockEnd) CallStream(svcMeth string, data []byte, chunkSize int, callerId int) bool {
+	return e.h.receive(e.to, svcMeth)
+}
+
+func makeHandlerHarness(t *testing.T, n int, id int) *handlerHarness {
+	h := &handlerHarness{}
+	h.t = t
+	h.n = n
+	h.sent = make([]string, 0)
+
+	replicas := make([]network.Transport, n)
+	publicKeys := make(map[int]crypto.PublicKey, n)
+	for j := 0; j < n; j++ {
+		replicas[j] = &mockEnd{h: h, to: j}
+		if j != CLIENT {
+			_, publicKeys[j] = pooledKeys(params.scheme, j)
+		}
+	}
+
+	privateKey, _ := pooledKeys(params.scheme, id)
+	h.pbft = Make(replicas, id, signing.KeySigner(privateKey), publicKeys)
+	return h
+}
+
+func (h *handlerHarness) receive(to int, svcMeth string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sent = append(h.sent, fmt.Sprintf("%s %d", svcMeth, to))
+	h.last = time.Now()
+	return true
+}
+
+// Delivers msg to the handler method of the server and returns its reply
+func (h *handlerHarness) apply(method string, msg interface{}) Reply {
+	handler := reflect.ValueOf(h.pbft).MethodByName(strings.TrimPrefix(method, "Pbft."))
+	if handler.IsValid() == false {
+		h.t.Fatalf("Unknown handler %s!", method)
+	}
+
+	reply := &Reply{}
+	handler.Call([]reflect.Value{reflect.ValueOf(msg), reflect.ValueOf(reply)})
+	return *reply
+}
+
+// Outgoing messages once none was sent for HANDLERQUIET
+func (h *handlerHarness) quiesce() []string {
+	for {
+		time.Sleep(HANDLERQUIET)
+
+		h.mu.Lock()
+		if time.Since(h.last) >= HANDLERQUIET {
+			sent := append([]string(nil), h.sent...)
+			h.sent = make([]string, 0)
+			h.mu.Unlock()
+
+			sort.Strings(sent)
+			return sent
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *handlerHarness) state(seqNum int) handlerState {
+	h.pbft.mu.Lock()
+	defer h.pbft.mu.Unlock()
+
+	state := handlerState{
+		view:          h.pbft.view,
+		prepareSeqNum: h.pbft.prepareSeqNum,
+		executeSeqNum: h.pbft.executeSeqNum}
+
+	if seqNum >= h.pbft.truncated && seqNum < h.pbft.prepareLength() {
+		state.prepares = len(h.pbft.prepareLog[seqNum-h.pbft.truncated].Msg1)
+	}
+	if seqNum >= h.pbft.truncated && seqNum < h.pbft.commitLength() {
+		state.commits = len(h.pbft.commitLog[seqNum-h.pbft.truncated].Msg1)
+	}
+	return state
+}
+
+//
+// ----------------------------- MESSAGE BUILDERS -----------------------------
+//
+func (h *handlerHarness) sign(j int, msgDigest [32]byte) []byte {
+	privateKey, _ := pooledKeys(params.scheme, j)
+	signer := &Pbft{}
+	signer.signer = signing.KeySigner(privateKey)
+	return signer.sign(msgDigest)
+}
+
+func (h *handlerHarness) request(timestamp int) ClientRequest {
+	return ClientRequest{
+		MsgType:   REPLICATE,
+		Timestamp: timestamp,
+		Operation: timestamp,
+		ClientId:  CLIENT,
+		Nonce:     bytes.Repeat([]byte{byte(timestamp)}, NONCESIZE)}
+}
+
+func (h *handlerHarness) prePrepare(seqNum int, timestamp int) PrepareLogEntry {
+	leader := h.pbft.getLeader()
+	request := h.request(timestamp)
+	msgDigest := digest(request)
+
+	msg := Message{
+		MsgType:         PREPREPARE,
+		MsgDigest:       msgDigest,
+		Signature:       h.sign(leader, msgDigest),
+		PrepareSeqNum:   seqNum,
+		View:            h.pbft.view,
+		ClientTimestamp: timestamp,
+		SenderId:        leader}
+
+	return PrepareLogEntry{
+		Request: request,
+		Msg0:    msg}
+}
+
+// Pre-prepare of the leader as forwarded by PBFT server hop
+func (h *handlerHarness) prepare(hop int, seqNum int, timestamp int) PrepareLogEntry {
+	prepareEntry := h.prePrepare(seqNum, timestamp)
+	prepareEntry.Hop = hop
+	return prepareEntry
+}
+
+func (h *handlerHarness) commit(sender int, seqNum int, timestamp int) CommitMessage {
+	request := h.request(timestamp)
+	msgDigest := digest(request)
+
+	msg := Message{
+		MsgType:         COMMIT,
+		MsgDigest:       msgDigest,
+		Signature:       h.sign(sender, msgDigest),
+		PrepareSeqNum:   seqNum,
+		View:            h.pbft.view,
+		ClientTimestamp: timestamp,
+		SenderId:        sender}
+
+	return CommitMessage{
+		Msg:     msg,
+		Request: request}
+}
+
+//
+// ------------------------------- TABLE RUNNER -------------------------------
+//
+func runHandlerCases(t *testing.T, cases []handlerCase) {
+	for _, c := range cases {
+		n := c.n
+		if n == 0 {
+			n = 5
+		}
+		seqNum := c.seqNum
+		if seqNum == 0 {
+			seqNum = 1
+		}
+
+		h := makeHandlerHarness(t, n, c.id)
+		if c.setup != nil {
+			c.setup(h)
+			h.quiesce()
+		}
+
+		reply := h.apply(c.method, c.msg(h))
+		sent := h.quiesce()
+		state := h.state(seqNum)
+
+		if len(reply.Signature) > 0 && h.pbft.verify(c.id, reply.MsgDigest, reply.Signature) == false {
+			t.Fatalf("%s: reply not signed by PBFT server (%d)!", c.name, c.id)
+		}
+		if reply.Success != c.reply.Success || reply.IsLeader != c.reply.IsLeader {
+			t.Fatalf("%s: expected reply (success %t, leader %t), got (success %t, leader %t)!", c.name,
+				c.reply.Success, c.reply.IsLeader, reply.Success, reply.IsLeader)
+		}
+		if state != c.state {
+			t.Fatalf("%s: expected state %+v, got %+v!", c.name, c.state, state)
+		}
+
+		expected := append([]string(nil), c.sent...)
+		sort.Strings(expected)
+		if strings.Join(sent, ", ") != strings.Join(expected, ", ") {
+			t.Fatalf("%s: expected messages [%s], got [%s]!", c.name, strings.Join(expected, ", "),
+				strings.Join(sent, ", "))
+		}
+	}
+}
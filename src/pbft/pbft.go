@@ -1,8 +1,12 @@
 package pbft
 
 import (
-	"crypto/rsa"
-	"network"
+	"crypto"
+	"github.com/csanti/cos518_project/src/debug"
+	"github.com/csanti/cos518_project/src/journal"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
 )
 
 //
@@ -17,8 +21,22 @@ func (pbft *Pbft) Replicate(request ClientRequest, reply *Reply) {
 
 	if pbft.id == pbft.getLeader() { // If PBFT server is the leader
 		reply.IsLeader = true
+		span := pbft.getTracer().Start(request.TraceId, "Pbft.Replicate")
+		defer span.End()
+
 		pbft.mu.Lock()
+		defer pbft.mu.Unlock()
+		if validNonce(request) == false {
+			pbft.log().With("trace", request.TraceId).Infof("Replicate: request without a nonce")
+			return
+		}
+		if err := checkSeqNum(request.Timestamp, pbft.truncated, pbft.prepareLength()); err != nil { // The timestamp is the sequence number
+			pbft.log().With("trace", request.TraceId).Infof("Replicate: invalid request: %v", err)
+			return
+		}
 		pbft.prepareSeqNum = request.Timestamp
+		span.Set("view", pbft.view)
+		span.Set("seqNum", pbft.prepareSeqNum)
 
 		msg := Message{ // Leader's prepare message
 			MsgType:         PREPREPARE,
@@ -27,10 +45,11 @@ func (pbft *Pbft) Replicate(request ClientRequest, reply *Reply) {
 			PrepareSeqNum:   pbft.prepareSeqNum,
 			View:            pbft.view,
 			ClientTimestamp: request.Timestamp,
-			SenderId:        pbft.id}
+			SenderId:        pbft.id,
+			TraceId:         request.TraceId,
+			Time:            pbft.now()}
 
 		prePrepareEntry := pbft.appendToPrepareLog(request, msg)
-		pbft.mu.Unlock()
 		for server, _ := range pbft.synchronousGroup {
 			if server != pbft.id {
 				go pbft.issuePrePrepare(server, prePrepareEntry)
@@ -44,7 +63,15 @@ func (pbft *Pbft) Replicate(request ClientRequest, reply *Reply) {
 // -------------------------------- PRE-PREPARE RPC -------------------------------
 //
 func (pbft *Pbft) sendPrePrepare(server int, prepareEntry PrepareLogEntry, reply *Reply) bool {
-	dPrintf("PrePrepare: from Pbft server (%d) to Pbft server (%d)\n", pbft.id, server)
+	prepareEntry, ok := pbft.tamperPrepare(server, prepareEntry) // Byzantine servers (see byzantine.go)
+	if ok == false {
+		return false
+	}
+	if pbft.reachFailpoint(PREPREPAREPOINT) == false {
+		return false
+	}
+
+	pbft.logMsg(prepareEntry.Msg0).Debugf("PrePrepare: to Pbft server (%d)", server)
 	return pbft.replicas[server].Call("Pbft.PrePrepare", prepareEntry, reply, pbft.id)
 }
 
@@ -57,20 +84,30 @@ func (pbft *Pbft) issuePrePrepare(server int, prepareEntry PrepareLogEntry) {
 
 func (pbft *Pbft) PrePrepare(prepareEntry PrepareLogEntry, reply *Reply) {
 	// By default reply.Success = false and reply.Suspicious = false
-	verification := pbft.verify(prepareEntry.Msg0.SenderId, prepareEntry.Msg0.MsgDigest, prepareEntry.Msg0.Signature)
+	span := pbft.getTracer().Start(prepareEntry.Request.TraceId, "Pbft.PrePrepare")
+	span.Set("view", prepareEntry.Msg0.View)
+	span.Set("seqNum", prepareEntry.Msg0.PrepareSeqNum)
+	defer span.End()
+
+	verification := pbft.verify(prepareEntry.Msg0.SenderId, prepareEntry.Msg0.MsgDigest, prepareEntry.Msg0.Signature) &&
+		digest(prepareEntry.Request) == prepareEntry.Msg0.MsgDigest && validNonce(prepareEntry.Request)
 	if verification == true && pbft.view == prepareEntry.Msg0.View {
 		pbft.mu.Lock()
-		if ok := pbft.addToPrepareLog(prepareEntry); !ok {
-			prepareEntry.Hop = pbft.id
-			pbft.mu.Unlock()
-			for server, _ := range pbft.synchronousGroup {
-				if server != pbft.id {
-					go pbft.issuePrepare(server, prepareEntry)
-				}
-			}
+		defer pbft.mu.Unlock()
+		_, err := pbft.addToPrepareLog(prepareEntry)
+		prepareEntry.Hop = pbft.id
+		if err != nil {
+			pbft.logMsg(prepareEntry.Msg0).Infof("PrePrepare: invalid message: %v", err)
 			return
 		}
-		pbft.mu.Unlock()
+
+		// Always forward the prepare (even if prepares of other servers arrived first) so a
+		// silent Byzantine server cannot keep the others from reaching a quorum
+		for server, _ := range pbft.synchronousGroup {
+			if server != pbft.id {
+				go pbft.issuePrepare(server, prepareEntry)
+			}
+		}
 	}
 }
 
@@ -78,7 +115,15 @@ func (pbft *Pbft) PrePrepare(prepareEntry PrepareLogEntry, reply *Reply) {
 // -------------------------------- PREPARE RPC -------------------------------
 //
 func (pbft *Pbft) sendPrepare(server int, prepareEntry PrepareLogEntry, reply *Reply) bool {
-	dPrintf("Prepare: from Pbft server (%d) to Pbft server (%d)\n", pbft.id, server)
+	prepareEntry, ok := pbft.tamperPrepare(server, prepareEntry) // Byzantine servers (see byzantine.go)
+	if ok == false {
+		return false
+	}
+	if pbft.reachFailpoint(PREPAREPOINT) == false {
+		return false
+	}
+
+	pbft.logMsg(prepareEntry.Msg0).Debugf("Prepare: to Pbft server (%d)", server)
 	return pbft.replicas[server].Call("Pbft.Prepare", prepareEntry, reply, pbft.id)
 }
 
@@ -92,12 +137,24 @@ func (pbft *Pbft) issuePrepare(server int, prepareEntry PrepareLogEntry) {
 
 func (pbft *Pbft) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
 	// By default reply.Success = false and reply.Suspicious = false
-	verification := pbft.verify(prepareEntry.Msg0.SenderId, prepareEntry.Msg0.MsgDigest, prepareEntry.Msg0.Signature)
+	span := pbft.getTracer().Start(prepareEntry.Request.TraceId, "Pbft.Prepare")
+	span.Set("view", prepareEntry.Msg0.View)
+	span.Set("seqNum", prepareEntry.Msg0.PrepareSeqNum)
+	span.Set("sender", prepareEntry.Hop)
+	defer span.End()
+
+	verification := pbft.verify(prepareEntry.Msg0.SenderId, prepareEntry.Msg0.MsgDigest, prepareEntry.Msg0.Signature) &&
+		digest(prepareEntry.Request) == prepareEntry.Msg0.MsgDigest && validNonce(prepareEntry.Request)
 
 	if verification == true && pbft.view == prepareEntry.Msg0.View {
 		pbft.mu.Lock()
-		if ok := pbft.addToPrepareLog(prepareEntry); ok {
-			if len(pbft.prepareLog[prepareEntry.Msg0.PrepareSeqNum].Msg1) >= 2*(len(pbft.replicas)-2)/3 {
+		defer pbft.mu.Unlock()
+		ok, err := pbft.addToPrepareLog(prepareEntry)
+		if err != nil {
+			pbft.logMsg(prepareEntry.Msg0).Infof("Prepare: invalid message: %v", err)
+		}
+		if ok {
+			if len(pbft.prepareLog[prepareEntry.Msg0.PrepareSeqNum-pbft.truncated].Msg1) >= 2*(len(pbft.replicas)-2)/3 {
 				msgDigest := digest(prepareEntry.Request)
 				signature := pbft.sign(msgDigest)
 				pbft.prepareSeqNum = prepareEntry.Msg0.PrepareSeqNum
@@ -109,20 +166,18 @@ func (pbft *Pbft) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
 					PrepareSeqNum:   pbft.prepareSeqNum,
 					View:            pbft.view,
 					ClientTimestamp: prepareEntry.Request.Timestamp,
-					SenderId:        pbft.id}
+					SenderId:        pbft.id,
+					TraceId:         prepareEntry.Request.TraceId,
+					Time:            prepareEntry.Msg0.Time}
 
 				cmsg := CommitMessage{
 					msg, prepareEntry.Request}
 
-				pbft.mu.Unlock()
-
 				for server, _ := range pbft.synchronousGroup {
 					go pbft.issueCommit(server, cmsg)
 				}
-				return
 			}
 		}
-		pbft.mu.Unlock()
 	}
 }
 
@@ -130,7 +185,15 @@ func (pbft *Pbft) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
 // --------------------------------- COMMIT RPC --------------------------------
 //
 func (pbft *Pbft) sendCommit(server int, msg CommitMessage, reply *Reply) bool {
-	dPrintf("Commit: from Pbft server (%d) to Pbft server (%d) for SeqNum %d\n", pbft.id, server, msg.Msg.ClientTimestamp)
+	msg, ok := pbft.tamperCommit(server, msg) // Byzantine servers (see byzantine.go)
+	if ok == false {
+		return false
+	}
+	if pbft.reachFailpoint(COMMITPOINT) == false {
+		return false
+	}
+
+	pbft.logMsg(msg.Msg).Debugf("Commit: to Pbft server (%d)", server)
 	return pbft.replicas[server].Call("Pbft.Commit", msg, reply, pbft.id)
 }
 
@@ -144,22 +207,27 @@ func (pbft *Pbft) issueCommit(server int, msg CommitMessage) {
 
 func (pbft *Pbft) Commit(msg CommitMessage, reply *Reply) {
 	// By default reply.Success == false
+	span := pbft.getTracer().Start(msg.Request.TraceId, "Pbft.Commit")
+	span.Set("view", msg.Msg.View)
+	span.Set("seqNum", msg.Msg.PrepareSeqNum)
+	span.Set("sender", msg.Msg.SenderId)
+	defer span.End()
+
 	if pbft.view != msg.Msg.View {
 		return
 	}
 
-	if pbft.verify(msg.Msg.SenderId, msg.Msg.MsgDigest, msg.Msg.Signature) == true {
+	if pbft.verify(msg.Msg.SenderId, msg.Msg.MsgDigest, msg.Msg.Signature) == true && digest(msg.Request) == msg.Msg.MsgDigest {
 		pbft.mu.Lock()
-		if ok := pbft.addToCommitLog(msg); ok {
-			if len(pbft.commitLog[msg.Msg.PrepareSeqNum].Msg1) >= 2*(len(pbft.replicas)-2)/3 && pbft.executeSeqNum < msg.Msg.PrepareSeqNum {
-				dPrintf("Server %d SeqNum %d Commits %d ", pbft.id, msg.Msg.PrepareSeqNum, len(pbft.commitLog[msg.Msg.PrepareSeqNum].Msg1))
-				pbft.executeSeqNum = msg.Msg.PrepareSeqNum
-				pbft.mu.Unlock()
-				go pbft.issueReply(msg)
-				return
-			}
+		defer pbft.mu.Unlock()
+		ok, err := pbft.addToCommitLog(msg)
+		if err != nil {
+			pbft.logMsg(msg.Msg).Infof("Commit: invalid message: %v", err)
+		}
+		if ok {
+			pbft.applyCommitted()
+			pbft.replyExecuted()
 		}
-		pbft.mu.Unlock()
 	}
 }
 
@@ -167,13 +235,16 @@ func (pbft *Pbft) Commit(msg CommitMessage, reply *Reply) {
 // --------------------------------- REPLY RPC --------------------------------
 //
 func (pbft *Pbft) sendReply(creply ClientReply, reply *Reply) bool {
-	iPrintf("Reply: from Pbft server (%d) to Pbft server (%d) for SeqNum %d\n", pbft.id, CLIENT, creply.Timestamp)
+	if pbft.reachFailpoint(REPLYPOINT) == false {
+		return false
+	}
+	pbft.log().With("seqNum", creply.Timestamp, "trace", creply.TraceId).Infof("Reply: to client server (%d)", CLIENT)
 	return pbft.replicas[CLIENT].Call("Client.Reply", creply, reply, pbft.id)
 }
 
-func (pbft *Pbft) issueReply(msg CommitMessage) {
+func (pbft *Pbft) issueReply(request ClientRequest, result []byte) {
 	reply := &Reply{}
-	creply := ClientReply{pbft.id, msg.Request.Timestamp}
+	creply := ClientReply{pbft.id, request.Timestamp, result, request.TraceId}
 
 	if ok := pbft.sendReply(creply, reply); ok {
 
@@ -183,8 +254,8 @@ func (pbft *Pbft) issueReply(msg CommitMessage) {
 //
 // ------------------------------- MAKE FUNCTION ------------------------------
 //
-func Make(replicas []*network.ClientEnd, id int, privateKey *rsa.PrivateKey,
-	publicKeys map[int]*rsa.PublicKey) *Pbft {
+func Make(replicas []network.Transport, id int, signer signing.Signer,
+	publicKeys map[int]crypto.PublicKey) *Pbft {
 	pbft := &Pbft{}
 
 	pbft.mu.Lock()
@@ -196,8 +267,25 @@ func Make(replicas []*network.ClientEnd, id int, privateKey *rsa.PrivateKey,
 	pbft.executeSeqNum = 0
 	pbft.prepareLog = make([]PrepareLogEntry, 0)
 	pbft.commitLog = make([]CommitLogEntry, 0)
-	pbft.privateKey = privateKey
+	pbft.signer = signer
 	pbft.publicKeys = publicKeys
+	pbft.sessions = signing.MakeSessions(id)
+	pbft.byzantine = HONEST
+	pbft.clock = network.RealClock{}
+	pbft.stateMachine = nil
+	pbft.applied = 0
+	pbft.results = make(map[int][]byte, 0)
+	pbft.checkpoint = Checkpoint{}
+	pbft.interval = 0
+	pbft.truncated = 0
+	pbft.votes = make(map[int]map[int]stateVote)
+	pbft.stable = 0
+	pbft.onStable = nil
+	pbft.SetLogger(debug.MakeLogger(debug.PBFT))
+	pbft.SetTracer(nil)
+	pbft.journal = journal.MakeJournal(journal.SIZE)
+	pbft.signTime = 0
+	pbft.verifyTime = 0
 
 	pbft.generateSynchronousGroup(int64(pbft.view))
 	pbft.mu.Unlock()
@@ -205,4 +293,15 @@ func Make(replicas []*network.ClientEnd, id int, privateKey *rsa.PrivateKey,
 	return pbft
 }
 
+// Drives sm with the operations of committed requests (see statemachine)
+func (pbft *Pbft) SetStateMachine(sm statemachine.StateMachine) {
+	pbft.mu.Lock()
+	defer pbft.mu.Unlock()
+
+	pbft.stateMachine = sm
+	pbft.applied = 0
+	pbft.results = make(map[int][]byte, 0)
+	pbft.applyCommitted()
+}
+
 func (pbft *Pbft) Kill() {}
@@ -0,0 +1,101 @@
+package pbft
+
+// Pairwise session keys of PBFT servers (see signing/session.go)
+//
+// pbft.EstablishSessions()           - Offers a session key to every other server, returns how many took it
+// pbft.mac(server, digest)           - MAC of digest for server (nil without a session key)
+// pbft.checkMAC(server, digest, mac) - Whether mac is the MAC of digest by server
+// cfg.establishSessions()            - Establishes the session keys of every pair of servers
+//
+// => Servers exchange keys over the Pbft.Session RPC, signing their halves with their private key
+// => No message of the protocol carries a MAC yet: the keys are there for fast paths whose
+//    messages are checked by their receiver only and never go into a certificate
+// => PBFT servers are only run by the tests, which establish the keys once all servers are up
+
+import (
+	"github.com/csanti/cos518_project/src/signing"
+	"sync"
+)
+
+type SessionReply struct {
+	Answer  signing.Handshake
+	Success bool // The offer was valid and answered
+}
+
+func (pbft *Pbft) EstablishSessions() int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	established := 0
+
+	for server := range pbft.replicas {
+		if server != CLIENT && server != pbft.id {
+			wg.Add(1)
+			go func(server int) {
+				defer wg.Done()
+				if pbft.offerSession(server) {
+					mu.Lock()
+					established++
+					mu.Unlock()
+				}
+			}(server)
+		}
+	}
+	wg.Wait()
+	return established
+}
+
+func (pbft *Pbft) offerSession(server int) bool {
+	offer, err := pbft.sessions.Offer(pbft.signer, server)
+	if err != nil {
+		pbft.log().Infof("Session: %v", err)
+		return false
+	}
+	reply := &SessionReply{}
+	if pbft.replicas[server].Call("Pbft.Session", offer, reply, pbft.id) == false || reply.Success == false {
+		pbft.log().Debugf("Session: no answer of PBFT server (%d)", server)
+		return false
+	}
+
+	pbft.mu.Lock()
+	publicKey := pbft.publicKeys[server]
+	pbft.mu.Unlock()
+	if err := pbft.sessions.Complete(offer, reply.Answer, publicKey); err != nil {
+		pbft.log().Infof("Session: %v", err)
+		return false
+	}
+	return true
+}
+
+func (pbft *Pbft) Session(offer signing.Handshake, reply *SessionReply) {
+	pbft.mu.Lock()
+	publicKey := pbft.publicKeys[offer.From]
+	pbft.mu.Unlock()
+
+	answer, reoffer, err := pbft.sessions.Answer(pbft.signer, offer, publicKey)
+	if err != nil {
+		pbft.log().Infof("Session: %v", err)
+		return
+	}
+	reply.Answer = answer
+	reply.Success = true
+
+	if reoffer {
+		go pbft.offerSession(offer.From)
+	}
+}
+
+func (pbft *Pbft) mac(server int, msgDigest [32]byte) []byte {
+	mac, err := pbft.sessions.MAC(server, msgDigest)
+	if err != nil {
+		pbft.log().Debugf("MAC: %v", err)
+	}
+	return mac
+}
+
+func (pbft *Pbft) checkMAC(server int, msgDigest [32]byte, mac []byte) bool {
+	if err := pbft.sessions.CheckMAC(server, msgDigest, mac); err != nil {
+		pbft.log().Debugf("MAC: %v", err)
+		return false
+	}
+	return true
+}
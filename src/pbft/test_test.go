@@ -1,12 +1,41 @@
 package pbft
 
 import (
+	"flag"
 	"fmt"
+	"github.com/csanti/cos518_project/src/debug"
+	"github.com/csanti/cos518_project/src/journal"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/tracing"
+	"github.com/csanti/cos518_project/src/workload"
 	"math/rand"
+	"reflect"
 	"testing"
 	"time"
 )
 
+// Cluster parameters for scripted experiments (see config.go/parameters), i.e.
+// "go test -run=TestCommonCase3 -args -n=8 -seed=1 -duration=10s"
+// => -n is also a go build flag, so pass the cluster parameters after -args
+func init() {
+	flag.IntVar(&params.n, "n", 0, "total number of client and PBFT servers (overrides each test's)")
+	flag.IntVar(&params.f, "f", 0, "number of faults to tolerate, n = 3f+2 (ignored if -n is set)")
+	flag.BoolVar(&params.unreliable, "unreliable", false, "run every test on an unreliable network")
+	flag.Int64Var(&params.seed, "seed", 0, "seed of all random choices of tests: network, workloads and nemeses (default: time)")
+	flag.DurationVar(&params.duration, "duration", 0, "run closed-loop tests for this long instead of a fixed number of proposals")
+	flag.BoolVar(&params.freshKeys, "freshkeys", false, "generate fresh RSA keys for every test instead of sharing pooled ones")
+	flag.Func("scheme", "signature scheme of the servers' keys: rsa-1024 (default), rsa-2048, rsa-3072, ecdsa-p256, ed25519, rsa-pss-2048 or rsa-pss-3072", func(name string) (err error) {
+		params.scheme, err = signing.ParseScheme(name)
+		return err
+	})
+	flag.DurationVar(&params.memSample, "memsample", 0, "sample memory during benchmarks at this interval (default: only before and after)")
+	flag.StringVar(&params.heapDir, "heapdir", "", "dump a heap profile to this directory at every memory sample of benchmarks")
+	flag.StringVar(&params.cpuDir, "cpudir", "", "write a CPU profile of the measurement window of every benchmark to this directory")
+	flag.StringVar(&params.mutexDir, "mutexdir", "", "write a mutex contention profile of the measurement window of every benchmark to this directory")
+	flag.Var(debug.Flag(), "debug", "per-module debug levels, i.e. pbft=2,network=0 (see debug/debug.go)")
+}
+
 func TestCommonCase3(t *testing.T) {
 	servers := 5
 	cfg := makeConfig(t, servers, false)
@@ -18,30 +47,478 @@ func TestCommonCase3(t *testing.T) {
 	rand.Read(op) // Operation is a 1 kB random byte array
 
 	iters := 500
-	for i := 0; i < iters; i++ {
-		ok := cfg.client.Propose(op)
-		for ok == false {
-			time.Sleep(time.Duration(10) * time.Millisecond)
-			ok = cfg.client.RePropose(op)
-		}
+	for i := 0; cfg.running(i, iters); i++ {
+		cfg.propose(op)
 	}
 
+	cfg.client.mu.Lock()
 	fmt.Printf("Client Proposed: %d Client Committed: %d\n", cfg.client.timestamp-1, cfg.client.committed)
+	cfg.client.mu.Unlock()
 	cfg.rpcCounts()
 	cfg.checkLogs()
 }
 
+func TestCommonCaseMessages1(t *testing.T) {
+	servers := 5
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Common Case - Message Complexity (f=1)")
+
+	cfg.propose(nil) // Not counted
+	cfg.beginRPCBudget()
+
+	iters := 10
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	// Every PBFT server prepares and commits every request at every other one: O(n^2) RPCs
+	cfg.assertRPCBudget(iters*4*cfg.n*cfg.n, int64(iters*4*cfg.n*cfg.n*1024))
+}
+
+func TestStateMachine1(t *testing.T) {
+	servers := 5
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: State Machine - Agreed Results (f=1)")
+
+	iters := 5
+	for i := 1; i <= iters; i++ {
+		result, ok := cfg.client.Execute(i)
+		if ok == false || string(result) != fmt.Sprint(i) { // Log machines count applied operations
+			cfg.t.Fatalf("Expected result %d, got %q!", i, result)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond) // Commits of the last request may still reach some replicas
+	for i := 2; i < cfg.n; i++ {
+		if reflect.DeepEqual(cfg.machines[i].Ops(), cfg.machines[1].Ops()) == false {
+			cfg.t.Fatalf("State machines of PBFT servers (1) and (%d) differ!", i)
+		}
+	}
+}
+
+// Replies and view-change confirmations that arrive after the client gave up on a request never
+// block it
+func TestLateReplies(t *testing.T) {
+	fmt.Println("Test: Client - Late Replies After a Timeout")
+
+	client := MakeClient([]network.Transport{nil}) // No servers: every request times out
+	if _, ok := client.Execute(1); ok == true {
+		t.Fatal("Request committed without servers!")
+	}
+
+	done := make(chan bool)
+	go func() {
+		client.Reply(ClientReply{Commiter: 1, Timestamp: 1, Result: []byte("1")}, &Reply{})
+		client.ConfirmVC(Message{}, &Reply{})
+		if _, ok := client.Execute(2); ok == true {
+			t.Error("Request committed without servers!")
+		}
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(4 * TIMEOUT * time.Millisecond):
+		t.Fatal("Client blocked on a reply to a request it gave up on!")
+	}
+	if ok := client.RePropose(2); ok == true {
+		t.Fatal("Reproposed request committed without servers!")
+	}
+}
+
+func TestSchemes1(t *testing.T) {
+	servers := 5
+
+	fmt.Println("Test: Signature Schemes - RSA, RSA-PSS, ECDSA and Ed25519 Keys (f=1)")
+
+	for _, scheme := range signing.Schemes() {
+		cfg := makeConfigScheme(t, servers, false, scheme)
+
+		for i := 1; i < cfg.n; i++ {
+			if keyScheme, err := signing.SchemeOf(cfg.publicKeys[i]); err != nil || keyScheme != scheme {
+				cfg.t.Fatalf("Server %d got a key of %v instead of %v (%v)!", i, keyScheme, scheme, err)
+			}
+		}
+
+		iters := 3
+		for i := 1; i <= iters; i++ {
+			if result, ok := cfg.client.Execute(i); ok == false || string(result) != fmt.Sprint(i) {
+				cfg.t.Fatalf("Expected result %d with %v keys, got %q!", i, scheme, result)
+			}
+		}
+		cfg.cleanup()
+	}
+}
+
+func TestSpans1(t *testing.T) {
+	servers := 5
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Tracing - Spans of the Phases of Requests (f=1)")
+
+	exporter := tracing.MakeMemoryExporter()
+	tracers := make([]*tracing.Tracer, servers)
+	for i := 1; i < servers; i++ {
+		tracers[i] = tracing.MakeTracer("pbft", fmt.Sprint(i), exporter)
+		cfg.pbftServers[i].SetTracer(tracers[i])
+	}
+
+	iters := 3
+	for i := 1; i <= iters; i++ {
+		if _, ok := cfg.client.Execute(i); ok == false {
+			cfg.t.Fatalf("Request %d not committed!", i)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond) // Commits of the last request may still reach some replicas
+	for i := 1; i < servers; i++ {
+		tracers[i].Flush()
+	}
+
+	traces := make(map[string]map[string]int) // Trace ID -> span name -> spans
+	for _, span := range exporter.Spans() {
+		if traces[span.TraceId] == nil {
+			traces[span.TraceId] = make(map[string]int)
+		}
+		traces[span.TraceId][span.Name]++
+	}
+	if len(traces) != iters {
+		cfg.t.Fatalf("Spans of %d traces for %d requests!", len(traces), iters)
+	}
+	for traceId, spans := range traces {
+		if spans["Pbft.Replicate"] != 1 {
+			cfg.t.Fatalf("Trace %s with %d replicate spans!", traceId, spans["Pbft.Replicate"])
+		}
+		for _, phase := range []string{"Pbft.PrePrepare", "Pbft.Prepare", "Pbft.Commit", "Pbft.Execute"} {
+			if spans[phase] == 0 {
+				cfg.t.Fatalf("Trace %s without a span of %s!", traceId, phase)
+			}
+		}
+	}
+}
+
+func TestCheckpoint1(t *testing.T) {
+	servers := 5
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Checkpoints - Truncation Below the Stable Checkpoint (f=1)")
+
+	interval := 4
+	for i := 1; i < cfg.n; i++ {
+		cfg.pbftServers[i].SetCheckpointInterval(interval)
+	}
+
+	op := make([]byte, 1024)
+	rand.Read(op)
+
+	iters := 2*interval + 1
+	for i := 0; i < iters; i++ {
+		cfg.propose(op)
+	}
+
+	time.Sleep(100 * time.Millisecond) // Commits of the last request may still reach some replicas
+	for i := 1; i < cfg.n; i++ {
+		pbft := cfg.pbftServers[i]
+		pbft.mu.Lock()
+		if pbft.checkpoint.SeqNum != 2*interval || pbft.truncated != 2*interval ||
+			len(pbft.commitLog) != iters+1-pbft.truncated || pbft.commitLog[0].Msg0.PrepareSeqNum != pbft.truncated {
+			pbft.mu.Unlock()
+			cfg.t.Fatalf("PBFT server (%d) did not truncate exactly the entries below its checkpoint!", i)
+		}
+		chain := pbft.checkpoint.Chain
+		pbft.mu.Unlock()
+
+		cfg.pbftServers[1].mu.Lock()
+		leaderChain := cfg.pbftServers[1].checkpoint.Chain
+		cfg.pbftServers[1].mu.Unlock()
+		if chain == [32]byte{} || chain != leaderChain {
+			cfg.t.Fatalf("PBFT servers (1) and (%d) hold different histories at their checkpoint!", i)
+		}
+
+		if reflect.DeepEqual(cfg.machines[i].Ops(), cfg.machines[1].Ops()) == false {
+			cfg.t.Fatalf("State machines of PBFT servers (1) and (%d) differ!", i)
+		}
+		if cfg.pbftServers[i].StableCheckpoint() != 2*interval {
+			cfg.t.Fatalf("PBFT server (%d) did not reach a stable checkpoint at %d!", i, 2*interval)
+		}
+		stable := cfg.pbftServers[i].Journal().Filter(journal.STABLE)
+		if len(stable) != 2 || stable[0].SeqNum != interval || stable[1].SeqNum != 2*interval {
+			cfg.t.Fatalf("PBFT server (%d) journaled stable checkpoints %v!", i, stable)
+		}
+	}
+	compareCheckpoints(cfg)
+}
+
+func TestCheckpointBounded1(t *testing.T) {
+	servers := 5
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Checkpoints - Log Length Bounded Over Many Requests (f=1)")
+
+	interval := 5
+	for i := 1; i < cfg.n; i++ {
+		cfg.pbftServers[i].SetCheckpointInterval(interval)
+	}
+
+	// The logs start at the stable checkpoint and hold the entries of the checkpoint taken but not
+	// yet stable and those committed since
+	bound := 2*interval + 2
+	for i := 0; i < 40*interval; i++ {
+		cfg.propose(nil)
+
+		for j := 1; j < cfg.n; j++ {
+			pbft := cfg.pbftServers[j]
+			pbft.mu.Lock()
+			prepares, commits := len(pbft.prepareLog), len(pbft.commitLog)
+			pbft.mu.Unlock()
+
+			if prepares > bound || commits > bound {
+				t.Fatalf("PBFT server (%d) holds %d prepare and %d commit log entries after %d requests!", j,
+					prepares, commits, i+1)
+			}
+		}
+	}
+	compareCheckpoints(cfg)
+}
+
+func TestSessionKeys1(t *testing.T) {
+	servers := 5
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Session Keys - Pairwise MACs of Every Pair of Servers (f=1)")
+
+	cfg.establishSessions()
+	for i := 2; i < cfg.n; i++ {
+		mac := cfg.pbftServers[i].mac(1, digest("prepare"))
+		if cfg.pbftServers[1].checkMAC(i, digest("commit"), mac) == true {
+			t.Fatalf("MAC of PBFT server (%d) valid for another digest!", i)
+		}
+	}
+
+	cfg.propose(nil)
+	cfg.checkLogs()
+}
+
+func TestFailpoint1(t *testing.T) {
+	servers := 5
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Failpoints - Dropped Commit (f=1)")
+
+	// PBFT server (ID = 2) drops the next commit message it sends
+	cfg.setFailpoint(2, COMMITPOINT, &failpoint{action: FAILDROP, count: 1})
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	if cfg.client.committed != iters {
+		cfg.t.Fatal("Not all operations committed!")
+	}
+	if cfg.failpointHits(2, COMMITPOINT) != 1 {
+		cfg.t.Fatal("Failpoint was not reached exactly once!")
+	}
+}
+
+func TestPartialSynchrony1(t *testing.T) {
+	servers := 5
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Partial Synchrony - Progress Resumes After GST (f=1)")
+
+	gst := cfg.partialSynchrony(300*time.Millisecond, 200*time.Millisecond, time.Second)
+	for time.Now().Before(gst) {
+		cfg.propose(nil)
+	}
+
+	iters := 10
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	// After GST the network is synchronous, so proposals commit without client timeouts
+	if elapsed := time.Since(gst); elapsed > 5*time.Second {
+		fmt.Printf("Committed %d proposals %v after GST\n", iters, elapsed)
+		cfg.t.Fatal("Progress did not resume after GST!")
+	}
+}
+
+func TestByzantineFault1(t *testing.T) {
+	strategies := map[int]string{
+		CORRUPTSIGNATURE: "Corrupted Signatures",
+		DROP:             "Dropped Messages",
+		EQUIVOCATE:       "Equivocation",
+		LIEVIEW:          "Lying About View"}
+
+	for strategy, desc := range strategies {
+		servers := 5
+		cfg := makeConfig(t, servers, false)
+
+		// PBFT server (ID = 2) follows the Byzantine strategy
+		cfg.setByzantine(2, strategy)
+
+		fmt.Printf("Test: Byzantine Fault - %s (f=1)\n", desc)
+
+		iters := 20
+		for i := 0; i < iters; i++ {
+			cfg.propose(nil)
+		}
+
+		if cfg.client.committed != iters {
+			cfg.t.Fatal("Not all operations committed!")
+		}
+		cfg.cleanup()
+	}
+}
+
+func TestHandlers1(t *testing.T) {
+	fmt.Println("Test: Handlers - Common Case (f=1)")
+
+	leader, follower := 1, 2
+	commits := []string{"Pbft.Commit 1", "Pbft.Commit 2", "Pbft.Commit 3", "Pbft.Commit 4"}
+
+	runHandlerCases(t, []handlerCase{
+		{name: "Replicate at the leader", id: leader, method: "Pbft.Replicate",
+			msg:   func(h *handlerHarness) interface{} { return h.request(1) },
+			reply: Reply{Success: true, IsLeader: true},
+			state: handlerState{view: 1, prepareSeqNum: 1}, // Logged past the padding (see appendToPrepareLog)
+			sent:  []string{"Pbft.PrePrepare 2", "Pbft.PrePrepare 3", "Pbft.PrePrepare 4"}},
+		{name: "Replicate at a follower", id: follower, method: "Pbft.Replicate",
+			msg:   func(h *handlerHarness) interface{} { return h.request(1) },
+			state: handlerState{view: 1}},
+		{name: "PrePrepare", id: follower, method: "Pbft.PrePrepare",
+			msg:   func(h *handlerHarness) interface{} { return h.prePrepare(1, 1) },
+			state: handlerState{view: 1, prepares: 1},
+			sent:  []string{"Pbft.Prepare 1", "Pbft.Prepare 3", "Pbft.Prepare 4"}},
+		{name: "PrePrepare with a forged signature", id: follower, method: "Pbft.PrePrepare",
+			msg: func(h *handlerHarness) interface{} {
+				prepareEntry := h.prePrepare(1, 1)
+				prepareEntry.Msg0.Signature = h.sign(3, prepareEntry.Msg0.MsgDigest)
+				return prepareEntry
+			},
+			state: handlerState{view: 1}},
+		{name: "PrePrepare of a different request", id: follower, method: "Pbft.PrePrepare",
+			msg: func(h *handlerHarness) interface{} {
+				prepareEntry := h.prePrepare(1, 1)
+				prepareEntry.Request = h.request(2)
+				return prepareEntry
+			},
+			state: handlerState{view: 1}},
+		{name: "Prepare below the quorum", id: follower, method: "Pbft.Prepare",
+			msg:   func(h *handlerHarness) interface{} { return h.prepare(3, 1, 1) },
+			state: handlerState{view: 1, prepares: 1}},
+		{name: "Prepare reaching the quorum", id: follower, method: "Pbft.Prepare",
+			setup: func(h *handlerHarness) { h.apply("Pbft.PrePrepare", h.prePrepare(1, 1)) },
+			msg:   func(h *handlerHarness) interface{} { return h.prepare(3, 1, 1) },
+			state: handlerState{view: 1, prepareSeqNum: 1, prepares: 2},
+			sent:  commits},
+		{name: "Prepare with a forged signature", id: follower, method: "Pbft.Prepare",
+			setup: func(h *handlerHarness) { h.apply("Pbft.PrePrepare", h.prePrepare(1, 1)) },
+			msg: func(h *handlerHarness) interface{} {
+				prepareEntry := h.prepare(3, 1, 1)
+				prepareEntry.Msg0.Signature = h.sign(3, prepareEntry.Msg0.MsgDigest)
+				return prepareEntry
+			},
+			state: handlerState{view: 1, prepares: 1}},
+		{name: "Commit below the quorum", id: follower, method: "Pbft.Commit",
+			msg:   func(h *handlerHarness) interface{} { return h.commit(3, 1, 1) },
+			state: handlerState{view: 1, commits: 1}},
+		{name: "Commit reaching the quorum", id: follower, method: "Pbft.Commit",
+			setup: func(h *handlerHarness) { h.apply("Pbft.Commit", h.commit(3, 1, 1)) },
+			msg:   func(h *handlerHarness) interface{} { return h.commit(4, 1, 1) },
+			state: handlerState{view: 1, executeSeqNum: 1, commits: 2},
+			sent:  []string{"Client.Reply 0"}},
+		{name: "Commit of an executed request", id: follower, method: "Pbft.Commit",
+			setup: func(h *handlerHarness) {
+				h.apply("Pbft.Commit", h.commit(3, 1, 1))
+				h.apply("Pbft.Commit", h.commit(4, 1, 1))
+			},
+			msg:   func(h *handlerHarness) interface{} { return h.commit(1, 1, 1) },
+			state: handlerState{view: 1, executeSeqNum: 1, commits: 3}},
+		{name: "Commit of a different request", id: follower, method: "Pbft.Commit",
+			msg: func(h *handlerHarness) interface{} {
+				msg := h.commit(3, 1, 1)
+				msg.Request = h.request(2)
+				return msg
+			},
+			state: handlerState{view: 1}},
+	})
+}
+
+func TestHandlers2(t *testing.T) {
+	fmt.Println("Test: Handlers - Invalid Messages (f=1)")
+
+	leader, follower := 1, 2
+
+	runHandlerCases(t, []handlerCase{
+		{name: "Replicate with a negative timestamp", id: leader, method: "Pbft.Replicate",
+			msg:   func(h *handlerHarness) interface{} { return h.request(-1) },
+			reply: Reply{IsLeader: true},
+			state: handlerState{view: 1}},
+		{name: "Replicate without a nonce", id: leader, method: "Pbft.Replicate",
+			msg: func(h *handlerHarness) interface{} {
+				request := h.request(1)
+				request.Nonce = nil
+				return request
+			},
+			reply: Reply{IsLeader: true},
+			state: handlerState{view: 1}},
+		{name: "PrePrepare without a nonce", id: follower, method: "Pbft.PrePrepare",
+			msg: func(h *handlerHarness) interface{} {
+				prepareEntry := h.prePrepare(1, 1)
+				prepareEntry.Request.Nonce = nil
+				prepareEntry.Msg0.MsgDigest = digest(prepareEntry.Request)
+				prepareEntry.Msg0.Signature = h.sign(h.pbft.getLeader(), prepareEntry.Msg0.MsgDigest)
+				return prepareEntry
+			},
+			state: handlerState{view: 1}},
+		{name: "PrePrepare far beyond the log", id: follower, method: "Pbft.PrePrepare",
+			msg:   func(h *handlerHarness) interface{} { return h.prePrepare(MAXGAP+1, 1) },
+			state: handlerState{view: 1}},
+		{name: "Prepare with a negative sequence number", id: follower, method: "Pbft.Prepare",
+			msg:   func(h *handlerHarness) interface{} { return h.prepare(3, -1, 1) },
+			state: handlerState{view: 1}},
+		{name: "Commit with a negative sequence number", id: follower, method: "Pbft.Commit",
+			msg:   func(h *handlerHarness) interface{} { return h.commit(3, -1, 1) },
+			state: handlerState{view: 1}},
+		{name: "Commit from an unknown server", id: follower, method: "Pbft.Commit",
+			msg:   func(h *handlerHarness) interface{} { return h.commit(h.n, 1, 1) }, // No public key
+			state: handlerState{view: 1}},
+	})
+}
+
 func (cfg *config) rpcCounts() {
 	for i := 0; i < cfg.n; i++ {
-		fmt.Printf("Server %d: RPC Count: %d\n", i, cfg.rpcCount(i))
+		fmt.Printf("Server %d: RPC Count: %d RPC Bytes: %d\n", i, cfg.rpcCount(i), cfg.rpcBytes(i))
+	}
+
+	for svcMeth, ms := range cfg.net.MethodStats() {
+		fmt.Printf("Method %s: RPC Count: %d Failed: %d Mean Latency: %v\n", svcMeth, ms.Count, ms.Failed,
+			ms.MeanLatency())
 	}
 }
 
 func (cfg *config) checkLogs() {
 	for i := 1; i < cfg.n; i++ {
-		for j := 1; j < cfg.pbftServers[i].executeSeqNum; j++ {
-			fmt.Printf("Server %d Round %d Commits %d\n", i, j, len(cfg.pbftServers[i].commitLog[j].Msg1))
+		pbft := cfg.pbftServers[i]
+		pbft.mu.Lock()
+		for j := 1; j < pbft.executeSeqNum; j++ {
+			if j >= pbft.truncated {
+				fmt.Printf("Server %d Round %d Commits %d\n", i, j, len(pbft.commitLog[j-pbft.truncated].Msg1))
+			}
 		}
+		pbft.mu.Unlock()
 	}
 }
 
@@ -50,13 +527,95 @@ func benchmarkNoFaults(n int, size int, b *testing.B) {
 	cfg := makeConfig(nil, servers, false)
 	defer cfg.cleanup()
 
-	op := make([]byte, size)
-	rand.Read(op) // Operation is random byte array of size bytes
+	gen := workload.MakeGenerator(workload.Config{Size: size}, cfg.rand.Int63()) // Writes of size bytes
 
+	cfg.resetStats() // Only measure the proposals
 	b.ResetTimer()
+	mem := startMemStats()
+	prof := startProfiles(b)
+	committed := 0
 	for i := 0; i < b.N; i++ {
-		cfg.client.Propose(op)
+		if cfg.client.Propose(gen.Next()) {
+			committed++
+		}
 	}
+	stopProfiles(prof, b)
+
+	b.ReportMetric(float64(cfg.totalBytes())/float64(b.N), "bytes/op")
+	reportMemStats(mem, committed, b)
+}
+
+// Same as benchmarkNoFaults() but with the PBFT servers spread over a WAN topology
+func benchmarkGeoReplication(topo *network.Topology, n int, size int, b *testing.B) {
+	servers := n // The number of PBFT servers is n-1 (client included!)
+	cfg := makeConfig(nil, servers, false)
+	defer cfg.cleanup()
+
+	cfg.setTopology(topo)
+
+	gen := workload.MakeGenerator(workload.Config{Size: size}, cfg.rand.Int63()) // Writes of size bytes
+
+	cfg.resetStats() // Only measure the proposals
+	b.ResetTimer()
+	mem := startMemStats()
+	prof := startProfiles(b)
+	committed := 0
+	for i := 0; i < b.N; i++ {
+		if cfg.client.Propose(gen.Next()) {
+			committed++
+		}
+	}
+	stopProfiles(prof, b)
+
+	b.ReportMetric(float64(cfg.totalBytes())/float64(b.N), "bytes/op")
+	reportMemStats(mem, committed, b)
+}
+
+// Encodes and decodes msg once per iteration and reports the size of the encoded message
+func benchmarkCodec(codec network.Codec, msg interface{}, b *testing.B) {
+	var data []byte
+
+	for i := 0; i < b.N; i++ {
+		data, _ = codec.Encode(msg)
+		if err := codec.Decode(data, reflect.New(reflect.TypeOf(msg)).Interface()); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(len(data)), "bytes/msg")
+}
+
+func sampleCommitMessage(size int) CommitMessage {
+	op := make([]byte, size)
+	rand.Read(op) // Operation is random byte array of size bytes
+
+	request := ClientRequest{MsgType: REPLICATE, Timestamp: 1, Operation: op, ClientId: CLIENT, Nonce: newNonce()}
+	signature := make([]byte, 128) // Size of an RSA-1024 signature
+	rand.Read(signature)
+
+	msg := Message{
+		MsgType:         COMMIT,
+		MsgDigest:       digest(request),
+		Signature:       signature,
+		PrepareSeqNum:   1,
+		View:            1,
+		ClientTimestamp: 1,
+		SenderId:        1}
+
+	return CommitMessage{Msg: msg, Request: request}
+}
+
+// Benchmark_Codec - Serialization cost of PBFT messages (n.b. compare the bytes/msg metric)
+func Benchmark_Codec_Gob_CommitMessage_1kB(b *testing.B) {
+	benchmarkCodec(network.GobCodec{}, sampleCommitMessage(1024), b)
+}
+func Benchmark_Codec_JSON_CommitMessage_1kB(b *testing.B) {
+	benchmarkCodec(network.JSONCodec{}, sampleCommitMessage(1024), b)
+}
+
+// Benchmark_Geo - Servers spread round-robin over 3 datacenters, No Faults
+func Benchmark_Geo_3DC_1kB(b *testing.B) {
+	benchmarkGeoReplication(network.ThreeDatacenters(4), 5, 1024, b)
 }
 
 // Benchmark_3_0 - Number of PBFT servers = 4 (t=1), No Faults
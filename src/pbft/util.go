@@ -3,30 +3,91 @@ package pbft
 import (
 	"bytes"
 	"crypto"
-	crand "crypto/rand"
-	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/csanti/cos518_project/src/debug"
+	"github.com/csanti/cos518_project/src/journal"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"github.com/csanti/cos518_project/src/tracing"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 //
 // ------------------------------ DEBUG FUNCTIONS -----------------------------
 //
 func dPrintf(format string, a ...interface{}) (n int, err error) {
-	if DEBUG > 1 {
-		log.Printf(format, a...)
-	}
-	return
+	return debug.Printf(debug.PBFT, debug.DEBUG, format, a...)
 }
 
 func iPrintf(format string, a ...interface{}) (n int, err error) {
-	if DEBUG > 0 {
-		log.Printf(format, a...)
-	}
-	return
+	return debug.Printf(debug.PBFT, debug.INFO, format, a...)
+}
+
+// Records of a server carry its ID as the "server" field (see debug/logger.go)
+func (pbft *Pbft) log() *debug.Logger {
+	return pbft.logger.Load().(*debug.Logger)
+}
+
+// Replaces the logger of the server (i.e. to collect its records in a test)
+func (pbft *Pbft) SetLogger(logger *debug.Logger) {
+	pbft.logger.Store(logger.With("server", pbft.id))
+}
+
+// Spans of the protocol phases of requests go to tracer (see tracing)
+func (pbft *Pbft) SetTracer(tracer *tracing.Tracer) {
+	pbft.tracer.Store(tracer)
+}
+
+func (pbft *Pbft) getTracer() *tracing.Tracer {
+	return pbft.tracer.Load().(*tracing.Tracer)
+}
+
+// Significant transitions of the server (see journal)
+func (pbft *Pbft) Journal() *journal.Journal {
+	return pbft.journal
+}
+
+// Must be called with pbft.mu held
+func (pbft *Pbft) record(kind int, seqNum int) {
+	pbft.journal.Record(time.Now(), kind, pbft.view, seqNum)
+}
+
+// The client server logs under its own module
+var clientLogger = debug.MakeLogger(debug.CLIENT)
+
+// Records about a message carry its view, sequence number and trace ID
+func (pbft *Pbft) logMsg(msg Message) *debug.Logger {
+	return pbft.log().With("view", msg.View, "seqNum", msg.PrepareSeqNum, "trace", msg.TraceId)
+}
+
+// Messages about a client request carry its trace ID to the network events (see network/events.go)
+func (request ClientRequest) Trace() string {
+	return request.TraceId
+}
+
+func (prepareEntry PrepareLogEntry) Trace() string {
+	return prepareEntry.Request.TraceId
+}
+
+func (msg CommitMessage) Trace() string {
+	return msg.Request.TraceId
 }
 
+func (creply ClientReply) Trace() string {
+	return creply.TraceId
+}
+
+// Recoverable failures (bad messages) are returned as errors wrapping these and handled as
+// protocol faults; checkError() is only for the test harness and key setup
+var errUnknownServer = errors.New("unknown server")
+var errSeqNum = errors.New("sequence number out of range")
+
 func checkError(err error) {
 	if err != nil {
 		log.Fatal(err)
@@ -42,26 +103,95 @@ func digest(msg interface{}) [32]byte { // Crypto message digest
 	return sha256.Sum256(jsonBytes)
 }
 
-func generateKeys() (*rsa.PrivateKey, *rsa.PublicKey) { // Crypto RSA private/public key generation
-	key, err := rsa.GenerateKey(crand.Reader, BITSIZE)
+func generateKeys(scheme signing.Scheme) (crypto.Signer, crypto.PublicKey) { // Crypto private/public key generation
+	key, err := scheme.GenerateKey()
 	checkError(err)
-	return key, &key.PublicKey
+	return key, key.Public()
+}
+
+// Key pairs shared by the configs of all tests, since RSA key generation dominates their setup:
+// PBFT server i of every test gets key pair i of its scheme, generated the first time a test
+// needs it
+// => Tests that need keys no other test used get fresh ones with makeConfigFreshKeys() (or all
+//    tests with -freshkeys)
+var keyPool struct {
+	mu   sync.Mutex
+	keys map[signing.Scheme][]crypto.Signer
+}
+
+func pooledKeys(scheme signing.Scheme, i int) (crypto.Signer, crypto.PublicKey) {
+	keyPool.mu.Lock()
+	defer keyPool.mu.Unlock()
+
+	if keyPool.keys == nil {
+		keyPool.keys = make(map[signing.Scheme][]crypto.Signer)
+	}
+	for len(keyPool.keys[scheme]) <= i {
+		keyPool.keys[scheme] = append(keyPool.keys[scheme], nil)
+	}
+	if keyPool.keys[scheme][i] == nil {
+		keyPool.keys[scheme][i], _ = generateKeys(scheme)
+	}
+	return keyPool.keys[scheme][i], keyPool.keys[scheme][i].Public()
+}
+
+func (cfg *config) keys(i int) (crypto.Signer, crypto.PublicKey) {
+	if cfg.freshKeys {
+		return generateKeys(cfg.scheme)
+	}
+	return pooledKeys(cfg.scheme, i)
 }
 
 func (pbft *Pbft) sign(msgDigest [32]byte) []byte { // Crypto message signature
-	signature, err := rsa.SignPKCS1v15(crand.Reader, pbft.privateKey, crypto.SHA256, msgDigest[:])
-	checkError(err)
+	start := time.Now()
+	signature, err := pbft.signer.Sign(msgDigest)
+	atomic.AddInt64(&pbft.signTime, int64(time.Since(start)))
+	if err != nil { // Receivers reject the unsigned message like any other invalid signature
+		pbft.log().Infof("Sign: signature of server %d: %v", pbft.id, err)
+		return nil
+	}
 	return signature
 }
 
 func (pbft *Pbft) verify(server int, msgDigest [32]byte, signature []byte) bool { // Crypto signature verification
-	err := rsa.VerifyPKCS1v15(pbft.publicKeys[server], crypto.SHA256, msgDigest[:], signature)
-	if err != nil {
+	if err := pbft.checkSignature(server, msgDigest, signature); err != nil {
+		pbft.log().Debugf("Verify: %v", err)
 		return false
 	}
 	return true
 }
 
+// Messages may claim any sender, so one without a public key is an invalid signature (errUnknownServer)
+func (pbft *Pbft) checkSignature(server int, msgDigest [32]byte, signature []byte) error {
+	publicKey := pbft.publicKeys[server]
+	if publicKey == nil {
+		return fmt.Errorf("signature of server %d: %w", server, errUnknownServer)
+	}
+
+	start := time.Now()
+	err := signing.Verify(publicKey, msgDigest, signature)
+	atomic.AddInt64(&pbft.verifyTime, int64(time.Since(start)))
+	if err != nil {
+		return fmt.Errorf("signature of server %d: %w", server, err)
+	}
+	return nil
+}
+
+// Sequence numbers index the logs from their first entry on (see checkpoint.go), so a message must
+// not make a server grow them without bound
+func checkSeqNum(seqNum int, first int, length int) error {
+	if seqNum < first || seqNum > length+MAXGAP {
+		return fmt.Errorf("sequence number %d of a log of entries %d to %d: %w", seqNum, first, length, errSeqNum)
+	}
+	return nil
+}
+
+// Time the server spent signing messages and verifying signatures, apart from the network and the
+// queues of its RPC server (see experiment)
+func (pbft *Pbft) CryptoTime() (time.Duration, time.Duration) {
+	return time.Duration(atomic.LoadInt64(&pbft.signTime)), time.Duration(atomic.LoadInt64(&pbft.verifyTime))
+}
+
 //
 // ------------------------------ HELPER FUNCTIONS ----------------------------
 //
@@ -69,6 +199,14 @@ func (pbft *Pbft) getLeader() int {
 	return ((pbft.view - 1) % (len(pbft.replicas) - 1)) + 1
 }
 
+// Time the primary assigns to the entries it pre-prepares, in nanoseconds
+func (pbft *Pbft) now() int64 {
+	pbft.failMu.Lock()
+	defer pbft.failMu.Unlock()
+
+	return pbft.clock.Now().UnixNano()
+}
+
 func (pbft *Pbft) generateSynchronousGroup(seed int64) {
 	pbft.synchronousGroup = make(map[int]bool, 0)
 
@@ -81,10 +219,10 @@ func (pbft *Pbft) generateSynchronousGroup(seed int64) {
 
 func (pbft *Pbft) appendToPrepareLog(request ClientRequest, msg Message) PrepareLogEntry {
 	pEDefault := PrepareLogEntry{}
-	for request.Timestamp >= len(pbft.prepareLog) {
+	for request.Timestamp >= pbft.prepareLength() {
 		pbft.prepareLog = append(pbft.prepareLog, pEDefault)
 	}
-	pE := pbft.prepareLog[request.Timestamp]
+	pE := pbft.prepareLog[request.Timestamp-pbft.truncated]
 
 	if len(pE.Msg1) == 0 {
 		msgMap := make(map[int]Message)
@@ -104,17 +242,24 @@ func (pbft *Pbft) appendToPrepareLog(request ClientRequest, msg Message) Prepare
 	return prepareEntryCopy
 }
 
-func (pbft *Pbft) addToPrepareLog(prepareLog PrepareLogEntry) bool {
+func (pbft *Pbft) addToPrepareLog(prepareLog PrepareLogEntry) (bool, error) {
+	if prepareLog.Msg0.PrepareSeqNum >= 0 && prepareLog.Msg0.PrepareSeqNum < pbft.truncated {
+		return false, nil // Late prepare of a request below the stable checkpoint
+	}
+	if err := checkSeqNum(prepareLog.Msg0.PrepareSeqNum, pbft.truncated, pbft.prepareLength()); err != nil {
+		return false, err
+	}
+	index := prepareLog.Msg0.PrepareSeqNum - pbft.truncated
 	sender := prepareLog.Hop
 	if sender == 0 {
 		sender = prepareLog.Msg0.SenderId
 	}
 	pEDefault := PrepareLogEntry{}
-	for prepareLog.Msg0.PrepareSeqNum >= len(pbft.prepareLog) {
+	for index >= len(pbft.prepareLog) {
 		pbft.prepareLog = append(pbft.prepareLog, pEDefault)
 	}
-	if prepareLog.Msg0.PrepareSeqNum < len(pbft.prepareLog) {
-		pE := pbft.prepareLog[prepareLog.Msg0.PrepareSeqNum]
+	if index < len(pbft.prepareLog) {
+		pE := pbft.prepareLog[index]
 
 		if len(pE.Msg1) == 0 {
 			msgMap := make(map[int]Message)
@@ -123,26 +268,33 @@ func (pbft *Pbft) addToPrepareLog(prepareLog PrepareLogEntry) bool {
 				Request: prepareLog.Request,
 				Msg0:    prepareLog.Msg0,
 				Msg1:    msgMap}
-			pbft.prepareLog[prepareLog.Msg0.PrepareSeqNum] = prepareEntry
-			return false
+			pbft.prepareLog[index] = prepareEntry
+			return false, nil
 		} else {
 			pE.Msg1[sender] = prepareLog.Msg0
 			if len(pE.Msg1) >= 2*(len(pbft.replicas)-2)/3 {
-				return true
+				return true, nil
 			}
-			return false
+			return false, nil
 		}
 	}
-	return false
+	return false, nil
 }
 
-func (pbft *Pbft) addToCommitLog(cmsg CommitMessage) bool {
+func (pbft *Pbft) addToCommitLog(cmsg CommitMessage) (bool, error) {
+	if cmsg.Msg.PrepareSeqNum >= 0 && cmsg.Msg.PrepareSeqNum < pbft.truncated {
+		return false, nil // Late commit of a request below the stable checkpoint
+	}
+	if err := checkSeqNum(cmsg.Msg.PrepareSeqNum, pbft.truncated, pbft.commitLength()); err != nil {
+		return false, err
+	}
+	index := cmsg.Msg.PrepareSeqNum - pbft.truncated
 	cEDefault := CommitLogEntry{}
-	for cmsg.Msg.PrepareSeqNum >= len(pbft.commitLog) {
+	for index >= len(pbft.commitLog) {
 		pbft.commitLog = append(pbft.commitLog, cEDefault)
 	}
-	if cmsg.Msg.PrepareSeqNum < len(pbft.commitLog) {
-		cE := pbft.commitLog[cmsg.Msg.PrepareSeqNum]
+	if index < len(pbft.commitLog) {
+		cE := pbft.commitLog[index]
 
 		if len(cE.Msg1) == 0 {
 			msgMap := make(map[int]Message)
@@ -151,17 +303,58 @@ func (pbft *Pbft) addToCommitLog(cmsg CommitMessage) bool {
 				Request: cmsg.Request,
 				Msg0:    cmsg.Msg,
 				Msg1:    msgMap}
-			pbft.commitLog[cmsg.Msg.PrepareSeqNum] = commitEntry
-			return false
+			pbft.commitLog[index] = commitEntry
+			return false, nil
 		} else {
 			cE.Msg1[cmsg.Msg.SenderId] = cmsg.Msg
 			if len(cE.Msg1) >= 2*(len(pbft.replicas)-2)/3 {
-				return true
+				return true, nil
 			}
-			return false
+			return false, nil
 		}
 	}
-	return false
+	return false, nil
+}
+
+// Apply the committed requests that follow the last applied one to the state machine, in sequence
+// number order (a request committed after a gap is applied once the gap commits); must be called
+// with pbft.mu held
+func (pbft *Pbft) applyCommitted() {
+	if pbft.stateMachine == nil {
+		return
+	}
+
+	for seqNum := pbft.applied + 1; seqNum < pbft.commitLength(); seqNum++ {
+		if len(pbft.commitLog[seqNum-pbft.truncated].Msg1) < 2*(len(pbft.replicas)-2)/3 {
+			return
+		}
+		request := pbft.commitLog[seqNum-pbft.truncated].Request
+		span := pbft.getTracer().Start(request.TraceId, "Pbft.Execute")
+		span.Set("seqNum", seqNum)
+		pbft.results[seqNum] = statemachine.ApplyAt(pbft.stateMachine, statemachine.Encode(request.Operation),
+			pbft.commitLog[seqNum-pbft.truncated].Msg0.Time) // Primary's time, the same on every replica
+		span.End()
+		pbft.applied = seqNum
+
+		if pbft.interval > 0 && pbft.applied%pbft.interval == 0 {
+			pbft.takeCheckpoint()
+		}
+	}
+}
+
+// Reply to the client for the committed requests that follow the last executed one, once they are
+// applied (see applyCommitted()), in sequence number order; without a state machine a committed
+// request is executed as soon as its predecessors are. Must be called with pbft.mu held
+func (pbft *Pbft) replyExecuted() {
+	for seqNum := pbft.executeSeqNum + 1; seqNum < pbft.commitLength(); seqNum++ {
+		entry := pbft.commitLog[seqNum-pbft.truncated]
+		if len(entry.Msg1) < 2*(len(pbft.replicas)-2)/3 || (pbft.stateMachine != nil && seqNum > pbft.applied) {
+			return
+		}
+		pbft.executeSeqNum = seqNum
+		pbft.record(journal.EXECUTED, pbft.executeSeqNum)
+		go pbft.issueReply(entry.Request, pbft.results[seqNum])
+	}
 }
 
 func (pbft *Pbft) appendToCommitLog(request ClientRequest, msg Message, msgMap map[int]Message) {
@@ -179,7 +372,7 @@ func (pbft *Pbft) updatePrepareLog(seqNum int, request ClientRequest, msg Messag
 		Request: request,
 		Msg0:    msg}
 
-	pbft.prepareLog[seqNum] = prepareEntry
+	pbft.prepareLog[seqNum-pbft.truncated] = prepareEntry
 }
 
 func (pbft *Pbft) compareLogs(prepareLog []PrepareLogEntry, commitLog []CommitLogEntry) bool {
@@ -288,3 +481,52 @@ func getCurrentView(cfg *config) int {
 
 	return currentView
 }
+
+// View that at least (n+1)/2 of the running servers are in, or 0 while there is none (unlike
+// getCurrentView(), this skips crashed servers and does not fail the test)
+func (cfg *config) agreedView() int {
+	views := make(map[int]int)
+	for i := 1; i < cfg.n; i++ {
+		if pbft := cfg.pbftServers[i]; pbft != nil {
+			pbft.mu.Lock()
+			views[pbft.view]++
+			pbft.mu.Unlock()
+		}
+	}
+
+	agreed := 0
+	for view, num := range views {
+		if num >= (cfg.n+1)/2 && view > agreed {
+			agreed = view
+		}
+	}
+	return agreed
+}
+
+// Wait until the servers agree on view v or a later one and return it; the test fails if they do
+// not within timeout
+// => PBFT has no view change yet, so servers stay in view 1
+func (cfg *config) waitForView(v int, timeout time.Duration) int {
+	for deadline := time.Now().Add(timeout); ; time.Sleep(10 * time.Millisecond) {
+		if view := cfg.agreedView(); view >= v {
+			return view
+		} else if time.Now().After(deadline) {
+			iPrintf("Servers agree on view %d instead of %d\n", view, v)
+			cfg.t.Fatalf("Servers failed to reach view %d!", v)
+		}
+	}
+}
+
+// Wait until the servers agree on a view whose leader is not server exclude (i.e. the crashed or
+// Byzantine leader) and return the new leader; the test fails if they do not within timeout
+func (cfg *config) waitForNewLeader(exclude int, timeout time.Duration) int {
+	for deadline := time.Now().Add(timeout); ; time.Sleep(10 * time.Millisecond) {
+		view := cfg.agreedView()
+		if leader := ((view - 1) % (cfg.n - 1)) + 1; view > 0 && leader != exclude {
+			return leader
+		} else if time.Now().After(deadline) {
+			iPrintf("Servers agree on view %d (leader %d)\n", view, leader)
+			cfg.t.Fatal("Servers failed to elect a new leader!")
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package profiling
+
+// CPU and mutex contention profiles of the measurement window of benchmarks
+//
+// p, err := Start(cpuPath, mutexPath) - Profiles the CPU and/or mutex contention from now on
+// paths, err := p.Stop()              - Stops profiling and writes the profiles, returns their paths
+// FileName(dir, name, kind)           - Path of a profile of a run, i.e. "dir/<name>.cpu.pprof"
+//
+// => Either path may be empty to skip that profile (Start("", "") profiles nothing); both files
+//    are created by Start(), so an invalid path fails before the benchmark runs
+// => The CPU profile samples every goroutine of the process (client, servers and simulated network),
+//    so "go tool pprof -top" ranks i.e. RSA, gob and the network against each other
+// => Mutex contention is sampled (1 in MUTEXRATE events) only between Start() and Stop(), but the
+//    runtime accumulates it over the whole process: when several runs share a process, the profile
+//    of a run also holds the contention of the runs before it (compare two profiles with
+//    "go tool pprof -base")
+// => Only one CPU profile can run at a time in a process, so Start() fails while another one
+//    runs, i.e. that of "go test -cpuprofile"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+)
+
+const MUTEXRATE = 5 // On average 1 in MUTEXRATE mutex contention events is sampled
+
+type Profiler struct {
+	cpu       *os.File // CPU profile being written (nil if none)
+	mutex     *os.File // Mutex contention profile, written by Stop() (nil if none)
+	mutexRate int      // Sampling rate of mutex contention before Start()
+}
+
+func Start(cpuPath string, mutexPath string) (*Profiler, error) {
+	p := &Profiler{}
+
+	if cpuPath != "" {
+		file, err := create(cpuPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("CPU profile %s: %v", cpuPath, err)
+		}
+		p.cpu = file
+	}
+
+	if mutexPath != "" {
+		file, err := create(mutexPath)
+		if err != nil {
+			p.stopCPU()
+			return nil, err
+		}
+		p.mutex = file
+		p.mutexRate = runtime.SetMutexProfileFraction(MUTEXRATE)
+	}
+
+	return p, nil
+}
+
+func create(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func (p *Profiler) stopCPU() error {
+	if p.cpu == nil {
+		return nil
+	}
+	pprof.StopCPUProfile()
+	err := p.cpu.Close()
+	p.cpu = nil
+	return err
+}
+
+// Returns the paths of the profiles written, even if another one failed
+func (p *Profiler) Stop() ([]string, error) {
+	paths := make([]string, 0)
+	var failed error
+
+	if p.cpu != nil {
+		path := p.cpu.Name()
+		if err := p.stopCPU(); err != nil {
+			failed = err
+		} else {
+			paths = append(paths, path)
+		}
+	}
+
+	if p.mutex != nil {
+		runtime.SetMutexProfileFraction(p.mutexRate)
+		err := pprof.Lookup("mutex").WriteTo(p.mutex, 0)
+		if closeErr := p.mutex.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			failed = err
+		} else {
+			paths = append(paths, p.mutex.Name())
+		}
+		p.mutex = nil
+	}
+
+	return paths, failed
+}
+
+// "" if dir is empty (no profile); slashes of sub-benchmark names are replaced by underscores
+func FileName(dir string, name string, kind string) string {
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, strings.ReplaceAll(name, "/", "_")+"."+kind+".pprof")
+}
@@ -0,0 +1,80 @@
+package profiling
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestProfiler(t *testing.T) {
+	fmt.Println("Test: Profiling - CPU and Mutex Contention Profiles")
+
+	dir := t.TempDir()
+	cpuPath := FileName(filepath.Join(dir, "profiles"), "Benchmark_Scaling/XPaxos_4", "cpu")
+	mutexPath := FileName(filepath.Join(dir, "profiles"), "Benchmark_Scaling/XPaxos_4", "mutex")
+	if cpuPath != filepath.Join(dir, "profiles", "Benchmark_Scaling_XPaxos_4.cpu.pprof") {
+		t.Fatalf("Invalid profile path %s!", cpuPath)
+	}
+	if FileName("", "Benchmark_Scaling", "cpu") != "" {
+		t.Fatal("Profile path without a directory!")
+	}
+
+	p, err := Start(cpuPath, mutexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Start(cpuPath, ""); err == nil {
+		t.Fatal("Second CPU profile started!")
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ { // Contend for mu while hashing
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sum := [32]byte{}
+			for start := time.Now(); time.Since(start) < 200*time.Millisecond; {
+				mu.Lock()
+				sum = sha256.Sum256(sum[:])
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	paths, err := p.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || paths[0] != cpuPath || paths[1] != mutexPath {
+		t.Fatalf("Invalid profiles %v!", paths)
+	}
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Fatalf("Empty profile %s!", path)
+		}
+	}
+	if runtime.SetMutexProfileFraction(-1) != 0 {
+		t.Fatal("Mutex contention still sampled after Stop()!")
+	}
+
+	none, err := Start("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths, err := none.Stop(); err != nil || len(paths) != 0 {
+		t.Fatalf("Profiles %v written without paths!", paths)
+	}
+	if _, err := Start(filepath.Join(cpuPath, "cpu.pprof"), ""); err == nil {
+		t.Fatal("Profile created below a file!")
+	}
+}
@@ -0,0 +1,192 @@
+package signing
+
+// X.509 certificates of the identities of servers, issued by a certificate authority
+//
+// ca, err := MakeCA(scheme)                        - Fresh self-signed CA with a key of scheme
+// ca, err := LoadCA(certificate, key)              - CA of a DER certificate and PKCS #8 key
+// certificate, err := ca.Issue(id, publicKey)      - DER certificate binding publicKey to server id
+// ca.Root()                                        - Certificate of the CA, which servers trust
+// ca.Certificate(), ca.Key()                       - DER certificate and PKCS #8 key of the CA
+// publicKey, err := VerifyIdentity(root, cert, id) - Public key of server id if root issued cert to it
+//
+// => The identity of a server is a URI in the subject alternative names of its certificate,
+//    "urn:cos518:server:<id>", so that a certificate of one server never passes for another one
+// => Certificates carry keys of any scheme (see signing.go), whatever the scheme of the CA's key;
+//    X.509 has no public keys of RSA-PSS only, so the certificate of a PSS key holds its RSA key
+//    and the URI "urn:cos518:scheme:rsa-pss-<bits>", which VerifyIdentity() turns back into a PSS
+//    key (a CA of a PSS key signs its certificates with RSA-PSS too)
+// => Certificates are valid for CERTVALIDITY from the time they are issued (and an hour before it,
+//    to tolerate clock skew); nothing revokes them, so a server whose key leaked must rotate it
+// => The CA of the tests and of clusters made by InitCluster() (see xpaxos/cluster.go) is kept
+//    with its key, to issue the certificates of rotated keys; a real deployment keeps the key of
+//    its CA offline
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const CERTVALIDITY = 365 * 24 * time.Hour
+
+type CA struct {
+	mu          sync.Mutex
+	key         crypto.Signer
+	certificate *x509.Certificate
+	serial      int64 // Serial number of the last certificate issued
+}
+
+var errIdentity = errors.New("certificate of another identity")
+
+// Identity of server id in the subject alternative names of its certificate
+func identity(id int) *url.URL {
+	return &url.URL{Scheme: "urn", Opaque: fmt.Sprintf("cos518:server:%d", id)}
+}
+
+// Scheme of the key of a certificate in its subject alternative names, where X.509 cannot tell it
+func schemeURI(scheme Scheme) *url.URL {
+	return &url.URL{Scheme: "urn", Opaque: "cos518:scheme:" + scheme.String()}
+}
+
+// Key of a certificate that X.509 can hold, and the algorithm a CA of the key signs with
+func certificateKey(key crypto.PublicKey) (crypto.PublicKey, x509.SignatureAlgorithm) {
+	if key, ok := key.(PSSPublicKey); ok {
+		return key.PublicKey, x509.SHA256WithRSAPSS
+	}
+	return key, x509.UnknownSignatureAlgorithm
+}
+
+// Signer of the CA that X.509 can sign with
+func certificateSigner(key crypto.Signer) crypto.Signer {
+	if key, ok := key.(PSSKey); ok {
+		return key.PrivateKey
+	}
+	return key
+}
+
+func MakeCA(scheme Scheme) (*CA, error) {
+	key, err := scheme.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	publicKey, algorithm := certificateKey(key.Public())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cos518 servers CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(CERTVALIDITY),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SignatureAlgorithm:    algorithm,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, publicKey, certificateSigner(key))
+	if err != nil {
+		return nil, fmt.Errorf("certificate of the CA: %w", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("certificate of the CA: %w", err)
+	}
+	return &CA{key: key, certificate: certificate, serial: 1}, nil
+}
+
+func LoadCA(certificate []byte, key []byte) (*CA, error) {
+	parsed, err := x509.ParseCertificate(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("certificate of the CA: %w", err)
+	}
+	privateKey, err := ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("key of the CA: %w", err)
+	}
+	return &CA{key: privateKey, certificate: parsed, serial: time.Now().UnixNano()}, nil
+}
+
+func (ca *CA) Issue(id int, publicKey crypto.PublicKey) ([]byte, error) {
+	scheme, err := SchemeOf(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("certificate of server %d: %w", id, err)
+	}
+	uris := []*url.URL{identity(id)}
+	if _, ok := publicKey.(PSSPublicKey); ok {
+		uris = append(uris, schemeURI(scheme))
+	}
+	certifiedKey, _ := certificateKey(publicKey)
+	_, algorithm := certificateKey(ca.key.Public())
+
+	ca.mu.Lock()
+	ca.serial++
+	serial := ca.serial
+	ca.mu.Unlock()
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:       big.NewInt(serial),
+		Subject:            pkix.Name{CommonName: fmt.Sprintf("server %d", id)},
+		NotBefore:          now.Add(-time.Hour),
+		NotAfter:           now.Add(CERTVALIDITY),
+		KeyUsage:           x509.KeyUsageDigitalSignature,
+		URIs:               uris,
+		SignatureAlgorithm: algorithm,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, certifiedKey, certificateSigner(ca.key))
+	if err != nil {
+		return nil, fmt.Errorf("certificate of server %d: %w", id, err)
+	}
+	return der, nil
+}
+
+func (ca *CA) Root() *x509.Certificate {
+	return ca.certificate
+}
+
+func (ca *CA) Certificate() []byte {
+	return ca.certificate.Raw
+}
+
+func (ca *CA) Key() ([]byte, error) {
+	return MarshalPrivateKey(ca.key)
+}
+
+func VerifyIdentity(root *x509.Certificate, certificate []byte, id int) (crypto.PublicKey, error) {
+	parsed, err := x509.ParseCertificate(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("certificate of server %d: %w", id, err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	options := x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	if _, err := parsed.Verify(options); err != nil {
+		return nil, fmt.Errorf("certificate of server %d: %w", id, err)
+	}
+
+	publicKey, identified := parsed.PublicKey, false
+	for _, uri := range parsed.URIs {
+		if uri.String() == identity(id).String() {
+			identified = true
+		}
+		if rsaKey, ok := parsed.PublicKey.(*rsa.PublicKey); ok {
+			if scheme, err := SchemeOf(PSSPublicKey{rsaKey}); err == nil && uri.String() == schemeURI(scheme).String() {
+				publicKey = PSSPublicKey{rsaKey}
+			}
+		}
+	}
+	if identified == false {
+		return nil, fmt.Errorf("certificate of server %d: %w", id, errIdentity)
+	}
+	if _, err := SchemeOf(publicKey); err != nil {
+		return nil, fmt.Errorf("certificate of server %d: %w", id, err)
+	}
+	return publicKey, nil
+}
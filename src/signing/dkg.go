@@ -0,0 +1,805 @@
+package signing
+
+// Distributed key generation: n signers generate a k-of-n threshold key (see threshold.go)
+// together, without a trusted dealer, so that none of them ever knows its private exponent
+//
+// ceremony, err := MakeCeremony(number, id, k, n, bits, signer, publicKeys)
+//                                      - Signer id (1..n) of ceremony number of a k-of-n key of bits bits
+// msgs, err := ceremony.Start()        - Messages of the first round, one to every signer (id included)
+// msgs, err := ceremony.Next(received) - Messages of the next round, from the messages of this round
+//                                         of all n signers (none once the ceremony is over)
+// ceremony.Share()                     - Share of the key of the signer (nil until the ceremony is over)
+// msg.Digest()                         - Digest of a message, which its signer signs
+//
+// => Rounds are synchronous: every signer sends one message to every signer in every round, and
+//    moves on once it holds those of all n signers; messages are signed by the long-term keys of
+//    their signers, and the values for one signer only are sealed (AES-GCM) under a pairwise key of
+//    ephemeral X25519 keys exchanged in the first round, so that a transport that reads or injects
+//    messages learns and changes nothing; messages carry the number of their ceremony, which the
+//    signers agree on beforehand, so that those of one ceremony never pass for those of another
+// => The modulus is generated as Boneh and Franklin do ("Efficient Generation of Shared RSA Keys",
+//    CRYPTO 1997): every signer picks additive shares of candidate primes p and q, the signers
+//    compute N = pq from Shamir shares of them (BGW multiplication, which needs n >= 2k-1, i.e. the
+//    t+1 of 2t+1 servers of XPaxos), drop the candidates with a factor below DKGSIEVE and keep the
+//    first that passes DKGTESTS rounds of their distributed biprimality test; candidates go in
+//    batches of DKGBATCH, of which a 1024-bit modulus takes ~30 on average
+// => The private exponent d of THRESHOLDEXPONENT is derived from the additive shares of phi(N),
+//    revealing phi(N) mod e as Boneh and Franklin do; every signer then deals its additive share of
+//    d with a polynomial over the integers and commits to its coefficients in the squares of Z_N
+//    (Feldman), so that every share is checked against the commitments of its dealer and the
+//    verification keys of all signers follow from the commitments
+// => The key is a key of Shoup's scheme whose primes are not safe primes: Damgård and Koprowski
+//    ("Practical Threshold RSA Signatures without a Trusted Dealer", EUROCRYPT 2001) show that its
+//    shares and their proofs remain sound for such moduli; the shares of a polynomial over the
+//    integers leak d modulo the IDs of their signers, a few bits like phi(N) mod e
+// => The last round signs a test digest with every share, and the ceremony fails unless all of
+//    them verify and combine into a signature of the key
+// => The ceremony assumes the signers follow it (honest-but-curious, as Boneh and Franklin do): a
+//    signer that deviates can make it fail, and shares or signatures that do not verify are pinned
+//    on their signer, but the ceremony is not robust against it
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+const DKGBATCH = 1024 // Candidate moduli per round
+const DKGSIEVE = 4096 // Candidate moduli with a prime factor below it are dropped
+const DKGTESTS = 40   // Rounds of the biprimality test of a modulus (a false biprime passes each with probability 1/2 at most)
+const DKGMARGIN = 128 // Bits by which the coefficients of the shares of d exceed the modulus
+
+type DKGMessage struct {
+	Ceremony  int
+	Round     int
+	From      int
+	To        int
+	Ephemeral []byte     // X25519 public key of From (first round only)
+	Values    []*big.Int // Public values of the round, the same for every signer
+	Sealed    []byte     // Values of the round for To only, under the pairwise key of From and To
+	Signature []byte     // By the long-term key of From, of all other fields
+}
+
+// Phases of a ceremony; the candidate phases repeat until a batch holds a biprime
+const (
+	dkgKeys       = iota // Ephemeral keys
+	dkgCandidates        // Shamir shares of the additive shares of p and q of every candidate
+	dkgModuli            // Shares of the candidate moduli
+	dkgTest              // First test of the moduli without small factors
+	dkgConfirm           // Remaining tests of the moduli that passed it
+	dkgTotient           // Additive shares of phi(N) mod e
+	dkgExponent          // x^(e d_i) of the additive shares of d, to correct their rounding
+	dkgDeal              // Commitments to the coefficients of the shares of d and the shares
+	dkgCheck             // Signature shares of a test digest
+	dkgDone
+)
+
+type Ceremony struct {
+	number     int
+	id         int
+	k          int
+	n          int
+	bits       int
+	signer     Signer
+	publicKeys map[int]crypto.PublicKey
+	round      int
+	phase      int
+	ephemeral  *ecdh.PrivateKey
+	aeads      map[int]cipher.AEAD // Signer ID -> pairwise key of the sealed values
+	field      *big.Int            // Prime of the Shamir shares of p and q, above any candidate modulus
+	lagrange   []*big.Int          // Coefficients at 0 of the signers 1..n in the field, at i-1
+	candidates []candidate         // Of the current batch
+	moduli     []*big.Int          // Of the current batch
+	survivors  []int               // Candidates without small factors
+	passed     []int               // Survivors that passed the first test
+	chosen     candidate
+	modulus    *big.Int
+	secret     *big.Int // Additive share of d
+	key        *ThresholdKey
+	share      *ThresholdShare
+}
+
+type candidate struct {
+	p *big.Int // Additive shares of the signer
+	q *big.Int
+}
+
+var errCeremony = errors.New("invalid ceremony message")
+
+func MakeCeremony(number int, id int, k int, n int, bits int, signer Signer,
+	publicKeys map[int]crypto.PublicKey) (*Ceremony, error) {
+	if k < 1 || 2*k-1 > n || n >= THRESHOLDEXPONENT || id < 1 || id > n {
+		return nil, fmt.Errorf("ceremony of signer %d of a threshold key of %d of %d signers", id, k, n)
+	}
+	for i := 1; i <= n; i++ {
+		if publicKeys[i] == nil {
+			return nil, fmt.Errorf("ceremony: no public key of signer %d", i)
+		}
+	}
+
+	c := &Ceremony{}
+	c.number = number
+	c.id = id
+	c.k = k
+	c.n = n
+	c.bits = bits
+	c.signer = signer
+	c.publicKeys = publicKeys
+	c.aeads = make(map[int]cipher.AEAD, n)
+	c.field = fieldPrime(bits)
+	c.lagrange = make([]*big.Int, n)
+	for i := 1; i <= n; i++ {
+		numerator, denominator := big.NewInt(1), big.NewInt(1)
+		for j := 1; j <= n; j++ {
+			if j != i {
+				numerator.Mul(numerator, big.NewInt(int64(j)))
+				denominator.Mul(denominator, big.NewInt(int64(j-i)))
+			}
+		}
+		denominator.Mod(denominator, c.field)
+		c.lagrange[i-1] = numerator.Mul(numerator, denominator.ModInverse(denominator, c.field)).Mod(numerator, c.field)
+	}
+	return c, nil
+}
+
+func (c *Ceremony) Share() *ThresholdShare {
+	if c.phase != dkgDone {
+		return nil
+	}
+	return c.share
+}
+
+func (c *Ceremony) Start() ([]DKGMessage, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("ceremony: %w", err)
+	}
+	c.ephemeral = ephemeral
+	c.round = 0
+	c.phase = dkgKeys
+
+	msgs := make([]DKGMessage, 0, c.n)
+	for to := 1; to <= c.n; to++ {
+		msg := DKGMessage{Ceremony: c.number, Round: c.round, From: c.id, To: to,
+			Ephemeral: ephemeral.PublicKey().Bytes()}
+		if msg.Signature, err = c.signer.Sign(msg.Digest()); err != nil {
+			return nil, fmt.Errorf("ceremony: %w", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (c *Ceremony) Next(received []DKGMessage) ([]DKGMessage, error) {
+	if c.phase == dkgDone {
+		return nil, nil
+	}
+	byFrom := make([]DKGMessage, c.n+1)
+	for _, msg := range received {
+		if msg.From < 1 || msg.From > c.n || byFrom[msg.From].From != 0 || msg.Ceremony != c.number ||
+			msg.Round != c.round || msg.To != c.id {
+			return nil, fmt.Errorf("round %d of signer %d: %w", msg.Round, msg.From, errCeremony)
+		}
+		if err := Verify(c.publicKeys[msg.From], msg.Digest(), msg.Signature); err != nil {
+			return nil, fmt.Errorf("round %d of signer %d: %w", msg.Round, msg.From, err)
+		}
+		for _, value := range msg.Values {
+			if value == nil || value.Sign() < 0 {
+				return nil, fmt.Errorf("round %d of signer %d: %w", msg.Round, msg.From, errCeremony)
+			}
+		}
+		byFrom[msg.From] = msg
+	}
+	for from := 1; from <= c.n; from++ {
+		if byFrom[from].From == 0 {
+			return nil, fmt.Errorf("round %d: no message of signer %d", c.round, from)
+		}
+	}
+	msgs := byFrom[1:]
+
+	switch c.phase {
+	case dkgKeys:
+		return c.keys(msgs)
+	case dkgCandidates:
+		return c.shareModuli(msgs)
+	case dkgModuli:
+		return c.sieve(msgs)
+	case dkgTest:
+		return c.test(msgs)
+	case dkgConfirm:
+		return c.confirm(msgs)
+	case dkgTotient:
+		return c.exponent(msgs)
+	case dkgExponent:
+		return c.deal(msgs)
+	case dkgDeal:
+		return c.check(msgs)
+	case dkgCheck:
+		return nil, c.finish(msgs)
+	}
+	return nil, nil
+}
+
+//
+// --------------------------------- MODULUS ----------------------------------
+//
+func (c *Ceremony) keys(msgs []DKGMessage) ([]DKGMessage, error) {
+	for _, msg := range msgs {
+		peer, err := ecdh.X25519().NewPublicKey(msg.Ephemeral)
+		if err != nil {
+			return nil, fmt.Errorf("ephemeral key of signer %d: %w", msg.From, errCeremony)
+		}
+		shared, err := c.ephemeral.ECDH(peer)
+		if err != nil {
+			return nil, fmt.Errorf("ephemeral key of signer %d: %w", msg.From, err)
+		}
+		low, high := c.ephemeral.PublicKey().Bytes(), msg.Ephemeral
+		if c.id > msg.From {
+			low, high = high, low
+		}
+		key := sha256.Sum256(bytes.Join([][]byte{[]byte("dkg"), shared, low, high}, nil))
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, err
+		}
+		if c.aeads[msg.From], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return c.newBatch()
+}
+
+// Shamir shares of the additive shares of a batch of candidates: f(j), g(j) and h(j) for signer j,
+// of random polynomials with f(0) = p_i and g(0) = q_i of degree k-1, and h(0) = 0 of degree 2k-2
+func (c *Ceremony) newBatch() ([]DKGMessage, error) {
+	c.phase = dkgCandidates
+	c.candidates = make([]candidate, DKGBATCH)
+	sealed := make([][]*big.Int, c.n+1)
+	for j := 1; j <= c.n; j++ {
+		sealed[j] = make([]*big.Int, 0, 3*DKGBATCH)
+	}
+
+	for b := range c.candidates {
+		p, err := c.primeShare(c.bits / 2)
+		if err != nil {
+			return nil, err
+		}
+		q, err := c.primeShare(c.bits - c.bits/2)
+		if err != nil {
+			return nil, err
+		}
+		c.candidates[b] = candidate{p, q}
+
+		polynomials := [][]*big.Int{{p}, {q}, {big.NewInt(0)}}
+		for i, degree := range []int{c.k - 1, c.k - 1, 2 * (c.k - 1)} {
+			for d := 1; d <= degree; d++ {
+				coefficient, err := crand.Int(crand.Reader, c.field)
+				if err != nil {
+					return nil, err
+				}
+				polynomials[i] = append(polynomials[i], coefficient)
+			}
+		}
+		for j := 1; j <= c.n; j++ {
+			for _, polynomial := range polynomials {
+				sealed[j] = append(sealed[j], evaluate(polynomial, j, c.field))
+			}
+		}
+	}
+	return c.messages(nil, func(to int) []*big.Int { return sealed[to] })
+}
+
+// Additive share of a prime of bits bits: the shares of all signers sum to [2^(bits-1), 2^bits),
+// and to 3 mod 4 (signer 1 holds 3 mod 4, the others 0 mod 4), as the biprimality test needs
+func (c *Ceremony) primeShare(bits int) (*big.Int, error) {
+	low := new(big.Int).Lsh(big.NewInt(1), uint(bits-1))
+	low.Quo(low, big.NewInt(int64(c.n)))
+	share, err := crand.Int(crand.Reader, low) // Of [2^(bits-1)/n, 2^bits/n)
+	if err != nil {
+		return nil, err
+	}
+	share.Add(share, low)
+	share.Rsh(share, 2).Lsh(share, 2)
+	if c.id == 1 {
+		share.Add(share, big.NewInt(3))
+	}
+	return share, nil
+}
+
+// Share of signer id of every candidate modulus: (sum of f_i(id)) (sum of g_i(id)) + sum of h_i(id)
+func (c *Ceremony) shareModuli(msgs []DKGMessage) ([]DKGMessage, error) {
+	shares := make([]*big.Int, DKGBATCH)
+	fs, gs := make([]*big.Int, DKGBATCH), make([]*big.Int, DKGBATCH)
+	for b := range shares {
+		shares[b], fs[b], gs[b] = new(big.Int), new(big.Int), new(big.Int)
+	}
+	for _, msg := range msgs {
+		values, err := c.open(msg, 3*DKGBATCH)
+		if err != nil {
+			return nil, err
+		}
+		for b := range shares {
+			fs[b].Add(fs[b], values[3*b])
+			gs[b].Add(gs[b], values[3*b+1])
+			shares[b].Add(shares[b], values[3*b+2])
+		}
+	}
+	for b := range shares {
+		shares[b].Add(shares[b], fs[b].Mul(fs[b], gs[b])).Mod(shares[b], c.field)
+	}
+	c.phase = dkgModuli
+	return c.messages(shares, nil)
+}
+
+// Interpolates the candidate moduli and drops those with small factors (and those too small or
+// even, which only a signer that deviates makes)
+func (c *Ceremony) sieve(msgs []DKGMessage) ([]DKGMessage, error) {
+	for _, msg := range msgs {
+		if len(msg.Values) != DKGBATCH {
+			return nil, fmt.Errorf("moduli of signer %d: %w", msg.From, errCeremony)
+		}
+	}
+	c.moduli = make([]*big.Int, DKGBATCH)
+	c.survivors = nil
+	for b := range c.moduli {
+		modulus := new(big.Int)
+		for i, msg := range msgs {
+			modulus.Add(modulus, new(big.Int).Mul(msg.Values[b], c.lagrange[i]))
+		}
+		c.moduli[b] = modulus.Mod(modulus, c.field)
+		if modulus.BitLen() >= c.bits-2 && modulus.Bit(0) == 1 && smallFactor(modulus) == false {
+			c.survivors = append(c.survivors, b)
+		}
+	}
+	if len(c.survivors) == 0 {
+		return c.newBatch()
+	}
+
+	values := make([]*big.Int, 0, len(c.survivors))
+	for _, b := range c.survivors {
+		values = append(values, c.testValue(b, 0))
+	}
+	c.phase = dkgTest
+	return c.messages(values, nil)
+}
+
+func (c *Ceremony) test(msgs []DKGMessage) ([]DKGMessage, error) {
+	for _, msg := range msgs {
+		if len(msg.Values) != len(c.survivors) {
+			return nil, fmt.Errorf("biprimality test of signer %d: %w", msg.From, errCeremony)
+		}
+	}
+	c.passed = nil
+	for s, b := range c.survivors {
+		if biprime(c.moduli[b], msgs, s) {
+			c.passed = append(c.passed, b)
+		}
+	}
+	if len(c.passed) == 0 {
+		return c.newBatch()
+	}
+
+	values := make([]*big.Int, 0, len(c.passed)*(DKGTESTS-1))
+	for _, b := range c.passed {
+		for test := 1; test < DKGTESTS; test++ {
+			values = append(values, c.testValue(b, test))
+		}
+	}
+	c.phase = dkgConfirm
+	return c.messages(values, nil)
+}
+
+func (c *Ceremony) confirm(msgs []DKGMessage) ([]DKGMessage, error) {
+	for _, msg := range msgs {
+		if len(msg.Values) != len(c.passed)*(DKGTESTS-1) {
+			return nil, fmt.Errorf("biprimality test of signer %d: %w", msg.From, errCeremony)
+		}
+	}
+	chosen := -1
+	for i, b := range c.passed {
+		passed := true
+		for test := 0; test < DKGTESTS-1 && passed; test++ {
+			passed = biprime(c.moduli[b], msgs, i*(DKGTESTS-1)+test)
+		}
+		if passed {
+			chosen = b
+			break
+		}
+	}
+	if chosen < 0 {
+		return c.newBatch()
+	}
+	c.chosen = c.candidates[chosen]
+	c.modulus = c.moduli[chosen]
+	c.candidates, c.moduli = nil, nil
+
+	c.phase = dkgTotient
+	e := big.NewInt(THRESHOLDEXPONENT)
+	return c.messages([]*big.Int{new(big.Int).Mod(c.totient(), e)}, nil)
+}
+
+// g^(phi_i/4) of test of candidate b, with phi_1 = N - p_1 - q_1 + 1 and phi_i = p_i + q_i otherwise
+// (so that phi(N) = phi_1 - sum of the others)
+func (c *Ceremony) testValue(b int, test int) *big.Int {
+	exponent := new(big.Int).Add(c.candidates[b].p, c.candidates[b].q)
+	if c.id == 1 {
+		exponent.Sub(c.moduli[b], exponent).Add(exponent, big.NewInt(1))
+	}
+	modulus := c.moduli[b]
+	return new(big.Int).Exp(testBase(modulus, test), exponent.Rsh(exponent, 2), modulus)
+}
+
+// Whether g^(phi(N)/4) = +-1 for the test values of all signers at index i: v_1 = +-(v_2 ... v_n)
+func biprime(modulus *big.Int, msgs []DKGMessage, i int) bool {
+	product := big.NewInt(1)
+	for _, msg := range msgs[1:] {
+		product.Mul(product, msg.Values[i]).Mod(product, modulus)
+	}
+	first := new(big.Int).Mod(msgs[0].Values[i], modulus)
+	return first.Cmp(product) == 0 || first.Add(first, product).Cmp(modulus) == 0
+}
+
+// Base of a test of a candidate modulus, of Jacobi symbol 1, which all signers derive alike
+func testBase(modulus *big.Int, test int) *big.Int {
+	for counter := 0; ; counter++ {
+		g := hashOnto(modulus, fmt.Sprintf("test %d %d", test, counter))
+		if big.Jacobi(g, modulus) == 1 {
+			return g
+		}
+	}
+}
+
+//
+// -------------------------------- EXPONENT ----------------------------------
+//
+// Additive share of phi(N): phi_1 = N - p_1 - q_1 + 1, and -(p_i + q_i) for the others
+func (c *Ceremony) totient() *big.Int {
+	phi := new(big.Int).Add(c.chosen.p, c.chosen.q)
+	if c.id == 1 {
+		return phi.Sub(c.modulus, phi).Add(phi, big.NewInt(1))
+	}
+	return phi.Neg(phi)
+}
+
+// Additive share of d = (1 + psi phi(N)) / e, with psi = -phi(N)^-1 mod e, rounded down
+func (c *Ceremony) exponent(msgs []DKGMessage) ([]DKGMessage, error) {
+	e := big.NewInt(THRESHOLDEXPONENT)
+	zeta := new(big.Int)
+	for _, msg := range msgs {
+		if len(msg.Values) != 1 {
+			return nil, fmt.Errorf("totient of signer %d: %w", msg.From, errCeremony)
+		}
+		zeta.Add(zeta, msg.Values[0])
+	}
+	if zeta.Mod(zeta, e).Sign() == 0 { // e divides phi(N): no private exponent
+		return c.newBatch()
+	}
+	psi := zeta.ModInverse(zeta, e)
+	psi.Sub(e, psi)
+
+	c.secret = new(big.Int).Mul(psi, c.totient())
+	if c.id == 1 {
+		c.secret.Add(c.secret, big.NewInt(1))
+	}
+	c.secret.Div(c.secret, e) // Euclidean, which rounds down for a positive divisor
+
+	c.phase = dkgExponent
+	key := &ThresholdKey{Modulus: c.modulus}
+	x := hashOnto(c.modulus, "exponent")
+	return c.messages([]*big.Int{key.exp(x, new(big.Int).Mul(c.secret, e))}, nil)
+}
+
+// The shares of d sum to d - r, with r in [0, n) for the roundings: x^(e (d - r)) x^(e r) = x
+// finds r, which signer 1 adds to its share; then every signer deals its share
+func (c *Ceremony) deal(msgs []DKGMessage) ([]DKGMessage, error) {
+	key := &ThresholdKey{Modulus: c.modulus}
+	x := hashOnto(c.modulus, "exponent")
+	product := big.NewInt(1)
+	for _, msg := range msgs {
+		if len(msg.Values) != 1 || key.unit(msg.Values[0]) == false {
+			return nil, fmt.Errorf("exponent of signer %d: %w", msg.From, errCeremony)
+		}
+		product.Mul(product, msg.Values[0]).Mod(product, c.modulus)
+	}
+	xe := new(big.Int).Exp(x, big.NewInt(THRESHOLDEXPONENT), c.modulus)
+	rounding := -1
+	for r := 0; r < c.n; r++ {
+		if product.Cmp(x) == 0 {
+			rounding = r
+			break
+		}
+		product.Mul(product, xe).Mod(product, c.modulus)
+	}
+	if rounding < 0 {
+		return nil, fmt.Errorf("ceremony: shares of the private exponent do not sum to it")
+	}
+	if c.id == 1 {
+		c.secret.Add(c.secret, big.NewInt(int64(rounding)))
+	}
+
+	// f(X) = d_i + a_1 X + ... + a_(k-1) X^(k-1) over the integers, committed to as v^d_i, v^a_1, ...
+	base := verificationBase(c.modulus)
+	bound := new(big.Int).Lsh(big.NewInt(1), uint(c.modulus.BitLen()+DKGMARGIN))
+	polynomial := []*big.Int{c.secret}
+	for d := 1; d < c.k; d++ {
+		coefficient, err := crand.Int(crand.Reader, bound)
+		if err != nil {
+			return nil, err
+		}
+		polynomial = append(polynomial, coefficient)
+	}
+	commitments := make([]*big.Int, len(polynomial))
+	for d, coefficient := range polynomial {
+		commitments[d] = key.exp(base, coefficient)
+	}
+
+	c.phase = dkgDeal
+	return c.messages(commitments, func(to int) []*big.Int {
+		return []*big.Int{evaluate(polynomial, to, nil)}
+	})
+}
+
+// Checks the shares against the commitments of their dealers, and signs a test digest with the key
+func (c *Ceremony) check(msgs []DKGMessage) ([]DKGMessage, error) {
+	key := &ThresholdKey{
+		Threshold:        c.k,
+		Signers:          c.n,
+		Modulus:          c.modulus,
+		Exponent:         THRESHOLDEXPONENT,
+		VerificationBase: verificationBase(c.modulus),
+		VerificationKeys: make([]*big.Int, c.n)}
+	for i := range key.VerificationKeys {
+		key.VerificationKeys[i] = big.NewInt(1)
+	}
+
+	secret := new(big.Int)
+	for _, msg := range msgs {
+		values, err := c.open(msg, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(msg.Values) != c.k || key.committed(msg.Values, c.id).Cmp(key.exp(key.VerificationBase, values[0])) != 0 {
+			return nil, fmt.Errorf("share of signer %d: %w", msg.From, errInvalidShare)
+		}
+		secret.Add(secret, values[0])
+		for j := 1; j <= c.n; j++ {
+			verificationKey := key.VerificationKeys[j-1]
+			verificationKey.Mul(verificationKey, key.committed(msg.Values, j)).Mod(verificationKey, c.modulus)
+		}
+	}
+	if secret.Sign() <= 0 {
+		return nil, fmt.Errorf("ceremony: share of signer %d: %w", c.id, errInvalidShare)
+	}
+	c.key = key
+	share := &ThresholdShare{Key: key, Signer: c.id, Secret: secret}
+
+	signed, err := share.Sign(key.testDigest())
+	if err != nil {
+		return nil, err
+	}
+	c.share = share
+	c.phase = dkgCheck
+	return c.messages([]*big.Int{signed.Value, signed.Challenge, signed.Response}, nil)
+}
+
+func (c *Ceremony) finish(msgs []DKGMessage) error {
+	msgDigest := c.key.testDigest()
+	shares := make([]SignatureShare, 0, c.n)
+	for _, msg := range msgs {
+		if len(msg.Values) != 3 {
+			return fmt.Errorf("test signature of signer %d: %w", msg.From, errCeremony)
+		}
+		share := SignatureShare{msg.From, msg.Values[0], msg.Values[1], msg.Values[2]}
+		if err := c.key.VerifyShare(msgDigest, share); err != nil {
+			c.share = nil
+			return fmt.Errorf("ceremony: %w", err)
+		}
+		shares = append(shares, share)
+	}
+	if _, err := c.key.Combine(msgDigest, shares); err != nil {
+		c.share = nil
+		return fmt.Errorf("ceremony: %w", err)
+	}
+	c.phase = dkgDone
+	return nil
+}
+
+// v^f(j) of the polynomial committed to by commitments (v^a_0, v^a_1, ...)
+func (key *ThresholdKey) committed(commitments []*big.Int, j int) *big.Int {
+	result := big.NewInt(1)
+	power := big.NewInt(1)
+	for _, commitment := range commitments {
+		result.Mul(result, new(big.Int).Exp(commitment, power, key.Modulus)).Mod(result, key.Modulus)
+		power.Mul(power, big.NewInt(int64(j)))
+	}
+	return result
+}
+
+func (key *ThresholdKey) testDigest() [32]byte {
+	return sha256.Sum256(append([]byte("dkg "), key.Modulus.Bytes()...))
+}
+
+//
+// --------------------------------- HELPERS ----------------------------------
+//
+// Messages of the next round to every signer, with values for all and sealed(to) for signer to only
+func (c *Ceremony) messages(values []*big.Int, sealed func(to int) []*big.Int) ([]DKGMessage, error) {
+	c.round++
+	msgs := make([]DKGMessage, 0, c.n)
+	for to := 1; to <= c.n; to++ {
+		msg := DKGMessage{Ceremony: c.number, Round: c.round, From: c.id, To: to, Values: values}
+		if sealed != nil {
+			var data bytes.Buffer
+			if err := gob.NewEncoder(&data).Encode(sealed(to)); err != nil {
+				return nil, err
+			}
+			msg.Sealed = c.aeads[to].Seal(nil, nonce(c.round, c.id, to), data.Bytes(), nil)
+		}
+		var err error
+		if msg.Signature, err = c.signer.Sign(msg.Digest()); err != nil {
+			return nil, fmt.Errorf("ceremony: %w", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// Sealed values of msg, of which there must be count
+func (c *Ceremony) open(msg DKGMessage, count int) ([]*big.Int, error) {
+	data, err := c.aeads[msg.From].Open(nil, nonce(msg.Round, msg.From, msg.To), msg.Sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("sealed values of signer %d: %w", msg.From, errCeremony)
+	}
+	var values []*big.Int
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil || len(values) != count {
+		return nil, fmt.Errorf("sealed values of signer %d: %w", msg.From, errCeremony)
+	}
+	for _, value := range values {
+		if value == nil {
+			return nil, fmt.Errorf("sealed values of signer %d: %w", msg.From, errCeremony)
+		}
+	}
+	return values, nil
+}
+
+// Every pair of signers seals at most one message per round and direction
+func nonce(round int, from int, to int) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint32(nonce[0:], uint32(round))
+	binary.BigEndian.PutUint32(nonce[4:], uint32(from))
+	binary.BigEndian.PutUint32(nonce[8:], uint32(to))
+	return nonce
+}
+
+func (msg DKGMessage) Digest() [32]byte {
+	hash := sha256.New()
+	header := make([]byte, 32)
+	binary.BigEndian.PutUint64(header[0:], uint64(msg.Ceremony))
+	binary.BigEndian.PutUint64(header[8:], uint64(msg.Round))
+	binary.BigEndian.PutUint64(header[16:], uint64(msg.From))
+	binary.BigEndian.PutUint64(header[24:], uint64(msg.To))
+	hash.Write([]byte("dkg"))
+	hash.Write(header)
+	for _, field := range [][]byte{msg.Ephemeral, msg.Sealed} {
+		hash.Write(binary.BigEndian.AppendUint64(nil, uint64(len(field))))
+		hash.Write(field)
+	}
+	hash.Write(binary.BigEndian.AppendUint64(nil, uint64(len(msg.Values))))
+	for _, value := range msg.Values {
+		data := []byte{0}
+		if value != nil {
+			data = append([]byte{byte(value.Sign() + 1)}, value.Bytes()...)
+		}
+		hash.Write(binary.BigEndian.AppendUint64(nil, uint64(len(data))))
+		hash.Write(data)
+	}
+	var msgDigest [32]byte
+	copy(msgDigest[:], hash.Sum(nil))
+	return msgDigest
+}
+
+// Horner, over the integers if field is nil
+func evaluate(polynomial []*big.Int, x int, field *big.Int) *big.Int {
+	result := new(big.Int)
+	for d := len(polynomial) - 1; d >= 0; d-- {
+		result.Mul(result, big.NewInt(int64(x)))
+		result.Add(result, polynomial[d])
+		if field != nil {
+			result.Mod(result, field)
+		}
+	}
+	return result
+}
+
+// Unit of Z_N that all signers derive alike from a label
+func hashOnto(modulus *big.Int, label string) *big.Int {
+	key := &ThresholdKey{Modulus: modulus}
+	for counter := 0; ; counter++ {
+		value := key.hash(sha256.Sum256([]byte(fmt.Sprintf("dkg %s %d", label, counter))))
+		if key.unit(value) {
+			return value
+		}
+	}
+}
+
+func verificationBase(modulus *big.Int) *big.Int {
+	base := hashOnto(modulus, "base")
+	return base.Mul(base, base).Mod(base, modulus)
+}
+
+// Primes of the field of the shares of candidate moduli, one per size, shared by all ceremonies
+var fields struct {
+	mu     sync.Mutex
+	primes map[int]*big.Int
+}
+
+// Smallest prime above 2^bits, above any candidate modulus of bits bits
+func fieldPrime(bits int) *big.Int {
+	fields.mu.Lock()
+	defer fields.mu.Unlock()
+
+	if fields.primes == nil {
+		fields.primes = make(map[int]*big.Int)
+	}
+	if fields.primes[bits] == nil {
+		prime := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+		for prime.Add(prime, big.NewInt(1)); prime.ProbablyPrime(20) == false; {
+			prime.Add(prime, big.NewInt(2))
+		}
+		fields.primes[bits] = prime
+	}
+	return fields.primes[bits]
+}
+
+// Odd primes below DKGSIEVE, in products of a word each
+var sieve struct {
+	once     sync.Once
+	products []*big.Int
+	primes   [][]uint64
+}
+
+func smallFactor(modulus *big.Int) bool {
+	sieve.once.Do(func() {
+		composite := make([]bool, DKGSIEVE)
+		product := uint64(1)
+		var primes []uint64
+		for i := 3; i < DKGSIEVE; i += 2 {
+			if composite[i] {
+				continue
+			}
+			for j := i * i; j < DKGSIEVE; j += 2 * i {
+				composite[j] = true
+			}
+			if product > (1<<63)/uint64(i) {
+				sieve.products = append(sieve.products, new(big.Int).SetUint64(product))
+				sieve.primes = append(sieve.primes, primes)
+				product, primes = 1, nil
+			}
+			product *= uint64(i)
+			primes = append(primes, uint64(i))
+		}
+		sieve.products = append(sieve.products, new(big.Int).SetUint64(product))
+		sieve.primes = append(sieve.primes, primes)
+	})
+
+	remainder := new(big.Int)
+	for i, product := range sieve.products {
+		r := remainder.Mod(modulus, product).Uint64()
+		for _, prime := range sieve.primes[i] {
+			if r%prime == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
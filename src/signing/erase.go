@@ -0,0 +1,121 @@
+package signing
+
+// Erasure of private keys from memory, i.e. when a server is killed or its key is revoked
+//
+// signer, err := ErasableSigner(key) - Signer of its own copy of key, held in memory
+// signer.(Eraser).Erase()            - Zeroes the key of the signer, which refuses to sign from then on
+// Erase(key)                         - Zeroes the secret values of a private key in place
+//
+// => ErasableSigner() copies the key (through its PKCS #8 encoding, which it zeroes afterwards),
+//    so that erasing the signer never wipes a key its caller still holds, i.e. the key file an
+//    operator restarts the server from, or the keys the tests share
+// => Erase() zeroes the words of the secret integers of RSA keys (the private exponent, the primes
+//    and the CRT values) and ECDSA keys, and the bytes of Ed25519 keys, and drops the precomputed
+//    values of RSA keys; copies the standard library made of them internally while signing are
+//    left to the garbage collector
+// => An erased signer fails every signature with errErased, so that a killed server whose
+//    goroutines keep running (as they do in the tests) cannot sign anything anymore
+// => Signers of keys outside the memory of the server (see remote.go) erase nothing: revoking
+//    them is up to the process, HSM or KMS holding the key
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"math/big"
+	"sync"
+)
+
+type Eraser interface {
+	Erase()
+}
+
+type erasableSigner struct {
+	mu        sync.RWMutex
+	key       crypto.Signer // nil once erased
+	publicKey crypto.PublicKey
+}
+
+var errErased = errors.New("key erased")
+
+func ErasableSigner(key crypto.Signer) (Signer, error) {
+	data, err := MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(data)
+	copied, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return &erasableSigner{key: copied, publicKey: copied.Public()}, nil
+}
+
+func (signer *erasableSigner) Sign(msgDigest [32]byte) ([]byte, error) {
+	signer.mu.RLock()
+	defer signer.mu.RUnlock()
+
+	if signer.key == nil {
+		return nil, errErased
+	}
+	return Sign(signer.key, msgDigest)
+}
+
+func (signer *erasableSigner) Public() crypto.PublicKey {
+	return signer.publicKey
+}
+
+func (signer *erasableSigner) Erase() {
+	signer.mu.Lock()
+	defer signer.mu.Unlock()
+
+	if signer.key != nil {
+		Erase(signer.key)
+		signer.key = nil
+	}
+}
+
+func Erase(key crypto.Signer) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		zeroInt(key.D)
+		for _, prime := range key.Primes {
+			zeroInt(prime)
+		}
+		zeroInt(key.Precomputed.Dp)
+		zeroInt(key.Precomputed.Dq)
+		zeroInt(key.Precomputed.Qinv)
+		for _, crt := range key.Precomputed.CRTValues {
+			zeroInt(crt.Exp)
+			zeroInt(crt.Coeff)
+			zeroInt(crt.R)
+		}
+		key.Precomputed = rsa.PrecomputedValues{}
+	case PSSKey:
+		Erase(key.PrivateKey)
+	case *ecdsa.PrivateKey:
+		zeroInt(key.D)
+	case ed25519.PrivateKey:
+		zeroBytes(key)
+	}
+}
+
+// Zeroes the words of n itself, which setting it to zero would leave in memory
+func zeroInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+	words := n.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	n.SetInt64(0)
+}
+
+func zeroBytes(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
@@ -0,0 +1,168 @@
+package signing
+
+// PEM files of keys and certificates, i.e. those of openssl or of another deployment tool
+//
+// data, err := EncodePrivateKeyPEM(key)        - "PRIVATE KEY" block of the PKCS #8 encoding of key
+// key, err := DecodePrivateKeyPEM(data)        - Private key of the first key block of data
+// data, err := EncodePublicKeyPEM(publicKey)   - "PUBLIC KEY" block of the PKIX encoding of publicKey
+// publicKey, err := DecodePublicKeyPEM(data)   - Public key of the first key block of data
+// EncodeCertificatePEM(der)                    - "CERTIFICATE" block of a DER certificate
+// der, err := DecodeCertificatePEM(data)       - DER certificate of the first certificate block of data
+// ReadPrivateKeyFile(path), ReadPublicKeyFile(path), ReadCertificateFile(path)
+//                                              - Same, of the PEM file at path
+// WritePrivateKeyFile(path, key), WritePublicKeyFile(path, publicKey), WriteCertificateFile(path, der)
+//                                              - Writes the PEM file at path (private keys readable
+//                                                by their owner only)
+//
+// => Keys of every scheme (see signing.go) go into PKCS #8 and PKIX blocks, which name the
+//    algorithm of the key (RSA-PSS keys that of RSA-PSS, see pss.go); decoding also reads the
+//    "RSA PRIVATE KEY" and "RSA PUBLIC KEY" (PKCS #1) and "EC PRIVATE KEY" (SEC 1) blocks that
+//    openssl writes by default
+// => Blocks of other types (i.e. the "EC PARAMETERS" block openssl writes before its EC keys) are
+//    skipped; data without a block of the expected type is an error
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var errNoPEM = errors.New("no PEM block")
+
+// First block of data of one of types
+func decodeBlock(data []byte, types ...string) (*pem.Block, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%w of type %q", errNoPEM, types)
+		}
+		for _, blockType := range types {
+			if block.Type == blockType {
+				return block, nil
+			}
+		}
+	}
+}
+
+func EncodePrivateKeyPEM(key crypto.Signer) ([]byte, error) {
+	data, err := MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: data}), nil
+}
+
+func DecodePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, err := decodeBlock(data, "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	var key crypto.Signer
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		key, err = ParsePrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := SchemeOf(key.Public()); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func EncodePublicKeyPEM(publicKey crypto.PublicKey) ([]byte, error) {
+	data, err := MarshalPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: data}), nil
+}
+
+func DecodePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, err := decodeBlock(data, "PUBLIC KEY", "RSA PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ParsePublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := SchemeOf(publicKey); err != nil {
+		return nil, err
+	}
+	return publicKey, nil
+}
+
+func EncodeCertificatePEM(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func DecodeCertificatePEM(data []byte) ([]byte, error) {
+	block, err := decodeBlock(data, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	return block.Bytes, nil
+}
+
+func ReadPrivateKeyFile(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := DecodePrivateKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+func ReadPublicKeyFile(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := DecodePublicKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return publicKey, nil
+}
+
+func ReadCertificateFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	der, err := DecodeCertificatePEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return der, nil
+}
+
+func WritePrivateKeyFile(path string, key crypto.Signer) error {
+	data, err := EncodePrivateKeyPEM(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func WritePublicKeyFile(path string, publicKey crypto.PublicKey) error {
+	data, err := EncodePublicKeyPEM(publicKey)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func WriteCertificateFile(path string, der []byte) error {
+	return os.WriteFile(path, EncodeCertificatePEM(der), 0644)
+}
@@ -0,0 +1,112 @@
+package signing
+
+// RSA keys that sign with PSS padding instead of PKCS #1 v1.5
+//
+// PSSKey{key}          - Private RSA key of a PSS scheme (RSAPSS2048 or RSAPSS3072)
+// PSSPublicKey{key}    - Its public key, which verifies PSS signatures only
+//
+// => An RSA key signs with one padding only, so the padding is part of the scheme of the key like
+//    the key size: a PSS key is an *rsa.PrivateKey wrapped in PSSKey, and its public key an
+//    *rsa.PublicKey wrapped in PSSPublicKey, so that Verify() picks the padding from the key
+// => Signatures salt with as many bytes as the hash (SHA-256), as TLS 1.3 does
+// => Encoded keys carry the scheme: PKIX and PKCS #8 encodings name the id-RSASSA-PSS algorithm
+//    (RFC 4055, without parameters) instead of rsaEncryption, so key files written by one server
+//    verify alike on every other one; X.509 certificates cannot hold such a key, so they hold the
+//    RSA key and name its scheme in a URI of their subject alternative names (see certificate.go)
+// => A cluster picks PSS by the scheme of its keys, i.e. kvctl -scheme=rsa-pss-2048 init 3; keys
+//    of other schemes still verify as before, so clusters may mix them, i.e. while rotating keys
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+)
+
+type PSSKey struct {
+	*rsa.PrivateKey
+}
+
+type PSSPublicKey struct {
+	*rsa.PublicKey
+}
+
+type pssPublicKeyInfo struct { // SubjectPublicKeyInfo of RFC 5280
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+type pssPrivateKeyInfo struct { // PrivateKeyInfo of PKCS #8
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+var oidRSAPSS = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+
+var pssOptions = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+
+var errNotPSS = errors.New("not an RSA-PSS key")
+
+func (key PSSKey) Public() crypto.PublicKey {
+	return PSSPublicKey{&key.PrivateKey.PublicKey}
+}
+
+func (key PSSKey) Sign(random io.Reader, msgDigest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return rsa.SignPSS(random, key.PrivateKey, crypto.SHA256, msgDigest, pssOptions)
+}
+
+func (publicKey PSSPublicKey) Equal(other crypto.PublicKey) bool {
+	otherKey, ok := other.(PSSPublicKey)
+	return ok && publicKey.PublicKey.Equal(otherKey.PublicKey)
+}
+
+func verifyPSS(publicKey PSSPublicKey, msgDigest [32]byte, signature []byte) error {
+	return rsa.VerifyPSS(publicKey.PublicKey, crypto.SHA256, msgDigest[:], signature, pssOptions)
+}
+
+func marshalPSSPublicKey(publicKey PSSPublicKey) ([]byte, error) {
+	data := x509.MarshalPKCS1PublicKey(publicKey.PublicKey)
+	return asn1.Marshal(pssPublicKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAPSS},
+		PublicKey: asn1.BitString{Bytes: data, BitLength: 8 * len(data)}})
+}
+
+func parsePSSPublicKey(data []byte) (PSSPublicKey, error) {
+	info := pssPublicKeyInfo{}
+	if rest, err := asn1.Unmarshal(data, &info); err != nil || len(rest) > 0 {
+		return PSSPublicKey{}, errNotPSS
+	}
+	if info.Algorithm.Algorithm.Equal(oidRSAPSS) == false {
+		return PSSPublicKey{}, errNotPSS
+	}
+	publicKey, err := x509.ParsePKCS1PublicKey(info.PublicKey.RightAlign())
+	if err != nil {
+		return PSSPublicKey{}, err
+	}
+	return PSSPublicKey{publicKey}, nil
+}
+
+func marshalPSSKey(key PSSKey) ([]byte, error) {
+	return asn1.Marshal(pssPrivateKeyInfo{
+		Algorithm:  pkix.AlgorithmIdentifier{Algorithm: oidRSAPSS},
+		PrivateKey: x509.MarshalPKCS1PrivateKey(key.PrivateKey)})
+}
+
+func parsePSSKey(data []byte) (PSSKey, error) {
+	info := pssPrivateKeyInfo{}
+	if rest, err := asn1.Unmarshal(data, &info); err != nil || len(rest) > 0 {
+		return PSSKey{}, errNotPSS
+	}
+	if info.Algorithm.Algorithm.Equal(oidRSAPSS) == false {
+		return PSSKey{}, errNotPSS
+	}
+	key, err := x509.ParsePKCS1PrivateKey(info.PrivateKey)
+	if err != nil {
+		return PSSKey{}, err
+	}
+	return PSSKey{key}, nil
+}
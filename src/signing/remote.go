@@ -0,0 +1,98 @@
+package signing
+
+// Signers in another process, i.e. one guarding the private key of a server like an HSM would
+//
+// ss, err := ServeSigner(path, signer) - Serves signer on a Unix socket (see network/socket.go)
+// signer, err := DialSigner(path)      - Signer signing through the signer served on path
+//
+// => The private key never leaves the serving process: servers send it the digests to sign and
+//    get their signatures back, one RPC each (within SIGNTIMEOUT)
+// => DialSigner() fetches the public key once, so it fails if nothing serves on path; a signature
+//    fails if the serving process is gone, and the server sends its message unsigned like after
+//    any other signing failure
+// => Anyone who can connect to the socket signs with the key, so the socket must be guarded like
+//    the key itself (i.e. by the permissions of its directory)
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"github.com/csanti/cos518_project/src/network"
+	"time"
+)
+
+const SIGNTIMEOUT = 5 * time.Second // Of the RPCs of a remote signer
+
+type SignerService struct {
+	signer Signer
+}
+
+type SignReply struct {
+	Signature []byte
+	Err       string // Of the signer ("" if the digest was signed)
+}
+
+type PublicKeyReply struct {
+	PublicKey []byte // PKIX encoded
+}
+
+type remoteSigner struct {
+	end       network.Transport
+	path      string
+	publicKey crypto.PublicKey
+}
+
+var errUnreachable = errors.New("signer unreachable")
+
+//
+// ------------------------------- SERVING SIDE -------------------------------
+//
+func ServeSigner(path string, signer Signer) (*network.SocketServer, error) {
+	srv := network.MakeServer()
+	srv.AddService(network.MakeService(&SignerService{signer}))
+	return network.ServeSocket(path, srv)
+}
+
+func (svc *SignerService) Sign(msgDigest [32]byte, reply *SignReply) {
+	signature, err := svc.signer.Sign(msgDigest)
+	if err != nil {
+		reply.Err = err.Error()
+		return
+	}
+	reply.Signature = signature
+}
+
+func (svc *SignerService) PublicKey(args int, reply *PublicKeyReply) {
+	reply.PublicKey, _ = MarshalPublicKey(svc.signer.Public()) // Empty if it cannot be encoded
+}
+
+//
+// ------------------------------- CALLING SIDE -------------------------------
+//
+func DialSigner(path string) (Signer, error) {
+	end := network.MakeSocketEnd(path)
+	reply := PublicKeyReply{}
+	if end.CallTimeout("SignerService.PublicKey", 0, &reply, 0, SIGNTIMEOUT) == false {
+		return nil, fmt.Errorf("signer %s: %w", path, errUnreachable)
+	}
+	publicKey, err := ParsePublicKey(reply.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("signer %s: public key: %w", path, err)
+	}
+	return &remoteSigner{end, path, publicKey}, nil
+}
+
+func (signer *remoteSigner) Sign(msgDigest [32]byte) ([]byte, error) {
+	reply := SignReply{}
+	if signer.end.CallTimeout("SignerService.Sign", msgDigest, &reply, 0, SIGNTIMEOUT) == false {
+		return nil, fmt.Errorf("signer %s: %w", signer.path, errUnreachable)
+	}
+	if reply.Err != "" {
+		return nil, fmt.Errorf("signer %s: %s", signer.path, reply.Err)
+	}
+	return reply.Signature, nil
+}
+
+func (signer *remoteSigner) Public() crypto.PublicKey {
+	return signer.publicKey
+}
@@ -0,0 +1,262 @@
+package signing
+
+// Pairwise session keys of servers, established by an authenticated key exchange
+//
+// sessions := MakeSessions(id)                        - Session keys of server id with its peers
+// offer, err := sessions.Offer(signer, to)            - Offer of a fresh key for the messages to server to
+// answer, reoffer, err := sessions.Answer(signer, offer, publicKey) - Answers the offer of a peer
+// sessions.Complete(offer, answer, publicKey)          - Keeps the key of an answered offer
+// mac, err := sessions.MAC(to, digest)                 - HMAC-SHA256 of a digest for server to
+// sessions.CheckMAC(from, digest, mac)                 - nil if mac is the MAC of digest by server from
+// sessions.Erase()                                     - Zeroes all keys, i.e. of a killed server
+//
+// => Every pair of servers holds two symmetric keys, one per direction: the key of the messages of
+//    i to j comes from an exchange i offered, so that concurrent offers of i and j never race
+// => Offers carry the time they were made, and both servers keep the key of the latest exchange
+//    they completed, whatever the order in which concurrent offers of one server arrive
+// => An exchange is an ephemeral X25519 Diffie-Hellman whose halves are signed by the long-term
+//    keys of both servers (any scheme, see signing.go); the answer signs the offer it answers, so
+//    that neither half can be replayed into another exchange, and the key is derived from the
+//    shared secret and both halves
+// => Both halves name the scheme of the long-term key that signed them (see signing.go), and a
+//    server rejects a half whose scheme is not that of the key it holds for the peer, so that
+//    servers configured with keys of different schemes (i.e. RSA-PSS on one side only) tell why
+//    they cannot exchange keys instead of reporting invalid signatures
+// => Keys live in memory only: a restarted server offers fresh keys to all its peers, and since
+//    it holds none of their keys either, its offers ask them to offer theirs in return; Erase()
+//    leaves the server with no keys, like a restarted one (see erase.go)
+// => MACs are much cheaper than signatures but convince only their receiver, so they fit messages
+//    that never need to be shown to a third server
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Handshake struct { // Offer or answer of a key exchange
+	From      int
+	To        int
+	Ephemeral []byte // X25519 public key of this half of the exchange
+	Keyless   bool   // The offering server holds no key of the messages of the peer
+	Scheme    Scheme // Of the long-term key of From
+	Time      int64  // Unix nanoseconds of the offer (zero for an answer)
+	Signature []byte // By the long-term key of From, of the half and of the offer it answers
+}
+
+type Sessions struct {
+	mu       sync.Mutex
+	id       int
+	pending  map[[32]byte]*ecdh.PrivateKey // Digest of an offer in flight -> its ephemeral key
+	outgoing map[int]sessionKey            // Server ID -> key of the messages to it
+	incoming map[int]sessionKey            // Server ID -> key of its messages
+}
+
+type sessionKey struct {
+	key  []byte
+	time int64 // Time of the offer of the exchange
+}
+
+var errNoSession = errors.New("no session key")
+var errBadHandshake = errors.New("invalid key exchange")
+var errScheme = errors.New("signed with a key of another scheme")
+
+func MakeSessions(id int) *Sessions {
+	sessions := &Sessions{}
+	sessions.id = id
+	sessions.pending = make(map[[32]byte]*ecdh.PrivateKey)
+	sessions.outgoing = make(map[int]sessionKey)
+	sessions.incoming = make(map[int]sessionKey)
+	return sessions
+}
+
+// Digest signed by the server making the half; offerDigest is that of the offer answered (zero for
+// an offer)
+func (half Handshake) digest(offerDigest [32]byte) [32]byte {
+	data := make([]byte, 0, 64+len(half.Ephemeral))
+	data = append(data, "session"...)
+	data = binary.BigEndian.AppendUint64(data, uint64(half.From))
+	data = binary.BigEndian.AppendUint64(data, uint64(half.To))
+	if half.Keyless {
+		data = append(data, 1)
+	} else {
+		data = append(data, 0)
+	}
+	data = binary.BigEndian.AppendUint64(data, uint64(half.Time))
+	data = binary.BigEndian.AppendUint64(data, uint64(half.Scheme))
+	data = append(data, half.Ephemeral...)
+	data = append(data, offerDigest[:]...)
+	return sha256.Sum256(data)
+}
+
+func (sessions *Sessions) Offer(signer Signer, to int) (Handshake, error) {
+	private, err := ecdh.X25519().GenerateKey(crand.Reader)
+	if err != nil {
+		return Handshake{}, fmt.Errorf("session offer to %d: %w", to, err)
+	}
+	scheme, err := SchemeOf(signer.Public())
+	if err != nil {
+		return Handshake{}, fmt.Errorf("session offer to %d: %w", to, err)
+	}
+	sessions.mu.Lock()
+	_, keyed := sessions.incoming[to]
+	sessions.mu.Unlock()
+	offer := Handshake{From: sessions.id, To: to, Ephemeral: private.PublicKey().Bytes(), Keyless: keyed == false,
+		Scheme: scheme, Time: time.Now().UnixNano()}
+	msgDigest := offer.digest([32]byte{})
+	if offer.Signature, err = signer.Sign(msgDigest); err != nil {
+		return Handshake{}, fmt.Errorf("session offer to %d: %w", to, err)
+	}
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	sessions.pending[msgDigest] = private
+	return offer, nil
+}
+
+// Keeps the key of the messages of the offering server unless it completed a later exchange;
+// reoffer tells whether the offering server asks for a key in return
+func (sessions *Sessions) Answer(signer Signer, offer Handshake, publicKey crypto.PublicKey) (Handshake, bool, error) {
+	if err := checkScheme(offer, publicKey); err != nil {
+		return Handshake{}, false, fmt.Errorf("session offer of %d: %w", offer.From, err)
+	}
+	offerDigest := offer.digest([32]byte{})
+	if offer.To != sessions.id || publicKey == nil || Verify(publicKey, offerDigest, offer.Signature) != nil {
+		return Handshake{}, false, fmt.Errorf("session offer of %d: %w", offer.From, errBadHandshake)
+	}
+	scheme, err := SchemeOf(signer.Public())
+	if err != nil {
+		return Handshake{}, false, fmt.Errorf("session answer to %d: %w", offer.From, err)
+	}
+	peerKey, err := ecdh.X25519().NewPublicKey(offer.Ephemeral)
+	if err != nil {
+		return Handshake{}, false, fmt.Errorf("session offer of %d: %w", offer.From, err)
+	}
+
+	private, err := ecdh.X25519().GenerateKey(crand.Reader)
+	if err != nil {
+		return Handshake{}, false, fmt.Errorf("session answer to %d: %w", offer.From, err)
+	}
+	shared, err := private.ECDH(peerKey)
+	if err != nil {
+		return Handshake{}, false, fmt.Errorf("session offer of %d: %w", offer.From, err)
+	}
+	answer := Handshake{From: sessions.id, To: offer.From, Ephemeral: private.PublicKey().Bytes(), Scheme: scheme}
+	answerDigest := answer.digest(offerDigest)
+	if answer.Signature, err = signer.Sign(answerDigest); err != nil {
+		return Handshake{}, false, fmt.Errorf("session answer to %d: %w", offer.From, err)
+	}
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	if current, ok := sessions.incoming[offer.From]; ok && current.time >= offer.Time {
+		return answer, false, nil // Answered, but superseded by a later exchange
+	}
+	sessions.incoming[offer.From] = sessionKey{deriveKey(shared, offerDigest, answerDigest), offer.Time}
+	return answer, offer.Keyless, nil
+}
+
+// Keeps the key of the exchange of offer and its answer unless a later one completed
+func (sessions *Sessions) Complete(offer Handshake, answer Handshake, publicKey crypto.PublicKey) error {
+	if err := checkScheme(answer, publicKey); err != nil {
+		return fmt.Errorf("session answer of %d: %w", answer.From, err)
+	}
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	offerDigest := offer.digest([32]byte{})
+	private, ok := sessions.pending[offerDigest]
+	if ok == false || answer.From != offer.To || answer.To != sessions.id {
+		return fmt.Errorf("session answer of %d: no offer in flight: %w", answer.From, errBadHandshake)
+	}
+	answerDigest := answer.digest(offerDigest)
+	if publicKey == nil || Verify(publicKey, answerDigest, answer.Signature) != nil {
+		return fmt.Errorf("session answer of %d: %w", answer.From, errBadHandshake)
+	}
+	peerKey, err := ecdh.X25519().NewPublicKey(answer.Ephemeral)
+	if err != nil {
+		return fmt.Errorf("session answer of %d: %w", answer.From, err)
+	}
+	shared, err := private.ECDH(peerKey)
+	if err != nil {
+		return fmt.Errorf("session answer of %d: %w", answer.From, err)
+	}
+
+	delete(sessions.pending, offerDigest)
+	if current, ok := sessions.outgoing[answer.From]; ok == false || current.time < offer.Time {
+		sessions.outgoing[answer.From] = sessionKey{deriveKey(shared, offerDigest, answerDigest), offer.Time}
+	}
+	return nil
+}
+
+// Checks that a half was signed with a key of the scheme of publicKey, the key held for its sender
+func checkScheme(half Handshake, publicKey crypto.PublicKey) error {
+	scheme, err := SchemeOf(publicKey)
+	if err != nil {
+		return err
+	}
+	if half.Scheme != scheme {
+		return fmt.Errorf("%v, expecting %v: %w", half.Scheme, scheme, errScheme)
+	}
+	return nil
+}
+
+func (sessions *Sessions) Erase() {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	for _, keys := range []map[int]sessionKey{sessions.outgoing, sessions.incoming} {
+		for server, session := range keys {
+			zeroBytes(session.key)
+			delete(keys, server)
+		}
+	}
+	sessions.pending = make(map[[32]byte]*ecdh.PrivateKey) // Ephemeral keys cannot be zeroed, only dropped
+}
+
+// Key of an exchange, from its shared secret and both of its halves
+func deriveKey(shared []byte, offerDigest [32]byte, answerDigest [32]byte) []byte {
+	mac := hmac.New(sha256.New, shared)
+	mac.Write([]byte("session key"))
+	mac.Write(offerDigest[:])
+	mac.Write(answerDigest[:])
+	return mac.Sum(nil)
+}
+
+func (sessions *Sessions) MAC(to int, msgDigest [32]byte) ([]byte, error) {
+	sessions.mu.Lock()
+	session, ok := sessions.outgoing[to]
+	sessions.mu.Unlock()
+	if ok == false {
+		return nil, fmt.Errorf("MAC for %d: %w", to, errNoSession)
+	}
+
+	mac := hmac.New(sha256.New, session.key)
+	mac.Write(msgDigest[:])
+	return mac.Sum(nil), nil
+}
+
+func (sessions *Sessions) CheckMAC(from int, msgDigest [32]byte, tag []byte) error {
+	sessions.mu.Lock()
+	session, ok := sessions.incoming[from]
+	sessions.mu.Unlock()
+	if ok == false {
+		return fmt.Errorf("MAC of %d: %w", from, errNoSession)
+	}
+
+	mac := hmac.New(sha256.New, session.key)
+	mac.Write(msgDigest[:])
+	if hmac.Equal(mac.Sum(nil), tag) == false {
+		return fmt.Errorf("MAC of %d: %w", from, errInvalid)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package signing
+
+// Signature schemes of XPaxos and PBFT servers
+//
+// scheme, err := ParseScheme(name)     - Scheme of a name, i.e. "rsa-2048" (see Schemes())
+// key, err := scheme.GenerateKey()     - Fresh private key of the scheme
+// Sign(key, digest)                    - Signature of a SHA-256 digest with a private key of any scheme
+// signer := KeySigner(key)             - Signer of a private key held in memory
+// Verify(publicKey, digest, signature) - nil if signature is a valid signature of digest
+// SchemeOf(publicKey)                  - Scheme of a public key
+// MarshalPublicKey(publicKey)          - PKIX encoding of a public key (ParsePublicKey() reads it back)
+// MarshalPrivateKey(key)               - PKCS #8 encoding of a private key (ParsePrivateKey() reads it back)
+//
+// => Private keys are the crypto.Signers of the standard library (*rsa.PrivateKey,
+//    *ecdsa.PrivateKey or ed25519.PrivateKey, or a PSSKey wrapping an RSA key) and public keys their
+//    Public(), so a key carries its scheme and servers need no other configuration to sign with it
+//    or verify with its public key
+// => RSA signs the digest with PKCS #1 v1.5, RSA-PSS with PSS padding (see pss.go), ECDSA with an
+//    ASN.1 signature and Ed25519 signs the digest as its message
+// => DEFAULT (RSA-1024) keeps the key generation and signatures of the tests fast, but is weak:
+//    deployed clusters should use i.e. RSA-3072, ECDSA P-256 or Ed25519
+// => ParsePublicKey() and ParsePrivateKey() also read the PKCS #1 encoding of RSA keys, that of
+//    key files written before keys had a scheme
+// => Servers sign through a Signer, so that their private keys may live outside their memory (an
+//    HSM, a KMS or another process, see remote.go); KeySigner() adapts an in-memory key, which is
+//    what the tests and key files hand the servers, and ErasableSigner() a copy of it that a
+//    killed server wipes (see erase.go)
+// => Threshold keys (see threshold.go) are not schemes of server keys: any k of n servers sign for
+//    one key together, i.e. to certify view changes or the commits of a log entry with one signature
+//    of constant size (threshold RSA, as the standard library has no pairing curve for BLS)
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+type Scheme int
+
+const (
+	RSA1024    Scheme = iota
+	RSA2048    Scheme = iota
+	RSA3072    Scheme = iota
+	ECDSAP256  Scheme = iota
+	ED25519    Scheme = iota
+	RSAPSS2048 Scheme = iota
+	RSAPSS3072 Scheme = iota
+)
+
+const DEFAULT = RSA1024 // Scheme of the keys of tests and experiments unless they set another
+
+var schemeNames = []string{"rsa-1024", "rsa-2048", "rsa-3072", "ecdsa-p256", "ed25519", "rsa-pss-2048", "rsa-pss-3072"}
+
+var errInvalid = errors.New("invalid signature")
+
+// Private key of a server, wherever it lives
+type Signer interface {
+	Sign(msgDigest [32]byte) ([]byte, error)
+	Public() crypto.PublicKey
+}
+
+type keySigner struct {
+	key crypto.Signer
+}
+
+func Schemes() []Scheme {
+	schemes := make([]Scheme, len(schemeNames))
+	for i := range schemes {
+		schemes[i] = Scheme(i)
+	}
+	return schemes
+}
+
+func ParseScheme(name string) (Scheme, error) {
+	for i, schemeName := range schemeNames {
+		if name == schemeName {
+			return Scheme(i), nil
+		}
+	}
+	return DEFAULT, fmt.Errorf("unknown signature scheme %q (expecting one of %v)", name, schemeNames)
+}
+
+func (scheme Scheme) String() string {
+	if scheme < 0 || int(scheme) >= len(schemeNames) {
+		return "unknown"
+	}
+	return schemeNames[scheme]
+}
+
+func (scheme Scheme) GenerateKey() (crypto.Signer, error) {
+	switch scheme {
+	case RSA1024:
+		return rsa.GenerateKey(crand.Reader, 1024)
+	case RSA2048:
+		return rsa.GenerateKey(crand.Reader, 2048)
+	case RSA3072:
+		return rsa.GenerateKey(crand.Reader, 3072)
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	case ED25519:
+		_, key, err := ed25519.GenerateKey(crand.Reader)
+		return key, err
+	case RSAPSS2048, RSAPSS3072:
+		bits := 2048
+		if scheme == RSAPSS3072 {
+			bits = 3072
+		}
+		key, err := rsa.GenerateKey(crand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+		return PSSKey{key}, nil
+	}
+	return nil, fmt.Errorf("unknown signature scheme %d", scheme)
+}
+
+func SchemeOf(publicKey crypto.PublicKey) (Scheme, error) {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		switch publicKey.N.BitLen() {
+		case 1024:
+			return RSA1024, nil
+		case 2048:
+			return RSA2048, nil
+		case 3072:
+			return RSA3072, nil
+		}
+		return DEFAULT, fmt.Errorf("RSA key of unsupported size %d", publicKey.N.BitLen())
+	case *ecdsa.PublicKey:
+		if publicKey.Curve == elliptic.P256() {
+			return ECDSAP256, nil
+		}
+		return DEFAULT, fmt.Errorf("ECDSA key of unsupported curve %s", publicKey.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return ED25519, nil
+	case PSSPublicKey:
+		switch publicKey.N.BitLen() {
+		case 2048:
+			return RSAPSS2048, nil
+		case 3072:
+			return RSAPSS3072, nil
+		}
+		return DEFAULT, fmt.Errorf("RSA-PSS key of unsupported size %d", publicKey.N.BitLen())
+	}
+	return DEFAULT, fmt.Errorf("unsupported public key %T", publicKey)
+}
+
+func Sign(key crypto.Signer, msgDigest [32]byte) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(crand.Reader, msgDigest[:], crypto.Hash(0))
+	}
+	return key.Sign(crand.Reader, msgDigest[:], crypto.SHA256)
+}
+
+func KeySigner(key crypto.Signer) Signer {
+	return keySigner{key}
+}
+
+func (signer keySigner) Sign(msgDigest [32]byte) ([]byte, error) {
+	return Sign(signer.key, msgDigest)
+}
+
+func (signer keySigner) Public() crypto.PublicKey {
+	return signer.key.Public()
+}
+
+func Verify(publicKey crypto.PublicKey, msgDigest [32]byte, signature []byte) error {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, msgDigest[:], signature)
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(publicKey, msgDigest[:], signature) == false {
+			return errInvalid
+		}
+		return nil
+	case ed25519.PublicKey:
+		if ed25519.Verify(publicKey, msgDigest[:], signature) == false {
+			return errInvalid
+		}
+		return nil
+	case PSSPublicKey:
+		return verifyPSS(publicKey, msgDigest, signature)
+	}
+	return fmt.Errorf("unsupported public key %T", publicKey)
+}
+
+func MarshalPublicKey(publicKey crypto.PublicKey) ([]byte, error) {
+	if publicKey, ok := publicKey.(PSSPublicKey); ok {
+		return marshalPSSPublicKey(publicKey)
+	}
+	return x509.MarshalPKIXPublicKey(publicKey)
+}
+
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	publicKey, err := x509.ParsePKIXPublicKey(data)
+	if err != nil {
+		if rsaKey, rsaErr := x509.ParsePKCS1PublicKey(data); rsaErr == nil {
+			return rsaKey, nil
+		}
+		if pssKey, pssErr := parsePSSPublicKey(data); pssErr == nil {
+			return pssKey, nil
+		}
+		return nil, err
+	}
+	return publicKey, nil
+}
+
+func MarshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	if key, ok := key.(PSSKey); ok {
+		return marshalPSSKey(key)
+	}
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(data)
+	if err != nil {
+		if rsaKey, rsaErr := x509.ParsePKCS1PrivateKey(data); rsaErr == nil {
+			return rsaKey, nil
+		}
+		if pssKey, pssErr := parsePSSKey(data); pssErr == nil {
+			return pssKey, nil
+		}
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if ok == false {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,734 @@
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"testing"
+)
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestSchemes(t *testing.T) {
+	fmt.Println("Test: Signing - Sign, Verify and Encode Keys of Every Scheme")
+
+	msgDigest := sha256.Sum256([]byte("prepare"))
+	otherDigest := sha256.Sum256([]byte("commit"))
+	for _, scheme := range Schemes() {
+		if parsed, err := ParseScheme(scheme.String()); err != nil || parsed != scheme {
+			t.Fatalf("Scheme %v parsed as %v (%v)!", scheme, parsed, err)
+		}
+
+		key, err := scheme.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if schemeOf, err := SchemeOf(key.Public()); err != nil || schemeOf != scheme {
+			t.Fatalf("Key of scheme %v has scheme %v (%v)!", scheme, schemeOf, err)
+		}
+
+		signature, err := Sign(key, msgDigest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(key.Public(), msgDigest, signature); err != nil {
+			t.Fatalf("Valid signature of scheme %v rejected: %v!", scheme, err)
+		}
+		if Verify(key.Public(), otherDigest, signature) == nil {
+			t.Fatalf("Signature of scheme %v valid for another digest!", scheme)
+		}
+
+		publicData, err := MarshalPublicKey(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKey, err := ParsePublicKey(publicData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(publicKey, msgDigest, signature); err != nil {
+			t.Fatalf("Parsed public key of scheme %v rejects a valid signature: %v!", scheme, err)
+		}
+
+		privateData, err := MarshalPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsedKey, err := ParsePrivateKey(privateData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signature, err = Sign(parsedKey, msgDigest); err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(key.Public(), msgDigest, signature); err != nil {
+			t.Fatalf("Parsed private key of scheme %v signs invalid signatures: %v!", scheme, err)
+		}
+	}
+
+	if _, err := ParseScheme("rsa-512"); err == nil {
+		t.Fatal("Unknown scheme parsed!")
+	}
+	fmt.Println("... Passed")
+}
+
+func TestPKCS1Keys(t *testing.T) {
+	fmt.Println("Test: Signing - PKCS #1 Keys of Older Key Files")
+
+	key, err := rsa.GenerateKey(crand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedKey, err := ParsePrivateKey(x509.MarshalPKCS1PrivateKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ParsePublicKey(x509.MarshalPKCS1PublicKey(&key.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgDigest := sha256.Sum256([]byte("prepare"))
+	signature, err := Sign(parsedKey, msgDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(publicKey, msgDigest, signature); err != nil {
+		t.Fatalf("Signature of PKCS #1 keys rejected: %v!", err)
+	}
+	if _, err := ParsePublicKey([]byte("not a key")); err == nil {
+		t.Fatal("Invalid public key parsed!")
+	}
+	fmt.Println("... Passed")
+}
+
+func TestPSSKeys(t *testing.T) {
+	fmt.Println("Test: Signing - RSA-PSS Keys Apart From PKCS #1 v1.5 Keys")
+
+	key, err := RSAPSS2048.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pssKey := key.(PSSKey)
+	msgDigest := sha256.Sum256([]byte("prepare"))
+
+	// The same RSA key verifies the signatures of its own padding only
+	pssSignature, err := Sign(pssKey, msgDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1Signature, err := Sign(pssKey.PrivateKey, msgDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Verify(&pssKey.PrivateKey.PublicKey, msgDigest, pssSignature) == nil ||
+		Verify(pssKey.Public(), msgDigest, pkcs1Signature) == nil {
+		t.Fatal("Signature of one RSA padding valid for the other!")
+	}
+
+	// A CA of a PSS key certifies PSS keys as PSS keys
+	ca, err := MakeCA(RSAPSS2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.Root().SignatureAlgorithm != x509.SHA256WithRSAPSS {
+		t.Fatalf("CA of a PSS key signs with %v!", ca.Root().SignatureAlgorithm)
+	}
+	certificate, err := ca.Issue(1, pssKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := VerifyIdentity(ca.Root(), certificate, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheme, _ := SchemeOf(publicKey); scheme != RSAPSS2048 || Verify(publicKey, msgDigest, pssSignature) != nil {
+		t.Fatalf("Certified PSS key came back as a key of %v!", scheme)
+	}
+
+	// Key exchanges name the scheme of their signer, so that a peer holding a key of another
+	// scheme tells why it rejects them
+	other, err := DEFAULT.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, err := MakeSessions(1).Offer(KeySigner(pssKey), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = MakeSessions(2).Answer(KeySigner(other), offer, &pssKey.PrivateKey.PublicKey)
+	if errors.Is(err, errScheme) == false {
+		t.Fatalf("Offer of a PSS key answered for a PKCS #1 v1.5 key: %v!", err)
+	}
+	if _, _, err := MakeSessions(2).Answer(KeySigner(other), offer, pssKey.Public()); err != nil {
+		t.Fatalf("Offer of a PSS key rejected: %v!", err)
+	}
+	fmt.Println("... Passed")
+}
+
+func TestPEM(t *testing.T) {
+	fmt.Println("Test: Signing - PEM Files of Keys and Certificates")
+
+	dir := t.TempDir()
+	msgDigest := sha256.Sum256([]byte("prepare"))
+	for _, scheme := range Schemes() {
+		key, err := scheme.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WritePrivateKeyFile(dir+"/key.pem", key); err != nil {
+			t.Fatal(err)
+		}
+		if err := WritePublicKeyFile(dir+"/pub.pem", key.Public()); err != nil {
+			t.Fatal(err)
+		}
+		privateKey, err := ReadPrivateKeyFile(dir + "/key.pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKey, err := ReadPublicKeyFile(dir + "/pub.pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := Sign(privateKey, msgDigest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if schemeOf, _ := SchemeOf(publicKey); schemeOf != scheme || Verify(publicKey, msgDigest, signature) != nil {
+			t.Fatalf("PEM files of a %v key hold a key of %v!", scheme, schemeOf)
+		}
+	}
+
+	// Blocks openssl writes by default, i.e. a SEC 1 EC key after its parameters
+	ecKey, err := ECDSAP256.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: []byte{6, 8}}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1})...)
+	key, err := DecodePrivateKeyPEM(data)
+	if err != nil || key.Public().(*ecdsa.PublicKey).Equal(ecKey.Public()) == false {
+		t.Fatalf("SEC 1 key not decoded: %v!", err)
+	}
+	rsaKey, err := rsa.GenerateKey(crand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	if _, err := DecodePrivateKeyPEM(data); err != nil {
+		t.Fatalf("PKCS #1 key not decoded: %v!", err)
+	}
+	if _, err := DecodePublicKeyPEM(data); errors.Is(err, errNoPEM) == false {
+		t.Fatalf("Private key block decoded as a public key: %v!", err)
+	}
+	if _, err := DecodePrivateKeyPEM([]byte("not a key")); err == nil {
+		t.Fatal("Invalid PEM data decoded!")
+	}
+
+	ca, err := MakeCA(DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCertificateFile(dir+"/ca.pem", ca.Certificate()); err != nil {
+		t.Fatal(err)
+	}
+	if der, err := ReadCertificateFile(dir + "/ca.pem"); err != nil || bytes.Equal(der, ca.Certificate()) == false {
+		t.Fatalf("Certificate not read back from its PEM file: %v!", err)
+	}
+	fmt.Println("... Passed")
+}
+
+// Whether the secret values of key are all zero
+func erased(key crypto.Signer) bool {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		for _, prime := range key.Primes {
+			if prime.Sign() != 0 {
+				return false
+			}
+		}
+		return key.D.Sign() == 0 && key.Precomputed.Dp == nil
+	case PSSKey:
+		return erased(key.PrivateKey)
+	case *ecdsa.PrivateKey:
+		return key.D.Sign() == 0
+	case ed25519.PrivateKey:
+		return bytes.Equal(key, make([]byte, len(key)))
+	}
+	return false
+}
+
+func TestErase(t *testing.T) {
+	fmt.Println("Test: Signing - Erasable Signers and Key Erasure")
+
+	msgDigest := sha256.Sum256([]byte("prepare"))
+	for _, scheme := range []Scheme{DEFAULT, ECDSAP256, ED25519, RSAPSS2048} {
+		key, err := scheme.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ErasableSigner(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := signer.Sign(msgDigest)
+		if err != nil || Verify(key.Public(), msgDigest, signature) != nil {
+			t.Fatalf("Erasable signer of scheme %v signs invalid signatures: %v!", scheme, err)
+		}
+
+		// The signer erases its own copy of the key only
+		signer.(Eraser).Erase()
+		if _, err := signer.Sign(msgDigest); errors.Is(err, errErased) == false {
+			t.Fatalf("Erased signer of scheme %v still signs: %v!", scheme, err)
+		}
+		if Verify(signer.Public(), msgDigest, signature) != nil {
+			t.Fatalf("Erased signer of scheme %v lost its public key!", scheme)
+		}
+		if erased(key) == true {
+			t.Fatalf("Erased signer of scheme %v wiped the key it copied!", scheme)
+		}
+		if signature, err = Sign(key, msgDigest); err != nil || Verify(key.Public(), msgDigest, signature) != nil {
+			t.Fatalf("Key of scheme %v no longer signs after its copy was erased: %v!", scheme, err)
+		}
+
+		Erase(key)
+		if erased(key) == false {
+			t.Fatalf("Secret values of a key of scheme %v not zeroed!", scheme)
+		}
+	}
+
+	fmt.Println("... Passed")
+}
+
+func TestRemoteSigner(t *testing.T) {
+	fmt.Println("Test: Signing - Signer in Another Process")
+
+	key, err := ED25519.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "1.signer")
+	if _, err := DialSigner(path); err == nil {
+		t.Fatal("Signer dialed while nothing serves it!")
+	}
+
+	socket, err := ServeSigner(path, KeySigner(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := DialSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schemeOf, err := SchemeOf(signer.Public()); err != nil || schemeOf != ED25519 {
+		t.Fatalf("Remote signer has a key of %v (%v)!", schemeOf, err)
+	}
+
+	msgDigest := sha256.Sum256([]byte("prepare"))
+	signature, err := signer.Sign(msgDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(key.Public(), msgDigest, signature); err != nil {
+		t.Fatalf("Remote signature rejected: %v!", err)
+	}
+
+	socket.Close()
+	if _, err := signer.Sign(msgDigest); err == nil {
+		t.Fatal("Signed after the signer process was gone!")
+	}
+	fmt.Println("... Passed")
+}
+
+func TestThresholdSignatures(t *testing.T) {
+	fmt.Println("Test: Signing - Threshold Signatures of Any k of n Signers")
+
+	k, n := 3, 5
+	key, shares, err := DealThreshold(k, n, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgDigest := sha256.Sum256([]byte("view 2"))
+	otherDigest := sha256.Sum256([]byte("view 3"))
+	signed := make([]SignatureShare, n)
+	for i, share := range shares {
+		if signed[i], err = share.Sign(msgDigest); err != nil {
+			t.Fatal(err)
+		}
+		if err := key.VerifyShare(msgDigest, signed[i]); err != nil {
+			t.Fatalf("Valid share of signer %d rejected: %v!", i+1, err)
+		}
+		if key.VerifyShare(otherDigest, signed[i]) == nil {
+			t.Fatalf("Share of signer %d valid for another digest!", i+1)
+		}
+	}
+
+	// Any k signers make the same signature, of the size of the modulus
+	var first []byte
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}} {
+		subsetShares := make([]SignatureShare, 0, k)
+		for _, i := range subset {
+			subsetShares = append(subsetShares, signed[i])
+		}
+		signature, err := key.Combine(msgDigest, subsetShares)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := key.Verify(msgDigest, signature); err != nil {
+			t.Fatalf("Signature of signers %v rejected: %v!", subset, err)
+		}
+		if first != nil && bytes.Equal(first, signature) == false {
+			t.Fatalf("Signers %v made another signature than signers 1, 2 and 3!", subset)
+		}
+		first = signature
+	}
+	if len(first) != 1024/8 {
+		t.Fatalf("Signature of %d bytes instead of %d!", len(first), 1024/8)
+	}
+	if key.Verify(otherDigest, first) == nil {
+		t.Fatal("Threshold signature valid for another digest!")
+	}
+
+	// A share made with another secret is pinned on its signer and does not count
+	forged := signed[3]
+	forged.Value = new(big.Int).Mul(forged.Value, big.NewInt(2))
+	if key.VerifyShare(msgDigest, forged) == nil {
+		t.Fatal("Forged share accepted!")
+	}
+	if _, err := key.Combine(msgDigest, []SignatureShare{signed[0], forged, signed[1]}); err == nil {
+		t.Fatal("Signature combined from k-1 valid shares!")
+	}
+	if _, err := key.Combine(msgDigest, []SignatureShare{signed[0], signed[0], signed[1]}); err == nil {
+		t.Fatal("Signature combined from the same share twice!")
+	}
+	if _, err := key.Combine(msgDigest, []SignatureShare{signed[0], forged, signed[1], signed[4]}); err != nil {
+		t.Fatalf("Signature of k valid shares and a forged one failed: %v!", err)
+	}
+	fmt.Println("... Passed")
+}
+
+// Runs a ceremony of k of n signers in lockstep, with tamper(round, msgs) changing the messages
+// of every round on their way; returns the shares, or the error of the first signer that failed
+func runCeremony(k int, n int, bits int, tamper func(round int, msgs []DKGMessage)) ([]*ThresholdShare, error) {
+	publicKeys := make(map[int]crypto.PublicKey, n)
+	ceremonies := make([]*Ceremony, n+1)
+	inboxes := make([][]DKGMessage, n+1)
+	keys := make([]crypto.Signer, n+1)
+	for i := 1; i <= n; i++ {
+		var err error
+		if keys[i], err = DEFAULT.GenerateKey(); err != nil {
+			return nil, err
+		}
+		publicKeys[i] = keys[i].Public()
+	}
+	for i := 1; i <= n; i++ {
+		var err error
+		if ceremonies[i], err = MakeCeremony(1, i, k, n, bits, KeySigner(keys[i]), publicKeys); err != nil {
+			return nil, err
+		}
+		msgs, err := ceremonies[i].Start()
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			inboxes[msg.To] = append(inboxes[msg.To], msg)
+		}
+	}
+
+	for round := 0; len(inboxes[1]) > 0; round++ {
+		received := inboxes
+		inboxes = make([][]DKGMessage, n+1)
+		for i := 1; i <= n; i++ {
+			if tamper != nil {
+				tamper(round, received[i])
+			}
+			msgs, err := ceremonies[i].Next(received[i])
+			if err != nil {
+				return nil, fmt.Errorf("signer %d: %w", i, err)
+			}
+			for _, msg := range msgs {
+				inboxes[msg.To] = append(inboxes[msg.To], msg)
+			}
+		}
+	}
+
+	shares := make([]*ThresholdShare, n)
+	for i := 1; i <= n; i++ {
+		if shares[i-1] = ceremonies[i].Share(); shares[i-1] == nil {
+			return nil, fmt.Errorf("signer %d holds no share after the ceremony", i)
+		}
+	}
+	return shares, nil
+}
+
+func TestDKG(t *testing.T) {
+	fmt.Println("Test: Signing - Threshold Keys of a Ceremony Without a Dealer")
+
+	k, n, bits := 2, 3, 512
+	shares, err := runCeremony(k, n, bits, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// All signers hold shares of the same key, and any k of them sign for it
+	key := shares[0].Key
+	if key.Threshold != k || key.Signers != n || key.Modulus.BitLen() < bits-2 {
+		t.Fatalf("Ceremony made a key of %d of %d signers of %d bits!", key.Threshold, key.Signers,
+			key.Modulus.BitLen())
+	}
+	msgDigest := sha256.Sum256([]byte("view 2"))
+	signed := make([]SignatureShare, n)
+	for i, share := range shares {
+		if share.Signer != i+1 || share.Key.Modulus.Cmp(key.Modulus) != 0 {
+			t.Fatalf("Signer %d holds a share of another key!", i+1)
+		}
+		if signed[i], err = share.Sign(msgDigest); err != nil {
+			t.Fatal(err)
+		}
+		if err := key.VerifyShare(msgDigest, signed[i]); err != nil {
+			t.Fatalf("Share of signer %d rejected: %v!", i+1, err)
+		}
+	}
+	var first []byte
+	for _, subset := range [][]int{{0, 1}, {1, 2}, {2, 0}} {
+		signature, err := key.Combine(msgDigest, []SignatureShare{signed[subset[0]], signed[subset[1]]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first != nil && bytes.Equal(first, signature) == false {
+			t.Fatalf("Signers %v made another signature than signers 1 and 2!", subset)
+		}
+		first = signature
+	}
+	if _, err := key.Combine(msgDigest, signed[:1]); err == nil {
+		t.Fatal("Signature combined from k-1 shares!")
+	}
+
+	// Messages changed on their way fail the ceremony
+	if _, err := runCeremony(k, n, bits, func(round int, msgs []DKGMessage) {
+		if round == 2 {
+			msgs[1].Values[0] = new(big.Int).Add(msgs[1].Values[0], big.NewInt(1))
+		}
+	}); err == nil {
+		t.Fatal("Ceremony passed with a changed message!")
+	}
+	if _, err := runCeremony(k, n, bits, func(round int, msgs []DKGMessage) {
+		msgs[0] = msgs[1]
+	}); err == nil {
+		t.Fatal("Ceremony passed with the message of one signer twice!")
+	}
+	if _, err := runCeremony(k, n, bits, func(round int, msgs []DKGMessage) {
+		msgs[2].Ceremony++
+	}); err == nil {
+		t.Fatal("Ceremony passed with a message of another ceremony!")
+	}
+	if _, err := MakeCeremony(1, 1, 2, 2, bits, nil, nil); err == nil {
+		t.Fatal("Ceremony of fewer than 2k-1 signers made!")
+	}
+	fmt.Println("... Passed")
+}
+
+func TestSessions(t *testing.T) {
+	fmt.Println("Test: Signing - Session Keys of an Authenticated Key Exchange")
+
+	keys := make([]Signer, 4)
+	for i := 1; i < len(keys); i++ {
+		key, err := DEFAULT.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = KeySigner(key)
+	}
+	exchange := func(from *Sessions, to *Sessions) bool {
+		offer, err := from.Offer(keys[from.id], to.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		answer, reoffer, err := to.Answer(keys[to.id], offer, keys[from.id].Public())
+		if err != nil {
+			t.Fatalf("Offer of %d to %d rejected: %v!", from.id, to.id, err)
+		}
+		if err := from.Complete(offer, answer, keys[to.id].Public()); err != nil {
+			t.Fatalf("Answer of %d to %d rejected: %v!", to.id, from.id, err)
+		}
+		return reoffer
+	}
+	checkSession := func(from *Sessions, to *Sessions) {
+		msgDigest := sha256.Sum256([]byte("prepare"))
+		otherDigest := sha256.Sum256([]byte("commit"))
+		mac, err := from.MAC(to.id, msgDigest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := to.CheckMAC(from.id, msgDigest, mac); err != nil {
+			t.Fatalf("MAC of %d rejected by %d: %v!", from.id, to.id, err)
+		}
+		if to.CheckMAC(from.id, otherDigest, mac) == nil {
+			t.Fatalf("MAC of %d valid for another digest!", from.id)
+		}
+	}
+
+	one, two := MakeSessions(1), MakeSessions(2)
+	if _, err := one.MAC(2, sha256.Sum256([]byte("prepare"))); err == nil {
+		t.Fatal("MAC without a session key!")
+	}
+	if exchange(one, two) == false {
+		t.Fatal("Server without a key of its peer did not ask for one!")
+	}
+	if exchange(two, one) == true {
+		t.Fatal("Server with a key of its peer asked for another one!")
+	}
+	checkSession(one, two)
+	checkSession(two, one)
+
+	// Keys are directional: the key of 1 to 2 does not make MACs of 2
+	msgDigest := sha256.Sum256([]byte("prepare"))
+	mac, _ := one.MAC(2, msgDigest)
+	if one.CheckMAC(2, msgDigest, mac) == nil {
+		t.Fatal("MAC of 1 to 2 accepted as a MAC of 2 to 1!")
+	}
+
+	// An offer signed by another key than that of its sender is rejected
+	three := MakeSessions(3)
+	forged, err := three.Offer(keys[3], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.From = 1
+	if _, _, err := two.Answer(keys[2], forged, keys[1].Public()); err == nil {
+		t.Fatal("Offer of server 3 accepted as one of server 1!")
+	}
+
+	// An answer completes only the offer it answers
+	offer, err := one.Offer(keys[1], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, _, err := two.Answer(keys[2], offer, keys[1].Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := one.Offer(keys[1], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one.Complete(other, answer, keys[2].Public()) == nil {
+		t.Fatal("Answer of another offer accepted!")
+	}
+
+	// Whatever the order in which they arrive, both servers keep the key of the later offer
+	otherAnswer, _, err := two.Answer(keys[2], other, keys[1].Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := two.Answer(keys[2], offer, keys[1].Public()); err != nil {
+		t.Fatal(err)
+	}
+	for _, exchange := range [][2]Handshake{{other, otherAnswer}, {offer, answer}} {
+		if err := one.Complete(exchange[0], exchange[1], keys[2].Public()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkSession(one, two)
+
+	// A restarted server gets fresh keys, and its peer offers its own again
+	restarted := MakeSessions(1)
+	if exchange(restarted, two) == false {
+		t.Fatal("Restarted server did not ask for a key of its peer!")
+	}
+	exchange(two, restarted)
+	checkSession(restarted, two)
+	checkSession(two, restarted)
+	if two.CheckMAC(1, msgDigest, mac) == nil {
+		t.Fatal("MAC of the key of 1 before its restart accepted!")
+	}
+
+	// Erased sessions hold no keys, like those of a restarted server
+	two.Erase()
+	if _, err := two.MAC(1, msgDigest); errors.Is(err, errNoSession) == false {
+		t.Fatalf("Erased sessions still make MACs: %v!", err)
+	}
+	if exchange(two, restarted) == false {
+		t.Fatal("Server with erased sessions did not ask for a key of its peer!")
+	}
+	fmt.Println("... Passed")
+}
+
+func TestCertificates(t *testing.T) {
+	fmt.Println("Test: Signing - Certificates of Server Identities by a CA")
+
+	ca, err := MakeCA(DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := MakeCA(DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scheme := range Schemes() {
+		key, err := scheme.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		certificate, err := ca.Issue(3, key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKey, err := VerifyIdentity(ca.Root(), certificate, 3)
+		if err != nil {
+			t.Fatalf("Certificate of a %v key rejected: %v!", scheme, err)
+		}
+		want, _ := MarshalPublicKey(key.Public())
+		if got, _ := MarshalPublicKey(publicKey); bytes.Equal(got, want) == false {
+			t.Fatalf("Certificate of a %v key holds another key!", scheme)
+		}
+		if _, err := VerifyIdentity(ca.Root(), certificate, 4); err == nil {
+			t.Fatal("Certificate of server 3 accepted for server 4!")
+		}
+		if _, err := VerifyIdentity(other.Root(), certificate, 3); err == nil {
+			t.Fatal("Certificate accepted by the root of another CA!")
+		}
+	}
+
+	// A CA loaded back from its encoding issues certificates its root verifies
+	key, err := ca.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCA(ca.Certificate(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKey, err := DEFAULT.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := loaded.Issue(1, serverKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyIdentity(ca.Root(), certificate, 1); err != nil {
+		t.Fatalf("Certificate of the loaded CA rejected: %v!", err)
+	}
+	if _, err := VerifyIdentity(ca.Root(), certificate[:len(certificate)-1], 1); err == nil {
+		t.Fatal("Truncated certificate accepted!")
+	}
+	fmt.Println("... Passed")
+}
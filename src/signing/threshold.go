@@ -0,0 +1,316 @@
+package signing
+
+// Threshold RSA signatures: any k of n signers sign together for one RSA key (Shoup, "Practical
+// Threshold Signatures", EUROCRYPT 2000)
+//
+// key, shares, err := DealThreshold(k, n, bits) - Public key of k-of-n signatures and the n private shares
+// share, err := shares[i].Sign(digest)          - Signature share of signer i+1 of a SHA-256 digest
+// key.VerifyShare(digest, share)                - nil if share is a valid share of its signer
+// signature, err := key.Combine(digest, shares) - Signature of k valid shares of distinct signers
+// key.Verify(digest, signature)                 - nil if signature is a valid signature of digest
+//
+// => A trusted dealer generates an RSA key from safe primes, deals a share of its private exponent
+//    to each signer (1..n) and forgets the key: no k-1 signers together can sign; without a dealer,
+//    the signers generate the key and their shares in a ceremony instead (see dkg.go)
+// => Signing takes no interaction: every signer signs its share on its own, and anyone holding k
+//    shares of a digest combines them into the same signature whichever k signers made them, an
+//    RSA signature of constant size (that of the modulus) instead of k signatures
+// => Every share carries a non-interactive proof that it was made with the share of its signer
+//    (checked against the signer's verification key), so an invalid share is pinned on its signer
+//    when received instead of spoiling the combination
+// => Digests are hashed onto Z_N (SHA-256 in counter mode), so signatures are not PKCS #1
+//    signatures and only key.Verify() checks them
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const THRESHOLDEXPONENT = 65537 // Public exponent of threshold keys, a prime above any number of signers
+
+type ThresholdKey struct {
+	Threshold        int        // Shares that make a signature (k)
+	Signers          int        // Number of signers (n)
+	Modulus          *big.Int   // N = pq, with p = 2p'+1 and q = 2q'+1 safe primes
+	Exponent         int        // Public exponent e
+	VerificationBase *big.Int   // v, a random square of Z_N
+	VerificationKeys []*big.Int // v^s of the share s of signer i at i-1
+}
+
+type ThresholdShare struct {
+	Key    *ThresholdKey
+	Signer int      // 1..n
+	Secret *big.Int // f(Signer) mod p'q', with f of degree k-1 and f(0) the private exponent
+}
+
+type SignatureShare struct {
+	Signer    int
+	Value     *big.Int // x^(2*delta*s), with x the hashed digest, delta = n! and s the secret of the signer
+	Challenge *big.Int // Proof that Value has the exponent of the signer's verification key
+	Response  *big.Int
+}
+
+var errInvalidShare = errors.New("invalid signature share")
+var errTooFewShares = errors.New("too few valid signature shares")
+
+//
+// --------------------------------- DEALING ----------------------------------
+//
+func DealThreshold(k int, n int, bits int) (*ThresholdKey, []*ThresholdShare, error) {
+	if k < 1 || k > n || n >= THRESHOLDEXPONENT {
+		return nil, nil, fmt.Errorf("threshold key of %d of %d signers", k, n)
+	}
+
+	p, pp, err := safePrime(bits / 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	q, qq, err := safePrime(bits - bits/2)
+	for err == nil && p.Cmp(q) == 0 {
+		q, qq, err = safePrime(bits - bits/2)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	modulus := new(big.Int).Mul(p, q)
+	order := new(big.Int).Mul(pp, qq) // Of the squares of Z_N
+
+	exponent := big.NewInt(THRESHOLDEXPONENT)
+	private := new(big.Int).ModInverse(exponent, order)
+	if private == nil {
+		return nil, nil, fmt.Errorf("threshold key: public exponent divides the order of the squares")
+	}
+
+	coefficients := []*big.Int{private} // f(X) = private + a1 X + ... + a(k-1) X^(k-1)
+	for i := 1; i < k; i++ {
+		coefficient, err := crand.Int(crand.Reader, order)
+		if err != nil {
+			return nil, nil, err
+		}
+		coefficients = append(coefficients, coefficient)
+	}
+
+	base, err := randomSquare(modulus)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := &ThresholdKey{
+		Threshold:        k,
+		Signers:          n,
+		Modulus:          modulus,
+		Exponent:         THRESHOLDEXPONENT,
+		VerificationBase: base,
+		VerificationKeys: make([]*big.Int, n)}
+
+	shares := make([]*ThresholdShare, n)
+	for i := 1; i <= n; i++ {
+		secret := new(big.Int)
+		for j := len(coefficients) - 1; j >= 0; j-- { // Horner
+			secret.Mul(secret, big.NewInt(int64(i)))
+			secret.Add(secret, coefficients[j])
+			secret.Mod(secret, order)
+		}
+		shares[i-1] = &ThresholdShare{Key: key, Signer: i, Secret: secret}
+		key.VerificationKeys[i-1] = new(big.Int).Exp(base, secret, modulus)
+	}
+	return key, shares, nil
+}
+
+// Safe prime p = 2p'+1 of bits bits, and p'
+func safePrime(bits int) (*big.Int, *big.Int, error) {
+	for {
+		pp, err := crand.Prime(crand.Reader, bits-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		p := new(big.Int).Lsh(pp, 1)
+		p.Add(p, big.NewInt(1))
+		if p.ProbablyPrime(20) {
+			return p, pp, nil
+		}
+	}
+}
+
+func randomSquare(modulus *big.Int) (*big.Int, error) {
+	for {
+		r, err := crand.Int(crand.Reader, modulus)
+		if err != nil {
+			return nil, err
+		}
+		if r.Sign() > 0 && new(big.Int).GCD(nil, nil, r, modulus).Cmp(big.NewInt(1)) == 0 {
+			return r.Mul(r, r).Mod(r, modulus), nil
+		}
+	}
+}
+
+//
+// --------------------------------- SIGNING ----------------------------------
+//
+func (share *ThresholdShare) Sign(msgDigest [32]byte) (SignatureShare, error) {
+	key := share.Key
+	x := key.hash(msgDigest)
+	delta := factorial(key.Signers)
+
+	exponent := new(big.Int).Mul(big.NewInt(2), delta)
+	exponent.Mul(exponent, share.Secret)
+	value := new(big.Int).Exp(x, exponent, key.Modulus)
+
+	// Proof that log_v(v_i) = log_x~(value^2), with x~ = x^(4*delta): commitments to a random r,
+	// a challenge c hashing them, and the response s*c + r
+	bound := new(big.Int).Lsh(big.NewInt(1), uint(key.Modulus.BitLen()+2*8*sha256.Size))
+	r, err := crand.Int(crand.Reader, bound)
+	if err != nil {
+		return SignatureShare{}, fmt.Errorf("signature share of signer %d: %w", share.Signer, err)
+	}
+	xt := key.proofBase(x, delta)
+	challenge := key.challenge(xt, key.VerificationKeys[share.Signer-1],
+		new(big.Int).Exp(value, big.NewInt(2), key.Modulus),
+		new(big.Int).Exp(key.VerificationBase, r, key.Modulus), new(big.Int).Exp(xt, r, key.Modulus))
+	response := new(big.Int).Mul(share.Secret, challenge)
+	response.Add(response, r)
+
+	return SignatureShare{share.Signer, value, challenge, response}, nil
+}
+
+func (key *ThresholdKey) VerifyShare(msgDigest [32]byte, share SignatureShare) error {
+	if share.Signer < 1 || share.Signer > key.Signers || share.Value == nil || share.Challenge == nil ||
+		share.Response == nil || share.Response.Sign() < 0 || key.unit(share.Value) == false {
+		return fmt.Errorf("signature share of signer %d: %w", share.Signer, errInvalidShare)
+	}
+
+	x := key.hash(msgDigest)
+	xt := key.proofBase(x, factorial(key.Signers))
+	verificationKey := key.VerificationKeys[share.Signer-1]
+	squared := new(big.Int).Exp(share.Value, big.NewInt(2), key.Modulus)
+	negated := new(big.Int).Neg(share.Challenge)
+
+	// Commitments of the signer: v^z / v_i^c and x~^z / value^(2c)
+	vp := new(big.Int).Exp(key.VerificationBase, share.Response, key.Modulus)
+	vp.Mul(vp, key.exp(verificationKey, negated)).Mod(vp, key.Modulus)
+	xp := new(big.Int).Exp(xt, share.Response, key.Modulus)
+	xp.Mul(xp, key.exp(squared, negated)).Mod(xp, key.Modulus)
+
+	if key.challenge(xt, verificationKey, squared, vp, xp).Cmp(share.Challenge) != 0 {
+		return fmt.Errorf("signature share of signer %d: %w", share.Signer, errInvalidShare)
+	}
+	return nil
+}
+
+// Invalid shares and shares of signers already combined are skipped
+func (key *ThresholdKey) Combine(msgDigest [32]byte, shares []SignatureShare) ([]byte, error) {
+	combined := make([]SignatureShare, 0, key.Threshold)
+	signers := make(map[int]bool)
+	for _, share := range shares {
+		if len(combined) == key.Threshold {
+			break
+		}
+		if signers[share.Signer] == false && key.VerifyShare(msgDigest, share) == nil {
+			combined = append(combined, share)
+			signers[share.Signer] = true
+		}
+	}
+	if len(combined) < key.Threshold {
+		return nil, fmt.Errorf("threshold signature of %d of %d shares: %w", len(combined), key.Threshold,
+			errTooFewShares)
+	}
+
+	// w = prod value_j^(2*lambda_j), with lambda_j = delta times the Lagrange coefficient of j at 0
+	// (an integer), so that w^e = x^(4*delta^2)
+	delta := factorial(key.Signers)
+	w := big.NewInt(1)
+	for _, share := range combined {
+		numerator := new(big.Int).Set(delta)
+		denominator := big.NewInt(1)
+		for _, other := range combined {
+			if other.Signer != share.Signer {
+				numerator.Mul(numerator, big.NewInt(int64(-other.Signer)))
+				denominator.Mul(denominator, big.NewInt(int64(share.Signer-other.Signer)))
+			}
+		}
+		lambda := numerator.Quo(numerator, denominator)
+		w.Mul(w, key.exp(share.Value, lambda.Lsh(lambda, 1))).Mod(w, key.Modulus)
+	}
+
+	// y = w^a x^b with a*4*delta^2 + b*e = 1, so that y^e = x
+	x := key.hash(msgDigest)
+	a, b := new(big.Int), new(big.Int)
+	power := new(big.Int).Mul(delta, delta)
+	power.Lsh(power, 2)
+	new(big.Int).GCD(a, b, power, big.NewInt(int64(key.Exponent)))
+	y := key.exp(w, a)
+	y.Mul(y, key.exp(x, b)).Mod(y, key.Modulus)
+
+	signature := y.FillBytes(make([]byte, (key.Modulus.BitLen()+7)/8))
+	if err := key.Verify(msgDigest, signature); err != nil {
+		return nil, fmt.Errorf("threshold signature: %w", err)
+	}
+	return signature, nil
+}
+
+func (key *ThresholdKey) Verify(msgDigest [32]byte, signature []byte) error {
+	if len(signature) != (key.Modulus.BitLen()+7)/8 {
+		return errInvalid
+	}
+	y := new(big.Int).SetBytes(signature)
+	if y.Cmp(key.Modulus) >= 0 {
+		return errInvalid
+	}
+	if y.Exp(y, big.NewInt(int64(key.Exponent)), key.Modulus).Cmp(key.hash(msgDigest)) != 0 {
+		return errInvalid
+	}
+	return nil
+}
+
+//
+// --------------------------------- HELPERS ----------------------------------
+//
+// Full-domain hash of a digest onto Z_N
+func (key *ThresholdKey) hash(msgDigest [32]byte) *big.Int {
+	data := make([]byte, 0, (key.Modulus.BitLen()+7)/8+2*sha256.Size)
+	for counter := uint32(0); len(data) < cap(data); counter++ {
+		block := make([]byte, 4, 4+len(msgDigest))
+		binary.BigEndian.PutUint32(block, counter)
+		sum := sha256.Sum256(append(block, msgDigest[:]...))
+		data = append(data, sum[:]...)
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(data), key.Modulus)
+}
+
+// x^(4*delta), the base of the proofs of shares of x
+func (key *ThresholdKey) proofBase(x *big.Int, delta *big.Int) *big.Int {
+	return new(big.Int).Exp(x, new(big.Int).Lsh(delta, 2), key.Modulus)
+}
+
+func (key *ThresholdKey) challenge(values ...*big.Int) *big.Int {
+	size := (key.Modulus.BitLen() + 7) / 8
+	hash := sha256.New()
+	for _, value := range append([]*big.Int{key.VerificationBase}, values...) {
+		hash.Write(new(big.Int).Mod(value, key.Modulus).FillBytes(make([]byte, size)))
+	}
+	return new(big.Int).SetBytes(hash.Sum(nil))
+}
+
+// base^exponent mod N, for negative exponents too
+func (key *ThresholdKey) exp(base *big.Int, exponent *big.Int) *big.Int {
+	if exponent.Sign() < 0 {
+		inverse := new(big.Int).ModInverse(base, key.Modulus)
+		if inverse == nil {
+			return big.NewInt(0)
+		}
+		return inverse.Exp(inverse, new(big.Int).Neg(exponent), key.Modulus)
+	}
+	return new(big.Int).Exp(base, exponent, key.Modulus)
+}
+
+func (key *ThresholdKey) unit(value *big.Int) bool {
+	return value.Sign() > 0 && value.Cmp(key.Modulus) < 0 &&
+		new(big.Int).GCD(nil, nil, value, key.Modulus).Cmp(big.NewInt(1)) == 0
+}
+
+func factorial(n int) *big.Int {
+	return new(big.Int).MulRange(1, int64(n))
+}
@@ -0,0 +1,242 @@
+package statemachine
+
+// Replicated state machines driven by the executors of XPaxos and PBFT
+//
+// sm.Apply(op)           - Applies a committed operation and returns its result
+// ApplyAt(sm, op, now)   - Applies it at the time of its log entry if sm is a TimedStateMachine
+// sm.Snapshot()          - Encodes the state (e.g. to persist it)
+// sm.Restore(data)       - Replaces the state with a snapshot
+// sm.Hash()              - Deterministic digest of the state (see Hasher)
+// sm.Scratch()           - Empty state machine of the same kind, i.e. to hash a snapshot
+// Encode(op)             - Operation of a client request as passed to Apply()
+// log := MakeLog()       - A state machine that records the operations it applied
+// MakeHasher()           - Builds a hash from parts in a deterministic order (see sm.Hash())
+// client.Execute(op)     - Consensus: proposes an operation and returns the result of Apply()
+// sm.Read(op)            - Reader: result of a read-only operation on the current state
+// client.Read(op)        - ReadConsensus: reads without proposing (see xpaxos/readindex.go)
+// MakeLocal(sm)          - Consensus of a single replica without a protocol (i.e. to test services)
+// local.SetClock(clock)  - Takes the time of operations from clock (see network)
+//
+// => A service plugs into a protocol with SetStateMachine() on every server (see xpaxos and
+//    pbft) and proposes its operations through the protocol's client; the leader's (XPaxos) or
+//    the replicas' (PBFT) replies carry the result of Apply() back to the client
+// => Servers apply committed requests in log order and every client request at most once, so
+//    Apply() must be deterministic: servers that applied the same operations hold the same state
+// => Hash() must only depend on the state, not on how it is stored (i.e. the iteration order of
+//    maps or the encoding of snapshots), so that servers holding the same state agree on its hash;
+//    checkpoints carry it so that servers can compare their states (see checkpoint.go of xpaxos
+//    and pbft)
+// => A snapshot received from another server is only as good as its hash: the server restores it
+//    on Scratch() and compares the hash there before it replaces its own state, so Scratch() must
+//    share the configuration of the state machine (i.e. the accounts of a bank) but no state, and
+//    restoring on it must have no effect outside of it
+// => Operations that depend on time (i.e. keys that expire) must not take it from the client, nor
+//    from the clock of the server applying them: the leader (XPaxos) or primary (PBFT) assigns its
+//    time to every entry of the log when it prepares it, and the executors pass it to ApplyAt(),
+//    so every server applies an operation at the same time
+// => Apply(), Snapshot(), Restore() and Hash() are called with the server's lock held and must not
+//    block
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"github.com/csanti/cos518_project/src/network"
+	"hash"
+	"strconv"
+	"sync"
+)
+
+type StateMachine interface {
+	Apply(op []byte) []byte
+	Snapshot() []byte
+	Restore(data []byte)
+	Hash() [32]byte
+	Scratch() StateMachine
+}
+
+// State machines whose operations depend on time: ApplyAt() applies a committed operation at the
+// time of its log entry (in nanoseconds), which Apply() does not know
+type TimedStateMachine interface {
+	StateMachine
+	ApplyAt(op []byte, now int64) []byte
+}
+
+// Builds the hash of a state from its parts in a deterministic order, i.e. the entries of a map
+// in the order of their keys; every part is length-prefixed, so that parts cannot run into each
+// other
+type Hasher struct {
+	h hash.Hash
+}
+
+// Client side of a protocol (xpaxos.Client and pbft.Client), so services need not know which
+// protocol replicates them
+type Consensus interface {
+	Execute(op interface{}) ([]byte, bool) // Result of Apply() and whether the request committed
+}
+
+// State machines that can serve read-only operations without applying them (i.e. the reads of a
+// service); Read() must not change the state
+type Reader interface {
+	Read(op []byte) []byte
+}
+
+// Clients of protocols that serve reads from a Reader without committing them (xpaxos.Client)
+type ReadConsensus interface {
+	Consensus
+	Read(op interface{}) ([]byte, bool) // Result of Read() and whether a leader served the read
+}
+
+type Log struct {
+	mu  sync.Mutex
+	ops [][]byte
+}
+
+type Local struct {
+	mu    sync.Mutex
+	sm    StateMachine
+	clock network.Clock
+}
+
+var _ StateMachine = &Log{}
+var _ Consensus = &Local{}
+
+// Operations that are already byte slices (or strings) are passed as is, anything else is
+// gob-encoded (values of custom types travelling in requests must be registered with gob anyway)
+func Encode(op interface{}) []byte {
+	switch v := op.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(op); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// Applies op at time now if sm depends on time, as Apply() does otherwise
+func ApplyAt(sm StateMachine, op []byte, now int64) []byte {
+	if timed, ok := sm.(TimedStateMachine); ok {
+		return timed.ApplyAt(op, now)
+	}
+	return sm.Apply(op)
+}
+
+func MakeHasher() *Hasher {
+	return &Hasher{sha256.New()}
+}
+
+func (hasher *Hasher) Write(part []byte) *Hasher {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(part)))
+	hasher.h.Write(length[:])
+	hasher.h.Write(part)
+	return hasher
+}
+
+func (hasher *Hasher) WriteString(part string) *Hasher {
+	return hasher.Write([]byte(part))
+}
+
+func (hasher *Hasher) WriteInt(part int64) *Hasher {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(part))
+	return hasher.Write(b[:])
+}
+
+func (hasher *Hasher) Sum() [32]byte {
+	var sum [32]byte
+	copy(sum[:], hasher.h.Sum(nil))
+	return sum
+}
+
+//
+// -------------------------------- LOG MACHINE -------------------------------
+//
+func MakeLog() *Log {
+	log := &Log{}
+	log.ops = make([][]byte, 0)
+	return log
+}
+
+// Returns the number of operations applied so far (including op) in decimal
+func (log *Log) Apply(op []byte) []byte {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	log.ops = append(log.ops, op)
+	return []byte(strconv.Itoa(len(log.ops)))
+}
+
+func (log *Log) Snapshot() []byte {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(log.ops)
+	return buf.Bytes()
+}
+
+func (log *Log) Restore(data []byte) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	ops := make([][]byte, 0)
+	if len(data) > 0 {
+		gob.NewDecoder(bytes.NewBuffer(data)).Decode(&ops)
+	}
+	log.ops = ops
+}
+
+func (log *Log) Hash() [32]byte {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	hasher := MakeHasher()
+	for _, op := range log.ops {
+		hasher.Write(op)
+	}
+	return hasher.Sum()
+}
+
+func (log *Log) Scratch() StateMachine {
+	return MakeLog()
+}
+
+// Copy of the operations applied so far
+func (log *Log) Ops() [][]byte {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	return append([][]byte(nil), log.ops...)
+}
+
+//
+// ------------------------------ LOCAL CONSENSUS -----------------------------
+//
+func MakeLocal(sm StateMachine) *Local {
+	return &Local{sm: sm, clock: network.RealClock{}}
+}
+
+func (local *Local) SetClock(clock network.Clock) {
+	local.mu.Lock()
+	defer local.mu.Unlock()
+
+	local.clock = clock
+}
+
+// Applies op to the state machine right away, at the time of the clock: every request commits, in
+// the order of the calls
+func (local *Local) Execute(op interface{}) ([]byte, bool) {
+	local.mu.Lock()
+	defer local.mu.Unlock()
+
+	return ApplyAt(local.sm, Encode(op), local.clock.Now().UnixNano()), true
+}
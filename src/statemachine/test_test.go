@@ -0,0 +1,52 @@
+package statemachine
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestLog(t *testing.T) {
+	fmt.Println("Test: State Machine - Apply, Snapshot and Restore")
+
+	log := MakeLog()
+	for i := 1; i <= 3; i++ {
+		if result := log.Apply(Encode(i)); string(result) != fmt.Sprint(i) {
+			t.Fatalf("Expected result %d, got %s!", i, result)
+		}
+	}
+
+	snapshot := log.Snapshot()
+	log.Apply(Encode("four"))
+
+	restored := MakeLog()
+	restored.Restore(snapshot)
+	if len(restored.Ops()) != 3 || bytes.Equal(restored.Ops()[2], Encode(3)) == false {
+		t.Fatal("Snapshot not restored!")
+	}
+	if string(restored.Apply(Encode("four"))) != "4" {
+		t.Fatal("Restored state machine does not continue from the snapshot!")
+	}
+
+	restored.Restore(nil)
+	if len(restored.Ops()) != 0 {
+		t.Fatal("Empty snapshot not restored!")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	fmt.Println("Test: State Machine - Encoding of Operations")
+
+	if bytes.Equal(Encode([]byte("op")), []byte("op")) == false || bytes.Equal(Encode("op"), []byte("op")) == false {
+		t.Fatal("Byte and string operations not passed as is!")
+	}
+	if Encode(nil) != nil {
+		t.Fatal("Nil operation not encoded as nil!")
+	}
+	if bytes.Equal(Encode(7), Encode(7)) == false || bytes.Equal(Encode(7), Encode(8)) == true {
+		t.Fatal("Encoding of operations is not deterministic!")
+	}
+}
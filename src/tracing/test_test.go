@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestSpans(t *testing.T) {
+	fmt.Println("Test: Tracing - Spans of a Trace")
+
+	var none *Tracer
+	none.Start("0123", "Prepare").End() // Servers without a tracer
+	if err := none.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	exporter := MakeMemoryExporter()
+	tracer := MakeTracer("xpaxos", "1", exporter)
+	tracer.Start("", "Prepare").End() // Requests without a trace ID
+
+	span := tracer.Start("0123", "Prepare")
+	span.Set("seqNum", 3)
+	time.Sleep(time.Millisecond)
+	span.End()
+	if len(exporter.Spans()) != 0 {
+		t.Fatal("Span exported before a flush or a full batch!")
+	}
+	if err := tracer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans exported instead of 1!", len(spans))
+	}
+	if spans[0].TraceId != "0123" || spans[0].Name != "Prepare" || spans[0].Instance != "1" ||
+		len(spans[0].SpanId) != 16 || spans[0].Get("seqNum") != 3 || spans[0].Duration() < time.Millisecond {
+		t.Fatalf("Invalid span %+v!", spans[0])
+	}
+
+	for i := 0; i < BATCHSIZE; i++ {
+		tracer.Start("0123", "Commit").End()
+	}
+	for start := time.Now(); len(exporter.Spans()) < BATCHSIZE+1; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("Full batch of spans not exported!")
+		}
+	}
+}
+
+func TestOTLPExport(t *testing.T) {
+	fmt.Println("Test: Tracing - Export to an OTLP Collector")
+
+	received := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := otlpRequest{}
+		if r.URL.Path != "/v1/traces" || json.NewDecoder(r.Body).Decode(&request) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- request
+	}))
+	defer collector.Close()
+
+	traceId := "0af7651916cd43dd8448eb211c80319c"
+	tracer1 := MakeTracer("xpaxos", "1", MakeOTLPExporter(collector.URL))
+	tracer2 := MakeTracer("xpaxos", "2", nil)
+	span := tracer1.Start(traceId, "XPaxos.Replicate")
+	span.Set("view", 1)
+	span.End()
+	span = tracer2.Start(traceId, "XPaxos.Prepare")
+	span.End()
+
+	spans := append(tracer1.queued, tracer2.queued...)
+	if err := tracer1.exporter.Export(spans); err != nil {
+		t.Fatal(err)
+	}
+	request := <-received
+
+	if len(request.ResourceSpans) != 2 {
+		t.Fatalf("Spans of %d resources instead of 2!", len(request.ResourceSpans))
+	}
+	resource := request.ResourceSpans[1]
+	if resource.Resource.Attributes[0].Value["stringValue"] != "xpaxos" ||
+		resource.Resource.Attributes[1].Value["stringValue"] != "2" {
+		t.Fatalf("Invalid resource %+v!", resource.Resource)
+	}
+	exported := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if exported.TraceId != traceId || exported.Name != "XPaxos.Replicate" ||
+		exported.Attributes[0].Key != "view" || exported.Attributes[0].Value["intValue"] != "1" {
+		t.Fatalf("Invalid span %+v!", exported)
+	}
+
+	failing := MakeTracer("xpaxos", "1", MakeOTLPExporter(collector.URL+"/missing"))
+	failing.Start(traceId, "XPaxos.Commit").End()
+	if err := failing.Flush(); err == nil || failing.Failed() != 1 {
+		t.Fatal("Failed export not reported!")
+	}
+}
@@ -0,0 +1,330 @@
+package tracing
+
+// Spans of the protocol phases of client requests, exportable to an OpenTelemetry collector
+//
+// tracer := MakeTracer(service, instance, exporter) - Tracer of a server, i.e. "xpaxos", "1"
+// span := tracer.Start(traceId, name)                - Starts a span of a request's trace
+// span.Set(key, value)                               - Adds an attribute, i.e. "seqNum", 3
+// span.End()                                         - Ends the span and queues it for export
+// tracer.Flush()                                     - Exports the queued spans
+// tracer.Failed()                                    - Spans that could not be exported
+// MakeMemoryExporter()                               - Keeps the exported spans (i.e. for tests)
+// MakeOTLPExporter(endpoint)                         - Posts the spans to an OTLP/HTTP collector
+//
+// => Spans of a request share its trace ID (see network.NewTraceId()), so a collector links the
+//    phases of the request on every server into one trace, i.e. to break its latency down
+// => A nil tracer starts nil spans, and the methods of a nil span do nothing, so servers without
+//    a tracer (the default) pay nothing; requests without a trace ID have no spans either
+// => Ended spans are queued and exported by a goroutine once BATCHSIZE of them are queued, so
+//    exports never block a server; deployments call Flush() periodically to export the rest
+// => MakeOTLPExporter() posts the JSON encoding of OTLP (ExportTraceServiceRequest) to
+//    <endpoint>/v1/traces, i.e. "http://localhost:4318" for a local collector, with no
+//    dependencies on the OpenTelemetry libraries
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const BATCHSIZE = 512                 // Queued spans exported together
+const EXPORTTIMEOUT = 5 * time.Second // Of a post to an OTLP collector
+
+type Attribute struct {
+	Key   string
+	Value interface{} // int, int64, bool or string (others are exported as strings)
+}
+
+type Span struct {
+	TraceId    string // 32 hex digits
+	SpanId     string // 16 hex digits
+	Name       string // i.e. "XPaxos.Prepare"
+	Service    string // Of the tracer, i.e. "xpaxos"
+	Instance   string // Of the tracer, i.e. the server ID
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes []Attribute
+	tracer     *Tracer
+}
+
+type Exporter interface {
+	Export(spans []Span) error
+}
+
+type Tracer struct {
+	mu       sync.Mutex
+	service  string
+	instance string
+	exporter Exporter
+	queued   []Span
+	failed   int
+}
+
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+//
+// ---------------------------------- TRACER ----------------------------------
+//
+func MakeTracer(service string, instance string, exporter Exporter) *Tracer {
+	tracer := &Tracer{}
+	tracer.service = service
+	tracer.instance = instance
+	tracer.exporter = exporter
+	tracer.queued = make([]Span, 0)
+	return tracer
+}
+
+// Returns nil if tracer is nil or traceId is ""
+func (tracer *Tracer) Start(traceId string, name string) *Span {
+	if tracer == nil || traceId == "" {
+		return nil
+	}
+
+	span := &Span{}
+	span.TraceId = traceId
+	span.SpanId = newSpanId()
+	span.Name = name
+	span.Service = tracer.service
+	span.Instance = tracer.instance
+	span.StartTime = time.Now()
+	span.tracer = tracer
+	return span
+}
+
+func newSpanId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (tracer *Tracer) queue(span Span) {
+	tracer.mu.Lock()
+	tracer.queued = append(tracer.queued, span)
+	if len(tracer.queued) < BATCHSIZE {
+		tracer.mu.Unlock()
+		return
+	}
+	batch := tracer.queued
+	tracer.queued = make([]Span, 0)
+	tracer.mu.Unlock()
+
+	go tracer.export(batch)
+}
+
+func (tracer *Tracer) export(batch []Span) error {
+	err := tracer.exporter.Export(batch)
+	if err != nil {
+		tracer.mu.Lock()
+		tracer.failed += len(batch)
+		tracer.mu.Unlock()
+	}
+	return err
+}
+
+func (tracer *Tracer) Flush() error {
+	if tracer == nil {
+		return nil
+	}
+
+	tracer.mu.Lock()
+	batch := tracer.queued
+	tracer.queued = make([]Span, 0)
+	tracer.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return tracer.export(batch)
+}
+
+func (tracer *Tracer) Failed() int {
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+
+	return tracer.failed
+}
+
+//
+// ----------------------------------- SPANS ----------------------------------
+//
+func (span *Span) Set(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+	span.Attributes = append(span.Attributes, Attribute{key, value})
+}
+
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+	span.EndTime = time.Now()
+	span.tracer.queue(*span)
+}
+
+func (span Span) Duration() time.Duration {
+	return span.EndTime.Sub(span.StartTime)
+}
+
+// Value of the attribute (nil if the span has none)
+func (span Span) Get(key string) interface{} {
+	for _, attribute := range span.Attributes {
+		if attribute.Key == key {
+			return attribute.Value
+		}
+	}
+	return nil
+}
+
+//
+// --------------------------------- EXPORTERS --------------------------------
+//
+func MakeMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{spans: make([]Span, 0)}
+}
+
+func (me *MemoryExporter) Export(spans []Span) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	me.spans = append(me.spans, spans...)
+	return nil
+}
+
+// Exported spans in export order
+func (me *MemoryExporter) Spans() []Span {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	return append([]Span(nil), me.spans...)
+}
+
+func MakeOTLPExporter(endpoint string) *OTLPExporter {
+	oe := &OTLPExporter{}
+	oe.endpoint = endpoint
+	oe.client = &http.Client{Timeout: EXPORTTIMEOUT}
+	return oe
+}
+
+func (oe *OTLPExporter) Export(spans []Span) error {
+	body, err := json.Marshal(encodeOTLP(spans))
+	if err != nil {
+		return err
+	}
+
+	resp, err := oe.client.Post(oe.endpoint+"/v1/traces", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP export to %s: %s", oe.endpoint, resp.Status)
+	}
+	return nil
+}
+
+//
+// ----------------------------------- OTLP -----------------------------------
+//
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	Name              string          `json:"name"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// Spans grouped by the resource (service and instance) of their tracer, in order
+func encodeOTLP(spans []Span) otlpRequest {
+	request := otlpRequest{ResourceSpans: make([]otlpResourceSpans, 0)}
+	resources := make(map[[2]string]int) // Service and instance -> index in request.ResourceSpans
+
+	for _, span := range spans {
+		resource := [2]string{span.Service, span.Instance}
+		i, ok := resources[resource]
+		if ok == false {
+			i = len(request.ResourceSpans)
+			resources[resource] = i
+			request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{[]otlpAttribute{
+					encodeAttribute(Attribute{"service.name", span.Service}),
+					encodeAttribute(Attribute{"service.instance.id", span.Instance})}},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{"cos518_project"}}}})
+		}
+
+		encoded := otlpSpan{
+			TraceId:           span.TraceId,
+			SpanId:            span.SpanId,
+			Name:              span.Name,
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10)}
+		for _, attribute := range span.Attributes {
+			encoded.Attributes = append(encoded.Attributes, encodeAttribute(attribute))
+		}
+
+		scope := &request.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, encoded)
+	}
+	return request
+}
+
+// 64-bit integers are strings in the JSON encoding of OTLP
+func encodeAttribute(attribute Attribute) otlpAttribute {
+	value := make(map[string]interface{})
+	switch v := attribute.Value.(type) {
+	case int:
+		value["intValue"] = strconv.Itoa(v)
+	case int64:
+		value["intValue"] = strconv.FormatInt(v, 10)
+	case bool:
+		value["boolValue"] = v
+	case string:
+		value["stringValue"] = v
+	default:
+		value["stringValue"] = fmt.Sprint(v)
+	}
+	return otlpAttribute{attribute.Key, value}
+}
@@ -0,0 +1,83 @@
+package workload
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestMix(t *testing.T) {
+	fmt.Println("Test: Workload - Read/Write Mix and Key Distributions")
+
+	uniform := MakeGenerator(Config{Size: 16, ReadRatio: 0.25, Keys: 10}, 1)
+	zipfian := MakeGenerator(Config{Size: 16, Keys: 10, Distribution: ZIPFIAN}, 1)
+
+	iters := 10000
+	reads := 0
+	uniformHits := map[string]int{}
+	zipfianHits := map[string]int{}
+	for i := 0; i < iters; i++ {
+		op := uniform.Next()
+		if op.Read == true {
+			reads++
+			if op.Value != nil {
+				t.Fatal("Read carries a value!")
+			}
+		} else if len(op.Value) != 16 {
+			t.Fatal("Write has the wrong size!")
+		}
+		uniformHits[op.Key]++
+		zipfianHits[zipfian.Next().Key]++
+	}
+
+	if reads < iters/5 || reads > iters*3/10 {
+		t.Fatalf("%d of %d operations are reads (expected about 25%%)!", reads, iters)
+	}
+	if len(uniformHits) != 10 || uniformHits["key0"] > iters/5 {
+		t.Fatal("Uniform keys are skewed!")
+	}
+	if zipfianHits["key0"] < iters/4 || zipfianHits["key0"] < 2*zipfianHits["key5"] {
+		t.Fatal("Zipfian keys are not skewed!")
+	}
+
+	again := MakeGenerator(Config{Size: 16, ReadRatio: 0.25, Keys: 10}, 1)
+	first := MakeGenerator(Config{Size: 16, ReadRatio: 0.25, Keys: 10}, 1).Next()
+	if op := again.Next(); op.Key != first.Key || string(op.Value) != string(first.Value) {
+		t.Fatal("Same seed generated different operations!")
+	}
+
+	// A skew <= 1 is clamped to SKEW instead of falling back to uniform keys
+	for _, skew := range []float64{1, 0.5, -2} {
+		clamped := MakeGenerator(Config{Keys: 10, Distribution: ZIPFIAN, Skew: skew}, 1)
+		skewed := MakeGenerator(Config{Keys: 10, Distribution: ZIPFIAN, Skew: SKEW}, 1)
+		for i := 0; i < 100; i++ {
+			if clamped.Next().Key != skewed.Next().Key {
+				t.Fatalf("Skew %v was not clamped to %v!", skew, SKEW)
+			}
+		}
+	}
+}
+
+func TestOpenClosedLoop(t *testing.T) {
+	fmt.Println("Test: Workload - Open and Closed Loop")
+
+	slow := func(i int, op Op) bool {
+		time.Sleep(20 * time.Millisecond)
+		return true
+	}
+
+	// Closed loop: one operation at a time
+	stats := MakeGenerator(Config{}, 1).Run(5, 0, slow)
+	if stats.Ops != 5 || stats.Committed != 5 || stats.Duration < 100*time.Millisecond {
+		t.Fatal("Closed loop did not propose operations one after another!")
+	}
+
+	// Open loop: 500 operations per second arrive regardless of the 20 ms each takes
+	stats = MakeGenerator(Config{Rate: 500}, 1).Run(0, 200*time.Millisecond, slow)
+	if stats.Ops < 50 || stats.Committed != stats.Ops || stats.Latencies.Count() != int64(stats.Ops) {
+		t.Fatalf("Open loop proposed %d operations (expected about 100)!", stats.Ops)
+	}
+}
@@ -0,0 +1,168 @@
+package workload
+
+// Configurable request workloads shared by the benchmarks, soak tests and experiments
+//
+// gen := MakeGenerator(config, seed)  - Creates a generator of operations
+// gen.Next()                          - Next operation (a read or a write of a key)
+// gen.Run(ops, duration, propose)     - Proposes operations through propose (closed or open loop)
+//
+// => Operations are generated from the seed, so two generators with the same config and seed
+//    produce the same sequence of operations
+// => Closed loop (Rate = 0): operations are proposed back to back, each once the previous one
+//    returned; open loop (Rate > 0): operations arrive as a Poisson process of Rate operations per
+//    second and are proposed concurrently, whether or not earlier ones returned
+// => Run() stops after ops operations or, if duration is set, once duration elapses (ignoring
+//    ops), and waits for the operations in flight
+// => A Zipfian skew <= 1 is clamped to SKEW, as rand.NewZipf() only accepts exponents > 1 (and
+//    would otherwise return nil, silently falling back to uniform keys)
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/csanti/cos518_project/src/histogram"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const ( // Key distributions
+	UNIFORM = iota
+	ZIPFIAN = iota
+)
+
+const KEYS = 1000 // Default number of distinct keys
+const SKEW = 1.1  // Default exponent of the Zipfian distribution (must be > 1)
+
+type Config struct {
+	Size         int     // Size of the value of every write in bytes
+	Rate         float64 // Operations per second (0 = closed loop)
+	ReadRatio    float64 // Fraction of operations that are reads (0 = writes only)
+	Keys         int     // Number of distinct keys (0 = KEYS)
+	Distribution int     // UNIFORM or ZIPFIAN
+	Skew         float64 // Exponent of the Zipfian distribution (<= 1 = SKEW)
+}
+
+type Op struct {
+	Read  bool
+	Key   string
+	Value []byte // Nil for reads
+}
+
+type Stats struct {
+	Ops       int // Operations proposed
+	Committed int // Operations propose returned true for
+	Reads     int
+	Duration  time.Duration
+	Latencies *histogram.Histogram
+}
+
+type Generator struct {
+	mu     sync.Mutex
+	config Config
+	r      *rand.Rand
+	zipf   *rand.Zipf
+}
+
+func init() {
+	gob.Register(Op{}) // Operations travel in the interface{} fields of client requests
+}
+
+func MakeGenerator(config Config, seed int64) *Generator {
+	if config.Keys == 0 {
+		config.Keys = KEYS
+	}
+	if config.Skew <= 1 {
+		config.Skew = SKEW
+	}
+
+	gen := &Generator{}
+	gen.config = config
+	gen.r = rand.New(rand.NewSource(seed))
+	if config.Distribution == ZIPFIAN {
+		gen.zipf = rand.NewZipf(gen.r, config.Skew, 1, uint64(config.Keys-1))
+	}
+	return gen
+}
+
+func (gen *Generator) Next() Op {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+
+	op := Op{}
+	op.Read = gen.r.Float64() < gen.config.ReadRatio
+
+	if gen.zipf != nil {
+		op.Key = fmt.Sprintf("key%d", gen.zipf.Uint64())
+	} else {
+		op.Key = fmt.Sprintf("key%d", gen.r.Intn(gen.config.Keys))
+	}
+
+	if op.Read == false {
+		op.Value = make([]byte, gen.config.Size)
+		gen.r.Read(op.Value)
+	}
+	return op
+}
+
+// Time until the next arrival of an open loop workload
+func (gen *Generator) interarrival() time.Duration {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+
+	return time.Duration(gen.r.ExpFloat64() / gen.config.Rate * float64(time.Second))
+}
+
+// propose(i, op) proposes the i-th operation and returns whether it committed
+func (gen *Generator) Run(ops int, duration time.Duration, propose func(i int, op Op) bool) Stats {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	stats := Stats{}
+	stats.Latencies = histogram.MakeHistogram()
+
+	issue := func(i int, op Op) {
+		start := time.Now()
+		ok := propose(i, op)
+		stats.Latencies.Record(time.Since(start))
+
+		mu.Lock()
+		if ok == true {
+			stats.Committed++
+		}
+		mu.Unlock()
+	}
+
+	start := time.Now()
+	next := start
+	for i := 0; ; i++ {
+		if duration > 0 && time.Since(start) >= duration {
+			break
+		} else if duration == 0 && i >= ops {
+			break
+		}
+
+		op := gen.Next()
+		stats.Ops++
+		if op.Read == true {
+			stats.Reads++
+		}
+
+		if gen.config.Rate == 0 {
+			issue(i, op)
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, op Op) {
+			defer wg.Done()
+			issue(i, op)
+		}(i, op)
+
+		next = next.Add(gen.interarrival())
+		time.Sleep(time.Until(next))
+	}
+	wg.Wait()
+	stats.Duration = time.Since(start)
+
+	return stats
+}
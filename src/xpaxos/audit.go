@@ -0,0 +1,154 @@
+package xpaxos
+
+// Audit trail of the signed messages of an XPaxos server, for offline verification
+//
+// xp.SetAuditTrail(enabled)                  - Archives every message the server signs from now on
+// ReadAuditTrail(persister)                  - Returns the archived messages of a persister, oldest first
+// VerifyAuditTrail(entries, keyHistory)      - Checks archived messages against the keys the servers signed with
+// Equivocations(entries)                     - Prepares and commits of different requests at one view and sequence number
+// cfg.setAuditTrail(enabled)                - Archives the signed messages of every server (restarts included)
+//
+// => Every signature the server produces (prepare, commit, suspect, view change, confirmation
+//    messages...) is archived with the ID of the server, in the AUDITLOG log of the persister, so
+//    an auditor holding the public keys can later hold the server to every message it emitted
+//    (XPaxos' fault detection relies on such signed evidence)
+// => Prepares and commits are archived with the fields they sign (type, request digest, view and
+//    sequence number, see signedDigest()), which the auditor digests again, so that two of them
+//    signed for different requests at one view and sequence number prove that the server
+//    equivocated; other messages are archived with the digest they sign (SIGNED)
+// => A server signs with each of its keys in turn (see rotation.go), so the entries of a trail are
+//    verified in order against the keys of their server, oldest first (KeyHistory): an entry
+//    signed with a later key moves on to that key, and no later entry may go back to an older one
+// => The trail is persisted like the prepare and commit logs, so a restarted server appends to
+//    the trail it persisted before crashing; it is never compacted by checkpoints
+// => Tests archive the trails of all servers with -audit, and write them with -persistdir (see
+//    replay.go); the audit command verifies the files offline, i.e.
+//    "go run ./cmd/audit -keys=dir/Test.keys dir/Test-*.persist"
+// => A message that failed to be signed is not archived, since no signature was emitted
+
+import (
+	"crypto"
+	"fmt"
+	"github.com/csanti/cos518_project/src/signing"
+)
+
+const SIGNED = -1 // Type of the archived messages known by the digest they sign only
+
+type AuditEntry struct {
+	Server    int      // Server that signed the message
+	MsgType   int      // PREPARE, COMMIT or SIGNED
+	MsgDigest [32]byte // Of the request of a prepare or commit, otherwise the digest signed
+	View      int      // Of a prepare or commit
+	SeqNum    int      // Of a prepare or commit
+	Time      int64    // Of a prepare or commit
+	Signature []byte
+}
+
+type KeyHistory map[int][]crypto.PublicKey // Server ID -> public keys of the server, oldest first
+
+func (xp *XPaxos) SetAuditTrail(enabled bool) {
+	xp.auditMu.Lock()
+	defer xp.auditMu.Unlock()
+
+	xp.auditing = enabled
+	xp.audited = len(xp.persister.ReadLog(AUDITLOG))
+}
+
+// Called by sign() and signMessage(), with or without xp.mu held
+func (xp *XPaxos) audit(entry AuditEntry) {
+	xp.auditMu.Lock()
+	defer xp.auditMu.Unlock()
+
+	if xp.auditing == false {
+		return
+	}
+	entry.Server = xp.id
+	data, err := encode(entry)
+	if err != nil {
+		xp.log().Infof("Audit: %v", err)
+		return
+	}
+	xp.persister.SaveEntry(AUDITLOG, xp.audited, data)
+	xp.audited++
+}
+
+func ReadAuditTrail(persister *Persister) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+	for i, data := range persister.ReadLog(AUDITLOG) {
+		entry := AuditEntry{}
+		if err := decode(data, &entry); err != nil {
+			return nil, fmt.Errorf("audit trail entry %d: %w", i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Entry of a prepare or commit message signed by the server
+func messageEntry(msg Message, signature []byte) AuditEntry {
+	return AuditEntry{
+		MsgType:   msg.MsgType,
+		MsgDigest: msg.MsgDigest,
+		View:      msg.View,
+		SeqNum:    msg.PrepareSeqNum,
+		Time:      msg.Time,
+		Signature: signature}
+}
+
+// Digest that the signature of the entry covers
+func (entry AuditEntry) signedDigest() [32]byte {
+	if entry.MsgType == SIGNED {
+		return entry.MsgDigest
+	}
+	msg := Message{MsgType: entry.MsgType, MsgDigest: entry.MsgDigest, View: entry.View, PrepareSeqNum: entry.SeqNum,
+		Time: entry.Time}
+	return msg.signedDigest()
+}
+
+// Problems of the archived messages (none if every signature is valid)
+func VerifyAuditTrail(entries []AuditEntry, keyHistory KeyHistory) []string {
+	problems := make([]string, 0)
+	current := make(map[int]int) // Server ID -> its key the last valid entry was signed with
+	for i, entry := range entries {
+		publicKeys := keyHistory[entry.Server]
+		if len(publicKeys) == 0 {
+			problems = append(problems, fmt.Sprintf("entry %d: no public key of server (%d)", i+1, entry.Server))
+			continue
+		}
+
+		msgDigest := entry.signedDigest()
+		key := current[entry.Server]
+		for key < len(publicKeys) && signing.Verify(publicKeys[key], msgDigest, entry.Signature) != nil {
+			key++
+		}
+		if key == len(publicKeys) {
+			problems = append(problems, fmt.Sprintf("entry %d: invalid signature of server (%d)", i+1,
+				entry.Server))
+			continue
+		}
+		current[entry.Server] = key
+	}
+	return problems
+}
+
+// Pairs of archived prepares (or commits) of a server that assign different requests to one view
+// and sequence number; their signatures are verified by VerifyAuditTrail()
+func Equivocations(entries []AuditEntry) []string {
+	type slot struct{ server, msgType, view, seqNum int }
+
+	equivocations := make([]string, 0)
+	first := make(map[slot]int) // Slot -> index of its first entry
+	for i, entry := range entries {
+		if entry.MsgType == SIGNED {
+			continue
+		}
+		s := slot{entry.Server, entry.MsgType, entry.View, entry.SeqNum}
+		if j, ok := first[s]; ok == false {
+			first[s] = i
+		} else if entries[j].MsgDigest != entry.MsgDigest {
+			equivocations = append(equivocations, fmt.Sprintf("entries %d and %d: server (%d) signed two "+
+				"requests at view %d, sequence number %d", j+1, i+1, entry.Server, entry.View, entry.SeqNum))
+		}
+	}
+	return equivocations
+}
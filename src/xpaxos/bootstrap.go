@@ -0,0 +1,220 @@
+package xpaxos
+
+// Deployment of XPaxos servers from a cluster configuration file and PEM keys
+//
+// config, err := ReadClusterConfig(path)    - Configuration of a cluster (JSON, see ClusterConfig)
+// WriteClusterConfig(path, config)          - Writes it back
+// StartReplicaConfig(path, id, signer, sm, k)
+//                                           - Serves XPaxos server id of the cluster configured in
+//                                             path, signing with signer, driving sm (see cluster.go)
+// ConnectClientConfig(path)                 - Client of the cluster configured in path
+//
+// => The configuration lists every server with its ID, the address of its Unix socket and the
+//    PEM file of its public key (or of its certificate, if the configuration names the PEM file of
+//    a CA), so that operators bootstrap a cluster from keys generated elsewhere (i.e. by openssl,
+//    see signing/pem.go) instead of the key file of InitCluster()
+// => Relative paths (addresses and PEM files) are relative to the directory of the configuration
+//    file; InitCluster() writes one ("cluster.json") next to its own files, with the PEM files of
+//    the keys, certificates and CA of the cluster, so both layouts serve the same sockets
+// => Server IDs must be 1..n, each listed once; a server fails to start if its signer's public key
+//    is not the one configured for it, and with a CA, if any certificate does not verify (see
+//    MakeCertified())
+// => A server reads its own private key from a PEM file readable by it only (i.e. "-key" of
+//    cmd/xpaxosd), and nothing else holds it
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/csanti/cos518_project/src/metrics"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const CLUSTERCONFIG = "cluster.json" // Configuration file written by InitCluster()
+
+type ClusterConfig struct {
+	CA      string         `json:"ca,omitempty"` // PEM certificate of the CA ("" = public keys are trusted)
+	Servers []ServerConfig `json:"servers"`
+}
+
+type ServerConfig struct {
+	Id          int    `json:"id"`
+	Address     string `json:"address"`               // Unix socket of the server
+	PublicKey   string `json:"public_key,omitempty"`  // PEM public key (without a CA)
+	Certificate string `json:"certificate,omitempty"` // PEM certificate of its key by the CA
+}
+
+func ReadClusterConfig(path string) (*ClusterConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &ClusterConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// Paths relative to the configuration file
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(filepath.Dir(path), file)
+	}
+	config.CA = resolve(config.CA)
+	listed := make(map[int]bool, len(config.Servers))
+	for i := range config.Servers {
+		server := &config.Servers[i]
+		if server.Id < 1 || server.Id > len(config.Servers) || listed[server.Id] {
+			return nil, fmt.Errorf("%s: server IDs must be 1..%d, each listed once", path, len(config.Servers))
+		}
+		if server.Address == "" || (config.CA == "" && server.PublicKey == "") ||
+			(config.CA != "" && server.Certificate == "") {
+			return nil, fmt.Errorf("%s: server (%d) lacks its address, key or certificate", path, server.Id)
+		}
+		listed[server.Id] = true
+		server.Address = resolve(server.Address)
+		server.PublicKey = resolve(server.PublicKey)
+		server.Certificate = resolve(server.Certificate)
+	}
+	return config, nil
+}
+
+func WriteClusterConfig(path string, config *ClusterConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Socket ends of the client (ID = 0, which has no socket) and all XPaxos servers of config
+func (config *ClusterConfig) ends() []network.Transport {
+	ends := make([]network.Transport, len(config.Servers)+1)
+	ends[CLIENT] = network.MakeSocketEnd("")
+	for _, server := range config.Servers {
+		ends[server.Id] = network.MakeSocketEnd(server.Address)
+	}
+	return ends
+}
+
+func (config *ClusterConfig) address(id int) string {
+	for _, server := range config.Servers {
+		if server.Id == id {
+			return server.Address
+		}
+	}
+	return ""
+}
+
+// Writes the PEM files of the key file, certificates and CA of the cluster in dir, and a
+// configuration of the cluster naming them (see InitCluster())
+func writeClusterPEM(dir string, ca *signing.CA) error {
+	privateKeys, publicKeys, err := readClusterKeys(dir)
+	if err != nil {
+		return err
+	}
+	_, certificates, err := readClusterCertificates(dir)
+	if err != nil {
+		return err
+	}
+	if err := signing.WriteCertificateFile(dir+"/ca.pem", ca.Certificate()); err != nil {
+		return err
+	}
+
+	config := &ClusterConfig{CA: "ca.pem", Servers: make([]ServerConfig, 0, len(publicKeys))}
+	for i := 1; i <= len(publicKeys); i++ {
+		server := ServerConfig{Id: i, Address: filepath.Base(socketPath(dir, i)),
+			PublicKey: fmt.Sprintf("%d.pub.pem", i), Certificate: fmt.Sprintf("%d.cert.pem", i)}
+		if err := signing.WritePrivateKeyFile(fmt.Sprintf("%s/%d.key.pem", dir, i), privateKeys[i]); err != nil {
+			return err
+		}
+		if err := signing.WritePublicKeyFile(dir+"/"+server.PublicKey, publicKeys[i]); err != nil {
+			return err
+		}
+		if err := signing.WriteCertificateFile(dir+"/"+server.Certificate, certificates[i]); err != nil {
+			return err
+		}
+		config.Servers = append(config.Servers, server)
+	}
+	return WriteClusterConfig(dir+"/"+CLUSTERCONFIG, config)
+}
+
+// Public keys of the PEM files of the servers of config
+func (config *ClusterConfig) publicKeys() (map[int]crypto.PublicKey, error) {
+	publicKeys := make(map[int]crypto.PublicKey, len(config.Servers))
+	for _, server := range config.Servers {
+		publicKey, err := signing.ReadPublicKeyFile(server.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("key of server (%d): %v", server.Id, err)
+		}
+		publicKeys[server.Id] = publicKey
+	}
+	return publicKeys, nil
+}
+
+func StartReplicaConfig(path string, id int, signer signing.Signer, sm statemachine.StateMachine,
+	interval int) (*XPaxos, *network.SocketServer, error) {
+	config, err := ReadClusterConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	address := config.address(id)
+	if address == "" {
+		return nil, nil, fmt.Errorf("no server (%d) in %s", id, path)
+	}
+
+	reg := metrics.MakeRegistry("server", strconv.Itoa(id))
+	ends := timedEnds(config.ends(), reg)
+	var xp *XPaxos
+	if config.CA != "" {
+		der, err := signing.ReadCertificateFile(config.CA)
+		if err != nil {
+			return nil, nil, err
+		}
+		root, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, nil, fmt.Errorf("certificate of the CA: %v", err)
+		}
+		certificates := make(map[int][]byte, len(config.Servers))
+		for _, server := range config.Servers {
+			if certificates[server.Id], err = signing.ReadCertificateFile(server.Certificate); err != nil {
+				return nil, nil, err
+			}
+		}
+		if xp, err = MakeCertified(ends, id, MakePersister(), signer, root, certificates); err != nil {
+			return nil, nil, fmt.Errorf("%v in %s", err, path)
+		}
+	} else {
+		publicKeys, err := config.publicKeys()
+		if err != nil {
+			return nil, nil, err
+		}
+		ownKey, _ := signing.MarshalPublicKey(publicKeys[id])
+		signerKey, err := signing.MarshalPublicKey(signer.Public())
+		if err != nil || bytes.Equal(ownKey, signerKey) == false {
+			return nil, nil, fmt.Errorf("signer of server (%d) does not hold the key configured in %s", id, path)
+		}
+		xp = Make(ends, id, MakePersister(), signer, publicKeys)
+	}
+	return serveReplica(xp, reg, address, sm, interval)
+}
+
+func ConnectClientConfig(path string) (*Client, error) {
+	config, err := ReadClusterConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	client := MakeClient(config.ends())
+	client.timestamp = int(time.Now().UnixNano())
+	return client, nil
+}
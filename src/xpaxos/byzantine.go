@@ -0,0 +1,91 @@
+package xpaxos
+
+// Byzantine behavior of XPaxos servers
+//
+// cfg.setByzantine(server, strategy) - Makes an XPaxos server follow a Byzantine strategy
+//
+// A Byzantine server tampers with the common case messages (prepare and commit) it sends; its RPC
+// handlers stay correct, so it still suspects a view when it receives an invalid message
+//
+// => HONEST           - Follow the protocol
+// => CORRUPTSIGNATURE - Reshuffle the bytes of the signature of every message
+// => DROP             - Silently drop every message (the sender sees the RPC fail)
+// => EQUIVOCATE       - Send every server a different (correctly signed) request or digest
+// => LIEVIEW          - Claim to be one view ahead of the current view
+//
+// => At most t = (n-2)/2 XPaxos servers may be Byzantine at the same time (the XFT fault bound)
+
+import (
+	"math/rand"
+)
+
+const ( // Byzantine strategies
+	HONEST           = iota
+	CORRUPTSIGNATURE = iota
+	DROP             = iota
+	EQUIVOCATE       = iota
+	LIEVIEW          = iota
+)
+
+// Returns the prepare entry to send to server and whether to send it at all
+func (xp *XPaxos) tamperPrepare(server int, prepareEntry PrepareLogEntry) (PrepareLogEntry, bool) {
+	xp.mu.Lock()
+	strategy := xp.byzantine
+	xp.mu.Unlock()
+
+	switch strategy {
+	case CORRUPTSIGNATURE:
+		prepareEntry.Msg0.Signature = shuffle(prepareEntry.Msg0.Signature)
+	case DROP:
+		return prepareEntry, false
+	case EQUIVOCATE:
+		prepareEntry.Request.Operation = server // A different operation for every server
+		prepareEntry.Msg0.MsgDigest = digest(prepareEntry.Request)
+		prepareEntry.Msg0.Signature = xp.signMessage(prepareEntry.Msg0)
+	case LIEVIEW:
+		prepareEntry.Msg0.View++
+	}
+
+	return prepareEntry, true
+}
+
+// Returns the commit message to send to server and whether to send it at all
+func (xp *XPaxos) tamperCommit(server int, msg Message) (Message, bool) {
+	xp.mu.Lock()
+	strategy := xp.byzantine
+	xp.mu.Unlock()
+
+	switch strategy {
+	case CORRUPTSIGNATURE:
+		msg.Signature = shuffle(msg.Signature)
+	case DROP:
+		return msg, false
+	case EQUIVOCATE:
+		msg.MsgDigest = digest(server) // A different digest for every server
+		msg.Signature = xp.signMessage(msg)
+	case LIEVIEW:
+		msg.View++
+	}
+
+	return msg, true
+}
+
+// Copy of a signature with its bytes reshuffled (by a permutation derived from the signature, so
+// that runs with the same seed tamper with messages in the same way)
+func shuffle(signature []byte) []byte {
+	shuffled := make([]byte, len(signature))
+	copy(shuffled, signature)
+
+	var seed int64
+	for _, b := range signature {
+		seed = seed*31 + int64(b)
+	}
+	r := rand.New(rand.NewSource(seed))
+
+	for i := len(shuffled) - 1; i > 0; i-- {
+		j := r.Intn(i + 1)
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+
+	return shuffled
+}
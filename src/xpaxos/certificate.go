@@ -0,0 +1,96 @@
+package xpaxos
+
+// Certificate-based identities of XPaxos servers (see signing/certificate.go)
+//
+// xp, err := MakeCertified(replicas, id, persister, signer, root, certificates)
+//                                       - Make() with the public keys of certificates issued by root
+// VerifyCertificates(root, certificates) - Public keys of valid certificates, by server ID
+// cfg.certificates()                    - Certificates of the current keys of every server (tests)
+// cfg.setPKI(enabled)                   - Makes every server check the certificates of key changes,
+//                                         and restarted servers start from certificates
+//
+// => certificates maps the ID of every server to the DER certificate of its key; a server starts
+//    only if the CA root issued every certificate to the server of its ID and its signer holds the
+//    key of its own certificate, so that no raw public key is taken on trust
+// => A server made by MakeCertified() keeps root: a key change (see rotation.go) must then carry a
+//    certificate of the new key issued by the same CA to the rotating server, or every server
+//    ignores the change alike
+// => Servers made by Make() trust the public keys they are handed; -pki makes every test start its
+//    servers from certificates of a CA of the tests, and clusters made by InitCluster() always do
+//    (see cluster.go)
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"sync"
+)
+
+func MakeCertified(replicas []network.Transport, id int, persister *Persister, signer signing.Signer,
+	root *x509.Certificate, certificates map[int][]byte) (*XPaxos, error) {
+	publicKeys, err := VerifyCertificates(root, certificates)
+	if err != nil {
+		return nil, err
+	}
+	ownKey, _ := signing.MarshalPublicKey(publicKeys[id])
+	signerKey, err := signing.MarshalPublicKey(signer.Public())
+	if err != nil || len(ownKey) == 0 || bytes.Equal(ownKey, signerKey) == false {
+		return nil, fmt.Errorf("signer of server (%d) does not hold the key of its certificate", id)
+	}
+
+	xp := Make(replicas, id, persister, signer, publicKeys)
+	xp.keyMu.Lock()
+	xp.root = root
+	xp.keyMu.Unlock()
+	return xp, nil
+}
+
+func VerifyCertificates(root *x509.Certificate, certificates map[int][]byte) (map[int]crypto.PublicKey, error) {
+	publicKeys := make(map[int]crypto.PublicKey, len(certificates))
+	for server, certificate := range certificates {
+		publicKey, err := signing.VerifyIdentity(root, certificate, server)
+		if err != nil {
+			return nil, err
+		}
+		publicKeys[server] = publicKey
+	}
+	return publicKeys, nil
+}
+
+// Checks the certificate of the new key of a key change, if the server has a CA; must be called
+// with xp.keyMu held
+func (xp *XPaxos) checkKeyCertificate(change KeyChange) error {
+	if xp.root == nil {
+		return nil
+	}
+	publicKey, err := signing.VerifyIdentity(xp.root, change.Certificate, change.Server)
+	if err != nil {
+		return err
+	}
+	certified, _ := signing.MarshalPublicKey(publicKey)
+	if bytes.Equal(certified, change.PublicKey) == false {
+		return fmt.Errorf("certificate of server %d: not of the new key", change.Server)
+	}
+	return nil
+}
+
+// CA of the tests, shared by the configs of all tests like their keys (see pooledKeys())
+var testCA struct {
+	mu sync.Mutex
+	ca *signing.CA
+}
+
+func pooledCA() *signing.CA {
+	testCA.mu.Lock()
+	defer testCA.mu.Unlock()
+
+	if testCA.ca == nil {
+		ca, err := signing.MakeCA(signing.DEFAULT)
+		checkError(err)
+		testCA.ca = ca
+	}
+	return testCA.ca
+}
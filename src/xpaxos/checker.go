@@ -0,0 +1,179 @@
+package xpaxos
+
+// Invariant checker running concurrently with the tests of the test harness
+//
+// chk := cfg.startChecker(interval) - Snapshots all XPaxos servers every interval (see makeConfig)
+// chk.violation()                   - First invariant violation found ("" if none)
+// chk.stop()                        - Stops snapshotting (after one last check)
+// chk.setArchiving(false)           - Stops archiving the entries servers drop (i.e. in the soak
+//                                     test, whose memory must not grow with every request)
+//
+// Every snapshot is checked against the following invariants:
+// => Monotonicity: the executeSeqNum of a server never decreases (a restarted server is a new
+//    instance and starts over from its persisted state)
+// => Agreement: the executed prefixes of the commit logs of all servers are consistent (one is a
+//    prefix of the other), compared by client ID and timestamp
+// => Leadership: at most one server acts as the leader of each view
+// => Checkpoints: every checkpoint taken or adopted at the same sequence number has the same state
+//    hash and the same head of its hash chain on every server (checked as the checkpoints are
+//    taken, see checkpoint.go)
+// => Self-checks: no server found a violation of the invariants of its own state (with -selfcheck,
+//    see selfcheck.go)
+//
+// => The checker cannot fail the test from its own goroutine, so cfg.propose() and cfg.cleanup()
+//    fail the test as soon as a violation was found (see cfg.checkInvariants())
+// => Unlike checkAgreement() on cleanup, requests are not compared by digest to keep snapshots cheap
+// => Servers drop the entries below their stable checkpoint (see checkpoint.go), so the checker
+//    archives the first copy of every dropped entry, from which cfg.fullCommitLog() rebuilds the
+//    commit logs the checks on cleanup compare
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const CHECKINTERVAL = 50 * time.Millisecond // Interval between snapshots of the invariant checker
+
+type checkedRequest struct {
+	clientId  int
+	timestamp int
+}
+
+type checker struct {
+	mu        sync.Mutex
+	cfg       *config
+	interval  time.Duration
+	executed  map[*XPaxos]int  // Last executeSeqNum of each server instance
+	reference []checkedRequest // Longest executed prefix of a commit log seen so far
+	hashes    map[int][32]byte // Sequence number -> state hash of the first checkpoint taken at it
+	chains    map[int][32]byte // Sequence number -> head of the hash chain of that checkpoint
+	hashedBy  map[int]int      // Sequence number -> server that took that checkpoint
+	archive   []CommitLogEntry // Entries dropped below a stable checkpoint, from the first one on
+	archiving bool             // Dropped entries are archived
+	first     string           // First invariant violation
+	done      chan bool
+	stopped   chan bool
+}
+
+func (chk *checker) stop() {
+	chk.done <- true
+	<-chk.stopped
+}
+
+func (chk *checker) setArchiving(enabled bool) {
+	chk.mu.Lock()
+	defer chk.mu.Unlock()
+
+	chk.archiving = enabled
+}
+
+func (chk *checker) violation() string {
+	chk.mu.Lock()
+	defer chk.mu.Unlock()
+
+	return chk.first
+}
+
+func (chk *checker) fail(format string, a ...interface{}) {
+	chk.mu.Lock()
+	defer chk.mu.Unlock()
+
+	if chk.first == "" {
+		chk.first = fmt.Sprintf(format, a...)
+		iPrintf("Checker: %s\n", chk.first)
+	}
+}
+
+func (chk *checker) check() {
+	leaders := make(map[int]int) // View -> server acting as its leader
+	running := make(map[*XPaxos]bool)
+
+	for i := 1; i < chk.cfg.n; i++ {
+		chk.cfg.mu.Lock()
+		xp := chk.cfg.xpServers[i]
+		chk.cfg.mu.Unlock()
+
+		if xp == nil {
+			continue // Crashed
+		}
+
+		xp.mu.Lock()
+		view := xp.view
+		isLeader := xp.id == xp.getLeader()
+		xp.mu.Unlock()
+
+		commitLog, first, executed := chk.cfg.fullCommitLog(xp)
+
+		running[xp] = true
+		if last, ok := chk.executed[xp]; ok && executed < last {
+			chk.fail("Server %d's execute sequence number decreased from %d to %d!", i, last, executed)
+		}
+		chk.executed[xp] = executed
+
+		if leader, ok := leaders[view]; ok && isLeader {
+			chk.fail("Servers %d and %d both lead view %d!", leader, i, view)
+		} else if isLeader {
+			leaders[view] = i
+		}
+
+		if violations := xp.Violations(); len(violations) > 0 { // Found by its self-check (see selfcheck.go)
+			chk.fail("Server %d violated an invariant: %s!", i, violations[0])
+		}
+
+		if executed > first+len(commitLog) {
+			executed = first + len(commitLog)
+		}
+
+		for j := first; j < executed && j <= len(chk.reference); j++ {
+			request := checkedRequest{commitLog[j-first].Request.ClientId, commitLog[j-first].Request.Timestamp}
+			if j == len(chk.reference) {
+				chk.reference = append(chk.reference, request)
+			} else if chk.reference[j] != request {
+				chk.fail("Server %d executed (client %d, timestamp %d) at sequence number %d instead of "+
+					"(client %d, timestamp %d)!", i, request.clientId, request.timestamp, j+1,
+					chk.reference[j].clientId, chk.reference[j].timestamp)
+				break
+			}
+		}
+	}
+
+	for xp := range chk.executed { // Crashed instances, which would stay reachable otherwise
+		if running[xp] == false {
+			delete(chk.executed, xp)
+		}
+	}
+}
+
+// Called by server with every checkpoint it takes or adopts (with its lock held)
+func (chk *checker) checkpointed(server int, checkpoint Checkpoint) {
+	chk.mu.Lock()
+	hash, ok := chk.hashes[checkpoint.SeqNum]
+	chain := chk.chains[checkpoint.SeqNum]
+	if ok == false {
+		chk.hashes[checkpoint.SeqNum] = checkpoint.Hash
+		chk.chains[checkpoint.SeqNum] = checkpoint.Chain
+		chk.hashedBy[checkpoint.SeqNum] = server
+	}
+	other := chk.hashedBy[checkpoint.SeqNum]
+	chk.mu.Unlock()
+
+	if ok && hash != checkpoint.Hash {
+		chk.fail("Servers %d and %d hold different states at checkpoint %d!", other, server, checkpoint.SeqNum)
+	}
+	if ok && chain != checkpoint.Chain {
+		chk.fail("Servers %d and %d hold different histories at checkpoint %d!", other, server, checkpoint.SeqNum)
+	}
+}
+
+// Called by server with the entries it drops from its logs (with its lock held)
+func (chk *checker) truncated(server int, entries []CommitLogEntry) {
+	chk.mu.Lock()
+	defer chk.mu.Unlock()
+
+	for _, entry := range entries {
+		if chk.archiving && entry.Msg0.PrepareSeqNum == len(chk.archive)+1 {
+			chk.archive = append(chk.archive, entry)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package xpaxos
+
+// Checkpoints of the state machine and truncation of the logs below them
+//
+// xp.SetCheckpointInterval(k)  - Takes a checkpoint every k applied commit log entries (0 = never)
+// cfg.setCheckpointInterval(k) - Same for every XPaxos server of a test (restarted ones included)
+// cfg.waitForCheckpoint(n)     - Waits until the servers of the synchronous group hold a stable
+//                                checkpoint of n entries or more
+//
+// A checkpoint is a snapshot of the state machine (see statemachine) after the first SeqNum commit
+// log entries, together with its hash and the last request of every client applied so far:
+// => A server that takes a checkpoint signs the digest of its state (all of it but the snapshot,
+//    which its hash stands in for, and the server that took it) and sends the signature to the
+//    rest of the synchronous group over the XPaxos.Checkpoint RPC
+// => The checkpoint becomes stable once it holds the signatures of t+1 servers (its certificate),
+//    at least one of which is correct and executed the entries below it; the server then drops
+//    those entries from both logs and from its persister, so the logs hold the entries of about
+//    one checkpoint interval plus those in flight, whatever the number of requests
+// => Entries keep their sequence numbers: the logs start at xp.truncated, the sequence number of
+//    the stable checkpoint, and messages that carry a log carry the checkpoint it starts at
+// => The stable checkpoint is persisted (see persister.go), so a restarted server restores its
+//    state machine from the snapshot and only replays the executed entries above it
+// => View change messages carry the sender's stable checkpoint, and a server adopts a stable
+//    checkpoint it receives if it applied fewer entries, i.e. when it joins the synchronous group
+//    and the entries it never executed were truncated; a server that executed past it adopts it
+//    only if its own entries chain to it, and one that restores its snapshot only if the snapshot
+//    restores a state of the signed hash (see checkSnapshot())
+// => Checkpoints of the same sequence number hold the same state on every correct server, so the
+//    invariant checker of the tests compares the hash of every checkpoint taken or adopted (after
+//    restoring the adopted snapshot) across servers (see checker.go)
+// => A checkpoint carries the head of the hash chain of the requests of its entries (every link
+//    hashes the previous head, the sequence number and the request digest) and is signed by the
+//    server that took it; the chain is part of the signed state, so the certificate binds the
+//    history below the checkpoint once its entries are gone
+// => Only the last signature of every server is kept, and a server that missed the signatures of
+//    a checkpoint (i.e. restarted) stays at its stable checkpoint until the next one
+
+import (
+	"errors"
+	"fmt"
+	"github.com/csanti/cos518_project/src/journal"
+)
+
+type chainLink struct {
+	Prev   [32]byte // Head of the chain of the entries before
+	SeqNum int
+	Digest [32]byte // Of the request of the entry
+}
+
+var errChain = errors.New("broken hash chain")
+var errUnstable = errors.New("checkpoint not stable")
+var errSnapshot = errors.New("snapshot does not match the hash")
+
+func (xp *XPaxos) SetCheckpointInterval(interval int) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	xp.interval = interval
+}
+
+// Head of the hash chain of entries extending head, that of the entries below sequence number
+// from+1 (the first of entries)
+func extendChain(head [32]byte, entries []CommitLogEntry, from int) [32]byte {
+	for i, entry := range entries {
+		head = digest(chainLink{head, from + i, digest(entry.Request)})
+	}
+	return head
+}
+
+// Digest signed by the server that took the checkpoint; the snapshot is bound by its hash
+func (checkpoint Checkpoint) digest() [32]byte {
+	checkpoint.Snapshot = nil
+	checkpoint.Signature = nil
+	checkpoint.Certificate = nil
+	return digest(checkpoint)
+}
+
+// Digest of the state of the checkpoint, the same for every server that took it
+func (checkpoint Checkpoint) stateDigest() [32]byte {
+	checkpoint.Server = 0
+	return checkpoint.digest()
+}
+
+// Checks that the state of a checkpoint was signed by t+1 servers; must be called with xp.mu held
+func (xp *XPaxos) checkStable(checkpoint Checkpoint) error {
+	stateDigest := checkpoint.stateDigest()
+	signed := 0
+	for server, signature := range checkpoint.Certificate {
+		if xp.checkSignature(server, stateDigest, signature) == nil {
+			signed++
+		}
+	}
+	if signed <= (len(xp.replicas)-1)/2 {
+		return fmt.Errorf("checkpoint %d: %d valid signatures: %w", checkpoint.SeqNum, signed, errUnstable)
+	}
+	return nil
+}
+
+// Checks that the snapshot of a checkpoint restores a state of its hash, since the certificate only
+// signs the hash (see digest()); the snapshot is restored on a scratch state machine, so the state
+// machine keeps its state either way; must be called with xp.mu held
+func (xp *XPaxos) checkSnapshot(checkpoint Checkpoint) error {
+	if xp.stateMachine == nil {
+		return nil
+	}
+	scratch := xp.stateMachine.Scratch()
+	scratch.Restore(checkpoint.Snapshot)
+	if scratch.Hash() != checkpoint.Hash {
+		return fmt.Errorf("checkpoint %d: %w", checkpoint.SeqNum, errSnapshot)
+	}
+	return nil
+}
+
+// Take a checkpoint of the entries applied so far and sign its state; must be called with xp.mu
+// held
+func (xp *XPaxos) takeCheckpoint() {
+	checkpoint := Checkpoint{
+		SeqNum:      xp.applied,
+		Snapshot:    xp.stateMachine.Snapshot(),
+		Hash:        xp.stateMachine.Hash(),
+		LastApplied: make(map[int]int, len(xp.lastApplied)),
+		Results:     make(map[int][]byte, len(xp.results)),
+		Chain:       extendChain(xp.checkpoint.Chain, xp.commitLog[:xp.applied-xp.truncated], xp.truncated),
+		Server:      xp.id}
+
+	for clientId, timestamp := range xp.lastApplied {
+		checkpoint.LastApplied[clientId] = timestamp
+	}
+	for clientId, result := range xp.results {
+		checkpoint.Results[clientId] = result
+	}
+	checkpoint.Signature = xp.sign(checkpoint.digest())
+
+	xp.log().With("view", xp.view, "seqNum", checkpoint.SeqNum).Debugf("Checkpoint: taken")
+	xp.record(journal.CHECKPOINTED, checkpoint.SeqNum)
+	xp.tentative = checkpoint
+	if xp.onCheckpoint != nil {
+		xp.onCheckpoint(xp.id, checkpoint)
+	}
+
+	msgDigest := checkpoint.stateDigest()
+	msg := CheckpointMessage{
+		MsgType:   CHECKPOINT,
+		MsgDigest: msgDigest,
+		Signature: xp.sign(msgDigest),
+		SeqNum:    checkpoint.SeqNum,
+		SenderId:  xp.id}
+
+	xp.votes[xp.id] = msg
+	for server, _ := range xp.synchronousGroup {
+		if server != xp.id {
+			go xp.issueCheckpoint(server, msg)
+		}
+	}
+	xp.stabilize()
+}
+
+//
+// ------------------------------- CHECKPOINT RPC -----------------------------
+//
+func (xp *XPaxos) issueCheckpoint(server int, msg CheckpointMessage) {
+	xp.log().With("seqNum", msg.SeqNum).Debugf("Checkpoint: to XPaxos server (%d)", server)
+	xp.replicas[server].Call("XPaxos.Checkpoint", msg, &Reply{}, xp.id) // A lost one delays the truncation
+}
+
+func (xp *XPaxos) Checkpoint(msg CheckpointMessage, reply *Reply) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	if msg.SeqNum <= xp.checkpoint.SeqNum || msg.SeqNum <= xp.votes[msg.SenderId].SeqNum {
+		return
+	}
+	if err := xp.checkSignature(msg.SenderId, msg.MsgDigest, msg.Signature); err != nil {
+		xp.log().With("seqNum", msg.SeqNum).Infof("Checkpoint: from XPaxos server (%d): %v", msg.SenderId, err)
+		return
+	}
+
+	xp.votes[msg.SenderId] = msg
+	xp.stabilize()
+	reply.Success = true
+}
+
+// Make the tentative checkpoint stable once t+1 servers signed its state; must be called with
+// xp.mu held
+func (xp *XPaxos) stabilize() {
+	checkpoint := xp.tentative
+	if checkpoint.SeqNum <= xp.checkpoint.SeqNum {
+		return
+	}
+
+	stateDigest := checkpoint.stateDigest()
+	checkpoint.Certificate = make(map[int][]byte)
+	for server, msg := range xp.votes {
+		if msg.SeqNum == checkpoint.SeqNum && msg.MsgDigest == stateDigest {
+			checkpoint.Certificate[server] = msg.Signature
+		}
+	}
+	if len(checkpoint.Certificate) <= (len(xp.replicas)-1)/2 {
+		return
+	}
+
+	xp.log().With("view", xp.view, "seqNum", checkpoint.SeqNum).Debugf("Checkpoint: stable")
+	xp.record(journal.STABLE, checkpoint.SeqNum)
+	xp.checkpoint = checkpoint
+	xp.tentative = Checkpoint{}
+	xp.persistSnapshot(checkpoint)
+	xp.truncate(checkpoint.SeqNum)
+}
+
+// Replace the state machine with a stable checkpoint that is ahead of it; must be called with
+// xp.mu held
+func (xp *XPaxos) adoptCheckpoint(checkpoint Checkpoint) {
+	if checkpoint.SeqNum <= xp.checkpoint.SeqNum {
+		return
+	}
+	if err := xp.checkStable(checkpoint); err != nil {
+		xp.log().With("view", xp.view, "seqNum", checkpoint.SeqNum).Infof("Checkpoint: rejected: %v", err)
+		return
+	}
+	if err := xp.checkSnapshot(checkpoint); err != nil { // Persisted, and restored after a restart
+		xp.log().With("view", xp.view, "seqNum", checkpoint.SeqNum).Infof("Checkpoint: rejected: %v", err)
+		return
+	}
+
+	if xp.tentative.SeqNum <= checkpoint.SeqNum {
+		xp.tentative = Checkpoint{}
+	}
+
+	if checkpoint.SeqNum <= xp.applied { // Executed past it, so our own entries stand in for the snapshot
+		head := extendChain(xp.checkpoint.Chain, xp.commitLog[:checkpoint.SeqNum-xp.truncated], xp.truncated)
+		if head != checkpoint.Chain {
+			xp.log().With("view", xp.view, "seqNum", checkpoint.SeqNum).Infof("Checkpoint: rejected: %v", errChain)
+			return
+		}
+		xp.log().With("view", xp.view, "seqNum", checkpoint.SeqNum).Debugf("Checkpoint: stable")
+		xp.record(journal.STABLE, checkpoint.SeqNum)
+		xp.checkpoint = checkpoint
+		xp.persistSnapshot(checkpoint)
+		xp.truncate(checkpoint.SeqNum)
+		return
+	}
+
+	xp.log().With("view", xp.view, "seqNum", checkpoint.SeqNum).Debugf("Checkpoint: adopted")
+	xp.record(journal.CHECKPOINTED, checkpoint.SeqNum)
+	xp.checkpoint = checkpoint
+	xp.persistSnapshot(checkpoint)
+	xp.restoreCheckpoint()
+	for xp.executeSeqNum < checkpoint.SeqNum { // Executed by the servers that signed it
+		xp.executeSeqNum++
+		xp.record(journal.EXECUTED, xp.executeSeqNum)
+	}
+	if xp.prepareSeqNum < xp.executeSeqNum {
+		xp.prepareSeqNum = xp.executeSeqNum
+	}
+	xp.truncate(checkpoint.SeqNum)
+	if xp.onCheckpoint != nil && xp.stateMachine != nil {
+		xp.onCheckpoint(xp.id, Checkpoint{SeqNum: checkpoint.SeqNum, Hash: xp.stateMachine.Hash(),
+			Chain: checkpoint.Chain})
+	}
+}
+
+// Restore the state machine from the last checkpoint; must be called with xp.mu held
+func (xp *XPaxos) restoreCheckpoint() {
+	xp.applied = xp.checkpoint.SeqNum
+	xp.lastApplied = make(map[int]int, len(xp.checkpoint.LastApplied))
+	xp.results = make(map[int][]byte, len(xp.checkpoint.Results))
+
+	for clientId, timestamp := range xp.checkpoint.LastApplied {
+		xp.lastApplied[clientId] = timestamp
+	}
+	for clientId, result := range xp.checkpoint.Results {
+		xp.results[clientId] = result
+	}
+	if xp.stateMachine != nil && xp.checkpoint.SeqNum > 0 {
+		xp.stateMachine.Restore(xp.checkpoint.Snapshot)
+	}
+}
+
+// Drop the log entries below seqNum, which the stable checkpoint stands in for; must be called
+// with xp.mu held
+func (xp *XPaxos) truncate(seqNum int) {
+	if seqNum <= xp.truncated {
+		return
+	}
+
+	commits := seqNum - xp.truncated
+	if commits > len(xp.commitLog) {
+		commits = len(xp.commitLog)
+	}
+	if xp.onTruncate != nil {
+		xp.onTruncate(xp.id, xp.commitLog[:commits])
+	}
+	xp.commitLog = append(make([]CommitLogEntry, 0, len(xp.commitLog)-commits), xp.commitLog[commits:]...)
+
+	prepares := seqNum - xp.truncated
+	if prepares > len(xp.prepareLog) {
+		prepares = len(xp.prepareLog)
+	}
+	xp.prepareLog = append(make([]PrepareLogEntry, 0, len(xp.prepareLog)-prepares), xp.prepareLog[prepares:]...)
+
+	xp.log().With("view", xp.view, "seqNum", seqNum).Debugf("Checkpoint: truncated %d entries", commits)
+	xp.truncated = seqNum
+	xp.notifyChange()
+	xp.persist(seqNum)
+}
+
+// Length of the commit log, truncated entries included
+func (xp *XPaxos) commitLength() int {
+	return xp.truncated + len(xp.commitLog)
+}
+
+// Length of the prepare log, truncated entries included
+func (xp *XPaxos) prepareLength() int {
+	return xp.truncated + len(xp.prepareLog)
+}
@@ -3,37 +3,79 @@ package xpaxos
 // RPC handlers for an XPaxos client server (propose)
 //
 // client := MakeClient(replicas) - Creates an XPaxos client server
+// client.Propose(op)            - Proposes an operation, returns whether the leader replied
+// client.Execute(op)            - Like Propose() but also returns the result of the state machine
+// => Every request carries a random nonce of NONCESIZE bytes chosen by the client; the prepare,
+//    commit and reply messages of a request sign its digest, which covers the nonce, so a signed
+//    message of one request's agreement cannot be spliced into the certificate of another one,
+//    even of a request with the same client, timestamp and operation (i.e. of a restarted client)
+// => Prepare and commit messages sign their view and sequence number along with that digest, so
+//    they cannot be moved to another slot of the log or another view either (see signedDigest())
+// => Servers neither prepare nor commit requests without a nonce (see validNonce())
+// => A request that every server replies to without committing it, i.e. sent while the view
+//    changes or given up by a leader that suspects its group (see fault.go), is sent again after
+//    DELTA until a leader commits it or the client issues its next request: the confirmation of
+//    the view change may have gone to an earlier request (see ConfirmVC()), and servers reply to a
+//    request they prepared already
+// => Requests slower than a threshold are logged with their phases (see slow.go)
 // => Option to perform cleanup with xp.Kill()
 
 import (
+	crand "crypto/rand"
 	"github.com/csanti/cos518_project/src/network"
 	"time"
 )
 
+const NONCESIZE = 16 // Bytes of the nonce of a client request
+
 //
 // ---------------------------- REPLICATE/REPLY RPC ---------------------------
 //
 func (client *Client) sendReplicate(server int, request ClientRequest, reply *Reply) bool {
-	dPrintf("Replicate: from client server (%d) to XPaxos server (%d)\n", CLIENT, server)
+	client.log().With("trace", request.TraceId).Debugf("Replicate: to XPaxos server (%d)", server)
 	return client.replicas[server].Call("XPaxos.Replicate", request, reply, CLIENT)
 }
 
-func (client *Client) issueReplicate(server int, request ClientRequest, replyCh chan bool, retry int) {
+func (client *Client) issueReplicate(server int, request ClientRequest, replyCh chan leaderReply,
+	declinedCh chan bool, retry int) {
 	reply := &Reply{}
 
 	if ok := client.sendReplicate(server, request, reply); ok {
 		if reply.Success == true { // Only the leader should reply to client server
-			replyCh <- reply.Success
+			replyCh <- leaderReply{server, reply.Result}
+		} else {
+			declinedCh <- true
 		}
 	} else {
 		if retry < RETRY {
 			retry++
-			go client.issueReplicate(server, request, replyCh, retry)
+			go client.issueReplicate(server, request, replyCh, declinedCh, retry)
 		}
 	}
 }
 
-func (client *Client) Propose(op interface{}) { // For simplicity, we assume the client's proposal is correct
+// Sends request to every server; returns a channel that gets a value from every server that replies
+// without committing it
+func (client *Client) broadcastReplicate(request ClientRequest, replyCh chan leaderReply) chan bool {
+	declinedCh := make(chan bool, len(client.replicas))
+	for server, _ := range client.replicas {
+		if server != CLIENT {
+			go client.issueReplicate(server, request, replyCh, declinedCh, 0)
+		}
+	}
+	return declinedCh
+}
+
+// Returns true if the leader replied; after a timeout or a view change the request may or may not
+// have been committed
+func (client *Client) Propose(op interface{}) bool {
+	_, ok := client.Execute(op)
+	return ok
+}
+
+// Returns the result of the leader's state machine (nil if the servers have none, or after a
+// timeout or a view change) and whether the leader replied
+func (client *Client) Execute(op interface{}) ([]byte, bool) { // For simplicity, we assume the client's proposal is correct
 	var timer <-chan time.Time
 
 	client.mu.Lock()
@@ -41,29 +83,56 @@ func (client *Client) Propose(op interface{}) { // For simplicity, we assume the
 		MsgType:   REPLICATE,
 		Timestamp: client.timestamp,
 		Operation: op,
-		ClientId:  CLIENT}
+		ClientId:  client.id,
+		Nonce:     newNonce(),
+		TraceId:   network.NewTraceId()}
 
-	replyCh := make(chan bool)
-	for server, _ := range client.replicas {
-		if server != CLIENT {
-			go client.issueReplicate(server, request, replyCh, 0)
-		}
-	}
+	start := client.clock.Now()
+	replyCh := make(chan leaderReply)
+	declinedCh := client.broadcastReplicate(request, replyCh)
+	servers := len(client.replicas) - 1
 
 	if WAIT == false {
-		timer = time.NewTimer(TIMEOUT * time.Millisecond).C
+		timer = client.clock.After(TIMEOUT * time.Millisecond)
 	}
 
 	client.timestamp++
+	timestamp := client.timestamp
+	slow := client.slow
 	client.mu.Unlock()
 
-	select {
-	case <-timer:
-		iPrintf("Timeout: Client.Propose: client server (%d)\n", CLIENT)
-	case <-replyCh:
-		iPrintf("Success: committed request (%d)\n", client.timestamp)
-	case <-client.vcCh:
-		iPrintf("Success: committed request after view change (%d)", client.timestamp)
+	logger := client.log().With("timestamp", timestamp, "trace", request.TraceId)
+	var retransmit <-chan time.Time
+	for declined := 0; ; {
+		select {
+		case <-timer:
+			logger.Infof("Timeout: Client.Propose")
+			return nil, false
+		case reply := <-replyCh:
+			logger.Infof("Success: committed request")
+			if latency := client.clock.Now().Sub(start); slow > 0 && latency > slow {
+				go client.logSlow(logger, reply.leader, request.TraceId, latency)
+			}
+			return reply.result, true
+		case <-client.vcCh:
+			logger.Infof("Success: committed request after view change")
+			if latency := client.clock.Now().Sub(start); slow > 0 && latency > slow {
+				go client.logSlow(logger, CLIENT, request.TraceId, latency)
+			}
+			return nil, false
+		case <-declinedCh:
+			if declined++; declined == servers {
+				retransmit = client.clock.After(network.DELTA * time.Millisecond)
+			}
+		case <-retransmit:
+			declined, retransmit = 0, nil
+			client.mu.Lock()
+			if client.timestamp == timestamp { // Otherwise a server may take the later one for it
+				logger.Debugf("Retransmit: not committed by any server")
+				declinedCh = client.broadcastReplicate(request, replyCh)
+			}
+			client.mu.Unlock()
+		}
 	}
 }
 
@@ -71,14 +140,27 @@ func (client *Client) ConfirmVC(msg Message, reply *Reply) {
 	client.vcCh <- true
 }
 
+func newNonce() []byte {
+	nonce := make([]byte, NONCESIZE)
+	crand.Read(nonce)
+	return nonce
+}
+
+// Requests without a nonce of their client are neither prepared nor committed
+func validNonce(request ClientRequest) bool {
+	return len(request.Nonce) == NONCESIZE
+}
+
 //
 // ------------------------------- MAKE FUNCTION ------------------------------
 //
-func MakeClient(replicas []*network.ClientEnd) *Client {
+func MakeClient(replicas []network.Transport) *Client {
 	client := &Client{}
 
 	client.mu.Lock()
+	client.id = CLIENT
 	client.replicas = replicas
+	client.clock = network.RealClock{}
 	client.timestamp = 0
 	client.vcCh = make(chan bool)
 	client.mu.Unlock()
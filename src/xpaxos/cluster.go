@@ -0,0 +1,293 @@
+package xpaxos
+
+// Deployment of XPaxos servers as OS processes talking over Unix sockets
+//
+// InitCluster(dir, servers, scheme) - Writes fresh keys of scheme for servers XPaxos servers to dir,
+//                                     with their certificates by a fresh CA (see certificate.go)
+//                                     and a configuration file of the cluster (see bootstrap.go)
+// StartReplica(dir, id, sm, k)      - Serves XPaxos server id of the cluster in dir, driving sm
+//                                     (its metrics are xp.Metrics(), see metrics.go)
+// StartReplicaSigner(dir, id, signer, sm, k)
+//                                   - Same, signing with signer instead of the key file (i.e. a
+//                                     signer in another process, see signing/remote.go)
+// ClusterSigner(dir, id)            - Signer of the key of server id in the key file (i.e. to serve
+//                                     it from another process, see cmd/signerd)
+// ConnectClient(dir)                - Client of the cluster in dir
+// ConnectClients(dir, m)            - m clients of the cluster in dir with distinct client IDs
+// ClusterStatus(dir, timeout)       - Status of every XPaxos server of the cluster in dir
+// InspectServer(dir, id, timeout)   - Internal state of XPaxos server id (see inspect.go)
+//
+// => A cluster is a directory holding the private keys of its servers ("keys"), the certificate and
+//    key of its CA ("ca"), the certificates of the keys of its servers ("certs") and the socket of
+//    every server ("<id>.sock", see network/socket.go); the process cluster of the tests (see
+//    process.go) and the commands cmd/xpaxosd and cmd/kvctl share this layout
+// => InitCluster() also writes the same keys, certificates and CA as PEM files ("<id>.key.pem",
+//    "<id>.pub.pem", "<id>.cert.pem" and "ca.pem") and a configuration file naming them and the
+//    sockets ("cluster.json"), from which servers start as well (see StartReplicaConfig())
+// => Servers take the public keys of their peers from the certificates only, and fail to start if
+//    any certificate was not issued by the CA to the server of its ID (see MakeCertified())
+// => Clients get replies on the connections of their Replicate RPCs, so a client needs no socket
+//    of its own (it misses confirmations of view changes, which only end its wait early)
+// => A client of ConnectClient() has the client ID, and a client's timestamps start at the current
+//    time in nanoseconds so that the requests of successive clients with the same ID (i.e. one per
+//    run of cmd/kvctl) are not taken for retransmissions of older ones
+// => Clients of ConnectClients() have IDs 1..m (like the clients of cfg.makeClients()), so that
+//    they can propose concurrently (i.e. behind the HTTP gateway, see gateway)
+// => State is persisted in memory only, so a restarted server process starts from scratch
+// => Servers establish their session keys with the servers already up when they start (see
+//    session.go)
+// => The key file holds the private keys of all servers, and the CA file the key of the CA, for
+//    tests and experiments; a server with an external signer fails to start if the signer's public
+//    key is not that of its certificate
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/gob"
+	"fmt"
+	"github.com/csanti/cos518_project/src/metrics"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Status struct {
+	Id            int
+	View          int
+	Leader        int
+	PrepareSeqNum int
+	ExecuteSeqNum int
+	InGroup       bool // Member of the synchronous group of its view
+	Reachable     bool // Replied to the status RPC (see ClusterStatus())
+}
+
+type clusterCA struct {
+	Certificate []byte // DER
+	Key         []byte // PKCS #8
+}
+
+func InitCluster(dir string, servers int, scheme signing.Scheme) error {
+	keys := make(map[int][]byte, servers) // PKCS #8 encoded private keys of all XPaxos servers
+	for i := 1; i <= servers; i++ {
+		privateKey, err := scheme.GenerateKey()
+		if err != nil {
+			return err
+		}
+		if keys[i], err = signing.MarshalPrivateKey(privateKey); err != nil {
+			return err
+		}
+	}
+	ca, err := signing.MakeCA(scheme)
+	if err != nil {
+		return err
+	}
+	if err := writeClusterKeys(dir, keys); err != nil {
+		return err
+	}
+	if err := writeClusterCertificates(dir, ca); err != nil {
+		return err
+	}
+	return writeClusterPEM(dir, ca)
+}
+
+func writeClusterKeys(dir string, keys map[int][]byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return writeGob(dir+"/keys", keys)
+}
+
+// Writes the CA and the certificates it issues to the keys of the key file of dir
+func writeClusterCertificates(dir string, ca *signing.CA) error {
+	_, publicKeys, err := readClusterKeys(dir)
+	if err != nil {
+		return err
+	}
+	certificates := make(map[int][]byte, len(publicKeys))
+	for i, publicKey := range publicKeys {
+		if certificates[i], err = ca.Issue(i, publicKey); err != nil {
+			return err
+		}
+	}
+	key, err := ca.Key()
+	if err != nil {
+		return err
+	}
+
+	if err := writeGob(dir+"/ca", clusterCA{ca.Certificate(), key}); err != nil {
+		return err
+	}
+	return writeGob(dir+"/certs", certificates)
+}
+
+func writeGob(path string, value interface{}) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return gob.NewEncoder(file).Encode(value)
+}
+
+func readGob(path string, value interface{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return gob.NewDecoder(file).Decode(value)
+}
+
+// Root certificate of the CA of the cluster in dir and the certificates of its servers
+func readClusterCertificates(dir string) (*x509.Certificate, map[int][]byte, error) {
+	var ca clusterCA
+	if err := readGob(dir+"/ca", &ca); err != nil {
+		return nil, nil, err
+	}
+	root, err := x509.ParseCertificate(ca.Certificate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("certificate of the CA: %v", err)
+	}
+	certificates := make(map[int][]byte)
+	if err := readGob(dir+"/certs", &certificates); err != nil {
+		return nil, nil, err
+	}
+	return root, certificates, nil
+}
+
+func readClusterKeys(dir string) (map[int]crypto.Signer, map[int]crypto.PublicKey, error) {
+	keys := make(map[int][]byte)
+	if err := readGob(dir+"/keys", &keys); err != nil {
+		return nil, nil, err
+	}
+
+	privateKeys := make(map[int]crypto.Signer, len(keys))
+	publicKeys := make(map[int]crypto.PublicKey, len(keys))
+	for i, key := range keys {
+		privateKey, err := signing.ParsePrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("key of server (%d): %v", i, err)
+		}
+		privateKeys[i] = privateKey
+		publicKeys[i] = privateKey.Public()
+	}
+	return privateKeys, publicKeys, nil
+}
+
+func ClusterSigner(dir string, id int) (signing.Signer, error) {
+	privateKeys, _, err := readClusterKeys(dir)
+	if err != nil {
+		return nil, err
+	}
+	if privateKeys[id] == nil {
+		return nil, fmt.Errorf("no key of server (%d) in %s", id, dir)
+	}
+	signer, err := signing.ErasableSigner(privateKeys[id])
+	for _, privateKey := range privateKeys {
+		signing.Erase(privateKey) // The signer holds its own copy
+	}
+	return signer, err
+}
+
+// Socket ends of the client (ID = 0) and all XPaxos servers of the cluster in dir
+func clusterEnds(dir string, n int) []network.Transport {
+	ends := make([]network.Transport, n)
+	for j := 0; j < n; j++ {
+		ends[j] = network.MakeSocketEnd(socketPath(dir, j))
+	}
+	return ends
+}
+
+// sm may be nil (no state machine); interval is the checkpoint interval (see checkpoint.go)
+func StartReplica(dir string, id int, sm statemachine.StateMachine, interval int) (*XPaxos, *network.SocketServer,
+	error) {
+	signer, err := ClusterSigner(dir, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return StartReplicaSigner(dir, id, signer, sm, interval)
+}
+
+func StartReplicaSigner(dir string, id int, signer signing.Signer, sm statemachine.StateMachine,
+	interval int) (*XPaxos, *network.SocketServer, error) {
+	root, certificates, err := readClusterCertificates(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reg := metrics.MakeRegistry("server", strconv.Itoa(id))
+	ends := timedEnds(clusterEnds(dir, len(certificates)+1), reg)
+	xp, err := MakeCertified(ends, id, MakePersister(), signer, root, certificates)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v in %s", err, dir)
+	}
+	return serveReplica(xp, reg, socketPath(dir, id), sm, interval)
+}
+
+// Serves xp on the socket at address, with its metrics in reg
+func serveReplica(xp *XPaxos, reg *metrics.Registry, address string, sm statemachine.StateMachine,
+	interval int) (*XPaxos, *network.SocketServer, error) {
+	xp.RegisterMetrics(reg)
+	xp.mu.Lock()
+	xp.registry = reg
+	xp.mu.Unlock()
+	if sm != nil {
+		xp.SetCheckpointInterval(interval)
+		xp.SetStateMachine(sm)
+	}
+
+	srv := network.MakeServer()
+	srv.AddService(network.MakeService(xp))
+	socket, err := network.ServeSocket(address, srv)
+	if err != nil {
+		xp.Kill()
+		return nil, nil, err
+	}
+	go xp.EstablishSessions()
+	return xp, socket, nil
+}
+
+func ConnectClient(dir string) (*Client, error) {
+	_, publicKeys, err := readClusterKeys(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	client := MakeClient(clusterEnds(dir, len(publicKeys)+1))
+	client.timestamp = int(time.Now().UnixNano())
+	return client, nil
+}
+
+func ConnectClients(dir string, m int) ([]*Client, error) {
+	clients := make([]*Client, m)
+	for i := range clients {
+		client, err := ConnectClient(dir)
+		if err != nil {
+			return nil, err
+		}
+		client.id = i + 1
+		clients[i] = client
+	}
+	return clients, nil
+}
+
+// Servers that do not reply within timeout are not Reachable
+func ClusterStatus(dir string, timeout time.Duration) ([]Status, error) {
+	_, publicKeys, err := readClusterKeys(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(publicKeys))
+	for i := range statuses {
+		end := network.MakeSocketEnd(socketPath(dir, i+1))
+		if end.CallTimeout("XPaxos.Status", 0, &statuses[i], CLIENT, timeout) == false {
+			statuses[i] = Status{}
+		}
+		statuses[i].Id = i + 1
+	}
+	return statuses, nil
+}
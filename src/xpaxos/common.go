@@ -1,19 +1,29 @@
 package xpaxos
 
 import (
-	"crypto/rsa"
+	"crypto"
+	"crypto/x509"
+	"github.com/csanti/cos518_project/src/histogram"
+	"github.com/csanti/cos518_project/src/journal"
+	"github.com/csanti/cos518_project/src/linearizability"
+	"github.com/csanti/cos518_project/src/metrics"
 	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
-const DEBUG = 1       // Debugging (0 = None, 1 = Info, 2 = Debug)
 const CLIENT = 0      // Client ID is always set to zero - DO NOT CHANGE
 const TIMEOUT = 10000 // Client timeout period (in milliseconds)
 const WAIT = true     // If false, client times out after TIMEOUT milliseconds; if true, client never times out
 const RETRY = 5       // Number of times the client tries to resend a failed replicate RPC
-const BITSIZE = 1024  // RSA private key bit size
+const KEYGRACE = 10   // Executed entries between the steps of a key change (see rotation.go)
+
+const VCPRIORITY = network.HIGHPRIORITY // Network priority of view change RPCs (see network/priority.go)
 
 const ( // RPC message types for common case and view change protocols
 	REPLICATE  = iota
@@ -24,33 +34,58 @@ const ( // RPC message types for common case and view change protocols
 	VIEWCHANGE = iota
 	VCFINAL    = iota
 	NEWVIEW    = iota
+	READINDEX  = iota
+	CHECKPOINT = iota
 )
 
 type config struct {
 	mu          sync.Mutex
 	t           *testing.T
 	net         *network.Network
-	n           int   // Total number of client and XPaxos servers
-	done        int32 // Tell internal threads to die
+	n           int        // Total number of client and XPaxos servers
+	start       time.Time  // When the config was made (see cfg.running())
+	rand        *rand.Rand // Source of random choices of tests (seeded with -seed)
+	done        int32      // Tell internal threads to die
 	xpServers   []*XPaxos
 	client      *Client
 	connected   []bool     // Whether each server is on the net
 	endnames    [][]string // The port file names each sends to
-	privateKeys map[int]*rsa.PrivateKey
-	publicKeys  map[int]*rsa.PublicKey
+	privateKeys map[int]crypto.Signer
+	publicKeys  map[int]crypto.PublicKey
+	keyHistory  KeyHistory                       // Every public key of each server, oldest first (see audit.go)
+	signers     map[int]signing.Signer           // Signers of servers that do not sign with privateKeys (see cfg.signer())
+	freshKeys   bool                             // Servers get fresh keys instead of pooled ones (see pooledKeys())
+	scheme      signing.Scheme                   // Signature scheme of the servers' keys (see signing)
+	saved       []*Persister                     // Persisted state of each XPaxos server (survives crash1)
+	history     *linearizability.History         // Invocations and responses of proposals made through cfg.propose
+	proposals   map[int]int                      // History operation ID -> client timestamp of the proposal
+	latencies   *histogram.Histogram             // Latencies of proposals made through cfg.propose
+	budgetRPCs  int                              // RPCs issued when the RPC budget began (see cfg.beginRPCBudget())
+	budgetBytes int64                            // Bytes sent when the RPC budget began
+	checker     *checker                         // Checks invariants while the test runs (see checker.go)
+	machines    []statemachine.StateMachine      // State machine of each XPaxos server (replayed on restart)
+	makeMachine func() statemachine.StateMachine // State machine of a (re)started server (see setStateMachines())
+	interval    int                              // Checkpoint interval of every server (see checkpoint.go)
+	audit       bool                             // Every server archives its signed messages (see audit.go)
+	threshold   bool                             // Every server certifies its view changes and commits (see threshold.go)
+	pki         bool                             // Servers start from certificates of their keys (see certificate.go)
 }
 
 type Client struct {
 	mu        sync.Mutex
-	replicas  []*network.ClientEnd
+	id        int // Client ID of its requests (see cfg.makeClients())
+	replicas  []network.Transport
+	clock     network.Clock
 	timestamp int
 	vcCh      chan bool
+	slow      time.Duration // Requests slower than it are logged with their phases (0 = none, see slow.go)
+	logger    atomic.Value  // *debug.Logger of the client (see client.log())
 	// Must include statistics for evaluation
 }
 
 type XPaxos struct {
 	mu               sync.Mutex
-	replicas         []*network.ClientEnd
+	replicas         []network.Transport
 	synchronousGroup map[int]bool
 	id               int
 	view             int
@@ -58,8 +93,8 @@ type XPaxos struct {
 	executeSeqNum    int
 	prepareLog       []PrepareLogEntry
 	commitLog        []CommitLogEntry
-	privateKey       *rsa.PrivateKey
-	publicKeys       map[int]*rsa.PublicKey
+	signer           signing.Signer // Private key of the server; guarded by keyMu, like publicKeys
+	publicKeys       map[int]crypto.PublicKey
 	suspectSet       map[[32]byte]SuspectMessage
 	vcSet            map[[32]byte]ViewChangeMessage
 	netFlag          bool // Flag to tell if netTimer is still valid
@@ -68,7 +103,83 @@ type XPaxos struct {
 	vcTimer          <-chan time.Time
 	receivedVCFinal  map[int]map[[32]byte]ViewChangeMessage
 	vcInProgress     bool
-	byzantine        bool
+	byzantine        int           // Byzantine strategy (see byzantine.go)
+	clock            network.Clock // Source of time for protocol timers
+	faultTimeout     time.Duration // Bound on acknowledgements of prepares and commits (see fault.go)
+	changed          chan struct{} // Closed when the log or the view changes (see notifyChange())
+	suspected        int           // Last view the server suspected (see issueSuspect())
+	persister        *Persister    // Stable storage for the view, sequence numbers and logs
+	failMu           sync.Mutex
+	failpoints       map[int]*failpoint        // Armed failpoints (see failpoint.go)
+	stateMachine     statemachine.StateMachine // Service driven by the executor (nil if none)
+	applied          int                       // Commit log entries applied to the state machine
+	lastApplied      map[int]int               // Client ID -> timestamp of its last applied request
+	results          map[int][]byte            // Client ID -> result of its last applied request
+	checkpoint       Checkpoint                // Last stable checkpoint (see checkpoint.go)
+	tentative        Checkpoint                // Last checkpoint taken, until it is stable
+	votes            map[int]CheckpointMessage // Server ID -> its last signature of a checkpoint's state
+	interval         int                       // Applied entries between checkpoints (0 = none)
+	truncated        int                       // Log entries dropped below the stable checkpoint
+	stateSources     map[int]int               // Server ID -> state requests to it in flight (see statetransfer.go)
+	stateStreams     *network.StreamBuffer     // Chunks of the states they stream
+	pendingReads     []pendingRead             // Reads waiting for a confirmation round (see readindex.go)
+	readsInFlight    bool                      // A confirmation round is running
+	readRounds       int                       // Confirmation rounds run so far
+	timings          map[string]Timing         // Trace ID -> phases of a request led (see slow.go)
+	timed            []string                  // Trace IDs of the timings, oldest first
+	onCheckpoint     func(int, Checkpoint)     // Called with every checkpoint taken or adopted (tests)
+	logger           atomic.Value              // *debug.Logger of the server (see xp.log())
+	tracer           atomic.Value              // *tracing.Tracer of the server (nil for none, see SetTracer())
+	journal          *journal.Journal          // Significant transitions of the server (see xp.Journal())
+	signatures       int64                     // Messages signed (atomic, see metrics.go)
+	verifications    int64                     // Signatures verified (atomic)
+	verifyHits       int64                     // Signatures found in the verification cache (atomic, see verifycache.go)
+	verifyMisses     int64                     // Signatures looked up in it and verified (atomic)
+	signTime         int64                     // Nanoseconds spent signing (atomic, see xp.CryptoTime())
+	verifyTime       int64                     // Nanoseconds spent verifying signatures (atomic)
+	pending          int64                     // Client requests in Replicate() (atomic, see xp.Backlog())
+	vcStats          ViewChangeStats           // Cost of the view changes (see vcstats.go)
+	vcStarted        time.Time                 // Start of the view change in progress
+	vcSent           int64                     // View change messages sent (atomic)
+	vcSentAtStart    int64                     // View change messages sent before the view change in progress
+	registry         *metrics.Registry         // Metrics of a deployed server (see metrics.go)
+	selfCheckDone    chan bool                 // Stops the self-checks (nil if none, see selfcheck.go)
+	checkedView      int                       // View of the last self-check
+	checkedPrepare   int                       // prepareSeqNum of the last self-check
+	checkedExecute   int                       // executeSeqNum of the last self-check
+	reported         map[string]bool           // Violations already logged
+	violations       []string                  // Distinct violations found, in order
+	auditMu          sync.Mutex
+	auditing         bool // Signed messages are archived to the persister (see audit.go)
+	audited          int  // Entries of the audit trail persisted so far
+	keyMu            sync.Mutex
+	retiring         map[int]retiringKey       // Server ID -> its old key, during the grace window of a key change
+	rotations        map[int]int               // Server ID -> key changes of the server applied so far
+	pendingKeys      map[string]signing.Signer // PKIX public key -> private key of a key change of the server
+	nextKey          signing.Signer            // Key the server signs with once it executed switchAt entries
+	switchAt         int
+	keysApplied      int                            // Executed entries whose key changes were applied (guarded by mu)
+	thresholdShare   *signing.ThresholdShare        // Certifies view changes and commits (nil = none, see threshold.go)
+	vcShares         map[int]signing.SignatureShare // Server ID -> its share of the certificate of the view
+	viewCertificate  []byte                         // Certificate of the current view (nil if none)
+	sessions         *signing.Sessions              // Session keys with the other servers (see session.go)
+	root             *x509.Certificate              // CA of new keys (nil = none, see certificate.go); guarded by keyMu
+	verified         *verifyCache                   // Signatures that verified (nil = none, see verifycache.go); guarded by keyMu
+	verifyEpoch      int                            // Key changes and cache resets so far; guarded by keyMu
+	presignMu        sync.Mutex
+	presignJobs      chan presignJob         // Jobs of the presigners (nil = sign inline, see presign.go)
+	presignView      int                     // View of the next prepare of the leader, as requests guess it
+	presignSeqNum    int                     // Sequence number of the next prepare, as requests guess it
+	presignWaiting   int                     // Requests that guessed a sequence number and wait for the lock
+	presignShare     *signing.ThresholdShare // Threshold share of the server, as requests guess it
+	presignLeader    bool                    // The server leads the view, as requests guess it
+	presignHits      int64                   // Prepares presigned for the view and sequence number they got (atomic)
+	presignMisses    int64                   // Prepares presigned for others, then signed under the lock (atomic)
+	dkgMu            sync.Mutex
+	dkgInbox         map[dkgSlot]signing.DKGMessage // Messages of ceremonies (see dkg.go)
+	dkgEnded         map[int]bool                   // Ceremonies the server took part in
+	dkgArrived       chan struct{}                  // Closed (and replaced) whenever a message arrives
+	onTruncate       func(int, []CommitLogEntry)    // Called with every entry dropped from the logs (tests)
 }
 
 type PrepareLogEntry struct {
@@ -77,10 +188,24 @@ type PrepareLogEntry struct {
 }
 
 type CommitLogEntry struct {
-	Request ClientRequest
-	Msg0    Message
-	Msg1    map[int]Message
-	View    int
+	Request      ClientRequest
+	Msg0         Message
+	Msg1         map[int]Message
+	View         int     // View the entry was committed in
+	Installation Message // Prepare of the entry in the last NEW-VIEW that installed it (see NewView())
+	Certificate  []byte  // Threshold signature of the commits of the group (nil if none, see certifyEntry())
+}
+
+type Checkpoint struct {
+	SeqNum      int            // Commit log entries applied to the snapshot
+	Snapshot    []byte         // Snapshot of the state machine
+	Hash        [32]byte       // Hash of the state machine (see statemachine)
+	LastApplied map[int]int    // Client ID -> timestamp of its last applied request
+	Results     map[int][]byte // Client ID -> result of its last applied request
+	Chain       [32]byte       // Head of the hash chain of the requests of the entries (see checkpoint.go)
+	Server      int            // Server that took the checkpoint
+	Signature   []byte         // Of the checkpoint's digest, by Server
+	Certificate map[int][]byte // Server ID -> its signature of the state (see stateDigest()), t+1 once stable
 }
 
 type ClientRequest struct {
@@ -88,6 +213,8 @@ type ClientRequest struct {
 	Timestamp int
 	Operation interface{}
 	ClientId  int
+	Nonce     []byte // Random, chosen by the client for every request (see client.go)
+	TraceId   string // Assigned by the client (see network.NewTraceId())
 }
 
 type Message struct {
@@ -98,6 +225,9 @@ type Message struct {
 	View            int
 	ClientTimestamp int
 	SenderId        int
+	TraceId         string // Of the client request ("" for messages about no request)
+	Share           []byte // Encoded share of the commit certificate of its entry (see signCommitShare())
+	Time            int64  // Prepare or commit: leader's time of the entry in nanoseconds (see applyExecuted())
 }
 
 type Reply struct {
@@ -106,6 +236,15 @@ type Reply struct {
 	Success    bool
 	IsLeader   bool
 	Suspicious bool
+	TimedOut   bool   // The receiver gave up waiting for the group (see fault.go)
+	Result     []byte // Result of the state machine (leader's reply to the client)
+	Commit     []byte // Signature of the receiver's commit of an executed prepare (see recordCommit())
+	Share      []byte // Encoded share of the certificate in that commit
+}
+
+type leaderReply struct { // Of the leader to a client's replicate
+	leader int
+	result []byte
 }
 
 type SuspectMessage struct {
@@ -117,12 +256,13 @@ type SuspectMessage struct {
 }
 
 type ViewChangeMessage struct {
-	MsgType   int
-	MsgDigest [32]byte
-	Signature []byte
-	View      int
-	SenderId  int
-	CommitLog []CommitLogEntry
+	MsgType    int
+	MsgDigest  [32]byte
+	Signature  []byte
+	View       int
+	SenderId   int
+	CommitLog  []CommitLogEntry // Entries above the checkpoint
+	Checkpoint Checkpoint       // Stable, stands in for the entries below it
 }
 
 type VCFinalMessage struct {
@@ -132,13 +272,24 @@ type VCFinalMessage struct {
 	View      int
 	SenderId  int
 	VCSet     map[[32]byte]ViewChangeMessage
+	Share     *signing.SignatureShare // Of the certificate of the view (nil if none, see threshold.go)
 }
 
 type NewViewMessage struct {
-	MsgType    int
-	MsgDigest  [32]byte
-	Signature  []byte
-	View       int
-	PrepareLog []PrepareLogEntry
-	SenderId   int
+	MsgType     int
+	MsgDigest   [32]byte
+	Signature   []byte
+	View        int
+	PrepareLog  []PrepareLogEntry // Entries above the checkpoint
+	SenderId    int
+	Certificate []byte     // Threshold signature of the view by its synchronous group (see threshold.go)
+	Checkpoint  Checkpoint // Stable checkpoint of the leader, stands in for the entries below it
+}
+
+type CheckpointMessage struct {
+	MsgType   int
+	MsgDigest [32]byte // Of the state of the checkpoint (see stateDigest())
+	Signature []byte
+	SeqNum    int
+	SenderId  int
 }
@@ -1,15 +1,65 @@
 package xpaxos
 
 import (
+	"crypto"
 	crand "crypto/rand"
-	"crypto/rsa"
 	"encoding/base64"
+	"fmt"
+	"github.com/csanti/cos518_project/src/histogram"
+	"github.com/csanti/cos518_project/src/linearizability"
+	"github.com/csanti/cos518_project/src/memstats"
 	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/profiling"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// Cluster parameters set by go test flags (see test_test.go); zero values keep those of each test
+type parameters struct {
+	n          int  // Total number of client and XPaxos servers (takes precedence over f)
+	f          int  // Number of faults to tolerate: n = 2f+2 (XPaxos servers = 2t+1)
+	unreliable bool // Run every test on an unreliable network
+	seed       int64
+	duration   time.Duration  // Closed-loop tests propose until the duration elapses (see cfg.running())
+	freshKeys  bool           // Every test generates fresh RSA keys instead of using pooled ones
+	scheme     signing.Scheme // Signature scheme of the keys of every test (signing.DEFAULT unless set)
+	soak       time.Duration  // How long TestSoak1 runs (0 = skipped)
+	update     bool           // Rewrite golden traces instead of comparing against them (see trace.go)
+	memSample  time.Duration  // How often benchmarks sample memory (0 = only before and after)
+	heapDir    string         // Benchmarks dump a heap profile here at every memory sample (if set)
+	cpuDir     string         // Benchmarks write a CPU profile of their measurement window here (if set)
+	mutexDir   string         // Benchmarks write a mutex contention profile here (if set)
+	persistDir string         // Every test writes the persisters of its servers here (see replay.go)
+	slow       time.Duration  // Clients log requests slower than it with their phases (0 = none, see slow.go)
+	selfCheck  time.Duration  // Servers check their own invariants at this interval (0 = never, see selfcheck.go)
+	audit      bool           // Servers archive every message they sign (see audit.go)
+	threshold  bool           // Servers certify view changes and commits with threshold signatures (see threshold.go)
+	pki        bool           // Servers start from certificates of their keys by a CA (see certificate.go)
+	presign    int            // Goroutines signing client requests ahead of the lock (0 = inline, see presign.go)
+}
+
+var params parameters
+
+func (p parameters) apply(n int, unreliable bool) (int, bool) {
+	if p.n > 0 {
+		n = p.n
+	} else if p.f > 0 {
+		n = 2*p.f + 2
+	}
+	return n, unreliable || p.unreliable
+}
+
 func randstring(n int) string {
 	b := make([]byte, 2*n)
 	crand.Read(b)
@@ -18,17 +68,44 @@ func randstring(n int) string {
 }
 
 func makeConfig(t *testing.T, n int, unreliable bool) *config {
+	return makeConfigKeys(t, n, unreliable, params.freshKeys, params.scheme)
+}
+
+// Like makeConfig() but with keys no other test used (see pooledKeys())
+func makeConfigFreshKeys(t *testing.T, n int, unreliable bool) *config {
+	return makeConfigKeys(t, n, unreliable, true, params.scheme)
+}
+
+// Like makeConfig() but with keys of scheme, whatever -scheme says
+func makeConfigScheme(t *testing.T, n int, unreliable bool, scheme signing.Scheme) *config {
+	return makeConfigKeys(t, n, unreliable, params.freshKeys, scheme)
+}
+
+func makeConfigKeys(t *testing.T, n int, unreliable bool, freshKeys bool, scheme signing.Scheme) *config {
 	runtime.GOMAXPROCS(4)
 	cfg := &config{}
+	n, unreliable = params.apply(n, unreliable)
 	cfg.t = t
 	cfg.net = network.MakeNetwork()
 	cfg.n = n
+	cfg.start = time.Now()
+	cfg.rand = makeRand()
+	cfg.net.Seed(cfg.rand.Int63()) // Network decisions also follow -seed
 	cfg.xpServers = make([]*XPaxos, cfg.n)
 	cfg.client = &Client{}
 	cfg.connected = make([]bool, cfg.n)
 	cfg.endnames = make([][]string, cfg.n)
-	cfg.privateKeys = make(map[int]*rsa.PrivateKey, cfg.n)
-	cfg.publicKeys = make(map[int]*rsa.PublicKey, cfg.n)
+	cfg.privateKeys = make(map[int]crypto.Signer, cfg.n)
+	cfg.publicKeys = make(map[int]crypto.PublicKey, cfg.n)
+	cfg.keyHistory = make(KeyHistory, cfg.n)
+	cfg.signers = make(map[int]signing.Signer)
+	cfg.freshKeys = freshKeys
+	cfg.scheme = scheme
+	cfg.saved = make([]*Persister, cfg.n)
+	cfg.machines = make([]statemachine.StateMachine, cfg.n)
+	cfg.history = linearizability.MakeHistory()
+	cfg.proposals = make(map[int]int)
+	cfg.latencies = histogram.MakeHistogram()
 
 	cfg.setUnreliable(unreliable)
 	cfg.net.LongDelays(false)
@@ -43,21 +120,36 @@ func makeConfig(t *testing.T, n int, unreliable bool) *config {
 		cfg.connect(i)
 	}
 
+	cfg.checker = cfg.startChecker(CHECKINTERVAL)
+
 	return cfg
 }
 
 func makeConfig2(t *testing.T, n int, unreliable bool, minDelay int, maxDelay int) *config {
 	runtime.GOMAXPROCS(4)
 	cfg := &config{}
+	n, unreliable = params.apply(n, unreliable)
 	cfg.t = t
 	cfg.net = network.MakeNetwork()
 	cfg.n = n
+	cfg.start = time.Now()
+	cfg.rand = makeRand()
+	cfg.net.Seed(cfg.rand.Int63()) // Network decisions also follow -seed
 	cfg.xpServers = make([]*XPaxos, cfg.n)
 	cfg.client = &Client{}
 	cfg.connected = make([]bool, cfg.n)
 	cfg.endnames = make([][]string, cfg.n)
-	cfg.privateKeys = make(map[int]*rsa.PrivateKey, cfg.n)
-	cfg.publicKeys = make(map[int]*rsa.PublicKey, cfg.n)
+	cfg.privateKeys = make(map[int]crypto.Signer, cfg.n)
+	cfg.publicKeys = make(map[int]crypto.PublicKey, cfg.n)
+	cfg.keyHistory = make(KeyHistory, cfg.n)
+	cfg.signers = make(map[int]signing.Signer)
+	cfg.freshKeys = params.freshKeys
+	cfg.scheme = params.scheme
+	cfg.saved = make([]*Persister, cfg.n)
+	cfg.machines = make([]statemachine.StateMachine, cfg.n)
+	cfg.history = linearizability.MakeHistory()
+	cfg.proposals = make(map[int]int)
+	cfg.latencies = histogram.MakeHistogram()
 
 	cfg.setUnreliable(unreliable)
 	cfg.net.LongDelays(false)
@@ -74,6 +166,8 @@ func makeConfig2(t *testing.T, n int, unreliable bool, minDelay int, maxDelay in
 		cfg.connect(i)
 	}
 
+	cfg.checker = cfg.startChecker(CHECKINTERVAL)
+
 	return cfg
 }
 
@@ -98,9 +192,16 @@ func (cfg *config) crash1(i int) {
 		cfg.mu.Lock()
 		cfg.xpServers[i] = nil
 	}
+
+	// A copy of the persisted state so that the crashed instance (which keeps running since we
+	// cannot really kill it) cannot overwrite what a restarted instance reads back
+	if cfg.saved[i] != nil {
+		cfg.saved[i] = cfg.saved[i].Copy()
+	}
 }
 
 // Start or re-start an XPaxos server; if one already exists, "kill" it first
+// A re-started server keeps its persisted state and keys
 // Allocate new outgoing port file names to isolate previous instance of this server since we 
 // cannot really kill it
 func (cfg *config) start1(i int) {
@@ -118,21 +219,68 @@ func (cfg *config) start1(i int) {
 	}
 
 	// A fresh set of ClientEnds
-	ends := make([]*network.ClientEnd, cfg.n)
+	ends := make([]network.Transport, cfg.n)
 	for j := 0; j < cfg.n; j++ {
 		ends[j] = cfg.net.MakeEnd(cfg.endnames[i][j])
 		cfg.net.Connect(cfg.endnames[i][j], j)
 	}
 
-	// A fresh pair of RSA private/public keys
-	privateKey, publicKey := generateKeys()
-	cfg.privateKeys[i] = privateKey
-	cfg.publicKeys[i] = publicKey
+	// A pair of private/public keys of the config's scheme for every server, since servers copy the
+	// public keys they are made with (a restarted server keeps its keys, see rotation.go)
+	for j := 1; j < cfg.n; j++ {
+		if cfg.privateKeys[j] == nil {
+			privateKey, publicKey := cfg.keys(j)
+			cfg.privateKeys[j] = privateKey
+			cfg.publicKeys[j] = publicKey
+			cfg.keyHistory[j] = append(cfg.keyHistory[j], publicKey)
+		}
+	}
 
-	xp := Make(ends, i, cfg.privateKeys[i], cfg.publicKeys)
+	// A restarted server reads back the state it persisted before crashing
+	cfg.mu.Lock()
+	if cfg.saved[i] != nil {
+		cfg.saved[i] = cfg.saved[i].Copy()
+	} else {
+		cfg.saved[i] = MakePersister()
+	}
+	persister := cfg.saved[i]
+	cfg.mu.Unlock()
+
+	var xp *XPaxos
+	if cfg.pki || params.pki {
+		var err error
+		xp, err = MakeCertified(ends, i, persister, cfg.signer(i), pooledCA().Root(), cfg.certificates())
+		checkError(err)
+	} else {
+		xp = Make(ends, i, persister, cfg.signer(i), cfg.publicKeys)
+	}
+	xp.clock = cfg.net.GetClock()
+
+	// A fresh state machine, which a restarted server rebuilds from its executed entries
+	machine := statemachine.StateMachine(statemachine.MakeLog())
+	if cfg.makeMachine != nil {
+		machine = cfg.makeMachine()
+	}
+	xp.onCheckpoint = func(server int, checkpoint Checkpoint) { // Checked by the invariant checker
+		if cfg.checker != nil {
+			cfg.checker.checkpointed(server, checkpoint)
+		}
+	}
+	xp.onTruncate = func(server int, entries []CommitLogEntry) { // Archived by the invariant checker
+		if cfg.checker != nil {
+			cfg.checker.truncated(server, entries)
+		}
+	}
+	xp.SetCheckpointInterval(cfg.interval)
+	xp.SetStateMachine(machine)
+	xp.SetSelfCheckInterval(params.selfCheck)
+	xp.SetAuditTrail(cfg.audit || params.audit)
+	xp.SetThresholdShare(cfg.thresholdShare(i))
+	xp.SetPresigners(params.presign)
 
 	cfg.mu.Lock()
 	cfg.xpServers[i] = xp
+	cfg.machines[i] = machine
 	cfg.mu.Unlock()
 
 	svc := network.MakeService(xp)
@@ -141,6 +289,56 @@ func (cfg *config) start1(i int) {
 	cfg.net.AddServer(i, srv)
 }
 
+// Drive every XPaxos server (restarted ones included) with a state machine of makeMachine instead
+// of a log machine; must be called before the servers apply any request
+func (cfg *config) setStateMachines(makeMachine func() statemachine.StateMachine) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	cfg.makeMachine = makeMachine
+	for i := 1; i < cfg.n; i++ {
+		if cfg.xpServers[i] != nil {
+			cfg.machines[i] = makeMachine()
+			cfg.xpServers[i].SetStateMachine(cfg.machines[i])
+		}
+	}
+}
+
+// Crash server i and restart it from its persisted state and keys, then wait until it executed as
+// many requests as the other servers of its synchronous group had when it crashed
+func (cfg *config) crashAndRestart(i int) {
+	target := 0
+	if xp := cfg.xpServers[i]; xp != nil {
+		xp.mu.Lock()
+		view := xp.view
+		xp.mu.Unlock()
+
+		for j := 1; j < cfg.n; j++ {
+			if other := cfg.xpServers[j]; j != i && other != nil {
+				other.mu.Lock()
+				if other.view == view && other.synchronousGroup[i] == true && other.executeSeqNum > target {
+					target = other.executeSeqNum
+				}
+				other.mu.Unlock()
+			}
+		}
+	}
+
+	cfg.crash1(i)
+	cfg.start1(i)
+	cfg.connect(i)
+
+	xp := cfg.xpServers[i]
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, _, executed := xp.CommitLog(); executed >= target {
+			return
+		} else if time.Now().After(deadline) {
+			iPrintf("Restarted XPaxos server (%d) executed %d of %d requests\n", i, executed, target)
+			cfg.t.Fatal("Restarted server failed to catch up!")
+		}
+	}
+}
+
 // Shut down the client server
 func (cfg *config) crashClient() {
 	cfg.disconnect(CLIENT)
@@ -171,13 +369,15 @@ func (cfg *config) startClient() {
 	}
 
 	// A fresh set of ClientEnds
-	ends := make([]*network.ClientEnd, cfg.n)
+	ends := make([]network.Transport, cfg.n)
 	for j := 0; j < cfg.n; j++ {
 		ends[j] = cfg.net.MakeEnd(cfg.endnames[CLIENT][j])
 		cfg.net.Connect(cfg.endnames[CLIENT][j], j)
 	}
 
 	client := MakeClient(ends)
+	client.clock = cfg.net.GetClock()
+	client.SetSlowThreshold(params.slow)
 
 	cfg.mu.Lock()
 	cfg.client = client
@@ -189,7 +389,79 @@ func (cfg *config) startClient() {
 	cfg.net.AddServer(CLIENT, srv)
 }
 
+// Make m more clients (client IDs 1 to m) that share the ends of the client server, i.e. to
+// propose concurrently with cfg.client
+// => Only cfg.client gets the ConfirmVC messages of a view change (the others wait for a reply)
+// => Their proposals are not recorded, so checkLinearizability() ignores them (see
+//    cfg.checkClients())
+func (cfg *config) makeClients(m int) []*Client {
+	clients := make([]*Client, m)
+	for i := 0; i < m; i++ {
+		client := MakeClient(cfg.client.replicas)
+		client.id = i + 1
+		client.clock = cfg.net.GetClock()
+		client.SetSlowThreshold(params.slow)
+		clients[i] = client
+	}
+	return clients
+}
+
+// Every client proposes in a closed loop until it made iters proposals (or, if duration > 0, until
+// duration elapsed); returns the client timestamps the leader replied to by client ID and the
+// results of the replies in the same order, or fails the test if the clients are not done after
+// timeout
+func (cfg *config) contend(clients []*Client, iters int, duration time.Duration,
+	timeout time.Duration) (map[int][]int, map[int][][]byte) {
+	var mu sync.Mutex
+	acked := make(map[int][]int, len(clients))
+	results := make(map[int][][]byte, len(clients))
+	done := make(chan bool, len(clients))
+	start := time.Now()
+
+	for _, client := range clients {
+		acked[client.id] = make([]int, 0)
+
+		go func(client *Client) {
+			for i := 0; (duration > 0 && time.Since(start) < duration) || (duration == 0 && i < iters); i++ {
+				client.mu.Lock()
+				timestamp := client.timestamp
+				client.mu.Unlock()
+
+				if result, ok := client.Execute(fmt.Sprintf("%d-%d", client.id, timestamp)); ok {
+					mu.Lock()
+					acked[client.id] = append(acked[client.id], timestamp)
+					results[client.id] = append(results[client.id], result)
+					mu.Unlock()
+				}
+			}
+			done <- true
+		}(client)
+	}
+
+	deadline := time.After(timeout)
+	for range clients {
+		select {
+		case <-done:
+		case <-deadline:
+			cfg.t.Fatal("Clients did not finish their proposals!")
+		}
+	}
+	return acked, results
+}
+
 func (cfg *config) cleanup() {
+	if params.persistDir != "" { // Before any check fails
+		cfg.writePersisters(params.persistDir)
+	}
+	cfg.checker.stop()
+	cfg.checkInvariants()
+	checkLinearizability(cfg)
+	cfg.checkAgreement()
+
+	if cfg.latencies.Count() > 0 {
+		fmt.Printf("Latency: %s\n", cfg.latencies.Summary())
+	}
+
 	if cfg.client != nil {
 		cfg.client.Kill()
 	}
@@ -203,6 +475,107 @@ func (cfg *config) cleanup() {
 	atomic.StoreInt32(&cfg.done, 1)
 }
 
+// Propose an operation through the client and record its invocation and response in the history
+// => A proposal the leader did not reply to stays pending: it may or may not have been committed
+// => The latency of every proposal (replied to or not) is recorded in cfg.latencies
+// => Returns whether the leader replied
+func (cfg *config) propose(op interface{}) bool {
+	cfg.checkInvariants() // Fail fast
+	return cfg.proposeAndRecord(op)
+}
+
+func (cfg *config) proposeAndRecord(op interface{}) bool {
+	cfg.client.mu.Lock()
+	timestamp := cfg.client.timestamp
+	cfg.client.mu.Unlock()
+
+	id := cfg.history.Invoke(CLIENT, linearizability.KvInput{
+		Op:    linearizability.PUT,
+		Key:   strconv.Itoa(CLIENT),
+		Value: strconv.Itoa(timestamp)})
+
+	cfg.mu.Lock()
+	cfg.proposals[id] = timestamp
+	cfg.mu.Unlock()
+
+	start := time.Now()
+	ok := cfg.client.Propose(op)
+	cfg.latencies.Record(time.Since(start))
+
+	if ok == true {
+		cfg.history.Return(id, nil) // Output is derived from the commit log in checkLinearizability
+	}
+	return ok
+}
+
+// Propose an operation like cfg.propose() but stop waiting for the reply after timeout, i.e. when
+// the network may keep delaying the proposal (and its retransmissions) and the client would wait
+// for it until the network recovers; returns whether the leader replied in time
+func (cfg *config) proposeWithin(op interface{}, timeout time.Duration) bool {
+	cfg.checkInvariants() // Fail fast (from the test's goroutine)
+
+	cfg.client.mu.Lock()
+	timestamp := cfg.client.timestamp
+	cfg.client.mu.Unlock()
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- cfg.proposeAndRecord(op)
+	}()
+
+	// Only give up once the client issued the request, so that an abandoned proposal never races
+	// the next one for its timestamp
+	for issued := false; issued == false; {
+		cfg.client.mu.Lock()
+		issued = cfg.client.timestamp != timestamp
+		cfg.client.mu.Unlock()
+		if issued == false {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	select {
+	case ok := <-done:
+		return ok
+	case <-time.After(timeout):
+		return false // The proposal stays pending in the history
+	}
+}
+
+// Propose null operations with cfg.proposeWithin() until the leader replies to one, at most attempts
+// times, i.e. after a view change where the first proposal may be confirmed by the view change (see
+// Client.ConfirmVC()) or given up on by a leader that is replaced right away
+func (cfg *config) proposeEventually(attempts int, timeout time.Duration) bool {
+	for i := 0; i < attempts; i++ {
+		if cfg.proposeWithin(nil, timeout) {
+			return true
+		}
+	}
+	return false
+}
+
+// Seeded with -seed if set (and with the time otherwise); all randomness of a test derives from it
+// (the test's own choices, network decisions and the seeds of workloads and nemeses), so that a
+// failing test can be re-run with the same random choices
+// => Concurrent RPCs may still be issued in a different order, which is up to the Go scheduler
+func makeRand() *rand.Rand {
+	seed := params.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	iPrintf("Seed: %d\n", seed)
+	return rand.New(rand.NewSource(seed))
+}
+
+// Whether a test loop goes on with iteration i: until iters iterations or, with -duration, until the
+// duration elapses since the config was made
+func (cfg *config) running(i int, iters int) bool {
+	if params.duration > 0 {
+		return time.Since(cfg.start) < params.duration
+	}
+	return i < iters
+}
+
 // Connect server i to the network
 func (cfg *config) connect(i int) {
 	if cfg.connected[i] == false {
@@ -261,6 +634,179 @@ func (cfg *config) rpcCount(server int) int {
 	return cfg.net.GetCount(server)
 }
 
+func (cfg *config) rpcBytes(server int) int64 {
+	return cfg.net.GetBytes(server)
+}
+
+// Total request and reply bytes handled by all servers (client included)
+func (cfg *config) resetStats() {
+	cfg.net.ResetStats()
+}
+
+func (cfg *config) totalBytes() int64 {
+	total := int64(0)
+	for i := 0; i < cfg.n; i++ {
+		total += cfg.rpcBytes(i)
+	}
+	return total
+}
+
+// RPCs issued (including failed ones) and request and reply bytes handled by all servers so far
+func (cfg *config) networkUsage() (int, int64) {
+	stats := cfg.net.Stats()
+
+	rpcs := 0
+	for _, ms := range stats.Methods {
+		rpcs += ms.Count
+	}
+
+	bytes := int64(0)
+	for _, ss := range stats.Servers {
+		bytes += ss.Bytes
+	}
+	return rpcs, bytes
+}
+
+// Start counting the RPCs and bytes that cfg.assertRPCBudget() bounds (unlike cfg.resetStats(),
+// the statistics of the network are kept)
+func (cfg *config) beginRPCBudget() {
+	cfg.budgetRPCs, cfg.budgetBytes = cfg.networkUsage()
+}
+
+// Fail the test if more than maxRPCs RPCs were issued or more than maxBytes bytes were sent since
+// cfg.beginRPCBudget() (0 = no bound), i.e. to enforce the message complexity of a protocol
+func (cfg *config) assertRPCBudget(maxRPCs int, maxBytes int64) {
+	rpcs, bytes := cfg.networkUsage()
+	rpcs -= cfg.budgetRPCs
+	bytes -= cfg.budgetBytes
+
+	if maxRPCs > 0 && rpcs > maxRPCs {
+		cfg.t.Fatalf("RPC budget exceeded: %d RPCs issued (at most %d)!", rpcs, maxRPCs)
+	}
+	if maxBytes > 0 && bytes > maxBytes {
+		cfg.t.Fatalf("RPC budget exceeded: %d bytes sent (at most %d)!", bytes, maxBytes)
+	}
+}
+
+// Drive the network and all protocol timers from clock (i.e. a network.VirtualClock)
+func (cfg *config) setClock(clock network.Clock) {
+	cfg.net.SetClock(clock)
+
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	for i := 1; i < cfg.n; i++ {
+		if cfg.xpServers[i] != nil {
+			cfg.xpServers[i].mu.Lock()
+			cfg.xpServers[i].clock = clock
+			cfg.xpServers[i].mu.Unlock()
+		}
+	}
+
+	if cfg.client != nil {
+		cfg.client.mu.Lock()
+		cfg.client.clock = clock
+		cfg.client.mu.Unlock()
+	}
+}
+
+// Drive the protocol timers of one XPaxos server from clock (i.e. a network.SkewedClock)
+func (cfg *config) setServerClock(server int, clock network.Clock) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if xp := cfg.xpServers[server]; xp != nil {
+		xp.mu.Lock()
+		xp.clock = clock
+		xp.mu.Unlock()
+	}
+}
+
+func (cfg *config) setJitter(jitter time.Duration) {
+	cfg.net.SetJitter(jitter)
+}
+
+func (cfg *config) setTopology(topo *network.Topology) {
+	cfg.net.SetTopology(topo)
+}
+
+func (cfg *config) setCodec(codec network.Codec) {
+	cfg.net.SetCodec(codec)
+}
+
+func (cfg *config) setCompression(threshold int) {
+	cfg.net.SetCompression(threshold)
+}
+
+func (cfg *config) setConcurrency(server int, limit int) {
+	cfg.net.SetConcurrency(server, limit)
+}
+
+func (cfg *config) setSendRate(server int, rate int) {
+	cfg.net.SetSendRate(server, rate)
+}
+
+func (cfg *config) setCorruptionRate(rate int) {
+	cfg.net.SetCorruptionRate(rate)
+}
+
+// Make an XPaxos server follow a Byzantine strategy (see byzantine.go); the test fails if more than
+// t = (n-2)/2 servers would be Byzantine at the same time
+func (cfg *config) setByzantine(server int, strategy int) {
+	numByzantine := 0
+	for i := 1; i < cfg.n; i++ {
+		xp := cfg.xpServers[i]
+		if xp == nil {
+			continue // Crashed
+		}
+		xp.mu.Lock()
+		if i != server && xp.byzantine != HONEST {
+			numByzantine++
+		}
+		xp.mu.Unlock()
+	}
+
+	if strategy != HONEST && numByzantine+1 > (cfg.n-2)/2 {
+		cfg.t.Fatal("Too many Byzantine servers!")
+	}
+
+	xp := cfg.xpServers[server]
+	xp.mu.Lock()
+	xp.byzantine = strategy
+	xp.mu.Unlock()
+}
+
+// Alternate the network between asynchronous periods of length async and synchronous periods of
+// length sync (starting with an asynchronous one) until gst elapses, after which the network stays
+// synchronous; returns the global stabilization time (GST)
+// => Liveness tests check that progress resumes after GST (see network/latency.go)
+func (cfg *config) partialSynchrony(async time.Duration, sync time.Duration, gst time.Duration) time.Time {
+	end := time.Now().Add(gst)
+	cfg.net.SetAsynchronous(true)
+
+	go func() {
+		asynchronous := true
+		for atomic.LoadInt32(&cfg.done) == 0 {
+			period := sync
+			if asynchronous {
+				period = async
+			}
+
+			if remaining := time.Until(end); remaining <= period {
+				time.Sleep(remaining)
+				cfg.net.SetAsynchronous(false)
+				return
+			}
+
+			time.Sleep(period)
+			asynchronous = !asynchronous
+			cfg.net.SetAsynchronous(asynchronous)
+		}
+	}()
+
+	return end
+}
+
 func (cfg *config) setUnreliable(unrel bool) {
 	cfg.net.Reliable(!unrel)
 }
@@ -268,3 +814,434 @@ func (cfg *config) setUnreliable(unrel bool) {
 func (cfg *config) setLongReordering(longrel bool) {
 	cfg.net.LongReordering(longrel)
 }
+
+func (cfg *config) startTrace() *trace {
+	tr := &trace{}
+	tr.cfg = cfg
+	tr.clock = network.MakeVirtualClock()
+	tr.events = cfg.net.Subscribe()
+	tr.steps = make([]string, 0)
+
+	cfg.setClock(tr.clock)
+	cfg.net.Hold(func(svcMeth string, from int, to interface{}) bool { return true })
+	return tr
+}
+
+// Fail the test if the trace differs from the golden file at path (or rewrite it with -update)
+func (cfg *config) compareTrace(tr *trace, path string) {
+	produced := tr.String()
+
+	if params.update {
+		checkError(ioutil.WriteFile(path, []byte(produced), 0644))
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		cfg.t.Fatalf("Golden trace %s missing (run with -args -update)!", path)
+	}
+	golden := string(data)
+
+	if golden == produced {
+		return
+	}
+
+	goldenLines := strings.Split(golden, "\n")
+	producedLines := strings.Split(produced, "\n")
+	for i := 0; i < len(goldenLines) || i < len(producedLines); i++ {
+		want, got := "<end of trace>", "<end of trace>"
+		if i < len(goldenLines) {
+			want = goldenLines[i]
+		}
+		if i < len(producedLines) {
+			got = producedLines[i]
+		}
+		if want != got {
+			fmt.Printf("Trace:\n%s", produced)
+			cfg.t.Fatalf("Trace differs from %s at line %d: expected %q, got %q!", path, i+1, want, got)
+		}
+	}
+}
+
+// Must be called before the servers apply any request to take the same checkpoints
+func (cfg *config) setCheckpointInterval(interval int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	cfg.interval = interval
+	for i := 1; i < cfg.n; i++ {
+		if cfg.xpServers[i] != nil {
+			cfg.xpServers[i].SetCheckpointInterval(interval)
+		}
+	}
+}
+
+// Fail the test unless the checkpoint of seqNum entries becomes stable on every server of the
+// synchronous group within a few seconds
+func (cfg *config) waitForCheckpoint(seqNum int) {
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		stable := true
+		for i := 1; i < cfg.n; i++ {
+			cfg.mu.Lock()
+			xp := cfg.xpServers[i]
+			cfg.mu.Unlock()
+
+			if xp != nil {
+				xp.mu.Lock()
+				stable = stable && (xp.synchronousGroup[xp.id] == false || xp.checkpoint.SeqNum >= seqNum)
+				xp.mu.Unlock()
+			}
+		}
+		if stable {
+			return
+		} else if time.Now().After(deadline) {
+			cfg.t.Fatalf("Checkpoint %d never became stable!", seqNum)
+		}
+	}
+}
+
+// Share of server i, if the servers of the config certify their view changes and commits
+func (cfg *config) thresholdShare(i int) *signing.ThresholdShare {
+	if cfg.threshold == false && params.threshold == false {
+		return nil
+	}
+	return pooledShares(cfg.n)[i-1]
+}
+
+// Also deals the shares to servers restarted later
+func (cfg *config) setThresholdKeys(enabled bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	cfg.threshold = enabled
+	for i := 1; i < cfg.n; i++ {
+		if cfg.xpServers[i] != nil {
+			cfg.xpServers[i].SetThresholdShare(cfg.thresholdShare(i))
+		}
+	}
+}
+
+// Fails the test unless every server establishes its session keys with every other one
+func (cfg *config) establishSessions() {
+	for i := 1; i < cfg.n; i++ {
+		cfg.mu.Lock()
+		xp := cfg.xpServers[i]
+		cfg.mu.Unlock()
+
+		if xp != nil && xp.EstablishSessions() != cfg.n-2 {
+			cfg.t.Fatalf("XPaxos server (%d) failed to establish its session keys!", i)
+		}
+	}
+	cfg.checkSessions()
+}
+
+// Fails the test unless every running server checks the MACs of every other one within a second
+// (offers made in return of others may still be in flight)
+func (cfg *config) checkSessions() {
+	msgDigest := digest("session")
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		cfg.mu.Lock()
+		servers := append([]*XPaxos{}, cfg.xpServers...)
+		cfg.mu.Unlock()
+
+		failed := 0
+		for i := 1; i < cfg.n; i++ {
+			for j := 1; j < cfg.n; j++ {
+				if i != j && servers[i] != nil && servers[j] != nil &&
+					servers[j].checkMAC(i, msgDigest, servers[i].mac(j, msgDigest)) == false {
+					failed = j
+				}
+			}
+		}
+		if failed == 0 {
+			return
+		}
+		if time.Since(start) > time.Second {
+			cfg.t.Fatalf("XPaxos server (%d) rejects the MACs of its session keys!", failed)
+		}
+	}
+}
+
+func (cfg *config) certificates() map[int][]byte {
+	certificates := make(map[int][]byte, cfg.n-1)
+	for j := 1; j < cfg.n; j++ {
+		certificate, err := pooledCA().Issue(j, cfg.publicKeys[j])
+		checkError(err)
+		certificates[j] = certificate
+	}
+	return certificates
+}
+
+func (cfg *config) setPKI(enabled bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	cfg.pki = enabled
+	for i := 1; i < cfg.n; i++ {
+		if xp := cfg.xpServers[i]; xp != nil {
+			xp.keyMu.Lock()
+			xp.root = nil
+			if enabled {
+				xp.root = pooledCA().Root()
+			}
+			xp.keyMu.Unlock()
+		}
+	}
+}
+
+// Also enables (or disables) the trails of servers restarted later
+func (cfg *config) setAuditTrail(enabled bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	cfg.audit = enabled
+	for i := 1; i < cfg.n; i++ {
+		if cfg.xpServers[i] != nil {
+			cfg.xpServers[i].SetAuditTrail(enabled || params.audit)
+		}
+	}
+}
+
+// Returns the new private key of server i once the change is committed (nil if it was not)
+func (cfg *config) rotateKey(i int) crypto.Signer {
+	privateKey, publicKey := generateKeys(cfg.scheme)
+	signer, err := signing.ErasableSigner(privateKey)
+	checkError(err)
+	change, err := cfg.xpServers[i].RotateKey(signer)
+	if err != nil {
+		cfg.t.Fatal(err)
+	}
+	if cfg.pki || params.pki {
+		change.Certificate, err = pooledCA().Issue(i, publicKey)
+		checkError(err)
+	}
+	if cfg.propose(change) == false {
+		return nil
+	}
+
+	cfg.privateKeys[i] = privateKey // For restarts (see start1())
+	cfg.publicKeys[i] = publicKey
+	cfg.keyHistory[i] = append(cfg.keyHistory[i], publicKey)
+	return privateKey
+}
+
+// Fails the test unless every running server of the config takes part in the ceremony successfully;
+// returns their shares (nil for servers that are down)
+func (cfg *config) generateThresholdKeys(ceremony int, bits int) []*signing.ThresholdShare {
+	cfg.mu.Lock()
+	servers := append([]*XPaxos{}, cfg.xpServers...)
+	cfg.mu.Unlock()
+
+	var wg sync.WaitGroup
+	shares := make([]*signing.ThresholdShare, cfg.n)
+	errs := make([]error, cfg.n)
+	for i := 1; i < cfg.n; i++ {
+		if servers[i] != nil {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				shares[i], errs[i] = servers[i].GenerateThresholdKey(ceremony, bits)
+			}(i)
+		}
+	}
+	wg.Wait()
+
+	for i := 1; i < cfg.n; i++ {
+		if errs[i] != nil {
+			cfg.t.Fatalf("XPaxos server (%d) failed its ceremony: %v!", i, errs[i])
+		}
+	}
+	return shares
+}
+
+// Sample memory while a benchmark runs (see memstats/memstats.go), as set by -memsample and -heapdir
+func startMemStats() *memstats.Sampler {
+	return memstats.Start(params.memSample, params.heapDir)
+}
+
+// Report the allocations of a benchmark since startMemStats() per committed operation, to compare
+// the cost of serialization and batching changes
+func reportMemStats(s *memstats.Sampler, committed int, b *testing.B) {
+	sum := s.Stop()
+	b.ReportMetric(sum.AllocsPer(committed), "allocs/commit")
+	b.ReportMetric(sum.BytesPer(committed), "B/commit")
+	b.ReportMetric(float64(sum.PeakHeap), "peak-heap-B")
+}
+
+// Profile the CPU and mutex contention while a benchmark runs (see profiling/profiling.go), as set
+// by -cpudir and -mutexdir; the profiles of a benchmark are named after it
+func startProfiles(b *testing.B) *profiling.Profiler {
+	p, err := profiling.Start(profiling.FileName(params.cpuDir, b.Name(), "cpu"),
+		profiling.FileName(params.mutexDir, b.Name(), "mutex"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return p
+}
+
+func stopProfiles(p *profiling.Profiler, b *testing.B) {
+	if _, err := p.Stop(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func (cfg *config) setFailpoint(server int, point int, fp *failpoint) {
+	cfg.mu.Lock()
+	xp := cfg.xpServers[server]
+	cfg.mu.Unlock()
+
+	xp.setFailpoint(point, fp)
+}
+
+func (cfg *config) failpointHits(server int, point int) int {
+	cfg.mu.Lock()
+	xp := cfg.xpServers[server]
+	cfg.mu.Unlock()
+
+	xp.failMu.Lock()
+	defer xp.failMu.Unlock()
+
+	if fp := xp.failpoints[point]; fp != nil {
+		return fp.hits
+	}
+	return 0
+}
+
+func (cfg *config) startChecker(interval time.Duration) *checker {
+	chk := &checker{}
+	chk.cfg = cfg
+	chk.interval = interval
+	chk.executed = make(map[*XPaxos]int)
+	chk.reference = make([]checkedRequest, 0)
+	chk.hashes = make(map[int][32]byte)
+	chk.chains = make(map[int][32]byte)
+	chk.hashedBy = make(map[int]int)
+	chk.archive = make([]CommitLogEntry, 0)
+	chk.archiving = true
+	chk.done = make(chan bool)
+	chk.stopped = make(chan bool)
+
+	go func() {
+		for {
+			select {
+			case <-chk.done:
+				chk.check()
+				chk.stopped <- true
+				return
+			case <-time.After(chk.interval):
+				chk.check()
+			}
+		}
+	}()
+
+	return chk
+}
+
+// Commit log of xp with the entries it dropped taken from the archive of the checker, from
+// sequence number first+1 on (0 unless the archive misses some of them), and its executeSeqNum
+func (cfg *config) fullCommitLog(xp *XPaxos) ([]CommitLogEntry, int, int) {
+	commitLog, truncated, executed := xp.CommitLog()
+	if truncated == 0 || cfg.checker == nil {
+		return commitLog, truncated, executed
+	}
+
+	cfg.checker.mu.Lock()
+	defer cfg.checker.mu.Unlock()
+
+	if len(cfg.checker.archive) < truncated {
+		return commitLog, truncated, executed
+	}
+	fullLog := make([]CommitLogEntry, 0, truncated+len(commitLog))
+	fullLog = append(fullLog, cfg.checker.archive[:truncated]...)
+	return append(fullLog, commitLog...), 0, executed
+}
+
+// Fail the test if the invariant checker found a violation
+func (cfg *config) checkInvariants() {
+	if violation := cfg.checker.violation(); violation != "" {
+		cfg.t.Fatal(violation)
+	}
+}
+
+// Starts a nemesis that makes a server faulty with one of gens every interval (see nemesis.go)
+func (cfg *config) startNemesis(seed int64, interval time.Duration, gens ...*generator) *nemesis {
+	nem := &nemesis{}
+	nem.cfg = cfg
+	nem.r = rand.New(rand.NewSource(seed))
+	nem.interval = interval
+	nem.generators = gens
+	nem.faults = make([]*nemesisFault, 0)
+	nem.injected = make(map[string]int)
+	nem.done = make(chan bool)
+	nem.stopped = make(chan bool)
+
+	iPrintf("Nemesis: seed (%d)\n", seed)
+
+	go func() {
+		for {
+			select {
+			case <-nem.done:
+				nem.stopped <- true
+				return
+			case <-time.After(nem.interval):
+				nem.step()
+			}
+		}
+	}()
+
+	return nem
+}
+
+// Final analysis phase of a nemesis test, once nem.stop() healed all faults
+func (cfg *config) analyze(nem *nemesis) {
+	// Let the servers settle on a view once all faults are healed
+	if cfg.proposeWithin(nil, 5*time.Second) == false {
+		cfg.t.Fatal("Servers did not recover once all faults were healed!")
+	}
+
+	summary := ""
+	for _, gen := range nem.generators {
+		summary += fmt.Sprintf(" %s=%d", gen.name, nem.injected[gen.name])
+	}
+	fmt.Printf("Nemesis: injected faults:%s\n", summary)
+
+	cfg.checkInvariants()
+	checkLinearizability(cfg)
+	cfg.checkAgreement()
+}
+
+// Executes the events of a scenario at their times from now on (see scenario.go)
+func (cfg *config) startScenario(events []scenarioEvent) *scenario {
+	sc := &scenario{}
+	sc.cfg = cfg
+	sc.events = events
+	sc.finished = make(chan bool)
+
+	go func() {
+		start := time.Now()
+		for _, event := range sc.events {
+			time.Sleep(event.at - time.Since(start))
+			sc.execute(event)
+		}
+		close(sc.finished)
+	}()
+
+	return sc
+}
+
+// Write the persister of every XPaxos server to dir/<test>-<server>.persist and their public keys
+// to dir/<test>.keys
+func (cfg *config) writePersisters(dir string) {
+	checkError(os.MkdirAll(dir, 0755))
+	name := strings.Replace(cfg.t.Name(), "/", "_", -1)
+
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	checkError(WriteKeyHistory(filepath.Join(dir, name+".keys"), cfg.keyHistory))
+	for i := 1; i < cfg.n; i++ {
+		if cfg.saved[i] != nil {
+			checkError(cfg.saved[i].WriteFile(filepath.Join(dir, fmt.Sprintf("%s-%d.persist", name, i))))
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package xpaxos
+
+// Distributed generation of the threshold key of the servers (see signing/dkg.go)
+//
+// share, err := xp.GenerateThresholdKey(ceremony, bits) - Takes part in ceremony, which every server
+//                                                          runs at the same time, and certifies the
+//                                                          view changes of the server with its share
+// cfg.generateThresholdKeys(ceremony, bits)             - Runs a ceremony of all servers of the config
+//
+// => Instead of being dealt their shares (see threshold.go), the servers generate a threshold key
+//    of which any t+1 of the 2t+1 sign (the size of a synchronous group) together, so that no one
+//    ever knows its private exponent, i.e. to bootstrap a deployed cluster (see cmd/xpaxosd)
+// => Messages go over the XPaxos.DKG RPC with the number of their ceremony, which the servers agree
+//    on beforehand; servers keep the messages of ceremonies (or rounds) they did not reach yet, so
+//    that they may join at different times, i.e. as they start
+// => Messages are signed with the current keys of the servers, and a server only keeps those whose
+//    signature verifies, so that a transport that injects messages cannot take the place of one
+// => A server fails its ceremony if it does not get the messages of every server of a round within
+//    DKGTIMEOUT; it keeps its previous share then
+// => A ceremony runs once per server: numbers of ended ones are refused, and their late messages dropped
+// => Shares live in memory only, like dealt ones: a restarted server needs a new ceremony
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"time"
+)
+
+const DKGTIMEOUT = 30 * time.Second // For the messages of all servers of a round of a ceremony
+const DKGTESTBITS = 512             // Modulus size of the threshold keys of ceremonies in tests
+
+var errCeremonyEnded = errors.New("ceremony already taken part in")
+
+type dkgSlot struct {
+	ceremony int
+	round    int
+	from     int
+}
+
+func (xp *XPaxos) GenerateThresholdKey(ceremony int, bits int) (*signing.ThresholdShare, error) {
+	xp.dkgMu.Lock()
+	ended := xp.dkgEnded[ceremony]
+	xp.dkgMu.Unlock()
+	if ended {
+		return nil, fmt.Errorf("ceremony %d of server %d: %w", ceremony, xp.id, errCeremonyEnded)
+	}
+
+	servers := len(xp.replicas) - 1
+	xp.keyMu.Lock()
+	signer := xp.signer
+	publicKeys := make(map[int]crypto.PublicKey, len(xp.publicKeys))
+	for server, publicKey := range xp.publicKeys {
+		publicKeys[server] = publicKey
+	}
+	xp.keyMu.Unlock()
+
+	c, err := signing.MakeCeremony(ceremony, xp.id, (servers-1)/2+1, servers, bits, signer, publicKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer xp.endCeremony(ceremony)
+
+	msgs, err := c.Start()
+	for round := 0; err == nil && len(msgs) > 0; round++ {
+		xp.sendCeremony(msgs)
+		var received []signing.DKGMessage
+		if received, err = xp.awaitCeremony(ceremony, round, servers); err == nil {
+			msgs, err = c.Next(received)
+		}
+	}
+	if err != nil {
+		xp.log().Infof("DKG: ceremony %d: %v", ceremony, err)
+		return nil, fmt.Errorf("ceremony %d of server %d: %w", ceremony, xp.id, err)
+	}
+
+	share := c.Share()
+	xp.log().Infof("DKG: ceremony %d: threshold key of %d bits", ceremony, share.Key.Modulus.BitLen())
+	xp.SetThresholdShare(share)
+	return share, nil
+}
+
+// Sends every message until it is delivered or the round times out (its own to the server itself)
+func (xp *XPaxos) sendCeremony(msgs []signing.DKGMessage) {
+	for _, msg := range msgs {
+		if msg.To == xp.id {
+			xp.keepCeremonyMessage(msg)
+			continue
+		}
+		go func(msg signing.DKGMessage) {
+			for deadline := xp.clock.Now().Add(DKGTIMEOUT); xp.clock.Now().Before(deadline); xp.clock.Sleep(network.DELTA * time.Millisecond) {
+				reply := &Reply{}
+				if xp.replicas[msg.To].Call("XPaxos.DKG", msg, reply, xp.id) && reply.Success {
+					return
+				}
+			}
+			xp.log().Debugf("DKG: round %d not delivered to XPaxos server (%d)", msg.Round, msg.To)
+		}(msg)
+	}
+}
+
+// Messages of all servers of a round, once they all arrived
+func (xp *XPaxos) awaitCeremony(ceremony int, round int, servers int) ([]signing.DKGMessage, error) {
+	timeout := xp.clock.After(DKGTIMEOUT)
+	for {
+		xp.dkgMu.Lock()
+		received := make([]signing.DKGMessage, 0, servers)
+		for from := 1; from <= servers; from++ {
+			if msg, ok := xp.dkgInbox[dkgSlot{ceremony, round, from}]; ok {
+				received = append(received, msg)
+			}
+		}
+		if len(received) == servers {
+			for from := 1; from <= servers; from++ {
+				delete(xp.dkgInbox, dkgSlot{ceremony, round, from})
+			}
+			xp.dkgMu.Unlock()
+			return received, nil
+		}
+		arrived := xp.dkgArrived
+		xp.dkgMu.Unlock()
+
+		select {
+		case <-arrived:
+		case <-timeout:
+			return nil, fmt.Errorf("round %d: messages of %d of %d servers", round, len(received), servers)
+		}
+	}
+}
+
+func (xp *XPaxos) DKG(msg signing.DKGMessage, reply *Reply) {
+	if msg.To != xp.id || msg.From < 1 || msg.From >= len(xp.replicas) ||
+		xp.verify(msg.From, msg.Digest(), msg.Signature) == false {
+		return
+	}
+	xp.keepCeremonyMessage(msg)
+	reply.Success = true
+}
+
+func (xp *XPaxos) keepCeremonyMessage(msg signing.DKGMessage) {
+	xp.dkgMu.Lock()
+	defer xp.dkgMu.Unlock()
+
+	if xp.dkgEnded[msg.Ceremony] {
+		return
+	}
+	xp.dkgInbox[dkgSlot{msg.Ceremony, msg.Round, msg.From}] = msg
+	close(xp.dkgArrived)
+	xp.dkgArrived = make(chan struct{})
+}
+
+// Drops the messages of a ceremony, and those still to come
+func (xp *XPaxos) endCeremony(ceremony int) {
+	xp.dkgMu.Lock()
+	defer xp.dkgMu.Unlock()
+
+	xp.dkgEnded[ceremony] = true
+	for slot := range xp.dkgInbox {
+		if slot.ceremony == ceremony {
+			delete(xp.dkgInbox, slot)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package xpaxos
+
+// Failpoints of XPaxos servers
+//
+// cfg.setFailpoint(server, point, fp) - Arms a failpoint of an XPaxos server (nil disarms it)
+// cfg.failpointHits(server, point)    - Number of times the failpoint was triggered
+//
+// A failpoint is a place in the protocol where a test injects a fault the next few times (or every
+// time) a server gets there, so that narrow races can be triggered without changing the protocol
+// for every experiment:
+// => PREPAREPOINT - The leader sends a prepare message to a follower
+// => COMMITPOINT  - A server sends a commit message to another member of the synchronous group
+// => PERSISTPOINT - A server persists its state (see persister.go)
+//
+// => FAILDROP  - Skip the step: a message is lost (the sender sees the RPC fail) and persisted
+//                state is not written (and so lost on a crash)
+// => FAILDELAY - Delay the step by fp.delay on the server's clock; persistence is delayed while
+//                holding xp.mu, like a slow disk
+//
+// i.e. "drop my next commit" at server 2:
+//      cfg.setFailpoint(2, COMMITPOINT, &failpoint{action: FAILDROP, count: 1})
+//      "delay persistence by 100ms" at server 1:
+//      cfg.setFailpoint(1, PERSISTPOINT, &failpoint{action: FAILDELAY, delay: 100 * time.Millisecond, count: -1})
+//
+// => A restarted server (see cfg.start1()) has no failpoints armed
+
+import (
+	"time"
+)
+
+const ( // Failpoints
+	PREPAREPOINT = iota
+	COMMITPOINT  = iota
+	PERSISTPOINT = iota
+)
+
+const ( // Failpoint actions
+	FAILDROP  = iota
+	FAILDELAY = iota
+)
+
+type failpoint struct {
+	action int
+	delay  time.Duration
+	count  int // Remaining times the failpoint triggers (negative = every time)
+	hits   int // Times the failpoint triggered
+}
+
+func (xp *XPaxos) setFailpoint(point int, fp *failpoint) {
+	xp.failMu.Lock()
+	defer xp.failMu.Unlock()
+
+	if xp.failpoints == nil {
+		xp.failpoints = make(map[int]*failpoint)
+	}
+
+	if fp == nil {
+		delete(xp.failpoints, point)
+	} else {
+		xp.failpoints[point] = fp
+	}
+}
+
+// Whether the step at a failpoint goes ahead (after a delay if the failpoint is armed to delay it)
+// => Uses its own lock since some failpoints are reached while holding xp.mu
+func (xp *XPaxos) reachFailpoint(point int) bool {
+	xp.failMu.Lock()
+	fp := xp.failpoints[point]
+	if fp == nil || fp.count == 0 {
+		xp.failMu.Unlock()
+		return true
+	}
+
+	if fp.count > 0 {
+		fp.count--
+	}
+	fp.hits++
+	action, delay := fp.action, fp.delay
+	xp.failMu.Unlock()
+
+	xp.log().Debugf("Failpoint: reached failpoint (%d)", point)
+
+	switch action {
+	case FAILDROP:
+		return false
+	case FAILDELAY:
+		xp.clock.Sleep(delay)
+	}
+	return true
+}
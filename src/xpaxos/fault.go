@@ -0,0 +1,48 @@
+package xpaxos
+
+// Fault detection of the prepare and commit RPCs of the synchronous group
+//
+// xp.SetFaultTimeout(d) - Bounds the acknowledgement of a prepare or commit by a member (0 = FAULTTIMEOUT)
+//
+// => A prepare or commit RPC that fails (the network gives up after DELTA, see network) suspects
+//    the view at once
+// => A commit whose receiver has not prepared its entry yet is acknowledged with neither success
+//    nor suspicion, and is retransmitted every RETRANSMIT by the goroutine that sent it, until it
+//    is acknowledged, the view changes or the bound passes: the sender then suspects the view,
+//    since a correct member prepares within the bound in a synchronous group, so that an entry
+//    that never commits leads to a view change instead of retransmissions forever
+// => The leader waits for the prepare replies of the group, and a follower for the prepares of
+//    earlier entries and the commits of the group, for the same bound, and suspects the view when
+//    it passes; a member that gave up waiting says so in its reply (TimedOut), and the leader
+//    suspects the view then too, but not on a reply of a member that merely moved to a later view
+// => So do the new leader waiting for the NEW-VIEW replies of its group and a leader waiting for
+//    the read confirmations of its group (see readindex.go)
+// => Handlers wait for the log or the view to change on a channel that every change closes (see
+//    notifyChange()), not by polling, so that their waits only depend on xp.clock
+// => A server suspects a view only once (see issueSuspect()): the SUSPECT messages it sends are
+//    retransmitted every RETRANSMIT to the servers that did not get them while the view lasts
+// => The bound is read by every RPC when it starts, so a change applies to the RPCs sent after it
+
+import (
+	"github.com/csanti/cos518_project/src/network"
+	"time"
+)
+
+const FAULTTIMEOUT = 3 * network.DELTA * time.Millisecond // Default bound on acknowledgements
+const RETRANSMIT = 10 * time.Millisecond                  // Between retransmissions of a commit or a SUSPECT
+
+// Wake the handlers waiting for the log or the view to change; must be called with xp.mu held
+func (xp *XPaxos) notifyChange() {
+	close(xp.changed)
+	xp.changed = make(chan struct{})
+}
+
+func (xp *XPaxos) SetFaultTimeout(d time.Duration) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	if d <= 0 {
+		d = FAULTTIMEOUT
+	}
+	xp.faultTimeout = d
+}
@@ -0,0 +1,379 @@
+package xpaxos
+
+// Table-driven tests of single RPC handlers
+//
+// runHandlerCases(t, cases)           - Applies every case to a fresh XPaxos server and checks it
+// roles(n, view)                      - Leader, follower and a server outside the synchronous group
+// h.prepare(view, seqNum, timestamp)  - Prepare of the leader of view (see also h.commit(), h.suspect())
+// h.newView(view, certified)          - New view of the leader of view, with a threshold certificate or not
+// h.sign(j, msgDigest)                - Signature of XPaxos server j (i.e. to forge a message)
+//
+// A case (handlerCase) is a row of a table: the initial state of one XPaxos server, one incoming
+// message and the expected reply, state and outgoing messages
+// => The peers of the server (and the client) are mocks (see mockEnd) that record the messages
+//    sent to them and answer like correct servers without running anything, so a case exercises
+//    exactly one handler and nothing is ever delivered back to the server
+// => Outgoing messages are listed as "<method> <to>" in sorted order; messages sent after the
+//    handler returned (i.e. suspect messages) are collected until none was sent for HANDLERQUIET
+// => A setup function may change the server directly (under no lock, nothing else runs yet) or
+//    apply earlier messages with h.apply(); messages it causes are not part of the outcome
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const HANDLERQUIET = 50 * time.Millisecond // No message sent for this long means the handler is done
+
+type handlerCase struct {
+	name   string
+	n      int                                 // Total number of client and XPaxos servers (default 4)
+	id     int                                 // XPaxos server handling the message
+	drop   []string                            // Methods whose calls fail (i.e. "XPaxos.Prepare")
+	setup  func(h *handlerHarness)             // Initial state (optional)
+	method string                              // Handler, i.e. "XPaxos.Prepare"
+	msg    func(h *handlerHarness) interface{} // Incoming message
+	reply  Reply                               // Expected Success, IsLeader and Suspicious
+	state  handlerState                        // Expected state after the handler
+	sent   []string                            // Expected outgoing messages
+}
+
+type handlerState struct {
+	view          int
+	prepareSeqNum int
+	executeSeqNum int
+	prepared      int // Length of the prepare log
+	logged        int // Length of the commit log
+	commits       int // Commit messages of the last commit log entry
+}
+
+type handlerHarness struct {
+	mu   sync.Mutex
+	t    *testing.T
+	n    int
+	xp   *XPaxos
+	drop map[string]bool
+	sent []string
+	last time.Time // When the last message was sent
+}
+
+// A peer of the server under test: records every message and answers like a correct server
+type mockEnd struct {
+	h  *handlerHarness
+	to int
+}
+
+var _ network.Transport = &mockEnd{}
+
+func (e *mockEnd) Call(svcMeth string, args interface{}, reply interface{}, callerId int) bool {
+	return e.h.receive(e.to, svcMeth, args, reply)
+}
+
+func (e *mockEnd) CallPriority(svcMeth string, args interface{}, reply interface{}, callerId int, priority int) bool {
+	return e.h.receive(e.to, svcMeth, args, reply)
+}
+
+func (e *mockEnd) CallTimeout(svcMeth string, args interface{}, reply interface{}, callerId int,
+	timeout time.Duration) bool {
+	return e.h.receive(e.to, svcMeth, args, reply)
+}
+
+func (e *mockEnd) Send(svcMeth string, args interface{}, callerId int) {
+	e.h.receive(e.to, svcMeth, args, &Reply{})
+}
+
+func (e *mockEnd) CallStream(svcMeth string, data []byte, chunkSize int, callerId int) bool {
+	return e.h.receive(e.to, svcMeth, data, nil)
+}
+
+func makeHandlerHarness(t *testing.T, n int, id int) *handlerHarness {
+	h := &handlerHarness{}
+	h.t = t
+	h.n = n
+	h.drop = make(map[string]bool, 0)
+	h.sent = make([]string, 0)
+
+	replicas := make([]network.Transport, n)
+	publicKeys := make(map[int]crypto.PublicKey, n)
+	for j := 0; j < n; j++ {
+		replicas[j] = &mockEnd{h: h, to: j}
+		if j != CLIENT {
+			_, publicKeys[j] = pooledKeys(params.scheme, j)
+		}
+	}
+
+	privateKey, _ := pooledKeys(params.scheme, id)
+	h.xp = Make(replicas, id, MakePersister(), signing.KeySigner(privateKey), publicKeys)
+	return h
+}
+
+func (h *handlerHarness) receive(to int, svcMeth string, args interface{}, reply interface{}) bool {
+	h.mu.Lock()
+	h.sent = append(h.sent, fmt.Sprintf("%s %d", svcMeth, to))
+	h.last = time.Now()
+	dropped := h.drop[svcMeth]
+	h.mu.Unlock()
+
+	if dropped {
+		return false
+	}
+
+	r, ok := reply.(*Reply)
+	if ok == false || to == CLIENT {
+		return true
+	}
+
+	switch msg := args.(type) { // Digest the handler of a correct server replies with
+	case PrepareLogEntry:
+		r.MsgDigest = digest(msg.Request)
+		r.Success = true
+		commit := msg.Msg0
+		commit.MsgType = COMMIT
+		r.Commit = h.sign(to, commit.signedDigest())
+	case Message:
+		r.MsgDigest = msg.MsgDigest
+		r.Success = true
+	case SuspectMessage:
+		r.MsgDigest = digest(msg.View)
+	case ViewChangeMessage:
+		r.MsgDigest = digest(msg.View)
+	case VCFinalMessage:
+		r.MsgDigest = digest(msg.View)
+	case NewViewMessage:
+		r.MsgDigest = digest(msg.View)
+	default:
+		return true
+	}
+	r.Signature = h.sign(to, r.MsgDigest)
+	return true
+}
+
+// Delivers msg to the handler method of the server and returns its reply
+func (h *handlerHarness) apply(method string, msg interface{}) Reply {
+	handler := reflect.ValueOf(h.xp).MethodByName(strings.TrimPrefix(method, "XPaxos."))
+	if handler.IsValid() == false {
+		h.t.Fatalf("Unknown handler %s!", method)
+	}
+
+	reply := &Reply{}
+	handler.Call([]reflect.Value{reflect.ValueOf(msg), reflect.ValueOf(reply)})
+	return *reply
+}
+
+// Outgoing messages once none was sent for HANDLERQUIET
+func (h *handlerHarness) quiesce() []string {
+	for {
+		time.Sleep(HANDLERQUIET)
+
+		h.mu.Lock()
+		if time.Since(h.last) >= HANDLERQUIET {
+			sent := append([]string(nil), h.sent...)
+			h.sent = make([]string, 0)
+			h.mu.Unlock()
+
+			sort.Strings(sent)
+			return sent
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *handlerHarness) state() handlerState {
+	h.xp.mu.Lock()
+	defer h.xp.mu.Unlock()
+
+	state := handlerState{
+		view:          h.xp.view,
+		prepareSeqNum: h.xp.prepareSeqNum,
+		executeSeqNum: h.xp.executeSeqNum,
+		prepared:      h.xp.prepareLength(),
+		logged:        h.xp.commitLength()}
+
+	if len(h.xp.commitLog) > 0 {
+		state.commits = len(h.xp.commitLog[len(h.xp.commitLog)-1].Msg1)
+	}
+	return state
+}
+
+// Moves the server to view (as a completed view change would)
+func (h *handlerHarness) setView(view int) {
+	h.xp.view = view
+	h.xp.generateSynchronousGroup(int64(view))
+}
+
+// Logs a prepare of the leader without executing it (as the leader does before its followers reply)
+func (h *handlerHarness) prepared(view int, seqNum int, timestamp int) {
+	prepareEntry := h.prepare(view, seqNum, timestamp)
+	h.xp.prepareSeqNum = seqNum
+	h.xp.prepareLog = append(h.xp.prepareLog, prepareEntry)
+	h.xp.appendToCommitLog(prepareEntry.Request, prepareEntry.Msg0, make(map[int]Message, 0))
+}
+
+// Certifies the view changes of the server with its share of the pooled threshold key
+func (h *handlerHarness) setThresholdKey() {
+	h.xp.thresholdShare = pooledShares(h.n)[h.xp.id-1]
+}
+
+//
+// ----------------------------- MESSAGE BUILDERS -----------------------------
+//
+func (h *handlerHarness) sign(j int, msgDigest [32]byte) []byte {
+	privateKey, _ := pooledKeys(params.scheme, j)
+	signer := &XPaxos{}
+	signer.signer = signing.KeySigner(privateKey)
+	return signer.sign(msgDigest)
+}
+
+func (h *handlerHarness) request(timestamp int) ClientRequest {
+	return ClientRequest{
+		MsgType:   REPLICATE,
+		Timestamp: timestamp,
+		Operation: timestamp,
+		ClientId:  CLIENT,
+		Nonce:     bytes.Repeat([]byte{byte(timestamp)}, NONCESIZE)}
+}
+
+func (h *handlerHarness) prepare(view int, seqNum int, timestamp int) PrepareLogEntry {
+	leader, _, _ := roles(h.n, view)
+	request := h.request(timestamp)
+	msgDigest := digest(request)
+
+	msg := Message{
+		MsgType:         PREPARE,
+		MsgDigest:       msgDigest,
+		PrepareSeqNum:   seqNum,
+		View:            view,
+		ClientTimestamp: timestamp,
+		SenderId:        leader}
+	msg.Signature = h.sign(leader, msg.signedDigest())
+
+	return PrepareLogEntry{
+		Request: request,
+		Msg0:    msg}
+}
+
+func (h *handlerHarness) commit(sender int, view int, seqNum int, timestamp int) Message {
+	msg := Message{
+		MsgType:         COMMIT,
+		MsgDigest:       digest(h.request(timestamp)),
+		PrepareSeqNum:   seqNum,
+		View:            view,
+		ClientTimestamp: timestamp,
+		SenderId:        sender}
+	msg.Signature = h.sign(sender, msg.signedDigest())
+	return msg
+}
+
+func (h *handlerHarness) suspect(sender int, view int) SuspectMessage {
+	msgDigest := digest(view)
+
+	return SuspectMessage{
+		MsgType:   SUSPECT,
+		MsgDigest: msgDigest,
+		Signature: h.sign(sender, msgDigest),
+		View:      view,
+		SenderId:  sender}
+}
+
+func (h *handlerHarness) newView(view int, certified bool) NewViewMessage {
+	leader, _, _ := roles(h.n, view)
+	msgDigest := digest(view)
+
+	msg := NewViewMessage{
+		MsgType:    NEWVIEW,
+		MsgDigest:  msgDigest,
+		Signature:  h.sign(leader, msgDigest),
+		View:       view,
+		PrepareLog: make([]PrepareLogEntry, 0),
+		SenderId:   leader}
+
+	if certified {
+		shares := make([]signing.SignatureShare, 0)
+		for _, thresholdShare := range pooledShares(h.n) { // Any t+1 of them make the certificate
+			share, err := thresholdShare.Sign(msgDigest)
+			if err != nil {
+				h.t.Fatal(err)
+			}
+			shares = append(shares, share)
+		}
+		certificate, err := pooledShares(h.n)[0].Key.Combine(msgDigest, shares)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		msg.Certificate = certificate
+	}
+	return msg
+}
+
+// Leader, follower and a server outside the synchronous group of view (n > 3)
+func roles(n int, view int) (int, int, int) {
+	xp := &XPaxos{}
+	xp.replicas = make([]network.Transport, n)
+	xp.view = view
+	xp.id = xp.getLeader()
+	xp.generateSynchronousGroup(int64(view))
+
+	leader, follower, outsider := xp.id, 0, 0
+	for server := 1; server < n; server++ {
+		if xp.synchronousGroup[server] == false && outsider == 0 {
+			outsider = server
+		} else if xp.synchronousGroup[server] == true && server != leader && follower == 0 {
+			follower = server
+		}
+	}
+	return leader, follower, outsider
+}
+
+//
+// ------------------------------- TABLE RUNNER -------------------------------
+//
+func runHandlerCases(t *testing.T, cases []handlerCase) {
+	for _, c := range cases {
+		n := c.n
+		if n == 0 {
+			n = 4
+		}
+
+		h := makeHandlerHarness(t, n, c.id)
+		if c.setup != nil {
+			c.setup(h)
+			h.quiesce()
+		}
+		for _, method := range c.drop {
+			h.drop[method] = true
+		}
+
+		reply := h.apply(c.method, c.msg(h))
+		sent := h.quiesce()
+		state := h.state()
+
+		if len(reply.Signature) > 0 && h.xp.verify(c.id, reply.MsgDigest, reply.Signature) == false {
+			t.Fatalf("%s: reply not signed by XPaxos server (%d)!", c.name, c.id)
+		}
+		if reply.Success != c.reply.Success || reply.IsLeader != c.reply.IsLeader ||
+			reply.Suspicious != c.reply.Suspicious {
+			t.Fatalf("%s: expected reply (success %t, leader %t, suspicious %t), got (success %t, "+
+				"leader %t, suspicious %t)!", c.name, c.reply.Success, c.reply.IsLeader, c.reply.Suspicious,
+				reply.Success, reply.IsLeader, reply.Suspicious)
+		}
+		if state != c.state {
+			t.Fatalf("%s: expected state %+v, got %+v!", c.name, c.state, state)
+		}
+
+		expected := append([]string(nil), c.sent...)
+		sort.Strings(expected)
+		if strings.Join(sent, ", ") != strings.Join(expected, ", ") {
+			t.Fatalf("%s: expected messages [%s], got [%s]!", c.name, strings.Join(expected, ", "),
+				strings.Join(sent, ", "))
+		}
+	}
+}
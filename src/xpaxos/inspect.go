@@ -0,0 +1,188 @@
+package xpaxos
+
+// Dump of the internal state of an XPaxos server as JSON, for live inspection during long runs
+//
+// xp.Inspect(0, &state)              - RPC returning the server's state (see ServerState)
+// InspectHandler(xp)                 - HTTP handler serving the state as JSON (see cmd/xpaxosd)
+// InspectServer(dir, id, timeout)    - State of server id of the cluster in dir (see cluster.go)
+//
+// => The state holds the view and its synchronous group, the sequence numbers, summaries of both
+//    logs (their lengths, truncated entries included, and last INSPECTTAIL entries), the pending requests (prepared but not
+//    executed yet), the pending reads, the suspect messages received and the progress of a view
+//    change
+// => Entries are summarized by their sequence number, view, client request (client ID, timestamp,
+//    trace ID and digest) and the servers whose commit messages they hold, never by operations,
+//    so a dump stays small and does not leak the data of the service
+// => The state is read with the server's lock held, so it is consistent but inspections should
+//    not be more frequent than every few seconds
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/csanti/cos518_project/src/network"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const INSPECTTAIL = 8 // Last log entries of a log summary
+
+type ServerState struct {
+	Id               int             `json:"id"`
+	View             int             `json:"view"`
+	Leader           int             `json:"leader"`
+	SynchronousGroup []int           `json:"synchronousGroup"`
+	PrepareSeqNum    int             `json:"prepareSeqNum"`
+	ExecuteSeqNum    int             `json:"executeSeqNum"`
+	Applied          int             `json:"applied"`    // Entries applied to the state machine
+	Checkpoint       int             `json:"checkpoint"` // Sequence number of the last checkpoint
+	Truncated        int             `json:"truncated"`  // Entries dropped below the stable checkpoint
+	PrepareLog       LogSummary      `json:"prepareLog"`
+	CommitLog        LogSummary      `json:"commitLog"`
+	Pending          []EntrySummary  `json:"pending"` // Prepared but not executed
+	PendingReads     int             `json:"pendingReads"`
+	ReadsInFlight    bool            `json:"readsInFlight"`
+	Suspected        []Suspicion     `json:"suspected"`
+	ViewChange       ViewChangeState `json:"viewChange"`
+}
+
+type LogSummary struct {
+	Length int            `json:"length"`
+	Tail   []EntrySummary `json:"tail"`
+}
+
+type EntrySummary struct {
+	SeqNum    int    `json:"seqNum"`
+	View      int    `json:"view"`
+	ClientId  int    `json:"clientId"`
+	Timestamp int    `json:"timestamp"`
+	TraceId   string `json:"traceId,omitempty"`
+	Digest    string `json:"digest"`            // Of the request, in hex
+	Commits   []int  `json:"commits,omitempty"` // Senders of the commit messages (commit log)
+}
+
+type Suspicion struct {
+	View     int `json:"view"`
+	SenderId int `json:"senderId"`
+}
+
+type ViewChangeState struct {
+	InProgress  bool `json:"inProgress"`
+	ViewChanges int  `json:"viewChanges"` // View change messages received
+	VCFinals    int  `json:"vcFinals"`    // Servers whose VC-final messages were received
+}
+
+//
+// -------------------------------- INSPECT RPC -------------------------------
+//
+// Served to operators (see InspectServer()), never sent by XPaxos servers
+func (xp *XPaxos) Inspect(args int, reply *ServerState) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	*reply = xp.inspect()
+}
+
+// Must be called with xp.mu held
+func (xp *XPaxos) inspect() ServerState {
+	state := ServerState{}
+	state.Id = xp.id
+	state.View = xp.view
+	state.Leader = xp.getLeader()
+	state.SynchronousGroup = make([]int, 0, len(xp.synchronousGroup))
+	for server, member := range xp.synchronousGroup {
+		if member == true {
+			state.SynchronousGroup = append(state.SynchronousGroup, server)
+		}
+	}
+	sort.Ints(state.SynchronousGroup)
+	state.PrepareSeqNum = xp.prepareSeqNum
+	state.ExecuteSeqNum = xp.executeSeqNum
+	state.Applied = xp.applied
+	state.Checkpoint = xp.checkpoint.SeqNum
+	state.Truncated = xp.truncated
+
+	state.PrepareLog = LogSummary{xp.prepareLength(), make([]EntrySummary, 0)}
+	for i := tail(len(xp.prepareLog)); i < len(xp.prepareLog); i++ {
+		entry := xp.prepareLog[i]
+		state.PrepareLog.Tail = append(state.PrepareLog.Tail,
+			summarize(xp.truncated+i+1, entry.Msg0.View, entry.Request, nil))
+	}
+	state.CommitLog = LogSummary{xp.commitLength(), make([]EntrySummary, 0)}
+	for i := tail(len(xp.commitLog)); i < len(xp.commitLog); i++ {
+		entry := xp.commitLog[i]
+		state.CommitLog.Tail = append(state.CommitLog.Tail,
+			summarize(xp.truncated+i+1, entry.View, entry.Request, entry.Msg1))
+	}
+	state.Pending = make([]EntrySummary, 0)
+	for seqNum := xp.executeSeqNum; seqNum < xp.commitLength(); seqNum++ {
+		entry := xp.commitLog[seqNum-xp.truncated]
+		state.Pending = append(state.Pending, summarize(seqNum+1, entry.View, entry.Request, entry.Msg1))
+	}
+	state.PendingReads = len(xp.pendingReads)
+	state.ReadsInFlight = xp.readsInFlight
+
+	state.Suspected = make([]Suspicion, 0, len(xp.suspectSet))
+	for _, msg := range xp.suspectSet {
+		state.Suspected = append(state.Suspected, Suspicion{msg.View, msg.SenderId})
+	}
+	sort.Slice(state.Suspected, func(i, j int) bool {
+		if state.Suspected[i].View != state.Suspected[j].View {
+			return state.Suspected[i].View < state.Suspected[j].View
+		}
+		return state.Suspected[i].SenderId < state.Suspected[j].SenderId
+	})
+	state.ViewChange = ViewChangeState{xp.vcInProgress, len(xp.vcSet), len(xp.receivedVCFinal)}
+	return state
+}
+
+// First entry of a log summary of a log of length entries
+func tail(length int) int {
+	if length < INSPECTTAIL {
+		return 0
+	}
+	return length - INSPECTTAIL
+}
+
+func summarize(seqNum int, view int, request ClientRequest, commits map[int]Message) EntrySummary {
+	msgDigest := digest(request)
+	entry := EntrySummary{
+		SeqNum:    seqNum,
+		View:      view,
+		ClientId:  request.ClientId,
+		Timestamp: request.Timestamp,
+		TraceId:   request.TraceId,
+		Digest:    hex.EncodeToString(msgDigest[:])}
+
+	for server := range commits {
+		entry.Commits = append(entry.Commits, server)
+	}
+	sort.Ints(entry.Commits)
+	return entry
+}
+
+//
+// ---------------------------------- SERVING ---------------------------------
+//
+func InspectHandler(xp *XPaxos) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := ServerState{}
+		xp.Inspect(0, &state)
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(state)
+	})
+}
+
+// Fails if the server does not reply within timeout
+func InspectServer(dir string, id int, timeout time.Duration) (ServerState, error) {
+	state := ServerState{}
+	end := network.MakeSocketEnd(socketPath(dir, id))
+	if end.CallTimeout("XPaxos.Inspect", 0, &state, CLIENT, timeout) == false {
+		return state, fmt.Errorf("XPaxos server (%d) unreachable", id)
+	}
+	return state, nil
+}
@@ -0,0 +1,188 @@
+package xpaxos
+
+// Prometheus metrics of a deployed XPaxos server (see metrics and cmd/xpaxosd)
+//
+// xp.RegisterMetrics(reg)   - Registers the metrics of the server with reg
+// timedEnds(ends, reg)      - Ends recording the latency of every RPC they send in reg
+// xp.Metrics()              - Registry of a server started by StartReplica() (nil otherwise)
+// xp.Backlog()              - Client requests in Replicate() and commit log entries not executed yet
+//
+// xpaxos_view                          - Current view
+// xpaxos_in_group                      - 1 if the server is in the synchronous group of its view
+// xpaxos_prepare_seqnum                - Last prepared sequence number
+// xpaxos_executed_total                - Executed requests (the commit throughput is its rate)
+// xpaxos_prepare_log_length            - Entries of the prepare log
+// xpaxos_commit_log_length             - Entries of the commit log
+// xpaxos_pending_requests              - Client requests waiting for the server's lock or being
+//                                        replicated (only a leader's grow beyond a few)
+// xpaxos_unexecuted_entries            - Commit log entries not executed yet (a leader's requests
+//                                        waiting for the commits of its group)
+// xpaxos_signatures_total              - Messages signed (signatures per second is its rate)
+// xpaxos_verifications_total           - Signatures verified (not those found in the cache)
+// xpaxos_verify_cache_hits_total       - Signatures found in the verification cache (see verifycache.go)
+// xpaxos_verify_cache_misses_total     - Signatures looked up in the cache, then verified
+// xpaxos_sign_seconds_total            - Time spent signing (its rate over that of the signatures
+//                                        is the mean cost of a signature)
+// xpaxos_verify_seconds_total          - Time spent verifying signatures
+// xpaxos_view_changes_started_total    - View changes started on a suspicion (see vcstats.go)
+// xpaxos_view_changes_total            - View changes completed (their frequency is its rate)
+// xpaxos_view_change_seconds_total     - Time spent in completed view changes (its rate over that
+//                                        of the view changes is their mean duration)
+// xpaxos_last_view_change_seconds      - Duration of the last completed view change
+// xpaxos_view_change_messages_total    - View change messages sent
+// xpaxos_reproposed_total              - Requests re-proposed by new leaders and executed at new views
+// xpaxos_invariant_violations_total   - Distinct violations found by the self-check (see selfcheck.go)
+// xpaxos_rpc_duration_seconds{method}  - Latency of the RPCs sent by the server (timeouts included)
+// xpaxos_rpcs_in_flight{peer}          - RPCs sent to a peer that have not returned yet
+//
+// => Every metric has the constant label server="<id>"; gauges are read with the server's lock
+//    held at every scrape, so scrapes should not be more frequent than every few seconds
+// => The pending requests, unexecuted entries and RPCs in flight are the queues of a server:
+//    when they keep growing during a benchmark, the server is overloaded (in the simulated
+//    network of tests, net.GetUndelivered() is the backlog of every server, see network/stats.go)
+
+import (
+	"github.com/csanti/cos518_project/src/metrics"
+	"github.com/csanti/cos518_project/src/network"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type timedEnd struct {
+	network.Transport
+	peer      string
+	latencies *metrics.HistogramVec
+	inFlight  *metrics.GaugeVec
+}
+
+func (xp *XPaxos) RegisterMetrics(reg *metrics.Registry) {
+	gauge := func(read func() int) func() float64 {
+		return func() float64 {
+			xp.mu.Lock()
+			defer xp.mu.Unlock()
+			return float64(read())
+		}
+	}
+
+	reg.GaugeFunc("xpaxos_view", "Current view.", gauge(func() int { return xp.view }))
+	reg.GaugeFunc("xpaxos_in_group", "Whether the server is in the synchronous group of its view.",
+		gauge(func() int {
+			if xp.synchronousGroup[xp.id] == true {
+				return 1
+			}
+			return 0
+		}))
+	reg.GaugeFunc("xpaxos_prepare_seqnum", "Last prepared sequence number.",
+		gauge(func() int { return xp.prepareSeqNum }))
+	reg.CounterFunc("xpaxos_executed_total", "Executed requests.", gauge(func() int { return xp.executeSeqNum }))
+	reg.GaugeFunc("xpaxos_prepare_log_length", "Entries of the prepare log above the stable checkpoint.",
+		gauge(func() int { return len(xp.prepareLog) }))
+	reg.GaugeFunc("xpaxos_commit_log_length", "Entries of the commit log above the stable checkpoint.",
+		gauge(func() int { return len(xp.commitLog) }))
+	reg.GaugeFunc("xpaxos_pending_requests", "Client requests waiting for the lock or being replicated.",
+		func() float64 { return float64(atomic.LoadInt64(&xp.pending)) })
+	reg.GaugeFunc("xpaxos_unexecuted_entries", "Commit log entries not executed yet.",
+		gauge(func() int { return xp.commitLength() - xp.executeSeqNum }))
+	reg.CounterFunc("xpaxos_signatures_total", "Messages signed.", func() float64 {
+		return float64(atomic.LoadInt64(&xp.signatures))
+	})
+	reg.CounterFunc("xpaxos_verifications_total", "Signatures verified.", func() float64 {
+		return float64(atomic.LoadInt64(&xp.verifications))
+	})
+	reg.CounterFunc("xpaxos_verify_cache_hits_total", "Signatures found in the verification cache.",
+		func() float64 {
+			hits, _ := xp.VerifyCacheStats()
+			return float64(hits)
+		})
+	reg.CounterFunc("xpaxos_verify_cache_misses_total", "Signatures looked up in the verification cache, then verified.",
+		func() float64 {
+			_, misses := xp.VerifyCacheStats()
+			return float64(misses)
+		})
+	vcStat := func(read func(stats ViewChangeStats) float64) func() float64 {
+		return func() float64 {
+			return read(xp.ViewChangeStats())
+		}
+	}
+	reg.CounterFunc("xpaxos_view_changes_started_total", "View changes started on a suspicion.",
+		vcStat(func(stats ViewChangeStats) float64 { return float64(stats.Started) }))
+	reg.CounterFunc("xpaxos_view_changes_total", "View changes completed.",
+		vcStat(func(stats ViewChangeStats) float64 { return float64(stats.Completed) }))
+	reg.CounterFunc("xpaxos_view_change_seconds_total", "Time spent in completed view changes.",
+		vcStat(func(stats ViewChangeStats) float64 { return stats.Duration.Seconds() }))
+	reg.GaugeFunc("xpaxos_last_view_change_seconds", "Duration of the last completed view change.",
+		vcStat(func(stats ViewChangeStats) float64 { return stats.Last.Seconds() }))
+	reg.CounterFunc("xpaxos_view_change_messages_total", "View change messages sent.",
+		vcStat(func(stats ViewChangeStats) float64 { return float64(stats.Messages) }))
+	reg.CounterFunc("xpaxos_reproposed_total", "Requests re-proposed by new leaders and executed at new views.",
+		vcStat(func(stats ViewChangeStats) float64 { return float64(stats.Reproposed) }))
+	reg.CounterFunc("xpaxos_sign_seconds_total", "Time spent signing messages.", func() float64 {
+		sign, _ := xp.CryptoTime()
+		return sign.Seconds()
+	})
+	reg.CounterFunc("xpaxos_verify_seconds_total", "Time spent verifying signatures.", func() float64 {
+		_, verify := xp.CryptoTime()
+		return verify.Seconds()
+	})
+	reg.CounterFunc("xpaxos_invariant_violations_total", "Distinct violations found by the self-check.",
+		gauge(func() int { return len(xp.violations) }))
+}
+
+func (xp *XPaxos) Metrics() *metrics.Registry {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	return xp.registry
+}
+
+func (xp *XPaxos) Backlog() (int, int) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	return int(atomic.LoadInt64(&xp.pending)), xp.commitLength() - xp.executeSeqNum
+}
+
+func timedEnds(ends []network.Transport, reg *metrics.Registry) []network.Transport {
+	latencies := reg.Histogram("xpaxos_rpc_duration_seconds", "Latency of the RPCs sent by the server.",
+		"method", nil)
+	inFlight := reg.GaugeVec("xpaxos_rpcs_in_flight", "RPCs sent to a peer that have not returned yet.", "peer")
+
+	timed := make([]network.Transport, len(ends))
+	for i, end := range ends {
+		timed[i] = &timedEnd{end, strconv.Itoa(i), latencies, inFlight}
+	}
+	return timed
+}
+
+func (end *timedEnd) Call(svcMeth string, args interface{}, reply interface{}, callerId int) bool {
+	defer end.observe(svcMeth, end.start())
+	return end.Transport.Call(svcMeth, args, reply, callerId)
+}
+
+func (end *timedEnd) CallPriority(svcMeth string, args interface{}, reply interface{}, callerId int,
+	priority int) bool {
+	defer end.observe(svcMeth, end.start())
+	return end.Transport.CallPriority(svcMeth, args, reply, callerId, priority)
+}
+
+func (end *timedEnd) CallTimeout(svcMeth string, args interface{}, reply interface{}, callerId int,
+	timeout time.Duration) bool {
+	defer end.observe(svcMeth, end.start())
+	return end.Transport.CallTimeout(svcMeth, args, reply, callerId, timeout)
+}
+
+func (end *timedEnd) CallStream(svcMeth string, data []byte, chunkSize int, callerId int) bool {
+	defer end.observe(svcMeth, end.start())
+	return end.Transport.CallStream(svcMeth, data, chunkSize, callerId)
+}
+
+func (end *timedEnd) start() time.Time {
+	end.inFlight.Add(end.peer, 1)
+	return time.Now()
+}
+
+func (end *timedEnd) observe(svcMeth string, start time.Time) {
+	end.inFlight.Add(end.peer, -1)
+	end.latencies.Observe(svcMeth, time.Since(start))
+}
@@ -0,0 +1,196 @@
+package xpaxos
+
+// Composable fault injection (nemesis) for the test harness
+//
+// nem := cfg.startNemesis(seed, interval, gens...) - Starts injecting a random fault every interval
+// nem.stop()                                     - Stops injecting faults and heals all of them
+// cfg.analyze(nem)                               - Final analysis phase (after nem.stop())
+//
+// A fault generator makes a single XPaxos server faulty in its own way and heals it again; a
+// nemesis composes any number of generators and runs concurrently with the workload (i.e. a
+// workload.Run() of the test). Every interval the nemesis picks a random XPaxos server; if the
+// server is faulty it is healed, otherwise a random generator makes it faulty:
+// => silencer()         - The server fails to send all of its RPCs (as in the full network
+//                         partition tests)
+// => partitioner()      - The links between the server and another XPaxos server are cut in both
+//                         directions
+// => delayer()          - All RPCs from the server are delayed by up to DELTA/2 milliseconds
+// => crasher()          - The server crashes and restarts from its persisted state when healed
+// => clockSkewer()      - The timers of the server run between 2 times slower and 2 times faster
+// => byzantineFlipper() - The server follows a random Byzantine strategy (see byzantine.go)
+//
+// => At most t = (n-2)/2 XPaxos servers are faulty at a time (when the budget is used up a faulty
+//    server is healed instead), so the protocol can always make progress once view changes settle
+// => The sequence of faults only depends on the seed; when they happen relative to the workload
+//    still depends on timing
+// => cfg.analyze() lets the servers settle once all faults are healed and then checks the invariants
+//    (see checker.go), the linearizability of the recorded history and the agreement of the commit
+//    logs, and reports the faults injected by every generator
+
+import (
+	"github.com/csanti/cos518_project/src/network"
+	"math/rand"
+	"time"
+)
+
+type generator struct {
+	name   string
+	inject func(nem *nemesis, fault *nemesisFault) // Makes fault.server faulty
+	heal   func(nem *nemesis, fault *nemesisFault)
+}
+
+type nemesisFault struct {
+	gen    *generator
+	server int
+	peer   int // Other side of a partition
+}
+
+type nemesis struct {
+	cfg        *config
+	r          *rand.Rand
+	interval   time.Duration
+	generators []*generator
+	faults     []*nemesisFault
+	injected   map[string]int // Faults injected by generator name
+	done       chan bool
+	stopped    chan bool
+}
+
+func (nem *nemesis) stop() {
+	nem.done <- true
+	<-nem.stopped
+
+	for len(nem.faults) > 0 {
+		nem.heal(0)
+	}
+}
+
+func (nem *nemesis) step() {
+	server := nem.r.Intn(nem.cfg.n-1) + 1
+
+	for i, fault := range nem.faults {
+		if fault.server == server {
+			nem.heal(i)
+			return
+		}
+	}
+
+	if len(nem.faults) >= (nem.cfg.n-2)/2 {
+		nem.heal(nem.r.Intn(len(nem.faults)))
+		return
+	}
+
+	fault := &nemesisFault{gen: nem.generators[nem.r.Intn(len(nem.generators))], server: server}
+	fault.gen.inject(nem, fault)
+
+	nem.faults = append(nem.faults, fault)
+	nem.injected[fault.gen.name]++
+}
+
+func (nem *nemesis) heal(i int) {
+	fault := nem.faults[i]
+	nem.faults = append(nem.faults[:i], nem.faults[i+1:]...)
+
+	fault.gen.heal(nem, fault)
+}
+
+//
+// ----------------------------- FAULT GENERATORS -----------------------------
+//
+func silencer() *generator {
+	gen := &generator{}
+	gen.name = "silence"
+	gen.inject = func(nem *nemesis, fault *nemesisFault) {
+		iPrintf("Nemesis: silence XPaxos server (%d)\n", fault.server)
+		nem.cfg.net.SetFaultRate(fault.server, 100)
+	}
+	gen.heal = func(nem *nemesis, fault *nemesisFault) {
+		iPrintf("Nemesis: stop silencing XPaxos server (%d)\n", fault.server)
+		nem.cfg.net.SetFaultRate(fault.server, 0)
+	}
+	return gen
+}
+
+func partitioner() *generator {
+	gen := &generator{}
+	gen.name = "partition"
+	gen.inject = func(nem *nemesis, fault *nemesisFault) {
+		fault.peer = nem.r.Intn(nem.cfg.n-2) + 1
+		if fault.peer >= fault.server {
+			fault.peer++
+		}
+		iPrintf("Nemesis: partition XPaxos servers (%d) and (%d)\n", fault.server, fault.peer)
+		nem.cfg.net.EnableLink(fault.server, fault.peer, false)
+		nem.cfg.net.EnableLink(fault.peer, fault.server, false)
+	}
+	gen.heal = func(nem *nemesis, fault *nemesisFault) {
+		iPrintf("Nemesis: heal partition of XPaxos servers (%d) and (%d)\n", fault.server, fault.peer)
+		nem.cfg.net.EnableLink(fault.server, fault.peer, true)
+		nem.cfg.net.EnableLink(fault.peer, fault.server, true)
+	}
+	return gen
+}
+
+func delayer() *generator {
+	gen := &generator{}
+	gen.name = "delay"
+	gen.inject = func(nem *nemesis, fault *nemesisFault) {
+		iPrintf("Nemesis: delay RPCs from XPaxos server (%d)\n", fault.server)
+		delay := network.UniformLatency{Min: 0, Max: network.DELTA / 2 * time.Millisecond}
+		for j := 0; j < nem.cfg.n; j++ {
+			nem.cfg.net.SetLinkLatency(fault.server, j, delay)
+		}
+	}
+	gen.heal = func(nem *nemesis, fault *nemesisFault) {
+		iPrintf("Nemesis: stop delaying RPCs from XPaxos server (%d)\n", fault.server)
+		for j := 0; j < nem.cfg.n; j++ {
+			nem.cfg.net.SetLinkLatency(fault.server, j, nil)
+		}
+	}
+	return gen
+}
+
+func crasher() *generator {
+	gen := &generator{}
+	gen.name = "crash"
+	gen.inject = func(nem *nemesis, fault *nemesisFault) {
+		iPrintf("Nemesis: crash XPaxos server (%d)\n", fault.server)
+		nem.cfg.crash1(fault.server)
+	}
+	gen.heal = func(nem *nemesis, fault *nemesisFault) {
+		iPrintf("Nemesis: restart XPaxos server (%d)\n", fault.server)
+		nem.cfg.start1(fault.server)
+		nem.cfg.connect(fault.server)
+	}
+	return gen
+}
+
+func clockSkewer() *generator {
+	gen := &generator{}
+	gen.name = "skew"
+	gen.inject = func(nem *nemesis, fault *nemesisFault) {
+		rate := 0.5 + 1.5*nem.r.Float64()
+		iPrintf("Nemesis: skew the clock of XPaxos server (%d) by %.2fx\n", fault.server, rate)
+		nem.cfg.setServerClock(fault.server, network.SkewedClock{Base: nem.cfg.net.GetClock(), Rate: rate})
+	}
+	gen.heal = func(nem *nemesis, fault *nemesisFault) {
+		iPrintf("Nemesis: reset the clock of XPaxos server (%d)\n", fault.server)
+		nem.cfg.setServerClock(fault.server, nem.cfg.net.GetClock())
+	}
+	return gen
+}
+
+func byzantineFlipper() *generator {
+	gen := &generator{}
+	gen.name = "byzantine"
+	gen.inject = func(nem *nemesis, fault *nemesisFault) {
+		strategy := nem.r.Intn(LIEVIEW) + 1 // Any strategy but HONEST
+		iPrintf("Nemesis: make XPaxos server (%d) follow Byzantine strategy (%d)\n", fault.server, strategy)
+		nem.cfg.setByzantine(fault.server, strategy)
+	}
+	gen.heal = func(nem *nemesis, fault *nemesisFault) {
+		iPrintf("Nemesis: make XPaxos server (%d) honest again\n", fault.server)
+		nem.cfg.setByzantine(fault.server, HONEST)
+	}
+	return gen
+}
@@ -0,0 +1,304 @@
+package xpaxos
+
+// Stable storage of XPaxos servers
+//
+// persister := MakePersister()            - Creates an empty persister
+// persister.Copy()                        - Snapshot of the persister (what a restarted server reads back)
+// persister.SaveState(data)               - Replaces the persisted state
+// persister.SaveEntry(log, index, data)   - Replaces (or appends) one entry of a persisted log
+// persister.TruncateLog(log, index)       - Drops the entries of a persisted log below index
+// persister.SaveSnapshot(data)            - Replaces the persisted checkpoint (see checkpoint.go)
+// persister.ReadState()                   - Returns the persisted state (nil if nothing was saved)
+// persister.ReadLog(log)                  - Returns the persisted entries of a log (from FirstEntry())
+// persister.FirstEntry(log)               - Index of the first persisted entry of a log
+// persister.ReadSnapshot()                - Returns the persisted checkpoint (nil if none)
+// persister.StateSize()                   - Size of the persisted state and logs in bytes
+//
+// => An XPaxos server persists its view and sequence numbers as its state, and every prepare and
+//    commit log entry on its own (see xp.persist()), whenever they change; Make() reads them back
+// => Entries are persisted one by one so that the common case only rewrites the tail of the logs
+//    (entries below executeSeqNum only change during a view change)
+// => Entries below the stable checkpoint are dropped from the persisted logs, which the
+//    checkpoint's snapshot of the state machine stands in for (see checkpoint.go); the others keep
+//    their indices
+// => Signing keys are kept by the config, not the persister
+// => A restarted server whose persisted state cannot be read back (i.e. a truncated log) logs why
+//    and starts from an empty state, like a server that lost its stable storage, instead of
+//    failing the process; persisting never fails the process either (see errUnreadable)
+// => Suspect and view change messages are not persisted: a restarted server in the middle of a
+//    view change waits for the next suspect (or times out) like a server that missed them
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
+)
+
+const PREPARELOG = "prepareLog" // Names of the persisted logs
+const COMMITLOG = "commitLog"
+const AUDITLOG = "auditLog" // Signed messages of the server (see audit.go)
+
+type Persister struct {
+	mu       sync.Mutex
+	state    []byte
+	logs     map[string][][]byte // Log name -> encoded entries
+	first    map[string]int      // Log name -> index of its first entry
+	snapshot []byte              // Encoded checkpoint
+}
+
+type persistentState struct {
+	View          int
+	PrepareSeqNum int
+	ExecuteSeqNum int
+	PrepareLogLen int // Persisted logs may hold stale entries past these lengths (truncated ones included)
+	CommitLogLen  int
+}
+
+func MakePersister() *Persister {
+	ps := &Persister{}
+	ps.logs = make(map[string][][]byte)
+	ps.first = make(map[string]int)
+	return ps
+}
+
+func (ps *Persister) Copy() *Persister {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	np := MakePersister()
+	np.state = ps.state
+	np.snapshot = ps.snapshot
+	for log, entries := range ps.logs {
+		np.logs[log] = append([][]byte(nil), entries...)
+	}
+	for log, first := range ps.first {
+		np.first[log] = first
+	}
+	return np
+}
+
+func (ps *Persister) SaveState(data []byte) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.state = data
+}
+
+func (ps *Persister) SaveEntry(log string, index int, data []byte) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	index -= ps.first[log]
+	if index < 0 {
+		return // Truncated
+	}
+	for len(ps.logs[log]) <= index {
+		ps.logs[log] = append(ps.logs[log], nil)
+	}
+	ps.logs[log][index] = data
+}
+
+func (ps *Persister) TruncateLog(log string, index int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	drop := index - ps.first[log]
+	if drop <= 0 {
+		return
+	}
+	if drop > len(ps.logs[log]) {
+		drop = len(ps.logs[log])
+	}
+	ps.logs[log] = append([][]byte(nil), ps.logs[log][drop:]...)
+	ps.first[log] = index
+}
+
+func (ps *Persister) SaveSnapshot(data []byte) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.snapshot = data
+}
+
+func (ps *Persister) ReadState() []byte {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	return ps.state
+}
+
+func (ps *Persister) ReadLog(log string) [][]byte {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	return append([][]byte(nil), ps.logs[log]...)
+}
+
+func (ps *Persister) FirstEntry(log string) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	return ps.first[log]
+}
+
+func (ps *Persister) ReadSnapshot() []byte {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	return ps.snapshot
+}
+
+func (ps *Persister) StateSize() int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	size := len(ps.state) + len(ps.snapshot)
+	for _, entries := range ps.logs {
+		for _, data := range entries {
+			size += len(data)
+		}
+	}
+	return size
+}
+
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, fmt.Errorf("encode %T: %w", v, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, v interface{}) error {
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(v); err != nil {
+		return fmt.Errorf("decode %T: %w", v, err)
+	}
+	return nil
+}
+
+// Persist the view, sequence numbers and log entries from index from onwards (from = 0 persists
+// the whole logs, above the truncated entries); must be called with xp.mu held
+func (xp *XPaxos) persist(from int) {
+	if xp.reachFailpoint(PERSISTPOINT) == false {
+		return
+	}
+
+	xp.persister.TruncateLog(PREPARELOG, xp.truncated)
+	xp.persister.TruncateLog(COMMITLOG, xp.truncated)
+
+	if err := xp.persistEntries(from); err != nil { // The persisted state still matches the logs
+		xp.log().Infof("Persist: %v", err)
+		return
+	}
+
+	state := persistentState{
+		View:          xp.view,
+		PrepareSeqNum: xp.prepareSeqNum,
+		ExecuteSeqNum: xp.executeSeqNum,
+		PrepareLogLen: xp.prepareLength(),
+		CommitLogLen:  xp.commitLength()}
+
+	data, err := encode(state)
+	if err != nil {
+		xp.log().Infof("Persist: %v", err)
+		return
+	}
+	xp.persister.SaveState(data)
+}
+
+// Must be called with xp.mu held
+func (xp *XPaxos) persistEntries(from int) error {
+	if from < xp.truncated {
+		from = xp.truncated
+	}
+	for i := from; i < xp.prepareLength(); i++ {
+		data, err := encode(xp.prepareLog[i-xp.truncated])
+		if err != nil {
+			return fmt.Errorf("prepare log entry %d: %w", i+1, err)
+		}
+		xp.persister.SaveEntry(PREPARELOG, i, data)
+	}
+	for i := from; i < xp.commitLength(); i++ {
+		data, err := encode(xp.commitLog[i-xp.truncated])
+		if err != nil {
+			return fmt.Errorf("commit log entry %d: %w", i+1, err)
+		}
+		xp.persister.SaveEntry(COMMITLOG, i, data)
+	}
+	return nil
+}
+
+// Must be called with xp.mu held
+func (xp *XPaxos) persistSnapshot(checkpoint Checkpoint) {
+	data, err := encode(checkpoint)
+	if err != nil {
+		xp.log().With("seqNum", checkpoint.SeqNum).Infof("Persist: checkpoint: %v", err)
+		return
+	}
+	xp.persister.SaveSnapshot(data)
+}
+
+// Leaves the server untouched if the persisted state cannot be read back; must be called with
+// xp.mu held
+func (xp *XPaxos) readPersist() error {
+	data := xp.persister.ReadState()
+	if len(data) == 0 {
+		return nil
+	}
+
+	state := persistentState{}
+	if err := decode(data, &state); err != nil {
+		return fmt.Errorf("%w: %v", errUnreadable, err)
+	}
+
+	checkpoint := Checkpoint{}
+	snapshot := xp.persister.ReadSnapshot()
+	if len(snapshot) > 0 {
+		if err := decode(snapshot, &checkpoint); err != nil {
+			return fmt.Errorf("%w: checkpoint: %v", errUnreadable, err)
+		}
+	}
+	first := xp.persister.FirstEntry(COMMITLOG)
+	if xp.persister.FirstEntry(PREPARELOG) != first || checkpoint.SeqNum < first {
+		return fmt.Errorf("%w: logs truncated at %d and %d, checkpoint %d", errUnreadable,
+			xp.persister.FirstEntry(PREPARELOG), first, checkpoint.SeqNum)
+	}
+
+	persisted := xp.persister.ReadLog(PREPARELOG)
+	if state.PrepareLogLen < first || state.PrepareLogLen-first > len(persisted) {
+		return fmt.Errorf("%w: %d prepare log entries persisted instead of %d", errUnreadable, len(persisted),
+			state.PrepareLogLen-first)
+	}
+	prepareLog := make([]PrepareLogEntry, state.PrepareLogLen-first)
+	for i := range prepareLog {
+		if err := decode(persisted[i], &prepareLog[i]); err != nil {
+			return fmt.Errorf("%w: prepare log entry %d: %v", errUnreadable, first+i+1, err)
+		}
+	}
+
+	persisted = xp.persister.ReadLog(COMMITLOG)
+	if state.CommitLogLen < first || state.CommitLogLen-first > len(persisted) {
+		return fmt.Errorf("%w: %d commit log entries persisted instead of %d", errUnreadable, len(persisted),
+			state.CommitLogLen-first)
+	}
+	commitLog := make([]CommitLogEntry, state.CommitLogLen-first)
+	for i := range commitLog {
+		if err := decode(persisted[i], &commitLog[i]); err != nil {
+			return fmt.Errorf("%w: commit log entry %d: %v", errUnreadable, first+i+1, err)
+		}
+		if commitLog[i].Msg1 == nil { // Gob does not send empty maps
+			commitLog[i].Msg1 = make(map[int]Message, 0)
+		}
+	}
+
+	xp.view = state.View
+	xp.prepareSeqNum = state.PrepareSeqNum
+	xp.executeSeqNum = state.ExecuteSeqNum
+	xp.prepareLog = prepareLog
+	xp.commitLog = commitLog
+	xp.truncated = first
+	xp.checkpoint = checkpoint
+	xp.truncate(checkpoint.SeqNum) // Crashed before it persisted the truncation
+	return nil
+}
@@ -0,0 +1,177 @@
+package xpaxos
+
+// Signatures of the leader computed off its critical path, on a pool of crypto goroutines
+//
+// xp.SetPresigners(k)                  - Signs the messages of client requests on k goroutines (0 = inline)
+// xp.PresignStats()                    - Prepares presigned for the sequence number they got (hits) or not (misses)
+// pending := xp.presign(digest)        - Starts signing digest, before the server takes its lock
+// signature := pending.wait()          - The signature, once it is needed under the lock
+// prepare := xp.presignPrepare(digest, now) - Starts signing the prepare of a request at the sequence number it expects
+// xp.signPrepare(prepare, msg)         - The signature and commit share of the prepare msg, under the lock
+//
+// => The reply of the leader signs the digest of its request only (not its view or sequence
+//    number), so Replicate() hands the digest to the pool as soon as the request arrives: while
+//    request N holds the lock or its prepares are in flight, the signature of the reply to request
+//    N+1 is computed beside it, and the leader finds it ready once it gets the lock
+// => Its prepare message (and its share of the commit certificate, see threshold.go) signs the view
+//    and sequence number as well (see signedDigest()), which are only assigned under the lock, so
+//    the request guesses them when it arrives: the view of the latest prepare and the sequence
+//    number after it, plus the requests that arrived before it and are still waiting for the lock
+//    (presignMu guards the guess, never xp.mu); the threshold share of the server is guessed the
+//    same way, as presigners may not read it off the lock
+// => Clients send their requests to every server, so only a server that led the view at the latest
+//    request presigns prepares; followers sign the reply only
+// => Under the lock, a prepare that got the view, sequence number and threshold share its request
+//    guessed takes the presigned signature and share, and any other prepare is signed there as
+//    before (i.e. the first request of a view, or requests that got the lock out of order), so a
+//    wrong guess only costs the signatures wasted on it
+// => The pool has a queue of PRESIGNQUEUE jobs; when it is full, wait() signs inline like a
+//    server without presigners, so a burst never waits behind the queue for longer than it takes
+//    to sign
+// => With no presigners (the default) wait() signs under the lock as before and nothing is
+//    guessed, so the order of the messages of a server, which golden traces compare (see
+//    trace.go), stays the same
+// => Presigners sign through xp.sign(), xp.signUnaudited() and xp.signCommitShareWith(), so their
+//    signatures are counted, timed and made with the current key of the server like every other
+//    one; xp.Kill() stops them
+// => Replies are archived in the audit trail (see audit.go) as they are signed, since every request
+//    gets one, but a presigned prepare only once it is sent, so a wrong guess does not leave two
+//    signed prepares of one view and sequence number in the trail
+
+import (
+	"github.com/csanti/cos518_project/src/signing"
+	"sync/atomic"
+)
+
+const PRESIGNQUEUE = 64 // Jobs waiting for a presigner
+
+type presignJob struct {
+	sign func() []byte
+	done chan []byte
+}
+
+type pendingSignature struct {
+	sign func() []byte
+	done chan []byte // Signature of a presigner (nil = signed by wait())
+}
+
+type pendingPrepare struct {
+	view           int                     // View the request expects its prepare to be signed in
+	seqNum         int                     // Sequence number it expects
+	thresholdShare *signing.ThresholdShare // Share of the server it expects (nil = none)
+	time           int64                   // Leader's time of the entry, when the request arrived
+	presigned      bool                    // False without presigners: the prepare is signed under the lock
+	signature      pendingSignature
+	share          pendingSignature // Of the commit certificate (nil without a threshold key)
+}
+
+// Replaces the presigners of the server with k fresh ones
+func (xp *XPaxos) SetPresigners(k int) {
+	xp.presignMu.Lock()
+	defer xp.presignMu.Unlock()
+
+	if xp.presignJobs != nil {
+		close(xp.presignJobs) // Presigners sign the jobs queued so far, then stop
+		xp.presignJobs = nil
+	}
+	if k <= 0 {
+		return
+	}
+
+	jobs := make(chan presignJob, PRESIGNQUEUE)
+	xp.presignJobs = jobs
+	for i := 0; i < k; i++ {
+		go func() {
+			for job := range jobs {
+				job.done <- job.sign()
+			}
+		}()
+	}
+}
+
+func (xp *XPaxos) PresignStats() (int64, int64) {
+	return atomic.LoadInt64(&xp.presignHits), atomic.LoadInt64(&xp.presignMisses)
+}
+
+func (xp *XPaxos) presign(msgDigest [32]byte) pendingSignature {
+	xp.presignMu.Lock()
+	defer xp.presignMu.Unlock()
+
+	return xp.enqueuePresign(func() []byte { return xp.sign(msgDigest) })
+}
+
+// Must be called with xp.presignMu held
+func (xp *XPaxos) enqueuePresign(sign func() []byte) pendingSignature {
+	pending := pendingSignature{sign: sign}
+	if xp.presignJobs == nil {
+		return pending
+	}
+	done := make(chan []byte, 1)
+	select {
+	case xp.presignJobs <- presignJob{sign, done}:
+		pending.done = done
+	default: // Queue full
+	}
+	return pending
+}
+
+func (pending pendingSignature) wait() []byte {
+	if pending.done == nil {
+		return pending.sign()
+	}
+	return <-pending.done
+}
+
+// Must be followed by xp.claimPrepare() once the request gets the lock
+func (xp *XPaxos) presignPrepare(msgDigest [32]byte, now int64) pendingPrepare {
+	xp.presignMu.Lock()
+	defer xp.presignMu.Unlock()
+
+	xp.presignWaiting++
+	pending := pendingPrepare{
+		view:           xp.presignView,
+		seqNum:         xp.presignSeqNum + xp.presignWaiting,
+		thresholdShare: xp.presignShare,
+		time:           now}
+	if xp.presignJobs == nil || xp.presignLeader == false {
+		return pending
+	}
+
+	msg := Message{MsgType: PREPARE, MsgDigest: msgDigest, View: pending.view, PrepareSeqNum: pending.seqNum,
+		Time: pending.time}
+	thresholdShare := pending.thresholdShare
+	pending.presigned = true
+	pending.signature = xp.enqueuePresign(func() []byte { return xp.signUnaudited(msg.signedDigest()) })
+	pending.share = xp.enqueuePresign(func() []byte { return xp.signCommitShareWith(thresholdShare, msg) })
+	return pending
+}
+
+// The request got the lock: the requests after it expect the sequence number after the next one,
+// which it takes if it is prepared; must be called with xp.mu held
+func (xp *XPaxos) claimPrepare() {
+	xp.presignMu.Lock()
+	defer xp.presignMu.Unlock()
+
+	xp.presignWaiting--
+	xp.presignView = xp.view
+	xp.presignSeqNum = xp.prepareSeqNum + 1
+	xp.presignShare = xp.thresholdShare
+	xp.presignLeader = xp.id == xp.getLeader() && xp.vcInProgress == false
+}
+
+// Must be called with xp.mu held
+func (xp *XPaxos) signPrepare(pending pendingPrepare, msg Message) ([]byte, []byte) {
+	if pending.presigned && pending.view == msg.View && pending.seqNum == msg.PrepareSeqNum &&
+		pending.thresholdShare == xp.thresholdShare {
+		atomic.AddInt64(&xp.presignHits, 1)
+		signature := pending.signature.wait()
+		if signature != nil {
+			xp.audit(messageEntry(msg, signature))
+		}
+		return signature, pending.share.wait()
+	}
+	if pending.presigned {
+		atomic.AddInt64(&xp.presignMisses, 1)
+	}
+	return xp.signMessage(msg), xp.signCommitShare(msg)
+}
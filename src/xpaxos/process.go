@@ -0,0 +1,144 @@
+package xpaxos
+
+// Multi-process cluster mode of the test harness
+//
+// cl := makeProcessCluster(t, n) - Spawns n-1 XPaxos servers as OS processes (client in the test)
+// cl.kill(i)                     - Kills the process of XPaxos server i (SIGKILL)
+// cl.start1(i)                   - Spawns (or re-spawns) the process of XPaxos server i
+// cl.propose(op, timeout)        - Proposes through the client; false if not replied to in time
+// cl.cleanup()                   - Kills all processes
+//
+// Every XPaxos server runs in its own process and talks to its peers and the client over Unix
+// sockets (see network/socket.go), so a crash is a real process kill and servers share no memory
+// => A server process is the test binary itself re-run with -test.run=TestReplicaProcess and the
+//    environment variables REPLICAENV (its ID) and CLUSTERENV (the cluster's directory, holding
+//    the sockets, the RSA keys generated by the test and their certificates, see cluster.go)
+// => A server process exits by itself once the test process that spawned it is gone
+// => State is persisted in memory only, so a re-spawned server starts from scratch
+// => None of the simulated network's faults apply (and there is no config); use cl.kill() to
+//    inject crashes
+
+import (
+	"fmt"
+	"github.com/csanti/cos518_project/src/debug"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const REPLICAENV = "XPAXOS_REPLICA"
+const CLUSTERENV = "XPAXOS_CLUSTER"
+
+type processCluster struct {
+	t            *testing.T
+	n            int    // Total number of client and XPaxos servers
+	dir          string // Sockets and keys
+	procs        []*exec.Cmd
+	client       *Client
+	clientSocket *network.SocketServer
+}
+
+func socketPath(dir string, server int) string {
+	return fmt.Sprintf("%s/%d.sock", dir, server)
+}
+
+func makeProcessCluster(t *testing.T, n int) *processCluster {
+	cl := &processCluster{}
+	n, _ = params.apply(n, false)
+	cl.t = t
+	cl.n = n
+	cl.dir = t.TempDir()
+	cl.procs = make([]*exec.Cmd, cl.n)
+
+	keys := make(map[int][]byte, cl.n) // PKCS #8 encoded private keys of all XPaxos servers
+	for i := 1; i < cl.n; i++ {
+		privateKey, _ := pooledKeys(params.scheme, i)
+		key, err := signing.MarshalPrivateKey(privateKey)
+		checkError(err)
+		keys[i] = key
+	}
+	checkError(writeClusterKeys(cl.dir, keys))
+	checkError(writeClusterCertificates(cl.dir, pooledCA()))
+
+	cl.client = MakeClient(clusterEnds(cl.dir, cl.n))
+
+	var err error
+	srv := network.MakeServer()
+	srv.AddService(network.MakeService(cl.client))
+	cl.clientSocket, err = network.ServeSocket(socketPath(cl.dir, CLIENT), srv)
+	checkError(err)
+
+	for i := 1; i < cl.n; i++ {
+		cl.start1(i)
+	}
+
+	return cl
+}
+
+func (cl *processCluster) start1(i int) {
+	cl.kill(i)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestReplicaProcess$", "-test.timeout=0")
+	cmd.Env = append(os.Environ(), REPLICAENV+"="+strconv.Itoa(i), CLUSTERENV+"="+cl.dir,
+		debug.DEBUGENV+"="+debug.String()) // Same verbosity as the test (i.e. set by -debug)
+	if debug.Level(debug.XPAXOS) > debug.NONE {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+	checkError(cmd.Start())
+	cl.procs[i] = cmd
+
+	// Wait for the server to listen on its socket
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if network.MakeSocketEnd(socketPath(cl.dir, i)).Call("XPaxos.Ping", 0, &Reply{}, CLIENT) {
+			return
+		} else if time.Now().After(deadline) {
+			cl.t.Fatalf("XPaxos server process (%d) did not start!", i)
+		}
+	}
+}
+
+func (cl *processCluster) kill(i int) {
+	if cmd := cl.procs[i]; cmd != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		cl.procs[i] = nil
+	}
+}
+
+func (cl *processCluster) propose(op interface{}, timeout time.Duration) bool {
+	done := make(chan bool, 1)
+	go func() {
+		done <- cl.client.Propose(op)
+	}()
+
+	select {
+	case ok := <-done:
+		return ok
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (cl *processCluster) cleanup() {
+	for i := 1; i < cl.n; i++ {
+		cl.kill(i)
+	}
+	cl.clientSocket.Close()
+	cl.client.Kill()
+}
+
+// Body of a server process (see TestReplicaProcess); never returns
+func runReplica(id int, dir string) {
+	_, _, err := StartReplica(dir, id, nil, 0)
+	checkError(err)
+
+	for parent := os.Getppid(); os.Getppid() == parent; {
+		time.Sleep(100 * time.Millisecond)
+	}
+	os.Exit(0) // The test process is gone
+}
@@ -0,0 +1,232 @@
+package xpaxos
+
+// Read-index reads: linearizable reads served from the leader's state machine without log entries
+//
+// client.Read(op)     - Reads through the leader (nil, false if none served it)
+// xp.ReadRounds()     - Confirmation rounds run by this server so far (e.g. for tests)
+//
+// => The leader takes every read it receives as pending at its read index, the number of entries
+//    executed so far, which covers every request it replied to; it then confirms with every other
+//    member of its synchronous group that they are still in its view, and once they all did,
+//    serves the pending reads from its state machine (which has applied up to the read index)
+// => One confirmation round serves every read pending when the round began, and reads that
+//    arrive during a round wait for the next one, so a burst of reads costs a single round
+// => A follower that confirmed the view did not join a view change before the confirmation, so no
+//    new view had committed requests the leader did not execute by then
+// => Confirmations are signed digests of the view and the leader's round, so a reply of an earlier
+//    round cannot confirm a later one
+// => The state machine must implement statemachine.Reader; servers without one, and leaders whose
+//    confirmation fails reply without success, and the client falls back to its caller (i.e. the
+//    clerk retries through the log, see kvservice)
+// => Followers and a leader whose view change is in progress (which may install another leader
+//    and committed requests it did not execute) reply as no leader, like Replicate(); once every
+//    server replied so, the client falls back to its caller as well, instead of waiting for a
+//    leader (WAIT)
+// => A member that does not confirm within the fault bound is suspected (see fault.go)
+
+import (
+	"bytes"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"time"
+)
+
+type pendingRead struct {
+	op     []byte
+	index  int // Executed entries when the read arrived
+	result chan []byte
+}
+
+type readConfirmation struct {
+	View  int
+	Round int
+}
+
+//
+// -------------------------------- READ RPC ----------------------------------
+//
+func (client *Client) sendRead(server int, request ClientRequest, reply *Reply) bool {
+	client.log().With("trace", request.TraceId).Debugf("Read: to XPaxos server (%d)", server)
+	return client.replicas[server].Call("XPaxos.Read", request, reply, CLIENT)
+}
+
+// Sends nil if the server did not reply
+func (client *Client) issueRead(server int, request ClientRequest, replyCh chan *Reply) {
+	reply := &Reply{}
+
+	if ok := client.sendRead(server, request, reply); ok == false {
+		reply = nil
+	}
+	replyCh <- reply
+}
+
+// Returns the result of the leader's state machine and whether the leader served the read
+func (client *Client) Read(op interface{}) ([]byte, bool) {
+	var timer <-chan time.Time
+
+	client.mu.Lock()
+	request := ClientRequest{
+		MsgType:   REPLICATE,
+		Operation: op,
+		ClientId:  client.id,
+		TraceId:   network.NewTraceId()}
+
+	replyCh := make(chan *Reply, len(client.replicas))
+	for server, _ := range client.replicas {
+		if server != CLIENT {
+			go client.issueRead(server, request, replyCh)
+		}
+	}
+	numReplies := len(client.replicas) - 1
+
+	if WAIT == false {
+		timer = client.clock.After(TIMEOUT * time.Millisecond)
+	}
+	client.mu.Unlock()
+
+	for i := 0; i < numReplies; i++ {
+		select {
+		case <-timer:
+			client.log().With("trace", request.TraceId).Infof("Timeout: Client.Read")
+			return nil, false
+		case reply := <-replyCh:
+			if reply == nil || reply.IsLeader == false {
+				continue
+			}
+			if reply.Success == true {
+				return reply.Result, true
+			}
+			client.log().With("trace", request.TraceId).Infof("Failure: leader could not confirm its view for a read")
+			return nil, false
+		}
+	}
+	client.log().With("trace", request.TraceId).Infof("Failure: no leader of an installed view for a read")
+	return nil, false
+}
+
+func (xp *XPaxos) Read(request ClientRequest, reply *Reply) {
+	xp.mu.Lock()
+	if xp.id != xp.getLeader() || xp.vcInProgress == true { // Not the leader of an installed view
+		xp.mu.Unlock()
+		return
+	}
+	reply.IsLeader = true
+
+	reader, ok := xp.stateMachine.(statemachine.Reader)
+	if ok == false {
+		xp.mu.Unlock()
+		return
+	}
+
+	read := pendingRead{
+		op:     statemachine.Encode(request.Operation),
+		index:  xp.executeSeqNum,
+		result: make(chan []byte, 1)}
+	xp.pendingReads = append(xp.pendingReads, read)
+	if xp.readsInFlight == false {
+		xp.readsInFlight = true
+		go xp.confirmReads(reader)
+	}
+	xp.mu.Unlock()
+
+	result, ok := <-read.result
+	reply.Result = result
+	reply.Success = ok
+}
+
+//
+// ---------------------------- CONFIRM VIEW RPC ------------------------------
+//
+func (xp *XPaxos) sendConfirmView(server int, msg Message, reply *Reply) bool {
+	xp.log().With("view", msg.View).Debugf("ConfirmView: to XPaxos server (%d)", server)
+	return xp.replicas[server].Call("XPaxos.ConfirmView", msg, reply, xp.id)
+}
+
+func (xp *XPaxos) issueConfirmView(server int, msg Message, replyCh chan bool) {
+	reply := &Reply{}
+
+	if ok := xp.sendConfirmView(server, msg, reply); ok {
+		if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) == 0 && reply.Success == true &&
+			xp.verify(server, reply.MsgDigest, reply.Signature) == true {
+			replyCh <- true
+			return
+		}
+	}
+	replyCh <- false
+}
+
+func (xp *XPaxos) ConfirmView(msg Message, reply *Reply) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	if xp.view != msg.View || xp.vcInProgress == true || msg.SenderId != xp.getLeader() ||
+		xp.verify(msg.SenderId, msg.MsgDigest, msg.Signature) == false {
+		return
+	}
+
+	reply.MsgDigest = msg.MsgDigest
+	reply.Signature = xp.sign(msg.MsgDigest)
+	reply.Success = true
+}
+
+// Confirm the view for the pending reads and serve them, until no read is pending
+func (xp *XPaxos) confirmReads(reader statemachine.Reader) {
+	for {
+		xp.mu.Lock()
+		reads := xp.pendingReads
+		xp.pendingReads = nil
+		if len(reads) == 0 {
+			xp.readsInFlight = false
+			xp.mu.Unlock()
+			return
+		}
+
+		xp.readRounds++
+		view := xp.view
+		msgDigest := digest(readConfirmation{view, xp.readRounds})
+		msg := Message{
+			MsgType:   READINDEX,
+			MsgDigest: msgDigest,
+			Signature: xp.sign(msgDigest),
+			View:      view,
+			SenderId:  xp.id}
+
+		numReplies := len(xp.synchronousGroup) - 1
+		replyCh := make(chan bool, numReplies)
+		for server, _ := range xp.synchronousGroup {
+			if server != xp.id {
+				go xp.issueConfirmView(server, msg, replyCh)
+			}
+		}
+		timeout := xp.faultTimeout
+		xp.mu.Unlock()
+
+		confirmed := true
+		timer := xp.clock.After(timeout)
+		for i := 0; i < numReplies && confirmed; i++ {
+			select {
+			case <-timer: // A member did not confirm within the bound (see fault.go)
+				confirmed = false
+				go xp.issueSuspect(view)
+			case ok := <-replyCh:
+				confirmed = ok
+			}
+		}
+
+		xp.mu.Lock()
+		for _, read := range reads {
+			if confirmed == true && xp.view == view && xp.vcInProgress == false && xp.applied >= read.index {
+				read.result <- reader.Read(read.op)
+			}
+			close(read.result)
+		}
+		xp.mu.Unlock()
+	}
+}
+
+func (xp *XPaxos) ReadRounds() int {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	return xp.readRounds
+}
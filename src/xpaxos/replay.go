@@ -0,0 +1,294 @@
+package xpaxos
+
+// Offline replay of the persisted state of an XPaxos server (post-mortem analysis)
+//
+// persister.WriteFile(path)              - Writes the persisted state, logs and checkpoint to a file
+// ReadPersister(path)                    - Reads back a persister written by WriteFile()
+// Replay(persister, sm, publicKeys)      - Replays the persisted commit log against sm
+// WritePublicKeys(path, publicKeys)      - Writes the public keys of the servers to a file
+// ReadPublicKeys(path)                   - Reads back the current public keys of a file
+// WriteKeyHistory(path, keyHistory)      - Writes every public key of the servers to a file (see audit.go)
+// ReadKeyHistory(path)                   - Reads back every public key of a file
+// cfg.writePersisters(dir)               - Writes the persister and public keys of every XPaxos server
+//
+// => Replay() restores sm from the persisted checkpoint (if any) and applies the executed commit
+//    log entries above it exactly like the server did (see applyExecuted()), so sm ends up in the
+//    state the server's state machine was in when the persister was written
+// => Every commit log entry is verified on the way: the request must match the digest the leader
+//    prepared, and, given the public keys of the servers, the prepare and commit messages must
+//    carry valid signatures of their senders; problems are reported, not fatal, so a corrupted log
+//    still replays to the end
+// => The entries below the checkpoint were truncated (see checkpoint.go), so Replay() verifies
+//    the checkpoint instead: its certificate must hold the signatures of t+1 servers and it must
+//    be signed by the server that took it; a prepare message re-issued by the leader of a later
+//    view (see VCFinal()) is signed by that leader
+// => Key changes (see rotation.go) are skipped like the servers skip them, but signatures are
+//    verified against the given keys only, i.e. the current keys written by the tests, so messages
+//    a server signed with a key it rotated away from are reported as invalid (audit trails are
+//    verified against every key of the servers instead)
+// => A key file holds every key of each server, oldest first; ReadPublicKeys() returns the last one
+// => Tests write the persisters of all servers at cleanup with -persistdir=dir, and the replay
+//    command replays them, i.e. "go run ./cmd/replay -keys=dir/Test.keys dir/Test-1.persist"
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/gob"
+	"fmt"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"io/ioutil"
+)
+
+type persisterFile struct {
+	State    []byte
+	Logs     map[string][][]byte
+	First    map[string]int
+	Snapshot []byte
+}
+
+type Replayed struct {
+	View          int      // View the server was in
+	ExecuteSeqNum int      // Commit log entries the server executed
+	Entries       int      // Commit log entries persisted (executed or not)
+	Checkpoint    int      // Entries covered by the persisted checkpoint
+	Applied       int      // Entries applied to the state machine (checkpoint included)
+	Problems      []string // Entries that failed verification
+}
+
+func (ps *Persister) WriteFile(path string) error {
+	ps.mu.Lock()
+	file := persisterFile{ps.state, ps.logs, ps.first, ps.snapshot}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(file)
+	ps.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func ReadPersister(path string) (*Persister, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := persisterFile{}
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	ps := MakePersister()
+	ps.state = file.State
+	ps.snapshot = file.Snapshot
+	for log, entries := range file.Logs {
+		ps.logs[log] = entries
+	}
+	for log, first := range file.First {
+		ps.first[log] = first
+	}
+	return ps, nil
+}
+
+func WritePublicKeys(path string, publicKeys map[int]crypto.PublicKey) error {
+	keyHistory := make(KeyHistory, len(publicKeys))
+	for i, publicKey := range publicKeys {
+		keyHistory[i] = []crypto.PublicKey{publicKey}
+	}
+	return WriteKeyHistory(path, keyHistory)
+}
+
+func ReadPublicKeys(path string) (map[int]crypto.PublicKey, error) {
+	keyHistory, err := ReadKeyHistory(path)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeys := make(map[int]crypto.PublicKey, len(keyHistory))
+	for i, keys := range keyHistory {
+		if len(keys) > 0 {
+			publicKeys[i] = keys[len(keys)-1]
+		}
+	}
+	return publicKeys, nil
+}
+
+func WriteKeyHistory(path string, keyHistory KeyHistory) error {
+	keys := make(map[int][][]byte, len(keyHistory)) // PKIX encoded public keys (see signing)
+	for i, publicKeys := range keyHistory {
+		for _, publicKey := range publicKeys {
+			key, err := signing.MarshalPublicKey(publicKey)
+			if err != nil {
+				return fmt.Errorf("server (%d): %v", i, err)
+			}
+			keys[i] = append(keys[i], key)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(keys); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func ReadKeyHistory(path string) (KeyHistory, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[int][][]byte)
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&keys); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	keyHistory := make(KeyHistory, len(keys))
+	for i, serverKeys := range keys {
+		for _, key := range serverKeys {
+			publicKey, err := signing.ParsePublicKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: server (%d): %v", path, i, err)
+			}
+			keyHistory[i] = append(keyHistory[i], publicKey)
+		}
+	}
+	return keyHistory, nil
+}
+
+// publicKeys may be nil to skip the verification of signatures
+func Replay(persister *Persister, sm statemachine.StateMachine, publicKeys map[int]crypto.PublicKey) (*Replayed,
+	error) {
+	replayed := &Replayed{}
+
+	data := persister.ReadState()
+	if len(data) == 0 {
+		return replayed, nil // Nothing persisted
+	}
+	state := persistentState{}
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&state); err != nil {
+		return nil, fmt.Errorf("state: %v", err)
+	}
+	replayed.View = state.View
+	replayed.ExecuteSeqNum = state.ExecuteSeqNum
+	replayed.Entries = state.CommitLogLen
+
+	checkpoint := Checkpoint{}
+	if data := persister.ReadSnapshot(); len(data) > 0 {
+		if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&checkpoint); err != nil {
+			return nil, fmt.Errorf("checkpoint: %v", err)
+		}
+	}
+	replayed.Checkpoint = checkpoint.SeqNum
+
+	entries := persister.ReadLog(COMMITLOG)
+	first := persister.FirstEntry(COMMITLOG) // Entries below were truncated
+	if state.CommitLogLen < first || len(entries) < state.CommitLogLen-first {
+		return nil, fmt.Errorf("commit log: %d entries from %d persisted instead of %d", len(entries), first,
+			state.CommitLogLen)
+	}
+	commitLog := make([]CommitLogEntry, state.CommitLogLen-first)
+	for i := range commitLog {
+		if err := gob.NewDecoder(bytes.NewBuffer(entries[i])).Decode(&commitLog[i]); err != nil {
+			return nil, fmt.Errorf("commit log entry %d: %v", first+i+1, err)
+		}
+		replayed.Problems = append(replayed.Problems, verifyEntry(commitLog[i], first+i+1, publicKeys)...)
+	}
+	replayed.Problems = append(replayed.Problems, verifyCheckpoint(checkpoint, first, publicKeys)...)
+
+	// Same as restoreCheckpoint() and applyExecuted()
+	lastApplied := make(map[int]int, len(checkpoint.LastApplied))
+	for clientId, timestamp := range checkpoint.LastApplied {
+		lastApplied[clientId] = timestamp
+	}
+	if checkpoint.SeqNum > 0 {
+		sm.Restore(checkpoint.Snapshot)
+	}
+
+	replayed.Applied = checkpoint.SeqNum
+	for replayed.Applied < state.ExecuteSeqNum && replayed.Applied < state.CommitLogLen {
+		if replayed.Applied < first {
+			break // Truncated above the checkpoint, reported by verifyCheckpoint()
+		}
+		entry := commitLog[replayed.Applied-first]
+		request := entry.Request
+		replayed.Applied++
+
+		if last, ok := lastApplied[request.ClientId]; ok && request.Timestamp <= last {
+			continue
+		}
+		lastApplied[request.ClientId] = request.Timestamp
+		if _, ok := request.Operation.(KeyChange); ok == false {
+			statemachine.ApplyAt(sm, statemachine.Encode(request.Operation), entry.Msg0.Time)
+		}
+	}
+	return replayed, nil
+}
+
+// Problems of commit log entry seqNum
+func verifyEntry(entry CommitLogEntry, seqNum int, publicKeys map[int]crypto.PublicKey) []string {
+	problems := make([]string, 0)
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf("entry %d: ", seqNum)+fmt.Sprintf(format, a...))
+	}
+
+	msgDigest := digest(entry.Request)
+	if entry.Msg0.MsgDigest != msgDigest {
+		problem("request does not match the prepared digest")
+	}
+	if entry.Msg0.PrepareSeqNum != seqNum {
+		problem("prepared at sequence number %d", entry.Msg0.PrepareSeqNum)
+	}
+	if publicKeys == nil {
+		return problems
+	}
+
+	leader := ((entry.Msg0.View - 1) % len(publicKeys)) + 1
+	if entry.Msg0.SenderId != leader {
+		problem("prepared by server (%d), not the leader of view %d", entry.Msg0.SenderId, entry.Msg0.View)
+	} else if verifyWith(publicKeys, leader, entry.Msg0.signedDigest(), entry.Msg0.Signature) == false {
+		problem("invalid prepare signature of server (%d)", entry.Msg0.SenderId)
+	}
+	for server, msg := range entry.Msg1 {
+		if msg.MsgDigest != msgDigest || msg.PrepareSeqNum != seqNum {
+			problem("server (%d) committed a different request", server)
+		} else if verifyWith(publicKeys, server, msg.signedDigest(), msg.Signature) == false {
+			problem("invalid commit signature of server (%d)", server)
+		}
+	}
+	return problems
+}
+
+// Problems of the persisted checkpoint, the commit log persisted from entry first+1 on
+func verifyCheckpoint(checkpoint Checkpoint, first int, publicKeys map[int]crypto.PublicKey) []string {
+	problems := make([]string, 0)
+	if checkpoint.SeqNum < first {
+		problems = append(problems, fmt.Sprintf("checkpoint %d: entries up to %d truncated", checkpoint.SeqNum, first))
+	}
+	if checkpoint.SeqNum == 0 || publicKeys == nil {
+		return problems
+	}
+	if verifyWith(publicKeys, checkpoint.Server, checkpoint.digest(), checkpoint.Signature) == false {
+		problems = append(problems, fmt.Sprintf("checkpoint %d: invalid signature of server (%d)", checkpoint.SeqNum,
+			checkpoint.Server))
+	}
+	stateDigest := checkpoint.stateDigest()
+	signed := 0
+	for server, signature := range checkpoint.Certificate {
+		if verifyWith(publicKeys, server, stateDigest, signature) {
+			signed++
+		}
+	}
+	if signed <= len(publicKeys)/2 { // Same t+1 as checkStable(), publicKeys has no client
+		problems = append(problems, fmt.Sprintf("checkpoint %d: %d valid signatures in its certificate",
+			checkpoint.SeqNum, signed))
+	}
+	return problems
+}
+
+func verifyWith(publicKeys map[int]crypto.PublicKey, server int, msgDigest [32]byte, signature []byte) bool {
+	publicKey, ok := publicKeys[server]
+	return ok && signing.Verify(publicKey, msgDigest, signature) == nil
+}
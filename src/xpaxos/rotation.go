@@ -0,0 +1,195 @@
+package xpaxos
+
+// Online rotation of the signing keys of XPaxos servers
+//
+// change, err := xp.RotateKey(newKey) - Key change replacing the key of the server with newKey
+// change.Certificate = certificate    - Certificate of the new key, for servers with a CA (see certificate.go)
+// client.Propose(change)              - Replicates the key change like any other operation
+// xp.PublicKeys()                     - Current public keys of the servers, as known to the server
+// cfg.rotateKey(i)                    - Rotates the key of server i to a fresh key of the config
+//
+// A key change is an operation of the log, so that every server changes the key of a server at
+// the same point of the log, without restarting any server:
+// => The change carries the new public key of the server, signed by its current key (so that no
+//    one else can replace it) and by the new key (proof that the server holds it), with the number
+//    of key changes of the server it follows, so that an old change cannot be replayed
+// => Servers apply the key changes of the entries they execute, whether or not they drive a state
+//    machine, and skip them when applying requests to it; a change whose signatures do not verify
+//    against the key of the server at that point of the log is ignored by every server alike
+// => Grace window: from the entry of the change at seqNum s, both keys of the server verify; the
+//    server signs with its new key once it executed entry s+KEYGRACE, and servers stop accepting
+//    the old key once they executed entry s+2*KEYGRACE, so servers whose executed entries lie up
+//    to KEYGRACE apart accept every message of the rotating server
+// => Servers record every key change they apply in their journal (journal.KEYCHANGED)
+// => A server that executes the change late (i.e. outside the synchronous group, at the next view
+//    change) rejects signatures of the new key until then
+// => Keys are not persisted (see persister.go): the config hands a restarted server its current
+//    keys, which cfg.rotateKey() updates once the change committed, and a deployed cluster must
+//    write the new keys to its key files (see cluster.go)
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/csanti/cos518_project/src/journal"
+	"github.com/csanti/cos518_project/src/signing"
+)
+
+type KeyChange struct {
+	Server       int
+	Rotation     int    // Key changes of the server before this one, plus one
+	PublicKey    []byte // New public key of the server (PKIX, see signing)
+	OldSignature []byte // Of the change's digest, by the current key of the server
+	NewSignature []byte // Of the change's digest, by the new key
+	Certificate  []byte // Of the new key, by the CA of the servers (see certificate.go); not signed
+}
+
+type retiringKey struct {
+	publicKey crypto.PublicKey
+	until     int // Executed entries after which it no longer verifies
+}
+
+var errStaleChange = errors.New("stale key change")
+
+func init() {
+	gob.Register(KeyChange{}) // Travels in the Operation of client requests
+}
+
+// Digest signed by both keys of the change; the certificate vouches for itself
+func (change KeyChange) digest() [32]byte {
+	change.OldSignature = nil
+	change.NewSignature = nil
+	change.Certificate = nil
+	return digest(change)
+}
+
+// The server keeps signing with its current key until the change is applied (see applyKeyChanges())
+func (xp *XPaxos) RotateKey(newKey signing.Signer) (KeyChange, error) {
+	publicKey, err := signing.MarshalPublicKey(newKey.Public())
+	if err != nil {
+		return KeyChange{}, fmt.Errorf("key change of server %d: %w", xp.id, err)
+	}
+
+	xp.keyMu.Lock()
+	change := KeyChange{Server: xp.id, Rotation: xp.rotations[xp.id] + 1, PublicKey: publicKey}
+	xp.keyMu.Unlock()
+
+	msgDigest := change.digest()
+	if change.OldSignature, err = xp.signDigest(msgDigest); err != nil {
+		return KeyChange{}, fmt.Errorf("key change of server %d: %w", xp.id, err)
+	}
+	xp.audit(AuditEntry{MsgType: SIGNED, MsgDigest: msgDigest, Signature: change.OldSignature})
+	if change.NewSignature, err = newKey.Sign(msgDigest); err != nil {
+		return KeyChange{}, fmt.Errorf("key change of server %d: new key: %w", xp.id, err)
+	}
+
+	xp.keyMu.Lock()
+	xp.pendingKeys[string(publicKey)] = newKey
+	xp.keyMu.Unlock()
+	return change, nil
+}
+
+func (xp *XPaxos) PublicKeys() map[int]crypto.PublicKey {
+	xp.keyMu.Lock()
+	defer xp.keyMu.Unlock()
+
+	publicKeys := make(map[int]crypto.PublicKey, len(xp.publicKeys))
+	for server, publicKey := range xp.publicKeys {
+		publicKeys[server] = publicKey
+	}
+	return publicKeys
+}
+
+// Apply the key changes of the executed entries not scanned yet, and take the steps of the grace
+// windows they reached; must be called with xp.mu held
+func (xp *XPaxos) applyKeyChanges() {
+	if xp.keysApplied < xp.truncated { // Entries below the stable checkpoint are gone (see checkpoint.go)
+		xp.keysApplied = xp.truncated
+	}
+	for xp.keysApplied < xp.executeSeqNum && xp.keysApplied < xp.commitLength() {
+		change, ok := xp.commitLog[xp.keysApplied-xp.truncated].Request.Operation.(KeyChange)
+		xp.keysApplied++
+
+		if ok {
+			if err := xp.changeKey(change, xp.keysApplied); err != nil {
+				xp.log().Infof("Keys: ignoring the key change of entry %d: %v", xp.keysApplied, err)
+			} else {
+				xp.record(journal.KEYCHANGED, xp.keysApplied)
+			}
+		}
+	}
+
+	xp.keyMu.Lock()
+	defer xp.keyMu.Unlock()
+
+	if xp.nextKey != nil && xp.keysApplied >= xp.switchAt {
+		xp.signer = xp.nextKey
+		xp.nextKey = nil
+		xp.log().Infof("Keys: signing with the new key of the server")
+	}
+	for server, retiring := range xp.retiring {
+		if xp.keysApplied >= retiring.until {
+			delete(xp.retiring, server)
+			xp.forgetSignatures(server) // Some verified with the old key only
+		}
+	}
+}
+
+// Change the key of a server by the key change of entry seqNum
+func (xp *XPaxos) changeKey(change KeyChange, seqNum int) error {
+	xp.keyMu.Lock()
+	defer xp.keyMu.Unlock()
+
+	currentKey := xp.publicKeys[change.Server]
+	if currentKey == nil {
+		return fmt.Errorf("key change of server %d: %w", change.Server, errUnknownServer)
+	}
+	if change.Rotation != xp.rotations[change.Server]+1 {
+		return fmt.Errorf("key change %d of server %d after %d: %w", change.Rotation, change.Server,
+			xp.rotations[change.Server], errStaleChange)
+	}
+	newKey, err := signing.ParsePublicKey(change.PublicKey)
+	if err != nil {
+		return fmt.Errorf("key change of server %d: %w", change.Server, err)
+	}
+	msgDigest := change.digest()
+	if err := signing.Verify(currentKey, msgDigest, change.OldSignature); err != nil {
+		return fmt.Errorf("key change of server %d: current key: %w", change.Server, err)
+	}
+	if err := signing.Verify(newKey, msgDigest, change.NewSignature); err != nil {
+		return fmt.Errorf("key change of server %d: new key: %w", change.Server, err)
+	}
+	if err := xp.checkKeyCertificate(change); err != nil {
+		return fmt.Errorf("key change of server %d: %w", change.Server, err)
+	}
+
+	xp.retiring[change.Server] = retiringKey{currentKey, seqNum + 2*KEYGRACE}
+	xp.publicKeys[change.Server] = newKey
+	xp.rotations[change.Server]++
+	xp.forgetSignatures(change.Server)
+
+	if change.Server == xp.id {
+		privateKey := xp.pendingKeys[string(change.PublicKey)]
+		xp.pendingKeys = make(map[string]signing.Signer) // Later ones were made for a stale rotation
+
+		if privateKey == nil { // i.e. made before the server restarted
+			xp.log().Infof("Keys: no private key for the key change of entry %d", seqNum)
+		} else {
+			xp.nextKey = privateKey
+			xp.switchAt = seqNum + KEYGRACE
+		}
+	}
+	return nil
+}
+
+// Whether server signs with privateKey (tests)
+func (xp *XPaxos) signsWith(privateKey crypto.Signer) bool {
+	xp.keyMu.Lock()
+	defer xp.keyMu.Unlock()
+
+	current, _ := signing.MarshalPublicKey(xp.signer.Public())
+	wanted, _ := signing.MarshalPublicKey(privateKey.Public())
+	return bytes.Equal(current, wanted)
+}
@@ -0,0 +1,245 @@
+package xpaxos
+
+// Scripted fault schedules (scenarios) for the test harness
+//
+// events, err := parseScenario(text)  - Parses a scenario (one timed event per line)
+// events, err := loadScenario(path)   - Reads and parses a scenario file (see testdata/)
+// sc := cfg.startScenario(events)     - Executes the events at their times from now on
+// sc.done()                           - Whether every event has been executed
+// sc.wait()                           - Blocks until every event has been executed
+//
+// A line is "t=<time> <action> [arguments]" (times as in time.ParseDuration); blank lines and
+// everything after a '#' are ignored:
+// => t=1s crash 3                 - XPaxos server 3 fails to send all of its RPCs
+// => t=2s restart 3               - XPaxos server 3 sends its RPCs again
+// => t=2s partition {1,2}|{3,4}   - Cuts the links between the groups in both directions (servers
+//                                   left out of every group, and the client, stay connected)
+// => t=3s delay 2 50ms            - Delays all RPCs from XPaxos server 2 by up to 50ms
+// => t=3s byzantine 2 equivocate  - XPaxos server 2 follows a Byzantine strategy (see byzantine.go)
+// => t=5s heal                    - Heals all crashes, partitions and delays (not Byzantine servers)
+//
+// => Events run in the order of their times (events with the same time in file order), so a
+//    scenario describes exactly which faults a view change has to cope with; like the nemesis,
+//    when they happen relative to the workload still depends on timing
+
+import (
+	"fmt"
+	"github.com/csanti/cos518_project/src/network"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ( // Scenario actions
+	CRASHEVENT     = iota
+	RESTARTEVENT   = iota
+	PARTITIONEVENT = iota
+	DELAYEVENT     = iota
+	BYZANTINEEVENT = iota
+	HEALEVENT      = iota
+)
+
+var STRATEGIES = map[string]int{ // Names of Byzantine strategies in scenarios
+	"honest":           HONEST,
+	"corruptsignature": CORRUPTSIGNATURE,
+	"drop":             DROP,
+	"equivocate":       EQUIVOCATE,
+	"lieview":          LIEVIEW}
+
+type scenarioEvent struct {
+	at       time.Duration
+	action   int
+	server   int
+	groups   [][]int       // Partition
+	delay    time.Duration // Maximum delay
+	strategy int           // Byzantine strategy
+	line     string        // As written in the scenario (for logging)
+}
+
+type scenario struct {
+	cfg      *config
+	events   []scenarioEvent
+	finished chan bool
+}
+
+func parseServer(s string) (int, error) {
+	server, err := strconv.Atoi(s)
+	if err != nil || server == CLIENT {
+		return 0, fmt.Errorf("invalid XPaxos server %q", s)
+	}
+	return server, nil
+}
+
+// "{1,2}|{3,4}"
+func parseGroups(s string) ([][]int, error) {
+	groups := make([][]int, 0)
+	for _, group := range strings.Split(s, "|") {
+		if !strings.HasPrefix(group, "{") || !strings.HasSuffix(group, "}") {
+			return nil, fmt.Errorf("invalid partition group %q", group)
+		}
+
+		servers := make([]int, 0)
+		for _, field := range strings.Split(group[1:len(group)-1], ",") {
+			server, err := parseServer(strings.TrimSpace(field))
+			if err != nil {
+				return nil, err
+			}
+			servers = append(servers, server)
+		}
+		groups = append(groups, servers)
+	}
+
+	if len(groups) < 2 {
+		return nil, fmt.Errorf("partition %q needs at least two groups", s)
+	}
+	return groups, nil
+}
+
+func parseEvent(line string) (scenarioEvent, error) {
+	event := scenarioEvent{}
+	event.line = line
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "t=") {
+		return event, fmt.Errorf("expected \"t=<time> <action>\"")
+	}
+
+	at, err := time.ParseDuration(fields[0][2:])
+	if err != nil {
+		return event, err
+	}
+	event.at = at
+
+	args := fields[2:]
+	expect := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s takes %d argument(s)", fields[1], n)
+		}
+		return nil
+	}
+
+	switch fields[1] {
+	case "crash", "restart":
+		event.action = CRASHEVENT
+		if fields[1] == "restart" {
+			event.action = RESTARTEVENT
+		}
+		if err = expect(1); err == nil {
+			event.server, err = parseServer(args[0])
+		}
+	case "partition":
+		event.action = PARTITIONEVENT
+		if err = expect(1); err == nil {
+			event.groups, err = parseGroups(args[0])
+		}
+	case "delay":
+		event.action = DELAYEVENT
+		if err = expect(2); err == nil {
+			if event.server, err = parseServer(args[0]); err == nil {
+				event.delay, err = time.ParseDuration(args[1])
+			}
+		}
+	case "byzantine":
+		event.action = BYZANTINEEVENT
+		if err = expect(2); err == nil {
+			if event.server, err = parseServer(args[0]); err == nil {
+				strategy, ok := STRATEGIES[args[1]]
+				if !ok {
+					err = fmt.Errorf("unknown Byzantine strategy %q", args[1])
+				}
+				event.strategy = strategy
+			}
+		}
+	case "heal":
+		event.action = HEALEVENT
+		err = expect(0)
+	default:
+		err = fmt.Errorf("unknown action %q", fields[1])
+	}
+
+	return event, err
+}
+
+func parseScenario(text string) ([]scenarioEvent, error) {
+	events := make([]scenarioEvent, 0)
+
+	for i, line := range strings.Split(text, "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		event, err := parseEvent(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at < events[j].at })
+	return events, nil
+}
+
+func loadScenario(path string) ([]scenarioEvent, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseScenario(string(text))
+}
+
+func (sc *scenario) done() bool {
+	select {
+	case <-sc.finished:
+		return true
+	default:
+		return false
+	}
+}
+
+func (sc *scenario) wait() {
+	<-sc.finished
+}
+
+func (sc *scenario) execute(event scenarioEvent) {
+	cfg := sc.cfg
+	iPrintf("Scenario: %s\n", event.line)
+
+	switch event.action {
+	case CRASHEVENT:
+		cfg.net.SetFaultRate(event.server, 100)
+	case RESTARTEVENT:
+		cfg.net.SetFaultRate(event.server, 0)
+	case PARTITIONEVENT:
+		for i, group := range event.groups {
+			for _, other := range event.groups[i+1:] {
+				for _, server := range group {
+					for _, peer := range other {
+						cfg.net.EnableLink(server, peer, false)
+						cfg.net.EnableLink(peer, server, false)
+					}
+				}
+			}
+		}
+	case DELAYEVENT:
+		delay := network.UniformLatency{Min: 0, Max: event.delay}
+		for j := 0; j < cfg.n; j++ {
+			cfg.net.SetLinkLatency(event.server, j, delay)
+		}
+	case BYZANTINEEVENT:
+		cfg.setByzantine(event.server, event.strategy)
+	case HEALEVENT:
+		for i := 1; i < cfg.n; i++ {
+			cfg.net.SetFaultRate(i, 0)
+			for j := 0; j < cfg.n; j++ {
+				cfg.net.EnableLink(i, j, true)
+				cfg.net.SetLinkLatency(i, j, nil)
+			}
+		}
+	}
+}
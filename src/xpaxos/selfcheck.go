@@ -0,0 +1,145 @@
+package xpaxos
+
+// Periodic self-check of the internal invariants of an XPaxos server
+//
+// xp.SetSelfCheckInterval(interval) - Checks the server's own state every interval (0 = never)
+// xp.SelfCheck()                    - Checks it once, returns the violations it found
+// xp.Violations()                   - Distinct violations found so far
+//
+// Every check holds the following invariants of the server's own state:
+// => Sequence numbers: executeSeqNum <= prepareSeqNum <= len(prepareLog) (a new leader prepares
+//    its log again before its new view), executeSeqNum <= len(commitLog), executeSeqNum never
+//    decreases, and prepareSeqNum never decreases within a view (a new view may drop the entries
+//    prepared but not committed in the last one); lengths count the truncated entries (see
+//    checkpoint.go), whose number is that of the stable checkpoint
+// => Certificates: the prepare message of every commit log entry is a prepare for its sequence
+//    number, it holds at most t commit messages, all for the same request and sequence number
+//    and each filed under its sender, and the entries the server executed in its view as a member
+//    of the synchronous group hold all t of them (a leader executes once its t followers
+//    committed, a follower once it holds the commits of the t members of the group but itself)
+//
+// => Unlike the invariant checker of the test harness (see checker.go), which compares servers,
+//    the self-check runs inside every server, deployed ones included (see cmd/xpaxosd), and only
+//    reads its state, so it never fails or stops the server
+// => A violation is logged at INFO ("Invariant violated: ...") with the view, sequence numbers,
+//    log lengths and view change progress of the server and the sequence number of the entry at
+//    fault, the first time it is found only; deployed servers count them as a metric
+// => A check holds the server's lock while it walks the commit log, so intervals should stay
+//    above a few hundred milliseconds for long logs; xp.Kill() stops the checks
+
+import (
+	"fmt"
+	"time"
+)
+
+// Starts checking every interval from now on, instead of the last interval set
+func (xp *XPaxos) SetSelfCheckInterval(interval time.Duration) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	if xp.selfCheckDone != nil {
+		close(xp.selfCheckDone)
+		xp.selfCheckDone = nil
+	}
+	if interval <= 0 {
+		return
+	}
+
+	done := make(chan bool)
+	xp.selfCheckDone = done
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				xp.SelfCheck()
+			}
+		}
+	}()
+}
+
+func (xp *XPaxos) SelfCheck() []string {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	return xp.selfCheck()
+}
+
+func (xp *XPaxos) Violations() []string {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	return append([]string(nil), xp.violations...)
+}
+
+// Must be called with xp.mu held
+func (xp *XPaxos) selfCheck() []string {
+	found := make([]string, 0)
+	violated := func(seqNum int, format string, a ...interface{}) {
+		violation := fmt.Sprintf(format, a...)
+		found = append(found, violation)
+		if xp.reported[violation] == true {
+			return
+		}
+		xp.reported[violation] = true
+		xp.violations = append(xp.violations, violation)
+		xp.log().With("view", xp.view, "seqNum", seqNum, "prepareSeqNum", xp.prepareSeqNum,
+			"executeSeqNum", xp.executeSeqNum, "prepareLog", xp.prepareLength(), "commitLog",
+			xp.commitLength(), "vcInProgress", xp.vcInProgress).Infof("Invariant violated: %s", violation)
+	}
+
+	if xp.executeSeqNum > xp.commitLength() {
+		violated(xp.executeSeqNum, "executeSeqNum %d above the commit log length %d", xp.executeSeqNum,
+			xp.commitLength())
+	}
+	if xp.executeSeqNum > xp.prepareSeqNum {
+		violated(xp.executeSeqNum, "executeSeqNum %d above prepareSeqNum %d", xp.executeSeqNum, xp.prepareSeqNum)
+	}
+	if xp.prepareSeqNum > xp.prepareLength() {
+		violated(xp.prepareSeqNum, "prepareSeqNum %d above the prepare log length %d", xp.prepareSeqNum,
+			xp.prepareLength())
+	}
+	if xp.truncated != xp.checkpoint.SeqNum {
+		violated(xp.truncated, "%d entries truncated below stable checkpoint %d", xp.truncated,
+			xp.checkpoint.SeqNum)
+	}
+	if xp.executeSeqNum < xp.checkedExecute {
+		violated(xp.executeSeqNum, "executeSeqNum decreased from %d to %d", xp.checkedExecute,
+			xp.executeSeqNum)
+	}
+	if xp.view == xp.checkedView && xp.prepareSeqNum < xp.checkedPrepare {
+		violated(xp.prepareSeqNum, "prepareSeqNum decreased from %d to %d in view %d", xp.checkedPrepare,
+			xp.prepareSeqNum, xp.view)
+	}
+	xp.checkedView = xp.view
+	xp.checkedPrepare = xp.prepareSeqNum
+	xp.checkedExecute = xp.executeSeqNum
+
+	t := (len(xp.replicas) - 1) / 2
+	for i, entry := range xp.commitLog {
+		seqNum := xp.truncated + i
+		if entry.Msg0.MsgType != PREPARE || entry.Msg0.PrepareSeqNum != seqNum+1 {
+			violated(seqNum+1, "prepare message of entry %d is a message of type %d for entry %d", seqNum+1,
+				entry.Msg0.MsgType, entry.Msg0.PrepareSeqNum)
+		}
+		if len(entry.Msg1) > t {
+			violated(seqNum+1, "entry %d holds %d commit messages instead of at most %d", seqNum+1,
+				len(entry.Msg1), t)
+		}
+		for sender, msg := range entry.Msg1 {
+			if msg.MsgType != COMMIT || msg.SenderId != sender || msg.MsgDigest != entry.Msg0.MsgDigest ||
+				msg.PrepareSeqNum != entry.Msg0.PrepareSeqNum {
+				violated(seqNum+1, "commit message of server %d does not match entry %d", sender, seqNum+1)
+			}
+		}
+		if seqNum < xp.executeSeqNum && entry.View == xp.view && xp.synchronousGroup[xp.id] == true &&
+			len(entry.Msg1) < t && entry.Certificate == nil {
+			violated(seqNum+1, "executed entry %d holds %d commit messages instead of %d", seqNum+1,
+				len(entry.Msg1), t)
+		}
+	}
+	return found
+}
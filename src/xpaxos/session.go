@@ -0,0 +1,108 @@
+package xpaxos
+
+// Pairwise session keys of XPaxos servers (see signing/session.go)
+//
+// xp.EstablishSessions()           - Offers a session key to every other server, returns how many took it
+// xp.mac(server, digest)           - MAC of digest for server (nil without a session key)
+// xp.checkMAC(server, digest, mac) - Whether mac is the MAC of digest by server
+// cfg.establishSessions()          - Establishes the session keys of every pair of servers
+//
+// => Servers exchange keys over the XPaxos.Session RPC, signing their halves with their current
+//    key (see rotation.go), so that only the holder of a server's key can take its place
+// => Deployed servers establish their sessions when they start (see cluster.go): peers that are
+//    not up yet get their keys when they start in turn, since their own offers ask for them
+// => No message of the protocol carries a MAC yet: the keys are there for fast paths whose
+//    messages are checked by their receiver only and never go into a certificate
+
+import (
+	"github.com/csanti/cos518_project/src/signing"
+	"sync"
+)
+
+type SessionReply struct {
+	Answer  signing.Handshake
+	Success bool // The offer was valid and answered
+}
+
+func (xp *XPaxos) EstablishSessions() int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	established := 0
+
+	for server := range xp.replicas {
+		if server != CLIENT && server != xp.id {
+			wg.Add(1)
+			go func(server int) {
+				defer wg.Done()
+				if xp.offerSession(server) {
+					mu.Lock()
+					established++
+					mu.Unlock()
+				}
+			}(server)
+		}
+	}
+	wg.Wait()
+	return established
+}
+
+func (xp *XPaxos) offerSession(server int) bool {
+	xp.keyMu.Lock()
+	signer := xp.signer
+	xp.keyMu.Unlock()
+
+	offer, err := xp.sessions.Offer(signer, server)
+	if err != nil {
+		xp.log().Infof("Session: %v", err)
+		return false
+	}
+	reply := &SessionReply{}
+	if xp.replicas[server].Call("XPaxos.Session", offer, reply, xp.id) == false || reply.Success == false {
+		xp.log().Debugf("Session: no answer of XPaxos server (%d)", server)
+		return false
+	}
+
+	xp.keyMu.Lock()
+	publicKey := xp.publicKeys[server]
+	xp.keyMu.Unlock()
+	if err := xp.sessions.Complete(offer, reply.Answer, publicKey); err != nil {
+		xp.log().Infof("Session: %v", err)
+		return false
+	}
+	return true
+}
+
+func (xp *XPaxos) Session(offer signing.Handshake, reply *SessionReply) {
+	xp.keyMu.Lock()
+	signer := xp.signer
+	publicKey := xp.publicKeys[offer.From]
+	xp.keyMu.Unlock()
+
+	answer, reoffer, err := xp.sessions.Answer(signer, offer, publicKey)
+	if err != nil {
+		xp.log().Infof("Session: %v", err)
+		return
+	}
+	reply.Answer = answer
+	reply.Success = true
+
+	if reoffer {
+		go xp.offerSession(offer.From)
+	}
+}
+
+func (xp *XPaxos) mac(server int, msgDigest [32]byte) []byte {
+	mac, err := xp.sessions.MAC(server, msgDigest)
+	if err != nil {
+		xp.log().Debugf("MAC: %v", err)
+	}
+	return mac
+}
+
+func (xp *XPaxos) checkMAC(server int, msgDigest [32]byte, mac []byte) bool {
+	if err := xp.sessions.CheckMAC(server, msgDigest, mac); err != nil {
+		xp.log().Debugf("MAC: %v", err)
+		return false
+	}
+	return true
+}
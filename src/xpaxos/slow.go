@@ -0,0 +1,106 @@
+package xpaxos
+
+// Logging of slow client requests with a breakdown of their latency into the phases of the leader
+//
+// client.SetSlowThreshold(threshold) - Logs the requests slower than threshold end to end (0 = none)
+// client.SetLogger(logger)           - Logs through logger with the client's ID as a field
+// xp.Timing(traceId, &reply)         - RPC returning the phases of a request the server led
+//
+// => A leader times the phases of every request it replicates (see Timing): waiting for its lock,
+//    preparing (signing, logging and persisting the prepare entry), committing (waiting for the
+//    commits of its synchronous group) and executing (persisting and applying the request), and
+//    keeps those of its last TIMINGS requests by trace ID
+// => A client whose request was slower than the threshold asks the leader that replied for its
+//    phases (off the critical path, so only slow requests cost an RPC and replies stay small) and
+//    logs them at INFO ("Slow: ...") with the latency and trace ID of the request (to find its
+//    spans on every server, see tracing); the rest of the latency ("network") is the network and
+//    the queue of the leader's RPC server
+// => Requests confirmed by a view change instead of a reply, or whose leader no longer has their
+//    phases, are logged without phases; reads are not timed
+// => Tests and benchmarks set the threshold of every client with -args -slow=50ms, and the
+//    gateway of a deployed cluster with -slow=50ms (see cmd/gateway)
+// => Phases are measured with the servers' clocks, so they are virtual in tests that drive one
+
+import (
+	"github.com/csanti/cos518_project/src/debug"
+	"time"
+)
+
+const TIMINGS = 256                   // Requests whose phases a leader keeps
+const TIMINGTIMEOUT = 1 * time.Second // Of a client's request for the phases of a slow request
+
+type Timing struct {
+	Lock    time.Duration // Waiting for the leader's lock
+	Prepare time.Duration // Signing, logging and persisting the prepare entry, issuing the prepares
+	Commit  time.Duration // Waiting for the commits of the synchronous group
+	Execute time.Duration // Persisting and applying the request
+}
+
+type TimingReply struct {
+	Found  bool // Whether the server led the request recently
+	Timing Timing
+}
+
+func (timing Timing) Total() time.Duration {
+	return timing.Lock + timing.Prepare + timing.Commit + timing.Execute
+}
+
+//
+// -------------------------------- LEADER SIDE -------------------------------
+//
+// Must be called with xp.mu held
+func (xp *XPaxos) recordTiming(traceId string, timing Timing) {
+	if traceId == "" {
+		return
+	}
+	if len(xp.timed) == TIMINGS {
+		delete(xp.timings, xp.timed[0])
+		xp.timed = xp.timed[1:]
+	}
+	xp.timings[traceId] = timing
+	xp.timed = append(xp.timed, traceId)
+}
+
+// Served to clients (see client.logSlow()), never sent by XPaxos servers
+func (xp *XPaxos) Timing(traceId string, reply *TimingReply) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	reply.Timing, reply.Found = xp.timings[traceId]
+}
+
+//
+// -------------------------------- CLIENT SIDE -------------------------------
+//
+func (client *Client) SetSlowThreshold(threshold time.Duration) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.slow = threshold
+}
+
+// Replaces the logger of the client (i.e. to collect its records in a test)
+func (client *Client) SetLogger(logger *debug.Logger) {
+	client.logger.Store(logger)
+}
+
+// leader is the server that replied (CLIENT for requests confirmed by a view change)
+func (client *Client) logSlow(logger *debug.Logger, leader int, traceId string, latency time.Duration) {
+	logger = logger.With("latency", latency)
+	reply := &TimingReply{}
+	if leader == CLIENT ||
+		client.replicas[leader].CallTimeout("XPaxos.Timing", traceId, reply, CLIENT, TIMINGTIMEOUT) == false ||
+		reply.Found == false {
+		logger.Infof("Slow: request took %v (phases unknown)", latency)
+		return
+	}
+
+	timing := reply.Timing
+	logger.With(
+		"leader", leader,
+		"lock", timing.Lock,
+		"prepare", timing.Prepare,
+		"commit", timing.Commit,
+		"execute", timing.Execute,
+		"network", latency-timing.Total()).Infof("Slow: request took %v", latency)
+}
@@ -0,0 +1,274 @@
+package xpaxos
+
+// State transfer to servers of the synchronous group that fell behind the others
+//
+// xp.requestState(server, view) - Asks server for its executed log of view and installs it if it
+//                                 extends the server's own, returns whether it did
+// xp.executedState(request)     - The state the leader ships for a request
+//
+// => A member of the synchronous group that lost its state (i.e. restarted with an empty persister)
+//    never gets the prepares of the entries it missed again, so without a transfer it waits for
+//    them until its fault timeout and suspects a leader that did nothing wrong (see Prepare())
+// => A member asks the leader for its state once a prepare still misses its predecessors after
+//    network.DELTA, which they would have arrived within in the synchronous group; the leader
+//    ships its executed commit log above its stable checkpoint and the checkpoint
+// => The member asks over the XPaxos.StateTransfer RPC, and the leader streams the state back to
+//    its XPaxos.InstallState handler in chunks (see network/stream.go) before it replies, so that
+//    the log of a long-running group never travels as a single message; a member only buffers
+//    the streams of servers it is waiting on
+// => The leader executes an entry only once every member prepared it, so a correct member that
+//    kept its state is never behind the leader's executed log, and its requests install nothing
+// => Every shipped entry the server did not execute must carry its commit certificate: the
+//    request matches the digest prepared at its sequence number, the prepare is signed by its
+//    sender, and the commits of t distinct followers of the group of their view (never the
+//    leader that prepared it) all commit that digest with valid signatures, or their threshold
+//    signature (see threshold.go), so that a leader cannot make a member execute an entry the
+//    group never committed; stricter than
+//    Replay() (see replay.go), which reports the problems of a log instead of rejecting it.
+//    Entries the server executed already must hold the same requests, and a checkpoint above the
+//    server's own must carry its certificate and a snapshot of the certified hash (see
+//    checkpoint.go)
+// => With a threshold key the leader ships the certificate of an entry instead of its commits
+// => Commits may still be on their way to the leader when it executes an entry, so the leader
+//    ships its executed entries up to the first that lacks commits, and no state before they arrive
+// => Only a server with no entry in flight (prepared but not executed) installs a state, since the
+//    Prepare() handlers waiting on such entries would execute them a second time
+// => The installed entries are executed at once, and the checkpoint adopted before they are applied
+
+import (
+	"errors"
+	"fmt"
+	"github.com/csanti/cos518_project/src/journal"
+	"github.com/csanti/cos518_project/src/network"
+)
+
+type StateRequest struct {
+	SenderId      int
+	View          int
+	ExecuteSeqNum int // Entries the requesting server executed already
+}
+
+type StateReply struct {
+	View       int              // View the state was requested for
+	CommitLog  []CommitLogEntry // Executed entries of the leader above its checkpoint that hold their commits
+	Checkpoint Checkpoint       // Stable, stands in for the entries below it
+	Success    bool             // The leader is in the view and executed more entries than the requesting server
+}
+
+var errStateRefused = errors.New("state refused")
+
+func (xp *XPaxos) requestState(server int, view int) bool {
+	xp.mu.Lock()
+	args := StateRequest{SenderId: xp.id, View: view, ExecuteSeqNum: xp.executeSeqNum}
+	xp.stateSources[server]++ // Its stream is buffered until it replies
+	xp.mu.Unlock()
+
+	reply := &Reply{}
+	ok := xp.replicas[server].Call("XPaxos.StateTransfer", args, reply, xp.id)
+
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	xp.stateSources[server]--
+	if xp.stateSources[server] == 0 {
+		delete(xp.stateSources, server)
+		xp.stateStreams.Drop(server) // Left behind by a stream that broke off
+	}
+	return ok && reply.Success && xp.executeSeqNum > args.ExecuteSeqNum
+}
+
+func (xp *XPaxos) StateTransfer(args StateRequest, reply *Reply) {
+	state := xp.executedState(args)
+	if state.Success == false {
+		return
+	}
+
+	data, err := encode(state)
+	if err != nil {
+		xp.log().With("view", args.View).Infof("StateTransfer: %v", err)
+		return
+	}
+	reply.Success = xp.replicas[args.SenderId].CallStream("XPaxos.InstallState", data, network.CHUNKSIZE, xp.id)
+}
+
+// Installs the state streamed by a server the server asked for it (see requestState())
+func (xp *XPaxos) InstallState(chunk network.StreamChunk, reply *network.StreamReply) {
+	xp.mu.Lock()
+	waiting := xp.stateSources[chunk.Sender] > 0
+	xp.mu.Unlock()
+	if waiting == false {
+		return
+	}
+
+	data, ok := xp.stateStreams.Add(chunk, reply)
+	if ok == false {
+		return
+	}
+
+	state := StateReply{}
+	if err := decode(data, &state); err != nil {
+		xp.log().Infof("StateTransfer: state of server %d: %v", chunk.Sender, err)
+		return
+	}
+
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	if err := xp.installState(chunk.Sender, state.View, state); err != nil {
+		xp.log().With("view", state.View).Infof("StateTransfer: %v", err)
+	}
+}
+
+func (xp *XPaxos) executedState(args StateRequest) StateReply {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	reply := StateReply{View: args.View}
+	if xp.view != args.View || xp.id != xp.getLeader() || xp.synchronousGroup[args.SenderId] == false {
+		return reply
+	}
+
+	t := (len(xp.replicas) - 1) / 2
+	certified := xp.truncated // Entries up to the first without the commits of the group
+	for certified < xp.executeSeqNum && (len(xp.commitLog[certified-xp.truncated].Msg1) >= t ||
+		xp.commitLog[certified-xp.truncated].Certificate != nil) {
+		certified++
+	}
+	if args.ExecuteSeqNum >= certified {
+		return reply
+	}
+
+	combined := certified // First entry whose certificate was combined now
+	reply.CommitLog = make([]CommitLogEntry, certified-xp.truncated)
+	for i, entry := range xp.commitLog[:certified-xp.truncated] {
+		reply.CommitLog[i] = entry
+		if entry.Certificate == nil && xp.certifyEntry(xp.truncated+i) != nil && combined == certified {
+			combined = xp.truncated + i
+		}
+		if certificate := xp.commitLog[i].Certificate; certificate != nil { // Stands in for the commits
+			reply.CommitLog[i].Certificate = certificate
+			reply.CommitLog[i].Msg0.Share = nil
+			reply.CommitLog[i].Msg1 = nil
+			continue
+		}
+		reply.CommitLog[i].Msg1 = make(map[int]Message, len(entry.Msg1)) // Commits may still arrive
+		for server, msg := range entry.Msg1 {
+			reply.CommitLog[i].Msg1[server] = msg
+		}
+	}
+	if combined < certified {
+		xp.persist(combined)
+	}
+	reply.Checkpoint = xp.checkpoint
+	reply.Success = true
+	return reply
+}
+
+// Must be called with xp.mu held
+func (xp *XPaxos) installState(server int, view int, reply StateReply) error {
+	if reply.Success == false {
+		return fmt.Errorf("server %d: %w", server, errStateRefused)
+	}
+	if xp.view != view || xp.vcInProgress == true {
+		return fmt.Errorf("state of server %d for view %d: view changed", server, view)
+	}
+	first := reply.Checkpoint.SeqNum
+	if xp.prepareSeqNum != xp.executeSeqNum || first+len(reply.CommitLog) <= xp.executeSeqNum {
+		return fmt.Errorf("%d entries of server %d, %d prepared: nothing to install", first+len(reply.CommitLog),
+			server, xp.prepareSeqNum)
+	}
+	if first > xp.checkpoint.SeqNum {
+		if err := xp.checkStable(reply.Checkpoint); err != nil {
+			return fmt.Errorf("server %d: %w", server, err)
+		}
+		if err := xp.checkSnapshot(reply.Checkpoint); err != nil { // The certificate only signs its hash
+			return fmt.Errorf("server %d: %w", server, err)
+		}
+	}
+
+	executed := xp.executeSeqNum
+	for i, entry := range reply.CommitLog {
+		seqNum := first + i
+		if seqNum < executed {
+			if seqNum >= xp.truncated && entry.Msg0.MsgDigest != xp.commitLog[seqNum-xp.truncated].Msg0.MsgDigest {
+				return fmt.Errorf("entry %d of server %d: another request was executed", seqNum+1, server)
+			}
+			continue
+		}
+		if err := xp.checkEntry(entry, seqNum+1); err != nil {
+			return fmt.Errorf("server %d: %w", server, err)
+		}
+	}
+
+	xp.adoptCheckpoint(reply.Checkpoint) // Stands in for the entries below it we did not execute
+	if xp.checkpoint.SeqNum < first {
+		return fmt.Errorf("checkpoint %d of server %d: not adopted", first, server)
+	}
+
+	executed = xp.executeSeqNum
+	xp.commitLog = append([]CommitLogEntry{}, xp.commitLog[:executed-xp.truncated]...)
+	xp.prepareLog = xp.prepareLog[:executed-xp.truncated]
+	for _, entry := range reply.CommitLog[executed-first:] {
+		if entry.Msg1 == nil {
+			entry.Msg1 = make(map[int]Message, 0)
+		}
+		xp.commitLog = append(xp.commitLog, entry)
+		xp.prepareLog = append(xp.prepareLog, PrepareLogEntry{Request: entry.Request, Msg0: entry.Msg0})
+		xp.record(journal.EXECUTED, xp.commitLength())
+	}
+	xp.prepareSeqNum = xp.commitLength()
+	xp.executeSeqNum = xp.commitLength()
+	xp.notifyChange()
+	xp.log().With("view", view).Infof("StateTransfer: installed %d entries of XPaxos server (%d)",
+		xp.executeSeqNum-executed, server)
+
+	xp.persist(executed)
+	xp.applyExecuted()
+	return nil
+}
+
+// Error unless commit log entry seqNum carries its commit certificate: a prepare signed by the
+// leader of its view and either the valid commits of t distinct followers of the group of that view,
+// all of the prepared request at seqNum (see signedDigest()), or their threshold signature
+func (xp *XPaxos) checkEntry(entry CommitLogEntry, seqNum int) error {
+	msgDigest := digest(entry.Request)
+	if entry.Msg0.MsgType != PREPARE || entry.Msg0.MsgDigest != msgDigest || entry.Msg0.PrepareSeqNum != seqNum {
+		return fmt.Errorf("entry %d: request does not match the prepared one", seqNum)
+	}
+	if entry.Msg0.SenderId != xp.leaderOf(entry.Msg0.View) {
+		return fmt.Errorf("entry %d: server (%d) is not the leader of view %d", seqNum, entry.Msg0.SenderId,
+			entry.Msg0.View)
+	}
+	if err := xp.checkSignature(entry.Msg0.SenderId, entry.Msg0.signedDigest(), entry.Msg0.Signature); err != nil {
+		return fmt.Errorf("entry %d: prepare of server (%d): %w", seqNum, entry.Msg0.SenderId, err)
+	}
+	if entry.Certificate != nil { // Stands in for the commits of the group (see certifyEntry())
+		if err := xp.checkEntryCertificate(entry); err != nil {
+			return fmt.Errorf("entry %d: certificate of the commits: %w", seqNum, err)
+		}
+		return nil
+	}
+
+	t := (len(xp.replicas) - 1) / 2
+	commits := 0
+	for server, msg := range entry.Msg1 {
+		if msg.MsgType != COMMIT || msg.SenderId != server || msg.PrepareSeqNum != seqNum || msg.MsgDigest != msgDigest {
+			return fmt.Errorf("entry %d: server (%d) committed a different request", seqNum, server)
+		}
+		if server == entry.Msg0.SenderId { // A leader alone cannot certify an entry
+			return fmt.Errorf("entry %d: server (%d) committed the entry it prepared", seqNum, server)
+		}
+		if msg.View != entry.Msg0.View || xp.groupOf(msg.View)[server] == false {
+			return fmt.Errorf("entry %d: server (%d) is no member of the group of view %d", seqNum, server,
+				entry.Msg0.View)
+		}
+		if err := xp.checkSignature(server, msg.signedDigest(), msg.Signature); err != nil {
+			return fmt.Errorf("entry %d: commit of server (%d): %w", seqNum, server, err)
+		}
+		commits++
+	}
+	if commits < t {
+		return fmt.Errorf("entry %d: %d of the %d commits of the group", seqNum, commits, t)
+	}
+	return nil
+}
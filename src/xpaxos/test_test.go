@@ -1,15 +1,41 @@
 package xpaxos
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/csanti/cos518_project/src/bank"
+	"github.com/csanti/cos518_project/src/debug"
+	"github.com/csanti/cos518_project/src/journal"
+	"github.com/csanti/cos518_project/src/kvservice"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"github.com/csanti/cos518_project/src/tracing"
+	"github.com/csanti/cos518_project/src/workload"
+	"io"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // We need to test more Byzantine faults such as bit flipping!
 
-// TO RUN TESTS      - "go test -run=Test [-count=10]" (n.b. set common.go/DEBUG > 0)
-// TO RUN BENCHMARKS - "go test -run=XXX -bench=. [-benchtime=100x]" (n.b. set common.go/DEBUG = 0)
+// TO RUN TESTS      - "go test -run=Test [-count=10]" (n.b. add "-args -debug=xpaxos=1" for logs)
+// TO RUN BENCHMARKS - "go test -run=XXX -bench=. [-benchtime=100x]" (n.b. add "-args -debug=all=0")
 //
 // Alternatively, use "go test -run=XXX -bench=3_0" to only run the Benchmark_3_0* benchmarks
 // It is prudent to only run one set of benchmarks at a time (otherwise Golang often exits due to high thread count)
@@ -19,6 +45,36 @@ import (
 // => The view change protocol can be slow when more than 3-4 servers fail so try to avoid
 //    testing extreme scenarios (if you want to, be sure to set WAIT = true in common.go)
 
+// Cluster parameters for scripted experiments (see config.go/parameters), i.e.
+// "go test -run=TestCommonCase1 -args -n=8 -seed=1 -duration=10s"
+// => -n is also a go build flag, so pass the cluster parameters after -args
+func init() {
+	flag.IntVar(&params.n, "n", 0, "total number of client and XPaxos servers (overrides each test's)")
+	flag.IntVar(&params.f, "f", 0, "number of faults to tolerate, n = 2f+2 (ignored if -n is set)")
+	flag.BoolVar(&params.unreliable, "unreliable", false, "run every test on an unreliable network")
+	flag.Int64Var(&params.seed, "seed", 0, "seed of all random choices of tests: network, workloads and nemeses (default: time)")
+	flag.DurationVar(&params.duration, "duration", 0, "run closed-loop tests for this long instead of a fixed number of proposals")
+	flag.BoolVar(&params.freshKeys, "freshkeys", false, "generate fresh RSA keys for every test instead of sharing pooled ones")
+	flag.Func("scheme", "signature scheme of the servers' keys: rsa-1024 (default), rsa-2048, rsa-3072, ecdsa-p256, ed25519, rsa-pss-2048 or rsa-pss-3072", func(name string) (err error) {
+		params.scheme, err = signing.ParseScheme(name)
+		return err
+	})
+	flag.DurationVar(&params.memSample, "memsample", 0, "sample memory during benchmarks at this interval (default: only before and after)")
+	flag.StringVar(&params.heapDir, "heapdir", "", "dump a heap profile to this directory at every memory sample of benchmarks")
+	flag.StringVar(&params.cpuDir, "cpudir", "", "write a CPU profile of the measurement window of every benchmark to this directory")
+	flag.StringVar(&params.mutexDir, "mutexdir", "", "write a mutex contention profile of the measurement window of every benchmark to this directory")
+	flag.DurationVar(&params.soak, "soak", 0, "run the soak test (TestSoak1) for this long (skipped otherwise)")
+	flag.StringVar(&params.persistDir, "persistdir", "", "write the persisted state of every server to this directory at the end of each test (see replay.go)")
+	flag.DurationVar(&params.slow, "slow", 0, "log every request slower than this end to end with its phases at the leader (see slow.go)")
+	flag.DurationVar(&params.selfCheck, "selfcheck", 0, "make every server check its own invariants at this interval and fail on violations (see selfcheck.go)")
+	flag.BoolVar(&params.audit, "audit", false, "make every server archive the messages it signs, i.e. to verify them with -persistdir (see audit.go)")
+	flag.BoolVar(&params.threshold, "threshold", false, "make every server certify its view changes and commits with threshold signatures (see threshold.go)")
+	flag.BoolVar(&params.pki, "pki", false, "start every server from certificates of the servers' keys by a test CA (see certificate.go)")
+	flag.IntVar(&params.presign, "presign", 0, "make every server sign client requests on this many crypto goroutines ahead of its lock (see presign.go)")
+	flag.BoolVar(&params.update, "update", false, "rewrite the golden traces in testdata/ with the traces of this run")
+	flag.Var(debug.Flag(), "debug", "per-module debug levels, i.e. xpaxos=2,network=0 (see debug/debug.go)")
+}
+
 //
 // ------------------------------ TEST FUNCTIONS ------------------------------
 //
@@ -30,8 +86,8 @@ func TestCommonCase1(t *testing.T) {
 	fmt.Println("Test: Common Case - Null Operation (t=1)")
 
 	iters := 5
-	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
+	for i := 0; cfg.running(i, iters); i++ {
+		cfg.propose(nil)
 		comparePrepareSeqNums(cfg)
 		compareExecuteSeqNums(cfg)
 		comparePrepareLogEntries(cfg)
@@ -47,8 +103,8 @@ func TestCommonCase2(t *testing.T) {
 	fmt.Println("Test: Common Case - Null Operation (t>1)")
 
 	iters := 5
-	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
+	for i := 0; cfg.running(i, iters); i++ {
+		cfg.propose(nil)
 		comparePrepareSeqNums(cfg)
 		compareExecuteSeqNums(cfg)
 		comparePrepareLogEntries(cfg)
@@ -67,8 +123,8 @@ func TestCommonCase3(t *testing.T) {
 	rand.Read(op) // Operation is a 1 kB random byte array
 
 	iters := 1000
-	for i := 0; i < iters; i++ {
-		cfg.client.Propose(op)
+	for i := 0; cfg.running(i, iters); i++ {
+		cfg.propose(op)
 	}
 
 	comparePrepareSeqNums(cfg)
@@ -88,8 +144,8 @@ func TestCommonCase4(t *testing.T) {
 	rand.Read(op) // Operation is a 1 kB random byte array
 
 	iters := 1000
-	for i := 0; i < iters; i++ {
-		cfg.client.Propose(op)
+	for i := 0; cfg.running(i, iters); i++ {
+		cfg.propose(op)
 	}
 
 	comparePrepareSeqNums(cfg)
@@ -98,393 +154,4265 @@ func TestCommonCase4(t *testing.T) {
 	compareCommitLogEntries(cfg)
 }
 
-func TestFullNetworkPartition1(t *testing.T) {
+func TestCommonCase5(t *testing.T) {
 	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	// XPaxos server (ID = 2) fails to send RPCs 100% of the time
-	cfg.net.SetFaultRate(2, 100)
+	cfg.setCodec(network.JSONCodec{})
 
-	fmt.Println("Test: Full Network Partition - Single Crash Failure (t=1)")
+	fmt.Println("Test: Common Case - 1kB Operation, JSON Codec (t=1)")
 
-	iters := 3
+	op := make([]byte, 1024)
+	rand.Read(op) // Operation is a 1 kB random byte array
+
+	iters := 10
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
+		cfg.propose(op)
 		comparePrepareSeqNums(cfg)
 		compareExecuteSeqNums(cfg)
 		comparePrepareLogEntries(cfg)
 		compareCommitLogEntries(cfg)
 	}
-
-	// It is very often (but not always) the case that the final view number is 6
-	//if cfg.xpServers[1].view != 6 || cfg.xpServers[3].view != 6 {
-	//	cfg.t.Fatal("Invalid current view (should be 6)!")
-	//}
 }
 
-func TestFullNetworkPartition2(t *testing.T) {
-	servers := 10
+func TestCommonCaseJitter1(t *testing.T) {
+	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	// XPaxos servers (ID = 2, 4, 6) fail to send RPCs 100% of the time
-	cfg.net.SetFaultRate(2, 100)
-	cfg.net.SetFaultRate(4, 100)
-	cfg.net.SetFaultRate(6, 100)
+	cfg.net.SetLatency(network.ConstantLatency{Delay: 20 * time.Millisecond})
+	cfg.setJitter(15 * time.Millisecond) // Noisy but well within DELTA
 
-	fmt.Println("Test: Full Network Partition - Single Crash Failure (t>1)")
+	fmt.Println("Test: Common Case - Latency Jitter (t=1)")
 
-	iters := 3
+	iters := 5
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
+		cfg.propose(nil)
 		comparePrepareSeqNums(cfg)
 		compareExecuteSeqNums(cfg)
 		comparePrepareLogEntries(cfg)
 		compareCommitLogEntries(cfg)
 	}
+
+	for i := 1; i < cfg.n; i++ {
+		cfg.xpServers[i].mu.Lock()
+		view := cfg.xpServers[i].view
+		cfg.xpServers[i].mu.Unlock()
+
+		if view != 1 {
+			cfg.t.Fatal("Jitter triggered a view change!")
+		}
+	}
 }
 
-func TestFullNetworkPartition3(t *testing.T) {
+func TestCommonCaseThrottled1(t *testing.T) {
 	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	cfg.net.SetFaultRate(2, 100)
+	cfg.setSendRate(2, 20) // The follower may only send 20 RPCs per second
 
-	fmt.Println("Test: Full Network Partition - Multiple Crash Failures (t=1)")
+	fmt.Println("Test: Common Case - Throttled Follower (t=1)")
 
 	iters := 10
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
-	}
-
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
-
-	cfg.net.SetFaultRate(2, 0)
-	cfg.net.SetFaultRate(3, 100)
-
-	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
+		cfg.propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
 	}
 
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
-
-	cfg.net.SetFaultRate(3, 0)
-	cfg.net.SetFaultRate(1, 100)
-
-	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
+	// The leader waits for the follower's commit of every request
+	if total := cfg.latencies.Mean() * time.Duration(iters); total < time.Duration(iters-1)*50*time.Millisecond {
+		cfg.t.Fatalf("Throttled follower committed too fast (%v)!", total)
 	}
-
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
 }
 
-func TestFullNetworkPartition4(t *testing.T) {
+func TestCommonCaseConcurrency1(t *testing.T) {
 	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	crash := rand.Intn(servers-1) + 1
-	cfg.net.SetFaultRate(crash, 100)
+	// With a single handler slot the leader's Replicate handler (waiting for the prepare reply)
+	// starves the follower's commit, whose Prepare handler in turn waits for the commit, so
+	// two slots is the minimum under which the common case makes progress
+	for i := 1; i < cfg.n; i++ {
+		cfg.setConcurrency(i, 2)
+	}
 
-	fmt.Println("Test: Full Network Partition - Multiple Crash Failures (t=1)")
+	fmt.Println("Test: Common Case - Two Concurrent Handlers per Server (t=1)")
 
-	iters := 50
+	iters := 5
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
-		cfg.net.SetFaultRate(crash, 0)
-		crash = rand.Intn(servers-1) + 1
-		cfg.net.SetFaultRate(crash, 100)
+		cfg.propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
 	}
-
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
 }
 
-func TestFullNetworkPartition5(t *testing.T) {
-	servers := 10
+func TestCommonCaseOrdering1(t *testing.T) {
+	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	cfg.net.SetFaultRate(2, 100)
-	cfg.net.SetFaultRate(4, 100)
-	cfg.net.SetFaultRate(6, 100)
+	events := cfg.net.Subscribe()
+	defer cfg.net.Unsubscribe(events)
 
-	fmt.Println("Test: Full Network Partition - Multiple Crash Failures (t>1)")
+	fmt.Println("Test: Common Case - No Commit Before Its Prepare (t=1)")
 
 	iters := 10
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
+		cfg.propose(nil)
 	}
 
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
-
-	cfg.net.SetFaultRate(2, 0)
-	cfg.net.SetFaultRate(4, 0)
-	cfg.net.SetFaultRate(6, 0)
-	cfg.net.SetFaultRate(3, 100)
-	cfg.net.SetFaultRate(5, 100)
-	cfg.net.SetFaultRate(7, 100)
+	// A follower only commits (i.e. sends XPaxos.Commit) requests that were prepared at it
+	prepared := map[int]int{}
+	committed := map[int]int{}
+	for len(events) > 0 {
+		ev := <-events
+		if ev.Type != network.DELIVERED {
+			continue
+		}
 
-	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
+		switch ev.SvcMeth {
+		case "XPaxos.Prepare":
+			prepared[ev.To.(int)]++
+		case "XPaxos.Commit":
+			committed[ev.From]++
+			if committed[ev.From] > prepared[ev.From] {
+				cfg.t.Fatalf("Commit from XPaxos server (%d) delivered before its prepare!", ev.From)
+			}
+		}
 	}
 
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
-
-	cfg.net.SetFaultRate(3, 0)
-	cfg.net.SetFaultRate(5, 0)
-	cfg.net.SetFaultRate(7, 0)
-	cfg.net.SetFaultRate(1, 100)
-	cfg.net.SetFaultRate(8, 100)
-	cfg.net.SetFaultRate(9, 100)
-
-	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
+	if len(committed) == 0 {
+		cfg.t.Fatal("No commits delivered!")
 	}
-
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
 }
 
-func TestFullNetworkPartition6(t *testing.T) {
-	servers := 10
+func TestCommonCaseBandwidth1(t *testing.T) {
+	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	crash1 := rand.Intn(servers-1) + 1
-	crash2 := rand.Intn(servers-1) + 1
-	cfg.net.SetFaultRate(crash1, 100)
-	cfg.net.SetFaultRate(crash2, 100)
+	fmt.Println("Test: Common Case - Bandwidth of 1kB Operations (t=1)")
 
-	fmt.Println("Test: Full Network Partition - Multiple Crash Failures (t>1)")
+	size := 1024
+	op := make([]byte, size)
+	rand.Read(op) // Operation is a 1 kB random byte array
 
 	iters := 10
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
-		cfg.net.SetFaultRate(crash1, 0)
-		cfg.net.SetFaultRate(crash2, 0)
-		crash1 = rand.Intn(servers-1) + 1
-		crash2 = rand.Intn(servers-1) + 1
-		cfg.net.SetFaultRate(crash1, 100)
-		cfg.net.SetFaultRate(crash2, 100)
+		cfg.propose(op)
 	}
 
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
+	// Each operation is sent to every XPaxos server by the client and to every follower
+	// by the leader; replies and commit messages carry digests only, and with a threshold key the
+	// shares of the commit certificate (prepare, and commit and reply of every follower)
+	minBytes := int64(iters * size * ((cfg.n - 1) + (cfg.n-2)/2))
+	maxBytes := 2 * minBytes
+	if thresholdShare := cfg.thresholdShare(1); thresholdShare != nil {
+		share, err := thresholdShare.Sign([32]byte{})
+		checkError(err)
+		data, err := encode(share)
+		checkError(err)
+		maxBytes += int64(iters * 3 * (cfg.n - 2) / 2 * len(data))
+	}
+	if total := cfg.totalBytes(); total < minBytes || total > maxBytes {
+		cfg.t.Fatalf("Invalid bandwidth usage (%d bytes, expected between %d and %d)!", total, minBytes, maxBytes)
+	}
 }
 
-func TestPartialNetworkPartition1(t *testing.T) {
+func TestCommonCaseCompression1(t *testing.T) {
 	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	// XPaxos server (ID = 2) fails to send RPCs 50% of the time
-	cfg.net.SetFaultRate(2, 50)
+	cfg.setCompression(1024)
 
-	fmt.Println("Test: Partial Network Partition - Single Partial Failure (t=1)")
+	fmt.Println("Test: Common Case - 16kB Compressible Operation, Compression (t=1)")
 
-	iters := 3
+	op := []byte(strings.Repeat("operation ", 1600)) // Operation is a 16 kB compressible byte array
+
+	iters := 5
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
+		cfg.propose(op)
 		comparePrepareSeqNums(cfg)
 		compareExecuteSeqNums(cfg)
 		comparePrepareLogEntries(cfg)
 		compareCommitLogEntries(cfg)
 	}
-}
-
-// Test sometimes fails if WAIT = false (because client times out before view change protocol completes)
-func TestPartialNetworkPartition2(t *testing.T) {
-	servers := 10
-	cfg := makeConfig(t, servers, false)
-	defer cfg.cleanup()
-
-	// XPaxos servers (ID = 2, 4, 6) fail to send RPCs 75%, 50%, and 25% of the time
-	cfg.net.SetFaultRate(2, 75)
-	cfg.net.SetFaultRate(4, 50)
-	cfg.net.SetFaultRate(6, 25)
 
-	fmt.Println("Test: Partial Network Partition - Single Partial Failure (t>1)")
-
-	iters := 3
-	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
-		comparePrepareSeqNums(cfg)
-		compareExecuteSeqNums(cfg)
-		comparePrepareLogEntries(cfg)
-		compareCommitLogEntries(cfg)
+	stats := cfg.net.CompressionStats()
+	if stats.Compressed == 0 || stats.Saved() <= 0 {
+		cfg.t.Fatal("Operations were not compressed!")
 	}
+	fmt.Printf("Compressed RPCs: %d, bytes saved: %d\n", stats.Compressed, stats.Saved())
 }
 
-func TestPartialNetworkPartition3(t *testing.T) {
+func TestCommonCaseMessages1(t *testing.T) {
 	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	partial := rand.Intn(servers-1) + 1
-	cfg.net.SetFaultRate(partial, 50)
+	fmt.Println("Test: Common Case - Message Complexity (t=1)")
 
-	fmt.Println("Test: Partial Network Partition - Multiple Partial Failures (t=1)")
+	cfg.beginRPCBudget()
 
-	iters := 50
+	iters := 10
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
-		cfg.net.SetFaultRate(partial, 0)
-		partial = rand.Intn(servers-1) + 1
-		cfg.net.SetFaultRate(partial, 50)
+		cfg.propose(nil)
 	}
 
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
+	cfg.assertRPCBudget(iters*cfg.maxRPCsPerRequest(), 0)
+
+	// The client sends each request to every XPaxos server (without retries), the leader
+	// prepares it at the only follower and the follower commits it at the leader
+	stats := cfg.net.MethodStats()
+	if stats["XPaxos.Replicate"].Count > iters*(cfg.n-1) {
+		cfg.t.Fatalf("Invalid number of replicate RPCs (%d)!", stats["XPaxos.Replicate"].Count)
+	}
+	if stats["XPaxos.Prepare"].Count != iters || stats["XPaxos.Commit"].Count != iters {
+		cfg.t.Fatalf("Invalid number of prepare/commit RPCs (%d/%d)!", stats["XPaxos.Prepare"].Count,
+			stats["XPaxos.Commit"].Count)
+	}
 }
 
-func TestPartialNetworkPartition4(t *testing.T) {
-	servers := 10
+// Collects the records of one server
+type records struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *records) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+func TestCommonCaseLogger1(t *testing.T) {
+	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	partial1 := rand.Intn(servers-1) + 1
-	partial2 := rand.Intn(servers-1) + 1
-	cfg.net.SetFaultRate(partial1, 25)
-	cfg.net.SetFaultRate(partial2, 75)
+	fmt.Println("Test: Common Case - Structured Records of a Server (t=1)")
 
-	fmt.Println("Test: Partial Network Partition - Multiple Partial Failures (t>1)")
+	saved := debug.String()
+	defer debug.Parse(saved)
+	debug.Parse("xpaxos=2,format=json")
 
-	iters := 10
+	leader := &records{}
+	cfg.xpServers[1].SetLogger(debug.MakeLogger(debug.XPAXOS).To(leader))
+
+	iters := 3
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
-		cfg.net.SetFaultRate(partial1, 0)
-		cfg.net.SetFaultRate(partial2, 0)
-		partial1 = rand.Intn(servers-1) + 1
-		partial2 = rand.Intn(servers-1) + 1
-		cfg.net.SetFaultRate(partial1, 25)
-		cfg.net.SetFaultRate(partial2, 75)
+		cfg.propose(nil)
 	}
 
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
+	leader.mu.Lock()
+	defer leader.mu.Unlock()
+
+	prepares := 0
+	for _, line := range strings.Split(strings.TrimSpace(leader.buf.String()), "\n") {
+		record := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			cfg.t.Fatalf("Record %q is not a JSON object: %v!", line, err)
+		}
+		if record["server"] != 1.0 {
+			cfg.t.Fatalf("Record of another server in the leader's records: %v!", record)
+		}
+		if strings.HasPrefix(record["msg"].(string), "Prepare:") {
+			if record["view"] != 1.0 || record["seqNum"] != float64(prepares+1) {
+				cfg.t.Fatalf("Prepare record without its view and sequence number: %v!", record)
+			}
+			prepares++
+		}
+	}
+	if prepares != iters {
+		cfg.t.Fatalf("%d prepare records for %d requests!", prepares, iters)
+	}
 }
 
-func TestByzantineFault1(t *testing.T) {
+func TestCommonCaseTraceIds1(t *testing.T) {
 	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	// XPaxos server (ID = 2) reshuffles bytes in the signature of messages it sends
-	cfg.xpServers[2].byzantine = true
+	fmt.Println("Test: Common Case - Trace IDs of Requests in Records and Events (t=1)")
 
-	fmt.Println("Test: Byzantine Fault - Single Failure (t=1)")
+	saved := debug.String()
+	defer debug.Parse(saved)
+	debug.Parse("xpaxos=2,format=json")
+
+	follower := &records{}
+	cfg.xpServers[2].SetLogger(debug.MakeLogger(debug.XPAXOS).To(follower))
+	events := cfg.net.Subscribe()
+	defer cfg.net.Unsubscribe(events)
 
 	iters := 3
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
-		comparePrepareSeqNums(cfg)
-		compareExecuteSeqNums(cfg)
-		comparePrepareLogEntries(cfg)
-		compareCommitLogEntries(cfg)
+		cfg.propose(nil)
+	}
+
+	traced := make(map[string]map[string]bool) // Trace ID -> methods of its messages
+	for drained := false; drained == false; {
+		select {
+		case ev := <-events:
+			if ev.Type != network.SENT || ev.SvcMeth == "XPaxos.Ping" {
+				continue
+			}
+			if ev.TraceId == "" {
+				cfg.t.Fatalf("%s event without a trace ID!", ev.SvcMeth)
+			}
+			if traced[ev.TraceId] == nil {
+				traced[ev.TraceId] = make(map[string]bool)
+			}
+			traced[ev.TraceId][ev.SvcMeth] = true
+		default:
+			drained = true
+		}
+	}
+	if len(traced) != iters {
+		cfg.t.Fatalf("%d trace IDs for %d requests!", len(traced), iters)
+	}
+	for traceId, methods := range traced {
+		if methods["XPaxos.Replicate"] == false || methods["XPaxos.Prepare"] == false ||
+			methods["XPaxos.Commit"] == false {
+			cfg.t.Fatalf("Trace %s without all messages of the common case: %v!", traceId, methods)
+		}
+	}
+
+	follower.mu.Lock()
+	defer follower.mu.Unlock()
+
+	commits := 0
+	for _, line := range strings.Split(strings.TrimSpace(follower.buf.String()), "\n") {
+		record := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			cfg.t.Fatalf("Record %q is not a JSON object: %v!", line, err)
+		}
+		if strings.HasPrefix(record["msg"].(string), "Commit:") {
+			if traceId, ok := record["trace"].(string); ok == false || traced[traceId] == nil {
+				cfg.t.Fatalf("Commit record without the trace ID of its request: %v!", record)
+			}
+			commits++
+		}
+	}
+	if commits < iters {
+		cfg.t.Fatalf("%d commit records for %d requests!", commits, iters)
 	}
 }
 
-func TestByzantineFault2(t *testing.T) {
-	servers := 10
+func TestCommonCaseSpans1(t *testing.T) {
+	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	// XPaxos servers (ID = 2, 4, 6) reshuffle bytes in the signature of messages they send
-	cfg.xpServers[2].byzantine = true
-	cfg.xpServers[4].byzantine = true
-	cfg.xpServers[6].byzantine = true
+	fmt.Println("Test: Common Case - Spans of the Phases of Requests (t=1)")
 
-	fmt.Println("Test: Byzantine Fault - Single Failure (t>1)")
+	cfg.setStateMachines(func() statemachine.StateMachine { return kvservice.MakeKV() })
+	exporter := tracing.MakeMemoryExporter()
+	tracers := make([]*tracing.Tracer, servers)
+	for i := 1; i < servers; i++ {
+		tracers[i] = tracing.MakeTracer("xpaxos", strconv.Itoa(i), exporter)
+		cfg.xpServers[i].SetTracer(tracers[i])
+	}
 
 	iters := 3
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
-		comparePrepareSeqNums(cfg)
-		compareExecuteSeqNums(cfg)
-		comparePrepareLogEntries(cfg)
-		compareCommitLogEntries(cfg)
+		cfg.propose(nil)
+	}
+	for i := 1; i < servers; i++ {
+		tracers[i].Flush()
+	}
+
+	traces := make(map[string]map[string]tracing.Span) // Trace ID -> "<name>@<server>" -> span
+	for _, span := range exporter.Spans() {
+		if traces[span.TraceId] == nil {
+			traces[span.TraceId] = make(map[string]tracing.Span)
+		}
+		traces[span.TraceId][span.Name+"@"+span.Instance] = span
+	}
+	if len(traces) != iters {
+		cfg.t.Fatalf("Spans of %d traces for %d requests!", len(traces), iters)
+	}
+
+	for traceId, spans := range traces {
+		for _, phase := range []string{"XPaxos.Replicate@1", "XPaxos.Prepare@2", "XPaxos.Commit@1",
+			"XPaxos.Execute@1", "XPaxos.Execute@2"} {
+			if _, ok := spans[phase]; ok == false {
+				cfg.t.Fatalf("Trace %s without a span of %s!", traceId, phase)
+			}
+		}
+		replicate, prepare := spans["XPaxos.Replicate@1"], spans["XPaxos.Prepare@2"]
+		if prepare.StartTime.Before(replicate.StartTime) || replicate.EndTime.Before(prepare.EndTime) {
+			cfg.t.Fatalf("Prepare span of trace %s outside of its replicate span!", traceId)
+		}
+		if replicate.Get("seqNum") != prepare.Get("seqNum") {
+			cfg.t.Fatalf("Spans of trace %s with different sequence numbers!", traceId)
+		}
 	}
 }
 
-func TestByzantineFault3(t *testing.T) {
+func TestCommonCaseSlowRequests1(t *testing.T) {
 	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	fault := rand.Intn(servers-1) + 1
-	cfg.xpServers[fault].byzantine = true
+	fmt.Println("Test: Common Case - Slow Requests with Their Phases (t=1)")
 
-	fmt.Println("Test: Byzantine Fault - Multiple Failures (t=1)")
+	saved := debug.String()
+	defer debug.Parse(saved)
+	debug.Parse("client=1,format=json")
 
-	iters := 50
+	client := &records{}
+	cfg.client.SetLogger(debug.MakeLogger(debug.CLIENT).To(client))
+
+	cfg.client.SetSlowThreshold(time.Hour)
+	cfg.propose(nil)
+	cfg.client.SetSlowThreshold(time.Nanosecond) // Every request is slow
+
+	iters := 3
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
-		cfg.xpServers[fault].byzantine = false
-		fault = rand.Intn(servers-1) + 1
-		cfg.xpServers[fault].byzantine = true
+		cfg.propose(nil)
 	}
 
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
+	slowRecords := func() []map[string]interface{} {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+
+		slow := make([]map[string]interface{}, 0)
+		for _, line := range strings.Split(strings.TrimSpace(client.buf.String()), "\n") {
+			record := make(map[string]interface{})
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				cfg.t.Fatalf("Record %q is not a JSON object: %v!", line, err)
+			}
+			if strings.HasPrefix(record["msg"].(string), "Slow:") {
+				slow = append(slow, record)
+			}
+		}
+		return slow
+	}
+
+	// Clients log slow requests once the leader returned their phases
+	slow := slowRecords()
+	for deadline := time.Now().Add(time.Second); len(slow) < iters && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		slow = slowRecords()
+	}
+	if len(slow) != iters {
+		cfg.t.Fatalf("%d slow request records for %d slow requests!", len(slow), iters)
+	}
+	for _, record := range slow {
+		for _, field := range []string{"trace", "latency", "leader", "lock", "prepare", "commit", "execute", "network"} {
+			if _, ok := record[field]; ok == false {
+				cfg.t.Fatalf("Slow request record without its %s: %v!", field, record)
+			}
+		}
+		if record["leader"] != 1.0 || record["latency"].(float64) < record["commit"].(float64) {
+			cfg.t.Fatalf("Invalid phases of a slow request: %v!", record)
+		}
+	}
 }
 
-func TestByzantineFault4(t *testing.T) {
+func TestCommonCaseMessages2(t *testing.T) {
 	servers := 10
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	fault1 := rand.Intn(servers-1) + 1
-	fault2 := rand.Intn(servers-1) + 1
-	cfg.xpServers[fault1].byzantine = true
-	cfg.xpServers[fault2].byzantine = true
+	fmt.Println("Test: Common Case - Message Complexity (t>1)")
 
-	fmt.Println("Test: Byzantine Fault - Multiple Failures (t>1)")
+	cfg.propose(nil) // Not counted
+	cfg.beginRPCBudget()
 
 	iters := 10
 	for i := 0; i < iters; i++ {
-		cfg.client.Propose(nil)
-		cfg.xpServers[fault1].byzantine = false
-		cfg.xpServers[fault2].byzantine = false
-		fault1 = rand.Intn(servers-1) + 1
-		fault2 = rand.Intn(servers-1) + 1
-		cfg.xpServers[fault1].byzantine = true
-		cfg.xpServers[fault2].byzantine = true
+		cfg.propose(nil)
 	}
 
-	comparePrepareSeqNums(cfg)
-	compareExecuteSeqNums(cfg)
-	comparePrepareLogEntries(cfg)
-	compareCommitLogEntries(cfg)
+	// Empty operations keep every message well below 1kB
+	cfg.assertRPCBudget(iters*cfg.maxRPCsPerRequest(), int64(iters*cfg.maxRPCsPerRequest()*1024))
+}
+
+func TestKeyPool1(t *testing.T) {
+	servers := 4
+	cfg1 := makeConfig(t, servers, false)
+	defer cfg1.cleanup()
+
+	fmt.Println("Test: Key Pool - Pooled and Fresh RSA Keys (t=1)")
+
+	cfg2 := makeConfigFreshKeys(t, servers, false)
+	defer cfg2.cleanup()
+
+	for i := 1; i < servers; i++ {
+		pooled, _ := pooledKeys(params.scheme, i)
+		if params.freshKeys == false && cfg1.privateKeys[i] != pooled {
+			cfg1.t.Fatal("Server did not get its pooled keys!")
+		}
+		if cfg2.privateKeys[i] == pooled {
+			cfg2.t.Fatal("Server got pooled keys instead of fresh ones!")
+		}
+	}
+
+	cfg1.propose(nil)
+	cfg2.propose(nil)
+	compareCommitLogEntries(cfg1)
+	compareCommitLogEntries(cfg2)
+}
+
+func TestSchemes1(t *testing.T) {
+	servers := 4
+
+	fmt.Println("Test: Signature Schemes - RSA, RSA-PSS, ECDSA and Ed25519 Keys (t=1)")
+
+	for _, scheme := range signing.Schemes() {
+		cfg := makeConfigScheme(t, servers, false, scheme)
+
+		for i := 1; i < cfg.n; i++ {
+			if keyScheme, err := signing.SchemeOf(cfg.publicKeys[i]); err != nil || keyScheme != scheme {
+				cfg.t.Fatalf("Server %d got a key of %v instead of %v (%v)!", i, keyScheme, scheme, err)
+			}
+		}
+
+		iters := 3
+		for i := 0; i < iters; i++ {
+			cfg.propose(nil)
+		}
+		compareExecuteSeqNums(cfg)
+		compareCommitLogEntries(cfg)
+
+		entries, _, _ := cfg.xpServers[1].CommitLog()
+		if problems := verifyEntry(entries[iters-1], iters, cfg.publicKeys); len(problems) > 0 {
+			cfg.t.Fatalf("Entry failed verification with %v keys: %v!", scheme, problems)
+		}
+		cfg.cleanup()
+	}
+}
+
+// Bound on the RPCs of a request in the common case: the client and the followers' pings to the
+// leader are O(n) while only the synchronous group of t+1 servers prepares and commits it, which
+// takes O(t^2) RPCs (unlike the O(n^2) of PBFT)
+func (cfg *config) maxRPCsPerRequest() int {
+	t := (cfg.n - 2) / 2
+	return 2*(cfg.n-1) + 2*(t+1)*(t+1)
+}
+
+func TestFullNetworkPartition1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// XPaxos server (ID = 2) fails to send RPCs 100% of the time
+	cfg.net.SetFaultRate(2, 100)
+
+	fmt.Println("Test: Full Network Partition - Single Crash Failure (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+
+	// It is very often (but not always) the case that the final view number is 6
+	//if cfg.xpServers[1].view != 6 || cfg.xpServers[3].view != 6 {
+	//	cfg.t.Fatal("Invalid current view (should be 6)!")
+	//}
+}
+
+func TestViewChange1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: View Change - Leader Crash Failure (t=1)")
+
+	cfg.propose(nil)
+	cfg.waitForView(1, time.Second)
+
+	// Leader of view 1 (ID = 1) fails to send RPCs 100% of the time
+	cfg.net.SetFaultRate(1, 100)
+
+	cfg.propose(nil)
+	leader := cfg.waitForNewLeader(1, 5*time.Second)
+	view := cfg.waitForView(2, time.Second)
+
+	if leader != ((view-1)%(cfg.n-1))+1 {
+		cfg.t.Fatal("Invalid leader of the current view!")
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+}
+
+func TestJournal1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Journal - Exactly One View Change (t=1)")
+
+	interval := 2
+	cfg.setCheckpointInterval(interval)
+
+	iters := 2 * interval
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+	cfg.waitForView(1, time.Second)
+
+	for server, count := range cfg.journalCounts(journal.VIEWCHANGED) {
+		if count != 0 {
+			cfg.t.Fatalf("Server (%d) journaled %d view changes before the leader failed!", server, count)
+		}
+	}
+
+	// Leader of view 1 (ID = 1) fails to send RPCs 100% of the time
+	cfg.net.SetFaultRate(1, 100)
+
+	cfg.propose(nil)
+	cfg.waitForNewLeader(1, 5*time.Second)
+	view := cfg.waitForNewView(2, time.Second) // Usually 2, unless the view change timed out
+
+	started := cfg.journalCounts(journal.VIEWCHANGESTARTED)
+	changed := cfg.journalCounts(journal.VIEWCHANGED)
+	checkpointed := cfg.journalCounts(journal.CHECKPOINTED)
+	executed := cfg.journalCounts(journal.EXECUTED)
+	for i := 2; i < cfg.n; i++ {
+		xp := cfg.xpServers[i]
+		xp.mu.Lock()
+		member, executeSeqNum := xp.synchronousGroup[i] && xp.view == view, xp.executeSeqNum
+		xp.mu.Unlock()
+
+		if member == false {
+			continue
+		}
+		if changed[i] == 0 || started[i] < changed[i] {
+			cfg.t.Fatalf("Server (%d) journaled %d view changes started and %d completed!", i, started[i],
+				changed[i])
+		}
+		if executed[i] != executeSeqNum {
+			cfg.t.Fatalf("Server (%d) journaled %d executed requests instead of %d!", i, executed[i], executeSeqNum)
+		}
+		if checkpointed[i] == 0 { // Servers joining the synchronous group adopt a checkpoint
+			cfg.t.Fatalf("Server (%d) journaled no checkpoints!", i)
+		}
+
+		entries := xp.Journal().Filter(journal.VIEWCHANGED)
+		views := make(map[int]bool)
+		for _, entry := range entries {
+			if views[entry.View] == true {
+				cfg.t.Fatalf("Server (%d) journaled two view changes to view %d!", i, entry.View)
+			}
+			views[entry.View] = true
+		}
+		if entries[len(entries)-1].View != view {
+			cfg.t.Fatalf("Server (%d) journaled a last view change to view %d instead of %d!", i,
+				entries[len(entries)-1].View, view)
+		}
+	}
+}
+
+func TestViewChangeStats1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: View Change - Duration, Messages and Re-proposed Requests (t=1)")
+
+	cfg.propose(nil)
+	cfg.waitForView(1, time.Second)
+
+	for i := 1; i < cfg.n; i++ {
+		if stats := cfg.xpServers[i].ViewChangeStats(); stats != (ViewChangeStats{}) {
+			cfg.t.Fatalf("Server (%d) counted view changes before the leader failed: %+v!", i, stats)
+		}
+	}
+
+	// Leader of view 1 (ID = 1) fails to send RPCs 100% of the time
+	cfg.net.SetFaultRate(1, 100)
+
+	start := time.Now()
+	cfg.propose(nil)
+	cfg.waitForNewLeader(1, 5*time.Second)
+	view := cfg.waitForNewView(2, time.Second)
+	elapsed := time.Since(start)
+
+	messages := 0
+	for i := 2; i < cfg.n; i++ {
+		xp := cfg.xpServers[i]
+		stats := xp.ViewChangeStats()
+		messages += stats.Messages
+
+		xp.mu.Lock()
+		member := xp.synchronousGroup[i] && xp.view == view
+		xp.mu.Unlock()
+		if member == false {
+			continue
+		}
+		if stats.Completed == 0 || stats.Completed != xp.Journal().Count(journal.VIEWCHANGED) ||
+			stats.Started < stats.Completed || stats.Last <= 0 || stats.Last > stats.Duration ||
+			stats.Duration > elapsed {
+			cfg.t.Fatalf("Invalid view change stats of server (%d): %+v!", i, stats)
+		}
+	}
+	if messages == 0 {
+		cfg.t.Fatal("No view change messages counted!")
+	}
+}
+
+// The follower's commit of an outstanding request reaches the leader only after the NEW-VIEW
+// of the next view (see network/hold.go)
+func TestViewChangeInterleaving1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: View Change - New-View Before Outstanding Commits (t=1)")
+
+	cfg.propose(nil)
+	cfg.waitForView(1, time.Second)
+
+	// Hold the commits of the follower of view 1 (ID = 2) to the leader (ID = 1)
+	cfg.net.Hold(func(svcMeth string, from int, to interface{}) bool {
+		return svcMeth == "XPaxos.Commit" && from == 2 && to == 1
+	})
+
+	go cfg.proposeAndRecord(nil) // Outstanding until its commit is released
+	cfg.net.WaitForHeld(1)
+	cfg.net.Hold(nil)
+
+	// XPaxos server (ID = 3) suspects the leader of view 1
+	go cfg.xpServers[3].issueSuspect(1)
+	cfg.waitForView(2, 5*time.Second)
+
+	cfg.net.ReleaseAll() // The commit of view 1 arrives in view 2
+
+	view := cfg.waitForSingleView(5 * time.Second)
+	if view < 2 {
+		cfg.t.Fatal("Servers returned to an earlier view!")
+	}
+
+	if cfg.proposeEventually(3, 2*time.Second) == false {
+		cfg.t.Fatal("Servers made no progress after the view change!")
+	}
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+}
+
+// Two view changes (to view 2 and to view 3) overlap: their view-change messages are delivered
+// alternately once both started
+func TestViewChangeInterleaving2(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: View Change - Two Interleaved View Changes (t=1)")
+
+	cfg.propose(nil)
+	cfg.waitForView(1, time.Second)
+
+	cfg.net.Hold(func(svcMeth string, from int, to interface{}) bool {
+		return svcMeth == "XPaxos.ViewChange"
+	})
+
+	// XPaxos server (ID = 3) suspects the leader of view 1, then XPaxos server (ID = 1) suspects the
+	// leader of view 2 before the first view change completes
+	go cfg.xpServers[3].issueSuspect(1)
+	cfg.waitForView(2, 5*time.Second)
+	go cfg.xpServers[1].issueSuspect(2)
+	cfg.waitForView(3, 5*time.Second)
+	cfg.net.Hold(nil)
+
+	byView := map[int][]int{} // View -> IDs of held view-change messages
+	for _, m := range cfg.net.Held() {
+		msg := ViewChangeMessage{}
+		if err := m.Decode(&msg); err != nil {
+			cfg.t.Fatal(err)
+		}
+		byView[msg.View] = append(byView[msg.View], m.Id)
+	}
+
+	if len(byView[2]) == 0 || len(byView[3]) == 0 {
+		cfg.t.Fatal("View changes did not overlap!")
+	}
+
+	for i := 0; i < len(byView[2]) || i < len(byView[3]); i++ {
+		for _, view := range []int{2, 3} {
+			if i < len(byView[view]) {
+				go cfg.net.Release(byView[view][i]) // The handler waits for the view-change timer
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+	cfg.net.ReleaseAll()
+
+	view := cfg.waitForSingleView(5 * time.Second)
+	if view < 3 {
+		cfg.t.Fatal("Servers returned to an earlier view!")
+	}
+
+	if cfg.proposeEventually(3, 2*time.Second) == false {
+		cfg.t.Fatal("Servers made no progress after the view changes!")
+	}
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+}
+
+// Every VIEW-CHANGE handler waiting on the network timer of the view returns once it fires, not
+// only the first one (see broadcastTimer())
+func TestViewChangeTimer1(t *testing.T) {
+	fmt.Println("Test: View Change - Handlers Waiting on the Network Timer All Return (t=1)")
+
+	leader, follower, outsider := roles(4, 2)
+	h := makeHandlerHarness(t, 4, follower)
+	h.apply("XPaxos.Suspect", h.suspect(outsider, 1))
+	h.quiesce()
+
+	done := make(chan bool, 2)
+	for _, sender := range []int{leader, outsider} {
+		msg := ViewChangeMessage{
+			MsgType:   VIEWCHANGE,
+			MsgDigest: digest(2),
+			Signature: h.sign(sender, digest(2)),
+			View:      2,
+			SenderId:  sender}
+		go func() {
+			h.apply("XPaxos.ViewChange", msg)
+			done <- true
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * network.DELTA * time.Millisecond):
+			t.Fatalf("%d of 2 VIEW-CHANGE handlers returned after the network timer!", i)
+		}
+	}
+}
+
+// A follower of the synchronous group never prepares an entry, since the leader's prepare is held
+// on its way to it: the commits of the other follower are never acknowledged (see fault.go)
+func TestFaultTimeout1(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Fault Detection - Unacknowledged Commits Lead to a View Change (t=2)")
+
+	cfg.propose(nil)
+	cfg.waitForView(1, time.Second)
+
+	xp := cfg.xpServers[1]
+	xp.mu.Lock()
+	leader := xp.getLeader()
+	followers := []int{}
+	for server := range xp.synchronousGroup {
+		if server != leader {
+			followers = append(followers, server)
+		}
+	}
+	xp.mu.Unlock()
+	sort.Ints(followers)
+	for i := 1; i < cfg.n; i++ {
+		cfg.xpServers[i].SetFaultTimeout(FAULTTIMEOUT / 2)
+	}
+
+	cfg.net.Hold(func(svcMeth string, from int, to interface{}) bool {
+		return svcMeth == "XPaxos.Prepare" && from == leader && to == followers[0]
+	})
+	go cfg.proposeAndRecord(nil) // Outstanding until the view changes
+	cfg.net.WaitForHeld(1)
+	cfg.net.Hold(nil)
+
+	cfg.waitForView(2, 5*time.Second)
+	cfg.net.ReleaseAll()
+
+	if cfg.proposeEventually(3, 2*time.Second) == false {
+		cfg.t.Fatal("Servers made no progress after the view change!")
+	}
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+}
+
+func TestGroupRotation1(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Group Rotation - Activated Servers Take Over the Committed Log (t=2)")
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+	cfg.waitForView(1, time.Second)
+
+	xp := cfg.xpServers[1]
+	xp.mu.Lock()
+	committed := append([]CommitLogEntry{}, xp.commitLog...)
+	before, after := xp.groupOf(1), xp.groupOf(2)
+	xp.mu.Unlock()
+
+	activated := 0
+	for server := range after {
+		if before[server] == false {
+			activated++
+		}
+	}
+	if len(after) != len(before) || activated == 0 {
+		t.Fatalf("Synchronous group of view 2 (%v) does not rotate that of view 1 (%v)!", after, before)
+	}
+
+	go cfg.xpServers[2].issueSuspect(1)
+	view := cfg.waitForNewView(2, 5*time.Second)
+
+	for server := range xp.groupOf(view) {
+		member := cfg.xpServers[server]
+		member.mu.Lock()
+		inGroup, executeSeqNum, commitLog := member.synchronousGroup[server], member.executeSeqNum, member.commitLog
+		member.mu.Unlock()
+
+		if inGroup == false || executeSeqNum < len(committed) {
+			t.Fatalf("Server (%d) of the group of view %d executed %d of %d entries!", server, view,
+				executeSeqNum, len(committed))
+		}
+		for seqNum, entry := range committed {
+			if commitLog[seqNum].Msg0.MsgDigest != entry.Msg0.MsgDigest || commitLog[seqNum].View != entry.View ||
+				commitLog[seqNum].Installation.View != view {
+				t.Fatalf("Server (%d) holds another entry at sequence number %d!", server, seqNum+1)
+			}
+		}
+	}
+
+	if cfg.proposeEventually(3, 2*time.Second) == false {
+		t.Fatal("Proposal failed in the rotated group!")
+	}
+	cfg.waitForExecuted(time.Second) // The first proposal may be acknowledged by the view change
+	compareExecuteSeqNums(cfg)
+}
+
+// The merge of a view change only counts the views of prepares signed by the leader of their view
+// for the sequence number and request of the entry (see acceptedView())
+func TestAcceptedView1(t *testing.T) {
+	fmt.Println("Test: Accepted View - Merge Decided on Signed Prepares Only (t=1)")
+
+	_, follower, _ := roles(4, 1)
+	h := makeHandlerHarness(t, 4, follower)
+	defer h.xp.Kill()
+
+	prepared := h.prepare(1, 1, 1)
+	entry := CommitLogEntry{Request: prepared.Request, Msg0: prepared.Msg0, View: 1}
+	installed := entry
+	installed.Installation = h.prepare(3, 1, 1).Msg0
+
+	_, follower3, _ := roles(4, 3)
+	byFollower := installed
+	byFollower.Installation.SenderId = follower3
+	byFollower.Installation.Signature = h.sign(follower3, byFollower.Installation.signedDigest())
+	forgedInstallation := installed
+	forgedInstallation.Installation.Signature = shuffle(installed.Installation.Signature)
+	liedInstallation := installed
+	liedInstallation.Installation.View = 5
+	movedInstallation := installed
+	movedInstallation.Installation = h.prepare(3, 2, 1).Msg0
+	otherInstallation := installed
+	otherInstallation.Installation = h.prepare(3, 1, 2).Msg0
+	liedView := entry
+	liedView.Msg0.View = 5
+	liedView.View = 5
+
+	cases := []struct {
+		name   string
+		entry  CommitLogEntry
+		seqNum int
+		view   int
+	}{
+		{"Committed entry", entry, 1, 1},
+		{"Installed entry", installed, 1, 3},
+		{"Installed by a follower", byFollower, 1, 1},
+		{"Installation with a forged signature", forgedInstallation, 1, 1},
+		{"Installation in a view it was not signed in", liedInstallation, 1, 1},
+		{"Installation of another sequence number", movedInstallation, 1, 1},
+		{"Installation of another request", otherInstallation, 1, 1},
+		{"Prepare in a view it was not signed in", liedView, 1, 0},
+		{"Prepare of another sequence number", installed, 2, 0},
+	}
+	for _, c := range cases {
+		if view := h.xp.acceptedView(c.entry, c.seqNum); view != c.view {
+			t.Fatalf("%s: accepted in view %d instead of %d!", c.name, view, c.view)
+		}
+	}
+}
+
+func TestStateTransfer1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: State Transfer - Follower Restarted Without State Catches Up (t=1)")
+
+	interval := 2
+	cfg.setCheckpointInterval(interval)
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	leader := cfg.xpServers[1]
+	leader.mu.Lock()
+	follower := 0
+	for server := range leader.synchronousGroup {
+		if server != leader.id {
+			follower = server
+		}
+	}
+	leader.mu.Unlock()
+
+	cfg.crash1(follower) // Restarts with an empty persister
+	cfg.mu.Lock()
+	cfg.saved[follower] = nil
+	cfg.mu.Unlock()
+	cfg.start1(follower)
+
+	// The leader's checkpoint with the snapshot of another state, which its certificate does not sign
+	reply := leader.executedState(StateRequest{SenderId: follower, View: 1, ExecuteSeqNum: 0})
+	if reply.Success == false || reply.Checkpoint.SeqNum < interval {
+		t.Fatalf("Leader shipped checkpoint %d (success: %v)!", reply.Checkpoint.SeqNum, reply.Success)
+	}
+	forged := reply
+	forged.Checkpoint.Snapshot = statemachine.MakeLog().Snapshot()
+
+	xp := cfg.xpServers[follower]
+	xp.mu.Lock()
+	err := xp.installState(leader.id, 1, forged)
+	executeSeqNum, checkpoint := xp.executeSeqNum, xp.checkpoint.SeqNum
+	xp.mu.Unlock()
+	if errors.Is(err, errSnapshot) == false || executeSeqNum != 0 || checkpoint != 0 {
+		t.Fatalf("Restarted follower installed %d entries from a forged snapshot of checkpoint %d (%v)!",
+			executeSeqNum, checkpoint, err)
+	}
+
+	// A state streamed without the follower asking for it is not even buffered
+	data, err := encode(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xp.InstallState(network.StreamChunk{StreamId: 1, Sender: leader.id, Seq: 0, Total: 1, Data: data},
+		&network.StreamReply{})
+	xp.mu.Lock()
+	executeSeqNum, pending := xp.executeSeqNum, xp.stateStreams.Pending()
+	xp.mu.Unlock()
+	if executeSeqNum != 0 || pending != 0 {
+		t.Fatalf("Restarted follower installed %d entries of a state it did not ask for!", executeSeqNum)
+	}
+	cfg.connect(follower)
+
+	if cfg.propose(nil) == false {
+		t.Fatal("Proposal failed after the restart of a follower!")
+	}
+	if view := getCurrentView(cfg); view != 1 {
+		t.Fatalf("Restarted follower caused a view change to view %d!", view)
+	}
+
+	xp.mu.Lock()
+	executeSeqNum, checkpoint = xp.executeSeqNum, xp.checkpoint.SeqNum
+	xp.mu.Unlock()
+	if executeSeqNum != iters+1 || checkpoint < interval {
+		t.Fatalf("Restarted follower executed %d entries from checkpoint %d instead of %d!", executeSeqNum,
+			checkpoint, iters+1)
+	}
+	compareExecuteSeqNums(cfg)
+	compareStateMachines(cfg)
+}
+
+// The leader ships its log with the commits of an entry stripped: the restarted follower installs
+// none of it, since a leader alone cannot certify an entry the group committed, not even with a
+// commit of its own
+func TestStateTransfer2(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: State Transfer - Entries Without Their Commit Certificate Are Rejected (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	leader := cfg.xpServers[1]
+	leader.mu.Lock()
+	follower := 0
+	for server := range leader.synchronousGroup {
+		if server != leader.id {
+			follower = server
+		}
+	}
+	leader.mu.Unlock()
+
+	cfg.crash1(follower) // Restarts with an empty persister, and stays disconnected
+	cfg.mu.Lock()
+	cfg.saved[follower] = nil
+	cfg.mu.Unlock()
+	cfg.start1(follower)
+
+	reply := leader.executedState(StateRequest{SenderId: follower, View: 1, ExecuteSeqNum: 0})
+	if reply.Success == false || len(reply.CommitLog) == 0 {
+		t.Fatalf("Leader shipped %d entries (success: %v)!", len(reply.CommitLog), reply.Success)
+	}
+
+	stripped := reply
+	stripped.CommitLog = append([]CommitLogEntry{}, reply.CommitLog...)
+	stripped.CommitLog[len(stripped.CommitLog)-1].Msg1 = nil
+	stripped.CommitLog[len(stripped.CommitLog)-1].Certificate = nil // With -threshold
+
+	xp := cfg.xpServers[follower]
+	xp.mu.Lock()
+	err := xp.installState(leader.id, 1, stripped)
+	executeSeqNum := xp.executeSeqNum
+	xp.mu.Unlock()
+	if err == nil || executeSeqNum != 0 {
+		t.Fatalf("Restarted follower installed %d entries of a log without commits (%v)!", executeSeqNum, err)
+	}
+
+	last := reply.CommitLog[len(reply.CommitLog)-1]
+	commit := Message{ // The leader commits the entry it prepared in place of the follower
+		MsgType:         COMMIT,
+		MsgDigest:       last.Msg0.MsgDigest,
+		PrepareSeqNum:   last.Msg0.PrepareSeqNum,
+		View:            last.Msg0.View,
+		ClientTimestamp: last.Msg0.ClientTimestamp,
+		SenderId:        leader.id}
+	leader.mu.Lock()
+	commit.Signature = leader.signUnaudited(commit.signedDigest())
+	leader.mu.Unlock()
+	stripped.CommitLog[len(stripped.CommitLog)-1].Msg1 = map[int]Message{leader.id: commit}
+
+	xp.mu.Lock()
+	err = xp.installState(leader.id, 1, stripped)
+	executeSeqNum = xp.executeSeqNum
+	xp.mu.Unlock()
+	if err == nil || executeSeqNum != 0 {
+		t.Fatalf("Restarted follower installed %d entries committed by the leader alone (%v)!", executeSeqNum, err)
+	}
+
+	xp.mu.Lock()
+	err = xp.installState(leader.id, 1, reply)
+	executeSeqNum = xp.executeSeqNum
+	xp.mu.Unlock()
+	if err != nil || executeSeqNum != len(reply.CommitLog) {
+		t.Fatalf("Restarted follower installed %d of %d certified entries (%v)!", executeSeqNum,
+			len(reply.CommitLog), err)
+	}
+}
+
+// With a threshold key the leader ships one certificate of constant size per entry instead of its
+// commits, which the restarted follower checks against the key
+func TestStateTransfer3(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: State Transfer - Threshold Certificates Stand In for the Commits (t=1)")
+
+	cfg.setThresholdKeys(true)
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	leader := cfg.xpServers[1]
+	leader.mu.Lock()
+	follower := 0
+	for server := range leader.synchronousGroup {
+		if server != leader.id {
+			follower = server
+		}
+	}
+	for seqNum, entry := range leader.commitLog { // Executed: one signature in place of the commits
+		if len(entry.Msg1) != 0 || entry.Certificate == nil {
+			leader.mu.Unlock()
+			t.Fatalf("Leader kept %d commits of executed entry %d (certificate: %v)!", len(entry.Msg1),
+				seqNum+1, entry.Certificate != nil)
+		}
+	}
+	leader.mu.Unlock()
+
+	cfg.crash1(follower) // Restarts with an empty persister, and stays disconnected
+	cfg.mu.Lock()
+	cfg.saved[follower] = nil
+	cfg.mu.Unlock()
+	cfg.start1(follower)
+
+	reply := leader.executedState(StateRequest{SenderId: follower, View: 1, ExecuteSeqNum: 0})
+	if reply.Success == false || len(reply.CommitLog) != iters {
+		t.Fatalf("Leader shipped %d of %d entries (success: %v)!", len(reply.CommitLog), iters, reply.Success)
+	}
+	modulus := len(pooledShares(servers)[0].Key.Modulus.Bytes())
+	for seqNum, entry := range reply.CommitLog {
+		if len(entry.Msg1) != 0 || len(entry.Certificate) == 0 || len(entry.Certificate) > modulus {
+			t.Fatalf("Entry %d shipped with %d commits and a certificate of %d bytes!", seqNum+1,
+				len(entry.Msg1), len(entry.Certificate))
+		}
+	}
+	leader.mu.Lock()
+	kept := leader.commitLog[0].Certificate
+	leader.mu.Unlock()
+	if bytes.Equal(kept, reply.CommitLog[0].Certificate) == false {
+		t.Fatal("Leader did not keep the certificate of its entry!")
+	}
+
+	swapped := reply
+	swapped.CommitLog = append([]CommitLogEntry{}, reply.CommitLog...)
+	swapped.CommitLog[0].Certificate = reply.CommitLog[1].Certificate
+
+	xp := cfg.xpServers[follower]
+	xp.mu.Lock()
+	err := xp.installState(leader.id, 1, swapped)
+	executeSeqNum := xp.executeSeqNum
+	xp.mu.Unlock()
+	if err == nil || executeSeqNum != 0 {
+		t.Fatalf("Restarted follower installed %d entries with the certificate of another one (%v)!",
+			executeSeqNum, err)
+	}
+
+	xp.mu.Lock()
+	err = xp.installState(leader.id, 1, reply)
+	executeSeqNum = xp.executeSeqNum
+	xp.mu.Unlock()
+	if err != nil || executeSeqNum != iters {
+		t.Fatalf("Restarted follower installed %d of %d certified entries (%v)!", executeSeqNum, iters, err)
+	}
+	if found := xp.SelfCheck(); len(found) != 0 {
+		t.Fatalf("Violations %v of the installed entries!", found)
+	}
+}
+
+func TestPartialSynchrony1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Partial Synchrony - Progress Resumes After GST (t=1)")
+
+	// Before GST the views may keep changing while a proposal is retransmitted, so the client stops
+	// waiting for it at GST
+	gst := cfg.partialSynchrony(300*time.Millisecond, 200*time.Millisecond, time.Second)
+	for time.Now().Before(gst) {
+		cfg.proposeWithin(nil, time.Until(gst))
+	}
+
+	iters := 10
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	// After GST the network is synchronous, so any view changes settle and proposals commit quickly
+	if elapsed := time.Since(gst); elapsed > 5*time.Second {
+		fmt.Printf("Committed %d proposals %v after GST\n", iters, elapsed)
+		cfg.t.Fatal("Progress did not resume after GST!")
+	}
+	// => A proposal the leader gave up on stays prepared by its followers, so sequence numbers are
+	//    not compared (agreement and linearizability are still checked on cleanup)
+}
+
+func TestAsymmetricNetworkPartition1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// XPaxos server (ID = 2) cannot reach the leader of view 1 (ID = 1) but the leader can still
+	// reach XPaxos server (ID = 2)
+	cfg.net.EnableLink(2, 1, false)
+
+	fmt.Println("Test: Asymmetric Network Partition - Single Link Failure (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+}
+
+func TestFullNetworkPartition2(t *testing.T) {
+	servers := 10
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// XPaxos servers (ID = 2, 4, 6) fail to send RPCs 100% of the time
+	cfg.net.SetFaultRate(2, 100)
+	cfg.net.SetFaultRate(4, 100)
+	cfg.net.SetFaultRate(6, 100)
+
+	fmt.Println("Test: Full Network Partition - Single Crash Failure (t>1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+}
+
+func TestFullNetworkPartition3(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	cfg.net.SetFaultRate(2, 100)
+
+	fmt.Println("Test: Full Network Partition - Multiple Crash Failures (t=1)")
+
+	iters := 10
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+
+	cfg.net.SetFaultRate(2, 0)
+	cfg.net.SetFaultRate(3, 100)
+
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+
+	cfg.net.SetFaultRate(3, 0)
+	cfg.net.SetFaultRate(1, 100)
+
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestFullNetworkPartition4(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	crash := cfg.rand.Intn(cfg.n-1) + 1
+	cfg.net.SetFaultRate(crash, 100)
+
+	fmt.Println("Test: Full Network Partition - Multiple Crash Failures (t=1)")
+
+	iters := 50
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		cfg.net.SetFaultRate(crash, 0)
+		crash = cfg.rand.Intn(cfg.n-1) + 1
+		cfg.net.SetFaultRate(crash, 100)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestFullNetworkPartition5(t *testing.T) {
+	servers := 10
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	cfg.net.SetFaultRate(2, 100)
+	cfg.net.SetFaultRate(4, 100)
+	cfg.net.SetFaultRate(6, 100)
+
+	fmt.Println("Test: Full Network Partition - Multiple Crash Failures (t>1)")
+
+	iters := 10
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+
+	cfg.net.SetFaultRate(2, 0)
+	cfg.net.SetFaultRate(4, 0)
+	cfg.net.SetFaultRate(6, 0)
+	cfg.net.SetFaultRate(3, 100)
+	cfg.net.SetFaultRate(5, 100)
+	cfg.net.SetFaultRate(7, 100)
+
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+
+	cfg.net.SetFaultRate(3, 0)
+	cfg.net.SetFaultRate(5, 0)
+	cfg.net.SetFaultRate(7, 0)
+	cfg.net.SetFaultRate(1, 100)
+	cfg.net.SetFaultRate(8, 100)
+	cfg.net.SetFaultRate(9, 100)
+
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestFullNetworkPartition6(t *testing.T) {
+	servers := 10
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	crash1 := cfg.rand.Intn(cfg.n-1) + 1
+	crash2 := cfg.rand.Intn(cfg.n-1) + 1
+	cfg.net.SetFaultRate(crash1, 100)
+	cfg.net.SetFaultRate(crash2, 100)
+
+	fmt.Println("Test: Full Network Partition - Multiple Crash Failures (t>1)")
+
+	iters := 10
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		cfg.net.SetFaultRate(crash1, 0)
+		cfg.net.SetFaultRate(crash2, 0)
+		crash1 = cfg.rand.Intn(cfg.n-1) + 1
+		crash2 = cfg.rand.Intn(cfg.n-1) + 1
+		cfg.net.SetFaultRate(crash1, 100)
+		cfg.net.SetFaultRate(crash2, 100)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestVirtualClock1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	clock := network.MakeVirtualClock()
+	cfg.setClock(clock)
+
+	// XPaxos server (ID = 2) fails to send RPCs 100% of the time
+	cfg.net.SetFaultRate(2, 100)
+
+	fmt.Println("Test: Virtual Clock - Single Crash Failure (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		done := make(chan bool)
+		go func() {
+			cfg.propose(nil)
+			done <- true
+		}()
+
+		// Timeouts only expire when the test advances the clock
+		for proposed := false; proposed == false; {
+			select {
+			case <-done:
+				proposed = true
+			case <-time.After(time.Millisecond):
+				clock.Advance(10 * time.Millisecond)
+			}
+		}
+
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+}
+
+// A SUSPECT message that fails is sent again after RETRANSMIT on the clock of its sender, not at
+// once, and no more once the view moved on
+func TestVirtualClock2(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	clock := network.MakeVirtualClock()
+	cfg.setClock(clock)
+
+	fmt.Println("Test: Virtual Clock - SUSPECT Retransmissions (t=1)")
+
+	suspecter := cfg.xpServers[1]
+	suspecter.mu.Lock()
+	view := suspecter.view
+	suspecter.mu.Unlock()
+
+	cfg.net.Hold(func(svcMeth string, from int, to interface{}) bool {
+		return svcMeth == "XPaxos.Suspect" && from == 1
+	})
+	pending := clock.Pending()
+	go suspecter.issueSuspect(view)
+
+	// Every SUSPECT fails (one per server, itself included) while the clock stands still
+	cfg.net.WaitForHeld(cfg.n - 1)
+	for _, m := range cfg.net.Held() {
+		cfg.net.Drop(m.Id)
+	}
+	for i := 0; i < 100 && clock.Pending() < pending+cfg.n-1; i++ { // Retransmissions wait on the clock
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if held := len(cfg.net.Held()); held != 0 {
+		cfg.t.Fatalf("%d SUSPECT messages retransmitted before RETRANSMIT!", held)
+	}
+
+	// They are sent again once RETRANSMIT passes, and deliver the view change
+	clock.Advance(RETRANSMIT)
+	cfg.net.WaitForHeld(cfg.n - 1)
+	cfg.net.Hold(nil)
+	cfg.net.ReleaseAll()
+	for moved := false; moved == false; {
+		suspecter.mu.Lock()
+		moved = suspecter.view > view
+		suspecter.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond) // Servers forward the SUSPECT once they move to the next view
+
+	// A SUSPECT of a view that is over is not retransmitted
+	cfg.net.Hold(func(svcMeth string, from int, to interface{}) bool {
+		return svcMeth == "XPaxos.Suspect" && from == 1
+	})
+	clock.Advance(RETRANSMIT)
+	time.Sleep(10 * time.Millisecond)
+	if held := len(cfg.net.Held()); held != 0 {
+		cfg.t.Fatalf("%d SUSPECT messages retransmitted after the view changed!", held)
+	}
+	cfg.net.Hold(nil)
+	cfg.net.ReleaseAll()
+}
+
+func TestPartialNetworkPartition1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// XPaxos server (ID = 2) fails to send RPCs 50% of the time
+	cfg.net.SetFaultRate(2, 50)
+
+	fmt.Println("Test: Partial Network Partition - Single Partial Failure (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+}
+
+// Test sometimes fails if WAIT = false (because client times out before view change protocol completes)
+func TestPartialNetworkPartition2(t *testing.T) {
+	servers := 10
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// XPaxos servers (ID = 2, 4, 6) fail to send RPCs 75%, 50%, and 25% of the time
+	cfg.net.SetFaultRate(2, 75)
+	cfg.net.SetFaultRate(4, 50)
+	cfg.net.SetFaultRate(6, 25)
+
+	fmt.Println("Test: Partial Network Partition - Single Partial Failure (t>1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+}
+
+func TestPartialNetworkPartition3(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	partial := cfg.rand.Intn(cfg.n-1) + 1
+	cfg.net.SetFaultRate(partial, 50)
+
+	fmt.Println("Test: Partial Network Partition - Multiple Partial Failures (t=1)")
+
+	iters := 50
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		cfg.net.SetFaultRate(partial, 0)
+		partial = cfg.rand.Intn(cfg.n-1) + 1
+		cfg.net.SetFaultRate(partial, 50)
+	}
+
+	// The last partial failure may have started a view change, which the servers complete once the
+	// network recovers; comparing them before would catch them in different views
+	cfg.net.SetFaultRate(partial, 0)
+	view := cfg.waitForSingleView(5 * time.Second)
+	cfg.waitForNewView(view, 5*time.Second)
+	cfg.waitForExecuted(time.Second)
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestPartialNetworkPartition4(t *testing.T) {
+	servers := 10
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	partial1 := cfg.rand.Intn(cfg.n-1) + 1
+	partial2 := cfg.rand.Intn(cfg.n-1) + 1
+	cfg.net.SetFaultRate(partial1, 25)
+	cfg.net.SetFaultRate(partial2, 75)
+
+	fmt.Println("Test: Partial Network Partition - Multiple Partial Failures (t>1)")
+
+	iters := 10
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		cfg.net.SetFaultRate(partial1, 0)
+		cfg.net.SetFaultRate(partial2, 0)
+		partial1 = cfg.rand.Intn(cfg.n-1) + 1
+		partial2 = cfg.rand.Intn(cfg.n-1) + 1
+		cfg.net.SetFaultRate(partial1, 25)
+		cfg.net.SetFaultRate(partial2, 75)
+	}
+
+	cfg.net.SetFaultRate(partial1, 0) // Same as TestPartialNetworkPartition3
+	cfg.net.SetFaultRate(partial2, 0)
+	view := cfg.waitForSingleView(5 * time.Second)
+	cfg.waitForNewView(view, 5*time.Second)
+	cfg.waitForExecuted(time.Second)
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestByzantineFault1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// XPaxos server (ID = 2) reshuffles bytes in the signature of messages it sends
+	cfg.setByzantine(2, CORRUPTSIGNATURE)
+
+	fmt.Println("Test: Byzantine Fault - Single Failure (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+}
+
+func TestByzantineFault2(t *testing.T) {
+	servers := 10
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// XPaxos servers (ID = 2, 4, 6) reshuffle bytes in the signature of messages they send
+	cfg.setByzantine(2, CORRUPTSIGNATURE)
+	cfg.setByzantine(4, CORRUPTSIGNATURE)
+	cfg.setByzantine(6, CORRUPTSIGNATURE)
+
+	fmt.Println("Test: Byzantine Fault - Single Failure (t>1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+}
+
+func TestByzantineFault3(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fault := cfg.rand.Intn(cfg.n-1) + 1
+	cfg.setByzantine(fault, CORRUPTSIGNATURE)
+
+	fmt.Println("Test: Byzantine Fault - Multiple Failures (t=1)")
+
+	iters := 50
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		cfg.setByzantine(fault, HONEST)
+		fault = cfg.rand.Intn(cfg.n-1) + 1
+		cfg.setByzantine(fault, CORRUPTSIGNATURE)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestByzantineFault4(t *testing.T) {
+	servers := 10
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fault1 := cfg.rand.Intn(cfg.n-1) + 1
+	fault2 := cfg.rand.Intn(cfg.n-1) + 1
+	cfg.setByzantine(fault1, CORRUPTSIGNATURE)
+	cfg.setByzantine(fault2, CORRUPTSIGNATURE)
+
+	fmt.Println("Test: Byzantine Fault - Multiple Failures (t>1)")
+
+	iters := 10
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		cfg.setByzantine(fault1, HONEST)
+		cfg.setByzantine(fault2, HONEST)
+		fault1 = cfg.rand.Intn(cfg.n-1) + 1
+		fault2 = cfg.rand.Intn(cfg.n-1) + 1
+		cfg.setByzantine(fault1, CORRUPTSIGNATURE)
+		cfg.setByzantine(fault2, CORRUPTSIGNATURE)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func testByzantineStrategy(t *testing.T, strategy int, desc string) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// XPaxos server (ID = 2) follows the Byzantine strategy (as a follower, then as the leader)
+	cfg.setByzantine(2, strategy)
+
+	fmt.Printf("Test: Byzantine Fault - %s (t=1)\n", desc)
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+}
+
+func TestByzantineDrop1(t *testing.T) {
+	testByzantineStrategy(t, DROP, "Dropped Messages")
+}
+
+func TestByzantineEquivocate1(t *testing.T) {
+	testByzantineStrategy(t, EQUIVOCATE, "Equivocation")
+}
+
+func TestByzantineLieView1(t *testing.T) {
+	testByzantineStrategy(t, LIEVIEW, "Lying About View")
+}
+
+func TestCrashRestart1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Crash and Restart - Leader and Follower (t=1)")
+
+	iters := 5
+	for server := 1; server < cfg.n; server++ {
+		for i := 0; i < iters; i++ {
+			cfg.propose(nil)
+		}
+
+		cfg.crashAndRestart(server) // Every XPaxos server (leader included) restarts once
+
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+
+	cfg.propose(nil)
+	compareExecuteSeqNums(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+// A restarted server whose persisted state is unreadable starts from an empty state
+func TestCrashRestart2(t *testing.T) {
+	fmt.Println("Test: Crash and Restart - Unreadable Persisted State (t=1)")
+
+	_, follower, _ := roles(4, 1)
+	h := makeHandlerHarness(t, 4, follower)
+	h.apply("XPaxos.Prepare", h.prepare(1, 1, 1))
+	h.apply("XPaxos.Prepare", h.prepare(1, 2, 2))
+	h.quiesce()
+
+	privateKey, _ := pooledKeys(params.scheme, follower)
+	restart := func(persister *Persister) *XPaxos {
+		return Make(h.xp.replicas, follower, persister, signing.KeySigner(privateKey), h.xp.publicKeys)
+	}
+
+	if xp := restart(h.xp.persister.Copy()); len(xp.commitLog) != 2 || xp.executeSeqNum != 2 {
+		t.Fatalf("Restarted server holds %d entries, %d executed, instead of 2!", len(xp.commitLog),
+			xp.executeSeqNum)
+	}
+
+	truncated := h.xp.persister.Copy()
+	truncated.logs[PREPARELOG] = truncated.logs[PREPARELOG][:1]
+	garbage := h.xp.persister.Copy()
+	garbage.SaveEntry(COMMITLOG, 1, []byte("garbage"))
+	for _, persister := range []*Persister{truncated, garbage} {
+		xp := restart(persister)
+		if xp.view != 1 || xp.prepareSeqNum != 0 || xp.executeSeqNum != 0 || len(xp.prepareLog) != 0 ||
+			len(xp.commitLog) != 0 {
+			t.Fatalf("Server restarted from an unreadable state in view %d with %d/%d entries!", xp.view,
+				len(xp.prepareLog), len(xp.commitLog))
+		}
+		if err := xp.readPersist(); errors.Is(err, errUnreadable) == false {
+			t.Fatalf("Unreadable state not reported: %v!", err)
+		}
+	}
+}
+
+func TestStateMachine1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: State Machine - Results and Replay After Restart (t=1)")
+
+	iters := 5
+	for i := 1; i <= iters; i++ {
+		result, ok := cfg.client.Execute(i)
+		if ok == false || string(result) != strconv.Itoa(i) { // Log machines count applied operations
+			cfg.t.Fatalf("Expected result %d, got %q!", i, result)
+		}
+		compareStateMachines(cfg)
+	}
+
+	// A restarted server rebuilds its state machine from the executed entries it persisted
+	for server := 1; server < cfg.n; server++ {
+		cfg.crashAndRestart(server)
+	}
+	compareStateMachines(cfg)
+
+	if result, ok := cfg.client.Execute(iters + 1); ok == false || string(result) != strconv.Itoa(iters+1) {
+		cfg.t.Fatalf("Expected result %d after restarts, got %q!", iters+1, result)
+	}
+	compareStateMachines(cfg)
+}
+
+func TestCheckpoint1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Checkpoints - Truncation and Restore After Restart (t=1)")
+
+	interval := 4
+	cfg.setCheckpointInterval(interval)
+
+	op := make([]byte, 1024)
+	rand.Read(op) // Operations are 1 kB random byte arrays
+
+	iters := 3*interval + 1
+	for i := 0; i < iters; i++ {
+		cfg.propose(op)
+	}
+	cfg.waitForCheckpoint(3 * interval)
+	compareStateMachines(cfg)
+
+	for i := 1; i < cfg.n; i++ {
+		xp := cfg.xpServers[i]
+		xp.mu.Lock()
+		checkpoint, truncated := xp.checkpoint, xp.truncated
+		commitLog := append([]CommitLogEntry(nil), xp.commitLog...)
+		prepared := len(xp.prepareLog)
+		stable := xp.checkStable(checkpoint)
+		xp.mu.Unlock()
+
+		if xp.synchronousGroup[i] == false {
+			continue // Never executed a request
+		}
+		if checkpoint.SeqNum != 3*interval || truncated != checkpoint.SeqNum || stable != nil {
+			cfg.t.Fatalf("Server %d truncated %d entries below checkpoint %d (%v) instead of %d!", i, truncated,
+				checkpoint.SeqNum, stable, 3*interval)
+		}
+		if len(commitLog) != iters-truncated || prepared != iters-truncated {
+			cfg.t.Fatalf("Server %d kept %d commit and %d prepare log entries instead of %d!", i, len(commitLog),
+				prepared, iters-truncated)
+		}
+
+		persisted := cfg.saved[i].ReadLog(COMMITLOG)
+		if cfg.saved[i].FirstEntry(COMMITLOG) != truncated || len(persisted) != len(commitLog) {
+			cfg.t.Fatalf("Server %d persisted %d entries from %d instead of %d from %d!", i, len(persisted),
+				cfg.saved[i].FirstEntry(COMMITLOG), len(commitLog), truncated)
+		}
+		for j := range commitLog {
+			entry := CommitLogEntry{}
+			checkError(decode(persisted[j], &entry))
+			if entry.Msg0.PrepareSeqNum != truncated+j+1 || digest(entry.Request) != commitLog[j].Msg0.MsgDigest {
+				cfg.t.Fatalf("Persisted entry %d of server %d does not match its digest!", truncated+j+1, i)
+			}
+		}
+	}
+
+	// Restarted servers restore their state machines from their checkpoints
+	for server := 1; server < cfg.n; server++ {
+		cfg.crashAndRestart(server)
+	}
+	compareStateMachines(cfg)
+
+	if result, ok := cfg.client.Execute(op); ok == false || string(result) != strconv.Itoa(iters+1) {
+		cfg.t.Fatalf("Expected result %d after restarts, got %q!", iters+1, result)
+	}
+	compareStateMachines(cfg)
+}
+
+func TestCheckpointBounded1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Checkpoints - Log Length Bounded Over Many Requests (t=1)")
+
+	interval := 5
+	cfg.setCheckpointInterval(interval)
+
+	// The logs hold the entries above the stable checkpoint, i.e. those of the checkpoint taken but
+	// not yet stable and those executed since
+	bound := 2*interval + 1
+	for i := 0; i < 40*interval; i++ {
+		cfg.propose(nil)
+
+		for j := 1; j < cfg.n; j++ {
+			xp := cfg.xpServers[j]
+			xp.mu.Lock()
+			commits, prepares := len(xp.commitLog), len(xp.prepareLog)
+			xp.mu.Unlock()
+			persisted := len(cfg.saved[j].ReadLog(COMMITLOG))
+
+			if commits > bound || prepares > bound || persisted > bound {
+				t.Fatalf("Server %d holds %d commit, %d prepare and %d persisted entries after %d requests!", j,
+					commits, prepares, persisted, i+1)
+			}
+		}
+	}
+	cfg.waitForCheckpoint(40 * interval)
+	compareStateMachines(cfg)
+}
+
+func TestCheckpointCertificate1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Checkpoints - Certificates of Stable Checkpoints (t=1)")
+
+	interval := 4
+	cfg.setCheckpointInterval(interval)
+	for i := 0; i < 2*interval+1; i++ {
+		cfg.propose(nil)
+	}
+	cfg.waitForCheckpoint(2 * interval)
+
+	leader, follower, outsider := cfg.xpServers[1], cfg.xpServers[2], cfg.xpServers[3]
+	commitLog, first, _ := cfg.fullCommitLog(leader)
+	leader.mu.Lock()
+	checkpoint := leader.checkpoint
+	leader.mu.Unlock()
+	if first != 0 || checkpoint.SeqNum != 2*interval ||
+		checkpoint.Chain != extendChain([32]byte{}, commitLog[:2*interval], 0) {
+		t.Fatalf("Checkpoint %d does not carry the head of the chain of its entries!", checkpoint.SeqNum)
+	}
+	follower.mu.Lock()
+	if follower.checkpoint.Chain != checkpoint.Chain {
+		t.Fatal("Servers of the synchronous group hold different chains at the same checkpoint!")
+	}
+	follower.mu.Unlock()
+
+	// A history that differs below the checkpoint, and a checkpoint of it signed by the leader alone
+	forged := append([]CommitLogEntry(nil), commitLog[:2*interval]...)
+	forged[1].Request.Operation = "forged"
+	forgedCheckpoint := checkpoint
+	forgedCheckpoint.Chain = extendChain([32]byte{}, forged, 0)
+	forgedCheckpoint.Signature, _ = signing.Sign(cfg.privateKeys[1], forgedCheckpoint.digest())
+	signature, _ := signing.Sign(cfg.privateKeys[1], forgedCheckpoint.stateDigest())
+	forgedCheckpoint.Certificate = map[int][]byte{1: signature}
+	tampered := checkpoint
+	tampered.Chain = forgedCheckpoint.Chain
+
+	for _, c := range []struct {
+		checkpoint Checkpoint
+		valid      bool
+	}{
+		{checkpoint, true},
+		{forgedCheckpoint, false}, // Signed by a single server
+		{tampered, false},         // Certificate of another state
+	} {
+		outsider.mu.Lock()
+		err := outsider.checkStable(c.checkpoint)
+		outsider.mu.Unlock()
+		if (err == nil) != c.valid {
+			t.Fatalf("Certificate of checkpoint %d: %v (expected valid=%v)!", c.checkpoint.SeqNum, err, c.valid)
+		}
+	}
+
+	outsider.mu.Lock()
+	outsider.adoptCheckpoint(forgedCheckpoint)
+	adopted := outsider.checkpoint.SeqNum
+	outsider.mu.Unlock()
+	if adopted != 0 {
+		t.Fatal("Server 3 adopted a checkpoint without a certificate!")
+	}
+
+	// The certificate of the checkpoint with the snapshot of another state, which it does not sign
+	outsider.mu.Lock()
+	forgedSnapshot := checkpoint
+	forgedSnapshot.Snapshot = outsider.stateMachine.Snapshot() // Of none of the entries
+	hash := outsider.stateMachine.Hash()
+	outsider.adoptCheckpoint(forgedSnapshot)
+	adopted = outsider.checkpoint.SeqNum
+	restored := outsider.stateMachine.Hash()
+	outsider.mu.Unlock()
+	if adopted != 0 || restored != hash {
+		t.Fatal("Server 3 adopted a snapshot that does not match the hash of its checkpoint!")
+	}
+
+	// The server outside the group adopts the checkpoint when it joins the group of view 2
+	cfg.net.SetFaultRate(1, 100)
+	cfg.propose(nil)
+	cfg.waitForNewLeader(1, 5*time.Second)
+
+	outsider.mu.Lock()
+	adoptedCheckpoint := outsider.checkpoint
+	outsider.mu.Unlock()
+	if adoptedCheckpoint.SeqNum != checkpoint.SeqNum || adoptedCheckpoint.Chain != checkpoint.Chain {
+		t.Fatalf("Server 3 adopted checkpoint %d instead of checkpoint %d of the same history!",
+			adoptedCheckpoint.SeqNum, checkpoint.SeqNum)
+	}
+	cfg.checkInvariants()
+}
+
+func TestReplay1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	startBanks(cfg)
+
+	fmt.Println("Test: Offline Replay - State and Signatures of Persisted Logs (t=1)")
+
+	interval := 4
+	cfg.setCheckpointInterval(interval)
+
+	iters := 2*interval + 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(bank.Encode(bank.RandomTransfer(cfg.rand, ACCOUNTS)))
+	}
+	cfg.waitForCheckpoint(2 * interval)
+
+	// The leader (ID = 1) executed every request by the time the client got the last reply
+	path := t.TempDir() + "/leader.persist"
+	checkError(cfg.saved[1].WriteFile(path))
+	persister, err := ReadPersister(path)
+	checkError(err)
+
+	replayed := bank.MakeBank(ACCOUNTS, BALANCE)
+	report, err := Replay(persister, replayed, cfg.publicKeys)
+	checkError(err)
+
+	if report.Checkpoint != 2*interval || report.Applied != iters || len(report.Problems) > 0 {
+		cfg.t.Fatalf("Replayed %d entries from checkpoint %d with problems %v!", report.Applied,
+			report.Checkpoint, report.Problems)
+	}
+	if digest(replayed.Snapshot()) != digest(cfg.machines[1].Snapshot()) {
+		cfg.t.Fatal("Replayed state machine differs from the leader's!")
+	}
+
+	// A commit message whose signature was tampered with after the fact
+	entry := CommitLogEntry{}
+	checkError(decode(persister.ReadLog(COMMITLOG)[iters-1-persister.FirstEntry(COMMITLOG)], &entry))
+	for server, msg := range entry.Msg1 {
+		msg.Signature[0] ^= 0xff
+		entry.Msg1[server] = msg
+	}
+	data, err := encode(entry)
+	checkError(err)
+	persister.SaveEntry(COMMITLOG, iters-1, data)
+
+	report, err = Replay(persister, bank.MakeBank(ACCOUNTS, BALANCE), cfg.publicKeys)
+	checkError(err)
+	if len(report.Problems) == 0 {
+		cfg.t.Fatal("Tampered signature not reported!")
+	}
+
+	// The certificate of the checkpoint, which stands in for the truncated entries, tampered with
+	persister, err = ReadPersister(path)
+	checkError(err)
+	checkpoint := Checkpoint{}
+	checkError(decode(persister.ReadSnapshot(), &checkpoint))
+	for server, signature := range checkpoint.Certificate {
+		signature[0] ^= 0xff
+		checkpoint.Certificate[server] = signature
+	}
+	data, err = encode(checkpoint)
+	checkError(err)
+	persister.SaveSnapshot(data)
+
+	report, err = Replay(persister, bank.MakeBank(ACCOUNTS, BALANCE), cfg.publicKeys)
+	checkError(err)
+	if strings.Contains(strings.Join(report.Problems, "\n"), "valid signatures") == false {
+		cfg.t.Fatalf("Certificate of the checkpoint tampered with but not reported: %v!", report.Problems)
+	}
+}
+
+func TestAudit1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Audit Trail - Signed Messages Verified Offline (t=1)")
+
+	cfg.setAuditTrail(true)
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	// A restarted server appends to the trail it persisted before crashing
+	archived, err := ReadAuditTrail(cfg.saved[2])
+	checkError(err)
+	cfg.crashAndRestart(2)
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	// The leader signs with its new key once the grace window of its key change ended
+	if cfg.rotateKey(1) == nil {
+		t.Fatal("Key change not committed!")
+	}
+	for i := 0; i < KEYGRACE+iters; i++ {
+		cfg.propose(nil)
+	}
+	if cfg.xpServers[1].signsWith(cfg.privateKeys[1]) == false {
+		t.Fatal("Leader does not sign with its new key!")
+	}
+
+	trails := make(map[int][]AuditEntry)
+	for i := 1; i < cfg.n; i++ {
+		path := fmt.Sprintf("%s/%d.persist", t.TempDir(), i)
+		checkError(cfg.saved[i].WriteFile(path))
+		persister, err := ReadPersister(path)
+		checkError(err)
+		trails[i], err = ReadAuditTrail(persister)
+		checkError(err)
+
+		for _, entry := range trails[i] {
+			if entry.Server != i {
+				t.Fatalf("Trail of server %d holds a message of server %d!", i, entry.Server)
+			}
+		}
+		if problems := VerifyAuditTrail(trails[i], cfg.keyHistory); len(problems) > 0 {
+			t.Fatalf("Trail of server %d failed verification: %v!", i, problems)
+		}
+		if equivocations := Equivocations(trails[i]); len(equivocations) > 0 {
+			t.Fatalf("Trail of server %d equivocates: %v!", i, equivocations)
+		}
+	}
+
+	// The leader archived the prepare of every entry with the fields it signed
+	prepares := make(map[[2]int]AuditEntry) // View and sequence number -> archived prepare
+	var last AuditEntry
+	for _, entry := range trails[1] {
+		if entry.MsgType == PREPARE {
+			prepares[[2]int{entry.View, entry.SeqNum}] = entry
+			last = entry
+		}
+	}
+	commitLog, _, _ := cfg.xpServers[1].CommitLog()
+	for _, entry := range commitLog {
+		prepare, ok := prepares[[2]int{entry.Msg0.View, entry.Msg0.PrepareSeqNum}]
+		if ok == false || prepare.MsgDigest != entry.Msg0.MsgDigest {
+			t.Fatalf("Prepare %d of view %d not archived!", entry.Msg0.PrepareSeqNum, entry.Msg0.View)
+		}
+	}
+
+	// Messages signed before the key change only verify against the old key of the leader
+	current := KeyHistory{1: cfg.keyHistory[1][1:]}
+	if problems := VerifyAuditTrail(trails[1], current); len(problems) == 0 {
+		t.Fatal("Messages signed with the old key of the leader verify against its new key!")
+	}
+	if len(archived) == 0 || len(trails[2]) <= len(archived) {
+		t.Fatalf("Restarted server archived %d messages after %d!", len(trails[2]), len(archived))
+	}
+
+	// A signature tampered with and a message of a server without a public key
+	tampered := append([]AuditEntry(nil), trails[1]...)
+	tampered[0].Signature = append([]byte(nil), tampered[0].Signature...)
+	tampered[0].Signature[0] ^= 0xff
+	unknown := tampered[1]
+	unknown.Server = cfg.n + 1
+	tampered = append(tampered, unknown)
+	if problems := VerifyAuditTrail(tampered, cfg.keyHistory); len(problems) != 2 {
+		t.Fatalf("Problems %v instead of 2!", problems)
+	}
+
+	// A prepare of another request at the sequence number of an archived one proves equivocation
+	equivocated := last
+	equivocated.MsgDigest = digest("another request")
+	equivocated.Signature, err = cfg.signer(1).Sign(equivocated.signedDigest())
+	checkError(err)
+	equivocating := append(append([]AuditEntry(nil), trails[1]...), equivocated)
+	if problems := VerifyAuditTrail(equivocating, cfg.keyHistory); len(problems) > 0 {
+		t.Fatalf("Equivocating prepare failed verification: %v!", problems)
+	}
+	if equivocations := Equivocations(equivocating); len(equivocations) != 1 {
+		t.Fatalf("Equivocations %v instead of 1!", equivocations)
+	}
+
+	cfg.setAuditTrail(false)
+	cfg.propose(nil)
+	if entries, _ := ReadAuditTrail(cfg.saved[1]); len(entries) != len(trails[1]) {
+		t.Fatal("Messages archived after the trail was disabled!")
+	}
+}
+
+type countingSigner struct {
+	signing.Signer
+	signatures int32
+}
+
+func (signer *countingSigner) Sign(msgDigest [32]byte) ([]byte, error) {
+	atomic.AddInt32(&signer.signatures, 1)
+	return signer.Signer.Sign(msgDigest)
+}
+
+func TestExternalSigner1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: External Signers - Servers Sign Through Signer Processes (t=1)")
+
+	// Every server signs through a signer serving its key on a socket, as if in another process
+	counting := make(map[int]*countingSigner)
+	for i := 1; i < cfg.n; i++ {
+		counting[i] = &countingSigner{Signer: signing.KeySigner(cfg.privateKeys[i])}
+		path := fmt.Sprintf("%s/%d.signer", t.TempDir(), i)
+		socket, err := signing.ServeSigner(path, counting[i])
+		checkError(err)
+		defer socket.Close()
+
+		cfg.signers[i], err = signing.DialSigner(path)
+		checkError(err)
+		cfg.crashAndRestart(i)
+	}
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		if cfg.propose(nil) == false {
+			t.Fatalf("Proposal %d failed!", i)
+		}
+	}
+	if atomic.LoadInt32(&counting[1].signatures) < int32(iters) { // Prepare messages of the leader
+		t.Fatalf("Leader signed %d messages through its signer instead of at least %d!",
+			atomic.LoadInt32(&counting[1].signatures), iters)
+	}
+}
+
+func TestKeyRotation1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Key Rotation - Leader Rotates Its Key Online (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	leader := cfg.xpServers[1].getLeader()
+	oldKey := cfg.privateKeys[leader]
+	newKey := cfg.rotateKey(leader)
+	if newKey == nil {
+		t.Fatal("Key change not committed!")
+	}
+
+	msgDigest := digest("rotation")
+	oldSignature, _ := signing.Sign(oldKey, msgDigest)
+	newSignature, _ := signing.Sign(newKey, msgDigest)
+	executed := func(i int) bool { // Whether server i executed the last proposal
+		_, _, executeSeqNum := cfg.xpServers[i].CommitLog()
+		_, _, leaderSeqNum := cfg.xpServers[leader].CommitLog()
+		return executeSeqNum == leaderSeqNum
+	}
+
+	// Grace window: both keys verify, and the leader still signs with its old key
+	if cfg.xpServers[leader].Journal().Count(journal.KEYCHANGED) != 1 {
+		t.Fatal("Leader did not apply its key change!")
+	}
+	if cfg.xpServers[leader].verify(leader, msgDigest, oldSignature) == false ||
+		cfg.xpServers[leader].verify(leader, msgDigest, newSignature) == false {
+		t.Fatal("Both keys do not verify during the grace window!")
+	}
+	if cfg.xpServers[leader].signsWith(oldKey) == false {
+		t.Fatal("Leader signs with its new key before the grace window ended!")
+	}
+
+	for i := 0; i < KEYGRACE; i++ {
+		cfg.propose(nil)
+	}
+	if cfg.xpServers[leader].signsWith(newKey) == false {
+		t.Fatal("Leader does not sign with its new key!")
+	}
+
+	for i := 0; i < KEYGRACE; i++ {
+		cfg.propose(nil)
+	}
+	for i := 1; i < cfg.n; i++ {
+		if executed(i) && (cfg.xpServers[i].verify(leader, msgDigest, oldSignature) == true ||
+			cfg.xpServers[i].verify(leader, msgDigest, newSignature) == false) {
+			t.Fatalf("Server %d does not verify with the new key only!", i)
+		}
+	}
+
+	// A replayed key change and one forged by another server are ignored
+	stale, err := cfg.xpServers[leader].RotateKey(signing.KeySigner(oldKey))
+	checkError(err)
+	stale.Rotation = 1
+	forged, err := cfg.xpServers[leader%(cfg.n-1)+1].RotateKey(signing.KeySigner(oldKey))
+	checkError(err)
+	forged.Server, forged.Rotation = leader, 2
+	cfg.propose(stale)
+	cfg.propose(forged)
+	cfg.propose(nil)
+	if cfg.xpServers[leader].Journal().Count(journal.KEYCHANGED) != 1 ||
+		cfg.xpServers[leader].signsWith(newKey) == false {
+		t.Fatal("Stale or forged key change applied!")
+	}
+
+	for _, count := range cfg.journalCounts(journal.VIEWCHANGESTARTED) {
+		if count > 0 {
+			t.Fatal("View change during the key rotation!")
+		}
+	}
+
+	// A restarted leader gets its new key from the config
+	cfg.crashAndRestart(leader)
+	for i := 0; i < iters; i++ {
+		if cfg.propose(nil) == false {
+			t.Fatal("Proposal failed after the leader restarted!")
+		}
+	}
+	if cfg.xpServers[leader].signsWith(newKey) == false {
+		t.Fatal("Restarted leader does not sign with its new key!")
+	}
+}
+
+func TestCertificates1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Certificates - Servers Start From and Rotate Certified Keys (t=1)")
+
+	cfg.setPKI(true)
+	for i := 1; i < cfg.n; i++ {
+		cfg.crashAndRestart(i) // From the certificates of the CA of the tests
+	}
+	if cfg.propose(nil) == false {
+		t.Fatal("Proposal failed between servers started from certificates!")
+	}
+
+	// A server does not start from a certificate of another server or of another CA
+	root := pooledCA().Root()
+	certificates := cfg.certificates()
+	certificates[2] = certificates[3]
+	if _, err := MakeCertified(nil, 1, MakePersister(), cfg.signer(1), root, certificates); err == nil {
+		t.Fatal("Server started with the certificate of server 3 as that of server 2!")
+	}
+	other, err := signing.MakeCA(signing.DEFAULT)
+	checkError(err)
+	certificates = cfg.certificates()
+	certificates[2], err = other.Issue(2, cfg.publicKeys[2])
+	checkError(err)
+	if _, err := MakeCertified(nil, 1, MakePersister(), cfg.signer(1), root, certificates); err == nil {
+		t.Fatal("Server started with a certificate of another CA!")
+	}
+	if _, err := MakeCertified(nil, 1, MakePersister(), cfg.signer(2), root, cfg.certificates()); err == nil {
+		t.Fatal("Server started with a signer of another key than that of its certificate!")
+	}
+
+	// A key change without a certificate of its new key is ignored, one with a certificate is not
+	leader := cfg.xpServers[1].getLeader()
+	privateKey, publicKey := generateKeys(cfg.scheme)
+	change, err := cfg.xpServers[leader].RotateKey(signing.KeySigner(privateKey))
+	checkError(err)
+	if change.Certificate, err = other.Issue(leader, publicKey); err != nil {
+		t.Fatal(err)
+	}
+	cfg.propose(change)
+	cfg.propose(nil)
+	if cfg.xpServers[leader].Journal().Count(journal.KEYCHANGED) != 0 {
+		t.Fatal("Key change certified by another CA applied!")
+	}
+	newKey := cfg.rotateKey(leader)
+	if newKey == nil {
+		t.Fatal("Key change not committed!")
+	}
+	for i := 1; i < cfg.n; i++ {
+		publicKey, _ := signing.MarshalPublicKey(cfg.xpServers[i].PublicKeys()[leader])
+		certified, _ := signing.MarshalPublicKey(newKey.Public())
+		if cfg.xpServers[i].Journal().Count(journal.KEYCHANGED) == 1 && bytes.Equal(publicKey, certified) == false {
+			t.Fatalf("Server %d did not apply the certified key change!", i)
+		}
+	}
+	if cfg.xpServers[leader].Journal().Count(journal.KEYCHANGED) != 1 {
+		t.Fatal("Leader did not apply its certified key change!")
+	}
+
+	// A restarted server starts from the certificate of the new key
+	cfg.crashAndRestart(leader)
+	if cfg.propose(nil) == false {
+		t.Fatal("Proposal failed after the leader restarted!")
+	}
+}
+
+func TestThresholdCertificate1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Threshold Certificates - New View Certified by One Signature (t=1)")
+
+	cfg.setThresholdKeys(true)
+	cfg.propose(nil)
+	cfg.waitForView(1, time.Second)
+
+	// Leader of view 1 (ID = 1) fails to send RPCs 100% of the time
+	cfg.net.SetFaultRate(1, 100)
+
+	cfg.propose(nil)
+	cfg.waitForNewLeader(1, 5*time.Second)
+	view := cfg.waitForNewView(2, time.Second)
+
+	key := pooledShares(cfg.n)[0].Key
+	certified := 0
+	for i := 2; i < cfg.n; i++ {
+		xp := cfg.xpServers[i]
+		xp.mu.Lock()
+		member, vcInProgress := xp.synchronousGroup[i] && xp.view == view, xp.vcInProgress
+		xp.mu.Unlock()
+
+		if member == false || vcInProgress == true {
+			continue
+		}
+		certificate := xp.ViewCertificate()
+		if err := key.Verify(digest(view), certificate); err != nil {
+			t.Fatalf("Server (%d) installed view %d without a valid certificate: %v!", i, view, err)
+		}
+		if len(certificate) != THRESHOLDBITS/8 { // One signature, whatever the size of the group
+			t.Fatalf("Certificate of %d bytes instead of %d!", len(certificate), THRESHOLDBITS/8)
+		}
+		certified++
+	}
+	if certified == 0 {
+		t.Fatalf("No server of the synchronous group of view %d installed it!", view)
+	}
+
+	if cfg.propose(nil) == false {
+		t.Fatal("Proposal failed in the certified view!")
+	}
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+}
+
+func TestDKG1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Distributed Key Generation - New View Certified Without a Dealer (t=1)")
+
+	shares := cfg.generateThresholdKeys(1, DKGTESTBITS)
+	key := shares[1].Key
+	for i := 1; i < cfg.n; i++ {
+		if shares[i].Signer != i || shares[i].Key.Modulus.Cmp(key.Modulus) != 0 {
+			t.Fatalf("Server (%d) generated another threshold key!", i)
+		}
+	}
+	if _, err := cfg.xpServers[1].GenerateThresholdKey(1, DKGTESTBITS); err == nil {
+		t.Fatal("Ceremony ran again with the number of an ended one!")
+	}
+
+	cfg.propose(nil)
+	cfg.waitForView(1, time.Second)
+
+	// Leader of view 1 (ID = 1) fails to send RPCs 100% of the time
+	cfg.net.SetFaultRate(1, 100)
+
+	cfg.propose(nil)
+	cfg.waitForNewLeader(1, 5*time.Second)
+	view := cfg.waitForNewView(2, time.Second)
+
+	certified := 0
+	for i := 2; i < cfg.n; i++ {
+		xp := cfg.xpServers[i]
+		xp.mu.Lock()
+		member, vcInProgress := xp.synchronousGroup[i] && xp.view == view, xp.vcInProgress
+		xp.mu.Unlock()
+
+		if member == false || vcInProgress == true {
+			continue
+		}
+		if err := key.Verify(digest(view), xp.ViewCertificate()); err != nil {
+			t.Fatalf("Server (%d) installed view %d without a valid certificate: %v!", i, view, err)
+		}
+		certified++
+	}
+	if certified == 0 {
+		t.Fatalf("No server of the synchronous group of view %d installed it!", view)
+	}
+
+	if cfg.propose(nil) == false {
+		t.Fatal("Proposal failed in the certified view!")
+	}
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+}
+
+func TestSessionKeys1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Session Keys - Pairwise MACs Before and After a Restart (t=1)")
+
+	cfg.establishSessions()
+	msgDigest := digest("prepare")
+	mac := cfg.xpServers[2].mac(1, msgDigest)
+	if cfg.xpServers[1].checkMAC(2, digest("commit"), mac) == true {
+		t.Fatal("MAC valid for another digest!")
+	}
+	if cfg.propose(nil) == false {
+		t.Fatal("Proposal failed!")
+	}
+
+	// The restarted server offers fresh keys, and its peers offer theirs in return
+	cfg.crashAndRestart(2)
+	if cfg.xpServers[2].EstablishSessions() != cfg.n-2 {
+		t.Fatal("Restarted server failed to establish its session keys!")
+	}
+	cfg.checkSessions()
+	if cfg.xpServers[1].checkMAC(2, msgDigest, mac) == true {
+		t.Fatal("MAC of the key of the server before its restart accepted!")
+	}
+	if cfg.propose(nil) == false {
+		t.Fatal("Proposal failed after the restart!")
+	}
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+}
+
+func TestPresign1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Presigning - Leader Signatures on a Pool of Crypto Goroutines (t=1)")
+
+	for i := 1; i < cfg.n; i++ {
+		cfg.xpServers[i].SetPresigners(2)
+	}
+
+	clients := cfg.makeClients(4)
+	acked, _ := cfg.contend(clients, 10, 0, 30*time.Second)
+	cfg.checkClients(acked)
+
+	// The prepare messages of the leader carry valid signatures of their requests
+	leader := cfg.xpServers[1]
+	commitLog, _, executed := leader.CommitLog()
+	if executed == 0 {
+		cfg.t.Fatal("No request executed with presigners!")
+	}
+	for seqNum, entry := range commitLog[:executed] {
+		if entry.Msg0.MsgDigest != digest(entry.Request) ||
+			leader.verify(entry.Msg0.SenderId, entry.Msg0.signedDigest(), entry.Msg0.Signature) == false {
+			cfg.t.Fatalf("Prepare message %d of the leader carries an invalid signature!", seqNum+1)
+		}
+	}
+
+	// A killed server neither presigns nor signs (see eraseKeys())
+	leader.Kill()
+	pending := leader.presign(digest("request"))
+	if pending.done != nil || pending.wait() != nil {
+		cfg.t.Fatal("Killed server still presigns!")
+	}
+}
+
+// The prepare of a request is signed by the presigners while the leader holds its lock, and taken
+// presigned once the request gets it
+func TestPresign2(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Presigning - Prepares Signed Off the Lock of the Leader (t=1)")
+
+	for i := 1; i < cfg.n; i++ {
+		cfg.xpServers[i].SetPresigners(2)
+	}
+
+	// Once the leader prepared a request of the view, the next ones guess their sequence number
+	iters := 5
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+	leader := cfg.xpServers[1]
+	hits, misses := leader.PresignStats()
+	if hits < int64(iters-1) {
+		cfg.t.Fatalf("Only %d of %d prepares presigned (%d misses)!", hits, iters, misses)
+	}
+
+	// The reply and the prepare of a request are both signed while the leader holds its lock
+	leader.mu.Lock()
+	signatures := atomic.LoadInt64(&leader.signatures)
+	done := make(chan bool)
+	go func() {
+		cfg.propose(nil)
+		done <- true
+	}()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&leader.signatures) < signatures+2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	signed := atomic.LoadInt64(&leader.signatures) - signatures
+	leader.mu.Unlock()
+	if signed < 2 {
+		cfg.t.Fatalf("Only %d signatures of the request made while the leader held its lock!", signed)
+	}
+	<-done
+
+	if newHits, newMisses := leader.PresignStats(); newHits != hits+1 || newMisses != misses {
+		cfg.t.Fatal("Prepare signed again under the lock of the leader!")
+	}
+	comparePrepareSeqNums(cfg)
+	compareCommitLogEntries(cfg)
+	commitLog, _, executed := leader.CommitLog()
+	last := commitLog[executed-1].Msg0
+	if leader.verify(last.SenderId, last.signedDigest(), last.Signature) == false {
+		cfg.t.Fatal("Presigned prepare carries an invalid signature!")
+	}
+}
+
+func TestVerifyCache1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Verification Cache - Signatures Verified Once (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	// The follower of the synchronous group verified the prepare messages of the leader when they arrived
+	var follower *XPaxos
+	var commitLog []CommitLogEntry
+	for i := 2; i < cfg.n; i++ {
+		if log, _, executed := cfg.xpServers[i].CommitLog(); executed == iters {
+			follower, commitLog = cfg.xpServers[i], log
+		}
+	}
+	if follower == nil {
+		t.Fatal("No follower executed the requests!")
+	}
+	msg0 := commitLog[0].Msg0
+	hits, misses := follower.VerifyCacheStats()
+	if follower.verify(msg0.SenderId, msg0.signedDigest(), msg0.Signature) == false {
+		t.Fatal("Prepare message of the leader does not verify!")
+	}
+	if newHits, newMisses := follower.VerifyCacheStats(); newHits != hits+1 || newMisses != misses {
+		t.Fatalf("Prepare message verified again (hits %d -> %d, misses %d -> %d)!", hits, newHits, misses,
+			newMisses)
+	}
+
+	// Invalid signatures are never remembered
+	forged := append([]byte{}, msg0.Signature...)
+	forged[0] ^= 0xff
+	for i := 0; i < 2; i++ {
+		hits, misses = follower.VerifyCacheStats()
+		if follower.verify(msg0.SenderId, msg0.signedDigest(), forged) == true {
+			t.Fatal("Forged signature verifies!")
+		}
+		if newHits, newMisses := follower.VerifyCacheStats(); newHits != hits || newMisses != misses+1 {
+			t.Fatal("Forged signature found in the cache!")
+		}
+	}
+
+	// The cache evicts its oldest signatures beyond its size
+	follower.SetVerifyCache(2)
+	for _, entry := range commitLog[:iters] {
+		follower.verify(entry.Msg0.SenderId, entry.Msg0.signedDigest(), entry.Msg0.Signature)
+	}
+	hits, misses = follower.VerifyCacheStats()
+	follower.verify(msg0.SenderId, msg0.signedDigest(), msg0.Signature)
+	last := commitLog[iters-1].Msg0
+	follower.verify(last.SenderId, last.signedDigest(), last.Signature)
+	if newHits, newMisses := follower.VerifyCacheStats(); newHits != hits+1 || newMisses != misses+1 {
+		t.Fatal("Cache did not evict its oldest signature!")
+	}
+
+	// A key change of the leader forgets its signatures, which its old key still verifies meanwhile
+	follower.SetVerifyCache(VERIFYCACHE)
+	follower.verify(msg0.SenderId, msg0.signedDigest(), msg0.Signature)
+	if cfg.rotateKey(msg0.SenderId) == nil {
+		t.Fatal("Key change not committed!")
+	}
+	hits, misses = follower.VerifyCacheStats()
+	if follower.verify(msg0.SenderId, msg0.signedDigest(), msg0.Signature) == false {
+		t.Fatal("Old key of the leader does not verify during the grace window!")
+	}
+	if newHits, newMisses := follower.VerifyCacheStats(); newHits != hits || newMisses != misses+1 {
+		t.Fatal("Signature of the old key found in the cache after the key change!")
+	}
+	for i := 0; i < 2*KEYGRACE; i++ {
+		cfg.propose(nil)
+	}
+	if follower.verify(msg0.SenderId, msg0.signedDigest(), msg0.Signature) == true {
+		t.Fatal("Signature of the retired key passes from the cache!")
+	}
+
+	// Without a cache, every signature is verified
+	follower.SetVerifyCache(0)
+	hits, misses = follower.VerifyCacheStats()
+	for i := 0; i < 2; i++ {
+		follower.verify(last.SenderId, last.signedDigest(), last.Signature)
+	}
+	if newHits, newMisses := follower.VerifyCacheStats(); newHits != hits || newMisses != misses {
+		t.Fatal("Server without a cache looked signatures up!")
+	}
+}
+
+// A signature remembered again, or forgotten and remembered again, is not evicted with the slot it
+// held before
+func TestVerifyCache2(t *testing.T) {
+	fmt.Println("Test: Verification Cache - Eviction of Keys Added Again")
+
+	keys := make([][32]byte, 5)
+	for i := range keys {
+		keys[i] = verifyKey(i%2, digest(i), []byte{byte(i)})
+	}
+	cache := makeVerifyCache(3)
+	cache.add(keys[0], 0)
+	cache.add(keys[1], 1)
+	cache.add(keys[0], 0) // Verified by two lookups at once
+	cache.add(keys[2], 0) // Wraps the ring: evicts the first slot of keys[0]
+	if _, ok := cache.entries[keys[0]]; ok == false {
+		t.Fatal("Key added again evicted with its first slot!")
+	}
+	cache.add(keys[3], 1)
+	if _, ok := cache.entries[keys[1]]; ok == true {
+		t.Fatal("Oldest key not evicted!")
+	}
+
+	cache.forget(0)
+	cache.add(keys[2], 0) // Evicts the slot of keys[0], which was forgotten
+	cache.add(keys[4], 0) // Evicts the first slot of keys[2]
+	if _, ok := cache.entries[keys[2]]; ok == false {
+		t.Fatal("Key forgotten and added again evicted with its first slot!")
+	}
+	if len(cache.entries) != 3 {
+		t.Fatalf("Cache holds %d entries instead of 3!", len(cache.entries))
+	}
+}
+
+func TestNonce1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Client Nonces - Signatures Bound to One Request (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	// Every request carries a nonce of its own
+	leader := cfg.xpServers[1]
+	commitLog, _, executed := leader.CommitLog()
+	if executed != iters {
+		t.Fatalf("Leader executed %d requests instead of %d!", executed, iters)
+	}
+	nonces := make(map[string]bool)
+	for seqNum, entry := range commitLog[:executed] {
+		if validNonce(entry.Request) == false || nonces[string(entry.Request.Nonce)] == true {
+			t.Fatalf("Request %d has no nonce of its own!", seqNum+1)
+		}
+		nonces[string(entry.Request.Nonce)] = true
+	}
+
+	// Signed messages of a request do not pass for those of the same request with another nonce
+	entry := commitLog[0]
+	spliced := entry.Request
+	spliced.Nonce = newNonce()
+	for sender, msg := range entry.Msg1 {
+		if leader.verify(sender, msg.signedDigest(), msg.Signature) == false {
+			t.Fatalf("Commit message of server %d does not verify!", sender)
+		}
+		msg.MsgDigest = digest(spliced)
+		if leader.verify(sender, msg.signedDigest(), msg.Signature) == true {
+			t.Fatalf("Commit message of server %d passes for another request!", sender)
+		}
+	}
+
+	// Requests without a nonce are neither prepared by the leader nor by its followers
+	request := ClientRequest{MsgType: REPLICATE, Timestamp: iters + 1, ClientId: CLIENT}
+	reply := &Reply{}
+	leader.Replicate(request, reply)
+	leader.mu.Lock()
+	prepareSeqNum := leader.prepareSeqNum
+	leader.mu.Unlock()
+	if reply.Success == true || prepareSeqNum != iters {
+		t.Fatal("Leader prepared a request without a nonce!")
+	}
+
+	msgDigest := digest(request)
+	prepareEntry := PrepareLogEntry{Request: request, Msg0: Message{MsgType: PREPARE, MsgDigest: msgDigest,
+		PrepareSeqNum: iters + 1, View: 1, SenderId: leader.id}}
+	prepareEntry.Msg0.Signature = leader.sign(prepareEntry.Msg0.signedDigest())
+	for i := 2; i < cfg.n; i++ {
+		follower := cfg.xpServers[i]
+		if _, _, executed := follower.CommitLog(); executed != iters {
+			continue // Not in the synchronous group
+		}
+		reply := &Reply{}
+		follower.Prepare(prepareEntry, reply)
+		if reply.Success == true || reply.Suspicious == false {
+			t.Fatalf("Server %d prepared a request without a nonce!", i)
+		}
+	}
+}
+
+func TestKeyErasure1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Key Erasure - Killed Servers Wipe Their Keys (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	leader := cfg.xpServers[1]
+	if leader.EstablishSessions() != cfg.n-2 {
+		t.Fatal("Leader did not establish its session keys!")
+	}
+	leader.keyMu.Lock()
+	signer := leader.signer
+	leader.keyMu.Unlock()
+
+	// The operator kills the leader, which wipes its keys, even if it keeps running
+	cfg.crash1(1)
+	msgDigest := digest("request")
+	if _, err := leader.signDigest(msgDigest); errors.Is(err, errErased) == false {
+		t.Fatalf("Killed server still signs: %v!", err)
+	}
+	if _, err := signer.Sign(msgDigest); err == nil {
+		t.Fatal("Signer of a killed server still signs!")
+	}
+	if leader.mac(2, msgDigest) != nil || leader.EstablishSessions() != 0 {
+		t.Fatal("Killed server still holds or makes session keys!")
+	}
+
+	// Restarted from the key of its operator, which the erasure left intact, the server signs again
+	cfg.start1(1)
+	cfg.connect(1)
+	if cfg.xpServers[1].verify(1, msgDigest, cfg.xpServers[1].sign(msgDigest)) == false {
+		t.Fatal("Restarted server does not sign!")
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, _, executed := cfg.xpServers[1].CommitLog(); executed >= iters {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("Restarted server failed to catch up!")
+		}
+	}
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestCommitCertificate1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Threshold Certificates - Executed Entries Keep One Signature (t=1)")
+
+	cfg.setThresholdKeys(true)
+	iters := 3
+	for i := 0; i < iters; i++ {
+		if cfg.propose(nil) == false {
+			t.Fatal("Proposal failed with threshold keys!")
+		}
+	}
+
+	key := pooledShares(cfg.n)[0].Key
+	leader := cfg.xpServers[1]
+	leader.mu.Lock()
+	group := make([]int, 0)
+	for server := range leader.synchronousGroup {
+		group = append(group, server)
+	}
+	leader.mu.Unlock()
+
+	for _, server := range group {
+		xp := cfg.xpServers[server]
+		xp.mu.Lock()
+		executeSeqNum, commitLog := xp.executeSeqNum, append([]CommitLogEntry{}, xp.commitLog...)
+		xp.mu.Unlock()
+
+		if executeSeqNum != iters {
+			t.Fatalf("Server (%d) executed %d of %d entries!", server, executeSeqNum, iters)
+		}
+		for seqNum, entry := range commitLog[:executeSeqNum] {
+			if len(entry.Msg1) != 0 {
+				t.Fatalf("Server (%d) kept %d commits of executed entry %d!", server, len(entry.Msg1), seqNum+1)
+			}
+			if err := key.Verify(entry.Msg0.commitDigest(), entry.Certificate); err != nil {
+				t.Fatalf("Server (%d) kept no valid certificate of entry %d: %v!", server, seqNum+1, err)
+			}
+			if len(entry.Certificate) != THRESHOLDBITS/8 { // One signature, whatever the size of the group
+				t.Fatalf("Certificate of %d bytes instead of %d!", len(entry.Certificate), THRESHOLDBITS/8)
+			}
+		}
+	}
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+}
+
+func TestReadIndex1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	cfg.setStateMachines(func() statemachine.StateMachine { return kvservice.MakeKV() })
+	clerk := kvservice.MakeClerk(cfg.client)
+
+	fmt.Println("Test: Read-Index Reads - Linearizable Reads Without Log Entries (t=1)")
+
+	for i := 0; i < 5; i++ {
+		if _, ok := clerk.Append("a", strconv.Itoa(i)); ok == false {
+			cfg.t.Fatal("Append not committed!")
+		}
+	}
+	executed := len(cfg.xpServers[1].commitLog)
+
+	// A burst of concurrent reads shares confirmation rounds and adds no log entries
+	reads := 20
+	results := make(chan string, reads)
+	for i := 0; i < reads; i++ {
+		go func() {
+			value, _ := cfg.client.Read(statemachine.Encode(kvservice.Op{Type: kvservice.GET, Key: "a"}))
+			results <- string(value)
+		}()
+	}
+	for i := 0; i < reads; i++ {
+		if value := <-results; value != "01234" {
+			cfg.t.Fatalf("Read %q instead of %q!", value, "01234")
+		}
+	}
+	if rounds := cfg.xpServers[1].ReadRounds(); rounds == 0 || rounds >= reads {
+		cfg.t.Fatalf("%d confirmation rounds for %d reads!", rounds, reads)
+	}
+	if len(cfg.xpServers[1].commitLog) != executed {
+		cfg.t.Fatal("Reads added log entries!")
+	}
+
+	// A leader cut off from its synchronous group cannot confirm its view
+	follower := 0
+	for server := range cfg.xpServers[1].synchronousGroup {
+		if server != 1 {
+			follower = server
+		}
+	}
+	cfg.disconnect(follower)
+	if _, ok := cfg.client.Read(statemachine.Encode(kvservice.Op{Type: kvservice.GET, Key: "a"})); ok == true {
+		cfg.t.Fatal("Read served without confirming the view!")
+	}
+	cfg.connect(follower)
+
+	if value, ok := clerk.Get("a"); ok == false || value != "01234" {
+		cfg.t.Fatalf("Get %q after reconnecting!", value)
+	}
+}
+
+func TestReadIndexViewChange1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	cfg.setStateMachines(func() statemachine.StateMachine { return kvservice.MakeKV() })
+	clerk := kvservice.MakeClerk(cfg.client)
+
+	fmt.Println("Test: Read-Index Reads - No Reads During a View Change (t=1)")
+
+	if _, ok := clerk.Put("a", "x"); ok == false {
+		cfg.t.Fatal("Put not committed!")
+	}
+
+	// The leader of view 2 starts a view change that cannot complete without the other servers
+	leader := cfg.xpServers[1].leaderOf(2)
+	for i := 1; i < servers; i++ {
+		if i != leader {
+			cfg.disconnect(i)
+		}
+	}
+	go cfg.xpServers[leader].issueSuspect(1)
+	for vcInProgress := false; vcInProgress == false; time.Sleep(time.Millisecond) {
+		xp := cfg.xpServers[leader]
+		xp.mu.Lock()
+		vcInProgress = xp.view == 2 && xp.vcInProgress
+		xp.mu.Unlock()
+	}
+
+	if _, ok := cfg.client.Read(statemachine.Encode(kvservice.Op{Type: kvservice.GET, Key: "a"})); ok == true {
+		cfg.t.Fatal("Read served during a view change!")
+	}
+	if rounds := cfg.xpServers[leader].ReadRounds(); rounds != 0 {
+		cfg.t.Fatalf("Leader of a view change in progress ran %d confirmation rounds!", rounds)
+	}
+
+	// Once a view is installed, its leader serves reads again
+	for i := 1; i < servers; i++ {
+		cfg.connect(i)
+	}
+	cfg.waitForNewView(2, 5*time.Second)
+	if value, ok := clerk.Get("a"); ok == false || value != "x" {
+		cfg.t.Fatalf("Get %q after the view change!", value)
+	}
+}
+
+func TestKVWatch1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	cfg.setStateMachines(func() statemachine.StateMachine { return kvservice.MakeKV() })
+	clerk := kvservice.MakeClerk(cfg.client)
+
+	fmt.Println("Test: Watches - Same Events in Log Order on Every Server (t=1)")
+
+	watchers := make(map[int]*kvservice.Watcher)
+	for server := range cfg.xpServers[1].synchronousGroup {
+		watchers[server] = cfg.machines[server].(*kvservice.KV).Watch("a")
+	}
+
+	iters := 10
+	for i := 0; i < iters; i++ {
+		if _, ok := clerk.Append("a", strconv.Itoa(i)); ok == false {
+			cfg.t.Fatal("Append not committed!")
+		}
+		clerk.Put("b", strconv.Itoa(i)) // Not watched
+	}
+
+	for server, w := range watchers {
+		value := ""
+		for i := 0; i < iters; i++ {
+			value += strconv.Itoa(i)
+			select {
+			case event := <-w.Events():
+				if event.Key != "a" || string(event.Value) != value {
+					cfg.t.Fatalf("XPaxos server (%d) delivered %+v instead of %q!", server, event, value)
+				}
+			case <-time.After(time.Second):
+				cfg.t.Fatalf("XPaxos server (%d) did not deliver the append of %d!", server, i)
+			}
+		}
+		w.Cancel()
+	}
+}
+
+func TestKVTTL1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	cfg.setStateMachines(func() statemachine.StateMachine { return kvservice.MakeKV() })
+	clerk := kvservice.MakeClerk(cfg.client)
+
+	fmt.Println("Test: Key-Value TTL - Keys Expire at the Leader's Time of Their Entries (t=1)")
+
+	ttl := 200 * time.Millisecond
+	if _, ok := clerk.PutTTL("a", "x", ttl); ok == false {
+		cfg.t.Fatal("Put not committed!")
+	}
+	if value, _ := clerk.Append("a", "y"); value != "xy" {
+		cfg.t.Fatalf("Expected xy before expiry, got %q!", value)
+	}
+	time.Sleep(2 * ttl)
+	clerk.Put("b", "z") // Past the expiry of a
+
+	// Every server applied the entries at the time the leader signed into them
+	hash := cfg.machines[1].Hash()
+	for server := range cfg.xpServers[1].synchronousGroup {
+		kv := cfg.machines[server].(*kvservice.KV)
+		if kv.Get("a") != "" || kv.Get("b") != "z" {
+			cfg.t.Fatalf("XPaxos server (%d) did not expire a!", server)
+		}
+		if kv.Hash() != hash {
+			cfg.t.Fatalf("XPaxos server (%d) applied the entries at other times!", server)
+		}
+	}
+
+	commitLog, _, _ := cfg.xpServers[2].CommitLog()
+	last := int64(0)
+	for i, entry := range commitLog {
+		if entry.Msg0.Time < last {
+			cfg.t.Fatalf("Entry %d has an earlier time than the one before it!", i+1)
+		}
+		for server, msg := range entry.Msg1 {
+			if msg.Time != entry.Msg0.Time {
+				cfg.t.Fatalf("XPaxos server (%d) committed entry %d at another time!", server, i+1)
+			}
+		}
+		last = entry.Msg0.Time
+	}
+	if last == 0 {
+		cfg.t.Fatal("Entries without the leader's time!")
+	}
+}
+
+func TestFailpoint1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Failpoints - Delayed and Dropped Persistence (t=1)")
+
+	// The leader of view 1 (ID = 1) persists its state 100ms late; server 2 never persists it
+	delay := 100 * time.Millisecond
+	cfg.setFailpoint(1, PERSISTPOINT, &failpoint{action: FAILDELAY, delay: delay, count: -1})
+	cfg.setFailpoint(2, PERSISTPOINT, &failpoint{action: FAILDROP, count: -1})
+
+	start := time.Now()
+	cfg.propose(nil)
+	if time.Since(start) < delay {
+		cfg.t.Fatal("Failpoint did not delay persistence!")
+	}
+
+	cfg.setFailpoint(1, PERSISTPOINT, nil)
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	if cfg.failpointHits(2, PERSISTPOINT) == 0 {
+		cfg.t.Fatal("Failpoint was never reached!")
+	}
+	if len(cfg.xpServers[2].persister.ReadLog(COMMITLOG)) != 0 {
+		cfg.t.Fatal("Failpoint did not drop persistence!")
+	}
+}
+
+func TestChecker1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Invariant Checker - Diverging Commit Logs (t=1)")
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	if violation := cfg.checker.violation(); violation != "" {
+		cfg.t.Fatal(violation)
+	}
+
+	// Server 2 pretends to have executed another request at sequence number 2
+	xp := cfg.xpServers[2]
+	xp.mu.Lock()
+	timestamp := xp.commitLog[1].Request.Timestamp
+	xp.commitLog[1].Request.Timestamp = -1
+	xp.mu.Unlock()
+
+	time.Sleep(2 * CHECKINTERVAL)
+
+	xp.mu.Lock()
+	xp.commitLog[1].Request.Timestamp = timestamp
+	xp.mu.Unlock()
+
+	if cfg.checker.violation() == "" {
+		cfg.t.Fatal("Invariant checker missed diverging commit logs!")
+	}
+
+	cfg.checker.mu.Lock()
+	cfg.checker.first = "" // The commit logs agree again
+	cfg.checker.mu.Unlock()
+}
+
+func TestChaos1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Chaos - Random Crashes, Partitions and Delays (t=1)")
+
+	seed := cfg.rand.Int63() // Logged by the nemesis to reproduce the fault sequence
+	nem := cfg.startNemesis(seed, 20*time.Millisecond, silencer(), partitioner(), delayer())
+
+	iters := 50
+	for i := 0; cfg.running(i, iters); i++ {
+		cfg.propose(nil)
+	}
+
+	nem.stop()
+	cfg.propose(nil) // Let the servers settle on a view once all faults are healed
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+// Every XPaxos server runs a bank of ACCOUNTS accounts (see bank/bank.go)
+const ACCOUNTS = 5
+const BALANCE = 1000
+
+func startBanks(cfg *config) {
+	cfg.setStateMachines(func() statemachine.StateMachine { return bank.MakeBank(ACCOUNTS, BALANCE) })
+}
+
+// Banks of the XPaxos servers, except the Byzantine ones (whose state is not to be trusted)
+func checkBanks(cfg *config) {
+	banks := make([]*bank.Bank, cfg.n)
+	for i := 1; i < cfg.n; i++ {
+		if xp := cfg.xpServers[i]; xp != nil {
+			xp.mu.Lock()
+			if xp.byzantine == HONEST {
+				banks[i] = cfg.machines[i].(*bank.Bank)
+			}
+			xp.mu.Unlock()
+		}
+	}
+
+	if err := bank.Check(banks, ACCOUNTS, BALANCE); err != nil {
+		cfg.t.Fatal(err)
+	}
+}
+
+func TestBank1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	startBanks(cfg)
+
+	fmt.Println("Test: Bank - Balance Conservation under Chaos (t=1)")
+
+	seed := cfg.rand.Int63() // Logged by the nemesis to reproduce the fault sequence
+	nem := cfg.startNemesis(seed, 20*time.Millisecond, silencer(), partitioner(), delayer())
+
+	iters := 50
+	for i := 0; cfg.running(i, iters); i++ {
+		cfg.propose(bank.Encode(bank.RandomTransfer(cfg.rand, ACCOUNTS)))
+	}
+
+	nem.stop()
+	cfg.propose(nil) // Let the servers settle on a view once all faults are healed
+
+	compareExecuteSeqNums(cfg)
+	compareStateMachines(cfg)
+	checkBanks(cfg)
+}
+
+func TestBank2(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	startBanks(cfg)
+
+	// XPaxos server (ID = 2) reshuffles bytes in the signature of messages it sends
+	cfg.setByzantine(2, CORRUPTSIGNATURE)
+
+	fmt.Println("Test: Bank - Balance Conservation with a Byzantine Server (t>1)")
+
+	iters := 20
+	for i := 0; i < iters; i++ {
+		cfg.propose(bank.Encode(bank.RandomTransfer(cfg.rand, ACCOUNTS)))
+		checkBanks(cfg)
+	}
+
+	compareExecuteSeqNums(cfg)
+	compareStateMachines(cfg)
+	checkBanks(cfg)
+}
+
+func TestNemesis1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Nemesis - Composed Silences, Delays and Clock Skew (t=1)")
+
+	// => Composing crasher(), partitioner() or byzantineFlipper() currently makes the commit logs
+	//    diverge: a server that missed requests (i.e. while crashed) has no way to catch up and
+	//    executes later requests at earlier sequence numbers once it rejoins the synchronous group
+	seed := cfg.rand.Int63() // Logged by the nemesis to reproduce the fault sequence
+	nem := cfg.startNemesis(seed, 50*time.Millisecond, silencer(), delayer(), clockSkewer())
+
+	// Reads and writes of skewed keys while the nemesis injects faults (see workload/workload.go)
+	gen := workload.MakeGenerator(workload.Config{ReadRatio: 0.5, Distribution: workload.ZIPFIAN},
+		cfg.rand.Int63())
+	stats := gen.Run(100, params.duration, func(i int, op workload.Op) bool {
+		return cfg.proposeWithin(op, time.Second)
+	})
+
+	nem.stop()
+	fmt.Printf("Client Proposed: %d Client Committed: %d\n", stats.Ops, stats.Committed)
+
+	cfg.analyze(nem)
+}
+
+func TestScenario1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Scenario - Crash and Delay from testdata/ (t=1)")
+
+	events, err := loadScenario("testdata/crash-delay.scenario")
+	if err != nil {
+		cfg.t.Fatal(err)
+	}
+
+	sc := cfg.startScenario(events)
+	for sc.done() == false {
+		cfg.propose(nil)
+	}
+	cfg.propose(nil) // Let the servers settle on a view once all faults are healed
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+
+	events, err = parseScenario("t=2s heal\nt=1s partition {1,2}|{3} # Comment")
+	if err != nil || len(events) != 2 || events[0].action != PARTITIONEVENT ||
+		reflect.DeepEqual(events[0].groups, [][]int{{1, 2}, {3}}) == false {
+		cfg.t.Fatal("Valid scenario parsed incorrectly!")
+	}
+
+	for _, text := range []string{"t=1s crash 0", "t=1s partition {1,2}", "crash 1", "t=1s explode 2",
+		"t=1s byzantine 2 lying"} {
+		if _, err := parseScenario(text); err == nil {
+			cfg.t.Fatalf("Invalid scenario %q parsed!", text)
+		}
+	}
+}
+
+// Meant to run with the race detector ("go test -race -run=Stress"): hundreds of proposals in flight
+// at once on an unreliable network interleave the leader's Replicate and the followers' Prepare
+// handlers, which release xp.mu while they wait for replies and take it again to execute
+// => Dropped messages cause dozens of view changes, so like TestChaos1 the test also fails when
+//    commit logs diverge across view changes
+func TestStress1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, true)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Stress - Hundreds of Concurrent Proposals on an Unreliable Network (t=1)")
+
+	proposals := 200
+	done := make(chan bool, proposals)
+	for i := 0; i < proposals; i++ {
+		go func(i int) {
+			done <- cfg.client.Propose(i) // Not recorded: the history assumes one proposal at a time
+		}(i)
+	}
+
+	replied := 0
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < proposals; i++ {
+		select {
+		case ok := <-done:
+			if ok {
+				replied++
+			}
+		case <-timeout:
+			i = proposals // Proposals the leader gave up on wait forever (WAIT = true)
+		}
+	}
+	fmt.Printf("Stress: %d of %d concurrent proposals replied to\n", replied, proposals)
+
+	// The servers must still make progress once the storm is over (and agree, see cfg.cleanup())
+	cfg.setUnreliable(false)
+	progress := make(chan bool, 1)
+	go func() {
+		progress <- cfg.client.Propose(nil)
+	}()
+
+	select {
+	case <-progress:
+	case <-time.After(5 * time.Second):
+		cfg.t.Fatal("Servers made no progress after the concurrent proposals!")
+	}
+	cfg.checkInvariants()
+}
+
+// Golden traces only hold for the cluster they were recorded with
+func skipTraceIfOverridden(t *testing.T) {
+	if params.n > 0 || params.f > 0 || params.unreliable {
+		t.Skip("Golden traces are recorded without -n, -f and -unreliable")
+	}
+}
+
+func TestMultiClient1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Multiple Clients - Per-Client Ordering and At-Most-Once Execution (t=1)")
+
+	clients := cfg.makeClients(5)
+	iters := 20
+	acked, results := cfg.contend(clients, iters, 0, 30*time.Second)
+
+	for _, client := range clients {
+		if len(acked[client.id]) != iters {
+			cfg.t.Fatalf("Client %d got %d of %d replies!", client.id, len(acked[client.id]), iters)
+		}
+	}
+	cfg.checkClients(acked)
+	cfg.checkResults(acked, results)
+}
+
+func TestMultiClient2(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Multiple Clients - Fair Throughput Under Contention (t=1)")
+
+	clients := cfg.makeClients(5)
+	acked, results := cfg.contend(clients, 0, 2*time.Second, 30*time.Second)
+
+	total := 0
+	for _, timestamps := range acked {
+		total += len(timestamps)
+	}
+	mean := float64(total) / float64(len(clients))
+
+	for _, client := range clients {
+		fmt.Printf("Client %d: %d committed\n", client.id, len(acked[client.id]))
+		if float64(len(acked[client.id])) < mean/2 {
+			cfg.t.Fatalf("Client %d committed %d requests (mean %.1f)!", client.id, len(acked[client.id]), mean)
+		}
+	}
+	cfg.checkClients(acked)
+	cfg.checkResults(acked, results)
+}
+
+func TestTraceCommonCase1(t *testing.T) {
+	skipTraceIfOverridden(t)
+
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Golden Trace - Common Case (t=1)")
+
+	tr := cfg.startTrace()
+	for i := 0; i < 3; i++ {
+		tr.step(fmt.Sprintf("Proposal %d", i+1), func() { cfg.propose(nil) })
+	}
+	tr.stop()
+
+	cfg.compareTrace(tr, "testdata/common-case.trace")
+}
+
+func TestTraceViewChange1(t *testing.T) {
+	skipTraceIfOverridden(t)
+
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Golden Trace - View Change After a Leader Failure (t=1)")
+
+	tr := cfg.startTrace()
+	tr.step("Proposal in view 1", func() { cfg.propose(nil) })
+
+	// Leader of view 1 (ID = 1) fails to send RPCs 100% of the time
+	cfg.net.SetFaultRate(1, 100)
+
+	tr.step("Proposal with a silent leader (view change)", func() { cfg.propose(nil) })
+	cfg.waitForNewLeader(1, time.Second)
+
+	tr.step("Proposal in the new view", func() { cfg.propose(nil) })
+	tr.stop()
+
+	cfg.compareTrace(tr, "testdata/view-change.trace")
+}
+
+// Log state machine whose Apply() panics once (after applying the operation) when armed is 1
+type panickingMachine struct {
+	*statemachine.Log
+	armed *int32
+}
+
+func (machine panickingMachine) Apply(op []byte) []byte {
+	result := machine.Log.Apply(op)
+	if atomic.CompareAndSwapInt32(machine.armed, 1, 0) {
+		panic("panicking state machine")
+	}
+	return result
+}
+
+func TestHandlerPanic1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Recovered Handler Panic - Leader Still Commits (t=1)")
+
+	armed := int32(0)
+	leader := cfg.xpServers[1]
+	leader.SetStateMachine(panickingMachine{statemachine.MakeLog(), &armed})
+
+	cfg.propose(nil)
+
+	// The leader's Replicate() panics while it holds xp.mu, and its RPC fails
+	atomic.StoreInt32(&armed, 1)
+	cfg.propose(nil)
+	if atomic.LoadInt32(&armed) != 0 {
+		t.Fatal("Handler did not panic!")
+	}
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		cfg.propose(nil)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if leader.mu.TryLock() {
+			leader.mu.Unlock()
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("Lock of the leader still held after its handler panicked!")
+		}
+	}
+	if _, _, executed := leader.CommitLog(); executed != iters+2 {
+		t.Fatalf("Leader executed %d requests instead of %d after its handler panicked!", executed, iters+2)
+	}
+}
+
+func TestHandlers1(t *testing.T) {
+	fmt.Println("Test: Handlers - Common Case (t=1)")
+
+	leader, follower, outsider := roles(4, 1)
+	suspects := []string{"XPaxos.Suspect 1", "XPaxos.Suspect 2", "XPaxos.Suspect 3"}
+
+	runHandlerCases(t, []handlerCase{
+		{name: "Replicate at the leader", id: leader, method: "XPaxos.Replicate",
+			msg:   func(h *handlerHarness) interface{} { return h.request(1) },
+			reply: Reply{Success: true, IsLeader: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, executeSeqNum: 1, prepared: 1, logged: 1, commits: 1},
+			sent:  []string{fmt.Sprintf("XPaxos.Prepare %d", follower)}},
+		{name: "Replicate of a prepared request", id: leader, method: "XPaxos.Replicate",
+			setup: func(h *handlerHarness) { h.apply("XPaxos.Replicate", h.request(1)) },
+			msg:   func(h *handlerHarness) interface{} { return h.request(1) },
+			reply: Reply{Success: true, IsLeader: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, executeSeqNum: 1, prepared: 1, logged: 1, commits: 1}},
+		{name: "Replicate with a silent follower", id: leader, method: "XPaxos.Replicate",
+			drop:  []string{"XPaxos.Prepare"},
+			msg:   func(h *handlerHarness) interface{} { return h.request(1) },
+			reply: Reply{IsLeader: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, prepared: 1, logged: 1},
+			sent:  append([]string{fmt.Sprintf("XPaxos.Prepare %d", follower)}, suspects...)},
+		{name: "Replicate at a follower", id: follower, method: "XPaxos.Replicate",
+			msg:   func(h *handlerHarness) interface{} { return h.request(1) },
+			state: handlerState{view: 1},
+			sent:  []string{fmt.Sprintf("XPaxos.Ping %d", leader)}},
+		{name: "Prepare", id: follower, method: "XPaxos.Prepare",
+			msg:   func(h *handlerHarness) interface{} { return h.prepare(1, 1, 1) },
+			reply: Reply{Success: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, executeSeqNum: 1, prepared: 1, logged: 1, commits: 1},
+			sent:  []string{fmt.Sprintf("XPaxos.Commit %d", leader)}},
+		{name: "Prepare of a prepared request", id: follower, method: "XPaxos.Prepare",
+			setup: func(h *handlerHarness) { h.apply("XPaxos.Prepare", h.prepare(1, 1, 1)) },
+			msg:   func(h *handlerHarness) interface{} { return h.prepare(1, 2, 1) },
+			reply: Reply{Success: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, executeSeqNum: 1, prepared: 1, logged: 1, commits: 1}},
+		{name: "Prepare with a gap", id: follower, method: "XPaxos.Prepare",
+			msg:   func(h *handlerHarness) interface{} { return h.prepare(1, 2, 1) },
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1},
+			sent:  append([]string{"XPaxos.StateTransfer 1"}, suspects...)}, // The leader ships no state
+		{name: "Prepare with a forged signature", id: follower, method: "XPaxos.Prepare",
+			msg: func(h *handlerHarness) interface{} {
+				prepareEntry := h.prepare(1, 1, 1)
+				prepareEntry.Msg0.Signature = h.sign(outsider, prepareEntry.Msg0.MsgDigest)
+				return prepareEntry
+			},
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1},
+			sent:  suspects},
+		{name: "Prepare moved to another sequence number", id: follower, method: "XPaxos.Prepare",
+			msg: func(h *handlerHarness) interface{} {
+				prepareEntry := h.prepare(1, 2, 1)
+				prepareEntry.Msg0.PrepareSeqNum = 1 // Signed for sequence number 2
+				return prepareEntry
+			},
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1},
+			sent:  suspects},
+		{name: "Prepare of a follower", id: follower, method: "XPaxos.Prepare",
+			msg: func(h *handlerHarness) interface{} {
+				prepareEntry := h.prepare(1, 1, 1)
+				prepareEntry.Msg0.SenderId = outsider // Not the leader of view 1
+				prepareEntry.Msg0.Signature = h.sign(outsider, prepareEntry.Msg0.signedDigest())
+				return prepareEntry
+			},
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1},
+			sent:  suspects},
+		{name: "Prepare from an unknown server", id: follower, method: "XPaxos.Prepare",
+			msg: func(h *handlerHarness) interface{} {
+				prepareEntry := h.prepare(1, 1, 1)
+				prepareEntry.Msg0.SenderId = h.n // No public key
+				return prepareEntry
+			},
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1},
+			sent:  suspects},
+		{name: "Prepare from a later view", id: follower, method: "XPaxos.Prepare",
+			msg:   func(h *handlerHarness) interface{} { return h.prepare(2, 1, 1) },
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1},
+			sent:  suspects},
+		{name: "Prepare from an earlier view", id: follower, method: "XPaxos.Prepare",
+			setup: func(h *handlerHarness) { h.setView(2) },
+			msg:   func(h *handlerHarness) interface{} { return h.prepare(1, 1, 1) },
+			state: handlerState{view: 2}},
+		{name: "Commit", id: leader, method: "XPaxos.Commit",
+			setup: func(h *handlerHarness) { h.prepared(1, 1, 1) },
+			msg:   func(h *handlerHarness) interface{} { return h.commit(follower, 1, 1, 1) },
+			reply: Reply{Success: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, prepared: 1, logged: 1, commits: 1}},
+		{name: "Commit of a different request", id: leader, method: "XPaxos.Commit",
+			setup: func(h *handlerHarness) { h.prepared(1, 1, 1) },
+			msg:   func(h *handlerHarness) interface{} { return h.commit(follower, 1, 1, 2) },
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, prepared: 1, logged: 1},
+			sent:  suspects},
+		{name: "Commit with a forged signature", id: leader, method: "XPaxos.Commit",
+			setup: func(h *handlerHarness) { h.prepared(1, 1, 1) },
+			msg: func(h *handlerHarness) interface{} {
+				msg := h.commit(follower, 1, 1, 1)
+				msg.Signature = h.sign(outsider, msg.MsgDigest)
+				return msg
+			},
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, prepared: 1, logged: 1},
+			sent:  suspects},
+		{name: "Commit moved to another view", id: leader, method: "XPaxos.Commit",
+			setup: func(h *handlerHarness) { h.prepared(1, 1, 1) },
+			msg: func(h *handlerHarness) interface{} {
+				msg := h.commit(follower, 2, 1, 1)
+				msg.View = 1 // Signed for view 2
+				return msg
+			},
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, prepared: 1, logged: 1},
+			sent:  suspects},
+		{name: "Commit from outside the synchronous group", id: leader, method: "XPaxos.Commit",
+			setup: func(h *handlerHarness) { h.prepared(1, 1, 1) },
+			msg:   func(h *handlerHarness) interface{} { return h.commit(outsider, 1, 1, 1) },
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, prepared: 1, logged: 1}},
+		{name: "Commit signed by the leader", id: follower, method: "XPaxos.Commit",
+			setup: func(h *handlerHarness) { h.prepared(1, 1, 1) },
+			msg:   func(h *handlerHarness) interface{} { return h.commit(leader, 1, 1, 1) },
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, prepared: 1, logged: 1}},
+		{name: "Commit from an unknown server", id: leader, method: "XPaxos.Commit",
+			setup: func(h *handlerHarness) { h.prepared(1, 1, 1) },
+			msg:   func(h *handlerHarness) interface{} { return h.commit(h.n, 1, 1, 1) }, // No public key
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, prepared: 1, logged: 1},
+			sent:  suspects},
+		{name: "Commit from a later view", id: leader, method: "XPaxos.Commit",
+			setup: func(h *handlerHarness) { h.prepared(1, 1, 1) },
+			msg:   func(h *handlerHarness) interface{} { return h.commit(follower, 2, 1, 1) },
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 1, prepareSeqNum: 1, prepared: 1, logged: 1},
+			sent:  suspects},
+		{name: "Commit from an earlier view", id: leader, method: "XPaxos.Commit",
+			setup: func(h *handlerHarness) { h.setView(2) },
+			msg:   func(h *handlerHarness) interface{} { return h.commit(follower, 1, 1, 1) },
+			reply: Reply{Suspicious: true},
+			state: handlerState{view: 2}},
+	})
+}
+
+func TestHandlers2(t *testing.T) {
+	fmt.Println("Test: Handlers - View Change (t=1)")
+
+	leader, _, outsider := roles(4, 1)
+	newLeader, newFollower, _ := roles(4, 2)
+	suspects := []string{"XPaxos.Suspect 1", "XPaxos.Suspect 2", "XPaxos.Suspect 3"}
+
+	runHandlerCases(t, []handlerCase{
+		{name: "Suspect", id: newLeader, method: "XPaxos.Suspect",
+			msg:   func(h *handlerHarness) interface{} { return h.suspect(outsider, 1) },
+			state: handlerState{view: 2},
+			sent: append([]string{fmt.Sprintf("XPaxos.ViewChange %d", newLeader),
+				fmt.Sprintf("XPaxos.ViewChange %d", newFollower)}, suspects...)},
+		{name: "Suspect with a forged signature", id: newLeader, method: "XPaxos.Suspect",
+			msg: func(h *handlerHarness) interface{} {
+				msg := h.suspect(outsider, 1)
+				msg.Signature = h.sign(leader, msg.MsgDigest)
+				return msg
+			},
+			state: handlerState{view: 1},
+			sent:  suspects},
+		{name: "Suspect of an earlier view", id: newLeader, method: "XPaxos.Suspect",
+			setup: func(h *handlerHarness) { h.setView(3) },
+			msg:   func(h *handlerHarness) interface{} { return h.suspect(outsider, 1) },
+			state: handlerState{view: 3}},
+		{name: "Ping", id: leader, method: "XPaxos.Ping",
+			msg:   func(h *handlerHarness) interface{} { return 1 },
+			state: handlerState{view: 1}},
+		{name: "New view certified by a threshold signature", id: newFollower, method: "XPaxos.NewView",
+			setup: func(h *handlerHarness) { h.setView(2); h.setThresholdKey() },
+			msg:   func(h *handlerHarness) interface{} { return h.newView(2, true) },
+			reply: Reply{Success: true},
+			state: handlerState{view: 2}},
+		{name: "New view without a certificate", id: newFollower, method: "XPaxos.NewView",
+			setup: func(h *handlerHarness) { h.setView(2); h.setThresholdKey() },
+			msg:   func(h *handlerHarness) interface{} { return h.newView(2, false) },
+			state: handlerState{view: 2},
+			sent:  suspects},
+	})
+}
+
+// Opt-in (-soak): continuous traffic with a crash and restart every few seconds; the number of
+// goroutines and the commit logs must stay bounded
+func TestSoak1(t *testing.T) {
+	if params.soak == 0 {
+		t.Skip("Soak test only runs with -soak (i.e. go test -run=Soak -timeout=1h -args -soak=10m)")
+	}
+
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test: Soak - Continuous Traffic and Periodic Crashes for %v (t=1)\n", params.soak)
+
+	// Checkpoints bound the logs and a key-value store of a bounded key space the state, so
+	// neither may grow with the number of requests
+	interval := 50
+	cfg.setCheckpointInterval(interval)
+	cfg.setStateMachines(func() statemachine.StateMachine { return kvservice.MakeKV() })
+	cfg.checker.setArchiving(false) // The archive of the harness would grow with every request
+
+	sampleEvery := time.Second
+	crashEvery := 5 * time.Second
+	slack := 100         // Goroutines allowed on top of twice the baseline (RPCs in flight, timers)
+	logSlack := interval // Entries of a checkpoint still waiting for the signatures to become stable
+	warmup := 5          // Samples before the heap baseline is taken
+	heapFactor := uint64(4)
+
+	var mem runtime.MemStats
+	baseline := 0
+	heapBaseline := uint64(0)
+	samples := 0
+	proposals := 0
+	nextSample := time.Now().Add(sampleEvery)
+	nextCrash := time.Now().Add(crashEvery)
+
+	// Small writes and reads of skewed keys (see workload/workload.go)
+	gen := workload.MakeGenerator(workload.Config{Size: 64, ReadRatio: 0.5, Distribution: workload.ZIPFIAN},
+		cfg.rand.Int63())
+
+	for start := time.Now(); time.Since(start) < params.soak; {
+		op := gen.Next()
+		kvOp := kvservice.Op{Type: kvservice.PUT, Key: op.Key, Value: op.Value}
+		if op.Read {
+			kvOp = kvservice.Op{Type: kvservice.GET, Key: op.Key}
+		}
+		cfg.checkInvariants()
+		cfg.client.Propose(statemachine.Encode(kvOp)) // Not recorded, the history would grow with every request too
+		proposals++
+
+		if time.Now().After(nextCrash) {
+			cfg.crashAndRestart(cfg.rand.Intn(cfg.n-1) + 1)
+			nextCrash = time.Now().Add(crashEvery)
+		}
+
+		if time.Now().Before(nextSample) {
+			continue
+		}
+		nextSample = time.Now().Add(sampleEvery)
+
+		runtime.GC()
+		runtime.ReadMemStats(&mem)
+		goroutines := runtime.NumGoroutine()
+		samples++
+
+		longest := 0
+		for i := 1; i < cfg.n; i++ {
+			if commitLog, _, _ := cfg.xpServers[i].CommitLog(); len(commitLog) > longest {
+				longest = len(commitLog)
+			}
+		}
+
+		fmt.Printf("Soak: %v proposals=%d log=%d goroutines=%d heap=%dkB\n", time.Since(start).Round(time.Second),
+			proposals, longest, goroutines, mem.HeapAlloc/1024)
+
+		if baseline == 0 {
+			baseline = goroutines
+		} else if goroutines > 2*baseline+slack {
+			pprof.Lookup("goroutine").WriteTo(os.Stdout, 1) // Goroutines grouped by stack
+			cfg.t.Fatalf("Goroutines grew from %d to %d!", baseline, goroutines)
+		}
+		if longest > interval+logSlack {
+			cfg.t.Fatalf("Commit log of %d entries with checkpoints every %d!", longest, interval)
+		}
+		if samples == warmup {
+			heapBaseline = mem.HeapAlloc
+		} else if samples > warmup && mem.HeapAlloc > heapFactor*heapBaseline {
+			pprof.Lookup("heap").WriteTo(os.Stdout, 1) // Allocations grouped by stack
+			cfg.t.Fatalf("Heap grew from %dkB to %dkB!", heapBaseline/1024, mem.HeapAlloc/1024)
+		}
+	}
+}
+
+func TestSelfCheck1(t *testing.T) {
+	fmt.Println("Test: Self-Check - Violations of a Server's Own Invariants (t=1)")
+
+	saved := debug.String()
+	defer debug.Parse(saved)
+	debug.Parse("xpaxos=1,format=json")
+
+	leader, follower, _ := roles(4, 1)
+	h := makeHandlerHarness(t, 4, follower)
+	defer h.xp.Kill()
+	logged := &records{}
+	h.xp.SetLogger(debug.MakeLogger(debug.XPAXOS).To(logged))
+
+	h.apply("XPaxos.Prepare", h.prepare(1, 1, 1))
+	h.apply("XPaxos.Prepare", h.prepare(1, 2, 2))
+	h.quiesce()
+	if found := h.xp.SelfCheck(); len(found) != 0 {
+		t.Fatalf("Violations %v of a correct server!", found)
+	}
+
+	h.xp.SetSelfCheckInterval(10 * time.Millisecond)
+
+	h.xp.mu.Lock()
+	delete(h.xp.commitLog[0].Msg1, follower) // Executed without its certificate
+	forged := h.commit(leader, 1, 2, 3)      // Commit of another request
+	h.xp.commitLog[1].Msg1[leader] = forged  // (the leader never commits)
+	h.xp.prepareSeqNum--                     // Prepare of entry 2 lost
+	h.xp.prepareLog = h.xp.prepareLog[:0]    // Prepare log lost
+	h.xp.mu.Unlock()
+
+	expected := []string{
+		"executeSeqNum 2 above prepareSeqNum 1",
+		"prepareSeqNum 1 above the prepare log length 0",
+		"prepareSeqNum decreased from 2 to 1 in view 1",
+		fmt.Sprintf("commit message of server %d does not match entry 2", leader),
+		"entry 2 holds 2 commit messages instead of at most 1",
+		"executed entry 1 holds 0 commit messages instead of 1"}
+	violations := h.xp.Violations()
+	for deadline := time.Now().Add(time.Second); len(violations) < len(expected) &&
+		time.Now().Before(deadline); violations = h.xp.Violations() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sort.Strings(violations)
+	sort.Strings(expected)
+	if reflect.DeepEqual(violations, expected) == false {
+		t.Fatalf("Violations %v instead of %v!", violations, expected)
+	}
+
+	// Every violation is logged once with the state of the server, however many checks found it
+	time.Sleep(50 * time.Millisecond)
+	if len(h.xp.SelfCheck()) != len(expected)-1 { // The decrease was only seen once
+		t.Fatal("Violations of the state not found again!")
+	}
+	logged.mu.Lock()
+	lines := strings.Split(strings.TrimSpace(logged.buf.String()), "\n")
+	logged.mu.Unlock()
+	if len(lines) != len(expected) {
+		t.Fatalf("%d records of %d violations!", len(lines), len(expected))
+	}
+	for _, line := range lines {
+		record := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Record %q is not a JSON object: %v!", line, err)
+		}
+		if strings.HasPrefix(record["msg"].(string), "Invariant violated: ") == false ||
+			record["server"] != float64(follower) || record["view"] != 1.0 || record["executeSeqNum"] != 2.0 ||
+			record["prepareSeqNum"] != 1.0 || record["commitLog"] != 2.0 || record["seqNum"] == nil {
+			t.Fatalf("Invalid record of a violation: %v!", record)
+		}
+	}
+}
+
+// Not a test: the body of an XPaxos server process spawned by makeProcessCluster (see process.go)
+func TestReplicaProcess(t *testing.T) {
+	id, err := strconv.Atoi(os.Getenv(REPLICAENV))
+	if err != nil {
+		t.Skip("Only runs as an XPaxos server process of a process cluster")
+	}
+	runReplica(id, os.Getenv(CLUSTERENV))
+}
+
+func TestProcessCluster1(t *testing.T) {
+	servers := 4
+	cl := makeProcessCluster(t, servers)
+	defer cl.cleanup()
+
+	fmt.Println("Test: Process Cluster - Real Process Kill (t=1)")
+
+	for i := 0; i < 10; i++ {
+		if cl.propose(i, 5*time.Second) == false {
+			cl.t.Fatal("Cluster failed to commit a request!")
+		}
+	}
+
+	cl.kill(3) // Not in the synchronous group of view 1
+
+	for i := 10; i < 20; i++ {
+		if cl.propose(i, 5*time.Second) == false {
+			cl.t.Fatal("Cluster made no progress after a replica was killed!")
+		}
+	}
+
+	// Operators see the killed replica and the leader's progress (see cluster.go)
+	statuses, err := ClusterStatus(cl.dir, time.Second)
+	checkError(err)
+	if statuses[2].Reachable == true || statuses[0].Reachable == false || statuses[0].ExecuteSeqNum != 20 {
+		cl.t.Fatalf("Unexpected cluster status %+v!", statuses)
+	}
+}
+
+func TestMetrics1(t *testing.T) {
+	fmt.Println("Test: Metrics - Deployed Replicas over Unix Sockets (t=1)")
+
+	dir, err := os.MkdirTemp("", "xpaxos") // Short, since socket paths are limited in length
+	checkError(err)
+	defer os.RemoveAll(dir)
+
+	replicas := 3
+	checkError(InitCluster(dir, replicas, params.scheme))
+	xps := make([]*XPaxos, replicas+1)
+	for id := 1; id <= replicas; id++ {
+		xp, socket, err := StartReplica(dir, id, nil, 0)
+		checkError(err)
+		xps[id] = xp
+		defer xp.Kill()
+		defer socket.Close()
+	}
+
+	client, err := ConnectClient(dir)
+	checkError(err)
+	iters := 5
+	for i := 0; i < iters; i++ {
+		if _, ok := client.Execute(i); ok == false {
+			t.Fatal("Cluster failed to commit a request!")
+		}
+	}
+
+	server := httptest.NewServer(xps[1].Metrics())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	checkError(err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	checkError(err)
+
+	for _, line := range []string{`xpaxos_view{server="1"} 1`, `xpaxos_executed_total{server="1"} 5`,
+		`xpaxos_commit_log_length{server="1"} 5`, `xpaxos_rpc_duration_seconds_count{server="1",method="XPaxos.Prepare"} 5`} {
+		if strings.Contains(string(body), line+"\n") == false {
+			t.Fatalf("Metrics of the leader lack %q:\n%s", line, body)
+		}
+	}
+	if strings.Contains(string(body), `xpaxos_signatures_total{server="1"} 0`) == true {
+		t.Fatal("Signatures of the leader not counted!")
+	}
+	if strings.Contains(string(body), `xpaxos_view_changes_total{server="1"} 0`+"\n") == false {
+		t.Fatal("View changes of the leader not exported!")
+	}
+	if strings.Contains(string(body), `xpaxos_sign_seconds_total{server="1"} 0`+"\n") == true ||
+		strings.Contains(string(body), `xpaxos_verify_seconds_total{server="1"}`) == false {
+		t.Fatal("Time spent signing by the leader not measured!")
+	}
+	if strings.Contains(string(body), `xpaxos_verify_cache_misses_total{server="1"} 0`+"\n") == true ||
+		strings.Contains(string(body), `xpaxos_verify_cache_hits_total{server="1"}`) == false {
+		t.Fatal("Verification cache of the leader not exported!")
+	}
+	for _, line := range []string{`xpaxos_pending_requests{server="1"} 0`, `xpaxos_unexecuted_entries{server="1"} 0`,
+		`xpaxos_rpcs_in_flight{server="1",peer="2"} 0`} {
+		if strings.Contains(string(body), line+"\n") == false {
+			t.Fatalf("Queues of the leader lack %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestClusterConfig1(t *testing.T) {
+	fmt.Println("Test: Cluster Configuration - Servers Bootstrapped From PEM Keys (t=1)")
+
+	dir, err := os.MkdirTemp("", "xpaxos") // Short, since socket paths are limited in length
+	checkError(err)
+	defer os.RemoveAll(dir)
+
+	replicas := 3
+	checkError(InitCluster(dir, replicas, params.scheme))
+	path := dir + "/" + CLUSTERCONFIG
+	for id := 1; id <= replicas; id++ {
+		key, err := signing.ReadPrivateKeyFile(fmt.Sprintf("%s/%d.key.pem", dir, id))
+		checkError(err)
+		xp, socket, err := StartReplicaConfig(path, id, signing.KeySigner(key), nil, 0)
+		checkError(err)
+		defer xp.Kill()
+		defer socket.Close()
+	}
+
+	client, err := ConnectClientConfig(path)
+	checkError(err)
+	for i := 0; i < 5; i++ {
+		if _, ok := client.Execute(i); ok == false {
+			t.Fatal("Cluster of a configuration file failed to commit a request!")
+		}
+	}
+
+	// Without a CA, servers take the public keys of the configuration, and a server refuses to
+	// start with a key other than its own
+	config, err := ReadClusterConfig(path)
+	checkError(err)
+	config.CA = ""
+	for i := range config.Servers {
+		config.Servers[i].Certificate = ""
+	}
+	checkError(WriteClusterConfig(dir+"/keys.json", config))
+	key, err := signing.ReadPrivateKeyFile(dir + "/2.key.pem")
+	checkError(err)
+	if _, _, err := StartReplicaConfig(dir+"/keys.json", 1, signing.KeySigner(key), nil, 0); err == nil {
+		t.Fatal("Server started with the key of another server!")
+	}
+
+	config.Servers[1].Id = 1
+	checkError(WriteClusterConfig(dir+"/duplicate.json", config))
+	if _, err := ReadClusterConfig(dir + "/duplicate.json"); err == nil {
+		t.Fatal("Configuration listing a server twice read!")
+	}
+}
+
+func TestDKG2(t *testing.T) {
+	fmt.Println("Test: Distributed Key Generation - Ceremony Over Unix Sockets (t=1)")
+
+	dir, err := os.MkdirTemp("", "xpaxos") // Short, since socket paths are limited in length
+	checkError(err)
+	defer os.RemoveAll(dir)
+
+	replicas := 3
+	checkError(InitCluster(dir, replicas, params.scheme))
+	servers := make([]*XPaxos, replicas+1)
+	for id := 1; id <= replicas; id++ {
+		xp, socket, err := StartReplica(dir, id, nil, 0)
+		checkError(err)
+		defer xp.Kill()
+		defer socket.Close()
+		servers[id] = xp
+	}
+
+	var wg sync.WaitGroup
+	shares := make([]*signing.ThresholdShare, replicas+1)
+	for id := 1; id <= replicas; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			time.Sleep(time.Duration(id) * 100 * time.Millisecond) // Servers join one after the other
+			var err error
+			if shares[id], err = servers[id].GenerateThresholdKey(7, DKGTESTBITS); err != nil {
+				t.Errorf("XPaxos server (%d) failed its ceremony: %v!", id, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	msgDigest := digest("dkg")
+	signatures := make([]signing.SignatureShare, 0, replicas)
+	for id := replicas; id >= 1; id-- {
+		signature, err := shares[id].Sign(msgDigest)
+		checkError(err)
+		signatures = append(signatures, signature)
+	}
+	signature, err := shares[1].Key.Combine(msgDigest, signatures[:2])
+	checkError(err)
+	if err := shares[2].Key.Verify(msgDigest, signature); err != nil {
+		t.Fatalf("Signature of the generated threshold key invalid: %v!", err)
+	}
+}
+
+// The prepare of a request is held on its way to the follower (see network/hold.go), so that the
+// request is pending on the leader, its entry unexecuted and the prepare undelivered
+func TestBacklog1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Metrics - Pending Requests, Unexecuted Entries and Undelivered RPCs (t=1)")
+
+	cfg.propose(nil)
+	cfg.waitForView(1, time.Second)
+
+	// The request waits for the lock of the leader (ID = 1) of view 1
+	leader := cfg.xpServers[1]
+	leader.mu.Lock()
+	done := make(chan bool)
+	go func() {
+		done <- cfg.proposeAndRecord(nil)
+	}()
+	for atomic.LoadInt64(&leader.pending) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Hold the prepare of the follower of view 1 (ID = 2)
+	cfg.net.Hold(func(svcMeth string, from int, to interface{}) bool {
+		return svcMeth == "XPaxos.Prepare" && from == 1 && to == 2
+	})
+	leader.mu.Unlock()
+	cfg.net.WaitForHeld(1)
+
+	if _, unexecuted := leader.Backlog(); unexecuted != 1 {
+		cfg.t.Fatalf("Leader has %d unexecuted entries instead of 1!", unexecuted)
+	}
+	if backlog := cfg.net.GetUndelivered(2); backlog != 1 {
+		cfg.t.Fatalf("Follower has %d undelivered RPCs instead of 1!", backlog)
+	}
+
+	cfg.net.Hold(nil)
+	cfg.net.ReleaseAll()
+	if <-done == false {
+		cfg.t.Fatal("Request not committed!")
+	}
+
+	start := time.Now()
+	for {
+		pending, unexecuted := leader.Backlog()
+		if pending == 0 && unexecuted == 0 && cfg.net.GetUndelivered(2) == 0 {
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			cfg.t.Fatalf("Backlog left behind: %d pending requests, %d unexecuted entries!", pending, unexecuted)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInspect1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Inspection - Internal State of a Server as JSON (t=1)")
+
+	iters := INSPECTTAIL + 2
+	for i := 0; i < iters; i++ {
+		cfg.propose(i)
+	}
+
+	server := httptest.NewServer(InspectHandler(cfg.xpServers[1]))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	checkError(err)
+	state := ServerState{}
+	err = json.NewDecoder(resp.Body).Decode(&state)
+	resp.Body.Close()
+	checkError(err)
+
+	if state.Id != 1 || state.View != 1 || state.Leader != 1 || reflect.DeepEqual(state.SynchronousGroup,
+		[]int{1, 2}) == false || state.ExecuteSeqNum != iters || state.PrepareSeqNum != iters {
+		cfg.t.Fatalf("Invalid state of the leader %+v!", state)
+	}
+	if state.CommitLog.Length != iters || len(state.CommitLog.Tail) != INSPECTTAIL ||
+		len(state.Pending) != 0 || len(state.Suspected) != 0 || state.ViewChange.InProgress == true {
+		cfg.t.Fatalf("Invalid log summary of the leader %+v!", state)
+	}
+
+	last := state.CommitLog.Tail[INSPECTTAIL-1]
+	cfg.xpServers[1].mu.Lock()
+	request := cfg.xpServers[1].commitLog[iters-1].Request
+	cfg.xpServers[1].mu.Unlock()
+	if last.SeqNum != iters || last.TraceId != request.TraceId || last.Timestamp != request.Timestamp ||
+		reflect.DeepEqual(last.Commits, []int{2}) == false {
+		cfg.t.Fatalf("Invalid summary %+v of the last entry!", last)
+	}
 }
 
 //
@@ -495,14 +4423,23 @@ func benchmarkNoFaults(n int, size int, b *testing.B) {
 	cfg := makeConfig(nil, servers, false)
 	defer cfg.cleanup()
 
-	op := make([]byte, size)
-	rand.Read(op) // Operation is random byte array of size bytes
+	gen := workload.MakeGenerator(workload.Config{Size: size}, cfg.rand.Int63()) // Writes of size bytes
 
+	cfg.resetStats() // Only measure the proposals
 	b.ResetTimer()
-	fmt.Printf("Iterations %d\n",b.N)
+	fmt.Printf("Iterations %d\n", b.N)
+	mem := startMemStats()
+	prof := startProfiles(b)
+	committed := 0
 	for i := 0; i < b.N; i++ {
-		cfg.client.Propose(op)
+		if cfg.client.Propose(gen.Next()) {
+			committed++
+		}
 	}
+	stopProfiles(prof, b)
+
+	b.ReportMetric(float64(cfg.totalBytes())/float64(b.N), "bytes/op")
+	reportMemStats(mem, committed, b)
 }
 
 func benchmarkNoFaultsWithDelay(n int, size int, b *testing.B) {
@@ -510,14 +4447,23 @@ func benchmarkNoFaultsWithDelay(n int, size int, b *testing.B) {
 	cfg := makeConfig2(nil, servers, false, 50, 100)
 	defer cfg.cleanup()
 
-	op := make([]byte, size)
-	rand.Read(op) // Operation is random byte array of size bytes
+	gen := workload.MakeGenerator(workload.Config{Size: size}, cfg.rand.Int63()) // Writes of size bytes
 
+	cfg.resetStats() // Only measure the proposals
 	b.ResetTimer()
-	fmt.Printf("Iterations %d\n",b.N)
+	fmt.Printf("Iterations %d\n", b.N)
+	mem := startMemStats()
+	prof := startProfiles(b)
+	committed := 0
 	for i := 0; i < b.N; i++ {
-		cfg.client.Propose(op)
+		if cfg.client.Propose(gen.Next()) {
+			committed++
+		}
 	}
+	stopProfiles(prof, b)
+
+	b.ReportMetric(float64(cfg.totalBytes())/float64(b.N), "bytes/op")
+	reportMemStats(mem, committed, b)
 }
 
 func benchmarkRandomCrashFaults1(n int, size int, b *testing.B) {
@@ -525,17 +4471,16 @@ func benchmarkRandomCrashFaults1(n int, size int, b *testing.B) {
 	cfg := makeConfig(nil, servers, false)
 	defer cfg.cleanup()
 
-	crash := rand.Intn(servers-1) + 1
+	crash := cfg.rand.Intn(cfg.n-1) + 1
 	cfg.net.SetFaultRate(crash, 100)
 
-	op := make([]byte, size)
-	rand.Read(op) // Operation is random byte array of size bytes
+	gen := workload.MakeGenerator(workload.Config{Size: size}, cfg.rand.Int63()) // Writes of size bytes
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		cfg.client.Propose(op)
+		cfg.client.Propose(gen.Next())
 		cfg.net.SetFaultRate(crash, 0)
-		crash = rand.Intn(servers-1) + 1
+		crash = cfg.rand.Intn(cfg.n-1) + 1
 		cfg.net.SetFaultRate(crash, 100)
 	}
 }
@@ -545,21 +4490,20 @@ func benchmarkRandomCrashFaults2(n int, size int, b *testing.B) {
 	cfg := makeConfig(nil, servers, false)
 	defer cfg.cleanup()
 
-	crash1 := rand.Intn(servers-1) + 1
-	crash2 := rand.Intn(servers-1) + 1
+	crash1 := cfg.rand.Intn(cfg.n-1) + 1
+	crash2 := cfg.rand.Intn(cfg.n-1) + 1
 	cfg.net.SetFaultRate(crash1, 100)
 	cfg.net.SetFaultRate(crash2, 100)
 
-	op := make([]byte, size)
-	rand.Read(op) // Operation is random byte array of size bytes
+	gen := workload.MakeGenerator(workload.Config{Size: size}, cfg.rand.Int63()) // Writes of size bytes
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		cfg.client.Propose(op)
+		cfg.client.Propose(gen.Next())
 		cfg.net.SetFaultRate(crash1, 0)
 		cfg.net.SetFaultRate(crash2, 0)
-		crash1 = rand.Intn(servers-1) + 1
-		crash2 = rand.Intn(servers-1) + 1
+		crash1 = cfg.rand.Intn(cfg.n-1) + 1
+		crash2 = cfg.rand.Intn(cfg.n-1) + 1
 		cfg.net.SetFaultRate(crash1, 100)
 		cfg.net.SetFaultRate(crash2, 100)
 	}
@@ -570,19 +4514,108 @@ func benchmarkByzantineFault(n int, size int, b *testing.B) {
 	cfg := makeConfig(nil, servers, false)
 	defer cfg.cleanup()
 
-	fault := rand.Intn(servers-1) + 1
-	cfg.xpServers[fault].byzantine = true
+	fault := cfg.rand.Intn(cfg.n-1) + 1
+	cfg.setByzantine(fault, CORRUPTSIGNATURE)
 
-	op := make([]byte, size)
-	rand.Read(op) // Operation is random byte array of size bytes
+	gen := workload.MakeGenerator(workload.Config{Size: size}, cfg.rand.Int63()) // Writes of size bytes
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg.client.Propose(gen.Next())
+		cfg.setByzantine(fault, HONEST)
+		fault = cfg.rand.Intn(cfg.n-1) + 1
+		cfg.setByzantine(fault, CORRUPTSIGNATURE)
+	}
+}
+
+// Same as benchmarkNoFaults() but with the XPaxos servers spread over a WAN topology
+func benchmarkGeoReplication(topo *network.Topology, n int, size int, b *testing.B) {
+	servers := n // The number of XPaxos servers is n-1 (client included!)
+	cfg := makeConfig(nil, servers, false)
+	defer cfg.cleanup()
+
+	cfg.setTopology(topo)
+
+	gen := workload.MakeGenerator(workload.Config{Size: size}, cfg.rand.Int63()) // Writes of size bytes
 
+	cfg.resetStats() // Only measure the proposals
 	b.ResetTimer()
+	mem := startMemStats()
+	prof := startProfiles(b)
+	committed := 0
+	for i := 0; i < b.N; i++ {
+		if cfg.client.Propose(gen.Next()) {
+			committed++
+		}
+	}
+	stopProfiles(prof, b)
+
+	b.ReportMetric(float64(cfg.totalBytes())/float64(b.N), "bytes/op")
+	reportMemStats(mem, committed, b)
+}
+
+// Encodes and decodes msg once per iteration and reports the size of the encoded message
+func benchmarkCodec(codec network.Codec, msg interface{}, b *testing.B) {
+	var data []byte
+
 	for i := 0; i < b.N; i++ {
-		cfg.client.Propose(op)
-		cfg.xpServers[fault].byzantine = false
-		fault = rand.Intn(servers-1) + 1
-		cfg.xpServers[fault].byzantine = true
+		data, _ = codec.Encode(msg)
+		if err := codec.Decode(data, reflect.New(reflect.TypeOf(msg)).Interface()); err != nil {
+			b.Fatal(err)
+		}
 	}
+
+	b.ReportMetric(float64(len(data)), "bytes/msg")
+}
+
+func samplePrepareLogEntry(size int) PrepareLogEntry {
+	op := make([]byte, size)
+	rand.Read(op) // Operation is random byte array of size bytes
+
+	request := ClientRequest{MsgType: REPLICATE, Timestamp: 1, Operation: op, ClientId: CLIENT, Nonce: newNonce()}
+	signature := make([]byte, 128) // Size of an RSA-1024 signature
+	rand.Read(signature)
+
+	msg := Message{
+		MsgType:         PREPARE,
+		MsgDigest:       digest(request),
+		Signature:       signature,
+		PrepareSeqNum:   1,
+		View:            1,
+		ClientTimestamp: 1,
+		SenderId:        1}
+
+	return PrepareLogEntry{Request: request, Msg0: msg}
+}
+
+// Benchmark_Codec - Serialization cost of protocol messages (n.b. compare the bytes/msg metric)
+func Benchmark_Codec_Gob_Message(b *testing.B) {
+	benchmarkCodec(network.GobCodec{}, samplePrepareLogEntry(0).Msg0, b)
+}
+
+func Benchmark_Codec_JSON_Message(b *testing.B) {
+	benchmarkCodec(network.JSONCodec{}, samplePrepareLogEntry(0).Msg0, b)
+}
+
+func Benchmark_Codec_Gob_PrepareLogEntry_1kB(b *testing.B) {
+	benchmarkCodec(network.GobCodec{}, samplePrepareLogEntry(1024), b)
+}
+
+func Benchmark_Codec_JSON_PrepareLogEntry_1kB(b *testing.B) {
+	benchmarkCodec(network.JSONCodec{}, samplePrepareLogEntry(1024), b)
+}
+
+func Benchmark_Codec_Gob_PrepareLogEntry_1MB(b *testing.B) {
+	benchmarkCodec(network.GobCodec{}, samplePrepareLogEntry(1048576), b)
+}
+
+func Benchmark_Codec_JSON_PrepareLogEntry_1MB(b *testing.B) {
+	benchmarkCodec(network.JSONCodec{}, samplePrepareLogEntry(1048576), b)
+}
+
+// Benchmark_Geo - Servers spread round-robin over 3 datacenters, No Faults
+func Benchmark_Geo_3DC_1kB(b *testing.B) {
+	benchmarkGeoReplication(network.ThreeDatacenters(3), 4, 1024, b)
 }
 
 // Benchmark_3_0 - Number of XPaxos servers = 3 (t=1), No Faults
@@ -657,4 +4690,4 @@ func Benchmark_11_0_8MB(b *testing.B)   { benchmarkNoFaults(12, 8388608, b) }
 // Benchmark_11_B - Number of XPaxos servers = 11 (t=5), One Byzantine Fault
 //func Benchmark_11_B_256kB(b *testing.B) { benchmarkByzantineFault(12, 262144, b) }
 
-func Benchmark_3_0_1kB_delay(b *testing.B)   { benchmarkNoFaultsWithDelay(4, 1024, b) }
+func Benchmark_3_0_1kB_delay(b *testing.B) { benchmarkNoFaultsWithDelay(4, 1024, b) }
@@ -0,0 +1,256 @@
+package xpaxos
+
+// Threshold certificates of view changes and of commit log entries
+//
+// xp.SetThresholdShare(share)   - Certifies the view changes and commits of the server with its share (nil = none)
+// xp.ViewCertificate()          - Certificate of the current view of the server (nil if none)
+// pooledShares(n)               - Shares of a threshold key of the XPaxos servers of n (tests)
+// cfg.setThresholdKeys(enabled) - Deals the shares of a threshold key to every server (restarts included)
+//
+// With a threshold key of which any t+1 of the 2t+1 servers sign (see signing/threshold.go), a new
+// view carries a single certificate that the t+1 servers of its synchronous group authorized it,
+// instead of their t+1 signatures:
+// => Every member of the new synchronous group signs a share of the view's digest into its VC-final
+//    message; members check the shares they receive like signatures, and suspect the view on an
+//    invalid or missing one
+// => The new leader combines the shares of the VC-final messages it received into a threshold
+//    signature, which its new-view message carries; followers install the new view only if it
+//    verifies against the threshold key, and keep it as the certificate of their view
+// => The certificate has the size of the key's modulus whatever the size of the group, and any
+//    t+1 servers make the same certificate of a view
+// => The commits of an entry are certified the same way: the leader signs a share of the entry's
+//    commit digest into its prepare and every follower one into its commit, so that an entry the
+//    whole group committed holds the t+1 shares of its certificate; every server combines them
+//    once it executed the entry and keeps the certificate in the entry instead of the t commit
+//    messages, so that the commit log holds one signature per entry whatever the size of the group
+// => Log entries move with their certificate: view change messages and state transfers (see
+//    statetransfer.go) carry it, and servers verify it against the threshold key before they keep
+//    it, i.e. a member of a new group drops a certificate of the merged log that does not verify
+// => Prepares and commits carry their share encoded, so that they keep their size without a
+//    threshold key
+// => Any t+1 shares of the key hold at least one of a correct server, which signs a share of a
+//    commit digest only as a member of the group of its view, so a certificate stands for the
+//    commits of the group the way the commit messages of its members do
+// => All servers must share the same threshold key: a server without one neither signs shares nor
+//    checks certificates; -threshold turns it on in every test
+// => A trusted dealer deals the shares (the config in tests), or the servers generate them in a
+//    ceremony without one (see dkg.go); servers hold them in memory only
+
+import (
+	"errors"
+	"github.com/csanti/cos518_project/src/signing"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const THRESHOLDBITS = 1024 // Modulus size of the threshold keys of tests
+
+var errNoThresholdKey = errors.New("no threshold key")
+
+func (xp *XPaxos) SetThresholdShare(share *signing.ThresholdShare) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	xp.thresholdShare = share
+}
+
+func (xp *XPaxos) ViewCertificate() []byte {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	return xp.viewCertificate
+}
+
+// Share of the certificate of msgDigest (nil without a threshold key); must be called with xp.mu held
+func (xp *XPaxos) signShare(msgDigest [32]byte) *signing.SignatureShare {
+	return xp.signShareWith(xp.thresholdShare, msgDigest)
+}
+
+// Share of the certificate of msgDigest signed with thresholdShare (nil = none), which the caller
+// read under xp.mu (i.e. a presigner, see presign.go)
+func (xp *XPaxos) signShareWith(thresholdShare *signing.ThresholdShare, msgDigest [32]byte) *signing.SignatureShare {
+	if thresholdShare == nil {
+		return nil
+	}
+	if xp.keysErased() { // Killed (see eraseKeys())
+		xp.log().Infof("Sign: share: %v", errErased)
+		return nil
+	}
+
+	atomic.AddInt64(&xp.signatures, 1)
+	start := time.Now()
+	share, err := thresholdShare.Sign(msgDigest)
+	atomic.AddInt64(&xp.signTime, int64(time.Since(start)))
+	if err != nil { // Receivers reject the missing share like an invalid one
+		xp.log().Infof("Sign: %v", err)
+		return nil
+	}
+	return &share
+}
+
+// Encoded share of the commit certificate of the entry msg prepares or commits (nil without a
+// threshold key), in bytes so that messages without one carry no type of the signing package;
+// must be called with xp.mu held
+func (xp *XPaxos) signCommitShare(msg Message) []byte {
+	return xp.signCommitShareWith(xp.thresholdShare, msg)
+}
+
+// Like signCommitShare(), with a thresholdShare the caller read under xp.mu
+func (xp *XPaxos) signCommitShareWith(thresholdShare *signing.ThresholdShare, msg Message) []byte {
+	share := xp.signShareWith(thresholdShare, msg.commitDigest())
+	if share == nil {
+		return nil
+	}
+	data, err := encode(*share)
+	if err != nil {
+		xp.log().Infof("Sign: %v", err)
+		return nil
+	}
+	return data
+}
+
+// Keeps the share of a VC-final message of server if it is valid (always true without a threshold
+// key); must be called with xp.mu held
+func (xp *XPaxos) acceptShare(server int, msgDigest [32]byte, share *signing.SignatureShare) bool {
+	if xp.thresholdShare == nil {
+		return true
+	}
+	if share == nil || share.Signer != server {
+		xp.log().Debugf("Verify: no signature share of server %d", server)
+		return false
+	}
+
+	atomic.AddInt64(&xp.verifications, 1)
+	start := time.Now()
+	err := xp.thresholdShare.Key.VerifyShare(msgDigest, *share)
+	atomic.AddInt64(&xp.verifyTime, int64(time.Since(start)))
+	if err != nil {
+		xp.log().Debugf("Verify: %v", err)
+		return false
+	}
+	xp.vcShares[server] = *share
+	return true
+}
+
+// Certificate of msgDigest from the shares of the VC-final messages received (nil without a
+// threshold key); must be called with xp.mu held
+func (xp *XPaxos) certifyView(msgDigest [32]byte) ([]byte, error) {
+	if xp.thresholdShare == nil {
+		return nil, nil
+	}
+
+	shares := make([]signing.SignatureShare, 0, len(xp.receivedVCFinal))
+	for server := range xp.receivedVCFinal {
+		if share, ok := xp.vcShares[server]; ok {
+			shares = append(shares, share)
+		}
+	}
+	atomic.AddInt64(&xp.signatures, 1)
+	start := time.Now()
+	certificate, err := xp.thresholdShare.Key.Combine(msgDigest, shares)
+	atomic.AddInt64(&xp.signTime, int64(time.Since(start)))
+	return certificate, err
+}
+
+// Certificate of the commits of entry seqNum from the shares of its prepare and commit messages,
+// combined once and kept in the entry (nil without a threshold key or without t+1 valid shares);
+// must be called with xp.mu held
+func (xp *XPaxos) certifyEntry(seqNum int) []byte {
+	entry := &xp.commitLog[seqNum-xp.truncated]
+	if xp.thresholdShare == nil || entry.Certificate != nil {
+		return entry.Certificate
+	}
+
+	msgDigest := entry.Msg0.commitDigest()
+	messages := make([]Message, 0, len(entry.Msg1)+1)
+	messages = append(messages, entry.Msg0)
+	for _, msg := range entry.Msg1 {
+		messages = append(messages, msg)
+	}
+	shares := make([]signing.SignatureShare, 0, len(messages))
+	for _, msg := range messages {
+		var share signing.SignatureShare
+		if msg.Share == nil || msg.commitDigest() != msgDigest || decode(msg.Share, &share) != nil {
+			continue // Combine() rejects invalid shares
+		}
+		shares = append(shares, share)
+	}
+	if len(shares) < xp.thresholdShare.Key.Threshold {
+		return nil
+	}
+
+	atomic.AddInt64(&xp.signatures, 1)
+	start := time.Now()
+	certificate, err := xp.thresholdShare.Key.Combine(msgDigest, shares)
+	atomic.AddInt64(&xp.signTime, int64(time.Since(start)))
+	if err != nil { // An invalid share: the entry keeps its commit messages
+		xp.log().Debugf("Sign: certificate of entry %d: %v", seqNum+1, err)
+		return nil
+	}
+	entry.Certificate = certificate
+	return certificate
+}
+
+// Replaces the commit messages of entry seqNum by their certificate once the server executed it
+// (without a threshold key, or with an invalid share, the entry keeps them); must be called with
+// xp.mu held
+func (xp *XPaxos) compactEntry(seqNum int) {
+	if xp.certifyEntry(seqNum) != nil {
+		xp.commitLog[seqNum-xp.truncated].Msg1 = make(map[int]Message, 0)
+	}
+}
+
+// Error unless the certificate of entry verifies against the threshold key; must be called with
+// xp.mu held
+func (xp *XPaxos) checkEntryCertificate(entry CommitLogEntry) error {
+	if xp.thresholdShare == nil {
+		return errNoThresholdKey
+	}
+
+	atomic.AddInt64(&xp.verifications, 1)
+	start := time.Now()
+	err := xp.thresholdShare.Key.Verify(entry.Msg0.commitDigest(), entry.Certificate)
+	atomic.AddInt64(&xp.verifyTime, int64(time.Since(start)))
+	return err
+}
+
+// Always true without a threshold key; must be called with xp.mu held
+func (xp *XPaxos) checkCertificate(msgDigest [32]byte, certificate []byte) bool {
+	if xp.thresholdShare == nil {
+		return true
+	}
+
+	atomic.AddInt64(&xp.verifications, 1)
+	start := time.Now()
+	err := xp.thresholdShare.Key.Verify(msgDigest, certificate)
+	atomic.AddInt64(&xp.verifyTime, int64(time.Since(start)))
+	if err != nil {
+		xp.log().Debugf("Verify: certificate of the view: %v", err)
+		return false
+	}
+	return true
+}
+
+// Shares of threshold keys shared by the configs of all tests, since dealing one means finding two
+// safe primes: XPaxos servers 1..n-1 of every test with n servers get the shares of the same key,
+// of which any t+1 (the size of a synchronous group) sign
+var thresholdPool struct {
+	mu     sync.Mutex
+	shares map[int][]*signing.ThresholdShare
+}
+
+func pooledShares(n int) []*signing.ThresholdShare {
+	thresholdPool.mu.Lock()
+	defer thresholdPool.mu.Unlock()
+
+	if thresholdPool.shares == nil {
+		thresholdPool.shares = make(map[int][]*signing.ThresholdShare)
+	}
+	if thresholdPool.shares[n] == nil {
+		_, shares, err := signing.DealThreshold((n-1)/2+1, n-1, THRESHOLDBITS)
+		checkError(err)
+		thresholdPool.shares[n] = shares
+	}
+	return thresholdPool.shares[n]
+}
@@ -0,0 +1,91 @@
+package xpaxos
+
+// Golden message traces for regression tests of the test harness
+//
+// tr := cfg.startTrace()         - Starts tracing the messages of the network
+// tr.step(name, fn)              - Runs a step of the scenario (i.e. a proposal) and traces it
+// tr.stop()                      - Stops tracing
+// cfg.compareTrace(tr, path)     - Diffs the trace against a golden file (see testdata/)
+//
+// A trace is the canonical form of the messages exchanged in a scenario: every request is held
+// by the network (see network/hold.go) and the tracer delivers them one at a time, always the
+// smallest held request by (method, sender, receiver), once the network is quiet. The trace lists
+// the delivered requests of every step (i.e. a proposal) as "<method> <from> -> <to>" in delivery
+// order, so the concurrency of the servers does not change it, only which messages the protocol
+// sends does
+// => All timers are driven by a virtual clock that only advances (by TRACETICK) once the network
+//    is quiet and nothing is held, so timeouts never race with messages in flight
+// => A step ends once its function returned, the network is quiet and nothing is held, so
+//    messages still in flight after the client got its reply belong to the step that caused them
+// => Only requests that reach the network are traced; a server that fails to send its RPCs (see
+//    net.SetFaultRate()) sends nothing
+// => "go test -run=Trace -args -update" rewrites the golden files with the traces of the current
+//    protocol; review the diff before committing them
+
+import (
+	"fmt"
+	"github.com/csanti/cos518_project/src/network"
+	"sort"
+	"strings"
+	"time"
+)
+
+const TRACEQUIET = 20 * time.Millisecond // No events for this long (real time) means the network is quiet
+const TRACETICK = time.Millisecond       // Virtual time the clock advances by when nothing else can happen
+
+type trace struct {
+	cfg    *config
+	clock  *network.VirtualClock
+	events <-chan network.Event
+	steps  []string // Canonical form of every closed step
+}
+
+func (tr *trace) step(name string, fn func()) {
+	lines := make([]string, 0)
+
+	done := make(chan bool, 1)
+	go func() {
+		fn()
+		done <- true
+	}()
+
+	for finished := false; ; {
+		select {
+		case <-tr.events:
+			continue
+		case <-done:
+			finished = true
+			continue
+		case <-time.After(TRACEQUIET):
+		}
+
+		held := tr.cfg.net.Held()
+		if len(held) > 0 {
+			sort.SliceStable(held, func(i, j int) bool { // Held in arrival order (ties stay in it)
+				return traceLine(held[i]) < traceLine(held[j])
+			})
+			lines = append(lines, traceLine(held[0]))
+			go tr.cfg.net.Release(held[0].Id) // The handler may itself send held requests
+		} else if finished {
+			break
+		} else {
+			tr.clock.Advance(TRACETICK) // Only time can make progress
+		}
+	}
+
+	tr.steps = append(tr.steps, fmt.Sprintf("# %s\n%s", name, strings.Join(lines, "\n")))
+}
+
+func traceLine(m *network.HeldMessage) string {
+	return fmt.Sprintf("%s %d -> %v", m.SvcMeth, m.From, m.To)
+}
+
+func (tr *trace) stop() {
+	tr.cfg.net.Hold(nil)
+	tr.cfg.net.ReleaseAll()
+	tr.cfg.net.Unsubscribe(tr.events)
+}
+
+func (tr *trace) String() string {
+	return strings.Join(tr.steps, "\n\n") + "\n"
+}
@@ -3,13 +3,22 @@ package xpaxos
 import (
 	"bytes"
 	"crypto"
-	crand "crypto/rand"
-	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/csanti/cos518_project/src/debug"
+	"github.com/csanti/cos518_project/src/journal"
+	"github.com/csanti/cos518_project/src/linearizability"
+	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"github.com/csanti/cos518_project/src/tracing"
 	"log"
 	"math/rand"
-	"github.com/csanti/cos518_project/src/network"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,19 +26,77 @@ import (
 // ------------------------------ DEBUG FUNCTIONS -----------------------------
 //
 func dPrintf(format string, a ...interface{}) (n int, err error) {
-	if DEBUG > 1 {
-		log.Printf(format, a...)
-	}
-	return
+	return debug.Printf(debug.XPAXOS, debug.DEBUG, format, a...)
 }
 
 func iPrintf(format string, a ...interface{}) (n int, err error) {
-	if DEBUG > 0 {
-		log.Printf(format, a...)
+	return debug.Printf(debug.XPAXOS, debug.INFO, format, a...)
+}
+
+// Records of a server carry its ID as the "server" field (see debug/logger.go)
+func (xp *XPaxos) log() *debug.Logger {
+	return xp.logger.Load().(*debug.Logger)
+}
+
+// Replaces the logger of the server (i.e. to collect its records in a test)
+func (xp *XPaxos) SetLogger(logger *debug.Logger) {
+	xp.logger.Store(logger.With("server", xp.id))
+}
+
+// Spans of the protocol phases of requests go to tracer (see tracing)
+func (xp *XPaxos) SetTracer(tracer *tracing.Tracer) {
+	xp.tracer.Store(tracer)
+}
+
+func (xp *XPaxos) getTracer() *tracing.Tracer {
+	return xp.tracer.Load().(*tracing.Tracer)
+}
+
+// Significant transitions of the server (see journal)
+func (xp *XPaxos) Journal() *journal.Journal {
+	return xp.journal
+}
+
+// Must be called with xp.mu held
+func (xp *XPaxos) record(kind int, seqNum int) {
+	xp.journal.Record(xp.clock.Now(), kind, xp.view, seqNum)
+}
+
+// Clients log under their own module with their ID as the "client" field
+var clientLogger = debug.MakeLogger(debug.CLIENT)
+
+func (client *Client) log() *debug.Logger {
+	logger, ok := client.logger.Load().(*debug.Logger)
+	if ok == false {
+		logger = clientLogger
 	}
-	return
+	return logger.With("client", client.id)
+}
+
+// Records about a message carry its view, sequence number and trace ID
+func (xp *XPaxos) logMsg(msg Message) *debug.Logger {
+	return xp.log().With("view", msg.View, "seqNum", msg.PrepareSeqNum, "trace", msg.TraceId)
+}
+
+// Messages about a client request carry its trace ID to the network events (see network/events.go)
+func (request ClientRequest) Trace() string {
+	return request.TraceId
+}
+
+func (prepareEntry PrepareLogEntry) Trace() string {
+	return prepareEntry.Request.TraceId
+}
+
+func (msg Message) Trace() string {
+	return msg.TraceId
 }
 
+// Recoverable failures (bad messages, unreadable stable storage) are returned as errors wrapping
+// these and handled as protocol faults; checkError() is only for the test harness and key setup
+var errUnknownServer = errors.New("unknown server")
+var errUnreadable = errors.New("unreadable persisted state")
+var errErased = errors.New("keys erased by Kill()")
+
 func checkError(err error) {
 	if err != nil {
 		log.Fatal(err)
@@ -45,52 +112,250 @@ func digest(msg interface{}) [32]byte { // Crypto message digest
 	return sha256.Sum256(jsonBytes)
 }
 
-func generateKeys() (*rsa.PrivateKey, *rsa.PublicKey) { // Crypto RSA private/public key generation
-	key, err := rsa.GenerateKey(crand.Reader, BITSIZE)
+// Digest that the signature of a prepare or commit message covers: the digest of its request, its
+// type and the view, sequence number and time it assigns to the request, so that a signed message
+// moves to neither another view nor another sequence number, nor applies its request at another
+// time
+func (msg Message) signedDigest() [32]byte {
+	return digest([5]interface{}{msg.MsgType, msg.MsgDigest, msg.View, msg.PrepareSeqNum, msg.Time})
+}
+
+// Digest of the commits of the request a prepare or commit message assigns, the same for every
+// member of the group, whose shares make the certificate of its entry (see certifyEntry())
+func (msg Message) commitDigest() [32]byte {
+	return digest([5]interface{}{COMMIT, msg.MsgDigest, msg.View, msg.PrepareSeqNum, msg.Time})
+}
+
+func generateKeys(scheme signing.Scheme) (crypto.Signer, crypto.PublicKey) { // Crypto private/public key generation
+	key, err := scheme.GenerateKey()
 	checkError(err)
-	return key, &key.PublicKey
+	return key, key.Public()
 }
 
-func (xp *XPaxos) sign(msgDigest [32]byte) []byte { // Crypto message signature
-	signature, err := rsa.SignPKCS1v15(crand.Reader, xp.privateKey, crypto.SHA256, msgDigest[:])
+// Key pairs shared by the configs of all tests, since RSA key generation dominates their setup:
+// XPaxos server i of every test gets key pair i of its scheme, generated the first time a test
+// needs it
+// => Tests that need keys no other test used get fresh ones with makeConfigFreshKeys() (or all
+//    tests with -freshkeys)
+var keyPool struct {
+	mu   sync.Mutex
+	keys map[signing.Scheme][]crypto.Signer
+}
+
+func pooledKeys(scheme signing.Scheme, i int) (crypto.Signer, crypto.PublicKey) {
+	keyPool.mu.Lock()
+	defer keyPool.mu.Unlock()
+
+	if keyPool.keys == nil {
+		keyPool.keys = make(map[signing.Scheme][]crypto.Signer)
+	}
+	for len(keyPool.keys[scheme]) <= i {
+		keyPool.keys[scheme] = append(keyPool.keys[scheme], nil)
+	}
+	if keyPool.keys[scheme][i] == nil {
+		keyPool.keys[scheme][i], _ = generateKeys(scheme)
+	}
+	return keyPool.keys[scheme][i], keyPool.keys[scheme][i].Public()
+}
+
+func (cfg *config) keys(i int) (crypto.Signer, crypto.PublicKey) {
+	if cfg.freshKeys {
+		return generateKeys(cfg.scheme)
+	}
+	return pooledKeys(cfg.scheme, i)
+}
+
+// Signer of server i: a copy of its private key, which Kill() erases, unless the test set another
+// signer (i.e. in another process)
+func (cfg *config) signer(i int) signing.Signer {
+	if signer := cfg.signers[i]; signer != nil {
+		return signer
+	}
+	signer, err := signing.ErasableSigner(cfg.privateKeys[i])
 	checkError(err)
+	return signer
+}
+
+func (xp *XPaxos) sign(msgDigest [32]byte) []byte { // Crypto message signature
+	signature := xp.signUnaudited(msgDigest)
+	if signature != nil {
+		xp.audit(AuditEntry{MsgType: SIGNED, MsgDigest: msgDigest, Signature: signature})
+	}
 	return signature
 }
 
-func (xp *XPaxos) verify(server int, msgDigest [32]byte, signature []byte) bool { // Crypto signature verification
-	err := rsa.VerifyPKCS1v15(xp.publicKeys[server], crypto.SHA256, msgDigest[:], signature)
+// Signature of a prepare or commit message, archived with the fields it signs (see audit.go)
+func (xp *XPaxos) signMessage(msg Message) []byte {
+	signature := xp.signUnaudited(msg.signedDigest())
+	if signature != nil {
+		xp.audit(messageEntry(msg, signature))
+	}
+	return signature
+}
+
+// Signature that the audit trail does not archive (i.e. of a message that may never be sent, see
+// presign.go)
+func (xp *XPaxos) signUnaudited(msgDigest [32]byte) []byte {
+	signature, err := xp.signDigest(msgDigest)
+	if err != nil { // Receivers reject the unsigned message like any other invalid signature
+		xp.log().Infof("Sign: %v", err)
+		return nil
+	}
+	return signature
+}
+
+func (xp *XPaxos) signDigest(msgDigest [32]byte) ([]byte, error) {
+	atomic.AddInt64(&xp.signatures, 1)
+	xp.keyMu.Lock()
+	signer := xp.signer
+	xp.keyMu.Unlock()
+
+	start := time.Now()
+	signature, err := signer.Sign(msgDigest)
+	atomic.AddInt64(&xp.signTime, int64(time.Since(start)))
 	if err != nil {
+		return nil, fmt.Errorf("signature of server %d: %w", xp.id, err)
+	}
+	return signature, nil
+}
+
+func (xp *XPaxos) verify(server int, msgDigest [32]byte, signature []byte) bool { // Crypto signature verification
+	if err := xp.checkSignature(server, msgDigest, signature); err != nil {
+		xp.log().Debugf("Verify: %v", err)
 		return false
 	}
 	return true
 }
 
+// Messages may claim any sender, so one without a public key is an invalid signature (errUnknownServer)
+// => During the grace window of a key change, the old key of the server verifies too (see rotation.go)
+// => Signatures that verified before are found in the verification cache (see verifycache.go)
+func (xp *XPaxos) checkSignature(server int, msgDigest [32]byte, signature []byte) error {
+	cacheKey := verifyKey(server, msgDigest, signature)
+	xp.keyMu.Lock()
+	publicKey := xp.publicKeys[server]
+	retiring, graced := xp.retiring[server]
+	cached, epoch := xp.lookupSignature(cacheKey)
+	xp.keyMu.Unlock()
+	if publicKey == nil {
+		return fmt.Errorf("signature of server %d: %w", server, errUnknownServer)
+	}
+	if cached { // Verified before (see verifycache.go)
+		return nil
+	}
+
+	atomic.AddInt64(&xp.verifications, 1)
+	start := time.Now()
+	err := signing.Verify(publicKey, msgDigest, signature)
+	if err != nil && graced && signing.Verify(retiring.publicKey, msgDigest, signature) == nil {
+		err = nil
+	}
+	atomic.AddInt64(&xp.verifyTime, int64(time.Since(start)))
+	if err != nil {
+		return fmt.Errorf("signature of server %d: %w", server, err)
+	}
+	xp.rememberSignature(cacheKey, server, epoch)
+	return nil
+}
+
+// Signer of a killed server, which keeps its public key only
+type erasedSigner struct {
+	publicKey crypto.PublicKey
+}
+
+func (signer erasedSigner) Sign(msgDigest [32]byte) ([]byte, error) {
+	return nil, errErased
+}
+
+func (signer erasedSigner) Public() crypto.PublicKey {
+	return signer.publicKey
+}
+
+// Wipes the private keys of a killed server (those of signers that can, see signing.Eraser) and its
+// session keys, and replaces its signer with one that fails, so that it never signs again, even if
+// its goroutines keep running
+// => The threshold share of the server belongs to its configuration (see threshold.go): the server
+//    stops signing with it, but does not wipe it
+func (xp *XPaxos) eraseKeys() {
+	xp.keyMu.Lock()
+	signers := []signing.Signer{xp.signer, xp.nextKey}
+	for _, signer := range xp.pendingKeys {
+		signers = append(signers, signer)
+	}
+	for _, signer := range signers {
+		if eraser, ok := signer.(signing.Eraser); ok {
+			eraser.Erase()
+		}
+	}
+	xp.signer = erasedSigner{xp.signer.Public()}
+	xp.nextKey = nil
+	xp.pendingKeys = make(map[string]signing.Signer)
+	xp.keyMu.Unlock()
+
+	xp.sessions.Erase()
+}
+
+func (xp *XPaxos) keysErased() bool {
+	xp.keyMu.Lock()
+	defer xp.keyMu.Unlock()
+
+	_, erased := xp.signer.(erasedSigner)
+	return erased
+}
+
+// Time the server spent signing messages and verifying signatures, apart from the network and the
+// queues of its RPC server (see metrics.go and experiment)
+func (xp *XPaxos) CryptoTime() (time.Duration, time.Duration) {
+	return time.Duration(atomic.LoadInt64(&xp.signTime)), time.Duration(atomic.LoadInt64(&xp.verifyTime))
+}
+
 //
 // ------------------------------ HELPER FUNCTIONS ----------------------------
 //
 func (xp *XPaxos) getLeader() int {
-	return ((xp.view - 1) % (len(xp.replicas) - 1)) + 1
+	return xp.leaderOf(xp.view)
 }
 
-func (xp *XPaxos) generateSynchronousGroup(seed int64) {
-	r := rand.New(rand.NewSource(seed))
+func (xp *XPaxos) leaderOf(view int) int {
+	return ((view - 1) % (len(xp.replicas) - 1)) + 1
+}
+
+// Synchronous group of view: its leader and t servers picked pseudo-randomly with the view as the
+// seed, so that every server computes the same group of any view, member or not
+func (xp *XPaxos) groupOf(view int) map[int]bool {
+	r := rand.New(rand.NewSource(int64(view)))
+	leader := xp.leaderOf(view)
 	numAdded := 0
 
-	xp.synchronousGroup = make(map[int]bool, 0)
-	xp.synchronousGroup[xp.getLeader()] = true
+	group := make(map[int]bool, 0)
+	group[leader] = true
 
 	for _, server := range r.Perm(len(xp.replicas)) {
-		if server != CLIENT && server != xp.getLeader() && numAdded < (len(xp.replicas)-1)/2 {
-			xp.synchronousGroup[server] = true
+		if server != CLIENT && server != leader && numAdded < (len(xp.replicas)-1)/2 {
+			group[server] = true
 			numAdded++
 		}
 	}
+	return group
+}
+
+// Synchronous group of view seed if the server is a member (empty otherwise)
+func (xp *XPaxos) generateSynchronousGroup(seed int64) {
+	xp.synchronousGroup = xp.groupOf(int(seed))
 
 	if xp.synchronousGroup[xp.id] != true {
 		xp.synchronousGroup = make(map[int]bool, 0)
 	}
 }
 
+// Run wait with xp.mu released, which must be held, and take it back even if wait panics, so that
+// handlers waiting for other servers can still release xp.mu with defer (see network/recover.go)
+func (xp *XPaxos) unlocked(wait func()) {
+	xp.mu.Unlock()
+	defer xp.mu.Lock()
+	wait()
+}
+
 func (xp *XPaxos) appendToPrepareLog(request ClientRequest, msg Message) PrepareLogEntry {
 	prepareEntry := PrepareLogEntry{
 		Request: request,
@@ -110,6 +375,133 @@ func (xp *XPaxos) appendToCommitLog(request ClientRequest, msg Message, msgMap m
 	xp.commitLog = append(xp.commitLog, commitEntry)
 }
 
+// Client timestamp of the last request of a client in the prepare log (-1 if none), since every
+// client numbers its requests on its own; the stable checkpoint stands in for truncated entries
+func (xp *XPaxos) lastPrepared(clientId int) int {
+	for seqNum := len(xp.prepareLog) - 1; seqNum >= 0; seqNum-- {
+		if xp.prepareLog[seqNum].Request.ClientId == clientId {
+			return xp.prepareLog[seqNum].Msg0.ClientTimestamp
+		}
+	}
+	if timestamp, ok := xp.checkpoint.LastApplied[clientId]; ok {
+		return timestamp
+	}
+	return -1
+}
+
+// Client timestamp of the last executed request of a client (-1 if none), like lastPrepared()
+func (xp *XPaxos) lastExecuted(clientId int) int {
+	for seqNum := xp.executeSeqNum - 1; seqNum >= xp.truncated; seqNum-- {
+		if xp.commitLog[seqNum-xp.truncated].Request.ClientId == clientId {
+			return xp.commitLog[seqNum-xp.truncated].Request.Timestamp
+		}
+	}
+	if timestamp, ok := xp.checkpoint.LastApplied[clientId]; ok {
+		return timestamp
+	}
+	return -1
+}
+
+// Number of distinct followers whose commit entry holds: the leader of the view of a commit never
+// commits, so a commit signed by it does not count; must be called with xp.mu held
+func (xp *XPaxos) followerCommits(entry CommitLogEntry) int {
+	commits := 0
+	for server, msg := range entry.Msg1 {
+		if msg.SenderId == server && server != xp.leaderOf(msg.View) {
+			commits++
+		}
+	}
+	return commits
+}
+
+// Execute the commit log entries that the whole synchronous group committed, in log order: an
+// entry whose commits are still missing holds back the entries after it, even those committed
+// already (i.e. of concurrent requests prepared after it); must be called with xp.mu held
+func (xp *XPaxos) executeCommitted() {
+	executed := xp.executeSeqNum
+	for xp.executeSeqNum >= xp.truncated && xp.executeSeqNum < xp.commitLength() &&
+		(xp.followerCommits(xp.commitLog[xp.executeSeqNum-xp.truncated]) >= len(xp.synchronousGroup)-1 ||
+			xp.commitLog[xp.executeSeqNum-xp.truncated].Certificate != nil) {
+		xp.compactEntry(xp.executeSeqNum) // Its certificate stands in for its commits (see threshold.go)
+		xp.executeSeqNum++
+		xp.record(journal.EXECUTED, xp.executeSeqNum)
+	}
+	if xp.executeSeqNum > executed {
+		xp.persist(executed)
+		xp.applyExecuted()
+		xp.notifyChange()
+	}
+}
+
+// Execute the committed entries until the one at seqNum (see executeCommitted()), waiting for the
+// commits of the group until timer fires or the view changes; returns whether the entry at seqNum
+// was executed; must be called with xp.mu held
+func (xp *XPaxos) executeUntil(seqNum int, view int, timer <-chan time.Time) bool {
+	for xp.view == view {
+		xp.executeCommitted()
+		if xp.executeSeqNum >= seqNum {
+			return true
+		}
+
+		timedOut := false
+		changed := xp.changed
+		xp.unlocked(func() {
+			select {
+			case <-timer:
+				timedOut = true
+			case <-changed:
+			}
+		})
+		if timedOut {
+			return false
+		}
+	}
+	return false
+}
+
+// Apply the executed commit log entries that were not applied yet to the state machine, in log
+// order and every client request at most once (retransmissions are prepared again after a view
+// change); must be called with xp.mu held
+func (xp *XPaxos) applyExecuted() {
+	xp.applyKeyChanges() // With or without a state machine
+	if xp.stateMachine == nil {
+		return
+	}
+
+	for xp.applied < xp.executeSeqNum && xp.applied < xp.commitLength() {
+		entry := xp.commitLog[xp.applied-xp.truncated]
+		request := entry.Request
+		xp.applied++
+
+		if last, ok := xp.lastApplied[request.ClientId]; ok && request.Timestamp <= last {
+			continue
+		}
+		xp.lastApplied[request.ClientId] = request.Timestamp
+		if _, ok := request.Operation.(KeyChange); ok { // Applied by applyKeyChanges()
+			xp.results[request.ClientId] = nil
+		} else { // At the time its leader signed into the entry, the same on every server
+			span := xp.getTracer().Start(request.TraceId, "XPaxos.Execute")
+			span.Set("seqNum", xp.applied)
+			xp.results[request.ClientId] = statemachine.ApplyAt(xp.stateMachine, statemachine.Encode(request.Operation),
+				entry.Msg0.Time)
+			span.End()
+		}
+
+		if xp.interval > 0 && xp.applied%xp.interval == 0 {
+			xp.takeCheckpoint()
+		}
+	}
+}
+
+// Result of a client request if it is the last one of its client applied to the state machine
+// (nil otherwise); must be called with xp.mu held
+func (xp *XPaxos) result(request ClientRequest) []byte {
+	if xp.stateMachine == nil || xp.lastApplied[request.ClientId] != request.Timestamp {
+		return nil
+	}
+	return xp.results[request.ClientId]
+}
+
 func (xp *XPaxos) updatePrepareLog(seqNum int, request ClientRequest, msg Message) {
 	prepareEntry := PrepareLogEntry{
 		Request: request,
@@ -118,17 +510,20 @@ func (xp *XPaxos) updatePrepareLog(seqNum int, request ClientRequest, msg Messag
 	xp.prepareLog[seqNum] = prepareEntry
 }
 
-func (xp *XPaxos) compareLogs(prepareLog []PrepareLogEntry, commitLog []CommitLogEntry) bool {
+// Whether prepareLog, whose first entry is at index first, matches the commit log wherever both
+// hold entries
+func (xp *XPaxos) compareLogs(prepareLog []PrepareLogEntry, first int) bool {
 	var commitEntryMsg0 Message
 	var check1 int
 	var check2 bool
 	var check3 bool
 
-	if len(prepareLog) != len(commitLog) {
+	if first > xp.truncated || first+len(prepareLog) != xp.commitLength() {
 		return false
 	} else {
-		for seqNum, prepareEntry := range prepareLog {
-			commitEntryMsg0 = commitLog[seqNum].Msg0
+		for seqNum := xp.truncated; seqNum < xp.commitLength(); seqNum++ {
+			prepareEntry := prepareLog[seqNum-first]
+			commitEntryMsg0 = xp.commitLog[seqNum-xp.truncated].Msg0
 
 			check1 = bytes.Compare(prepareEntry.Msg0.MsgDigest[:], commitEntryMsg0.MsgDigest[:])
 			check2 = (prepareEntry.Msg0.PrepareSeqNum == commitEntryMsg0.PrepareSeqNum)
@@ -142,27 +537,40 @@ func (xp *XPaxos) compareLogs(prepareLog []PrepareLogEntry, commitLog []CommitLo
 	}
 }
 
+// Timer channel that is closed once d elapsed on the server's clock, so that every receiver
+// waiting on it wakes up (the channel of clock.After() only wakes up one of them, and the others
+// would wait forever)
+func (xp *XPaxos) broadcastTimer(d time.Duration) <-chan time.Time {
+	timer := xp.clock.After(d)
+	expired := make(chan time.Time)
+	go func() {
+		<-timer
+		close(expired)
+	}()
+	return expired
+}
+
 func (xp *XPaxos) setVCTimer() {
 	oldView := xp.view
 
 	xp.netFlag = true
 	xp.vcFlag = false
-	xp.vcTimer = time.NewTimer(3 * network.DELTA * time.Millisecond).C
+	xp.vcTimer = xp.clock.After(3 * network.DELTA * time.Millisecond)
 
-	go func(xp *XPaxos, oldView int) {
-		<-xp.vcTimer
+	go func(xp *XPaxos, oldView int, vcTimer <-chan time.Time) {
+		<-vcTimer
 
 		xp.mu.Lock()
 		if xp.vcFlag == false && xp.view == oldView {
-			dPrintf("Timeout: XPaxos.setVCTimer: XPaxos server (%d)\n", xp.id)
+			xp.log().With("view", oldView).Debugf("Timeout: XPaxos.setVCTimer")
 			go xp.issueSuspect(xp.view)
 		}
 		xp.mu.Unlock()
-	}(xp, oldView)
+	}(xp, oldView, xp.vcTimer)
 }
 
 func (xp *XPaxos) issueConfirmVC() bool {
-	dPrintf("ConfirmVC: from XPaxos server (%d) to client server (%d)\n", xp.id, CLIENT)
+	xp.log().Debugf("ConfirmVC: to client server (%d)", CLIENT)
 	return xp.replicas[CLIENT].Call("Client.ConfirmVC", Message{}, &Reply{}, xp.id)
 }
 
@@ -241,6 +649,24 @@ func compareCommitLogEntries(cfg *config) {
 	}
 }
 
+// The state machines of the servers of the synchronous group hold the same state
+func compareStateMachines(cfg *config) {
+	currentView := getCurrentView(cfg)
+
+	for i := 1; i < cfg.n; i++ {
+		if cfg.xpServers[i].view == currentView {
+			stateHash := cfg.machines[i].Hash()
+			for j := 1; j < cfg.n; j++ {
+				if cfg.xpServers[i].synchronousGroup[j] == true && cfg.machines[j].Hash() != stateHash {
+					if cfg.xpServers[i].vcInProgress == false && cfg.xpServers[j].vcInProgress == false {
+						cfg.t.Fatal("Invalid state machines!")
+					}
+				}
+			}
+		}
+	}
+}
+
 func compareCommitLogEntriesChecker(commitLog1 []CommitLogEntry, commitLog2 []CommitLogEntry) bool {
 	if len(commitLog1) != len(commitLog2) {
 		return false
@@ -260,6 +686,169 @@ func compareCommitLogEntriesChecker(commitLog1 []CommitLogEntry, commitLog2 []Co
 	return true
 }
 
+// Check the proposals recorded by cfg.propose against the longest commit log: every proposal is a
+// put of its client timestamp, and its output is the timestamp of the operation logged right
+// before it
+// => Only the executed prefix of a log counts: the leader acknowledges a request once it executed
+//    it, while a server left out of the last view may still hold entries of an earlier view past
+//    its executed prefix that were never committed (and whose slots were reused)
+// => Byzantine servers only tamper with the messages they send (see byzantine.go), so their logs
+//    are as trustworthy as those of correct servers
+func checkLinearizability(cfg *config) {
+	ops := cfg.history.Operations()
+	if len(ops) == 0 {
+		return
+	}
+
+	var logged []CommitLogEntry
+	for i := 1; i < cfg.n; i++ {
+		commitLog, first, executed := cfg.fullCommitLog(cfg.xpServers[i])
+		if executed > len(commitLog) {
+			executed = len(commitLog)
+		}
+		if first == 0 && executed > len(logged) {
+			logged = commitLog[:executed]
+		}
+	}
+
+	outputs := make(map[int]string, len(logged))
+	previous := ""
+	for _, commitEntry := range logged {
+		if _, ok := outputs[commitEntry.Request.Timestamp]; !ok && commitEntry.Request.ClientId == CLIENT {
+			outputs[commitEntry.Request.Timestamp] = previous
+			previous = strconv.Itoa(commitEntry.Request.Timestamp)
+		}
+	}
+
+	cfg.mu.Lock()
+	history := make([]linearizability.Operation, 0, len(ops))
+	for id, op := range ops {
+		if output, ok := outputs[cfg.proposals[id]]; ok {
+			op.Output = linearizability.KvOutput{Value: output}
+		} else if op.Return != linearizability.PENDING {
+			iPrintf("Proposal (%d) was acknowledged but never logged\n", cfg.proposals[id])
+			cfg.mu.Unlock()
+			cfg.t.Fatal("Acknowledged proposal was never logged!")
+		} else {
+			continue // Unacknowledged proposal that never took effect
+		}
+		history = append(history, op)
+	}
+	cfg.mu.Unlock()
+
+	if linearizability.CheckOperations(linearizability.KvModel, history) == false {
+		order := make([]int, len(logged))
+		for i, commitEntry := range logged {
+			order[i] = commitEntry.Request.Timestamp
+		}
+		iPrintf("Logged client timestamps: %v\n", order)
+		cfg.t.Fatal("History is not linearizable!")
+	}
+}
+
+// Check that all servers executed the same requests in the same order: a server may have executed
+// fewer requests than another, but never different ones
+func (cfg *config) checkAgreement() {
+	var reference []CommitLogEntry
+	referenceId := 0
+
+	for i := 1; i < cfg.n; i++ {
+		if cfg.xpServers[i] == nil {
+			continue // Crashed
+		}
+
+		commitLog, first, executed := cfg.fullCommitLog(cfg.xpServers[i])
+		if executed > first+len(commitLog) {
+			executed = first + len(commitLog)
+		}
+		if executed < first {
+			continue // Adopted a checkpoint above the entries it holds
+		}
+		commitLog = commitLog[:executed-first]
+
+		for j := first; j < executed && j < len(reference); j++ {
+			if digest(commitLog[j-first].Request) != digest(reference[j].Request) {
+				cfg.t.Fatalf("Commit logs diverge at sequence number %d: server %d executed (client %d, "+
+					"timestamp %d) but server %d executed (client %d, timestamp %d)!", j+1, referenceId,
+					reference[j].Request.ClientId, reference[j].Request.Timestamp, i,
+					commitLog[j-first].Request.ClientId, commitLog[j-first].Request.Timestamp)
+			}
+		}
+
+		if executed > len(reference) && first <= len(reference) {
+			reference = append(append([]CommitLogEntry(nil), reference[:first]...), commitLog...)
+			referenceId = i
+		}
+	}
+}
+
+// Check the executed requests of every client (acked: client ID -> timestamps the leader replied
+// to) against the commit logs: each client's requests execute in the order it proposed them and
+// at most once, and every acknowledged request executes on some server
+func (cfg *config) checkClients(acked map[int][]int) {
+	executed := make(map[int]map[int]bool, len(acked))
+
+	for i := 1; i < cfg.n; i++ {
+		if cfg.xpServers[i] == nil {
+			continue // Crashed
+		}
+
+		commitLog, first, numExecuted := cfg.fullCommitLog(cfg.xpServers[i])
+		if numExecuted > first+len(commitLog) {
+			numExecuted = first + len(commitLog)
+		}
+
+		last := make(map[int]int, len(acked))
+		for j := first; j < numExecuted; j++ {
+			request := commitLog[j-first].Request
+			if previous, ok := last[request.ClientId]; ok && request.Timestamp <= previous {
+				cfg.t.Fatalf("Server %d executed (client %d, timestamp %d) at sequence number %d after "+
+					"timestamp %d!", i, request.ClientId, request.Timestamp, j+1, previous)
+			}
+			last[request.ClientId] = request.Timestamp
+
+			if executed[request.ClientId] == nil {
+				executed[request.ClientId] = make(map[int]bool)
+			}
+			executed[request.ClientId][request.Timestamp] = true
+		}
+	}
+
+	for clientId, timestamps := range acked {
+		for _, timestamp := range timestamps {
+			if executed[clientId][timestamp] == false {
+				cfg.t.Fatalf("Acknowledged request (client %d, timestamp %d) was never executed!", clientId,
+					timestamp)
+			}
+		}
+	}
+}
+
+// The result of every acknowledged request (see cfg.contend()) must be its position in the log
+// machines of the servers (see statemachine.Log), i.e. the leader replied once it applied the
+// request and every request before it
+func (cfg *config) checkResults(acked map[int][]int, results map[int][][]byte) {
+	var ops [][]byte
+	for i := 1; i < cfg.n; i++ {
+		if machine, ok := cfg.machines[i].(*statemachine.Log); ok && cfg.xpServers[i] != nil &&
+			len(machine.Ops()) > len(ops) {
+			ops = machine.Ops()
+		}
+	}
+
+	for clientId, timestamps := range acked {
+		for k, timestamp := range timestamps {
+			result := results[clientId][k]
+			position, err := strconv.Atoi(string(result))
+			if result == nil || err != nil || position < 1 || position > len(ops) ||
+				string(ops[position-1]) != fmt.Sprintf("%d-%d", clientId, timestamp) {
+				cfg.t.Fatalf("Acknowledged request (client %d, timestamp %d) returned %q!", clientId, timestamp,
+					result)
+			}
+		}
+	}
+}
+
 func getCurrentView(cfg *config) int {
 	numCurrent := 0
 	currentView := 0
@@ -279,3 +868,159 @@ func getCurrentView(cfg *config) int {
 
 	return currentView
 }
+
+// View that at least (n+1)/2 of the running servers are in, or 0 while there is none (unlike
+// getCurrentView(), this skips crashed servers and does not fail the test)
+func (cfg *config) agreedView() int {
+	views := make(map[int]int)
+	for i := 1; i < cfg.n; i++ {
+		if xp := cfg.xpServers[i]; xp != nil {
+			xp.mu.Lock()
+			views[xp.view]++
+			xp.mu.Unlock()
+		}
+	}
+
+	agreed := 0
+	for view, num := range views {
+		if num >= (cfg.n+1)/2 && view > agreed {
+			agreed = view
+		}
+	}
+	return agreed
+}
+
+// Wait until the servers agree on view v or a later one and return it; the test fails if they do
+// not within timeout
+func (cfg *config) waitForView(v int, timeout time.Duration) int {
+	for deadline := time.Now().Add(timeout); ; time.Sleep(10 * time.Millisecond) {
+		if view := cfg.agreedView(); view >= v {
+			return view
+		} else if time.Now().After(deadline) {
+			iPrintf("Servers agree on view %d instead of %d\n", view, v)
+			cfg.t.Fatalf("Servers failed to reach view %d!", v)
+		}
+	}
+}
+
+// Wait until every XPaxos server that is up is in the same view and return it; the test fails if
+// they do not converge within timeout
+func (cfg *config) waitForSingleView(timeout time.Duration) int {
+	for deadline := time.Now().Add(timeout); ; time.Sleep(10 * time.Millisecond) {
+		views := make(map[int]bool)
+		view := 0
+		for i := 1; i < cfg.n; i++ {
+			cfg.mu.Lock()
+			xp := cfg.xpServers[i]
+			cfg.mu.Unlock()
+
+			if xp != nil {
+				xp.mu.Lock()
+				view = xp.view
+				views[view] = true
+				xp.mu.Unlock()
+			}
+		}
+
+		if len(views) == 1 {
+			return view
+		} else if time.Now().After(deadline) {
+			iPrintf("Servers are in views %v\n", views)
+			cfg.t.Fatal("Servers failed to converge to a single view!")
+		}
+	}
+}
+
+// Wait until the servers agree on a view whose leader is not server exclude (i.e. the crashed or
+// Byzantine leader) and return the new leader; the test fails if they do not within timeout
+func (cfg *config) waitForNewLeader(exclude int, timeout time.Duration) int {
+	for deadline := time.Now().Add(timeout); ; time.Sleep(10 * time.Millisecond) {
+		view := cfg.agreedView()
+		if leader := ((view - 1) % (cfg.n - 1)) + 1; view > 0 && leader != exclude {
+			return leader
+		} else if time.Now().After(deadline) {
+			iPrintf("Servers agree on view %d (leader %d)\n", view, leader)
+			cfg.t.Fatal("Servers failed to elect a new leader!")
+		}
+	}
+}
+
+// Wait until the servers agree on view v or a later one and every server of its synchronous group
+// that is up reached it and completed its view change to it (see NewView()), and return the view;
+// the test fails if they do not within timeout
+func (cfg *config) waitForNewView(v int, timeout time.Duration) int {
+	for deadline := time.Now().Add(timeout); ; time.Sleep(10 * time.Millisecond) {
+		view := cfg.agreedView()
+		completed := view >= v
+		for i := 1; i < cfg.n && completed == true; i++ {
+			cfg.mu.Lock()
+			xp := cfg.xpServers[i]
+			cfg.mu.Unlock()
+
+			if xp != nil {
+				xp.mu.Lock()
+				if xp.groupOf(view)[i] == true && (xp.view < view || (xp.view == view && xp.vcInProgress == true)) {
+					completed = false
+				}
+				xp.mu.Unlock()
+			}
+		}
+
+		if completed == true {
+			return view
+		} else if time.Now().After(deadline) {
+			iPrintf("Servers agree on view %d\n", view)
+			cfg.t.Fatalf("Servers failed to complete a view change to view %d!", v)
+		}
+	}
+}
+
+// Wait until every server of the synchronous group of the agreed view that is up executed the
+// entries its leader prepared, i.e. a request the view change acknowledged (see issueConfirmVC())
+// that is still in flight; the test fails if they do not within timeout
+func (cfg *config) waitForExecuted(timeout time.Duration) {
+	for deadline := time.Now().Add(timeout); ; time.Sleep(10 * time.Millisecond) {
+		view := cfg.agreedView()
+		prepared, executed := 0, -1
+		for i := 1; i < cfg.n; i++ {
+			cfg.mu.Lock()
+			xp := cfg.xpServers[i]
+			cfg.mu.Unlock()
+
+			if xp != nil {
+				xp.mu.Lock()
+				if xp.view == view && xp.synchronousGroup[i] == true {
+					if xp.prepareSeqNum > prepared {
+						prepared = xp.prepareSeqNum
+					}
+					if executed < 0 || xp.executeSeqNum < executed {
+						executed = xp.executeSeqNum
+					}
+				}
+				xp.mu.Unlock()
+			}
+		}
+
+		if executed >= prepared {
+			return
+		} else if time.Now().After(deadline) {
+			iPrintf("Servers of view %d executed %d of %d entries\n", view, executed, prepared)
+			cfg.t.Fatal("Servers failed to execute the prepared entries!")
+		}
+	}
+}
+
+// Entries of a kind ever recorded in the journal of every XPaxos server that is up (see journal)
+func (cfg *config) journalCounts(kind int) map[int]int {
+	counts := make(map[int]int)
+	for i := 1; i < cfg.n; i++ {
+		cfg.mu.Lock()
+		xp := cfg.xpServers[i]
+		cfg.mu.Unlock()
+
+		if xp != nil {
+			counts[i] = xp.Journal().Count(kind)
+		}
+	}
+	return counts
+}
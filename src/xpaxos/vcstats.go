@@ -0,0 +1,68 @@
+package xpaxos
+
+// Cost of the view changes of an XPaxos server, for the recovery-cost comparison with PBFT
+//
+// xp.ViewChangeStats() - View changes the server started and completed, and their cost
+//
+// => A view change starts when the server moves to a higher view on a suspicion and completes when
+//    it accepts the NEW-VIEW of its view; its duration is measured with the server's clock, so it
+//    is virtual in tests that drive one
+// => A view change interrupted by another suspicion is started again but completes once, so
+//    Started - Completed view changes were interrupted (or are still in progress)
+// => Messages are the view change messages (suspect, view-change, VC-final and new-view) the
+//    server sent while a view change was in progress, forwarded suspicions included; the sum over
+//    all servers is the number of messages the view changes required
+// => Reproposed requests are those the new leader re-proposed that the server had not executed,
+//    i.e. the requests the view change committed
+// => Deployed servers export the stats as metrics (see metrics.go) and experiments report them
+//    (see experiment); the PBFT implementation has no view change protocol
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type ViewChangeStats struct {
+	Started    int           // View changes started on a suspicion
+	Completed  int           // View changes completed on a new view
+	Duration   time.Duration // Of all completed view changes
+	Last       time.Duration // Of the last completed view change
+	Messages   int           // View change messages sent during view changes
+	Reproposed int           // Requests re-proposed by new leaders and executed at their new views
+}
+
+func (xp *XPaxos) ViewChangeStats() ViewChangeStats {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	return xp.vcStats
+}
+
+// Must be called with xp.mu held, before xp.vcInProgress is set
+func (xp *XPaxos) startViewChange() {
+	xp.vcStats.Started++
+	if xp.vcInProgress == false {
+		xp.vcStarted = xp.clock.Now()
+		xp.vcSentAtStart = atomic.LoadInt64(&xp.vcSent)
+	}
+}
+
+// Must be called with xp.mu held, before xp.vcInProgress is cleared
+func (xp *XPaxos) completeViewChange(reproposed int) {
+	if xp.vcInProgress == false {
+		return
+	}
+
+	duration := xp.clock.Now().Sub(xp.vcStarted)
+	xp.vcStats.Completed++
+	xp.vcStats.Duration += duration
+	xp.vcStats.Last = duration
+	xp.vcStats.Messages += int(atomic.LoadInt64(&xp.vcSent) - xp.vcSentAtStart)
+	xp.vcStats.Reproposed += reproposed
+	xp.log().With("view", xp.view).Debugf("NewView: view change completed in %v", duration)
+}
+
+// Called by the senders of view change messages
+func (xp *XPaxos) countViewChangeMessage() {
+	atomic.AddInt64(&xp.vcSent, 1)
+}
@@ -0,0 +1,119 @@
+package xpaxos
+
+// Signatures a server already verified, so that it does not verify them again
+//
+// xp.SetVerifyCache(size)   - Remembers the last size signatures that verified (0 = no cache)
+// xp.VerifyCacheStats()     - Signatures found in the cache (hits) and verified (misses) so far
+//
+// => Retransmitted prepares and commits, and the commit logs of view changes and certificates of
+//    checkpoints, carry signatures the server checked before; checkSignature() looks them up by
+//    the digest of (message digest, server, signature) before any public key operation
+// => Only signatures that verified are remembered, so a Byzantine server cannot make the cache
+//    reject a valid signature, and a signature missing from it is verified as before
+// => The cache holds at most size entries (VERIFYCACHE by default); the oldest is evicted first,
+//    and a signature remembered twice is only as old as its latest add()
+// => A key change of a server, and the end of its grace window, forget the entries of the server
+//    (see rotation.go), so that a signature of a key it retired never passes from the cache
+// => Hits and misses are exported as xpaxos_verify_cache_hits_total and
+//    xpaxos_verify_cache_misses_total (see metrics.go); xpaxos_verifications_total counts misses
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync/atomic"
+)
+
+const VERIFYCACHE = 4096 // Signatures remembered by a server by default
+
+type verifyCache struct {
+	entries map[[32]byte]cached // Cache key -> server whose signature verified
+	order   [][32]byte          // Cache keys, in a ring of the size of the cache
+	next    int                 // Slot of the next entry, which holds the oldest one
+}
+
+type cached struct {
+	server int // Server whose signature verified
+	slot   int // Slot of the ring that holds the key, the latest one if it was added again
+}
+
+func makeVerifyCache(size int) *verifyCache {
+	if size <= 0 {
+		return nil
+	}
+	cache := &verifyCache{}
+	cache.entries = make(map[[32]byte]cached, size)
+	cache.order = make([][32]byte, size)
+	return cache
+}
+
+func verifyKey(server int, msgDigest [32]byte, signature []byte) [32]byte {
+	data := make([]byte, 0, 40+len(signature))
+	data = binary.BigEndian.AppendUint64(data, uint64(server))
+	data = append(data, msgDigest[:]...)
+	data = append(data, signature...)
+	return sha256.Sum256(data)
+}
+
+// The oldest key is evicted only if its slot is still the one of its entry: a key added again
+// since (i.e. verified by two lookups at once), or forgotten and then added again, lives on in a
+// later slot
+func (cache *verifyCache) add(key [32]byte, server int) {
+	oldest := cache.order[cache.next]
+	if entry, ok := cache.entries[oldest]; ok && entry.slot == cache.next {
+		delete(cache.entries, oldest)
+	}
+	cache.entries[key] = cached{server, cache.next}
+	cache.order[cache.next] = key
+	cache.next = (cache.next + 1) % len(cache.order)
+}
+
+func (cache *verifyCache) forget(server int) {
+	for key, entry := range cache.entries {
+		if entry.server == server {
+			delete(cache.entries, key)
+		}
+	}
+}
+
+func (xp *XPaxos) SetVerifyCache(size int) {
+	xp.keyMu.Lock()
+	defer xp.keyMu.Unlock()
+
+	xp.verified = makeVerifyCache(size)
+	xp.verifyEpoch++
+}
+
+func (xp *XPaxos) VerifyCacheStats() (int64, int64) {
+	return atomic.LoadInt64(&xp.verifyHits), atomic.LoadInt64(&xp.verifyMisses)
+}
+
+// Must be called with xp.keyMu held; the epoch is to be passed to rememberSignature()
+func (xp *XPaxos) lookupSignature(key [32]byte) (bool, int) {
+	if xp.verified == nil {
+		return false, xp.verifyEpoch
+	}
+	if _, ok := xp.verified.entries[key]; ok {
+		atomic.AddInt64(&xp.verifyHits, 1)
+		return true, xp.verifyEpoch
+	}
+	atomic.AddInt64(&xp.verifyMisses, 1)
+	return false, xp.verifyEpoch
+}
+
+// Remembers a signature that verified, unless the keys of a server changed since it was looked up
+func (xp *XPaxos) rememberSignature(key [32]byte, server int, epoch int) {
+	xp.keyMu.Lock()
+	defer xp.keyMu.Unlock()
+
+	if xp.verified != nil && xp.verifyEpoch == epoch {
+		xp.verified.add(key, server)
+	}
+}
+
+// Must be called with xp.keyMu held
+func (xp *XPaxos) forgetSignatures(server int) {
+	if xp.verified != nil {
+		xp.verified.forget(server)
+	}
+	xp.verifyEpoch++
+}
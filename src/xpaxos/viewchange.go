@@ -1,9 +1,42 @@
 package xpaxos
 
+// View change of XPaxos: replaces the synchronous group of a view (and its leader) that a server
+// suspects of a fault
+//
+// xp.issueSuspect(view) - Suspects view: signs a SUSPECT message of it and sends it to every server
+//
+// => A server that receives a valid SUSPECT of its view (or a higher one) moves to the next view,
+//    forwards the SUSPECT, picks the synchronous group of the new view (its leader and t servers
+//    seeded by the view, the same on every server, see groupOf()) and sends a VIEW-CHANGE message
+//    with its commit log above its stable checkpoint (and the checkpoint) to every member of the
+//    new group, so that members activated by the view change, whose logs lag, take over the log of
+//    the servers it replaces
+// => A member sends a VC-FINAL message with the VIEW-CHANGE messages it received to the rest of the
+//    group once it has those of all servers, or those of a majority after 3 DELTA
+// => Once a member has the VC-FINAL messages of the whole group, it merges the commit logs they
+//    carry (the entry of the highest view wins at every sequence number); the new leader signs
+//    prepare messages of the merged log and sends them in a NEW-VIEW message (see threshold.go for
+//    its certificate), and members that find it matches their merged log install the view and
+//    execute the log, after which the leader tells the client (see issueConfirmVC())
+// => Entries of an installed log keep the view they were committed in, whose group signed their
+//    commits, and record the prepare of the new leader that installed them: a server that missed
+//    the view change (i.e. while partitioned) may still hold an entry it prepared in an older view
+//    at the same sequence number, which must lose to the installed one when both meet in a later
+//    merge (see acceptedView())
+// => The merge decides on signed messages only: an entry counts with the view of its prepare, or
+//    of the prepare that installed it, if the leader of that view signed it for that sequence
+//    number, so a faulty server cannot win the merge by claiming a higher view for its entries
+// => A server suspects the view again whenever a message of the view change fails to arrive or
+//    to verify, so the view keeps increasing until a group completes its view change
+// => The leader of a view accepts client requests only once it installed the view (see Replicate()),
+//    so that no request is prepared at a sequence number the merged log of the view already holds
+
 import (
 	"bytes"
 	//"math/rand"
+	"github.com/csanti/cos518_project/src/journal"
 	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
 	"time"
 )
 
@@ -18,38 +51,71 @@ func (xp *XPaxos) sendSuspect(server int, msg SuspectMessage, reply *Reply) bool
 	//	}
 	//}
 
-	dPrintf("Suspect: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
-	return xp.replicas[server].Call("XPaxos.Suspect", msg, reply, xp.id)
+	xp.log().With("view", msg.View).Debugf("Suspect: to XPaxos server (%d)", server)
+	xp.countViewChangeMessage()
+	return xp.replicas[server].CallPriority("XPaxos.Suspect", msg, reply, xp.id, VCPRIORITY)
 }
 
+// Sends a SUSPECT message to server; one of our own that fails is sent again every RETRANSMIT
+// while its view lasts
 func (xp *XPaxos) issueSuspectHelper(server int, msg SuspectMessage) {
-	reply := &Reply{}
+	for {
+		reply := &Reply{}
 
-	if ok := xp.sendSuspect(server, msg, reply); ok {
-		xp.mu.Lock()
-		if xp.view != msg.View {
+		if ok := xp.sendSuspect(server, msg, reply); ok {
+			xp.mu.Lock()
+			if xp.view != msg.View {
+				xp.mu.Unlock()
+				return
+			}
+
+			verification := xp.verify(server, reply.MsgDigest, reply.Signature)
+
+			if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) != 0 || verification == false {
+				go xp.issueSuspect(xp.view)
+			}
 			xp.mu.Unlock()
 			return
 		}
 
-		verification := xp.verify(server, reply.MsgDigest, reply.Signature)
-
-		if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) != 0 || verification == false {
-			go xp.issueSuspect(xp.view)
+		xp.mu.Lock()
+		if msg.SenderId != xp.id || xp.keysErased() == true { // Retransmit our own only
+			xp.mu.Unlock()
+			return
+		}
+		timer := xp.clock.After(RETRANSMIT)
+		for waiting := true; waiting && xp.view == msg.View; {
+			changed := xp.changed
+			xp.unlocked(func() {
+				select {
+				case <-timer:
+					waiting = false
+				case <-changed:
+				}
+			})
 		}
+		view := xp.view
 		xp.mu.Unlock()
-	} else {
-		go xp.issueSuspect(msg.View)
+
+		if view != msg.View {
+			return
+		}
 	}
 }
 
+// Suspects view once: later calls for the same view (i.e. by every handler that timed out waiting
+// for the same member) send nothing
 func (xp *XPaxos) issueSuspect(view int) {
 	xp.mu.Lock()
 	defer xp.mu.Unlock()
 
-	if xp.view != view {
+	if xp.view != view || xp.suspected >= view {
 		return
 	}
+	if xp.keysErased() { // Killed: failed sends would retry without the cost of a signature forever
+		return
+	}
+	xp.suspected = view
 
 	msgDigest := digest(xp.view)
 	signature := xp.sign(msgDigest)
@@ -99,18 +165,24 @@ func (xp *XPaxos) Suspect(msg SuspectMessage, reply *Reply) {
 			xp.suspectSet[digest(msg)] = msg
 
 			xp.view = msg.View + 1
+			xp.notifyChange()
 			go xp.forwardSuspect(msg)
 
 			xp.generateSynchronousGroup(int64(xp.view))
 			xp.vcSet = make(map[[32]byte]ViewChangeMessage, 0)
 			xp.receivedVCFinal = make(map[int]map[[32]byte]ViewChangeMessage, 0)
+			xp.vcShares = make(map[int]signing.SignatureShare)
+			xp.startViewChange()
 			xp.vcInProgress = true
+			xp.record(journal.VIEWCHANGESTARTED, 0)
+			xp.persist(xp.executeSeqNum)
 
 			go xp.issueViewChange(xp.view)
 
+			xp.netTimer = nil // That of the view we left may have expired already
 			if len(xp.synchronousGroup) > 0 {
 				xp.netFlag = false
-				xp.netTimer = time.NewTimer(3 * network.DELTA * time.Millisecond).C
+				xp.netTimer = xp.broadcastTimer(3 * network.DELTA * time.Millisecond)
 			}
 		}
 	} else {
@@ -129,8 +201,9 @@ func (xp *XPaxos) sendViewChange(server int, msg ViewChangeMessage, reply *Reply
 	//	}
 	//}
 
-	dPrintf("ViewChange: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
-	return xp.replicas[server].Call("XPaxos.ViewChange", msg, reply, xp.id)
+	xp.log().With("view", msg.View).Debugf("ViewChange: to XPaxos server (%d)", server)
+	xp.countViewChangeMessage()
+	return xp.replicas[server].CallPriority("XPaxos.ViewChange", msg, reply, xp.id, VCPRIORITY)
 }
 
 func (xp *XPaxos) issueViewChange(view int) {
@@ -145,12 +218,13 @@ func (xp *XPaxos) issueViewChange(view int) {
 	signature := xp.sign(msgDigest)
 
 	msg := ViewChangeMessage{
-		MsgType:   VIEWCHANGE,
-		MsgDigest: msgDigest,
-		Signature: signature,
-		View:      xp.view,
-		SenderId:  xp.id,
-		CommitLog: xp.commitLog}
+		MsgType:    VIEWCHANGE,
+		MsgDigest:  msgDigest,
+		Signature:  signature,
+		View:       xp.view,
+		SenderId:   xp.id,
+		CommitLog:  xp.commitLog,
+		Checkpoint: xp.checkpoint}
 
 	for server, _ := range xp.synchronousGroup {
 		go func(xp *XPaxos, server int, msg ViewChangeMessage) {
@@ -178,6 +252,8 @@ func (xp *XPaxos) issueViewChange(view int) {
 
 func (xp *XPaxos) ViewChange(msg ViewChangeMessage, reply *Reply) {
 	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
 	msgDigest := digest(msg.View)
 	signature := xp.sign(msgDigest)
 	reply.MsgDigest = msgDigest
@@ -190,16 +266,17 @@ func (xp *XPaxos) ViewChange(msg ViewChangeMessage, reply *Reply) {
 			if len(xp.vcSet) == len(xp.replicas)-1 {
 				xp.setVCTimer()
 				go xp.issueVCFinal(xp.view)
-				xp.mu.Unlock()
 				return
 			}
-			xp.mu.Unlock()
+			netTimer := xp.netTimer // Replaced by a later suspect message
 
-			<-xp.netTimer
+			// Outside the synchronous group, which never reaches VC-FINAL
+			if netTimer == nil {
+				return
+			}
+			xp.unlocked(func() { <-netTimer }) // Every handler waiting on it wakes up
 
-			xp.mu.Lock()
 			if xp.view != msg.View {
-				xp.mu.Unlock()
 				return
 			}
 
@@ -207,6 +284,7 @@ func (xp *XPaxos) ViewChange(msg ViewChangeMessage, reply *Reply) {
 				xp.setVCTimer()
 				go xp.issueVCFinal(xp.view)
 			} else if xp.netFlag == false {
+				xp.netFlag = true // The other handlers woken by the timer suspect no more
 				xp.vcFlag = true
 				go xp.issueSuspect(xp.view)
 			}
@@ -214,7 +292,6 @@ func (xp *XPaxos) ViewChange(msg ViewChangeMessage, reply *Reply) {
 			go xp.issueSuspect(xp.view)
 		}
 	}
-	xp.mu.Unlock()
 }
 
 //
@@ -228,8 +305,9 @@ func (xp *XPaxos) sendVCFinal(server int, msg VCFinalMessage, reply *Reply) bool
 	//	}
 	//}
 
-	dPrintf("VCFinal: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
-	return xp.replicas[server].Call("XPaxos.VCFinal", msg, reply, xp.id)
+	xp.log().With("view", msg.View).Debugf("VCFinal: to XPaxos server (%d)", server)
+	xp.countViewChangeMessage()
+	return xp.replicas[server].CallPriority("XPaxos.VCFinal", msg, reply, xp.id, VCPRIORITY)
 }
 
 func (xp *XPaxos) issueVCFinal(view int) {
@@ -255,7 +333,8 @@ func (xp *XPaxos) issueVCFinal(view int) {
 		Signature: signature,
 		View:      xp.view,
 		SenderId:  xp.id,
-		VCSet:     vcSetCopy}
+		VCSet:     vcSetCopy,
+		Share:     xp.signShare(msgDigest)}
 
 	for server, _ := range xp.synchronousGroup {
 		go func(xp *XPaxos, server int, msg VCFinalMessage) {
@@ -283,8 +362,9 @@ func (xp *XPaxos) issueVCFinal(view int) {
 
 func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
 	if xp.view != msg.View {
-		xp.mu.Unlock()
 		return
 	}
 
@@ -298,11 +378,16 @@ func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 			for _, msg := range vcSet {
 				if xp.view != msg.View {
 					delete(xp.receivedVCFinal, senderId)
+					delete(xp.vcShares, senderId)
 				}
 			}
 		}
 
 		if xp.synchronousGroup[msg.SenderId] == true {
+			if xp.acceptShare(msg.SenderId, msgDigest, msg.Share) == false {
+				go xp.issueSuspect(xp.view)
+				return
+			}
 			xp.receivedVCFinal[msg.SenderId] = msg.VCSet
 
 			if len(xp.receivedVCFinal) >= len(xp.synchronousGroup) {
@@ -311,16 +396,34 @@ func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 				}
 
 				for _, msg := range xp.vcSet {
-					for seqNum, _ := range msg.CommitLog {
-						if len(xp.commitLog) <= seqNum {
-							xp.commitLog = append(xp.commitLog, msg.CommitLog[seqNum])
-						} else {
-							if xp.commitLog[seqNum].View < msg.CommitLog[seqNum].View {
-								xp.commitLog[seqNum] = msg.CommitLog[seqNum]
-							}
+					xp.adoptCheckpoint(msg.Checkpoint) // Truncated entries we may not have executed
+				}
+				for _, msg := range xp.vcSet {
+					if msg.Checkpoint.SeqNum > xp.truncated { // Its checkpoint was not stable
+						continue
+					}
+					for i, entry := range msg.CommitLog {
+						seqNum := msg.Checkpoint.SeqNum + i
+						if entry.Msg1 == nil { // Gob does not send empty maps, but commits are added to it
+							entry.Msg1 = make(map[int]Message, 0)
+						}
+						if entry.Certificate != nil && xp.checkEntryCertificate(entry) != nil {
+							entry.Certificate = nil // Stands for no commits (see threshold.go)
+						}
+						if seqNum < xp.truncated {
+							continue
+						}
+						view := xp.acceptedView(entry, seqNum+1)
+						if view == 0 { // Not prepared by a leader, nor is the rest of its log then
+							break
+						} else if xp.commitLength() <= seqNum {
+							xp.commitLog = append(xp.commitLog, entry)
+						} else if xp.acceptedView(xp.commitLog[seqNum-xp.truncated], seqNum+1) < view {
+							xp.commitLog[seqNum-xp.truncated] = entry
 						}
 					}
 				}
+				xp.persist(0)
 
 				if xp.id == xp.getLeader() {
 					var request ClientRequest
@@ -329,38 +432,47 @@ func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 					var msgDigest [32]byte
 					var signature []byte
 
-					for seqNum, _ := range xp.commitLog {
-						request = xp.commitLog[seqNum].Request
-						msg0 = xp.commitLog[seqNum].Msg0
+					for i, _ := range xp.commitLog { // Prepared again by us in the new view
+						request = xp.commitLog[i].Request
+						msg0 = xp.commitLog[i].Msg0
 						msgDigest = digest(request)
-						signature = xp.sign(msgDigest)
 
 						newMsg0 = Message{
 							MsgType:         PREPARE,
 							MsgDigest:       msgDigest,
-							Signature:       signature,
-							PrepareSeqNum:   seqNum + 1,
+							PrepareSeqNum:   xp.truncated + i + 1,
 							View:            xp.view,
 							ClientTimestamp: msg0.ClientTimestamp,
-							SenderId:        msg0.SenderId}
+							SenderId:        xp.id,
+							TraceId:         msg0.TraceId,
+							Time:            msg0.Time} // Applied at the same time in every view
+						newMsg0.Signature = xp.signMessage(newMsg0)
 
-						if seqNum < len(xp.prepareLog) {
-							xp.updatePrepareLog(seqNum, request, newMsg0)
+						if i < len(xp.prepareLog) {
+							xp.updatePrepareLog(i, request, newMsg0)
 						} else {
 							xp.appendToPrepareLog(request, newMsg0)
 						}
 					}
+					xp.persist(0)
 
 					msgDigest = digest(xp.view)
 					signature = xp.sign(msgDigest)
+					certificate, err := xp.certifyView(msgDigest)
+					if err != nil { // The view change times out
+						xp.log().With("view", xp.view).Infof("NewView: %v", err)
+						return
+					}
 
 					msg := NewViewMessage{
-						MsgType:    NEWVIEW,
-						MsgDigest:  msgDigest,
-						Signature:  signature,
-						View:       xp.view,
-						PrepareLog: xp.prepareLog,
-						SenderId:   xp.id}
+						MsgType:     NEWVIEW,
+						MsgDigest:   msgDigest,
+						Signature:   signature,
+						View:        xp.view,
+						PrepareLog:  xp.prepareLog,
+						SenderId:    xp.id,
+						Certificate: certificate,
+						Checkpoint:  xp.checkpoint}
 
 					numReplies := len(xp.synchronousGroup) - 1
 					replyCh := make(chan bool, numReplies)
@@ -370,22 +482,24 @@ func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 							go xp.issueNewView(server, msg, replyCh)
 						}
 					}
-					xp.mu.Unlock()
-
-					timer := time.NewTimer(3 * network.DELTA * time.Millisecond).C
-
-					for i := 0; i < numReplies; i++ {
-						select {
-						case <-timer:
-							dPrintf("Timeout: XPaxos.VCFinal: XPaxos server (%d)\n", xp.id)
-							return
-						case <-replyCh:
+					timer := xp.clock.After(xp.faultTimeout)
+					timedOut := false
+					xp.unlocked(func() {
+						for i := 0; i < numReplies && timedOut == false; i++ {
+							select {
+							case <-timer: // A member did not install the view within the bound (see fault.go)
+								timedOut = true
+							case <-replyCh:
+							}
 						}
-					}
+					})
 
-					xp.mu.Lock()
+					if timedOut {
+						xp.log().With("view", msg.View).Infof("Timeout: XPaxos.VCFinal")
+						go xp.issueSuspect(msg.View)
+						return
+					}
 					if xp.view != msg.View {
-						xp.mu.Unlock()
 						return
 					}
 
@@ -396,7 +510,6 @@ func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 	} else {
 		go xp.issueSuspect(xp.view)
 	}
-	xp.mu.Unlock()
 }
 
 //
@@ -410,8 +523,9 @@ func (xp *XPaxos) sendNewView(server int, msg NewViewMessage, reply *Reply) bool
 	//	}
 	//}
 
-	dPrintf("NewView: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
-	return xp.replicas[server].Call("XPaxos.NewView", msg, reply, xp.id)
+	xp.log().With("view", msg.View).Debugf("NewView: to XPaxos server (%d)", server)
+	xp.countViewChangeMessage()
+	return xp.replicas[server].CallPriority("XPaxos.NewView", msg, reply, xp.id, VCPRIORITY)
 }
 
 func (xp *XPaxos) issueNewView(server int, msg NewViewMessage, replyCh chan bool) {
@@ -454,15 +568,36 @@ func (xp *XPaxos) NewView(msg NewViewMessage, reply *Reply) {
 
 	xp.vcFlag = true
 
-	if bytes.Compare(msg.MsgDigest[:], msgDigest[:]) == 0 && xp.verify(msg.SenderId, msgDigest, msg.Signature) == true {
-		if xp.compareLogs(msg.PrepareLog, xp.commitLog) {
-			xp.prepareLog = msg.PrepareLog
-			xp.prepareSeqNum = len(xp.prepareLog)
-			xp.executeSeqNum = len(xp.commitLog)
+	if bytes.Compare(msg.MsgDigest[:], msgDigest[:]) == 0 && xp.verify(msg.SenderId, msgDigest, msg.Signature) == true &&
+		xp.checkCertificate(msgDigest, msg.Certificate) == true {
+		xp.adoptCheckpoint(msg.Checkpoint)
+		if xp.compareLogs(msg.PrepareLog, msg.Checkpoint.SeqNum) {
+			xp.prepareLog = append([]PrepareLogEntry{}, msg.PrepareLog[xp.truncated-msg.Checkpoint.SeqNum:]...)
+			xp.prepareSeqNum = xp.prepareLength()
+			xp.notifyChange()
+			for seqNum := range xp.commitLog { // Entries of the merged log are accepted in the new view
+				xp.commitLog[seqNum].Installation = xp.prepareLog[seqNum].Msg0
+			}
+			reproposed := 0
+			if xp.commitLength() > xp.executeSeqNum {
+				reproposed = xp.commitLength() - xp.executeSeqNum
+			}
+			for seqNum := xp.executeSeqNum + 1; seqNum <= xp.commitLength(); seqNum++ {
+				xp.record(journal.EXECUTED, seqNum)
+			}
+			xp.executeSeqNum = xp.commitLength()
+			xp.persist(0)
+			xp.applyExecuted()
 
 			xp.suspectSet = make(map[[32]byte]SuspectMessage, 0)
 			xp.vcSet = make(map[[32]byte]ViewChangeMessage, 0)
 			xp.receivedVCFinal = make(map[int]map[[32]byte]ViewChangeMessage, 0)
+			xp.vcShares = make(map[int]signing.SignatureShare)
+			xp.viewCertificate = msg.Certificate
+			if xp.vcInProgress == true {
+				xp.record(journal.VIEWCHANGED, 0)
+			}
+			xp.completeViewChange(reproposed)
 			xp.vcInProgress = false
 
 			if xp.id == xp.getLeader() {
@@ -477,3 +612,21 @@ func (xp *XPaxos) NewView(msg NewViewMessage, reply *Reply) {
 		go xp.issueSuspect(xp.view)
 	}
 }
+
+// View the entry at seqNum was last accepted in: the view of its prepare, or a later view whose
+// NEW-VIEW installed it, counting only prepares signed by the leader of their view (0 if none)
+func (xp *XPaxos) acceptedView(entry CommitLogEntry, seqNum int) int {
+	if xp.validPrepare(entry.Msg0, entry.Request, seqNum) == false {
+		return 0
+	}
+	if entry.Installation.View > entry.Msg0.View && xp.validPrepare(entry.Installation, entry.Request, seqNum) {
+		return entry.Installation.View
+	}
+	return entry.Msg0.View
+}
+
+// Whether msg is a prepare of request at seqNum signed by the leader of its view
+func (xp *XPaxos) validPrepare(msg Message, request ClientRequest, seqNum int) bool {
+	return msg.MsgType == PREPARE && msg.MsgDigest == digest(request) && msg.PrepareSeqNum == seqNum &&
+		msg.SenderId == xp.leaderOf(msg.View) && xp.verify(msg.SenderId, msg.signedDigest(), msg.Signature)
+}
@@ -14,14 +14,29 @@ package xpaxos
 // We simulate a network in the eponymous package - in particular, this allows gives us
 // fine-grained control over the time frame delta (defined in network/common.go - line 9)
 //
-// xp := Make(replicas, id, privateKey, publicKeys) - Creates an XPaxos server
+// xp := Make(replicas, id, persister, signer, publicKeys) - Creates an XPaxos server signing with
+//                                                             signer (see signing)
+// xp.CommitLog() - Copy of the commit log above the stable checkpoint, number of entries truncated
+//                  below it and number of executed entries (see checkpoint.go)
+// xp.SetStateMachine(sm) - Drives sm with the operations of executed requests (see statemachine)
+// xp.SetLogger(logger) - Logs through logger with the server's ID as a field (see debug/logger.go)
+// xp.SetTracer(tracer) - Records spans of the phases of requests (see tracing)
+// xp.Journal() - View changes, checkpoints and executed requests of the server (see journal)
+// => Entries are executed in log order once the whole synchronous group committed them and every
+//    entry before them (see executeCommitted()), and the leader replies to a request once its own
+//    entry is applied, so concurrent requests that commit out of order are not executed out of it
+// => A server made with a non-empty persister resumes from the persisted state (see persister.go)
 // => Option to perform cleanup with xp.Kill()
 
 import (
 	"bytes"
-	"crypto/rsa"
-	"math/rand"
+	"crypto"
+	"github.com/csanti/cos518_project/src/debug"
+	"github.com/csanti/cos518_project/src/journal"
 	"github.com/csanti/cos518_project/src/network"
+	"github.com/csanti/cos518_project/src/signing"
+	"github.com/csanti/cos518_project/src/statemachine"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,36 +45,58 @@ import (
 //
 func (xp *XPaxos) Replicate(request ClientRequest, reply *Reply) {
 	// By default reply.IsLeader = false and reply.Success = false
-	xp.mu.Lock()
+	atomic.AddInt64(&xp.pending, 1) // Waiting for the lock or being replicated
+	defer atomic.AddInt64(&xp.pending, -1)
+	start, timing := xp.clock.Now(), Timing{}
 	msgDigest := digest(request)
-	signature := xp.sign(msgDigest)
+	pending := xp.presign(msgDigest) // Signed while waiting for the lock (see presign.go)
+	prepare := xp.presignPrepare(msgDigest, start.UnixNano())
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+	xp.claimPrepare()
+	locked := xp.clock.Now()
+	timing.Lock = locked.Sub(start)
+	signature := pending.wait()
 	reply.MsgDigest = msgDigest
 	reply.Signature = signature
 
-	if xp.id == xp.getLeader() { // If XPaxos server is the leader
+	if xp.id == xp.getLeader() && xp.vcInProgress == false { // If XPaxos server is the leader of an installed view
 		reply.IsLeader = true
+		span := xp.getTracer().Start(request.TraceId, "XPaxos.Replicate")
+		defer span.End()
 
-		if len(xp.prepareLog) > 0 && request.Timestamp <= xp.prepareLog[len(xp.prepareLog)-1].Msg0.ClientTimestamp {
-			xp.mu.Unlock()
-			reply.Success = true
+		if validNonce(request) == false {
+			xp.log().With("trace", request.TraceId).Infof("Replicate: request without a nonce")
+			return
+		}
+		if request.Timestamp <= xp.lastPrepared(request.ClientId) { // Already prepared
+			if request.Timestamp <= xp.lastExecuted(request.ClientId) { // Otherwise retransmitted by the client
+				reply.Result = xp.result(request)
+				reply.Success = true
+			}
 			return
 		}
 
 		xp.prepareSeqNum++
+		span.Set("view", xp.view)
+		span.Set("seqNum", xp.prepareSeqNum)
 
 		msg := Message{ // Leader's prepare message
 			MsgType:         PREPARE,
 			MsgDigest:       msgDigest,
-			Signature:       signature,
 			PrepareSeqNum:   xp.prepareSeqNum,
 			View:            xp.view,
 			ClientTimestamp: request.Timestamp,
-			SenderId:        xp.id}
+			SenderId:        xp.id,
+			TraceId:         request.TraceId,
+			Time:            prepare.time}
+		msg.Signature, msg.Share = xp.signPrepare(prepare, msg) // Share: the leader's part of the commit certificate
 
 		prepareEntry := xp.appendToPrepareLog(request, msg)
 
 		msgMap := make(map[int]Message, 0)
 		xp.appendToCommitLog(request, msg, msgMap)
+		xp.persist(xp.executeSeqNum)
 
 		numReplies := len(xp.synchronousGroup) - 1
 		replyCh := make(chan bool, numReplies)
@@ -70,45 +107,61 @@ func (xp *XPaxos) Replicate(request ClientRequest, reply *Reply) {
 			}
 		}
 
-		xp.mu.Unlock()
-
-		timer := time.NewTimer(3 * network.DELTA * time.Millisecond).C
-
-		for i := 0; i < numReplies; i++ {
-			select {
-			case <-timer:
-				dPrintf("Timeout: XPaxos.Replicate: XPaxos server (%d)\n", xp.id)
-				return
-			case <-replyCh:
+		prepared := xp.clock.Now()
+		timing.Prepare = prepared.Sub(locked)
+		timeout := xp.faultTimeout
+		timedOut := false
+		committed := prepared
+
+		timer := xp.clock.After(timeout)
+		xp.unlocked(func() {
+			for i := 0; i < numReplies && timedOut == false; i++ {
+				select {
+				case <-timer:
+					timedOut = true
+				case <-replyCh:
+				}
 			}
-		}
+			committed = xp.clock.Now()
+		})
 
-		xp.mu.Lock()
+		// Concurrent requests may commit out of order, so wait for the commits of earlier entries
+		if timedOut == false && xp.executeUntil(msg.PrepareSeqNum, msg.View, timer) == false &&
+			xp.view == msg.View {
+			timedOut = true
+		}
+		if timedOut { // A member of the synchronous group did not prepare within the bound (see fault.go)
+			xp.logMsg(msg).Infof("Timeout: XPaxos.Replicate")
+			go xp.issueSuspect(msg.View)
+			return
+		}
+		timing.Commit = committed.Sub(prepared)
 		if xp.view != msg.View {
-			xp.mu.Unlock()
 			return
 		}
 
-		xp.executeSeqNum++
+		reply.Result = xp.result(request)
 		reply.Success = true
+		timing.Execute = xp.clock.Now().Sub(committed)
+		xp.recordTiming(request.TraceId, timing)
 	} else {
 		go xp.issuePing(xp.getLeader(), xp.view)
 	}
-	xp.mu.Unlock()
 }
 
 //
 // -------------------------------- PREPARE RPC -------------------------------
 //
 func (xp *XPaxos) sendPrepare(server int, prepareEntry PrepareLogEntry, reply *Reply) bool {
-	if xp.byzantine == true {
-		for i := len(prepareEntry.Msg0.Signature) - 1; i > 0; i-- {
-			j := rand.Intn(i + 1)
-			prepareEntry.Msg0.Signature[i], prepareEntry.Msg0.Signature[j] = prepareEntry.Msg0.Signature[j], prepareEntry.Msg0.Signature[i]
-		}
+	prepareEntry, ok := xp.tamperPrepare(server, prepareEntry) // Byzantine servers (see byzantine.go)
+	if ok == false {
+		return false
+	}
+	if xp.reachFailpoint(PREPAREPOINT) == false {
+		return false
 	}
 
-	dPrintf("Prepare: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
+	xp.logMsg(prepareEntry.Msg0).Debugf("Prepare: to XPaxos server (%d)", server)
 	return xp.replicas[server].Call("XPaxos.Prepare", prepareEntry, reply, xp.id)
 }
 
@@ -126,10 +179,15 @@ func (xp *XPaxos) issuePrepare(server int, prepareEntry PrepareLogEntry, replyCh
 
 		if bytes.Compare(prepareEntry.Msg0.MsgDigest[:], reply.MsgDigest[:]) == 0 && verification == true {
 			if reply.Success == true {
+				if len(reply.Commit) > 0 {
+					xp.recordCommit(server, prepareEntry.Msg0, reply.Commit, reply.Share)
+				}
 				replyCh <- reply.Success
 			} else if reply.Suspicious == true {
 				xp.mu.Unlock()
 				return
+			} else if reply.TimedOut == true { // The receiver gave up waiting for the group (see fault.go)
+				go xp.issueSuspect(prepareEntry.Msg0.View)
 			}
 		} else { // Verification of crypto signature in reply fails
 			go xp.issueSuspect(xp.view)
@@ -140,115 +198,199 @@ func (xp *XPaxos) issuePrepare(server int, prepareEntry PrepareLogEntry, replyCh
 	}
 }
 
+// A follower replies to a prepare once it executed the entry, with the signature of its commit
+// message, which stands in for that message when it is still on its way (see executeCommitted());
+// must be called with xp.mu held
+func (xp *XPaxos) recordCommit(server int, msg0 Message, signature []byte, share []byte) {
+	seqNum := msg0.PrepareSeqNum - 1
+	if seqNum < xp.truncated || seqNum >= xp.commitLength() {
+		return
+	}
+	if _, ok := xp.commitLog[seqNum-xp.truncated].Msg1[server]; ok {
+		return
+	}
+	if xp.commitLog[seqNum-xp.truncated].Certificate != nil { // Executed already (see compactEntry())
+		return
+	}
+	if xp.groupOf(msg0.View)[server] == false { // Only members complete the commits of an entry
+		return
+	}
+	if server == xp.leaderOf(msg0.View) { // Its prepare is no commit of a follower
+		return
+	}
+
+	msg := Message{
+		MsgType:         COMMIT,
+		MsgDigest:       msg0.MsgDigest,
+		Signature:       signature,
+		PrepareSeqNum:   msg0.PrepareSeqNum,
+		View:            msg0.View,
+		ClientTimestamp: msg0.ClientTimestamp,
+		SenderId:        server,
+		TraceId:         msg0.TraceId,
+		Share:           share,
+		Time:            msg0.Time}
+	if xp.verify(server, msg.signedDigest(), msg.Signature) == false {
+		go xp.issueSuspect(xp.view)
+		return
+	}
+
+	xp.commitLog[seqNum-xp.truncated].Msg1[server] = msg
+	xp.notifyChange()
+	xp.persist(seqNum)
+}
+
 func (xp *XPaxos) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
 	// By default reply.Success = false and reply.Suspicious = false
+	span := xp.getTracer().Start(prepareEntry.Request.TraceId, "XPaxos.Prepare")
+	span.Set("view", prepareEntry.Msg0.View)
+	span.Set("seqNum", prepareEntry.Msg0.PrepareSeqNum)
+	defer span.End()
+
 	xp.mu.Lock()
+	defer xp.mu.Unlock()
 	msgDigest := digest(prepareEntry.Request)
 	signature := xp.sign(msgDigest)
 	reply.MsgDigest = msgDigest
 	reply.Signature = signature
 
 	if xp.view != prepareEntry.Msg0.View {
-		xp.mu.Unlock()
+		if prepareEntry.Msg0.View > xp.view { // Leader claims a view we have not reached
+			reply.Suspicious = true
+			go xp.issueSuspect(xp.view)
+		}
+		return
+	}
+
+	// Prepares of concurrent requests may arrive out of order, so wait for the missing ones
+	timeout := xp.faultTimeout
+	timer := xp.clock.After(timeout)
+	transfer := xp.clock.After(network.DELTA * time.Millisecond)
+	waiting := true
+	for waiting && xp.view == prepareEntry.Msg0.View && prepareEntry.Msg0.PrepareSeqNum > xp.prepareSeqNum+1 {
+		changed := xp.changed
+		xp.unlocked(func() {
+			select {
+			case <-timer:
+				waiting = false
+			case <-transfer: // The missing prepares were lost with our state (see statetransfer.go)
+				go xp.requestState(prepareEntry.Msg0.SenderId, prepareEntry.Msg0.View)
+			case <-changed:
+			}
+		})
+	}
+
+	if xp.view != prepareEntry.Msg0.View {
 		return
 	}
 
 	if prepareEntry.Msg0.PrepareSeqNum == xp.prepareSeqNum+1 && bytes.Compare(prepareEntry.Msg0.MsgDigest[:],
-		msgDigest[:]) == 0 && xp.verify(prepareEntry.Msg0.SenderId, msgDigest, prepareEntry.Msg0.Signature) == true {
-		if len(xp.prepareLog) > 0 && prepareEntry.Request.Timestamp <= xp.prepareLog[len(xp.prepareLog)-1].Msg0.ClientTimestamp {
+		msgDigest[:]) == 0 && validNonce(prepareEntry.Request) &&
+		prepareEntry.Msg0.MsgType == PREPARE && prepareEntry.Msg0.SenderId == xp.leaderOf(prepareEntry.Msg0.View) &&
+		xp.verify(prepareEntry.Msg0.SenderId, prepareEntry.Msg0.signedDigest(), prepareEntry.Msg0.Signature) == true {
+		if prepareEntry.Request.Timestamp <= xp.lastPrepared(prepareEntry.Request.ClientId) {
 			reply.Success = true
-			xp.mu.Unlock()
 			return
 		}
 
 		xp.prepareSeqNum++
 		xp.prepareLog = append(xp.prepareLog, prepareEntry)
+		xp.notifyChange()
 
 		msg := Message{
 			MsgType:         COMMIT,
 			MsgDigest:       msgDigest,
-			Signature:       signature,
 			PrepareSeqNum:   xp.prepareSeqNum,
 			View:            xp.view,
 			ClientTimestamp: prepareEntry.Request.Timestamp,
-			SenderId:        xp.id}
+			SenderId:        xp.id,
+			TraceId:         prepareEntry.Request.TraceId,
+			Time:            prepareEntry.Msg0.Time}
+		msg.Signature = xp.signMessage(msg)
+		msg.Share = xp.signCommitShare(msg)
 
-		if xp.executeSeqNum >= len(xp.commitLog) {
+		if xp.commitLength() < xp.prepareSeqNum { // Commit log entries follow the prepare log
 			msgMap := make(map[int]Message, 0)
 			msgMap[xp.id] = msg                                                   // Follower's commit message
 			xp.appendToCommitLog(prepareEntry.Request, prepareEntry.Msg0, msgMap) // Leader's prepare message is prepareEntry.Msg0
 		}
+		xp.persist(xp.executeSeqNum)
 
 		numReplies := len(xp.synchronousGroup) - 1
 		replyCh := make(chan bool, numReplies)
 
 		for server, _ := range xp.synchronousGroup {
 			if server != xp.id {
-				go xp.issueCommit(server, msg, replyCh)
+				go xp.issueCommit(server, msg, replyCh, timeout)
 			}
 		}
-		xp.mu.Unlock()
 
-		timer := time.NewTimer(3 * network.DELTA * time.Millisecond).C
-
-		for i := 0; i < numReplies; i++ {
-			select {
-			case <-timer:
-				dPrintf("Timeout: XPaxos.Prepare: XPaxos server (%d)\n", xp.id)
-				return
-			case <-replyCh:
+		timedOut := false
+		xp.unlocked(func() {
+			timer = xp.clock.After(timeout)
+			for i := 0; i < numReplies && timedOut == false; i++ {
+				select {
+				case <-timer:
+					timedOut = true
+				case <-replyCh:
+				}
 			}
-		}
+		})
 
-		timer = time.NewTimer(3 * network.DELTA * time.Millisecond).C
+		timer = xp.clock.After(timeout)
 
-		// Busy wait until XPaxos server receives commit messages from entire synchronous group
-		xp.mu.Lock()
-		for xp.executeSeqNum < len(xp.commitLog) && len(xp.commitLog[xp.executeSeqNum].Msg1) != len(xp.synchronousGroup)-1 {
-			xp.mu.Unlock()
-			select {
-			case <-timer:
-				dPrintf("Timeout: XPaxos.Prepare: XPaxos server (%d)\n", xp.id)
-				return
-			default:
-				time.Sleep(10 * time.Millisecond)
-			}
-			xp.mu.Lock()
+		// Wait until XPaxos server receives commit messages from entire synchronous group, for this
+		// entry and every earlier one (see executeCommitted())
+		if timedOut == false && xp.executeUntil(msg.PrepareSeqNum, msg.View, timer) == false &&
+			xp.view == msg.View {
+			timedOut = true
 		}
 
+		if timedOut { // A member of the synchronous group did not commit within the bound (see fault.go)
+			xp.logMsg(msg).Infof("Timeout: XPaxos.Prepare")
+			reply.TimedOut = true
+			go xp.issueSuspect(msg.View)
+			return
+		}
 		if xp.view != msg.View {
-			xp.mu.Unlock()
 			return
 		}
 
-		xp.executeSeqNum++
+		reply.Commit = msg.Signature
+		reply.Share = msg.Share
 		reply.Success = true
-	} else { // Verification of crypto signature in prepareEntry fails
+	} else { // Verification of crypto signature in prepareEntry fails (or its request has no nonce)
 		reply.Suspicious = true
 		go xp.issueSuspect(xp.view)
 	}
-	xp.mu.Unlock()
 }
 
 //
 // --------------------------------- COMMIT RPC --------------------------------
 //
 func (xp *XPaxos) sendCommit(server int, msg Message, reply *Reply) bool {
-	if xp.byzantine == true {
-		for i := len(msg.Signature) - 1; i > 0; i-- {
-			j := rand.Intn(i + 1)
-			msg.Signature[i], msg.Signature[j] = msg.Signature[j], msg.Signature[i]
-		}
+	msg, ok := xp.tamperCommit(server, msg) // Byzantine servers (see byzantine.go)
+	if ok == false {
+		return false
+	}
+	if xp.reachFailpoint(COMMITPOINT) == false {
+		return false
 	}
 
-	dPrintf("Commit: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
+	xp.logMsg(msg).Debugf("Commit: to XPaxos server (%d)", server)
 	return xp.replicas[server].Call("XPaxos.Commit", msg, reply, xp.id)
 }
 
-func (xp *XPaxos) issueCommit(server int, msg Message, replyCh chan bool) {
-	reply := &Reply{}
+func (xp *XPaxos) issueCommit(server int, msg Message, replyCh chan bool, timeout time.Duration) {
+	deadline := xp.clock.After(timeout)
+
+	for {
+		reply := &Reply{}
+		if ok := xp.sendCommit(server, msg, reply); ok == false { // RPC times out after time frame delta (see network)
+			go xp.issueSuspect(msg.View)
+			return
+		}
 
-	if ok := xp.sendCommit(server, msg, reply); ok {
 		xp.mu.Lock()
 		if xp.view != msg.View {
 			xp.mu.Unlock()
@@ -257,26 +399,39 @@ func (xp *XPaxos) issueCommit(server int, msg Message, replyCh chan bool) {
 
 		verification := xp.verify(server, reply.MsgDigest, reply.Signature)
 
-		if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) == 0 && verification == true {
-			if reply.Success == true {
-				replyCh <- reply.Success
-			} else if reply.Suspicious == true {
-				xp.mu.Unlock()
-				return
-			} else {
-				go xp.issueCommit(server, msg, replyCh) // Retransmit if commit RPC fails - DO NOT CHANGE
-			}
-		} else { // Verification of crypto signature in reply fails
-			go xp.issueSuspect(xp.view)
+		if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) != 0 || verification == false {
+			go xp.issueSuspect(xp.view) // Verification of crypto signature in reply fails
+			xp.mu.Unlock()
+			return
+		}
+		if reply.Success == true {
+			replyCh <- reply.Success
+			xp.mu.Unlock()
+			return
 		}
 		xp.mu.Unlock()
-	} else { // RPC times out after time frame delta (see network)
-		go xp.issueSuspect(msg.View)
+		if reply.Suspicious == true {
+			return
+		}
+
+		select { // Retransmit until the receiver prepared the entry - DO NOT CHANGE
+		case <-deadline:
+			xp.logMsg(msg).Infof("Timeout: commit not acknowledged by XPaxos server (%d)", server)
+			go xp.issueSuspect(msg.View)
+			return
+		case <-xp.clock.After(RETRANSMIT):
+		}
 	}
 }
 
 func (xp *XPaxos) Commit(msg Message, reply *Reply) {
 	// By default reply.Success == false
+	span := xp.getTracer().Start(msg.TraceId, "XPaxos.Commit")
+	span.Set("view", msg.View)
+	span.Set("seqNum", msg.PrepareSeqNum)
+	span.Set("sender", msg.SenderId)
+	defer span.End()
+
 	xp.mu.Lock()
 	defer xp.mu.Unlock()
 
@@ -287,13 +442,33 @@ func (xp *XPaxos) Commit(msg Message, reply *Reply) {
 
 	if xp.view != msg.View {
 		reply.Suspicious = true
+		if msg.View > xp.view { // Sender claims a view we have not reached
+			go xp.issueSuspect(xp.view)
+		}
 		return
 	}
 
-	if xp.verify(msg.SenderId, msgDigest, msg.Signature) == true {
-		if xp.executeSeqNum < len(xp.commitLog) {
+	if msg.MsgType == COMMIT && xp.verify(msg.SenderId, msg.signedDigest(), msg.Signature) == true {
+		seqNum := msg.PrepareSeqNum - 1
+		if xp.groupOf(msg.View)[msg.SenderId] == false { // Only members complete the commits of an entry
+			xp.logMsg(msg).Infof("Commit: XPaxos server (%d) outside the synchronous group", msg.SenderId)
+			reply.Suspicious = true
+		} else if msg.SenderId == xp.leaderOf(msg.View) { // Only followers commit, the leader prepares
+			xp.logMsg(msg).Infof("Commit: XPaxos server (%d) is the leader of view %d", msg.SenderId, msg.View)
+			reply.Suspicious = true
+		} else if seqNum >= xp.truncated && seqNum < xp.commitLength() &&
+			(msgDigest != xp.commitLog[seqNum-xp.truncated].Msg0.MsgDigest ||
+				msg.Time != xp.commitLog[seqNum-xp.truncated].Msg0.Time) {
+			reply.Suspicious = true // Sender committed a different request (or time) than the one prepared
+			go xp.issueSuspect(xp.view)
+		} else if seqNum >= xp.truncated && seqNum < xp.commitLength() &&
+			xp.commitLog[seqNum-xp.truncated].Certificate != nil { // Executed already (see compactEntry())
+			reply.Success = true
+		} else if seqNum >= xp.truncated && seqNum < xp.commitLength() { // Otherwise retransmitted until prepared
 			senderId := msg.SenderId
-			xp.commitLog[xp.executeSeqNum].Msg1[senderId] = msg
+			xp.commitLog[seqNum-xp.truncated].Msg1[senderId] = msg
+			xp.notifyChange()
+			xp.persist(seqNum)
 			reply.Success = true
 		}
 	} else { // Verification of crypto signature in msg fails
@@ -306,7 +481,7 @@ func (xp *XPaxos) Commit(msg Message, reply *Reply) {
 // --------------------------------- PING RPC ---------------------------------
 //
 func (xp *XPaxos) sendPing(server int, view int, reply *Reply) bool {
-	dPrintf("Ping: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
+	xp.log().With("view", view).Debugf("Ping: to XPaxos server (%d)", server)
 	return xp.replicas[server].Call("XPaxos.Ping", view, reply, xp.id)
 }
 
@@ -324,11 +499,28 @@ func (xp *XPaxos) Ping(view int, reply *Reply) {
 	return
 }
 
+//
+// -------------------------------- STATUS RPC --------------------------------
+//
+// Served to operators (see ClusterStatus() in cluster.go), never sent by XPaxos servers
+func (xp *XPaxos) Status(args int, reply *Status) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	reply.Id = xp.id
+	reply.View = xp.view
+	reply.Leader = xp.getLeader()
+	reply.PrepareSeqNum = xp.prepareSeqNum
+	reply.ExecuteSeqNum = xp.executeSeqNum
+	reply.InGroup = xp.synchronousGroup[xp.id]
+	reply.Reachable = true
+}
+
 //
 // ------------------------------- MAKE FUNCTION ------------------------------
 //
-func Make(replicas []*network.ClientEnd, id int, privateKey *rsa.PrivateKey,
-	publicKeys map[int]*rsa.PublicKey) *XPaxos {
+func Make(replicas []network.Transport, id int, persister *Persister, signer signing.Signer,
+	publicKeys map[int]crypto.PublicKey) *XPaxos {
 	xp := &XPaxos{}
 
 	xp.mu.Lock()
@@ -340,8 +532,11 @@ func Make(replicas []*network.ClientEnd, id int, privateKey *rsa.PrivateKey,
 	xp.executeSeqNum = 0
 	xp.prepareLog = make([]PrepareLogEntry, 0)
 	xp.commitLog = make([]CommitLogEntry, 0)
-	xp.privateKey = privateKey
-	xp.publicKeys = publicKeys
+	xp.signer = signer
+	xp.publicKeys = make(map[int]crypto.PublicKey, len(publicKeys)) // Changed by key changes
+	for server, publicKey := range publicKeys {
+		xp.publicKeys[server] = publicKey
+	}
 	xp.suspectSet = make(map[[32]byte]SuspectMessage, 0)
 	xp.vcSet = make(map[[32]byte]ViewChangeMessage, 0)
 	xp.netFlag = false
@@ -350,12 +545,95 @@ func Make(replicas []*network.ClientEnd, id int, privateKey *rsa.PrivateKey,
 	xp.vcTimer = nil
 	xp.receivedVCFinal = make(map[int]map[[32]byte]ViewChangeMessage, 0)
 	xp.vcInProgress = false
-	xp.byzantine = false
-
+	xp.byzantine = HONEST
+	xp.clock = network.RealClock{}
+	xp.faultTimeout = FAULTTIMEOUT
+	xp.changed = make(chan struct{})
+	xp.suspected = 0
+	xp.persister = persister
+	xp.stateMachine = nil
+	xp.applied = 0
+	xp.lastApplied = make(map[int]int, 0)
+	xp.results = make(map[int][]byte, 0)
+	xp.checkpoint = Checkpoint{}
+	xp.tentative = Checkpoint{}
+	xp.votes = make(map[int]CheckpointMessage)
+	xp.interval = 0
+	xp.truncated = 0
+	xp.stateSources = make(map[int]int)
+	xp.stateStreams = network.MakeStreamBuffer()
+	xp.pendingReads = nil
+	xp.readsInFlight = false
+	xp.readRounds = 0
+	xp.timings = make(map[string]Timing)
+	xp.timed = make([]string, 0, TIMINGS)
+	xp.onCheckpoint = nil
+	xp.journal = journal.MakeJournal(journal.SIZE)
+	xp.SetLogger(debug.MakeLogger(debug.XPAXOS))
+	xp.SetTracer(nil)
+	xp.signatures = 0
+	xp.verifications = 0
+	xp.verifyHits = 0
+	xp.verifyMisses = 0
+	xp.presignHits = 0
+	xp.presignMisses = 0
+	xp.signTime = 0
+	xp.verifyTime = 0
+	xp.vcStats = ViewChangeStats{}
+	xp.vcSent = 0
+	xp.registry = nil
+	xp.selfCheckDone = nil
+	xp.reported = make(map[string]bool)
+	xp.violations = make([]string, 0)
+	xp.retiring = make(map[int]retiringKey)
+	xp.rotations = make(map[int]int)
+	xp.pendingKeys = make(map[string]signing.Signer)
+	xp.nextKey = nil
+	xp.switchAt = 0
+	xp.keysApplied = 0
+	xp.thresholdShare = nil
+	xp.vcShares = make(map[int]signing.SignatureShare)
+	xp.viewCertificate = nil
+	xp.sessions = signing.MakeSessions(id)
+	xp.root = nil
+	xp.verified = makeVerifyCache(VERIFYCACHE)
+	xp.verifyEpoch = 0
+	xp.dkgInbox = make(map[dkgSlot]signing.DKGMessage)
+	xp.dkgEnded = make(map[int]bool)
+	xp.dkgArrived = make(chan struct{})
+	xp.onTruncate = nil
+
+	if err := xp.readPersist(); err != nil {
+		xp.log().Infof("Persist: starting from an empty state: %v", err)
+	}
 	xp.generateSynchronousGroup(int64(xp.view))
 	xp.mu.Unlock()
 
 	return xp
 }
 
-func (xp *XPaxos) Kill() {}
+func (xp *XPaxos) Kill() {
+	xp.SetSelfCheckInterval(0)
+	xp.SetPresigners(0)
+	xp.eraseKeys()
+}
+
+// A restarted server (made from a non-empty persister) restores sm from its last checkpoint and
+// replays the executed entries above it
+func (xp *XPaxos) SetStateMachine(sm statemachine.StateMachine) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	xp.stateMachine = sm
+	xp.restoreCheckpoint() // Starts from the empty state if the server has no checkpoint
+	xp.applyExecuted()
+}
+
+func (xp *XPaxos) CommitLog() ([]CommitLogEntry, int, int) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	commitLog := make([]CommitLogEntry, len(xp.commitLog))
+	copy(commitLog, xp.commitLog)
+	return commitLog, xp.truncated, xp.executeSeqNum
+}